@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// maxAbstractSocketName is the longest abstract socket name, "@" included
+// (sun_path is 108 bytes; the kernel's leading NUL replaces the "@")
+const maxAbstractSocketName = 107
+
+// errAbstractSocketsUnsupported is returned outside Linux, which has no
+// abstract socket namespace
+var errAbstractSocketsUnsupported = errors.New("abstract sockets are only supported on Linux")
+
+// defaultAbstractSocketName is the name clients try when config.json (which
+// a sandboxed client may not be able to read) doesn't set abstract_socket
+func defaultAbstractSocketName() string {
+	return fmt.Sprintf("@noorsigner-%d", os.Getuid())
+}
+
+// validateAbstractSocketName checks an abstract_socket value ("" = off)
+func validateAbstractSocketName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if !strings.HasPrefix(name, "@") || len(name) < 2 {
+		return fmt.Errorf("abstract_socket must start with @, e.g. %s", defaultAbstractSocketName())
+	}
+	if len(name) > maxAbstractSocketName {
+		return fmt.Errorf("abstract_socket must be at most %d bytes", maxAbstractSocketName)
+	}
+	if strings.ContainsRune(name, 0) {
+		return fmt.Errorf("abstract_socket must not contain NUL bytes")
+	}
+	return nil
+}
+
+// configuredAbstractSocket returns the abstract_socket name ("" if unset)
+func configuredAbstractSocket() string {
+	if appConfig == nil || validateAbstractSocketName(appConfig.AbstractSocket) != nil {
+		return ""
+	}
+	return appConfig.AbstractSocket
+}
+
+// startAbstractListener opens the additional abstract socket listener if
+// abstract_socket is set. Abstract sockets have no file permissions: anyone
+// on the host may connect, so the peer UID check in handleConnection is what
+// guards them. A failure is logged; the filesystem socket keeps working.
+func (d *Daemon) startAbstractListener() {
+	name := d.config.AbstractSocket
+	if name == "" {
+		return
+	}
+	if err := validateAbstractSocketName(name); err != nil {
+		d.abstractErr = err
+		logError("⚠️  %v - abstract socket disabled", err)
+		return
+	}
+
+	listener, err := listenAbstractSocket(name)
+	if err != nil {
+		d.abstractErr = err
+		logError("⚠️  Cannot listen on abstract socket %s: %v", name, err)
+		return
+	}
+	d.abstractListener = listener
+	logInfo("📡 Also listening on abstract socket: %s", name)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return // Daemon shutting down
+				}
+				logError("Accept error on %s: %v", name, err)
+				continue
+			}
+			d.admitConnection(conn)
+		}
+	}()
+}
+
+// inspectAbstractListener reports the abstract socket for the security
+// summary. There is nothing on disk to inspect; what matters is that the
+// listener is up and that peer UIDs are checked.
+func (d *Daemon) inspectAbstractListener() ListenerSecurity {
+	listener := ListenerSecurity{
+		Path:            d.config.AbstractSocket,
+		Kind:            "abstract_socket",
+		PeerCredentials: peerCredentialsMode,
+		Expected:        "no file permissions - every connection's peer UID must be the daemon's",
+	}
+	if d.abstractListener == nil {
+		listener.Problems = append(listener.Problems, fmt.Sprintf("not listening: %v", d.abstractErr))
+	}
+	if peerCredentialsMode != "uid_checked" {
+		listener.Problems = append(listener.Problems, "peer UIDs are not checked - any local user can connect")
+	}
+	return listener
+}
+
+// dialAbstractSocket is dialConnection's last resort: a sandboxed client
+// may reach the daemon only through its abstract socket. Anyone can bind an
+// abstract name, so the other end must run as our own user.
+func dialAbstractSocket() (net.Conn, error) {
+	name := configuredAbstractSocket()
+	if name == "" {
+		name = defaultAbstractSocketName()
+	}
+
+	conn, err := dialAbstract(name)
+	if err != nil {
+		return nil, err
+	}
+	cred, err := peerCredentials(conn)
+	if err != nil || cred.UID != os.Getuid() {
+		conn.Close()
+		return nil, fmt.Errorf("%s is not served by a process of the current user", name)
+	}
+	return conn, nil
+}
@@ -0,0 +1,16 @@
+//go:build linux
+
+package main
+
+import "net"
+
+// listenAbstractSocket listens on an abstract socket. Go maps a leading "@"
+// to the abstract namespace; nothing is created on disk.
+func listenAbstractSocket(name string) (net.Listener, error) {
+	return net.Listen("unix", name)
+}
+
+// dialAbstract connects to an abstract socket
+func dialAbstract(name string) (net.Conn, error) {
+	return net.Dial("unix", name)
+}
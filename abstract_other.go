@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// listenAbstractSocket fails outside Linux. On other systems a leading "@"
+// is an ordinary file name, so the name must not reach net.Listen.
+func listenAbstractSocket(name string) (net.Listener, error) {
+	return nil, errAbstractSocketsUnsupported
+}
+
+// dialAbstract fails outside Linux
+func dialAbstract(name string) (net.Conn, error) {
+	return nil, errAbstractSocketsUnsupported
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// caseInsensitiveFS reports whether dir ignores case in names
+func caseInsensitiveFS(t *testing.T, dir string) bool {
+	t.Helper()
+	probe := filepath.Join(dir, "case-probe")
+	if err := os.WriteFile(probe, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(probe)
+	_, err := os.Stat(filepath.Join(dir, "CASE-PROBE"))
+	return err == nil
+}
+
+// accountDirNames returns the names under accounts/
+func accountDirNames(t *testing.T) []string {
+	t.Helper()
+	accountsDir, err := getAccountsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(accountsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+// An account directory created in upper case is the same account to every
+// lookup, and doctor --fix (or daemon startup) renames it
+func TestAccountDirCaseVariant(t *testing.T) {
+	testHome(t)
+	key, npub := testKey(t)
+	prompt := &scriptedPrompter{answers: []string{key, testPassword, testPassword}}
+	if err := addAccount(prompt, ""); err != nil {
+		t.Fatal(err)
+	}
+	accountsDir, err := getAccountsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	variant := strings.ToUpper(npub)
+	if err := renameAccountDir(accountsDir, npub, variant); err != nil {
+		t.Fatal(err)
+	}
+	if names := accountDirNames(t); len(names) != 1 || names[0] != variant {
+		t.Fatalf("accounts/ holds %v, want only %s", names, variant)
+	}
+
+	// Lookups and the list agree the account is there, once
+	if !accountExists(npub) || !accountExists(variant) {
+		t.Error("accountExists does not find the upper-case directory")
+	}
+	accounts, err := listAccounts()
+	if err != nil || len(accounts) != 1 || accounts[0].Npub != npub {
+		t.Errorf("listAccounts = %+v, %v; want %s once", accounts, err, npub)
+	}
+	if _, err := loadAccountEncryptedKey(npub); err != nil {
+		t.Errorf("loadAccountEncryptedKey: %v", err)
+	}
+	prompt = &scriptedPrompter{answers: []string{key}}
+	if err := addAccount(prompt, ""); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("adding the account again: %v, want already exists", err)
+	}
+
+	// Reported, then renamed
+	issues, err := checkAccountDirCase(false)
+	if err != nil || len(issues) != 1 || issues[0].Name != variant || issues[0].Fixed || issues[0].Duplicate {
+		t.Fatalf("checkAccountDirCase = %+v, %v; want %s to be reported", issues, err, variant)
+	}
+	issues, err = checkAccountDirCase(true)
+	if err != nil || len(issues) != 1 || !issues[0].Fixed {
+		t.Fatalf("checkAccountDirCase with fix = %+v, %v; want %s renamed", issues, err, variant)
+	}
+	if names := accountDirNames(t); len(names) != 1 || names[0] != npub {
+		t.Errorf("accounts/ holds %v after the fix, want only %s", names, npub)
+	}
+	if issues, err := checkAccountDirCase(false); err != nil || len(issues) != 0 {
+		t.Errorf("checkAccountDirCase after the fix = %+v, %v", issues, err)
+	}
+	if _, err := loadAccountEncryptedKey(npub); err != nil {
+		t.Errorf("loadAccountEncryptedKey after the fix: %v", err)
+	}
+}
+
+// A variant next to the canonical directory may hold another key file: it
+// is reported as a duplicate and left alone
+func TestAccountDirCaseDuplicate(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	accountsDir, err := getAccountsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caseInsensitiveFS(t, accountsDir) {
+		t.Skip("the filesystem cannot hold names differing only in case")
+	}
+	variant := "NPUB1" + npub[len("npub1"):]
+	if err := os.Mkdir(filepath.Join(accountsDir, variant), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err := checkAccountDirCase(true)
+	if err != nil || len(issues) != 1 || !issues[0].Duplicate || issues[0].Fixed {
+		t.Fatalf("checkAccountDirCase = %+v, %v; want a duplicate, not renamed", issues, err)
+	}
+	if !strings.Contains(issues[0].String(), "duplicates accounts/"+npub) {
+		t.Errorf("issue %q does not name the canonical directory", issues[0])
+	}
+	if names := accountDirNames(t); len(names) != 2 {
+		t.Errorf("accounts/ holds %v, want both directories left", names)
+	}
+	accounts, err := listAccounts()
+	if err != nil || len(accounts) != 1 || accounts[0].Npub != npub {
+		t.Errorf("listAccounts = %+v, %v; want %s once", accounts, err, npub)
+	}
+	// The canonical directory is the one used
+	if dir, err := getAccountDir(strings.ToUpper(npub)); err != nil || filepath.Base(dir) != npub {
+		t.Errorf("getAccountDir = %s, %v; want accounts/%s", dir, err, npub)
+	}
+}
+
+// An active_account written in upper case is rewritten in lower case
+func TestActiveAccountCase(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	filePath, err := getActiveAccountFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte(strings.ToUpper(npub)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if message, err := checkActiveAccountCase(false); err != nil || message == "" {
+		t.Errorf("checkActiveAccountCase = %q, %v; want it reported", message, err)
+	}
+	if message, err := checkActiveAccountCase(true); err != nil || message == "" {
+		t.Errorf("checkActiveAccountCase with fix = %q, %v; want it rewritten", message, err)
+	}
+	if active, err := loadActiveAccount(); err != nil || active != npub {
+		t.Errorf("active account %s, %v; want %s", active, err, npub)
+	}
+	if message, err := checkActiveAccountCase(false); err != nil || message != "" {
+		t.Errorf("checkActiveAccountCase after the fix = %q, %v", message, err)
+	}
+}
+
+// Labels are compared ignoring case: two that differ only in case collide,
+// and either spelling selects the account
+func TestAccountLabelCaseCollision(t *testing.T) {
+	testHome(t)
+	work := addTestAccount(t, "Work")
+	other := addTestAccount(t, "")
+
+	if err := setAccountLabel(other, "work"); !errors.Is(err, errLabelTaken) {
+		t.Errorf("labeling another account %q: %v, want %v", "work", err, errLabelTaken)
+	}
+	if err := checkNewAccountLabel("WORK"); !errors.Is(err, errLabelTaken) {
+		t.Errorf("new account labeled %q: %v, want %v", "WORK", err, errLabelTaken)
+	}
+	key, npub := testKey(t)
+	prompt := &scriptedPrompter{answers: []string{key, testPassword, testPassword}}
+	if err := addAccount(prompt, "wORK"); err == nil {
+		t.Errorf("add-account --label %q succeeded", "wORK")
+	}
+	if accountExists(npub) {
+		t.Error("the account was added with a colliding label")
+	}
+	if label := accountLabel(other); label != "" {
+		t.Errorf("the other account is labeled %q", label)
+	}
+
+	for _, selector := range []string{"Work", "work", "WORK"} {
+		if resolved, err := resolveAccount(selector); err != nil || resolved != work {
+			t.Errorf("resolveAccount(%q) = %s, %v; want %s", selector, resolved, err, work)
+		}
+	}
+
+	// The account itself may change the case of its own label
+	if err := setAccountLabel(work, "WORK"); err != nil {
+		t.Fatalf("relabeling in upper case: %v", err)
+	}
+	if label := accountLabel(work); label != "WORK" {
+		t.Errorf("label %q, want WORK", label)
+	}
+}
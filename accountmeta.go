@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// accountMetadataVersion is the format of metadata.json
+	accountMetadataVersion = 1
+
+	// maxAccountLabelLen is the longest label, in characters
+	maxAccountLabelLen = 32
+
+	codeLabelTaken = "ERR_LABEL_TAKEN"
+)
+
+var (
+	// errLabelTaken is returned for a label another account already has
+	errLabelTaken = errors.New("label already names another account")
+
+	// errInvalidLabel is returned for a label that can't name an account
+	errInvalidLabel = errors.New("invalid label")
+)
+
+// AccountMetadata is an account's metadata.json, written when the account
+// is created. The directory's mtime moves whenever a trust session or
+// health record is written; this stays put.
+type AccountMetadata struct {
+	Version   int    `json:"version"`
+	Npub      string `json:"npub"`
+	Pubkey    string `json:"pubkey"`
+	CreatedAt int64  `json:"created_at"`
+	Label     string `json:"label,omitempty"`
+	// Profile is the cached kind 0 profile (see profile.go)
+	Profile *AccountProfile `json:"profile,omitempty"`
+}
+
+// newAccountMetadata returns the metadata of an account created at createdAt
+func newAccountMetadata(npub string, createdAt time.Time) (*AccountMetadata, error) {
+	pubkey, err := npubToPubkey(npub)
+	if err != nil {
+		return nil, err
+	}
+	return &AccountMetadata{
+		Version:   accountMetadataVersion,
+		Npub:      canonicalNpub(npub),
+		Pubkey:    pubkey,
+		CreatedAt: createdAt.Unix(),
+	}, nil
+}
+
+// marshalAccountMetadata encodes metadata.json
+func marshalAccountMetadata(meta *AccountMetadata) ([]byte, error) {
+	content, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
+
+// loadAccountMetadata reads an account's metadata.json. A missing file
+// returns an error for which os.IsNotExist is true.
+func loadAccountMetadata(npub string) (*AccountMetadata, error) {
+	store, err := accountStore()
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := store.ReadRecord(npub, recordMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta AccountMetadata
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", recordMetadata, err)
+	}
+	if meta.Version > accountMetadataVersion {
+		return nil, fmt.Errorf("unsupported %s version %d", recordMetadata, meta.Version)
+	}
+	if meta.Npub != canonicalNpub(npub) {
+		return nil, fmt.Errorf("%s belongs to %s", recordMetadata, meta.Npub)
+	}
+	return &meta, nil
+}
+
+// applyAccountMetadata sets the creation time and label of a listed account
+// from its metadata.json. Accounts created before metadata.json existed get
+// one now, with the time the store reported (the directory's mtime for the
+// files backend), so their creation time stops drifting from here on.
+func applyAccountMetadata(store Store, account *AccountInfo) {
+	meta, err := loadAccountMetadata(account.Npub)
+	if err == nil {
+		account.CreatedAt = time.Unix(meta.CreatedAt, 0)
+		account.Label = meta.Label
+		account.Profile = meta.Profile
+		return
+	}
+	if !os.IsNotExist(err) {
+		// Left alone: a damaged file is not ours to overwrite
+		return
+	}
+
+	meta, err = newAccountMetadata(account.Npub, account.CreatedAt)
+	if err != nil {
+		return
+	}
+	content, err := marshalAccountMetadata(meta)
+	if err != nil {
+		return
+	}
+	// Under storage.lock, so an account removed meanwhile isn't recreated
+	withStorageLock(func() error {
+		if _, _, err := store.ReadRecord(account.Npub, recordKey); err != nil {
+			return err
+		}
+		if _, _, err := store.ReadRecord(account.Npub, recordMetadata); !os.IsNotExist(err) {
+			return err
+		}
+		return store.WriteRecords(account.Npub, storeRecord{Name: recordMetadata, Data: content})
+	})
+}
+
+// validateAccountLabel checks label can name an account ("" is fine: no
+// label). A label can't look like an npub or a position (see
+// resolveAccount), so it never shadows one.
+func validateAccountLabel(label string) error {
+	if label != strings.TrimSpace(label) {
+		return fmt.Errorf("%w: no leading or trailing spaces", errInvalidLabel)
+	}
+	if utf8.RuneCountInString(label) > maxAccountLabelLen {
+		return fmt.Errorf("%w: at most %d characters", errInvalidLabel, maxAccountLabelLen)
+	}
+	for _, r := range label {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: no control characters", errInvalidLabel)
+		}
+	}
+	if strings.HasPrefix(strings.ToLower(label), "npub1") {
+		return fmt.Errorf("%w: must not start with npub1", errInvalidLabel)
+	}
+	if label != "" && strings.Trim(label, "0123456789") == "" {
+		return fmt.Errorf("%w: must not be a number, which selects an account by position", errInvalidLabel)
+	}
+	return nil
+}
+
+// checkNewAccountLabel checks label can name an account about to be added
+func checkNewAccountLabel(label string) error {
+	if err := validateAccountLabel(label); err != nil || label == "" {
+		return err
+	}
+	if npub, err := resolveNamedAccount(label); err == nil {
+		return fmt.Errorf("%w: %s", errLabelTaken, displayNpub(npub))
+	}
+	return nil
+}
+
+// setAccountLabel sets an account's label ("" removes it) under
+// storage.lock. Labels are unique across accounts, ignoring case.
+func setAccountLabel(npub, label string) error {
+	if err := validateAccountLabel(label); err != nil {
+		return err
+	}
+	npub = canonicalNpub(npub)
+	store, err := accountStore()
+	if err != nil {
+		return err
+	}
+
+	return withStorageLock(func() error {
+		accounts, err := store.ListAccounts()
+		if err != nil {
+			return err
+		}
+		var self *AccountInfo
+		for i, account := range accounts {
+			if account.Npub == npub {
+				self = &accounts[i]
+				continue
+			}
+			if label == "" {
+				continue
+			}
+			if meta, err := loadAccountMetadata(account.Npub); err == nil && strings.EqualFold(meta.Label, label) {
+				return fmt.Errorf("%w: %s", errLabelTaken, displayNpub(account.Npub))
+			}
+		}
+		if self == nil {
+			return fmt.Errorf("%w: %s", errUnknownAccount, displayNpub(npub))
+		}
+
+		meta, err := loadAccountMetadata(npub)
+		if os.IsNotExist(err) {
+			meta, err = newAccountMetadata(npub, self.CreatedAt)
+		}
+		if err != nil {
+			return err
+		}
+		meta.Label = label
+		content, err := marshalAccountMetadata(meta)
+		if err != nil {
+			return err
+		}
+		return store.WriteRecords(npub, storeRecord{Name: recordMetadata, Data: content})
+	})
+}
+
+// accountLabel returns an account's label ("" if it has none)
+func accountLabel(npub string) string {
+	meta, err := loadAccountMetadata(npub)
+	if err != nil {
+		return ""
+	}
+	return meta.Label
+}
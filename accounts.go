@@ -1,20 +1,32 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/btcutil/bech32"
 )
 
+// errNoTrustSession means an account has no valid trust session to unlock
+// it with
+var errNoTrustSession = errors.New("no valid trust session")
+
 // AccountInfo represents metadata about a stored account
 type AccountInfo struct {
 	Npub      string    `json:"npub"`
 	Pubkey    string    `json:"pubkey"`
 	CreatedAt time.Time `json:"created_at"`
+	Label     string    `json:"label,omitempty"`
+	// Profile is the cached kind 0 profile, nil if never fetched
+	Profile *AccountProfile `json:"profile,omitempty"`
 }
 
 // getAccountsDir returns ~/.noorsigner/accounts/ directory
@@ -42,40 +54,128 @@ func getAccountDir(npub string) (string, error) {
 	}
 
 	// Sanitize npub for filesystem (npub1... is safe, but just in case)
-	safeNpub := sanitizeNpubForPath(npub)
-	accountDir := filepath.Join(accountsDir, safeNpub)
+	safeNpub := sanitizeNpubForPath(canonicalNpub(npub))
 
-	return accountDir, nil
+	return findAccountDir(accountsDir, safeNpub), nil
 }
 
-// sanitizeNpubForPath ensures npub is safe for filesystem path
-func sanitizeNpubForPath(npub string) string {
-	// npub1... format is already safe, but truncate to reasonable length
-	// Full npub is ~63 chars, which is fine for most filesystems
-	if len(npub) > 70 {
-		return npub[:70]
+// minNpubPrefixLen is the shortest npub prefix (with "npub1") that selects
+// an account
+const minNpubPrefixLen = 8
+
+// errAmbiguousAccount is returned for an npub prefix several accounts share
+var errAmbiguousAccount = errors.New("ambiguous account")
+
+// resolveAccount returns the npub of the account selector names, the way a
+// person types it: its 1-based position in list-accounts, or what
+// resolveNamedAccount accepts, or an npub prefix of at least
+// minNpubPrefixLen characters that only one account has.
+func resolveAccount(selector string) (string, error) {
+	selector = strings.TrimSpace(selector)
+	if selector != "" && strings.Trim(selector, "0123456789") == "" {
+		return accountAtPosition(selector)
+	}
+	npub := canonicalNpub(selector)
+	if _, err := npubToPubkey(npub); err == nil || !strings.HasPrefix(npub, "npub1") {
+		return resolveNamedAccount(selector)
 	}
-	return npub
-}
 
-// getAccountKeyFilePath returns path to encrypted key file for an account
-func getAccountKeyFilePath(npub string) (string, error) {
-	accountDir, err := getAccountDir(npub)
+	// Not a valid npub, so a prefix of one
+	if len(npub) < minNpubPrefixLen {
+		return "", fmt.Errorf("%w: npub prefix %q is too short (at least %d characters)", errAccountField, npub, minNpubPrefixLen)
+	}
+	accounts, err := listAccounts()
 	if err != nil {
 		return "", err
 	}
+	var matches []string
+	for _, account := range accounts {
+		if strings.HasPrefix(account.Npub, npub) {
+			matches = append(matches, account.Npub)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%w: no account's npub starts with %s", errUnknownAccount, npub)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%w: %s matches %d accounts:\n  %s", errAmbiguousAccount, npub, len(matches), strings.Join(matches, "\n  "))
+}
 
-	return filepath.Join(accountDir, "keys.encrypted"), nil
+// accountAtPosition returns the npub of the account at a 1-based position
+// in list-accounts (and list_accounts)
+func accountAtPosition(position string) (string, error) {
+	accounts, err := listAccounts()
+	if err != nil {
+		return "", err
+	}
+	index, err := strconv.Atoi(position)
+	if err != nil || index < 1 || index > len(accounts) {
+		return "", fmt.Errorf("%w: no account #%s (there are %d)", errUnknownAccount, position, len(accounts))
+	}
+	return accounts[index-1].Npub, nil
 }
 
-// getAccountTrustSessionFilePath returns path to trust session file for an account
-func getAccountTrustSessionFilePath(npub string) (string, error) {
-	accountDir, err := getAccountDir(npub)
+// resolveNamedAccount returns the npub of the account selector names: an
+// npub, or an account's label (ignoring case). An npub is returned as is,
+// whether or not its account exists; "" is returned for "". Requests that
+// use a key go by this alone: a position or prefix could quietly pick
+// another account than the client meant.
+func resolveNamedAccount(selector string) (string, error) {
+	selector = strings.TrimSpace(selector)
+	if selector == "" || strings.HasPrefix(strings.ToLower(selector), "npub1") {
+		return canonicalNpub(selector), nil
+	}
+
+	accounts, err := listAccounts()
 	if err != nil {
 		return "", err
 	}
+	for _, account := range accounts {
+		if account.Label != "" && strings.EqualFold(account.Label, selector) {
+			return account.Npub, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q is neither an npub nor a label", errUnknownAccount, selector)
+}
+
+// canonicalNpub returns the form account directories and active_account use.
+// Bech32 is case-insensitive, but npubs are always stored lowercase.
+func canonicalNpub(npub string) string {
+	return strings.ToLower(strings.TrimSpace(npub))
+}
+
+// findAccountDir returns the directory for name inside accountsDir. A
+// directory whose name differs only in case (created by hand or by another
+// tool) is used when the exact name doesn't exist, so lookups agree with
+// listAccounts on case-sensitive filesystems too.
+func findAccountDir(accountsDir, name string) string {
+	exact := filepath.Join(accountsDir, name)
+	if _, err := os.Lstat(exact); err == nil {
+		return exact
+	}
+
+	entries, err := os.ReadDir(accountsDir)
+	if err != nil {
+		return exact
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.ToLower(entry.Name()) == name {
+			return filepath.Join(accountsDir, entry.Name())
+		}
+	}
+	return exact
+}
 
-	return filepath.Join(accountDir, "trust_session"), nil
+// sanitizeNpubForPath ensures npub is safe for filesystem path
+func sanitizeNpubForPath(npub string) string {
+	// npub1... format is already safe, but truncate to reasonable length
+	// Full npub is ~63 chars, which is fine for most filesystems
+	if len(npub) > 70 {
+		return npub[:70]
+	}
+	return npub
 }
 
 // getActiveAccountFilePath returns path to active_account file
@@ -88,180 +188,155 @@ func getActiveAccountFilePath() (string, error) {
 	return filepath.Join(storageDir, "active_account"), nil
 }
 
-// saveActiveAccount saves the active account npub to file
+// saveActiveAccount saves the active account npub (under storage.lock)
 func saveActiveAccount(npub string) error {
-	filePath, err := getActiveAccountFilePath()
+	store, err := accountStore()
 	if err != nil {
 		return err
 	}
-
-	if err := os.WriteFile(filePath, []byte(npub), 0600); err != nil {
-		return fmt.Errorf("cannot write active account file: %v", err)
+	err = withStorageLock(func() error {
+		return store.SaveActiveAccount(npub)
+	})
+	if err != nil {
+		return err
 	}
-
+	invalidatePermissions(npub)
 	return nil
 }
 
-// loadActiveAccount loads the active account npub from file
+// loadActiveAccount loads the active account npub
 func loadActiveAccount() (string, error) {
-	filePath, err := getActiveAccountFilePath()
+	store, err := accountStore()
 	if err != nil {
 		return "", err
 	}
-
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return "", fmt.Errorf("no active account set")
-	}
-
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", fmt.Errorf("cannot read active account file: %v", err)
-	}
-
-	return strings.TrimSpace(string(content)), nil
+	return store.LoadActiveAccount()
 }
 
-// listAccounts returns all stored accounts
+// listAccounts returns all stored accounts, with their creation time and
+// label from metadata.json
 func listAccounts() ([]AccountInfo, error) {
-	accountsDir, err := getAccountsDir()
+	store, err := accountStore()
 	if err != nil {
 		return nil, err
 	}
-
-	entries, err := os.ReadDir(accountsDir)
+	accounts, err := store.ListAccounts()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []AccountInfo{}, nil
-		}
-		return nil, fmt.Errorf("cannot read accounts directory: %v", err)
+		return nil, err
 	}
-
-	var accounts []AccountInfo
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		npub := entry.Name()
-		if !strings.HasPrefix(npub, "npub1") {
-			continue // Skip non-npub directories
-		}
-
-		// Get creation time from directory
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
-
-		// Derive pubkey from npub
-		pubkey, err := npubToPubkey(npub)
-		if err != nil {
-			continue
-		}
-
-		accounts = append(accounts, AccountInfo{
-			Npub:      npub,
-			Pubkey:    pubkey,
-			CreatedAt: info.ModTime(),
-		})
+	for i := range accounts {
+		applyAccountMetadata(store, &accounts[i])
 	}
-
 	return accounts, nil
 }
 
 // accountExists checks if an account exists
 func accountExists(npub string) bool {
-	accountDir, err := getAccountDir(npub)
+	store, err := accountStore()
 	if err != nil {
 		return false
 	}
-
-	keyFile := filepath.Join(accountDir, "keys.encrypted")
-	_, err = os.Stat(keyFile)
+	_, _, err = store.ReadRecord(npub, recordKey)
 	return err == nil
 }
 
-// saveAccountEncryptedKey saves encrypted key for an account
+// saveAccountEncryptedKey saves encrypted key for an account, together with
+// its checksum, under storage.lock: it creates the account if it is new,
+// with its metadata.json
 func saveAccountEncryptedKey(npub string, encKey *EncryptedKey) error {
-	accountDir, err := getAccountDir(npub)
+	store, err := accountStore()
 	if err != nil {
 		return err
 	}
 
-	// Create account directory
-	if err := os.MkdirAll(accountDir, 0700); err != nil {
-		return fmt.Errorf("cannot create account directory: %v", err)
-	}
-
-	keyFile := filepath.Join(accountDir, "keys.encrypted")
-
-	// Simple hex encoding for storage
-	saltHex := encodeHex(encKey.Salt)
-	encryptedHex := encodeHex(encKey.EncryptedNsec)
-
-	content := fmt.Sprintf("%s:%s", saltHex, encryptedHex)
-
-	if err := os.WriteFile(keyFile, []byte(content), 0600); err != nil {
-		return fmt.Errorf("cannot write account key file: %v", err)
+	content, err := marshalEncryptedKey(encKey)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	// The checksum is the baseline for the key health check (see health.go)
+	records := []storeRecord{
+		{Name: recordKey, Data: content},
+		{Name: recordKeyChecksum, Data: []byte(keyChecksum(content))},
+	}
+	return withStorageLock(func() error {
+		if _, _, err := store.ReadRecord(npub, recordKey); os.IsNotExist(err) {
+			meta, err := newAccountMetadata(npub, time.Now())
+			if err != nil {
+				return err
+			}
+			metaContent, err := marshalAccountMetadata(meta)
+			if err != nil {
+				return err
+			}
+			records = append(records, storeRecord{Name: recordMetadata, Data: metaContent})
+		}
+		return store.WriteRecords(npub, records...)
+	})
 }
 
 // loadAccountEncryptedKey loads encrypted key for an account
 func loadAccountEncryptedKey(npub string) (*EncryptedKey, error) {
-	keyFile, err := getAccountKeyFilePath(npub)
+	store, err := accountStore()
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err := os.Stat(keyFile); os.IsNotExist(err) {
+	content, _, err := store.ReadRecord(npub, recordKey)
+	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("account not found: %s", npub)
 	}
-
-	content, err := os.ReadFile(keyFile)
 	if err != nil {
 		return nil, fmt.Errorf("cannot read account key file: %v", err)
 	}
 
-	// Parse hex encoded content
-	parts := strings.SplitN(string(content), ":", 2)
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid account key file format")
-	}
-
-	salt, err := decodeHex(parts[0])
+	encKey, err := parseEncryptedKey(content)
 	if err != nil {
-		return nil, fmt.Errorf("invalid salt in account key file: %v", err)
+		return nil, fmt.Errorf("invalid account key file: %v", err)
 	}
-
-	encrypted, err := decodeHex(parts[1])
-	if err != nil {
-		return nil, fmt.Errorf("invalid encrypted data in account key file: %v", err)
-	}
-
-	return &EncryptedKey{
-		Salt:          salt,
-		EncryptedNsec: encrypted,
-	}, nil
+	return encKey, nil
 }
 
 // saveAccountTrustSession saves trust session for an account
 func saveAccountTrustSession(npub string, session *TrustSession) error {
-	sessionFile, err := getAccountTrustSessionFilePath(npub)
+	store, err := accountStore()
 	if err != nil {
 		return err
 	}
 
-	// Format: token:expires_unix:created_unix:encrypted_nsec_hex
+	// The token goes to the OS keyring unless trust_token_store is file
+	// (see trustkeyring.go). A session loaded from disk has it there already.
+	token := session.SessionToken
+	if session.TokenInKeyring {
+		if token != "" {
+			if err := saveTrustToken(npub, token); err != nil {
+				return err
+			}
+		}
+		token = trustTokenMarker
+	}
+
+	// Format: v2:token:expires_unix:created_unix:encrypted_nsec_hex[:last_used_unix[:hard_expires_unix]]
+	// where token is "keyring" if the token is in the OS keyring
 	encryptedHex := encodeHex(session.EncryptedNsec)
-	content := fmt.Sprintf("%s:%d:%d:%s",
-		session.SessionToken,
+	content := fmt.Sprintf("%s:%s:%d:%d:%s",
+		trustSessionFormat,
+		token,
 		session.ExpiresAt.Unix(),
 		session.CreatedAt.Unix(),
 		encryptedHex)
+	var lastUsed int64 // 0 = not used yet
+	if !session.LastUsedAt.IsZero() {
+		lastUsed = session.LastUsedAt.Unix()
+	}
+	switch {
+	case !session.HardExpiresAt.IsZero():
+		content += fmt.Sprintf(":%d:%d", lastUsed, session.HardExpiresAt.Unix())
+	case lastUsed != 0:
+		content += fmt.Sprintf(":%d", lastUsed)
+	}
 
-	if err := os.WriteFile(sessionFile, []byte(content), 0600); err != nil {
+	if err := store.WriteRecords(npub, storeRecord{Name: recordTrustSession, Data: []byte(content)}); err != nil {
 		return fmt.Errorf("cannot write account trust session file: %v", err)
 	}
 
@@ -270,23 +345,27 @@ func saveAccountTrustSession(npub string, session *TrustSession) error {
 
 // loadAccountTrustSession loads trust session for an account
 func loadAccountTrustSession(npub string) (*TrustSession, error) {
-	sessionFile, err := getAccountTrustSessionFilePath(npub)
+	store, err := accountStore()
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err := os.Stat(sessionFile); os.IsNotExist(err) {
+	content, _, err := store.ReadRecord(npub, recordTrustSession)
+	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("no trust session for account: %s", npub)
 	}
-
-	content, err := os.ReadFile(sessionFile)
 	if err != nil {
 		return nil, fmt.Errorf("cannot read account trust session file: %v", err)
 	}
 
-	// Parse format: token:expires_unix:created_unix:encrypted_nsec_hex
+	// Parse format: v2:token:expires_unix:created_unix:encrypted_nsec_hex[:last_used_unix[:hard_expires_unix]]
 	parts := strings.Split(string(content), ":")
-	if len(parts) != 4 {
+	if parts[0] != trustSessionFormat {
+		// Sessions before machine binding start with the token
+		return nil, errTrustSessionOutdated
+	}
+	parts = parts[1:]
+	if len(parts) < 4 || len(parts) > 6 {
 		return nil, fmt.Errorf("invalid account trust session format")
 	}
 
@@ -305,52 +384,131 @@ func loadAccountTrustSession(npub string) (*TrustSession, error) {
 		return nil, fmt.Errorf("invalid encrypted nsec: %v", err)
 	}
 
-	return &TrustSession{
+	session := &TrustSession{
 		SessionToken:  parts[0],
 		ExpiresAt:     time.Unix(expiresUnix, 0),
 		CreatedAt:     time.Unix(createdUnix, 0),
 		EncryptedNsec: encryptedNsec,
-	}, nil
+		npub:          npub,
+	}
+	if parts[0] == trustTokenMarker {
+		// Fetched from the OS keyring only when the nsec is decrypted
+		session.SessionToken = ""
+		session.TokenInKeyring = true
+	}
+	if len(parts) >= 5 {
+		lastUsedUnix, err := parseInt64(parts[4])
+		if err != nil {
+			return nil, fmt.Errorf("invalid last-used timestamp: %v", err)
+		}
+		if lastUsedUnix != 0 {
+			session.LastUsedAt = time.Unix(lastUsedUnix, 0)
+		}
+	}
+	if len(parts) == 6 {
+		hardUnix, err := parseInt64(parts[5])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hard limit timestamp: %v", err)
+		}
+		session.HardExpiresAt = time.Unix(hardUnix, 0)
+	}
+	return session, nil
 }
 
-// clearAccountTrustSession removes trust session for an account
+// clearAccountTrustSession removes trust session for an account: the
+// record and, if it is kept there, the token in the OS keyring
 func clearAccountTrustSession(npub string) error {
-	sessionFile, err := getAccountTrustSessionFilePath(npub)
+	store, err := accountStore()
 	if err != nil {
 		return err
 	}
+	var tokenErr error
+	content, _, err := store.ReadRecord(npub, recordTrustSession)
+	if err == nil && strings.HasPrefix(string(content), trustSessionFormat+":"+trustTokenMarker+":") {
+		tokenErr = deleteTrustToken(npub)
+	}
+	if err := store.DeleteRecord(npub, recordTrustSession); err != nil {
+		return err
+	}
+	return tokenErr
+}
 
-	if _, err := os.Stat(sessionFile); os.IsNotExist(err) {
-		return nil // Already cleared
+// openAccountTrustSession decrypts npub's key from its trust session, to
+// unlock the account without the password. It fails with errNoTrustSession
+// if npub has no valid session.
+func openAccountTrustSession(npub string) (*TrustSession, *btcec.PrivateKey, error) {
+	session, err := loadAccountTrustSession(npub)
+	if err != nil || !isTrustSessionValid(session) {
+		return nil, nil, errNoTrustSession
 	}
 
-	return os.Remove(sessionFile)
+	nsec, err := decryptTrustSessionNsec(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKey, err := nsecToPrivateKey(nsec)
+	if err != nil || !keyMatchesNpub(privateKey, npub) {
+		if privateKey != nil {
+			privateKey.Zero()
+		}
+		return nil, nil, fmt.Errorf("trust session of %s holds another key", displayNpub(npub))
+	}
+	return session, privateKey, nil
+}
+
+// hasAccountTrustSession reports whether an account has a trust session,
+// valid or not
+func hasAccountTrustSession(npub string) bool {
+	store, err := accountStore()
+	if err != nil {
+		return false
+	}
+	_, _, err = store.ReadRecord(npub, recordTrustSession)
+	return err == nil
 }
 
-// removeAccount removes an account and all its data
+// removeAccount removes an account and all its data (under storage.lock)
 func removeAccount(npub string) error {
-	accountDir, err := getAccountDir(npub)
+	store, err := accountStore()
 	if err != nil {
 		return err
 	}
+	return withStorageLock(func() error {
+		// The session token may be in the OS keyring, outside the account
+		if err := clearAccountTrustSession(npub); err != nil {
+			return fmt.Errorf("cannot remove trust session: %v", err)
+		}
+		return store.RemoveAccount(npub)
+	})
+}
 
-	if _, err := os.Stat(accountDir); os.IsNotExist(err) {
-		return fmt.Errorf("account not found: %s", npub)
-	}
+// secureDeleteDir overwrites every regular file in dir with zeros and syncs it,
+// so key material doesn't linger in freed blocks after removal
+func secureDeleteDir(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
 
-	// Remove entire account directory
-	if err := os.RemoveAll(accountDir); err != nil {
-		return fmt.Errorf("cannot remove account: %v", err)
-	}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
 
-	// If this was the active account, clear active_account file
-	activeNpub, err := loadActiveAccount()
-	if err == nil && activeNpub == npub {
-		activeFile, _ := getActiveAccountFilePath()
-		os.Remove(activeFile)
-	}
+		file, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
 
-	return nil
+		if _, err := file.Write(make([]byte, info.Size())); err != nil {
+			return err
+		}
+		return file.Sync()
+	})
 }
 
 // migrateToMultiAccount migrates from old single-account format to new multi-account format
@@ -378,7 +536,7 @@ func migrateToMultiAccount() error {
 		return nil
 	}
 
-	fmt.Println("🔄 Migrating to multi-account format...")
+	fmt.Fprintln(os.Stderr, "🔄 Migrating to multi-account format...")
 
 	// Load old encrypted key
 	encKey, err := loadEncryptedKey()
@@ -403,20 +561,22 @@ func migrateToMultiAccount() error {
 	if err != nil {
 		return fmt.Errorf("invalid nsec: %v", err)
 	}
-	npub := privateKeyToNpub(privateKey)
+	npub, err := privateKeyToNpub(privateKey)
+	if err != nil {
+		return err
+	}
 
-	// Save to new location
-	if err := saveAccountEncryptedKey(npub, encKey); err != nil {
+	// Save to new location, in the current key file format
+	newKey, err := encryptNsec(nsec, password)
+	if err != nil {
+		return fmt.Errorf("cannot encrypt migrated key: %v", err)
+	}
+	if err := saveAccountEncryptedKey(npub, newKey); err != nil {
 		return fmt.Errorf("cannot save migrated key: %v", err)
 	}
 
-	// Migrate trust session if exists
-	if _, err := os.Stat(oldTrustFile); err == nil {
-		oldSession, err := loadTrustSession()
-		if err == nil {
-			saveAccountTrustSession(npub, oldSession)
-		}
-	}
+	// The old trust session is not carried over: it is bound to no machine
+	// (see trustbind.go), so the password is needed once
 
 	// Set as active account
 	if err := saveActiveAccount(npub); err != nil {
@@ -427,7 +587,7 @@ func migrateToMultiAccount() error {
 	os.Remove(oldKeyFile)
 	os.Remove(oldTrustFile)
 
-	fmt.Printf("✅ Migrated account: %s\n", npub)
+	fmt.Fprintf(os.Stderr, "✅ Migrated account: %s\n", displayNpub(npub))
 	return nil
 }
 
@@ -487,23 +647,100 @@ func parseInt64(s string) (int64, error) {
 	return result, nil
 }
 
-// npubToPubkey converts npub to hex pubkey
+// npubToPubkey converts npub to hex pubkey. Only the canonical encoding of
+// a 32-byte key is accepted, so the result converts back to the same npub.
 func npubToPubkey(npub string) (string, error) {
 	if !strings.HasPrefix(npub, "npub1") {
 		return "", fmt.Errorf("invalid npub format")
 	}
 
-	// Decode bech32 using btcutil/bech32
-	_, data, err := bech32.Decode(npub)
+	payload, err := decodeBech32Key(npub, "npub")
 	if err != nil {
-		return "", fmt.Errorf("invalid bech32: %v", err)
+		return "", err
 	}
+	return encodeHex(payload), nil
+}
 
-	// Convert 5-bit to 8-bit
-	converted, err := bech32.ConvertBits(data, 5, 8, false)
+// parsePubkey returns an npub or a hex pubkey as lowercase hex, checking
+// the key is on the curve
+func parsePubkey(value string) (string, error) {
+	pubkey := value
+	if strings.HasPrefix(strings.ToLower(value), "npub1") {
+		decoded, err := npubToPubkey(value)
+		if err != nil {
+			return "", err
+		}
+		pubkey = decoded
+	}
+
+	pubkeyBytes, err := hex.DecodeString(pubkey)
+	if err != nil || len(pubkeyBytes) != 32 || pubkey != strings.ToLower(pubkey) {
+		return "", fmt.Errorf("must be an npub or 64 lowercase hex characters")
+	}
+	if _, err := schnorr.ParsePubKey(pubkeyBytes); err != nil {
+		return "", fmt.Errorf("not a valid public key")
+	}
+	return pubkey, nil
+}
+
+// pubkeyToNpub converts hex pubkey to npub
+func pubkeyToNpub(pubkey string) (string, error) {
+	pubkeyBytes, err := hex.DecodeString(pubkey)
+	if err != nil || len(pubkeyBytes) != 32 {
+		return "", fmt.Errorf("invalid pubkey format")
+	}
+
+	return encodeBech32Key("npub", pubkeyBytes)
+}
+
+// bech32KeyGroups is the length of a 32-byte key in 5-bit groups: 256 bits
+// take 52 groups, the last 4 bits of which are padding
+const bech32KeyGroups = 52
+
+// decodeBech32Key decodes a NIP-19 key (npub, nsec) with prefix hrp. Anything
+// but the canonical encoding of exactly 32 bytes is rejected: bech32m
+// checksums, other lengths and set padding bits.
+func decodeBech32Key(value, hrp string) ([]byte, error) {
+	decodedHRP, data, version, err := bech32.DecodeGeneric(value)
 	if err != nil {
-		return "", fmt.Errorf("bit conversion failed: %v", err)
+		return nil, fmt.Errorf("invalid bech32: %v", err)
+	}
+	if decodedHRP != hrp {
+		return nil, fmt.Errorf("invalid %s: prefix is %q", hrp, decodedHRP)
+	}
+	if version != bech32.Version0 {
+		return nil, fmt.Errorf("invalid %s: bech32m checksum, NIP-19 uses bech32", hrp)
+	}
+	if len(data) != bech32KeyGroups {
+		return nil, fmt.Errorf("invalid %s: encodes %d bits, expected a 32-byte key", hrp, len(data)*5)
+	}
+	if data[len(data)-1]&0x0f != 0 {
+		return nil, fmt.Errorf("invalid %s: non-canonical encoding (padding bits set)", hrp)
 	}
 
-	return encodeHex(converted), nil
+	payload, err := bech32.ConvertBits(data, 5, 8, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: bit conversion failed: %v", hrp, err)
+	}
+	if len(payload) != 32 {
+		return nil, fmt.Errorf("invalid %s: payload is %d bytes, expected 32", hrp, len(payload))
+	}
+
+	// Uppercase input decodes too; only the lowercase form round-trips
+	if canonical, err := encodeBech32Key(hrp, payload); err != nil || canonical != value {
+		return nil, fmt.Errorf("invalid %s: non-canonical encoding (use %s)", hrp, canonical)
+	}
+	return payload, nil
+}
+
+// encodeBech32Key encodes a 32-byte key as a NIP-19 bech32 string
+func encodeBech32Key(hrp string, key []byte) (string, error) {
+	if len(key) != 32 {
+		return "", fmt.Errorf("cannot encode %s: key is %d bytes, expected 32", hrp, len(key))
+	}
+	converted, err := bech32.ConvertBits(key, 8, 5, true)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode %s: %v", hrp, err)
+	}
+	return bech32.Encode(hrp, converted)
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+)
+
+var quickConfig = &quick.Config{MaxCount: 2000}
+
+// Any 32 bytes encode to an npub that decodes to the same bytes
+func TestBech32KeyRoundTrip(t *testing.T) {
+	roundTrip := func(key [32]byte) bool {
+		npub, err := encodeBech32Key("npub", key[:])
+		if err != nil {
+			return false
+		}
+		decoded, err := decodeBech32Key(npub, "npub")
+		return err == nil && string(decoded) == string(key[:])
+	}
+	if err := quick.Check(roundTrip, quickConfig); err != nil {
+		t.Error(err)
+	}
+}
+
+// The hex and npub forms of a key convert into each other
+func TestNpubPubkeyRoundTrip(t *testing.T) {
+	roundTrip := func(key [32]byte) bool {
+		pubkey := hex.EncodeToString(key[:])
+		npub, err := pubkeyToNpub(pubkey)
+		if err != nil {
+			return false
+		}
+		back, err := npubToPubkey(npub)
+		return err == nil && back == pubkey
+	}
+	if err := quick.Check(roundTrip, quickConfig); err != nil {
+		t.Error(err)
+	}
+}
+
+// Whatever string decodes as an npub is the canonical encoding of its key:
+// typos, case changes, truncation and extension are rejected or (for a
+// checksum collision) decode to a key that re-encodes to the same string
+func TestNpubDecodingIsCanonical(t *testing.T) {
+	const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7lQPZRY9X8GF2TVDW0S3JN54KHCE6MUA7L1b"
+	canonical := func(key [32]byte, seed int64) bool {
+		npub, err := encodeBech32Key("npub", key[:])
+		if err != nil {
+			return false
+		}
+		random := rand.New(rand.NewSource(seed))
+		mutated := []byte(npub)
+		switch random.Intn(4) {
+		case 0: // Replace a character
+			i := random.Intn(len(mutated))
+			mutated[i] = charset[random.Intn(len(charset))]
+		case 1: // Change the case of a character
+			i := 5 + random.Intn(len(mutated)-5)
+			mutated[i] = strings.ToUpper(string(mutated[i]))[0]
+		case 2: // Drop a character
+			i := random.Intn(len(mutated))
+			mutated = append(mutated[:i], mutated[i+1:]...)
+		case 3: // Insert a character
+			i := random.Intn(len(mutated) + 1)
+			mutated = append(mutated[:i], append([]byte{charset[random.Intn(len(charset))]}, mutated[i:]...)...)
+		}
+
+		pubkey, err := npubToPubkey(string(mutated))
+		if err != nil {
+			return true
+		}
+		again, err := pubkeyToNpub(pubkey)
+		return err == nil && again == string(mutated)
+	}
+	if err := quick.Check(canonical, quickConfig); err != nil {
+		t.Error(err)
+	}
+}
+
+// Encodings other than bech32 of exactly 32 bytes with clear padding bits
+// are rejected
+func TestDecodeBech32KeyRejectsNonCanonical(t *testing.T) {
+	padded := func(key [32]byte, padding uint8) bool {
+		data, err := bech32.ConvertBits(key[:], 8, 5, true)
+		if err != nil {
+			return false
+		}
+		data[len(data)-1] |= padding%15 + 1
+		encoded, err := bech32.Encode("npub", data)
+		if err != nil {
+			return false
+		}
+		_, err = decodeBech32Key(encoded, "npub")
+		return err != nil
+	}
+	if err := quick.Check(padded, quickConfig); err != nil {
+		t.Errorf("set padding bits: %v", err)
+	}
+
+	bech32m := func(key [32]byte) bool {
+		data, err := bech32.ConvertBits(key[:], 8, 5, true)
+		if err != nil {
+			return false
+		}
+		encoded, err := bech32.EncodeM("npub", data)
+		if err != nil {
+			return false
+		}
+		_, err = decodeBech32Key(encoded, "npub")
+		return err != nil
+	}
+	if err := quick.Check(bech32m, quickConfig); err != nil {
+		t.Errorf("bech32m: %v", err)
+	}
+
+	length := func(payload []byte) bool {
+		if len(payload) == 32 {
+			return true
+		}
+		data, err := bech32.ConvertBits(payload, 8, 5, true)
+		if err != nil {
+			return false
+		}
+		encoded, err := bech32.Encode("npub", data)
+		if err != nil {
+			// Too long for bech32 at all
+			return true
+		}
+		_, err = decodeBech32Key(encoded, "npub")
+		return err != nil
+	}
+	if err := quick.Check(length, quickConfig); err != nil {
+		t.Errorf("other lengths: %v", err)
+	}
+
+	hrp := func(key [32]byte) bool {
+		nsec, err := encodeBech32Key("nsec", key[:])
+		if err != nil {
+			return false
+		}
+		_, err = decodeBech32Key(nsec, "npub")
+		return err != nil
+	}
+	if err := quick.Check(hrp, quickConfig); err != nil {
+		t.Errorf("other prefix: %v", err)
+	}
+}
+
+// A private key's npub is the npub of its public key, and parsePubkey
+// accepts it and the hex form alike
+func TestPrivateKeyToNpub(t *testing.T) {
+	derive := func(seed [32]byte) bool {
+		privateKey, _ := btcec.PrivKeyFromBytes(seed[:])
+		if privateKey.Key.IsZero() {
+			return true
+		}
+		npub, err := privateKeyToNpub(privateKey)
+		if err != nil {
+			return false
+		}
+		pubkey := hex.EncodeToString(schnorr.SerializePubKey(privateKey.PubKey()))
+		fromNpub, err := parsePubkey(npub)
+		if err != nil || fromNpub != pubkey {
+			return false
+		}
+		fromHex, err := parsePubkey(pubkey)
+		return err == nil && fromHex == pubkey && keyMatchesNpub(privateKey, npub)
+	}
+	if err := quick.Check(derive, &quick.Config{MaxCount: 200}); err != nil {
+		t.Error(err)
+	}
+}
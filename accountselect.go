@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// Error codes of per-request account selection
+const (
+	codeAccountLocked  = "ERR_ACCOUNT_LOCKED"
+	codeUnknownAccount = "ERR_UNKNOWN_ACCOUNT"
+)
+
+var (
+	// errAccountLocked is returned when a request names an account other
+	// than the unlocked one and no valid trust session of it can be opened
+	errAccountLocked = errors.New("account is locked")
+
+	// errUnknownAccount is returned when a request names an account that
+	// isn't stored
+	errUnknownAccount = errors.New("account not found")
+
+	// errAccountField is returned for a malformed pubkey or npub, or for
+	// both naming different accounts
+	errAccountField = errors.New("invalid account")
+)
+
+// accountKey is the key a request is served with: the active account's,
+// one kept by unlock_account, or one opened from a trust session for this
+// one request
+type accountKey struct {
+	privateKey *btcec.PrivateKey
+	pubkey     string
+	// convKeys caches conversation keys; nil for a key opened on demand
+	convKeys *conversationKeyCache
+}
+
+// activeKey returns the unlocked account's key. Caller must hold d.mu (read).
+func (d *Daemon) activeKey() *accountKey {
+	return &accountKey{privateKey: d.privateKey, pubkey: d.pubkey, convKeys: &d.convKeys}
+}
+
+// conversationKey returns the NIP-44 conversation key between the account
+// and a peer
+func (k *accountKey) conversationKey(peerPubkey string) ([32]byte, error) {
+	keyBytes := k.privateKey.Serialize()
+	privateKeyHex := hex.EncodeToString(keyBytes)
+	zeroBytes(keyBytes)
+
+	if k.convKeys != nil {
+		return k.convKeys.get(peerPubkey, privateKeyHex)
+	}
+	key, err := nip44.GenerateConversationKey(peerPubkey, privateKeyHex)
+	if err != nil {
+		return key, fmt.Errorf("failed to generate conversation key: %v", err)
+	}
+	return key, nil
+}
+
+// drop zeroes a key opened on demand
+func (k *accountKey) drop() {
+	loadedKeys.remove(k.privateKey)
+	k.privateKey.Zero()
+}
+
+// requestAccount returns the npub of the account req names in pubkey or
+// npub, which may also be a label ("" if it names none)
+func requestAccount(req SignRequest) (string, error) {
+	npub, err := resolveNamedAccount(req.Npub)
+	if err != nil {
+		return "", err
+	}
+	if npub != "" {
+		if _, err := npubToPubkey(npub); err != nil {
+			return "", fmt.Errorf("%w: %v", errAccountField, err)
+		}
+	}
+	if req.Pubkey == "" {
+		return npub, nil
+	}
+
+	fromPubkey, err := pubkeyToNpub(strings.ToLower(strings.TrimSpace(req.Pubkey)))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errAccountField, err)
+	}
+	if npub != "" && npub != fromPubkey {
+		return "", fmt.Errorf("%w: pubkey and npub name different accounts", errAccountField)
+	}
+	return fromPubkey, nil
+}
+
+// requestNpub returns the account a key-using request acts as: the one it
+// names, else the active one. Permissions, approvals and the activity log
+// go by it.
+func (d *Daemon) requestNpub(req SignRequest) string {
+	if approvalMethods[req.Method] {
+		if npub, err := requestAccount(req); err == nil && npub != "" {
+			return npub
+		}
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.npub
+}
+
+// withAccountKey runs f with the key of the account req names, or of the
+// active account if it names none. Another account's key is the one
+// unlock_account left in memory, or else is opened from its trust session
+// and zeroed when f returns. While the daemon is locked, no account's key
+// is used.
+func (d *Daemon) withAccountKey(req SignRequest, f func(key *accountKey) error) error {
+	npub, err := requestAccount(req)
+	if err != nil {
+		return err
+	}
+
+	d.mu.RLock()
+	if npub == "" || npub == d.npub {
+		defer d.mu.RUnlock()
+		if err := d.requireUnlocked(); err != nil {
+			return err
+		}
+		return f(d.activeKey())
+	}
+	if privateKey := d.unlocked[npub]; privateKey != nil {
+		defer d.mu.RUnlock()
+		pubkey, _ := npubToPubkey(npub)
+		return f(&accountKey{privateKey: privateKey, pubkey: pubkey})
+	}
+	unlocked := d.privateKey != nil
+	d.mu.RUnlock()
+	if !unlocked {
+		return d.lockedError()
+	}
+
+	key, err := d.openTrustedKey(npub)
+	if err != nil {
+		return err
+	}
+	defer key.drop()
+	return f(key)
+}
+
+// openTrustedKey opens npub's key from its trust session. With
+// trust_idle_timeout the use counts for the session like any other.
+func (d *Daemon) openTrustedKey(npub string) (*accountKey, error) {
+	if !accountExists(npub) {
+		return nil, fmt.Errorf("%w: %s", errUnknownAccount, displayNpub(npub))
+	}
+	if d.noTrust {
+		return nil, fmt.Errorf("%w: %s (Trust Mode is disabled)", errAccountLocked, displayNpub(npub))
+	}
+
+	session, privateKey, err := openAccountTrustSession(npub)
+	if errors.Is(err, errSignerFrozen) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errAccountLocked, displayNpub(npub), err)
+	}
+	loadedKeys.add(privateKey)
+
+	now := time.Now()
+	if d.config.trustIdleTimeout() > 0 && now.Sub(session.lastUsed()) >= trustTouchInterval {
+		if err := touchAccountTrustSession(npub, now); err != nil {
+			logError("⚠️  Cannot record the use of %s's trust session: %v", displayNpub(npub), err)
+		}
+	}
+
+	pubkey, _ := npubToPubkey(npub)
+	return &accountKey{privateKey: privateKey, pubkey: pubkey}, nil
+}
+
+// unlockAccount decrypts npub's key with password and keeps it in memory
+// next to the active account's, so requests naming npub need no trust
+// session. The active account stays as it is. With trust, npub also gets a
+// trust session, as with switch_account.
+func (d *Daemon) unlockAccount(npub, password string, trust bool) error {
+	d.mu.RLock()
+	err := d.requireUnlocked()
+	d.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if !accountExists(npub) {
+		return fmt.Errorf("%w: %s", errUnknownAccount, displayNpub(npub))
+	}
+
+	encKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		return fmt.Errorf("failed to load account: %v", err)
+	}
+	nsec, privateKey, err := decryptAccountKey(encKey, npub, password)
+	if err != nil {
+		return err
+	}
+
+	if trust {
+		session, err := createTrustSession(nsec)
+		if err == nil {
+			err = saveAccountTrustSession(npub, session)
+		}
+		if err != nil {
+			logError("⚠️  No trust session for %s: %v", displayNpub(npub), err)
+		}
+	}
+
+	// Clear nsec from memory
+	for i := range nsec {
+		nsec = nsec[:i] + "x" + nsec[i+1:]
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Locked meanwhile, or the active account, whose key is loaded already
+	if d.privateKey == nil || npub == d.npub {
+		privateKey.Zero()
+		return d.requireUnlocked()
+	}
+	d.dropUnlockedLocked(npub)
+	if d.unlocked == nil {
+		d.unlocked = make(map[string]*btcec.PrivateKey)
+	}
+	d.unlocked[npub] = privateKey
+	loadedKeys.add(privateKey)
+	return nil
+}
+
+// dropUnlockedLocked zeroes and forgets npub's key from unlock_account, or
+// all of them with "". Caller must hold d.mu.
+func (d *Daemon) dropUnlockedLocked(npub string) {
+	for unlockedNpub, privateKey := range d.unlocked {
+		if npub == "" || unlockedNpub == npub {
+			loadedKeys.remove(privateKey)
+			privateKey.Zero()
+			delete(d.unlocked, unlockedNpub)
+		}
+	}
+}
+
+// unlockedAccounts lists the accounts unlocked with unlock_account, for
+// get_status
+func (d *Daemon) unlockedAccounts() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	npubs := make([]string, 0, len(d.unlocked))
+	for npub := range d.unlocked {
+		npubs = append(npubs, npub)
+	}
+	sort.Strings(npubs)
+	return npubs
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// A request naming an account by pubkey or npub is served with that
+// account's key, or refused with the code that says why it can't be
+func TestDaemonAccountRouting(t *testing.T) {
+	testHome(t)
+	_, strangerNpub := testKey(t)
+	trusted := addTestAccount(t, "trusted")
+	unlocked := addTestAccount(t, "unlocked")
+	stored := addTestAccount(t, "stored")
+	active := addTestAccount(t, "active")
+	freezeTestAccount(t, trusted)
+	d := testDaemon(t, active)
+	d.noTrust = false
+	if err := d.unlockAccount(unlocked, testPassword, false); err != nil {
+		t.Fatalf("unlock_account: %v", err)
+	}
+	serveTestDaemon(t, d)
+
+	pubkey := func(npub string) string {
+		pubkey, err := npubToPubkey(npub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pubkey
+	}
+	tests := []struct {
+		name   string
+		pubkey string
+		npub   string
+		signer string // Account whose key signs, "" if refused
+		code   string
+	}{
+		{"none named", "", "", active, ""},
+		{"active by pubkey", pubkey(active), "", active, ""},
+		{"active by npub", "", active, active, ""},
+		{"trust session by pubkey", pubkey(trusted), "", trusted, ""},
+		{"trust session by label", "", "trusted", trusted, ""},
+		{"unlock_account by pubkey", strings.ToUpper(pubkey(unlocked)), "", unlocked, ""},
+		{"pubkey and npub agree", pubkey(unlocked), unlocked, unlocked, ""},
+		{"no trust session", pubkey(stored), "", "", codeAccountLocked},
+		{"no trust session by npub", "", stored, "", codeAccountLocked},
+		{"unknown pubkey", pubkey(strangerNpub), "", "", codeUnknownAccount},
+		{"unknown npub", "", strangerNpub, "", codeUnknownAccount},
+		{"malformed pubkey", "abc123", "", "", codeInvalidRequest},
+		{"pubkey and npub disagree", pubkey(trusted), unlocked, "", codeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := SignRequest{
+				Method:    "sign_event",
+				EventJSON: `{"kind":1,"content":"hi","tags":[],"created_at":1700000000}`,
+				Pubkey:    tt.pubkey,
+				Npub:      tt.npub,
+			}
+			var response SignResponse
+			if err := dialTestDaemon(t).request(t, request, &response); err != nil {
+				t.Fatal(err)
+			}
+			if tt.signer == "" {
+				if response.Code != tt.code || response.Event != nil {
+					t.Fatalf("sign_event: %+v, want %s", response, tt.code)
+				}
+				return
+			}
+			if response.Error != "" || response.Event == nil {
+				t.Fatalf("sign_event: %+v", response)
+			}
+			if want := pubkey(tt.signer); response.Event.Pubkey != want {
+				t.Fatalf("signed as %s, want %s", response.Event.Pubkey, want)
+			}
+		})
+	}
+
+	t.Run("no trust", func(t *testing.T) {
+		// With --no-trust even a trust session isn't used
+		d.noTrust = true
+		defer func() { d.noTrust = false }()
+		request := SignRequest{Method: "nip44_encrypt", Pubkey: pubkey(trusted), RecipientPubkey: pubkey(active), Plaintext: "hi"}
+		var response SignResponse
+		if err := dialTestDaemon(t).request(t, request, &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != codeAccountLocked {
+			t.Fatalf("nip44_encrypt: %+v, want %s", response, codeAccountLocked)
+		}
+	})
+
+	t.Run("daemon locked", func(t *testing.T) {
+		// Locked, no account is used, not even one with a trust session
+		d.mu.Lock()
+		d.clearKeyLocked()
+		d.mu.Unlock()
+		for _, named := range []string{trusted, unlocked, strangerNpub} {
+			request := SignRequest{Method: "nip44_encrypt", Npub: named, RecipientPubkey: pubkey(active), Plaintext: "hi"}
+			var response SignResponse
+			if err := dialTestDaemon(t).request(t, request, &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Code != codeLocked {
+				t.Errorf("nip44_encrypt as %s: %+v, want %s", displayNpub(named), response, codeLocked)
+			}
+		}
+	})
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// accountWatchDebounce lets a burst of changes (an atomic write, a
+	// migration, a removal) settle into one rescan
+	accountWatchDebounce = 250 * time.Millisecond
+
+	// accountPollInterval is how often the polling fallback compares
+	// mtimes, and how often a vanished storage dir is looked for
+	accountPollInterval = 2 * time.Second
+)
+
+// Account watch modes, reported as account_watch in get_status
+const (
+	accountWatchNotify  = "fsnotify"
+	accountWatchPolling = "polling"
+)
+
+// accountWatcher caches the account list for the daemon and drops the cache
+// when the accounts change on disk - by the daemon itself or by the CLI. It
+// watches the storage dir, the accounts dir and each account's directory
+// with one fsnotify watcher. Where watches can't be set up (no inotify
+// instances left, some network filesystems) it polls mtimes instead.
+type accountWatcher struct {
+	// onChange is called when the set of accounts or the active account
+	// changed, after a burst of changes settled
+	onChange func(accounts []AccountInfo, active string)
+
+	mu       sync.Mutex
+	accounts []AccountInfo // nil = scan on the next list
+	known    []string      // Npubs at the last rescan, sorted
+	active   string        // Active account at the last rescan
+	mode     string
+	debounce *time.Timer
+}
+
+// newAccountWatcher returns a watcher; start begins watching
+func newAccountWatcher(onChange func(accounts []AccountInfo, active string)) *accountWatcher {
+	return &accountWatcher{onChange: onChange}
+}
+
+// list returns the accounts, from the cache if nothing changed since the
+// last scan
+func (w *accountWatcher) list() ([]AccountInfo, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.accounts == nil {
+		accounts, err := listAccounts()
+		if err != nil {
+			return nil, err
+		}
+		if accounts == nil {
+			accounts = []AccountInfo{}
+		}
+		w.accounts = accounts
+	}
+	return slices.Clone(w.accounts), nil
+}
+
+// invalidate drops the cache, e.g. right after the daemon changed the
+// accounts itself, so the next list doesn't wait for the debounce
+func (w *accountWatcher) invalidate() {
+	w.mu.Lock()
+	w.accounts = nil
+	w.mu.Unlock()
+}
+
+// watchMode returns accountWatchNotify or accountWatchPolling ("" before start)
+func (w *accountWatcher) watchMode() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.mode
+}
+
+// start takes the first snapshot and begins watching in the background
+func (w *accountWatcher) start() {
+	w.rescan()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watchAccountTree(watcher); err == nil {
+			w.setMode(accountWatchNotify)
+			go w.notifyLoop(watcher)
+			return
+		}
+		watcher.Close()
+	}
+	logInfo("📂 Cannot watch the accounts for changes (%v) - checking every %s instead", err, accountPollInterval)
+	w.setMode(accountWatchPolling)
+	go w.pollLoop()
+}
+
+// setMode records how changes are noticed, for get_status
+func (w *accountWatcher) setMode(mode string) {
+	w.mu.Lock()
+	w.mode = mode
+	w.mu.Unlock()
+}
+
+// changed drops the cache and schedules a rescan once changes settle
+func (w *accountWatcher) changed() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.accounts = nil
+	if w.debounce != nil {
+		w.debounce.Stop()
+	}
+	w.debounce = time.AfterFunc(accountWatchDebounce, w.rescan)
+}
+
+// rescan reads the accounts and calls onChange if the set of accounts, their
+// labels or the active account differs from the last rescan
+func (w *accountWatcher) rescan() {
+	accounts, err := listAccounts()
+	if err != nil {
+		logError("⚠️  Cannot read the accounts: %v", err)
+		return
+	}
+	active, _ := loadActiveAccount()
+	// A relabeled account is a change too
+	npubs := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		npubs = append(npubs, account.Npub+"\t"+account.Label)
+	}
+	slices.Sort(npubs)
+
+	w.mu.Lock()
+	first := w.known == nil
+	changed := !slices.Equal(w.known, npubs) || w.active != active
+	w.known, w.active = npubs, active
+	w.accounts = append([]AccountInfo{}, accounts...)
+	w.mu.Unlock()
+
+	if changed && !first && w.onChange != nil {
+		w.onChange(accounts, active)
+	}
+}
+
+// watchAccountTree adds the storage dir, the accounts dir and every
+// account directory to watcher
+func watchAccountTree(watcher *fsnotify.Watcher) error {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(storageDir); err != nil {
+		return err
+	}
+	return watchAccountsDir(watcher)
+}
+
+// watchAccountsDir adds the accounts dir and every account directory
+func watchAccountsDir(watcher *fsnotify.Watcher) error {
+	accountsDir, err := getAccountsDir()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(accountsDir); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(accountsDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err := watcher.Add(filepath.Join(accountsDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// notifyLoop handles the watcher's events until it fails, then falls back
+// to polling
+func (w *accountWatcher) notifyLoop(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if err := w.handleEvent(watcher, event); err != nil {
+				logInfo("📂 Lost the watch on the accounts (%v) - checking every %s instead", err, accountPollInterval)
+				w.setMode(accountWatchPolling)
+				go w.pollLoop()
+				return
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// Events were lost; a rescan catches up
+				w.changed()
+				continue
+			}
+			logError("⚠️  Account watch: %v", err)
+		}
+	}
+}
+
+// handleEvent reacts to one fsnotify event: new directories get a watch,
+// relevant changes a rescan, and a removed storage dir a fresh set of
+// watches once it is back
+func (w *accountWatcher) handleEvent(watcher *fsnotify.Watcher, event fsnotify.Event) error {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return err
+	}
+	accountsDir := filepath.Join(storageDir, "accounts")
+
+	if event.Name == storageDir && event.Has(fsnotify.Remove|fsnotify.Rename) {
+		w.changed()
+		for _, path := range watcher.WatchList() {
+			watcher.Remove(path)
+		}
+		return w.rewatch(watcher)
+	}
+	if !accountEventRelevant(storageDir, accountsDir, event.Name) {
+		return nil
+	}
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			add := watcher.Add
+			if event.Name == accountsDir {
+				add = func(string) error { return watchAccountsDir(watcher) }
+			}
+			if err := add(event.Name); err != nil {
+				return err
+			}
+		}
+	}
+	w.changed()
+	return nil
+}
+
+// rewatch waits for a removed storage dir to come back, then watches it
+// again. The storage dir is not created here: that is up to whoever
+// restores it.
+func (w *accountWatcher) rewatch(watcher *fsnotify.Watcher) error {
+	for {
+		time.Sleep(accountPollInterval)
+		storageDir, err := getStorageDir()
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(storageDir); err != nil {
+			continue
+		}
+		if err := watchAccountTree(watcher); err != nil {
+			return err
+		}
+		logInfo("📂 Storage dir is back - watching the accounts again")
+		w.changed()
+		return nil
+	}
+}
+
+// accountEventRelevant tells whether a change to path can change the
+// account list, an account's label or the active account. Trust sessions,
+// health records and temp files of atomic writes are not.
+func accountEventRelevant(storageDir, accountsDir, path string) bool {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	switch {
+	case path == accountsDir:
+		return true
+	case dir == storageDir:
+		return name == "active_account" || strings.HasPrefix(name, "noorsigner.db")
+	case dir == accountsDir:
+		return true
+	case filepath.Dir(dir) == accountsDir:
+		return name == recordKey || name == recordMetadata
+	}
+	return false
+}
+
+// pollLoop compares the mtimes of the accounts tree every
+// accountPollInterval and rescans when they changed
+func (w *accountWatcher) pollLoop() {
+	// The first tick always rescans: changes made before the loop started
+	// must not go unnoticed
+	var last string
+	ticker := time.NewTicker(accountPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if stamp := accountTreeStamp(); stamp != last {
+			last = stamp
+			w.changed()
+		}
+	}
+}
+
+// accountTreeStamp summarizes the mtimes and sizes the account list and
+// active account depend on
+func accountTreeStamp() string {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return ""
+	}
+	accountsDir := filepath.Join(storageDir, "accounts")
+
+	var stamp strings.Builder
+	add := func(path string) {
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&stamp, "%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+		} else {
+			fmt.Fprintf(&stamp, "%s:-;", path)
+		}
+	}
+	add(filepath.Join(storageDir, "active_account"))
+	add(filepath.Join(storageDir, "noorsigner.db"))
+	add(accountsDir)
+	entries, _ := os.ReadDir(accountsDir)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			add(filepath.Join(accountsDir, entry.Name(), recordKey))
+			add(filepath.Join(accountsDir, entry.Name(), recordMetadata))
+		}
+	}
+	return stamp.String()
+}
+
+// accountsChanged tells subscribers the accounts changed on disk, so GUIs
+// can refresh right after a CLI change
+func (d *Daemon) accountsChanged(accounts []AccountInfo, active string) {
+	pubkey, _ := npubToPubkey(active)
+	d.emit(StreamEvent{
+		Type:   "accounts_changed",
+		Npub:   active,
+		Pubkey: pubkey,
+		Data:   map[string]string{"accounts": fmt.Sprintf("%d", len(accounts))},
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// accountChange is one onChange call of the watcher under test
+type accountChange struct {
+	npubs  []string
+	active string
+}
+
+// waitAccountChange waits for an onChange of the watcher after which done
+// holds. Changes come within the debounce with fsnotify, within a poll
+// interval without.
+func waitAccountChange(t *testing.T, changes <-chan accountChange, done func(accountChange) bool) {
+	t.Helper()
+	timeout := time.After(3 * accountPollInterval)
+	for {
+		select {
+		case change := <-changes:
+			if done(change) {
+				return
+			}
+		case <-timeout:
+			t.Fatal("change not noticed")
+		}
+	}
+}
+
+// Accounts created, relabeled, renamed away or removed by another process,
+// and switching the active account, each reach the daemon's account list
+func TestAccountWatcher(t *testing.T) {
+	testHome(t)
+	first := addTestAccount(t, "")
+
+	changes := make(chan accountChange, 16)
+	w := newAccountWatcher(func(accounts []AccountInfo, active string) {
+		change := accountChange{active: active}
+		for _, account := range accounts {
+			change.npubs = append(change.npubs, account.Npub)
+		}
+		select {
+		case changes <- change:
+		default:
+		}
+	})
+	w.start()
+	listed := func() []string {
+		t.Helper()
+		accounts, err := w.list()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var npubs []string
+		for _, account := range accounts {
+			npubs = append(npubs, account.Npub)
+		}
+		return npubs
+	}
+	if got := listed(); !slices.Equal(got, []string{first}) {
+		t.Fatalf("accounts at start %v, want %v", got, []string{first})
+	}
+
+	second := addTestAccount(t, "")
+	waitAccountChange(t, changes, func(change accountChange) bool {
+		return len(change.npubs) == 2 && change.active == second
+	})
+	if got := listed(); !slices.Contains(got, second) {
+		t.Errorf("created account missing from %v", got)
+	}
+
+	if err := setAccountLabel(first, "work"); err != nil {
+		t.Fatal(err)
+	}
+	waitAccountChange(t, changes, func(accountChange) bool {
+		accounts, _ := w.list()
+		return slices.ContainsFunc(accounts, func(a AccountInfo) bool { return a.Npub == first && a.Label == "work" })
+	})
+
+	if err := saveActiveAccount(first); err != nil {
+		t.Fatal(err)
+	}
+	waitAccountChange(t, changes, func(change accountChange) bool {
+		return change.active == first
+	})
+
+	// Moved out of the accounts directory, as by mv
+	accountsDir, err := getAccountsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(accountsDir, second), filepath.Join(t.TempDir(), second)); err != nil {
+		t.Skipf("accounts are not directories in this store: %v", err)
+	}
+	waitAccountChange(t, changes, func(change accountChange) bool {
+		return !slices.Contains(change.npubs, second)
+	})
+	if got := listed(); slices.Contains(got, second) {
+		t.Errorf("renamed account still in %v", got)
+	}
+
+	if err := removeAccount(first); err != nil {
+		t.Fatal(err)
+	}
+	waitAccountChange(t, changes, func(change accountChange) bool {
+		return len(change.npubs) == 0
+	})
+	if got := listed(); len(got) != 0 {
+		t.Errorf("accounts after remove %v, want none", got)
+	}
+}
+
+func TestAccountEventRelevant(t *testing.T) {
+	storageDir := filepath.Join("home", ".noorsigner")
+	accountsDir := filepath.Join(storageDir, "accounts")
+	account := filepath.Join(accountsDir, "npub1example")
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{accountsDir, true},
+		{account, true},
+		{filepath.Join(account, recordKey), true},
+		{filepath.Join(account, recordMetadata), true},
+		{filepath.Join(account, recordTrustSession), false},
+		{filepath.Join(account, recordCounters), false},
+		{filepath.Join(account, recordKey+".tmp123"), false},
+		{filepath.Join(storageDir, "active_account"), true},
+		{filepath.Join(storageDir, "noorsigner.db-wal"), true},
+		{filepath.Join(storageDir, "daemon.log"), false},
+		{filepath.Join(storageDir, "config.json"), false},
+	}
+	for _, tt := range tests {
+		if got := accountEventRelevant(storageDir, accountsDir, tt.path); got != tt.want {
+			t.Errorf("accountEventRelevant(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
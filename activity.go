@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRecentActivitySize is how many activity entries the daemon keeps
+	defaultRecentActivitySize = 200
+
+	// maxRecentActivitySize bounds recent_activity_size in config.json
+	maxRecentActivitySize = 10000
+
+	// defaultActivityPageSize is the get_recent_activity page size without "limit"
+	defaultActivityPageSize = 50
+
+	// maxActivityErrorLength truncates error messages kept in activity entries
+	maxActivityErrorLength = 200
+)
+
+// auditedMethods are the methods that produce activity entries: everything
+// that uses or changes a key. Read-only queries are not recorded.
+var auditedMethods = map[string]bool{
+	"sign_event":          true,
+	"sign_events":         true,
+	"zap_request":         true,
+	"sign_relay_list":     true,
+	"gift_wrap":           true,
+	"unwrap":              true,
+	"send_dm":             true,
+	"decrypt_dm":          true,
+	"nip44_encrypt":       true,
+	"nip44_decrypt":       true,
+	"nip44_decrypt_batch": true,
+	"nip04_encrypt":       true,
+	"nip04_decrypt":       true,
+	"add_account":         true,
+	"switch_account":      true,
+	"unlock_account":      true,
+	"remove_account":      true,
+	"set_label":           true,
+	"set_relays":          true,
+	"respond_credential":  true,
+	"enable_autostart":    true,
+	"disable_autostart":   true,
+	"shutdown_daemon":     true,
+	"lock":                true,
+	"set_policy":          true,
+	"respond_approval":    true,
+	"nip46_connect":       true,
+	"nip46_bunker":        true,
+	"reset_rate_limits":   true,
+}
+
+// ActivityEntry is one audited request. Entries are redacted when recorded:
+// they never contain event content, plaintexts, ciphertexts, passwords,
+// nsecs or counterparty pubkeys - only what was done, for which account,
+// and whether it worked.
+type ActivityEntry struct {
+	Seq       uint64 `json:"seq"`
+	Timestamp int64  `json:"timestamp"`
+	Method    string `json:"method"`
+	Npub      string `json:"npub,omitempty"`
+	ConnID    uint64 `json:"conn_id"`
+	// Client is the authorized client's name (only with require_auth)
+	Client  string `json:"client,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
+	// Kind is the event kind for sign_event (the rumor's for gift_wrap)
+	Kind *int `json:"kind,omitempty"`
+	// EventID is the id of the event signed by sign_event, zap_request or
+	// sign_relay_list, or of the wrap gift_wrap created
+	EventID string `json:"event_id,omitempty"`
+	// Items is the number of payloads in a nip44_decrypt_batch request or
+	// events in a sign_events request
+	Items int `json:"items,omitempty"`
+	// Who sent the request (see clientident.go)
+	ClientIdentity
+}
+
+// RecentActivityResponse represents get_recent_activity response
+type RecentActivityResponse struct {
+	ID      string          `json:"id"`
+	Entries []ActivityEntry `json:"entries"`
+	// OldestSeq is the oldest entry still buffered; after_seq below it means
+	// entries were dropped in between
+	OldestSeq uint64 `json:"oldest_seq"`
+	// LatestSeq is the newest entry recorded so far (0 = none yet)
+	LatestSeq uint64 `json:"latest_seq"`
+	// HasMore is set when the page was cut off by limit
+	HasMore bool   `json:"has_more"`
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+// activityRing is a bounded buffer of the most recent activity entries
+type activityRing struct {
+	mu      sync.Mutex
+	entries []ActivityEntry // Oldest first, at most size entries
+	size    int
+	lastSeq uint64
+}
+
+// newActivityRing returns a ring holding size entries (0 disables recording)
+func newActivityRing(size int) *activityRing {
+	return &activityRing{size: size}
+}
+
+// enabled reports whether activity is recorded at all
+func (r *activityRing) enabled() bool {
+	return r != nil && r.size > 0
+}
+
+// add stores entry under the next sequence number and returns it
+func (r *activityRing) add(entry ActivityEntry) ActivityEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastSeq++
+	entry.Seq = r.lastSeq
+
+	if len(r.entries) >= r.size {
+		// Shift instead of wrapping indexes - size is small and pages stay contiguous
+		copy(r.entries, r.entries[1:])
+		r.entries = r.entries[:len(r.entries)-1]
+	}
+	r.entries = append(r.entries, entry)
+
+	return entry
+}
+
+// page returns up to limit entries in ascending order. With afterSeq > 0 it
+// pages forward from afterSeq; otherwise it returns the newest entries
+// before beforeSeq (0 = up to the latest).
+func (r *activityRing) page(afterSeq, beforeSeq uint64, limit int) (entries []ActivityEntry, oldest, latest uint64, hasMore bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.entries) > 0 {
+		oldest = r.entries[0].Seq
+	}
+	latest = r.lastSeq
+
+	var selected []ActivityEntry
+	if afterSeq > 0 {
+		for i, entry := range r.entries {
+			if entry.Seq > afterSeq {
+				selected = r.entries[i:]
+				break
+			}
+		}
+		if len(selected) > limit {
+			selected, hasMore = selected[:limit], true
+		}
+	} else {
+		selected = r.entries
+		if beforeSeq > 0 {
+			end := 0
+			for end < len(selected) && selected[end].Seq < beforeSeq {
+				end++
+			}
+			selected = selected[:end]
+		}
+		if len(selected) > limit {
+			selected, hasMore = selected[len(selected)-limit:], true
+		}
+	}
+
+	entries = make([]ActivityEntry, len(selected))
+	copy(entries, selected)
+	return entries, oldest, latest, hasMore
+}
+
+// recordActivity adds an entry for an audited request and streams it to
+// subscribers as an "activity" event
+func (d *Daemon) recordActivity(session *connSession, req SignRequest, result activityResult) {
+	if !auditedMethods[req.Method] || (!d.activity.enabled() && !d.config.auditEnabled()) {
+		return
+	}
+
+	npub := d.requestNpub(req)
+	if req.Method == "add_account" || req.Method == "switch_account" || req.Method == "unlock_account" || req.Method == "remove_account" || req.Method == "set_label" || req.Method == "set_relays" {
+		// The account acted on, not the one that happens to be active
+		npub = result.Npub
+		if npub == "" {
+			npub, _ = resolveAccount(req.Npub)
+		}
+		if npub == "" && req.Pubkey != "" {
+			npub, _ = pubkeyToNpub(req.Pubkey)
+		}
+	}
+
+	entry := ActivityEntry{
+		Timestamp: time.Now().Unix(),
+		Method:    req.Method,
+		Npub:      npub,
+		ConnID:    session.id,
+		Client:    session.client,
+		Success:   result.Error == "",
+		Error:     redactActivityError(result.Error),
+		Code:      result.Code,
+
+		ClientIdentity: session.identity(req),
+	}
+	switch req.Method {
+	case "sign_event":
+		entry.Kind = eventKind(req.EventJSON)
+	case "zap_request":
+		kind := zapRequestKind
+		entry.Kind = &kind
+	case "sign_relay_list":
+		kind := relayListKind
+		entry.Kind = &kind
+	case "gift_wrap":
+		entry.Kind = eventKind(req.EventJSON)
+	case "send_dm":
+		kind := dmKind
+		entry.Kind = &kind
+	case "nip44_decrypt_batch":
+		entry.Items = len(req.Items)
+	case "sign_events":
+		entry.Items = len(req.Events)
+	}
+
+	entry.EventID = result.EventID
+	d.auditActivity(entry, req, result)
+	if d.activity.enabled() {
+		entry = d.activity.add(entry)
+		d.emit(StreamEvent{Type: "activity", Timestamp: entry.Timestamp, Npub: entry.Npub, Activity: &entry})
+	}
+}
+
+// recentActivity answers get_recent_activity
+func (d *Daemon) recentActivity(req SignRequest) RecentActivityResponse {
+	if !d.activity.enabled() {
+		return RecentActivityResponse{
+			ID:    req.ID,
+			Error: "recent activity is disabled (recent_activity_size is 0)",
+			Code:  codeActivityDisabled,
+		}
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultActivityPageSize
+	}
+
+	entries, oldest, latest, hasMore := d.activity.page(req.AfterSeq, req.BeforeSeq, limit)
+	return RecentActivityResponse{
+		ID:        req.ID,
+		Entries:   entries,
+		OldestSeq: oldest,
+		LatestSeq: latest,
+		HasMore:   hasMore,
+	}
+}
+
+// redactActivityError strips secrets from an error message and truncates it
+func redactActivityError(message string) string {
+	message = redactSecrets("activity", message)
+	if len(message) > maxActivityErrorLength {
+		message = message[:maxActivityErrorLength] + "..."
+	}
+	return message
+}
+
+// activityResult is what recordActivity needs to know about a response
+type activityResult struct {
+	Error   string `json:"error"`
+	Code    string `json:"code"`
+	Npub    string `json:"npub"`
+	EventID string `json:"event_id"`
+	// Sender of the rumor unwrap opened, for audit.log
+	Sender string `json:"sender"`
+	// Results of sign_events, audited one entry per event
+	Results []struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	} `json:"results"`
+}
+
+// responseRecorder passes responses through to the client and remembers the
+// outcome of the last one, so handleRequest needs no audit calls of its own.
+// It also counts error responses for the stats method.
+type responseRecorder struct {
+	w       io.Writer
+	capture bool // Only audited methods pay for decoding their response
+	last    activityResult
+	metrics *daemonMetrics
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	// json.Encoder writes each response with a single Write
+	if r.capture {
+		r.last = activityResult{}
+		json.Unmarshal(p, &r.last)
+	}
+	if r.metrics != nil && bytes.Contains(p, []byte(`"error":`)) {
+		result := r.last
+		if !r.capture {
+			json.Unmarshal(p, &result)
+		}
+		if result.Error != "" {
+			r.metrics.countError(result.Code)
+		}
+	}
+	return r.w.Write(p)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// defaultApprovalTimeout is how long approval_command may take to decide
+	defaultApprovalTimeout = 30 * time.Second
+
+	// approvalPreviewLength is how many characters of event content the
+	// approval command sees
+	approvalPreviewLength = 120
+
+	codeApprovalDenied = "ERR_APPROVAL_DENIED"
+)
+
+// approvalMethods are the methods that use the key. With approval_command
+// set, each of these requests runs only if the command approves it.
+var approvalMethods = map[string]bool{
+	"sign_event":          true,
+	"sign_events":         true,
+	"zap_request":         true,
+	"sign_relay_list":     true,
+	"gift_wrap":           true,
+	"unwrap":              true,
+	"send_dm":             true,
+	"decrypt_dm":          true,
+	"nip44_encrypt":       true,
+	"nip44_decrypt":       true,
+	"nip44_decrypt_batch": true,
+	"nip04_encrypt":       true,
+	"nip04_decrypt":       true,
+}
+
+// approvalRedactedKinds are kinds whose content is private or encrypted. The
+// approval command gets no preview of them, only the kind.
+var approvalRedactedKinds = map[int]bool{
+	4:     true, // legacy DM
+	13:    true, // seal
+	14:    true, // direct message
+	1059:  true, // gift wrap
+	23194: true, // wallet request
+	23195: true, // wallet response
+	24133: true, // remote signer message
+}
+
+// ApprovalContext is written as JSON to approval_command's stdin. Like the
+// activity feed it never holds plaintexts, ciphertexts or counterparty
+// pubkeys; event content only as a short preview, and not for the kinds in
+// approvalRedactedKinds.
+type ApprovalContext struct {
+	// TraceID ties the decision to its daemon.log line and error message
+	TraceID   string `json:"trace_id"`
+	Timestamp int64  `json:"timestamp"`
+	Method    string `json:"method"`
+	RequestID string `json:"request_id,omitempty"`
+	Npub      string `json:"npub,omitempty"`
+	// Kind of the event to sign (sign_event, zap_request)
+	Kind *int `json:"kind,omitempty"`
+	// Tags is the number of tags of the event to sign
+	Tags int `json:"tags,omitempty"`
+	// Kinds and Items describe a batch (sign_events, nip44_decrypt_batch)
+	Kinds []int `json:"kinds,omitempty"`
+	Items int   `json:"items,omitempty"`
+	// ContentPreview is the start of the event content; ContentRedacted is
+	// set when the kind's content is withheld
+	ContentPreview  string `json:"content_preview,omitempty"`
+	ContentRedacted bool   `json:"content_redacted,omitempty"`
+	// AmountMsats is the amount of a zap_request
+	AmountMsats int64 `json:"amount_msats,omitempty"`
+	// ClientName is the authorized client (require_auth, see clients.go)
+	ClientName string         `json:"client_name,omitempty"`
+	Client     ClientIdentity `json:"client"`
+}
+
+// approvalCommand returns approval_command ("" = no approval needed)
+func (c *Config) approvalCommand() string {
+	if c == nil {
+		return ""
+	}
+	return c.ApprovalCommand
+}
+
+// approvalTimeout returns approval_timeout, or the default if unset or invalid
+func (c *Config) approvalTimeout() time.Duration {
+	if c == nil {
+		return defaultApprovalTimeout
+	}
+	timeout, err := parseApprovalTimeout(c.ApprovalTimeout)
+	if err != nil {
+		return defaultApprovalTimeout
+	}
+	return timeout
+}
+
+// approvalFallbackApproves reports whether approval_fallback is "approve"
+func (c *Config) approvalFallbackApproves() bool {
+	return c != nil && c.ApprovalFallback == "approve"
+}
+
+// validateApprovalCommand checks approval_command when it is set. The path
+// must be absolute, since the daemon does not run in the user's shell.
+func validateApprovalCommand(value string) error {
+	if value != "" && !filepath.IsAbs(value) {
+		return fmt.Errorf("approval_command must be an absolute path")
+	}
+	return nil
+}
+
+// parseApprovalTimeout parses approval_timeout (empty = 30s)
+func parseApprovalTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultApprovalTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid approval_timeout %q: %v", value, err)
+	}
+	if timeout < time.Second || timeout > 10*time.Minute {
+		return 0, fmt.Errorf("approval_timeout must be between 1s and 10m")
+	}
+	return timeout, nil
+}
+
+// validateApprovalFallback checks approval_fallback
+func validateApprovalFallback(value string) error {
+	if value != "" && value != "deny" && value != "approve" {
+		return fmt.Errorf("invalid approval_fallback %q (use deny or approve)", value)
+	}
+	return nil
+}
+
+// contentPreview shortens event content for the approval command and strips
+// control characters, so a dialog shows it on one line
+func contentPreview(content string) string {
+	content = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, strings.TrimSpace(content))
+	if runes := []rune(content); len(runes) > approvalPreviewLength {
+		content = string(runes[:approvalPreviewLength]) + "…"
+	}
+	return content
+}
+
+// setEventPreview fills in the kind and content preview of an event
+func (a *ApprovalContext) setEventPreview(kind *int, content string) {
+	a.Kind = kind
+	if kind != nil && approvalRedactedKinds[*kind] {
+		a.ContentRedacted = content != ""
+		return
+	}
+	a.ContentPreview = contentPreview(content)
+}
+
+// approvalContext describes req for the approval command
+func (d *Daemon) approvalContext(session *connSession, req SignRequest, traceID string) ApprovalContext {
+	npub := d.requestNpub(req)
+
+	approval := ApprovalContext{
+		TraceID:    traceID,
+		Timestamp:  time.Now().Unix(),
+		Method:     req.Method,
+		RequestID:  req.ID,
+		Npub:       npub,
+		ClientName: session.client,
+		Client:     session.identity(req),
+	}
+
+	switch req.Method {
+	case "sign_event":
+		var event struct {
+			Kind    *int              `json:"kind"`
+			Content string            `json:"content"`
+			Tags    []json.RawMessage `json:"tags"`
+		}
+		json.Unmarshal([]byte(req.EventJSON), &event)
+		approval.setEventPreview(event.Kind, event.Content)
+		approval.Tags = len(event.Tags)
+	case "sign_events":
+		approval.Items = len(req.Events)
+		for _, eventJSON := range req.Events {
+			if kind := eventKind(eventJSON); kind != nil {
+				approval.Kinds = append(approval.Kinds, *kind)
+			}
+		}
+	case "zap_request":
+		kind := zapRequestKind
+		if req.Zap != nil {
+			approval.setEventPreview(&kind, req.Zap.Comment)
+			approval.AmountMsats = req.Zap.Amount
+		} else {
+			approval.Kind = &kind
+		}
+	case "sign_relay_list":
+		kind := relayListKind
+		approval.Kind = &kind
+	case "gift_wrap":
+		var rumor struct {
+			Kind    *int   `json:"kind"`
+			Content string `json:"content"`
+		}
+		json.Unmarshal([]byte(req.EventJSON), &rumor)
+		approval.setEventPreview(rumor.Kind, rumor.Content)
+	case "send_dm":
+		kind := dmKind
+		approval.setEventPreview(&kind, req.Plaintext)
+	case "nip44_decrypt_batch":
+		approval.Items = len(req.Items)
+	}
+	return approval
+}
+
+// newTraceID returns a random id that ties an approval to its log lines
+func newTraceID() string {
+	traceBytes := make([]byte, 8)
+	rand.Read(traceBytes)
+	return hex.EncodeToString(traceBytes)
+}
+
+// approveRequest asks approval_command whether a key-using request may run.
+// It returns nil when it may, else the response that denies it. Commands
+// run one at a time, so a user never faces two dialogs at once.
+func (d *Daemon) approveRequest(session *connSession, req SignRequest) *SignResponse {
+	command := d.config.approvalCommand()
+	if command == "" || !approvalMethods[req.Method] {
+		return nil
+	}
+
+	traceID := newTraceID()
+	approval := d.approvalContext(session, req, traceID)
+
+	d.approvalMu.Lock()
+	started := time.Now()
+	approved, remember, outcome := d.runApprovalCommand(command, approval)
+	d.approvalMu.Unlock()
+
+	verdict := "denied"
+	if approved {
+		verdict = "approved"
+	}
+	logInfo("🛂 Approval %s: %s from %s %s (%s, %s)", traceID, req.Method, approval.Client, verdict,
+		outcome, time.Since(started).Round(time.Millisecond))
+
+	if approved {
+		if remember {
+			d.rememberApproved(session, req, approval.Npub)
+		}
+		return nil
+	}
+	return &SignResponse{
+		ID:    req.ID,
+		Error: fmt.Sprintf("request denied by approval_command (trace %s)", traceID),
+		Code:  codeApprovalDenied,
+	}
+}
+
+// runApprovalCommand runs command with approval on stdin and returns its
+// decision, whether to remember it, and what led to it. Exit code 0
+// approves, exit code 3 approves and remembers the approval as a
+// permission (see permissions.go), any other exit code denies. A missing command or one that doesn't finish within
+// approval_timeout gets approval_fallback. A command that anybody but the
+// user could have replaced is never run.
+func (d *Daemon) runApprovalCommand(command string, approval ApprovalContext) (bool, bool, string) {
+	fallback := d.config.approvalFallbackApproves()
+
+	if _, err := os.Stat(command); os.IsNotExist(err) {
+		return fallback, false, "approval_command not found, approval_fallback applied"
+	}
+	// The command decides over the key - same ownership rules as for hooks
+	configFile, err := getConfigFilePath()
+	if err != nil {
+		return false, false, err.Error()
+	}
+	if err := checkFileOwnedByUser(configFile); err != nil {
+		return false, false, err.Error()
+	}
+	if err := checkFileOwnedByUser(command); err != nil {
+		return false, false, err.Error()
+	}
+
+	input, err := json.Marshal(approval)
+	if err != nil {
+		return false, false, err.Error()
+	}
+
+	timeout := d.config.approvalTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command)
+	cmd.Stdin = strings.NewReader(redactSecrets("approval_command", string(input)) + "\n")
+	cmd.Env = append(os.Environ(), "NOORSIGNER_TRACE_ID="+approval.TraceID)
+	// Don't wait for children that outlive a killed command
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return fallback, false, fmt.Sprintf("timed out after %s, approval_fallback applied", timeout)
+	case err == nil:
+		return true, false, "exit code 0"
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return false, false, "exit code 1"
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 3:
+		return true, true, "exit code 3"
+	case errors.As(err, &exitErr):
+		return false, false, fmt.Sprintf("failed: %v", exitErr)
+	case errors.Is(err, os.ErrNotExist):
+		return fallback, false, "approval_command not found, approval_fallback applied"
+	default:
+		return false, false, fmt.Sprintf("cannot run approval_command: %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultAskTimeout is how long daemon --ask waits for y/N
+	defaultAskTimeout = 60 * time.Second
+
+	// askQueueSize is how many requests may wait for their prompt
+	askQueueSize = 32
+)
+
+// askMethods are the requests daemon --ask prompts for: everything that
+// signs, and the decryptions that reveal message contents
+var askMethods = map[string]bool{
+	"sign_event":          true,
+	"sign_events":         true,
+	"zap_request":         true,
+	"sign_relay_list":     true,
+	"gift_wrap":           true,
+	"unwrap":              true,
+	"send_dm":             true,
+	"decrypt_dm":          true,
+	"nip44_decrypt":       true,
+	"nip44_decrypt_batch": true,
+	"nip04_decrypt":       true,
+}
+
+// askTimeout returns ask_timeout, or the default if unset or invalid
+func (c *Config) askTimeout() time.Duration {
+	if c == nil {
+		return defaultAskTimeout
+	}
+	timeout, err := parseAskTimeout(c.AskTimeout)
+	if err != nil {
+		return defaultAskTimeout
+	}
+	return timeout
+}
+
+// parseAskTimeout parses ask_timeout (empty = 60s)
+func parseAskTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultAskTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ask_timeout %q: %v", value, err)
+	}
+	if timeout < 5*time.Second || timeout > 10*time.Minute {
+		return 0, fmt.Errorf("ask_timeout must be between 5s and 10m")
+	}
+	return timeout, nil
+}
+
+// askPrompt is one request waiting for its y/N
+type askPrompt struct {
+	approval ApprovalContext
+	deadline time.Time
+	answer   chan askAnswer // Buffered, so a late answer never blocks the prompt loop
+}
+
+// askAnswer is the decision on a prompt and how it came about. remember
+// is set when the answer was "always" (see permissions.go).
+type askAnswer struct {
+	approved bool
+	remember bool
+	outcome  string
+}
+
+// terminalAsker prompts on the daemon's terminal. Requests queue up and are
+// asked one at a time by a goroutine of its own, so connections that don't
+// need a prompt are served as usual while one is open.
+type terminalAsker struct {
+	prompts chan *askPrompt
+	lines   chan string
+	out     io.Writer
+	timeout time.Duration
+}
+
+// newTerminalAsker starts prompting on out and reading answers from in
+func newTerminalAsker(in io.Reader, out io.Writer, timeout time.Duration) *terminalAsker {
+	a := &terminalAsker{
+		prompts: make(chan *askPrompt, askQueueSize),
+		lines:   make(chan string),
+		out:     out,
+		timeout: timeout,
+	}
+	go a.readLines(in)
+	go a.promptLoop()
+	return a
+}
+
+// readLines passes every line typed on the terminal to the prompt loop.
+// At EOF the channel is closed and every further prompt is denied.
+func (a *terminalAsker) readLines(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		a.lines <- scanner.Text()
+	}
+	close(a.lines)
+}
+
+// promptLoop asks the queued prompts in order
+func (a *terminalAsker) promptLoop() {
+	for prompt := range a.prompts {
+		if !time.Now().Before(prompt.deadline) {
+			// Its requester has given up already
+			continue
+		}
+		prompt.answer <- a.prompt(prompt)
+	}
+}
+
+// prompt shows one request and waits for y/N/a until its deadline
+func (a *terminalAsker) prompt(prompt *askPrompt) askAnswer {
+	// Lines typed while nothing was asked answer nothing
+	for drained := false; !drained; {
+		select {
+		case _, ok := <-a.lines:
+			if !ok {
+				return askAnswer{outcome: "terminal closed"}
+			}
+		default:
+			drained = true
+		}
+	}
+
+	approval := prompt.approval
+	question := "Sign?"
+	if revealsPlaintext(approval.Method) {
+		question = "Decrypt?"
+	}
+	fmt.Fprintln(a.out)
+	fmt.Fprintf(a.out, "✋ %s from %s\n", approval.Method, approval.Client)
+	if approval.Npub != "" {
+		fmt.Fprintf(a.out, "   as %s\n", displayAccount(approval.Npub))
+	}
+	for _, line := range describeAskRequest(approval) {
+		fmt.Fprintf(a.out, "   %s\n", line)
+	}
+	remaining := time.Until(prompt.deadline).Round(time.Second)
+	fmt.Fprintf(a.out, "   %s [y/N/a=always] (%s) ", question, remaining)
+
+	timer := time.NewTimer(time.Until(prompt.deadline))
+	defer timer.Stop()
+	select {
+	case line, ok := <-a.lines:
+		if !ok {
+			fmt.Fprintln(a.out)
+			fmt.Fprintln(a.out, "   🚫 Terminal closed - denied")
+			return askAnswer{outcome: "terminal closed"}
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			fmt.Fprintln(a.out, "   ✅ Approved")
+			return askAnswer{approved: true, outcome: "answered"}
+		case "a", "always":
+			fmt.Fprintln(a.out, "   ✅ Approved and remembered")
+			return askAnswer{approved: true, remember: true, outcome: "answered always"}
+		}
+		fmt.Fprintln(a.out, "   🚫 Denied")
+		return askAnswer{outcome: "answered"}
+	case <-timer.C:
+		fmt.Fprintln(a.out)
+		fmt.Fprintf(a.out, "   ⏱️  No answer within %s - denied\n", remaining)
+		return askAnswer{outcome: "timed out"}
+	}
+}
+
+// describeAskRequest returns the summary lines of a prompt
+func describeAskRequest(approval ApprovalContext) []string {
+	var lines []string
+	if approval.Kind != nil {
+		line := describeKind(*approval.Kind)
+		if approval.Tags > 0 {
+			line += fmt.Sprintf(", %d tags", approval.Tags)
+		}
+		if approval.AmountMsats > 0 {
+			line += fmt.Sprintf(", %d sats", approval.AmountMsats/1000)
+		}
+		lines = append(lines, line)
+	}
+	if len(approval.Kinds) > 0 {
+		lines = append(lines, describeApproval(approval))
+	} else if approval.Items > 0 {
+		lines = append(lines, fmt.Sprintf("%d messages", approval.Items))
+	}
+	switch {
+	case approval.ContentRedacted:
+		lines = append(lines, "(content not shown for this kind)")
+	case approval.ContentPreview != "":
+		lines = append(lines, fmt.Sprintf("%q", approval.ContentPreview))
+	}
+	if revealsPlaintext(approval.Method) {
+		lines = append(lines, "reveals the plaintext to the client")
+	}
+	return lines
+}
+
+// revealsPlaintext reports whether method hands decrypted content to the
+// client
+func revealsPlaintext(method string) bool {
+	return strings.Contains(method, "decrypt") || method == "unwrap"
+}
+
+// askRequest asks on the terminal whether a request may run (daemon --ask).
+// It returns nil when it may, else the response that denies it.
+func (d *Daemon) askRequest(session *connSession, req SignRequest) *SignResponse {
+	if d.asker == nil || !askMethods[req.Method] {
+		return nil
+	}
+
+	traceID := newTraceID()
+	prompt := &askPrompt{
+		approval: d.approvalContext(session, req, traceID),
+		deadline: time.Now().Add(d.asker.timeout),
+		answer:   make(chan askAnswer, 1),
+	}
+
+	approved, remember, outcome := false, false, "queue full"
+	select {
+	case d.asker.prompts <- prompt:
+		// A little longer than the prompt, so an open prompt reports its own timeout
+		timer := time.NewTimer(time.Until(prompt.deadline) + time.Second)
+		select {
+		case answer := <-prompt.answer:
+			approved, remember, outcome = answer.approved, answer.remember, answer.outcome
+		case <-timer.C:
+			outcome = "timed out"
+		}
+		timer.Stop()
+	default:
+	}
+
+	verdict := "denied"
+	if approved {
+		verdict = "approved"
+	}
+	logInfo("🛂 Ask %s: %s from %s %s (%s)", traceID, req.Method, prompt.approval.Client, verdict, outcome)
+	if approved {
+		if remember {
+			d.rememberApproved(session, req, prompt.approval.Npub)
+		}
+		return nil
+	}
+	return &SignResponse{
+		ID:    req.ID,
+		Error: fmt.Sprintf("request denied on the daemon's terminal (trace %s, %s)", traceID, outcome),
+		Code:  codeApprovalDenied,
+	}
+}
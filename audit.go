@@ -0,0 +1,660 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxAuditEntrySize bounds one audit.log line, so every entry is a
+	// single small write
+	maxAuditEntrySize = 4096
+
+	// defaultAuditListSize is how many entries `noorsigner audit` and the
+	// audit method return
+	defaultAuditListSize = 50
+
+	// maxAuditListSize bounds the limit of the audit method
+	maxAuditListSize = 1000
+
+	// Rotation: audit.log is rotated to audit.log.1 ... audit.log.N
+	auditMaxSize  = 10 * 1024 * 1024
+	auditMaxFiles = 5
+
+	// maxAuditCounterparties bounds the senders recorded for a
+	// nip44_decrypt_batch, so the entry stays one small line
+	maxAuditCounterparties = 40
+
+	// auditChainSize is what the prev and hash fields add to an entry
+	auditChainSize = 2*64 + len(`,"prev":"","hash":""`)
+
+	// codePeerNotAllowed rejects append_audit from another user's process
+	codePeerNotAllowed = "ERR_PEER_NOT_ALLOWED"
+)
+
+// AuditEntry is one line of audit.log. Each entry is one JSON object on one
+// line, written with a single append, so a torn write can only ever damage
+// the line it belongs to. Entries are redacted like activity entries.
+type AuditEntry struct {
+	Timestamp int64 `json:"timestamp"`
+	// Source is "daemon" for requests the daemon served, "cli" for
+	// operations the CLI performed itself
+	Source string `json:"source"`
+	PID    int    `json:"pid"`
+	Action string `json:"action"`
+	Npub   string `json:"npub,omitempty"`
+	// Client is the authorized client's name (only with require_auth)
+	Client  string `json:"client,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	Kind    *int   `json:"kind,omitempty"`
+	Items   int    `json:"items,omitempty"`
+	// EventID is the id of the signed event (sign_event, sign_events,
+	// zap_request, sign_relay_list, gift_wrap)
+	EventID string `json:"event_id,omitempty"`
+	// Counterparty is the other side's hex pubkey of a NIP-04/NIP-44
+	// operation; Counterparties the distinct senders of a batch. Unlike
+	// activity entries, audit entries keep them - never the plaintext.
+	Counterparty   string   `json:"counterparty,omitempty"`
+	Counterparties []string `json:"counterparties,omitempty"`
+	// Reason says why the daemon locked (action "locked", see locklog.go)
+	Reason string `json:"reason,omitempty"`
+	// Who sent a daemon request (see clientident.go)
+	ClientIdentity
+	// Prev is the hash of the entry before, Hash the SHA-256 of this entry
+	// with Hash left empty. Editing, removing or inserting an entry breaks
+	// the chain (see verifyAuditChain). Entries from before hash chaining
+	// have neither.
+	Prev string `json:"prev,omitempty"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// AppendAuditResponse represents append_audit response
+type AppendAuditResponse struct {
+	ID      string `json:"id"`
+	Written bool   `json:"written"`
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+// AuditOutput is the audit --json document
+type AuditOutput struct {
+	Entries []AuditEntry `json:"entries"`
+	// CorruptLines are the lines that were skipped, as "file:line"
+	CorruptLines []string `json:"corrupt_lines,omitempty"`
+}
+
+// AuditResponse represents audit response
+type AuditResponse struct {
+	ID      string       `json:"id"`
+	Entries []AuditEntry `json:"entries"`
+	// Enabled tells whether the running daemon writes audit.log
+	Enabled      bool     `json:"enabled"`
+	CorruptLines []string `json:"corrupt_lines,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// getAuditLogPath returns the path to audit.log in the storage directory
+func getAuditLogPath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storageDir, "audit.log"), nil
+}
+
+// appendAuditEntry appends entry to audit.log as one line. The file is
+// opened with O_APPEND and held under an exclusive advisory lock for the
+// single write, so entries from the daemon and CLI never interleave. Under
+// the same lock the entry is chained to the one before.
+func appendAuditEntry(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if size := len(redactSecrets("audit.log", string(line))) + auditChainSize + 1; size > maxAuditEntrySize {
+		return fmt.Errorf("audit entry too large (%d bytes)", size)
+	}
+
+	path, err := getAuditLogPath()
+	if err != nil {
+		return err
+	}
+	file, unlock, err := openAuditLogLocked(path, len(line)+auditChainSize+1)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	defer unlock()
+
+	line, err = chainAuditEntry(entry, lastAuditHash(file, path))
+	if err != nil {
+		return err
+	}
+
+	// A line torn by a crash has no newline; start on a fresh line so only
+	// the torn entry is lost
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+
+	n, err := file.Write(line)
+	if err == nil && n < len(line) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write audit log: %v", err)
+	}
+	return nil
+}
+
+// chainAuditEntry returns the redacted line of entry, chained to prev. The
+// hash is taken over the redacted entry as it is read back, so that
+// verifyAuditChain can recompute it from the line.
+func chainAuditEntry(entry AuditEntry, prev string) ([]byte, error) {
+	entry.Prev, entry.Hash = prev, ""
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	var redacted AuditEntry
+	if err := json.Unmarshal([]byte(redactSecrets("audit.log", string(raw))), &redacted); err != nil {
+		return nil, err
+	}
+	redacted.Hash = auditEntryHash(redacted)
+	line, err := json.Marshal(redacted)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// auditEntryHash returns the hex SHA-256 of entry with Hash left empty
+func auditEntryHash(entry AuditEntry) string {
+	entry.Hash = ""
+	data, _ := json.Marshal(entry)
+	sum := sha256.Sum256(data)
+	return encodeHex(sum[:])
+}
+
+// lastAuditHash returns the hash of the newest entry: the last complete
+// line of the open audit.log or, right after a rotation, of audit.log.1
+// ("" if there is none or it has no hash)
+func lastAuditHash(file *os.File, path string) string {
+	if entry, ok := lastAuditEntry(file); ok {
+		return entry.Hash
+	}
+	rotated, err := os.Open(path + ".1")
+	if err != nil {
+		return ""
+	}
+	defer rotated.Close()
+	entry, _ := lastAuditEntry(rotated)
+	return entry.Hash
+}
+
+// lastAuditEntry returns the last entry of file that parses. A line torn
+// by a crash is passed over, like readAuditFile does.
+func lastAuditEntry(file *os.File) (AuditEntry, bool) {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return AuditEntry{}, false
+	}
+	size := min(info.Size(), 2*maxAuditEntrySize)
+	tail := make([]byte, size)
+	if _, err := file.ReadAt(tail, info.Size()-size); err != nil && err != io.EOF {
+		return AuditEntry{}, false
+	}
+
+	lines := bytes.Split(tail, []byte("\n"))
+	// The part after the last newline is empty or torn; the first part
+	// may be cut off by the tail
+	for i := len(lines) - 2; i >= 0; i-- {
+		var entry AuditEntry
+		if json.Unmarshal(lines[i], &entry) == nil && entry.Action != "" {
+			return entry, true
+		}
+	}
+	return AuditEntry{}, false
+}
+
+// verifyAuditChain checks the hash chain of entries (oldest first, as
+// readAuditLog returns them) and describes every break. The first entry
+// may point to one that was rotated away; entries without a hash are
+// from before hash chaining and not checked.
+func verifyAuditChain(entries []AuditEntry) []string {
+	var problems []string
+	prev := ""
+	for i, entry := range entries {
+		if entry.Hash == "" {
+			prev = ""
+			continue
+		}
+		when := time.Unix(entry.Timestamp, 0).Format("2006-01-02 15:04:05")
+		if auditEntryHash(entry) != entry.Hash {
+			problems = append(problems, fmt.Sprintf("entry %s %s was altered", when, entry.Action))
+		}
+		if i > 0 && prev != "" && entry.Prev != prev {
+			problems = append(problems, fmt.Sprintf("entries are missing or were inserted before %s %s", when, entry.Action))
+		}
+		prev = entry.Hash
+	}
+	return problems
+}
+
+// openAuditLogLocked opens audit.log for appending and takes its lock,
+// rotating it first when the next entry would make it exceed auditMaxSize.
+// A writer that waited for the lock while another one rotated holds the
+// renamed file, so it checks the path and opens the new file instead.
+func openAuditLogLocked(path string, entrySize int) (*os.File, func(), error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot open audit log: %v", err)
+		}
+		unlock, err := lockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("cannot lock audit log: %v", err)
+		}
+
+		info, statErr := file.Stat()
+		current, pathErr := os.Stat(path)
+		switch {
+		case statErr != nil || pathErr != nil || !os.SameFile(info, current):
+			// Rotated while we waited for the lock
+		case info.Size() > 0 && info.Size()+int64(entrySize) > auditMaxSize:
+			if err := rotateAuditLog(path); err != nil {
+				unlock()
+				file.Close()
+				return nil, nil, err
+			}
+		default:
+			return file, unlock, nil
+		}
+		unlock()
+		file.Close()
+	}
+	return nil, nil, fmt.Errorf("cannot open audit log: it keeps being rotated")
+}
+
+// rotateAuditLog shifts audit.log -> audit.log.1 -> ... and drops the oldest
+func rotateAuditLog(path string) error {
+	os.Remove(fmt.Sprintf("%s.%d", path, auditMaxFiles))
+	for i := auditMaxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	if err := os.Rename(path, path+".1"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot rotate audit log: %v", err)
+	}
+	return nil
+}
+
+// auditPubkey normalizes a counterparty pubkey (hex or npub) to lowercase
+// hex, or returns "" for anything else
+func auditPubkey(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if isHexID(value) {
+		return value
+	}
+	if strings.HasPrefix(value, "npub1") {
+		if pubkey, err := npubToPubkey(value); err == nil {
+			return pubkey
+		}
+	}
+	return ""
+}
+
+// auditEnabled reports whether audit_log is on
+func (c *Config) auditEnabled() bool {
+	return c != nil && c.AuditLog
+}
+
+// auditActivity writes an activity entry to audit.log if audit_log is on.
+// The audit entry adds what the activity feed leaves out: the counterparty
+// of NIP-04/NIP-44 operations, and one entry per event of a sign_events
+// batch.
+func (d *Daemon) auditActivity(entry ActivityEntry, req SignRequest, result activityResult) {
+	if !d.config.auditEnabled() {
+		return
+	}
+
+	audit := AuditEntry{
+		Timestamp: entry.Timestamp,
+		Source:    "daemon",
+		PID:       os.Getpid(),
+		Action:    entry.Method,
+		Npub:      entry.Npub,
+		Client:    entry.Client,
+		Success:   entry.Success,
+		Error:     entry.Error,
+		Kind:      entry.Kind,
+		Items:     entry.Items,
+		EventID:   entry.EventID,
+
+		ClientIdentity: entry.ClientIdentity,
+	}
+	entries := []AuditEntry{audit}
+
+	switch req.Method {
+	case "nip44_encrypt", "nip04_encrypt", "gift_wrap", "send_dm":
+		entries[0].Counterparty = auditPubkey(req.RecipientPubkey)
+	case "unwrap", "decrypt_dm":
+		entries[0].Counterparty = auditPubkey(result.Sender)
+	case "nip44_decrypt", "nip04_decrypt":
+		entries[0].Counterparty = auditPubkey(req.SenderPubkey)
+	case "nip44_decrypt_batch":
+		seen := make(map[string]bool)
+		for _, item := range req.Items {
+			sender := auditPubkey(item.SenderPubkey)
+			if sender != "" && !seen[sender] && len(seen) < maxAuditCounterparties {
+				seen[sender] = true
+				entries[0].Counterparties = append(entries[0].Counterparties, sender)
+			}
+		}
+	case "sign_events":
+		if len(result.Results) == len(req.Events) {
+			entries = entries[:0]
+			for i, signed := range result.Results {
+				item := audit
+				item.Kind = eventKind(req.Events[i])
+				item.EventID = signed.ID
+				item.Success = signed.Error == ""
+				item.Error = redactActivityError(signed.Error)
+				entries = append(entries, item)
+			}
+		}
+	}
+
+	for _, audit := range entries {
+		if err := appendAuditEntry(audit); err != nil {
+			logError("⚠️  %v", err)
+			return
+		}
+	}
+}
+
+// auditEntries answers audit: the newest entries of audit.log and its
+// rotated files, optionally only those since a Unix time
+func (d *Daemon) auditEntries(req SignRequest) AuditResponse {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultAuditListSize
+	}
+	if limit > maxAuditListSize {
+		limit = maxAuditListSize
+	}
+
+	entries, corrupt, err := readAuditLog()
+	if err != nil {
+		return AuditResponse{ID: req.ID, Error: redactedError(err)}
+	}
+	entries = filterAuditEntries(entries, req.Since, limit)
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	return AuditResponse{
+		ID:           req.ID,
+		Entries:      entries,
+		Enabled:      d.config.auditEnabled(),
+		CorruptLines: corrupt,
+	}
+}
+
+// filterAuditEntries keeps the entries from since on (0 = all) and of those
+// the newest limit
+func filterAuditEntries(entries []AuditEntry, since int64, limit int) []AuditEntry {
+	if since > 0 {
+		first := len(entries)
+		for i, entry := range entries {
+			if entry.Timestamp >= since {
+				first = i
+				break
+			}
+		}
+		entries = entries[first:]
+	}
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries
+}
+
+// appendAuditFromClient answers append_audit: the CLI hands its entries to
+// the running daemon, which stays the only writer. Only processes of the
+// daemon's own user may add entries.
+func (d *Daemon) appendAuditFromClient(conn net.Conn, req SignRequest) AppendAuditResponse {
+	// A replayed request (see policy.go) has no connection to vouch for it,
+	// and peerIsSameUser must not be the only guard against that
+	sameUser, err := false, errors.New("no client connection")
+	if conn != nil {
+		sameUser, err = peerIsSameUser(conn)
+	}
+	if err != nil || !sameUser {
+		reason := "peer is another user"
+		if err != nil {
+			reason = err.Error()
+		}
+		return AppendAuditResponse{
+			ID:    req.ID,
+			Error: "append_audit is only accepted from processes of the daemon's user: " + reason,
+			Code:  codePeerNotAllowed,
+		}
+	}
+	if !d.config.auditEnabled() {
+		return AppendAuditResponse{ID: req.ID, Error: "audit_log is off in the running daemon"}
+	}
+	var entry AuditEntry
+	if len(req.Audit) == 0 || json.Unmarshal(req.Audit, &entry) != nil || entry.Action == "" {
+		return AppendAuditResponse{ID: req.ID, Error: "audit entry with action required"}
+	}
+
+	entry.Source = "cli" // Clients can't pose as the daemon
+	if entry.Timestamp == 0 {
+		entry.Timestamp = time.Now().Unix()
+	}
+	entry.Error = redactActivityError(entry.Error)
+
+	if err := appendAuditEntry(entry); err != nil {
+		return AppendAuditResponse{ID: req.ID, Error: redactedError(err)}
+	}
+	return AppendAuditResponse{ID: req.ID, Written: true}
+}
+
+// auditCLI records an operation the CLI performed itself. With a daemon
+// running the entry goes through append_audit; otherwise the CLI appends it
+// under the file lock.
+func auditCLI(action, npub string, opErr error, kind *int) {
+	if !appConfig.auditEnabled() {
+		return
+	}
+
+	entry := AuditEntry{
+		Timestamp: time.Now().Unix(),
+		Source:    "cli",
+		PID:       os.Getpid(),
+		Action:    action,
+		Npub:      npub,
+		Success:   opErr == nil,
+		Kind:      kind,
+	}
+	if opErr != nil {
+		entry.Error = redactActivityError(opErr.Error())
+	}
+
+	if appendAuditViaDaemon(entry) == nil {
+		return
+	}
+	if err := appendAuditEntry(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  %v\n", err)
+	}
+}
+
+// readAuditLog reads the rotated files and audit.log, oldest first. Lines
+// that don't parse (e.g. torn by a crash mid-write) are skipped and
+// reported as "file:line".
+func readAuditLog() ([]AuditEntry, []string, error) {
+	path, err := getAuditLogPath()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entries []AuditEntry
+	var corrupt []string
+	for i := auditMaxFiles; i >= 0; i-- {
+		filePath := path
+		if i > 0 {
+			filePath = fmt.Sprintf("%s.%d", path, i)
+		}
+		fileEntries, fileCorrupt, err := readAuditFile(filePath)
+		entries = append(entries, fileEntries...)
+		for _, line := range fileCorrupt {
+			corrupt = append(corrupt, fmt.Sprintf("%s:%d", filepath.Base(filePath), line))
+		}
+		if err != nil {
+			return entries, corrupt, err
+		}
+	}
+	return entries, corrupt, nil
+}
+
+// readAuditFile reads one audit log file (nothing if it doesn't exist)
+func readAuditFile(path string) ([]AuditEntry, []int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	var corrupt []int
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var entry AuditEntry
+			// A line without its newline was cut off mid-write
+			if !bytes.HasSuffix(line, []byte("\n")) || json.Unmarshal(line, &entry) != nil || entry.Action == "" {
+				corrupt = append(corrupt, lineNumber)
+			} else {
+				entries = append(entries, entry)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, corrupt, fmt.Errorf("cannot read audit log: %v", err)
+		}
+	}
+	return entries, corrupt, nil
+}
+
+// auditCmd prints the newest audit.log entries
+func auditCmd(args []string) error {
+	limit := defaultAuditListSize
+	var since int64
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--limit" && i+1 < len(args):
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n <= 0 {
+				return commandFailed(1, "Invalid --limit %q", args[i])
+			}
+			limit = n
+		case args[i] == "--since" && i+1 < len(args):
+			i++
+			t, err := parseAuditSince(args[i], time.Now())
+			if err != nil {
+				return commandFailed(1, "❌ %v", err)
+			}
+			since = t.Unix()
+		default:
+			return commandFailed(1, "Usage: noorsigner audit [--since <duration|date>] [--limit <n>]")
+		}
+	}
+
+	entries, corrupt, err := readAuditLog()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	entries = filterAuditEntries(entries, since, limit)
+
+	if jsonOutput {
+		if entries == nil {
+			entries = []AuditEntry{}
+		}
+		printJSON(AuditOutput{Entries: entries, CorruptLines: corrupt})
+		return nil
+	}
+
+	for _, line := range corrupt {
+		fmt.Printf("⚠️  %s is corrupt - skipped\n", line)
+	}
+	if len(entries) == 0 {
+		if !appConfig.auditEnabled() {
+			fmt.Println("No audit entries. The audit log is off - turn it on with: noorsigner config set audit_log true")
+		} else {
+			fmt.Println("No audit entries yet.")
+		}
+		return nil
+	}
+
+	for _, entry := range entries {
+		outcome := "✅"
+		if !entry.Success {
+			outcome = "❌ " + entry.Error
+		}
+		detail := ""
+		if entry.Kind != nil {
+			detail = " " + describeKind(*entry.Kind)
+		}
+		if entry.Items > 0 {
+			detail += fmt.Sprintf(" (%d items)", entry.Items)
+		}
+		if counterparty, err := pubkeyToNpub(entry.Counterparty); entry.Counterparty != "" && err == nil {
+			detail += " with " + displayNpub(counterparty)
+		}
+		if entry.Reason != "" {
+			detail += " (" + entry.Reason + ")"
+		}
+		npub := "-"
+		if entry.Npub != "" {
+			npub = displayNpub(entry.Npub)
+		}
+		fmt.Printf("%s  %-6s %-36s %-18s %s\n",
+			time.Unix(entry.Timestamp, 0).Format("2006-01-02 15:04:05"),
+			entry.Source, entry.Action+detail, npub, outcome)
+	}
+	return nil
+}
+
+// parseAuditSince accepts a duration back from now ("1h") or a point in time
+// (see parseLocalTime)
+func parseAuditSince(value string, now time.Time) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+		return now.Add(-duration), nil
+	}
+	if t, ok := parseLocalTime(value); ok {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: use a duration (1h, 168h) or a date (2025-08-01)", value)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+// append_audit is taken only from a connection of the daemon's own user:
+// not from a replayed request, which has none, and not from a connection
+// whose peer can't be vouched for
+func TestAppendAuditPeers(t *testing.T) {
+	testHome(t)
+	appConfig.AuditLog = true
+	npub := addTestAccount(t, "")
+	d := testDaemon(t, npub)
+	serveTestDaemon(t, d)
+	entry := func(action string) SignRequest {
+		audit, _ := json.Marshal(AuditEntry{Action: action})
+		return SignRequest{ID: action, Method: "append_audit", Audit: audit}
+	}
+
+	refused := map[string]net.Conn{"no connection": nil}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	refused["in-memory connection"] = server
+	for name, conn := range refused {
+		response := d.appendAuditFromClient(conn, entry(name))
+		if response.Written || response.Code != codePeerNotAllowed {
+			t.Errorf("%s: %+v, want %s", name, response, codePeerNotAllowed)
+		}
+	}
+	if name := "no connection"; !strings.Contains(d.appendAuditFromClient(nil, entry(name)).Error, "no client connection") {
+		t.Errorf("%s: the refusal does not say why", name)
+	}
+
+	var response AppendAuditResponse
+	if err := dialTestDaemon(t).request(t, entry("socket"), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Written || response.Error != "" {
+		t.Fatalf("append_audit over the socket: %+v", response)
+	}
+
+	entries, _, err := readAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, written := range entries {
+		if _, sent := refused[written.Action]; sent || written.Action == "socket" {
+			actions = append(actions, written.Action)
+		}
+	}
+	if len(actions) != 1 || actions[0] != "socket" {
+		t.Errorf("entries written %v, want only the one sent over the socket", actions)
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 // getAutostartStatus checks if autostart is currently enabled
@@ -14,23 +17,84 @@ func getAutostartStatus() (bool, error) {
 		return getAutostartStatusMac()
 	case "linux":
 		return getAutostartStatusLinux()
+	case "windows":
+		return getAutostartStatusWindows()
 	default:
 		return false, fmt.Errorf("unsupported platform: %s", runtime.GOOS)
 	}
 }
 
-// enableAutostart installs autostart for the current platform
-func enableAutostart() error {
+// errAutostartNotManaged means enabling would overwrite an entry noorsigner didn't write
+var errAutostartNotManaged = errors.New("existing autostart entry was not created by noorsigner - use --force to overwrite it")
+
+// autostartMarker tags entries written by noorsigner
+const autostartMarker = "Generated by noorsigner"
+
+// AutostartPlan describes what enableAutostart would write
+type AutostartPlan struct {
+	Location string
+	Existing string // Current entry ("" if none)
+	Content  string // Entry enableAutostart would write
+	Managed  bool   // No entry yet, or the existing one was written by noorsigner
+}
+
+// planAutostart computes the autostart entry for the current platform
+// without writing anything
+func planAutostart() (*AutostartPlan, error) {
 	switch runtime.GOOS {
 	case "darwin":
-		return enableAutostartMac()
+		return planAutostartMac()
 	case "linux":
-		return enableAutostartLinux()
+		return planAutostartLinux()
+	case "windows":
+		return planAutostartWindows()
 	default:
-		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+		return nil, fmt.Errorf("unsupported platform: %s", runtime.GOOS)
 	}
 }
 
+// enableAutostart installs autostart for the current platform. It refuses to
+// replace an entry noorsigner didn't write unless force is set, and reports
+// whether an existing entry was replaced.
+func enableAutostart(force bool) (bool, error) {
+	plan, err := planAutostart()
+	if err != nil {
+		return false, err
+	}
+
+	if !plan.Managed && !force {
+		return false, errAutostartNotManaged
+	}
+
+	if runtime.GOOS == "windows" {
+		err = writeAutostartWindows(plan.Content)
+	} else {
+		err = writeAutostartFile(plan.Location, plan.Content)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return plan.Existing != "", nil
+}
+
+// writeAutostartFile writes a plist or desktop entry, creating its directory
+func writeAutostartFile(path, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(content), 0644)
+}
+
+// readAutostartFile returns the current entry ("" if there is none)
+func readAutostartFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(content), err
+}
+
 // disableAutostart removes autostart for the current platform
 func disableAutostart() error {
 	switch runtime.GOOS {
@@ -38,12 +102,80 @@ func disableAutostart() error {
 		return disableAutostartMac()
 	case "linux":
 		return disableAutostartLinux()
+	case "windows":
+		return disableAutostartWindows()
 	default:
 		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
 	}
 }
 
+// getAutostartLocation returns where the autostart entry lives (file path or registry key)
+func getAutostartLocation() (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return getAutostartLocationMac()
+	case "linux":
+		return getAutostartLocationLinux()
+	case "windows":
+		return getAutostartLocationWindows()
+	default:
+		return "", fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
+// getAutostartExecutable returns the executable the installed entry launches
+// ("" if no entry is installed)
+func getAutostartExecutable() (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return getAutostartExecutableMac()
+	case "linux":
+		return getAutostartExecutableLinux()
+	case "windows":
+		return getAutostartExecutableWindows()
+	default:
+		return "", fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
 // macOS: LaunchAgent plist
+func getAutostartLocationMac() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", "com.noorsigner.daemon.plist"), nil
+}
+
+func getAutostartExecutableMac() (string, error) {
+	plistPath, err := getAutostartLocationMac()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(plistPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// First <string> after ProgramArguments is the executable
+	plist := string(content)
+	argsIndex := strings.Index(plist, "<key>ProgramArguments</key>")
+	if argsIndex < 0 {
+		return "", fmt.Errorf("no ProgramArguments in %s", plistPath)
+	}
+	rest := plist[argsIndex:]
+	start := strings.Index(rest, "<string>")
+	end := strings.Index(rest, "</string>")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("no executable in %s", plistPath)
+	}
+	return strings.TrimSpace(rest[start+len("<string>") : end]), nil
+}
+
 func getAutostartStatusMac() (bool, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -57,28 +189,65 @@ func getAutostartStatusMac() (bool, error) {
 	return err == nil, err
 }
 
-func enableAutostartMac() error {
+// Keys noorsigner owns in the LaunchAgent; everything else in an existing
+// plist (log paths, KeepAlive, ...) is left as the user set it
+var managedPlistKeys = map[string]bool{
+	"Label":            true,
+	"ProgramArguments": true,
+	"RunAtLoad":        true,
+}
+
+func planAutostartMac() (*AutostartPlan, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Get path to current executable
 	exePath, err := os.Executable()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	plistDir := filepath.Join(home, "Library", "LaunchAgents")
-	plistPath := filepath.Join(plistDir, "com.noorsigner.daemon.plist")
+	plistPath, err := getAutostartLocationMac()
+	if err != nil {
+		return nil, err
+	}
 
-	// Ensure LaunchAgents directory exists
-	if err := os.MkdirAll(plistDir, 0755); err != nil {
-		return err
+	existing, err := readAutostartFile(plistPath)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create plist content
-	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+	generated := []plistEntry{
+		{"Label", "<string>com.noorsigner.daemon</string>"},
+		{"ProgramArguments", fmt.Sprintf("<array>\n        <string>%s</string>\n%s        <string>daemon</string>\n        <string>--foreground</string>\n    </array>", xmlEscape(exePath), plistHomeArgs())},
+		{"RunAtLoad", "<true/>"},
+		{"KeepAlive", "<false/>"},
+		{"StandardOutPath", fmt.Sprintf("<string>%s</string>", xmlEscape(filepath.Join(home, "Library", "Logs", "noorsigner-stdout.log")))},
+		{"StandardErrorPath", fmt.Sprintf("<string>%s</string>", xmlEscape(filepath.Join(home, "Library", "Logs", "noorsigner-stderr.log")))},
+	}
+
+	plan := &AutostartPlan{
+		Location: plistPath,
+		Existing: existing,
+		Managed:  existing == "" || strings.Contains(existing, autostartMarker) || isLegacyPlist(existing, home),
+	}
+
+	// Merge into an existing plist so user-managed keys survive
+	entries := generated
+	if existing != "" {
+		if existingEntries, err := parsePlistDict(existing); err == nil {
+			entries = mergePlistEntries(existingEntries, generated, managedPlistKeys)
+		}
+	}
+
+	plan.Content = renderPlist(autostartMarker+" - Label, ProgramArguments and RunAtLoad are managed, other keys are preserved", entries)
+	return plan, nil
+}
+
+// legacyPlistTemplate is the plist written before entries carried a marker
+const legacyPlistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
 <plist version="1.0">
 <dict>
@@ -88,7 +257,7 @@ func enableAutostartMac() error {
     <array>
         <string>%s</string>
         <string>daemon</string>
-    </array>
+%s    </array>
     <key>RunAtLoad</key>
     <true/>
     <key>KeepAlive</key>
@@ -98,10 +267,40 @@ func enableAutostartMac() error {
     <key>StandardErrorPath</key>
     <string>%s/Library/Logs/noorsigner-stderr.log</string>
 </dict>
-</plist>`, exePath, home, home)
+</plist>`
+
+// isLegacyPlist reports whether content is an unmodified plist from an older
+// noorsigner that predates the marker
+func isLegacyPlist(content, home string) bool {
+	entries, err := parsePlistDict(content)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range entries {
+		if entry.Key != "ProgramArguments" {
+			continue
+		}
+		start := strings.Index(entry.Raw, "<string>")
+		end := strings.Index(entry.Raw, "</string>")
+		if start < 0 || end < start {
+			return false
+		}
+		exePath := entry.Raw[start+len("<string>") : end]
+		for _, extra := range []string{"", "        <string>--foreground</string>\n"} {
+			if content == fmt.Sprintf(legacyPlistTemplate, exePath, extra, home, home) {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	// Write plist file
-	return os.WriteFile(plistPath, []byte(plist), 0644)
+// xmlEscape escapes text for use inside an XML element
+func xmlEscape(text string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
 }
 
 func disableAutostartMac() error {
@@ -120,6 +319,42 @@ func disableAutostartMac() error {
 }
 
 // Linux: XDG autostart
+func getAutostartLocationLinux() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "autostart", "noorsigner.desktop"), nil
+}
+
+func getAutostartExecutableLinux() (string, error) {
+	desktopPath, err := getAutostartLocationLinux()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(desktopPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "Exec=") {
+			command := strings.TrimPrefix(line, "Exec=")
+			if end := strings.Index(command, " --home "); end >= 0 {
+				command = command[:end]
+			} else if end := strings.LastIndex(command, " daemon"); end >= 0 {
+				command = command[:end]
+			}
+			return strings.Trim(strings.TrimSpace(command), `"`), nil
+		}
+	}
+	return "", fmt.Errorf("no Exec line in %s", desktopPath)
+}
+
 func getAutostartStatusLinux() (bool, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -134,38 +369,62 @@ func getAutostartStatusLinux() (bool, error) {
 	return err == nil, err
 }
 
-func enableAutostartLinux() error {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return err
-	}
-
+func planAutostartLinux() (*AutostartPlan, error) {
 	// Get path to current executable
 	exePath, err := os.Executable()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	autostartDir := filepath.Join(home, ".config", "autostart")
-	desktopPath := filepath.Join(autostartDir, "noorsigner.desktop")
+	desktopPath, err := getAutostartLocationLinux()
+	if err != nil {
+		return nil, err
+	}
 
-	// Ensure autostart directory exists
-	if err := os.MkdirAll(autostartDir, 0755); err != nil {
-		return err
+	existing, err := readAutostartFile(desktopPath)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create desktop entry
-	desktop := fmt.Sprintf(`[Desktop Entry]
+	desktop := fmt.Sprintf(`# %s - rewritten by 'noorsigner autostart enable'
+[Desktop Entry]
 Type=Application
 Name=NoorSigner Daemon
 Comment=Nostr Key Signing Daemon
-Exec=%s daemon
+Exec=%s%s daemon --foreground
 Terminal=false
 Hidden=false
-X-GNOME-Autostart-enabled=true`, exePath)
+X-GNOME-Autostart-enabled=true
+`, autostartMarker, exePath, desktopHomeArgs())
 
-	// Write desktop file
-	return os.WriteFile(desktopPath, []byte(desktop), 0644)
+	return &AutostartPlan{
+		Location: desktopPath,
+		Existing: existing,
+		Content:  desktop,
+		Managed:  existing == "" || strings.Contains(existing, autostartMarker) || isLegacyDesktopEntry(existing),
+	}, nil
+}
+
+// legacyDesktopTemplate is the desktop entry written before entries carried a marker
+const legacyDesktopTemplate = `[Desktop Entry]
+Type=Application
+Name=NoorSigner Daemon
+Comment=Nostr Key Signing Daemon
+Exec=%s
+Terminal=false
+Hidden=false
+X-GNOME-Autostart-enabled=true`
+
+// isLegacyDesktopEntry reports whether content is an unmodified desktop entry
+// from an older noorsigner that predates the marker
+func isLegacyDesktopEntry(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "Exec=") {
+			return content == fmt.Sprintf(legacyDesktopTemplate, strings.TrimPrefix(line, "Exec="))
+		}
+	}
+	return false
 }
 
 func disableAutostartLinux() error {
@@ -182,3 +441,201 @@ func disableAutostartLinux() error {
 	}
 	return err
 }
+
+// autostartCmd manages autostart from the CLI, without needing a running daemon
+func autostartCmd(args []string) error {
+	usage := "Usage: noorsigner autostart enable [--dry-run] [--force] | disable | status"
+	if len(args) == 0 {
+		return commandFailed(1, "%s", usage)
+	}
+
+	dryRun, force := false, false
+	for _, arg := range args[1:] {
+		switch {
+		case args[0] == "enable" && arg == "--dry-run":
+			dryRun = true
+		case args[0] == "enable" && arg == "--force":
+			force = true
+		default:
+			return commandFailed(1, "Unknown option: %s\n%s", arg, usage)
+		}
+	}
+
+	location, err := getAutostartLocation()
+	if err != nil {
+		return commandFailed(1, "Error: %v", err)
+	}
+
+	switch args[0] {
+	case "enable":
+		if dryRun {
+			return autostartDryRunCmd()
+		}
+
+		replaced, err := enableAutostart(force)
+		if err != nil {
+			fmt.Printf("❌ Failed to enable autostart: %v\n", err)
+			if errors.Is(err, errAutostartNotManaged) {
+				fmt.Println("   Preview the change with: noorsigner autostart enable --dry-run")
+			}
+			return &commandError{Code: 1}
+		}
+		fmt.Println("✅ Autostart enabled")
+		if replaced {
+			fmt.Printf("   Updated: %s\n", location)
+		} else {
+			fmt.Printf("   Created: %s\n", location)
+		}
+
+	case "disable":
+		if err := disableAutostart(); err != nil {
+			return commandFailed(1, "❌ Failed to disable autostart: %v", err)
+		}
+		fmt.Println("✅ Autostart disabled")
+		fmt.Printf("   Removed: %s\n", location)
+
+	case "status":
+		return autostartStatusCmd(location)
+
+	default:
+		return commandFailed(1, "Unknown autostart action: %s\n%s", args[0], usage)
+	}
+	return nil
+}
+
+// autostartDryRunCmd prints the entry enable would write and a diff
+// against the current one
+func autostartDryRunCmd() error {
+	plan, err := planAutostart()
+	if err != nil {
+		return commandFailed(1, "Error: %v", err)
+	}
+
+	fmt.Printf("Would write: %s\n", plan.Location)
+	fmt.Println()
+
+	if plan.Existing == "" {
+		fmt.Println(strings.TrimSuffix(plan.Content, "\n"))
+		return nil
+	}
+
+	diff := unifiedDiff(plan.Location+" (current)", plan.Location+" (new)", plan.Existing, plan.Content)
+	if diff == "" {
+		fmt.Println("No changes - the installed entry is up to date.")
+	} else {
+		fmt.Print(diff)
+	}
+
+	if !plan.Managed {
+		fmt.Println()
+		fmt.Println("⚠️  The existing entry was not created by noorsigner - enable will refuse without --force")
+	}
+	return nil
+}
+
+// autostartStatusCmd prints the autostart state and offers to repair an
+// entry that launches a different binary than the one running now
+func autostartStatusCmd(location string) error {
+	enabled, err := getAutostartStatus()
+	if err != nil {
+		return commandFailed(1, "Error: %v", err)
+	}
+
+	installedExe, err := getAutostartExecutable()
+	if err != nil {
+		fmt.Printf("⚠️  Cannot read autostart entry: %v\n", err)
+	}
+
+	if installedExe == "" {
+		fmt.Println("Autostart: disabled")
+		return nil
+	}
+
+	if enabled {
+		fmt.Println("Autostart: enabled")
+	} else {
+		fmt.Println("Autostart: installed but inactive")
+	}
+	fmt.Printf("   Entry:      %s\n", location)
+	fmt.Printf("   Executable: %s\n", installedExe)
+
+	currentExe, err := os.Executable()
+	if err != nil || sameExecutable(installedExe, currentExe) {
+		return nil
+	}
+
+	fmt.Println()
+	fmt.Println("⚠️  Autostart launches a different executable than this one:")
+	fmt.Printf("   %s\n", currentExe)
+	answer, err := readInput("Rewrite the entry to use this executable? [y/N]: ")
+	if err != nil || strings.ToLower(answer) != "y" {
+		return nil
+	}
+
+	if _, err := enableAutostart(false); err != nil {
+		return commandFailed(1, "❌ Failed to rewrite autostart entry: %v", err)
+	}
+	fmt.Printf("✅ Autostart entry updated: %s\n", location)
+	return nil
+}
+
+// sameExecutable compares two executable paths after resolving symlinks
+func sameExecutable(a, b string) bool {
+	if resolved, err := filepath.EvalSymlinks(a); err == nil {
+		a = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(b); err == nil {
+		b = resolved
+	}
+	return filepath.Clean(a) == filepath.Clean(b)
+}
+
+// applyAutostartPreference makes the autostart entry match the "autostart"
+// setting in config.json. Unset (nil) leaves the entry alone.
+func applyAutostartPreference(enabled *bool) {
+	if enabled == nil {
+		return
+	}
+
+	current, err := getAutostartStatus()
+	if err != nil {
+		logError("⚠️  Cannot check autostart: %v", err)
+		return
+	}
+
+	switch {
+	case *enabled && !current:
+		if _, err := enableAutostart(false); err != nil {
+			logError("⚠️  Cannot enable autostart (config autostart=true): %v", err)
+			return
+		}
+		logInfo("🚀 Autostart enabled (config autostart=true)")
+	case !*enabled && current:
+		if err := disableAutostart(); err != nil {
+			logError("⚠️  Cannot disable autostart (config autostart=false): %v", err)
+			return
+		}
+		logInfo("🚀 Autostart disabled (config autostart=false)")
+	}
+}
+
+// plistHomeArgs returns ProgramArguments entries passing a relocated
+// storage directory (NOORSIGNER_HOME) on to the autostarted daemon
+func plistHomeArgs() string {
+	storageDir := storageDirOverride()
+	if storageDir == "" {
+		return ""
+	}
+	return fmt.Sprintf("        <string>--home</string>\n        <string>%s</string>\n", xmlEscape(storageDir))
+}
+
+// desktopHomeArgs returns the Exec= arguments passing a relocated storage
+// directory on, quoted per the Desktop Entry spec
+func desktopHomeArgs() string {
+	storageDir := storageDirOverride()
+	if storageDir == "" {
+		return ""
+	}
+	quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", "$", `\$`).Replace(storageDir)
+	return fmt.Sprintf(` --home "%s"`, quoted)
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// Windows autostart is only available in Windows builds
+
+func getAutostartStatusWindows() (bool, error) {
+	return false, fmt.Errorf("windows autostart not available on this platform")
+}
+
+func planAutostartWindows() (*AutostartPlan, error) {
+	return nil, fmt.Errorf("windows autostart not available on this platform")
+}
+
+func writeAutostartWindows(command string) error {
+	return fmt.Errorf("windows autostart not available on this platform")
+}
+
+func disableAutostartWindows() error {
+	return fmt.Errorf("windows autostart not available on this platform")
+}
+
+func getAutostartLocationWindows() (string, error) {
+	return "", fmt.Errorf("windows autostart not available on this platform")
+}
+
+func getAutostartExecutableWindows() (string, error) {
+	return "", fmt.Errorf("windows autostart not available on this platform")
+}
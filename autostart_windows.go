@@ -0,0 +1,144 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// Windows: HKCU Run registry key
+const (
+	windowsRunKeyPath   = `Software\Microsoft\Windows\CurrentVersion\Run`
+	windowsRunValueName = "NoorSigner"
+)
+
+func getAutostartLocationWindows() (string, error) {
+	return `HKCU\` + windowsRunKeyPath + `\` + windowsRunValueName, nil
+}
+
+// readRunValueWindows returns the current Run entry ("" if there is none)
+func readRunValueWindows() (string, error) {
+	key, err := registry.OpenKey(registry.CURRENT_USER, windowsRunKeyPath, registry.QUERY_VALUE)
+	if err != nil {
+		if err == registry.ErrNotExist {
+			return "", nil
+		}
+		return "", err
+	}
+	defer key.Close()
+
+	command, _, err := key.GetStringValue(windowsRunValueName)
+	if err == registry.ErrNotExist {
+		return "", nil
+	}
+	return command, err
+}
+
+func getAutostartExecutableWindows() (string, error) {
+	command, err := readRunValueWindows()
+	if err != nil || command == "" {
+		return "", err
+	}
+
+	return parseWindowsRunCommand(command), nil
+}
+
+func getAutostartStatusWindows() (bool, error) {
+	command, err := readRunValueWindows()
+	if err != nil || command == "" {
+		return false, err
+	}
+
+	// An entry pointing at a moved or deleted binary won't start anything
+	exePath := parseWindowsRunCommand(command)
+	if _, err := os.Stat(exePath); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+func planAutostartWindows() (*AutostartPlan, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := getAutostartLocationWindows()
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := readRunValueWindows()
+	if err != nil {
+		return nil, err
+	}
+
+	// A registry value can't carry a marker - treat entries launching a
+	// noorsigner binary as ours
+	existingExe := strings.ToLower(filepath.Base(parseWindowsRunCommand(existing)))
+
+	return &AutostartPlan{
+		Location: location,
+		Existing: existing,
+		// Quote the path so spaces (e.g. "Program Files") survive
+		Content: fmt.Sprintf(`"%s"%s daemon`, exePath, windowsHomeArgs()),
+		Managed: existing == "" || strings.HasPrefix(existingExe, "noorsigner"),
+	}, nil
+}
+
+func writeAutostartWindows(command string) error {
+	key, _, err := registry.CreateKey(registry.CURRENT_USER, windowsRunKeyPath, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	return key.SetStringValue(windowsRunValueName, command)
+}
+
+func disableAutostartWindows() error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, windowsRunKeyPath, registry.SET_VALUE)
+	if err != nil {
+		if err == registry.ErrNotExist {
+			return nil
+		}
+		return err
+	}
+	defer key.Close()
+
+	// Remove value (ignore if doesn't exist)
+	err = key.DeleteValue(windowsRunValueName)
+	if err == registry.ErrNotExist {
+		return nil
+	}
+	return err
+}
+
+// parseWindowsRunCommand extracts the executable path from a Run entry
+func parseWindowsRunCommand(command string) string {
+	command = strings.TrimSpace(command)
+	if strings.HasPrefix(command, `"`) {
+		if end := strings.Index(command[1:], `"`); end >= 0 {
+			return command[1 : end+1]
+		}
+	}
+	if fields := strings.Fields(command); len(fields) > 0 {
+		return fields[0]
+	}
+	return command
+}
+
+// windowsHomeArgs returns the Run command arguments passing a relocated
+// storage directory (NOORSIGNER_HOME) on to the autostarted daemon
+func windowsHomeArgs() string {
+	storageDir := storageDirOverride()
+	if storageDir == "" {
+		return ""
+	}
+	return fmt.Sprintf(` --home "%s"`, storageDir)
+}
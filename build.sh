@@ -4,25 +4,38 @@ set -e
 VERSION="0.1.0"
 OUT_DIR="./bin"
 
+# Reproducible build flags (see README "Reproducible Builds")
+export CGO_ENABLED=0
+BUILD_FLAGS="-trimpath"
+LDFLAGS="-s -w -buildid="
+
 echo "Building NoorSigner v${VERSION}..."
 
+# Serialization and crypto must agree with go-nostr before anything ships
+echo "Running interoperability self-test..."
+go run . selftest
+
 mkdir -p "$OUT_DIR"
 
 # macOS (ARM64)
 echo "Building macOS ARM64..."
-GOOS=darwin GOARCH=arm64 go build -o "$OUT_DIR/noorsigner-macos-arm64" -ldflags="-s -w" .
+GOOS=darwin GOARCH=arm64 go build $BUILD_FLAGS -o "$OUT_DIR/noorsigner-macos-arm64" -ldflags="$LDFLAGS" .
 
 # macOS (AMD64)
 echo "Building macOS AMD64..."
-GOOS=darwin GOARCH=amd64 go build -o "$OUT_DIR/noorsigner-macos-amd64" -ldflags="-s -w" .
+GOOS=darwin GOARCH=amd64 go build $BUILD_FLAGS -o "$OUT_DIR/noorsigner-macos-amd64" -ldflags="$LDFLAGS" .
 
 # Linux (AMD64)
 echo "Building Linux AMD64..."
-GOOS=linux GOARCH=amd64 go build -o "$OUT_DIR/noorsigner-linux-amd64" -ldflags="-s -w" .
+GOOS=linux GOARCH=amd64 go build $BUILD_FLAGS -o "$OUT_DIR/noorsigner-linux-amd64" -ldflags="$LDFLAGS" .
+
+# Windows (AMD64)
+echo "Building Windows AMD64..."
+GOOS=windows GOARCH=amd64 go build $BUILD_FLAGS -o "$OUT_DIR/noorsigner-windows-amd64.exe" -ldflags="$LDFLAGS" .
 
 # Linux (ARM64)
 echo "Building Linux ARM64..."
-GOOS=linux GOARCH=arm64 go build -o "$OUT_DIR/noorsigner-linux-arm64" -ldflags="-s -w" .
+GOOS=linux GOARCH=arm64 go build $BUILD_FLAGS -o "$OUT_DIR/noorsigner-linux-arm64" -ldflags="$LDFLAGS" .
 
 echo "Build complete! Binaries in $OUT_DIR"
 ls -lh "$OUT_DIR"
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// bunkerSecretTTL is how long a bunker:// URI can be used to connect
+const bunkerSecretTTL = 10 * time.Minute
+
+// BunkerResponse answers nip46_bunker
+type BunkerResponse struct {
+	ID string `json:"id"`
+	// URI is the bunker:// connection string for the app. Its secret
+	// connects one client, until ExpiresAt.
+	URI       string   `json:"uri,omitempty"`
+	Npub      string   `json:"npub,omitempty"`
+	Relays    []string `json:"relays,omitempty"`
+	ExpiresAt int64    `json:"expires_at,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Code      string   `json:"code,omitempty"`
+}
+
+// bunkerOffer is a bunker:// URI that was handed out and not used yet
+type bunkerOffer struct {
+	secret    string
+	name      string   // Grant name; "" derives one from the client pubkey
+	perms     []string // Grant perms; nil takes those the client asks for, if any
+	expiresAt time.Time
+}
+
+// parseBunkerRelays splits and checks bunker_relays
+func parseBunkerRelays(value string) ([]string, error) {
+	var relays []string
+	for _, relay := range strings.Split(value, ",") {
+		relay = strings.TrimSpace(relay)
+		if relay == "" {
+			continue
+		}
+		if err := checkZapRelay(relay); err != nil {
+			return nil, fmt.Errorf("invalid bunker relay %q: expected a wss:// or ws:// URL", relay)
+		}
+		relays = append(relays, relay)
+	}
+	return relays, nil
+}
+
+// bunkerRelays returns the configured bunker relays
+func (c *Config) bunkerRelays() []string {
+	if c == nil {
+		return nil
+	}
+	relays, _ := parseBunkerRelays(c.BunkerRelays)
+	return relays
+}
+
+// bunkerURI builds the connection string apps paste in:
+// bunker://<pubkey>?relay=<url>&secret=<secret>
+func bunkerURI(pubkey string, relays []string, secret string) string {
+	query := url.Values{"relay": relays, "secret": {secret}}
+	return "bunker://" + pubkey + "?" + query.Encode()
+}
+
+// nip46Bunker answers nip46_bunker: it makes a one-time secret for the
+// active account and listens on the relays for a connect request carrying
+// it. The client that sends it gets a grant, as if accepted with
+// 'noorsigner connect'.
+func (d *Daemon) nip46Bunker(req SignRequest) BunkerResponse {
+	relays := req.Relays
+	if len(relays) == 0 {
+		relays = d.config.bunkerRelays()
+	}
+	if len(relays) == 0 {
+		return BunkerResponse{ID: req.ID, Error: "no relays given and bunker_relays is not set", Code: codeInvalidRequest}
+	}
+	for _, relay := range relays {
+		if err := checkZapRelay(relay); err != nil {
+			return BunkerResponse{ID: req.ID, Error: fmt.Sprintf("invalid relay %q: expected a wss:// or ws:// URL", relay), Code: codeInvalidRequest}
+		}
+	}
+	for _, perm := range req.Perms {
+		if method, _, _ := strings.Cut(perm, ":"); !nip46Methods[method] {
+			return BunkerResponse{ID: req.ID, Error: fmt.Sprintf("unsupported permission %q", perm), Code: codeInvalidRequest}
+		}
+	}
+	if req.AppName != "" && !clientNamePattern.MatchString(req.AppName) {
+		return BunkerResponse{ID: req.ID, Error: "name may only contain letters, digits, '.', '_' and '-' (at most 64)", Code: codeInvalidRequest}
+	}
+
+	d.mu.RLock()
+	npub, pubkey, locked := d.npub, d.pubkey, d.privateKey == nil
+	d.mu.RUnlock()
+	if locked {
+		return BunkerResponse{ID: req.ID, Error: redactedError(errDaemonLocked), Code: errorCode(errDaemonLocked)}
+	}
+
+	secretBytes := make([]byte, 16)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return BunkerResponse{ID: req.ID, Error: fmt.Sprintf("cannot generate secret: %v", err), Code: codeInternal}
+	}
+	offer := bunkerOffer{
+		secret:    hex.EncodeToString(secretBytes),
+		name:      req.AppName,
+		perms:     req.Perms,
+		expiresAt: time.Now().Add(bunkerSecretTTL),
+	}
+
+	d.nip46Mu.Lock()
+	if d.bunker != nil && (d.bunker.grant.Npub != npub || !slices.Equal(d.bunker.grant.Relays, relays)) {
+		// One listener at a time: URIs for another account or relays stop working
+		d.bunker.cancel()
+		d.bunker, d.bunkerOffers = nil, nil
+		logInfo("🔌 NIP-46 bunker moved - earlier bunker URIs no longer work")
+	}
+	if d.bunker == nil {
+		ctx, s := d.newNIP46Session(Grant{Name: "bunker", Npub: npub, Relays: relays}, pubkey)
+		for _, relayURL := range relays {
+			go d.runNIP46Relay(ctx, s, relayURL)
+		}
+		d.bunker = s
+		logInfo("🔌 NIP-46 bunker listening for %s on %s (conn %d)", displayNpub(npub), strings.Join(relays, ", "), s.session.id)
+	}
+	d.bunkerOffers = append(d.bunkerOffers, offer)
+	d.nip46Mu.Unlock()
+	time.AfterFunc(bunkerSecretTTL, d.stopIdleBunker)
+
+	return BunkerResponse{
+		ID:        req.ID,
+		URI:       bunkerURI(pubkey, relays, offer.secret),
+		Npub:      npub,
+		Relays:    relays,
+		ExpiresAt: offer.expiresAt.Unix(),
+	}
+}
+
+// pruneBunkerOffersLocked drops expired offers. Caller must hold d.nip46Mu.
+func (d *Daemon) pruneBunkerOffersLocked(now time.Time) {
+	d.bunkerOffers = slices.DeleteFunc(d.bunkerOffers, func(offer bunkerOffer) bool {
+		return !now.Before(offer.expiresAt)
+	})
+}
+
+// takeBunkerOffer removes and returns the unexpired offer with secret
+func (d *Daemon) takeBunkerOffer(secret string) (bunkerOffer, bool) {
+	d.nip46Mu.Lock()
+	defer d.nip46Mu.Unlock()
+
+	d.pruneBunkerOffersLocked(time.Now())
+	for i, offer := range d.bunkerOffers {
+		if subtle.ConstantTimeCompare([]byte(offer.secret), []byte(secret)) == 1 {
+			d.bunkerOffers = slices.Delete(d.bunkerOffers, i, i+1)
+			return offer, true
+		}
+	}
+	return bunkerOffer{}, false
+}
+
+// stopIdleBunker disconnects the bunker listener once no unused URI is left.
+// Connected clients keep their own sessions.
+func (d *Daemon) stopIdleBunker() {
+	d.nip46Mu.Lock()
+	defer d.nip46Mu.Unlock()
+
+	d.pruneBunkerOffersLocked(time.Now())
+	if d.bunker == nil || len(d.bunkerOffers) > 0 {
+		return
+	}
+	d.bunker.cancel()
+	d.bunker = nil
+	logInfo("🔌 NIP-46 bunker stopped listening: no unused bunker URI left")
+}
+
+// handleBunkerEvent serves a request to the bunker listener. Only connect
+// with a secret handed out by nip46_bunker is answered; clients that have a
+// grant are served by their own session.
+func (d *Daemon) handleBunkerEvent(s *nip46Session, event *nostr.Event) {
+	if event.Kind != nip46Kind || !hexPubkeyPattern.MatchString(event.PubKey) {
+		return
+	}
+	if ok, _ := event.CheckSignature(); !ok {
+		logDebug("NIP-46 bunker: event %s has a bad signature - ignored", event.ID)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.session.ids.use(event.ID) {
+		return
+	}
+	defer d.recoverNIP46(s)
+
+	grants, err := loadGrants()
+	if err != nil {
+		logError("⚠️  %v", err)
+		return
+	}
+	if _, ok := findGrant(grants, event.PubKey); ok {
+		return
+	}
+
+	d.mu.RLock()
+	serving := d.npub == s.grant.Npub && d.privateKey != nil
+	d.mu.RUnlock()
+	if !serving {
+		logInfo("🔌 NIP-46 bunker request ignored: %s is not the unlocked account", displayNpub(s.grant.Npub))
+		return
+	}
+
+	plaintext, nip04, err := d.nip46Decrypt(event.Content, event.PubKey)
+	if err != nil {
+		logDebug("NIP-46 bunker: cannot decrypt request from %s: %v", event.PubKey[:8], err)
+		return
+	}
+	var request nip46Request
+	if err := json.Unmarshal([]byte(plaintext), &request); err != nil || request.ID == "" || request.Method != "connect" {
+		logDebug("NIP-46 bunker: ignored a request from %s that is not connect", event.PubKey[:8])
+		return
+	}
+
+	response := d.acceptBunkerClient(s, event.PubKey, request)
+	if _, err := d.publishNIP46Response(s, event.PubKey, response, nip04); err != nil {
+		logError("⚠️  NIP-46 bunker: cannot answer connect: %v", err)
+	}
+	if response.Error == "" {
+		// Its session serves the client from now on
+		d.stopIdleBunker()
+	}
+}
+
+// acceptBunkerClient checks a connect request's secret and, if it belongs
+// to an unused bunker URI, stores a grant for the client and starts serving
+// it. params are the signer's pubkey, the secret and optionally the
+// permissions the client asks for.
+func (d *Daemon) acceptBunkerClient(s *nip46Session, clientPubkey string, request nip46Request) nip46Response {
+	response := nip46Response{ID: request.ID}
+	params := request.Params
+	if len(params) < 2 || params[0] != s.pubkey {
+		response.Error = "connect needs the signer's pubkey and the secret of the bunker URI"
+		return response
+	}
+
+	offer, ok := d.takeBunkerOffer(params[1])
+	if !ok {
+		logInfo("🚫 NIP-46 bunker: connect from %s with an unknown, used or expired secret", clientPubkey[:8])
+		response.Error = "unknown, used or expired secret"
+		return response
+	}
+
+	perms := offer.perms
+	if perms == nil && len(params) > 2 && params[2] != "" {
+		perms = strings.Split(params[2], ",")
+	}
+	name := offer.name
+	if name == "" {
+		name = "bunker-" + clientPubkey[:8]
+	}
+
+	grant, err := storeGrant(Grant{
+		Name:         name,
+		ClientPubkey: clientPubkey,
+		Npub:         s.grant.Npub,
+		Relays:       s.grant.Relays,
+		Perms:        perms,
+		CreatedAt:    time.Now().Unix(),
+	})
+	if err != nil {
+		logError("⚠️  NIP-46 bunker: cannot store the grant: %v", err)
+		response.Error = "cannot store the connection"
+		return response
+	}
+	// Relays don't keep NIP-46 events: the client's first request must
+	// find its session subscribed
+	if session := d.startNIP46Session(grant); session != nil {
+		timer := time.NewTimer(nip46ConnectTimeout)
+		defer timer.Stop()
+		select {
+		case <-session.connected:
+		case <-timer.C:
+		}
+	}
+
+	logInfo("🔌 NIP-46 client %q connected for %s with a bunker URI", grant.Name, displayNpub(grant.Npub))
+	response.Result = "ack"
+	return response
+}
+
+// nip46BunkerViaDaemon asks the daemon for a bunker:// URI
+func nip46BunkerViaDaemon(relays, perms []string, name string) (*BunkerResponse, error) {
+	request := SignRequest{
+		Method:  "nip46_bunker",
+		Relays:  relays,
+		Perms:   perms,
+		AppName: name,
+	}
+	var response BunkerResponse
+	if err := daemonRequest(context.Background(), request, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// bunkerCmd prints a bunker:// URI for the active account. The app it is
+// pasted into connects through the daemon, which then serves it like a
+// client accepted with 'noorsigner connect'.
+func bunkerCmd(args []string) error {
+	usage := "Usage: noorsigner bunker [--relay <wss://...>]... [--perms <perm,...>] [--name <name>]"
+	var relays, perms []string
+	var name string
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return commandFailed(1, "%s", usage)
+		}
+		switch args[i] {
+		case "--relay":
+			relays = append(relays, args[i+1])
+		case "--perms":
+			perms = strings.Split(args[i+1], ",")
+		case "--name":
+			name = args[i+1]
+		default:
+			return commandFailed(1, "%s", usage)
+		}
+		i++
+	}
+	if !isDaemonRunning() {
+		return commandFailed(1, "❌ Daemon not running - start it first with 'noorsigner daemon'")
+	}
+
+	response, err := nip46BunkerViaDaemon(relays, perms, name)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	fmt.Printf("🔌 Bunker URI for %s:\n", displayNpub(response.Npub))
+	fmt.Println()
+	fmt.Println(response.URI)
+	fmt.Println()
+	fmt.Printf("   Paste it into the app. It connects one app, until %s.\n", time.Unix(response.ExpiresAt, 0).Format("15:04"))
+	fmt.Println("   Anyone with the URI can connect as that app - don't share it.")
+	if len(perms) == 0 {
+		fmt.Println("   The app gets the permissions it asks for when connecting. If it asks for none,")
+		fmt.Println("   it can only read your public key: pass --perms to let it sign or encrypt.")
+	} else {
+		fmt.Println("   The app may:")
+		for _, perm := range perms {
+			fmt.Printf("   - %s\n", describePerm(perm))
+		}
+	}
+	fmt.Println("   Signing policy, approval_command and --ask still apply to each request.")
+	fmt.Println("   See it with 'noorsigner grant list', revoke it with 'noorsigner grant revoke <name>'.")
+	return nil
+}
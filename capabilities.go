@@ -0,0 +1,25 @@
+package main
+
+// CapabilitiesResponse represents get_capabilities response: what this
+// daemon offers and how it is protected, for clients deciding how to use it
+type CapabilitiesResponse struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	// ProtocolVersion is the newest IPC protocol version (see protocol.go)
+	ProtocolVersion int              `json:"protocol_version"`
+	Security        *SecuritySummary `json:"security"`
+	// Kinds describes well-known event kinds, so GUIs can word their
+	// prompts like the daemon does
+	Kinds []KindDescription `json:"kinds"`
+}
+
+// capabilities answers get_capabilities
+func (d *Daemon) capabilities(id string) CapabilitiesResponse {
+	return CapabilitiesResponse{
+		ID:              id,
+		Version:         version,
+		ProtocolVersion: protocolVersion,
+		Security:        d.securitySummary(),
+		Kinds:           kindTable(),
+	}
+}
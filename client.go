@@ -1,49 +1,69 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
+
+	"github.com/77elements/noorsigner/pkg/client"
 )
 
+// daemonClient returns a client for the daemon the CLI is configured for
+// (socket_path, --home), sending the CLI's token and name
+func daemonClient() *client.Client {
+	c := client.New(func(context.Context) (net.Conn, error) {
+		return dialConnection()
+	})
+	c.Token = clientToken()
+	c.AppName, c.AppVersion = cliAppName, version
+	return c
+}
+
+// daemonError turns a rejection that means the same for every method into
+// the CLI's error for it: ERR_DRAINING into *DaemonDrainingError and
+// ERR_UNAUTHORIZED into errDaemonUnauthorized. Other errors pass unchanged.
+func daemonError(err error) error {
+	var daemonErr *client.Error
+	if !errors.As(err, &daemonErr) {
+		return err
+	}
+	switch daemonErr.Code {
+	case codeDraining:
+		return &DaemonDrainingError{RetryAfter: daemonErr.RetryAfter}
+	case codeUnauthorized:
+		return fmt.Errorf("%w: %s - set %s to a token from 'noorsigner authorize'",
+			errDaemonUnauthorized, strings.TrimPrefix(daemonErr.Message, "unauthorized: "), clientTokenEnv)
+	}
+	return err
+}
+
+// daemonRequest sends one request to the daemon and decodes the response
+// into v. An error response fails with *client.Error, or see daemonError.
+func daemonRequest(ctx context.Context, request SignRequest, v any) error {
+	return daemonError(daemonClient().Do(ctx, request, v))
+}
+
 // signEventViaSocket sends signing request to daemon via IPC
 func signEventViaSocket(eventJSON string) (string, error) {
-	// Connect to daemon (Unix socket or Windows Named Pipe)
-	conn, err := dialConnection()
+	event, err := daemonClient().SignEvent(context.Background(), eventJSON)
 	if err != nil {
-		return "", fmt.Errorf("failed to connect to daemon: %v\nIs the daemon running? Try: noorsigner daemon", err)
-	}
-	defer conn.Close()
-	
-	// Create signing request
-	request := SignRequest{
-		ID:        "test-001",
-		Method:    "sign_event",
-		EventJSON: eventJSON,
-	}
-	
-	// Send request
-	encoder := json.NewEncoder(conn)
-	if err := encoder.Encode(request); err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
-	}
-	
-	// Read response
-	decoder := json.NewDecoder(conn)
-	var response SignResponse
-	if err := decoder.Decode(&response); err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
+		var daemonErr *client.Error
+		switch err = daemonError(err); {
+		case errors.Is(err, client.ErrNotRunning):
+			return "", fmt.Errorf("%v\nIs the daemon running? Try: noorsigner daemon", err)
+		case errors.As(err, &daemonErr):
+			return "", fmt.Errorf("daemon error: %w", err)
+		}
+		return "", err
 	}
-	
-	// Check for errors
-	if response.Error != "" {
-		return "", fmt.Errorf("daemon error: %s", response.Error)
-	}
-	
-	return response.Signature, nil
+	return event.Sig, nil
 }
 
 // testDaemonSigning tests signing via daemon
-func testDaemonSigning() {
+func testDaemonSigning() error {
 	fmt.Println("🔗 Testing daemon signing...")
 
 	// Create test event JSON
@@ -52,56 +72,151 @@ func testDaemonSigning() {
 	// Sign via daemon
 	signature, err := signEventViaSocket(testEventJSON)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return commandFailed(1, "Error: %v", err)
+	}
+
+	if jsonOutput {
+		printJSON(TestDaemonOutput{Signature: signature})
+		return nil
 	}
 
 	fmt.Printf("✅ Daemon signature: %s\n", signature)
 	fmt.Println("Daemon signing working correctly!")
+	return nil
 }
 
-// isDaemonRunning checks if daemon is running by trying to connect
+// isDaemonRunning checks that a daemon answers a ping within pingTimeout
+// (see ping.go). One that accepts the connection but doesn't answer counts
+// as down.
 func isDaemonRunning() bool {
-	conn, err := dialConnection()
-	if err != nil {
-		return false
-	}
-	conn.Close()
-	return true
+	_, err := pingDaemon()
+	return !errors.Is(err, errDaemonUnreachable)
 }
 
-// switchAccountViaDaemon tells the running daemon to switch accounts
+// switchAccountViaDaemon tells the running daemon to switch accounts. An
+// empty password lets the daemon use the account's trust session; without
+// one the error is errPasswordRequired.
 func switchAccountViaDaemon(npub, password string) error {
-	conn, err := dialConnection()
-	if err != nil {
-		return fmt.Errorf("daemon not running: %v", err)
+	_, err := daemonClient().SwitchAccount(context.Background(), npub, password)
+	var daemonErr *client.Error
+	if errors.As(err, &daemonErr) && daemonErr.Code == codePasswordRequired {
+		return errPasswordRequired
 	}
-	defer conn.Close()
+	return daemonError(err)
+}
+
+// lockViaDaemon asks the daemon to drop its key and trust session. reason
+// is sent along for the lock history (see locklog.go).
+func lockViaDaemon(reason string) error {
+	return daemonRequest(context.Background(), SignRequest{Method: "lock", Reason: reason}, nil)
+}
+
+// listPendingCredentialsViaDaemon fetches credential requests from the daemon
+func listPendingCredentialsViaDaemon() ([]PendingCredential, error) {
+	var response PendingCredentialsResponse
+	if err := daemonRequest(context.Background(), SignRequest{Method: "pending_credentials"}, &response); err != nil {
+		return nil, err
+	}
+	return response.Requests, nil
+}
 
-	// Create switch request
+// respondCredentialViaDaemon answers a pending credential request with a
+// password, or with a security key's secret if fido is set
+func respondCredentialViaDaemon(nonce, password string, fido bool) error {
 	request := SignRequest{
-		ID:       "switch-001",
-		Method:   "switch_account",
-		Npub:     npub,
+		Method:   "respond_credential",
+		Nonce:    nonce,
 		Password: password,
+		Fido:     fido,
 	}
+	return daemonRequest(context.Background(), request, nil)
+}
 
-	// Send request
-	encoder := json.NewEncoder(conn)
-	if err := encoder.Encode(request); err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+// listPendingApprovalsViaDaemon lists requests waiting for confirmation
+func listPendingApprovalsViaDaemon() ([]PendingApproval, error) {
+	var response PendingApprovalsResponse
+	if err := daemonRequest(context.Background(), SignRequest{Method: "pending_approvals"}, &response); err != nil {
+		return nil, err
 	}
+	return response.Requests, nil
+}
 
-	// Read response
-	decoder := json.NewDecoder(conn)
-	var response AccountActionResponse
-	if err := decoder.Decode(&response); err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
+// respondApprovalViaDaemon confirms or denies a waiting request
+func respondApprovalViaDaemon(id string, approve, remember bool) error {
+	request := SignRequest{
+		Method:   "respond_approval",
+		Nonce:    id,
+		Approve:  approve,
+		Remember: remember,
 	}
+	return daemonRequest(context.Background(), request, nil)
+}
 
-	if response.Error != "" {
-		return fmt.Errorf("%s", response.Error)
+// getPolicyViaDaemon returns the running daemon's signing policy
+func getPolicyViaDaemon() (*PolicyResponse, error) {
+	return policyRequestViaDaemon(SignRequest{Method: "get_policy"})
+}
+
+// setPolicyViaDaemon changes the signing policy of the running daemon and
+// in config.json
+func setPolicyViaDaemon(update PolicyUpdate) (*PolicyResponse, error) {
+	return policyRequestViaDaemon(SignRequest{Method: "set_policy", Policy: &update})
+}
+
+// policyRequestViaDaemon sends get_policy or set_policy
+func policyRequestViaDaemon(request SignRequest) (*PolicyResponse, error) {
+	var response PolicyResponse
+	if err := daemonRequest(context.Background(), request, &response); err != nil {
+		return nil, err
 	}
+	return &response, nil
+}
 
-	return nil
-}
\ No newline at end of file
+// decryptBatchViaDaemon decrypts NIP-44 payloads with nip44_decrypt_batch
+func decryptBatchViaDaemon(items []DecryptBatchItem) ([]DecryptBatchResult, error) {
+	var response DecryptBatchResponse
+	if err := daemonRequest(context.Background(), SignRequest{Method: "nip44_decrypt_batch", Items: items}, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Results) != len(items) {
+		return nil, fmt.Errorf("daemon returned %d results for %d items", len(response.Results), len(items))
+	}
+	return response.Results, nil
+}
+
+// getStatusViaDaemon fetches status and effective config from the daemon
+func getStatusViaDaemon() (*StatusResponse, error) {
+	var response StatusResponse
+	if err := daemonRequest(context.Background(), SignRequest{Method: "get_status"}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// drainViaDaemon puts the daemon into drain mode
+func drainViaDaemon(timeout string) (*DrainResponse, error) {
+	var response DrainResponse
+	if err := daemonRequest(context.Background(), SignRequest{Method: "drain", Timeout: timeout}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// appendAuditViaDaemon hands an audit entry to the running daemon, which
+// writes it to audit.log
+func appendAuditViaDaemon(entry AuditEntry) error {
+	audit, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return daemonRequest(context.Background(), SignRequest{Method: "append_audit", Audit: audit}, nil)
+}
+
+// nip46ConnectViaDaemon has the daemon accept a nostrconnect:// URI
+func nip46ConnectViaDaemon(uri string) (*GrantResponse, error) {
+	var response GrantResponse
+	if err := daemonRequest(context.Background(), SignRequest{Method: "nip46_connect", ConnectURI: uri}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
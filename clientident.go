@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// recentRequestsSize is how many requests get_status lists
+	recentRequestsSize = 50
+
+	// maxAppFieldLength truncates app_name and app_version
+	maxAppFieldLength = 64
+
+	// cliAppName identifies the noorsigner CLI's own requests
+	cliAppName = "noorsigner-cli"
+)
+
+// ClientIdentity tells which program sent a request. App and AppVersion are
+// what the client says about itself and are not verified. PeerPID and Exe
+// come from the kernel (SO_PEERCRED, /proc/<pid>/exe) where available, and
+// identify clients that don't send app_name.
+type ClientIdentity struct {
+	App        string `json:"app,omitempty"`
+	AppVersion string `json:"app_version,omitempty"`
+	PeerPID    int    `json:"peer_pid,omitempty"`
+	Exe        string `json:"exe,omitempty"`
+}
+
+// String names the client for logs and the status listing
+func (c ClientIdentity) String() string {
+	var name string
+	switch {
+	case c.App != "" && c.AppVersion != "":
+		name = c.App + " " + c.AppVersion
+	case c.App != "":
+		name = c.App
+	case c.Exe != "":
+		name = filepath.Base(c.Exe)
+	default:
+		name = "unknown client"
+	}
+	if c.PeerPID != 0 {
+		name += fmt.Sprintf(" (pid %d)", c.PeerPID)
+	}
+	return name
+}
+
+// cleanAppField strips control characters from a client-supplied name and
+// truncates it, so it can't forge log lines or bloat the buffers
+func cleanAppField(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(value))
+	if len(value) > maxAppFieldLength {
+		value = strings.ToValidUTF8(value[:maxAppFieldLength], "")
+	}
+	return value
+}
+
+// peerExecutable resolves a peer PID to its executable. Only Linux exposes
+// this without cgo; elsewhere the PID alone is recorded.
+func peerExecutable(pid int) string {
+	if pid <= 0 || runtime.GOOS != "linux" {
+		return ""
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return "" // Process gone already, or not ours to inspect
+	}
+	return exe
+}
+
+// identity returns who sent req on this session
+func (s *connSession) identity(req SignRequest) ClientIdentity {
+	return ClientIdentity{
+		App:        cleanAppField(req.AppName),
+		AppVersion: cleanAppField(req.AppVersion),
+		PeerPID:    s.peer.PID,
+		Exe:        s.exe,
+	}
+}
+
+// RecentRequest is one entry of recent_requests in get_status. Unlike the
+// activity feed it covers every method, but only says who asked for what.
+type RecentRequest struct {
+	Timestamp int64  `json:"timestamp"`
+	Method    string `json:"method"`
+	ConnID    uint64 `json:"conn_id"`
+	ClientIdentity
+}
+
+// requestHistory is a bounded buffer of the most recent requests
+type requestHistory struct {
+	mu      sync.Mutex
+	entries []RecentRequest // Oldest first, at most recentRequestsSize
+}
+
+// add records a request, dropping the oldest when full
+func (h *requestHistory) add(entry RecentRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) >= recentRequestsSize {
+		copy(h.entries, h.entries[1:])
+		h.entries = h.entries[:len(h.entries)-1]
+	}
+	h.entries = append(h.entries, entry)
+}
+
+// snapshot returns the buffered requests, oldest first
+func (h *requestHistory) snapshot() []RecentRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := make([]RecentRequest, len(h.entries))
+	copy(entries, h.entries)
+	return entries
+}
+
+// recordRequest adds a request to the history and logs who sent it
+func (d *Daemon) recordRequest(session *connSession, req SignRequest) {
+	identity := session.identity(req)
+	// Only ID and method - request bodies may carry passwords, keys or plaintexts
+	logDebug("conn %d: request id=%q method=%q from %s", session.id, req.ID, req.Method, identity)
+	d.requests.add(RecentRequest{
+		Timestamp:      time.Now().Unix(),
+		Method:         req.Method,
+		ConnID:         session.id,
+		ClientIdentity: identity,
+	})
+}
+
+// printRecentRequests prints the newest requests for status
+func printRecentRequests(requests []RecentRequest, limit int) {
+	if len(requests) == 0 {
+		return
+	}
+	if len(requests) > limit {
+		requests = requests[len(requests)-limit:]
+	}
+	fmt.Println()
+	fmt.Println("Recent requests:")
+	for _, request := range requests {
+		fmt.Printf("  %s  %-22s %s\n", time.Unix(request.Timestamp, 0).Format("15:04:05"), request.Method, request.ClientIdentity)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// clientTokenPrefix marks noorsigner client tokens, so they are easy to
+	// recognize in config files and secret scanners
+	clientTokenPrefix = "nst_"
+
+	// clientTokenEnv is where the CLI finds its own token when require_auth is on
+	clientTokenEnv = "NOORSIGNER_TOKEN"
+
+	codeUnauthorized = "ERR_UNAUTHORIZED"
+)
+
+// errDaemonUnauthorized is returned by the client helpers when the daemon
+// rejects the CLI's token (or its lack of one)
+var errDaemonUnauthorized = errors.New("unauthorized")
+
+// authExemptMethods answer without a client token, so clients can find out
+// what the daemon speaks and that it wants a token
+var authExemptMethods = map[string]bool{
+	"get_protocol_version": true,
+	"get_version":          true,
+	"get_capabilities":     true,
+}
+
+// clientNamePattern keeps client names short and printable
+var clientNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// AuthorizedClient is one entry of clients.json. Only the token's SHA-256 is
+// stored; the token itself is shown once by 'noorsigner authorize'.
+type AuthorizedClient struct {
+	Name      string `json:"name"`
+	TokenHash string `json:"token_hash"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// clientsFile is the layout of ~/.noorsigner/clients.json
+type clientsFile struct {
+	Clients []AuthorizedClient `json:"clients"`
+}
+
+// getClientsPath returns the path of clients.json
+func getClientsPath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storageDir, "clients.json"), nil
+}
+
+// loadClients reads clients.json (a missing file means no clients)
+func loadClients() ([]AuthorizedClient, error) {
+	path, err := getClientsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read clients.json: %v", err)
+	}
+
+	var file clientsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid clients.json: %v", err)
+	}
+	return file.Clients, nil
+}
+
+// saveClients writes clients.json (mode 0600)
+func saveClients(clients []AuthorizedClient) error {
+	path, err := getClientsPath()
+	if err != nil {
+		return err
+	}
+	if clients == nil {
+		clients = []AuthorizedClient{}
+	}
+
+	data, err := json.MarshalIndent(clientsFile{Clients: clients}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0600)
+}
+
+// hashClientToken returns the hex SHA-256 of a token. Tokens are 256 random
+// bits, so a plain hash is enough - there is nothing to brute-force.
+func hashClientToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateClientToken returns a new random client token
+func generateClientToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", fmt.Errorf("cannot generate token: %v", err)
+	}
+	return clientTokenPrefix + hex.EncodeToString(tokenBytes), nil
+}
+
+// findClientByToken returns the client a token belongs to
+func findClientByToken(clients []AuthorizedClient, token string) (AuthorizedClient, bool) {
+	tokenHash := []byte(hashClientToken(token))
+	var found AuthorizedClient
+	matched := false
+	// Compare against every entry in constant time
+	for _, client := range clients {
+		if subtle.ConstantTimeCompare(tokenHash, []byte(client.TokenHash)) == 1 {
+			found = client
+			matched = true
+		}
+	}
+	return found, matched
+}
+
+// authRequired reports whether require_auth is on
+func (c *Config) authRequired() bool {
+	return c != nil && c.RequireAuth
+}
+
+// authorizeRequest checks a request's client token when require_auth is on
+// and records the client's name in the session. It returns the error
+// response for a request without a valid token. clients.json is read for
+// every request, so 'noorsigner clients revoke' takes effect without a
+// restart.
+func (d *Daemon) authorizeRequest(session *connSession, req SignRequest) *SignResponse {
+	session.client = ""
+	if !d.config.authRequired() || authExemptMethods[req.Method] {
+		return nil
+	}
+
+	unauthorized := &SignResponse{
+		ID:    req.ID,
+		Error: "unauthorized: client token required",
+		Code:  codeUnauthorized,
+	}
+	if req.Token == "" {
+		return unauthorized
+	}
+
+	clients, err := loadClients()
+	if err != nil {
+		logError("⚠️  %v", err)
+		return unauthorized
+	}
+	client, ok := findClientByToken(clients, req.Token)
+	if !ok {
+		logInfo("🚫 conn %d: %s from %s with an unknown client token %v", session.id, req.Method, session.identity(req), secret(req.Token))
+		unauthorized.Error = "unauthorized: unknown or revoked client token"
+		return unauthorized
+	}
+
+	session.client = client.Name
+	return nil
+}
+
+// authorizeCmd creates a client and prints its token. The token is the only
+// thing on stdout, so it can be captured:
+//
+//	export NOORSIGNER_TOKEN=$(noorsigner authorize cli)
+func authorizeCmd(args []string) error {
+	if len(args) != 1 {
+		return commandFailed(1, "Usage: noorsigner authorize <name>")
+	}
+	name := args[0]
+	if !clientNamePattern.MatchString(name) {
+		return commandFailed(1, "❌ Invalid client name %q: use up to 64 letters, digits, '.', '_' or '-'", name)
+	}
+
+	redirectChatter()
+
+	clients, err := loadClients()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	for _, client := range clients {
+		if client.Name == name {
+			return commandFailed(1, "❌ Client %q already exists - revoke it first with 'noorsigner clients revoke %s'", name, name)
+		}
+	}
+
+	token, err := generateClientToken()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	clients = append(clients, AuthorizedClient{
+		Name:      name,
+		TokenHash: hashClientToken(token),
+		CreatedAt: time.Now().Unix(),
+	})
+	if err := saveClients(clients); err != nil {
+		return commandFailed(1, "❌ Error saving clients.json: %v", err)
+	}
+
+	fmt.Printf("✅ Client %q authorized. Its token is shown only this once:\n", name)
+	fmt.Fprintln(jsonStdout, token)
+	if !appConfig.authRequired() {
+		fmt.Println("ℹ️  require_auth is off - enable it with 'noorsigner config set require_auth true' and restart the daemon")
+	}
+	return nil
+}
+
+// clientsCmd lists or revokes authorized clients
+func clientsCmd(args []string) error {
+	usage := "Usage: noorsigner clients list|revoke <name>"
+	if len(args) == 0 {
+		return commandFailed(1, "%s", usage)
+	}
+
+	clients, err := loadClients()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		if len(clients) == 0 {
+			fmt.Println("No authorized clients. Use 'noorsigner authorize <name>' to add one.")
+			return nil
+		}
+		fmt.Printf("Authorized clients (%d):\n", len(clients))
+		for _, client := range clients {
+			fmt.Printf("  %-24s created %s\n", client.Name, time.Unix(client.CreatedAt, 0).Format("2006-01-02 15:04"))
+		}
+		if !appConfig.authRequired() {
+			fmt.Println()
+			fmt.Println("ℹ️  require_auth is off - tokens are not checked")
+		}
+
+	case args[0] == "revoke" && len(args) == 2:
+		name := args[1]
+		kept := clients[:0]
+		for _, client := range clients {
+			if client.Name != name {
+				kept = append(kept, client)
+			}
+		}
+		if len(kept) == len(clients) {
+			return commandFailed(1, "❌ No client named %q", name)
+		}
+		if err := saveClients(kept); err != nil {
+			return commandFailed(1, "❌ Error saving clients.json: %v", err)
+		}
+		fmt.Printf("✅ Client %q revoked. Its token is rejected from the next request on.\n", name)
+
+	default:
+		return commandFailed(1, "%s", usage)
+	}
+	return nil
+}
+
+// clientToken returns the CLI's own token from $NOORSIGNER_TOKEN
+func clientToken() string {
+	return strings.TrimSpace(os.Getenv(clientTokenEnv))
+}
@@ -0,0 +1,1146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTrustDuration is how long a Trust Mode session lasts unless
+// config.json sets trust_duration
+const defaultTrustDuration = 24 * time.Hour
+
+// defaultPermissionDuration is how long a remembered permission lasts unless
+// config.json sets permission_duration
+const defaultPermissionDuration = 30 * 24 * time.Hour
+
+// Config holds user settings from ~/.noorsigner/config.json
+type Config struct {
+	// Hooks maps lifecycle event names to executables (see hooks.go)
+	Hooks map[string]string `json:"hooks,omitempty"`
+
+	// KindPolicy maps event kinds (and "default") to allow, deny or confirm
+	// (see policy.go)
+	KindPolicy map[string]string `json:"kind_policy,omitempty"`
+
+	// ApprovalCommand must approve every key-using request (see approval.go)
+	ApprovalCommand string `json:"approval_command,omitempty"`
+	// ApprovalTimeout bounds how long ApprovalCommand may take (default 30s)
+	ApprovalTimeout string `json:"approval_timeout,omitempty"`
+	// ApprovalFallback decides when ApprovalCommand is missing or times out:
+	// "deny" (default) or "approve"
+	ApprovalFallback string `json:"approval_fallback,omitempty"`
+	// AskTimeout is how long daemon --ask waits for an answer (default 60s)
+	AskTimeout string `json:"ask_timeout,omitempty"`
+
+	// StrictConfirmation makes destructive methods require a confirmation token
+	StrictConfirmation bool `json:"strict_confirmation,omitempty"`
+	// RequireAuth makes every request carry a client token (see clients.go)
+	RequireAuth bool `json:"require_auth,omitempty"`
+	// AuditLog appends every signing and account operation to audit.log
+	AuditLog bool `json:"audit_log,omitempty"`
+
+	// ReceiptTime is when the daemon makes the daily receipt (HH:MM local
+	// time, default off); ReceiptRelay where it publishes it (see receipts.go)
+	ReceiptTime  string `json:"receipt_time,omitempty"`
+	ReceiptRelay string `json:"receipt_relay,omitempty"`
+
+	// BunkerRelays are the relays 'noorsigner bunker' listens on, comma
+	// separated (see bunker.go)
+	BunkerRelays string `json:"bunker_relays,omitempty"`
+	// ProfileRelays are the relays account profiles (kind 0) are fetched
+	// from, comma separated (see profile.go)
+	ProfileRelays string `json:"profile_relays,omitempty"`
+	// WSOrigins are the web pages allowed to open a WebSocket to the HTTP
+	// API, comma separated (see websocket.go)
+	WSOrigins string `json:"ws_origins,omitempty"`
+	// Strict turns on strict mode (see strict.go)
+	Strict bool `json:"strict,omitempty"`
+
+	// HealthCheckInterval enables periodic key integrity checks ("daily", "weekly" or a duration)
+	HealthCheckInterval string `json:"health_check_interval,omitempty"`
+
+	// LogLevel controls daemon.log verbosity: "error", "info" (default) or "debug"
+	LogLevel string `json:"log_level,omitempty"`
+
+	// MetricsTextfile is a .prom file for node_exporter's textfile collector (empty = off)
+	MetricsTextfile string `json:"metrics_textfile,omitempty"`
+
+	// MetricsInterval is how often MetricsTextfile is rewritten (default 15s)
+	MetricsInterval string `json:"metrics_interval,omitempty"`
+
+	// TrustDuration is how long a Trust Mode session lasts (default 24h)
+	TrustDuration string `json:"trust_duration,omitempty"`
+
+	// TrustIdleTimeout locks the daemon after this long without a key-using
+	// request (default off, see trustidle.go)
+	TrustIdleTimeout string `json:"trust_idle_timeout,omitempty"`
+
+	// TrustSliding extends the trust session to TrustDuration from each
+	// successful key operation, up to TrustMaxLifetime (see trustslide.go)
+	TrustSliding bool `json:"trust_sliding,omitempty"`
+
+	// TrustMaxLifetime caps a sliding trust session (default 168h)
+	TrustMaxLifetime string `json:"trust_max_lifetime,omitempty"`
+
+	// TrustTokenStore is where new trust sessions keep their token: the OS
+	// keyring (default) or the session file (see trustkeyring.go)
+	TrustTokenStore string `json:"trust_token_store,omitempty"`
+
+	// PermissionDuration is how long a remembered permission lasts (default 720h)
+	PermissionDuration string `json:"permission_duration,omitempty"`
+
+	// RequestTimeout is how long a connection may take to send a request (default 30s)
+	RequestTimeout string `json:"request_timeout,omitempty"`
+
+	// SocketPath overrides the Unix socket path (Windows: the named pipe)
+	SocketPath string `json:"socket_path,omitempty"`
+	// AbstractSocket adds a Linux abstract socket listener, e.g. "@noorsigner-1000"
+	AbstractSocket string `json:"abstract_socket,omitempty"`
+
+	// KDF is the key derivation function for newly encrypted keys (only "scrypt")
+	KDF string `json:"kdf,omitempty"`
+
+	// Autostart makes the daemon enable (true) or remove (false) its
+	// autostart entry when it starts. Unset leaves the entry alone.
+	Autostart *bool `json:"autostart,omitempty"`
+
+	// MaxConnections caps concurrently served connections (default 64, 0 = no limit)
+	MaxConnections *int `json:"max_connections,omitempty"`
+
+	// Signing and encryption requests per minute and client (default and
+	// 0 = no limit, see ratelimit.go)
+	SignRateLimit    *int `json:"sign_rate_limit,omitempty"`
+	EncryptRateLimit *int `json:"encrypt_rate_limit,omitempty"`
+
+	// RecentActivitySize is how many audited requests get_recent_activity can
+	// return (default 200, 0 turns the feed off)
+	RecentActivitySize *int `json:"recent_activity_size,omitempty"`
+
+	// Event limits checked by validate_event and before signing (0 = no limit)
+	MaxEventBytes      *int `json:"max_event_bytes,omitempty"`
+	MaxEventTags       *int `json:"max_event_tags,omitempty"`
+	MaxEventTagBytes   *int `json:"max_event_tag_bytes,omitempty"`
+	MaxTagElementBytes *int `json:"max_tag_element_bytes,omitempty"`
+
+	// Notifications configures desktop notifications (see notify.go)
+	Notifications *NotificationConfig `json:"notifications,omitempty"`
+
+	// Warnings collects problems found while loading (unknown keys, bad values)
+	Warnings []string `json:"-"`
+}
+
+// configOption describes one config.json key for validation and `noorsigner config`
+type configOption struct {
+	Key     string
+	Help    string
+	Default string
+	get     func(c *Config) string
+	set     func(c *Config, value string) error // "" resets to the default
+}
+
+// configOptions lists every scalar config key. Hooks are addressed as
+// hooks.<event>, the signing policy as kind_policy.<kind>, the
+// notifications section as notifications.<key>.
+var configOptions = append([]configOption{
+	{
+		Key:     "trust_duration",
+		Help:    "How long a Trust Mode session lasts (e.g. 8h, 72h)",
+		Default: "24h",
+		get:     func(c *Config) string { return c.TrustDuration },
+		set: func(c *Config, value string) error {
+			if _, err := parseTrustDuration(value); err != nil {
+				return err
+			}
+			c.TrustDuration = value
+			return nil
+		},
+	},
+	{
+		Key:     "trust_idle_timeout",
+		Help:    "Lock and end the trust session after this long unused (e.g. 2h; off = never)",
+		Default: "off",
+		get:     func(c *Config) string { return c.TrustIdleTimeout },
+		set: func(c *Config, value string) error {
+			if _, err := parseTrustIdleTimeout(value); err != nil {
+				return err
+			}
+			c.TrustIdleTimeout = value
+			return nil
+		},
+	},
+	{
+		Key:     "trust_sliding",
+		Help:    "Each signature or en-/decryption extends the trust session to trust_duration from then",
+		Default: "false",
+		get: func(c *Config) string {
+			if !c.TrustSliding {
+				return ""
+			}
+			return "true"
+		},
+		set: func(c *Config, value string) error {
+			if value == "" {
+				c.TrustSliding = false
+				return nil
+			}
+			sliding, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid trust_sliding %q (use true or false)", value)
+			}
+			c.TrustSliding = sliding
+			return nil
+		},
+	},
+	{
+		Key:     "trust_max_lifetime",
+		Help:    "Hard limit of a sliding trust session, counted from its creation (e.g. 72h)",
+		Default: "168h",
+		get:     func(c *Config) string { return c.TrustMaxLifetime },
+		set: func(c *Config, value string) error {
+			if _, err := parseTrustMaxLifetime(value); err != nil {
+				return err
+			}
+			c.TrustMaxLifetime = value
+			return nil
+		},
+	},
+	{
+		Key:     "trust_token_store",
+		Help:    "Where a trust session's token is kept: keyring (OS keyring) or file (next to the encrypted nsec)",
+		Default: trustTokenKeyring,
+		get:     func(c *Config) string { return c.TrustTokenStore },
+		set: func(c *Config, value string) error {
+			if err := validateTrustTokenStore(value); err != nil {
+				return err
+			}
+			c.TrustTokenStore = value
+			return nil
+		},
+	},
+	{
+		Key:     "permission_duration",
+		Help:    "How long a remembered per-app permission lasts (e.g. 24h, 2160h)",
+		Default: "720h",
+		get:     func(c *Config) string { return c.PermissionDuration },
+		set: func(c *Config, value string) error {
+			if _, err := parsePermissionDuration(value); err != nil {
+				return err
+			}
+			c.PermissionDuration = value
+			return nil
+		},
+	},
+	{
+		Key:     "log_level",
+		Help:    "Daemon log verbosity: error, info or debug",
+		Default: "info",
+		get:     func(c *Config) string { return c.LogLevel },
+		set: func(c *Config, value string) error {
+			if _, err := parseLogLevel(value); err != nil {
+				return err
+			}
+			c.LogLevel = value
+			return nil
+		},
+	},
+	{
+		Key:     "socket_path",
+		Help:    "Socket path for daemon and clients (Windows: \\\\.\\pipe\\ name)",
+		Default: "",
+		get:     func(c *Config) string { return c.SocketPath },
+		set: func(c *Config, value string) error {
+			if _, err := resolveSocketPathSetting(value); err != nil {
+				return err
+			}
+			c.SocketPath = value
+			return nil
+		},
+	},
+	{
+		Key:     "abstract_socket",
+		Help:    "Additional abstract socket listener for sandboxed clients, e.g. @noorsigner-1000 (Linux)",
+		Default: "",
+		get:     func(c *Config) string { return c.AbstractSocket },
+		set: func(c *Config, value string) error {
+			if err := validateAbstractSocketName(value); err != nil {
+				return err
+			}
+			c.AbstractSocket = value
+			return nil
+		},
+	},
+	{
+		Key:     "kdf",
+		Help:    "Key derivation for newly encrypted keys (scrypt)",
+		Default: "scrypt",
+		get:     func(c *Config) string { return c.KDF },
+		set: func(c *Config, value string) error {
+			if value != "" && value != "scrypt" {
+				return fmt.Errorf("unsupported kdf %q (supported: scrypt)", value)
+			}
+			c.KDF = value
+			return nil
+		},
+	},
+	{
+		Key:     "autostart",
+		Help:    "Daemon enables (true) or removes (false) its autostart entry on start",
+		Default: "",
+		get: func(c *Config) string {
+			if c.Autostart == nil {
+				return ""
+			}
+			return strconv.FormatBool(*c.Autostart)
+		},
+		set: func(c *Config, value string) error {
+			if value == "" {
+				c.Autostart = nil
+				return nil
+			}
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid autostart %q (use true or false)", value)
+			}
+			c.Autostart = &enabled
+			return nil
+		},
+	},
+	{
+		Key:     "strict_confirmation",
+		Help:    "Destructive methods require a confirmation token",
+		Default: "false",
+		get: func(c *Config) string {
+			if !c.StrictConfirmation {
+				return ""
+			}
+			return "true"
+		},
+		set: func(c *Config, value string) error {
+			if value == "" {
+				c.StrictConfirmation = false
+				return nil
+			}
+			strict, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid strict_confirmation %q (use true or false)", value)
+			}
+			c.StrictConfirmation = strict
+			return nil
+		},
+	},
+	{
+		Key:     "strict",
+		Help:    "Strict mode: no trust sessions or debug logging, memory locked, kinds 0, 3 and 5 confirmed",
+		Default: "false",
+		get: func(c *Config) string {
+			if !c.Strict {
+				return ""
+			}
+			return "true"
+		},
+		set: func(c *Config, value string) error {
+			if value == "" {
+				c.Strict = false
+				return nil
+			}
+			strict, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid strict %q (use true or false)", value)
+			}
+			c.Strict = strict
+			return nil
+		},
+	},
+	{
+		Key:     "require_auth",
+		Help:    "Every request must carry a client token (see 'noorsigner authorize')",
+		Default: "false",
+		get: func(c *Config) string {
+			if !c.RequireAuth {
+				return ""
+			}
+			return "true"
+		},
+		set: func(c *Config, value string) error {
+			if value == "" {
+				c.RequireAuth = false
+				return nil
+			}
+			required, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid require_auth %q (use true or false)", value)
+			}
+			c.RequireAuth = required
+			return nil
+		},
+	},
+	{
+		Key:     "approval_command",
+		Help:    "Executable that approves (exit 0) or denies each signing or encryption request",
+		Default: "",
+		get:     func(c *Config) string { return c.ApprovalCommand },
+		set: func(c *Config, value string) error {
+			if err := validateApprovalCommand(value); err != nil {
+				return err
+			}
+			c.ApprovalCommand = value
+			return nil
+		},
+	},
+	{
+		Key:     "approval_timeout",
+		Help:    "How long approval_command may take to decide",
+		Default: defaultApprovalTimeout.String(),
+		get:     func(c *Config) string { return c.ApprovalTimeout },
+		set: func(c *Config, value string) error {
+			if _, err := parseApprovalTimeout(value); err != nil {
+				return err
+			}
+			c.ApprovalTimeout = value
+			return nil
+		},
+	},
+	{
+		Key:     "approval_fallback",
+		Help:    "Decision when approval_command is missing or times out: deny or approve",
+		Default: "deny",
+		get:     func(c *Config) string { return c.ApprovalFallback },
+		set: func(c *Config, value string) error {
+			if err := validateApprovalFallback(value); err != nil {
+				return err
+			}
+			c.ApprovalFallback = value
+			return nil
+		},
+	},
+	{
+		Key:     "ask_timeout",
+		Help:    "How long daemon --ask waits for y/N before it denies",
+		Default: defaultAskTimeout.String(),
+		get:     func(c *Config) string { return c.AskTimeout },
+		set: func(c *Config, value string) error {
+			if _, err := parseAskTimeout(value); err != nil {
+				return err
+			}
+			c.AskTimeout = value
+			return nil
+		},
+	},
+	{
+		Key:     "audit_log",
+		Help:    "Append signing and account operations to audit.log",
+		Default: "false",
+		get: func(c *Config) string {
+			if !c.AuditLog {
+				return ""
+			}
+			return "true"
+		},
+		set: func(c *Config, value string) error {
+			if value == "" {
+				c.AuditLog = false
+				return nil
+			}
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid audit_log %q (use true or false)", value)
+			}
+			c.AuditLog = enabled
+			return nil
+		},
+	},
+	{
+		Key:     "receipt_time",
+		Help:    "Make a signed daily receipt of the audit log at this local time (HH:MM)",
+		Default: "",
+		get:     func(c *Config) string { return c.ReceiptTime },
+		set: func(c *Config, value string) error {
+			if _, err := parseReceiptTime(value); err != nil {
+				return err
+			}
+			c.ReceiptTime = value
+			return nil
+		},
+	},
+	{
+		Key:     "receipt_relay",
+		Help:    "Relay to publish daily receipts to (e.g. a private wss:// relay)",
+		Default: "",
+		get:     func(c *Config) string { return c.ReceiptRelay },
+		set: func(c *Config, value string) error {
+			if value != "" {
+				if err := checkZapRelay(value); err != nil {
+					return fmt.Errorf("invalid receipt_relay %q: expected a wss:// or ws:// URL", value)
+				}
+			}
+			c.ReceiptRelay = value
+			return nil
+		},
+	},
+	{
+		Key:     "bunker_relays",
+		Help:    "Relays NIP-46 apps reach the bunker on, comma separated (noorsigner bunker)",
+		Default: "",
+		get:     func(c *Config) string { return c.BunkerRelays },
+		set: func(c *Config, value string) error {
+			if _, err := parseBunkerRelays(value); err != nil {
+				return err
+			}
+			c.BunkerRelays = value
+			return nil
+		},
+	},
+	{
+		Key:     "profile_relays",
+		Help:    "Relays account profiles (kind 0) are fetched from, comma separated (noorsigner profile)",
+		Default: defaultProfileRelays,
+		get:     func(c *Config) string { return c.ProfileRelays },
+		set: func(c *Config, value string) error {
+			if _, err := parseProfileRelays(value); err != nil {
+				return err
+			}
+			c.ProfileRelays = value
+			return nil
+		},
+	},
+	{
+		Key:     "ws_origins",
+		Help:    "Web pages allowed to use the WebSocket API, comma separated; none admits clients without Origin (daemon --http)",
+		Default: "",
+		get:     func(c *Config) string { return c.WSOrigins },
+		set: func(c *Config, value string) error {
+			if _, err := parseWSOrigins(value); err != nil {
+				return err
+			}
+			c.WSOrigins = value
+			return nil
+		},
+	},
+	{
+		Key:     "health_check_interval",
+		Help:    "Periodic key integrity check: daily, weekly or a duration",
+		Default: "off",
+		get:     func(c *Config) string { return c.HealthCheckInterval },
+		set: func(c *Config, value string) error {
+			if _, err := parseHealthCheckInterval(value); err != nil {
+				return err
+			}
+			c.HealthCheckInterval = value
+			return nil
+		},
+	},
+	{
+		Key:     "metrics_textfile",
+		Help:    "Prometheus textfile to write daemon metrics to",
+		Default: "",
+		get:     func(c *Config) string { return c.MetricsTextfile },
+		set: func(c *Config, value string) error {
+			c.MetricsTextfile = value
+			return nil
+		},
+	},
+	{
+		Key:     "metrics_interval",
+		Help:    "How often metrics_textfile is rewritten",
+		Default: defaultMetricsInterval.String(),
+		get:     func(c *Config) string { return c.MetricsInterval },
+		set: func(c *Config, value string) error {
+			if _, err := parseMetricsInterval(value); err != nil {
+				return err
+			}
+			c.MetricsInterval = value
+			return nil
+		},
+	},
+	{
+		Key:     "request_timeout",
+		Help:    "How long a connection may take to send a request",
+		Default: defaultRequestTimeout.String(),
+		get:     func(c *Config) string { return c.RequestTimeout },
+		set: func(c *Config, value string) error {
+			if _, err := parseRequestTimeout(value); err != nil {
+				return err
+			}
+			c.RequestTimeout = value
+			return nil
+		},
+	},
+	intOption("max_connections", "Connections served at once (0 = no limit)",
+		defaultMaxConnections, maxConnectionsLimit,
+		func(c *Config) **int { return &c.MaxConnections }),
+	intOption("sign_rate_limit", "Signing requests per minute and client (0 = no limit)",
+		0, maxRateLimit,
+		func(c *Config) **int { return &c.SignRateLimit }),
+	intOption("encrypt_rate_limit", "NIP-04/NIP-44 requests per minute and client (0 = no limit)",
+		0, maxRateLimit,
+		func(c *Config) **int { return &c.EncryptRateLimit }),
+	intOption("recent_activity_size", "Requests kept for get_recent_activity (0 = feed off)",
+		defaultRecentActivitySize, maxRecentActivitySize,
+		func(c *Config) **int { return &c.RecentActivitySize }),
+	intOption("max_event_bytes", "Largest serialized event that is signed (0 = no limit)",
+		defaultMaxEventBytes, maxEventLimit,
+		func(c *Config) **int { return &c.MaxEventBytes }),
+	intOption("max_event_tags", "Most tags an event may have (0 = no limit)",
+		defaultMaxEventTags, maxEventLimit,
+		func(c *Config) **int { return &c.MaxEventTags }),
+	intOption("max_event_tag_bytes", "Largest serialized tags array in bytes (0 = no limit)",
+		defaultMaxEventTagBytes, maxEventLimit,
+		func(c *Config) **int { return &c.MaxEventTagBytes }),
+	intOption("max_tag_element_bytes", "Longest single tag element in bytes (0 = no limit)",
+		defaultMaxTagElementBytes, maxEventLimit,
+		func(c *Config) **int { return &c.MaxTagElementBytes }),
+}, notificationConfigOptions()...)
+
+// intOption describes an optional integer key between 0 and max. field
+// returns the Config field, which is nil while the default applies.
+func intOption(key, help string, defaultValue, max int, field func(c *Config) **int) configOption {
+	return configOption{
+		Key:     key,
+		Help:    help,
+		Default: strconv.Itoa(defaultValue),
+		get: func(c *Config) string {
+			if *field(c) == nil {
+				return ""
+			}
+			return strconv.Itoa(**field(c))
+		},
+		set: func(c *Config, value string) error {
+			if value == "" {
+				*field(c) = nil
+				return nil
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > max {
+				return fmt.Errorf("invalid %s %q (use 0-%d)", key, value, max)
+			}
+			*field(c) = &n
+			return nil
+		},
+	}
+}
+
+// intSetting returns the value of an intOption field, or its default if unset
+func intSetting(value *int, defaultValue int) int {
+	if value == nil {
+		return defaultValue
+	}
+	return *value
+}
+
+// appConfig is config.json as loaded by main() for the CLI and the daemon
+var appConfig = &Config{}
+
+// appConfigErr is set when config.json could not be read or parsed
+var appConfigErr error
+
+// findConfigOption returns the option for key (nil if unknown)
+func findConfigOption(key string) *configOption {
+	for i := range configOptions {
+		if configOptions[i].Key == key {
+			return &configOptions[i]
+		}
+	}
+	return nil
+}
+
+// getConfigFilePath returns path to config.json
+func getConfigFilePath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(storageDir, "config.json"), nil
+}
+
+// loadConfig loads config.json, returning defaults when the file is missing.
+// Unknown keys and invalid values are recorded in Config.Warnings and fall
+// back to their defaults instead of failing.
+func loadConfig() (*Config, error) {
+	config := &Config{}
+
+	configFile, err := getConfigFilePath()
+	if err != nil {
+		return config, err
+	}
+
+	content, err := os.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("cannot read config file: %v", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return &Config{}, fmt.Errorf("invalid config file: %v", err)
+	}
+
+	// Decode key by key so one bad entry doesn't discard the rest
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key != "hooks" && key != "kind_policy" && key != "notifications" && findConfigOption(key) == nil {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("unknown config key %q ignored", key))
+			continue
+		}
+
+		single, _ := json.Marshal(map[string]json.RawMessage{key: raw[key]})
+		if err := json.Unmarshal(single, config); err != nil {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("invalid value for %q - using default", key))
+		}
+	}
+
+	for event := range config.Hooks {
+		if !hookEvents[event] {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("unknown hook event %q ignored", event))
+		}
+	}
+	for key, action := range config.KindPolicy {
+		if _, err := parsePolicyKey(key); err != nil {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("kind_policy: %v - ignored", err))
+			delete(config.KindPolicy, key)
+		} else if err := validatePolicyAction(action); err != nil {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("kind_policy.%s: %v - ignored", key, err))
+			delete(config.KindPolicy, key)
+		}
+	}
+	if config.Notifications != nil {
+		for category := range config.Notifications.Categories {
+			if findNotificationCategory(category) == nil {
+				config.Warnings = append(config.Warnings, fmt.Sprintf("unknown notification category %q ignored", category))
+			}
+		}
+	}
+
+	for _, option := range configOptions {
+		value := option.get(config)
+		if value == "" {
+			continue
+		}
+		if err := option.set(config, value); err != nil {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("%v - using default", err))
+			option.set(config, "")
+		}
+	}
+
+	return config, nil
+}
+
+// effectiveValue returns an option's value with the default filled in
+func (c *Config) effectiveValue(option *configOption) string {
+	if value := option.get(c); value != "" {
+		return value
+	}
+	if option.Key == "socket_path" {
+		if socketPath, err := getSocketPath(); err == nil {
+			return socketPath
+		}
+	}
+	return option.Default
+}
+
+// effective returns every setting with defaults filled in (for status output)
+func (c *Config) effective() map[string]string {
+	values := make(map[string]string)
+	for i := range configOptions {
+		values[configOptions[i].Key] = c.effectiveValue(&configOptions[i])
+	}
+	for event, hookPath := range c.Hooks {
+		if hookEvents[event] {
+			values["hooks."+event] = hookPath
+		}
+	}
+	values["kind_policy."+policyDefaultKey] = policyAllow
+	for key, action := range c.KindPolicy {
+		values["kind_policy."+key] = action
+	}
+	return values
+}
+
+// parseTrustDuration parses trust_duration (empty = 24h)
+func parseTrustDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultTrustDuration, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid trust_duration %q: %v", value, err)
+	}
+	if duration < time.Minute || duration > 30*24*time.Hour {
+		return 0, fmt.Errorf("trust_duration must be between 1m and 720h")
+	}
+	return duration, nil
+}
+
+// trustDuration returns the configured Trust Mode session length
+func trustDuration() time.Duration {
+	duration, err := parseTrustDuration(appConfig.TrustDuration)
+	if err != nil {
+		return defaultTrustDuration
+	}
+	return duration
+}
+
+// parsePermissionDuration parses permission_duration (empty = 720h)
+func parsePermissionDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultPermissionDuration, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid permission_duration %q: %v", value, err)
+	}
+	if duration < time.Minute || duration > 365*24*time.Hour {
+		return 0, fmt.Errorf("permission_duration must be between 1m and 8760h")
+	}
+	return duration, nil
+}
+
+// permissionDuration returns how long a remembered permission lasts
+func (c *Config) permissionDuration() time.Duration {
+	if c == nil {
+		return defaultPermissionDuration
+	}
+	duration, err := parsePermissionDuration(c.PermissionDuration)
+	if err != nil {
+		return defaultPermissionDuration
+	}
+	return duration
+}
+
+// recentActivitySize returns the configured activity buffer size
+func (c *Config) recentActivitySize() int {
+	if c == nil {
+		return defaultRecentActivitySize
+	}
+	return intSetting(c.RecentActivitySize, defaultRecentActivitySize)
+}
+
+// maxConnections returns max_connections
+func (c *Config) maxConnections() int {
+	if c == nil {
+		return defaultMaxConnections
+	}
+	return intSetting(c.MaxConnections, defaultMaxConnections)
+}
+
+// signRateLimit returns sign_rate_limit (0 = no limit)
+func (c *Config) signRateLimit() int {
+	if c == nil {
+		return 0
+	}
+	return intSetting(c.SignRateLimit, 0)
+}
+
+// encryptRateLimit returns encrypt_rate_limit (0 = no limit)
+func (c *Config) encryptRateLimit() int {
+	if c == nil {
+		return 0
+	}
+	return intSetting(c.EncryptRateLimit, 0)
+}
+
+// eventLimits returns the configured event limits
+func (c *Config) eventLimits() eventLimits {
+	if c == nil {
+		c = &Config{}
+	}
+	return eventLimits{
+		MaxEventBytes:      intSetting(c.MaxEventBytes, defaultMaxEventBytes),
+		MaxTags:            intSetting(c.MaxEventTags, defaultMaxEventTags),
+		MaxTagBytes:        intSetting(c.MaxEventTagBytes, defaultMaxEventTagBytes),
+		MaxTagElementBytes: intSetting(c.MaxTagElementBytes, defaultMaxTagElementBytes),
+	}
+}
+
+// describeDuration formats a duration for users ("24 hours", "90m0s")
+func describeDuration(duration time.Duration) string {
+	if duration%time.Hour == 0 {
+		hours := int(duration / time.Hour)
+		if hours == 1 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", hours)
+	}
+	return duration.String()
+}
+
+// resolveSocketPathSetting validates socket_path and expands a leading ~/
+func resolveSocketPathSetting(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	if runtime.GOOS == "windows" {
+		if !strings.HasPrefix(value, `\\.\pipe\`) {
+			return "", fmt.Errorf(`socket_path must be a named pipe (\\.\pipe\...) on Windows`)
+		}
+		return value, nil
+	}
+
+	if strings.HasPrefix(value, "~/") {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot get home directory: %v", err)
+		}
+		value = filepath.Join(homeDir, value[2:])
+	}
+	if !filepath.IsAbs(value) {
+		return "", fmt.Errorf("socket_path must be an absolute path")
+	}
+	return value, nil
+}
+
+// configuredSocketPath returns the socket_path override ("" if unset)
+func configuredSocketPath() string {
+	socketPath, err := resolveSocketPathSetting(appConfig.SocketPath)
+	if err != nil {
+		return ""
+	}
+	return socketPath
+}
+
+// printConfigWarnings reports config.json problems for CLI commands
+func printConfigWarnings() {
+	if appConfigErr != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  %v - using defaults\n", appConfigErr)
+	}
+	for _, warning := range appConfig.Warnings {
+		fmt.Fprintf(os.Stderr, "⚠️  config.json: %s\n", warning)
+	}
+}
+
+// setConfigValue validates value and stores it under key in config.json.
+// An empty value removes the key. Keys this version doesn't know about are
+// kept as they are.
+func setConfigValue(key, value string) error {
+	configFile, err := getConfigFilePath()
+	if err != nil {
+		return err
+	}
+
+	raw := make(map[string]json.RawMessage)
+	content, err := os.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot read config file: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &raw); err != nil {
+			return fmt.Errorf("config.json is not valid JSON - fix it by hand first: %v", err)
+		}
+	}
+
+	if event, ok := strings.CutPrefix(key, "hooks."); ok {
+		if !hookEvents[event] {
+			return fmt.Errorf("unknown hook event %q", event)
+		}
+
+		hooks := make(map[string]string)
+		if existing, ok := raw["hooks"]; ok {
+			if err := json.Unmarshal(existing, &hooks); err != nil {
+				return fmt.Errorf("config.json has an invalid hooks entry: %v", err)
+			}
+		}
+		if value == "" {
+			delete(hooks, event)
+		} else {
+			hooks[event] = value
+		}
+
+		if len(hooks) == 0 {
+			delete(raw, "hooks")
+		} else {
+			raw["hooks"], _ = json.Marshal(hooks)
+		}
+	} else if kindKey, ok := strings.CutPrefix(key, "kind_policy."); ok {
+		if _, err := parsePolicyKey(kindKey); err != nil {
+			return err
+		}
+		if value != "" {
+			if err := validatePolicyAction(value); err != nil {
+				return err
+			}
+		}
+
+		policy := make(map[string]string)
+		if existing, ok := raw["kind_policy"]; ok {
+			if err := json.Unmarshal(existing, &policy); err != nil {
+				return fmt.Errorf("config.json has an invalid kind_policy entry: %v", err)
+			}
+		}
+		if value == "" {
+			delete(policy, kindKey)
+		} else {
+			policy[kindKey] = value
+		}
+
+		if len(policy) == 0 {
+			delete(raw, "kind_policy")
+		} else {
+			raw["kind_policy"], _ = json.Marshal(policy)
+		}
+	} else {
+		option := findConfigOption(key)
+		if option == nil {
+			return fmt.Errorf("unknown config key %q", key)
+		}
+
+		// Render just this key through the typed struct. A key inside a
+		// section (notifications.*) starts from the section as it is.
+		updated := &Config{}
+		section, _, _ := strings.Cut(key, ".")
+		if existing, ok := raw[section]; ok && section != key {
+			single, _ := json.Marshal(map[string]json.RawMessage{section: existing})
+			if err := json.Unmarshal(single, updated); err != nil {
+				return fmt.Errorf("config.json has an invalid %s entry: %v", section, err)
+			}
+		}
+		if err := option.set(updated, value); err != nil {
+			return err
+		}
+		rendered, _ := json.Marshal(updated)
+		var fields map[string]json.RawMessage
+		json.Unmarshal(rendered, &fields)
+
+		if field, ok := fields[section]; ok {
+			raw[section] = field
+		} else {
+			delete(raw, section)
+		}
+	}
+
+	output, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(configFile, append(output, '\n'), 0600)
+}
+
+// configCmd implements `noorsigner config get [key]` and `config set <key> <value>`
+func configCmd(args []string) error {
+	if len(args) == 0 {
+		printConfigUsage()
+		return &commandError{Code: 1}
+	}
+
+	switch args[0] {
+	case "get":
+		if len(args) > 2 {
+			printConfigUsage()
+			return &commandError{Code: 1}
+		}
+		if len(args) == 2 {
+			return configGetCmd(args[1])
+		}
+		configListCmd()
+
+	case "set":
+		if len(args) != 3 {
+			printConfigUsage()
+			return &commandError{Code: 1}
+		}
+		if err := setConfigValue(args[1], args[2]); err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+		if args[2] == "" {
+			fmt.Printf("✅ %s reset to default\n", args[1])
+		} else {
+			fmt.Printf("✅ %s = %s\n", args[1], args[2])
+		}
+		if isDaemonRunning() {
+			fmt.Println("   Restart the daemon to apply: pkill noorsigner && noorsigner daemon")
+		}
+
+	default:
+		printConfigUsage()
+		return &commandError{Code: 1}
+	}
+	return nil
+}
+
+// configGetCmd prints the effective value of one key
+func configGetCmd(key string) error {
+	if event, ok := strings.CutPrefix(key, "hooks."); ok {
+		if !hookEvents[event] {
+			return commandFailed(1, "Unknown hook event: %s", event)
+		}
+		fmt.Println(appConfig.Hooks[event])
+		return nil
+	}
+	if kindKey, ok := strings.CutPrefix(key, "kind_policy."); ok {
+		kind, err := parsePolicyKey(kindKey)
+		if err != nil {
+			return commandFailed(1, "%s", err)
+		}
+		if kind == nil {
+			fmt.Println(newSigningPolicy(appConfig).defaultAction)
+		} else {
+			fmt.Println(newSigningPolicy(appConfig).action(*kind))
+		}
+		return nil
+	}
+
+	option := findConfigOption(key)
+	if option == nil {
+		fmt.Printf("Unknown config key: %s\n", key)
+		printConfigUsage()
+		return &commandError{Code: 1}
+	}
+	fmt.Println(appConfig.effectiveValue(option))
+	return nil
+}
+
+// configListCmd prints every setting, marking the ones left at their default
+func configListCmd() {
+	configFile, _ := getConfigFilePath()
+	fmt.Printf("📄 %s\n", configFile)
+	fmt.Println()
+
+	values := appConfig.effective()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		suffix := ""
+		if option := findConfigOption(key); option != nil && option.get(appConfig) == "" {
+			suffix = "  (default)"
+		}
+		value := values[key]
+		if value == "" {
+			value = "-"
+		}
+		fmt.Printf("  %-36s %s%s\n", key, value, suffix)
+	}
+}
+
+// printConfigUsage lists the config subcommands and keys
+func printConfigUsage() {
+	fmt.Println("Usage: noorsigner config get [key]")
+	fmt.Println("       noorsigner config set <key> <value>   (empty value resets to default)")
+	fmt.Println()
+	fmt.Println("Keys:")
+	for _, option := range configOptions {
+		fmt.Printf("  %-36s %s\n", option.Key, option.Help)
+	}
+	fmt.Printf("  %-36s %s\n", "hooks.<event>", "Executable to run on a lifecycle event")
+	fmt.Printf("  %-36s %s\n", "kind_policy.<kind|default>", "Signing policy for a kind: allow, deny or confirm")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// confirmationTTL is how long a confirmation token stays valid
+const confirmationTTL = 60 * time.Second
+
+// pendingConfirmation is an issued, not yet redeemed confirmation token
+type pendingConfirmation struct {
+	connID    uint64
+	method    string
+	target    string
+	force     bool
+	expiresAt time.Time
+}
+
+// checkConfirmation enforces the two-step confirmation flow for a destructive
+// request. It returns ok=true when the request carries a valid token and the
+// retyped npub; otherwise it returns the response to send to the client.
+func (d *Daemon) checkConfirmation(session *connSession, req SignRequest, targetNpub string) (AccountActionResponse, bool) {
+	d.confirmMu.Lock()
+	defer d.confirmMu.Unlock()
+
+	now := time.Now()
+
+	// Drop expired tokens so the map can't grow without bound
+	for token, pending := range d.confirmations {
+		if now.After(pending.expiresAt) {
+			delete(d.confirmations, token)
+		}
+	}
+
+	if req.ConfirmationToken == "" {
+		tokenBytes := make([]byte, 16)
+		if _, err := rand.Read(tokenBytes); err != nil {
+			return AccountActionResponse{
+				ID:    req.ID,
+				Error: fmt.Sprintf("cannot generate confirmation token: %v", err),
+			}, false
+		}
+		token := hex.EncodeToString(tokenBytes)
+		expiresAt := now.Add(confirmationTTL)
+
+		session.awaitingConfirmation = true
+		d.confirmations[token] = &pendingConfirmation{
+			connID:    session.id,
+			method:    req.Method,
+			target:    targetNpub,
+			force:     req.Force,
+			expiresAt: expiresAt,
+		}
+
+		return AccountActionResponse{
+			ID:                    req.ID,
+			Error:                 "confirmation required",
+			Code:                  codeConfirmationRequired,
+			ConfirmationToken:     token,
+			ConfirmationPrompt:    confirmationPrompt(req.Method, targetNpub),
+			ConfirmationExpiresAt: expiresAt.Unix(),
+		}, false
+	}
+
+	pending, exists := d.confirmations[req.ConfirmationToken]
+	// Tokens are single-use: consume before validating the rest
+	delete(d.confirmations, req.ConfirmationToken)
+
+	switch {
+	case !exists:
+		return confirmationInvalid(req.ID, "unknown or expired confirmation token"), false
+	case pending.connID != session.id:
+		return confirmationInvalid(req.ID, "confirmation token was issued to another connection"), false
+	case pending.method != req.Method || pending.target != targetNpub || pending.force != req.Force:
+		return confirmationInvalid(req.ID, "confirmation token does not match this request"), false
+	case req.ConfirmNpub != targetNpub:
+		return confirmationInvalid(req.ID, "retyped npub does not match"), false
+	}
+
+	return AccountActionResponse{}, true
+}
+
+// confirmationPrompt returns the human prompt a GUI should show. The npub
+// is never truncated here since the user has to retype it exactly.
+func confirmationPrompt(method, npub string) string {
+	switch method {
+	case "remove_account":
+		return fmt.Sprintf("This permanently deletes the account %s. Type the full npub to confirm.", npub)
+	default:
+		return fmt.Sprintf("Confirm %s for %s by typing the full npub.", method, npub)
+	}
+}
+
+func confirmationInvalid(id, message string) AccountActionResponse {
+	return AccountActionResponse{
+		ID:    id,
+		Error: message,
+		Code:  codeConfirmationInvalid,
+	}
+}
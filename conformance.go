@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/coder/websocket"
+)
+
+// conformanceTimeout bounds each request so a wedged implementation fails instead of hanging
+const conformanceTimeout = 10 * time.Second
+
+// conformanceClient speaks the raw wire protocol using untyped JSON so the
+// suite checks what is actually on the wire, not our Go structs
+type conformanceClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	seq    int
+}
+
+// conformanceCase is one documented request with its expected outcome
+type conformanceCase struct {
+	Method      string
+	Description string
+	Run         func(c *conformanceClient, state *conformanceState) error
+}
+
+// conformanceState carries values discovered by earlier cases
+type conformanceState struct {
+	npub   string
+	pubkey string
+}
+
+// request sends one request and returns the decoded response object
+func (c *conformanceClient) request(fields map[string]interface{}) (map[string]interface{}, error) {
+	c.seq++
+	id := fmt.Sprintf("conformance-%03d", c.seq)
+	fields["id"] = id
+	if token := clientToken(); token != "" {
+		fields["token"] = token
+	}
+
+	line, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return c.raw(append(line, '\n'), id)
+}
+
+// raw writes bytes verbatim and reads one response line
+func (c *conformanceClient) raw(payload []byte, expectedID string) (map[string]interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(conformanceTimeout))
+	if _, err := c.conn.Write(payload); err != nil {
+		return nil, fmt.Errorf("write failed: %v", err)
+	}
+
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read failed: %v", err)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(line, &response); err != nil {
+		return nil, fmt.Errorf("response is not a JSON object: %v", err)
+	}
+	if expectedID != "" && response["id"] != expectedID {
+		return nil, fmt.Errorf("response id %v does not match request id %s", response["id"], expectedID)
+	}
+	return response, nil
+}
+
+// stringField returns a string field, failing if it is missing or an error was returned
+func stringField(response map[string]interface{}, field string) (string, error) {
+	if errMsg, ok := response["error"].(string); ok && errMsg != "" {
+		return "", fmt.Errorf("daemon error: %s", errMsg)
+	}
+	value, ok := response[field].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("missing %q in response", field)
+	}
+	return value, nil
+}
+
+// conformanceCorpus is the documented request corpus. Cases run in order on
+// one connection and never modify stored accounts.
+var conformanceCorpus = []conformanceCase{
+	{
+		Method:      "get_npub",
+		Description: "returns the active npub in \"signature\"",
+		Run: func(c *conformanceClient, state *conformanceState) error {
+			response, err := c.request(map[string]interface{}{"method": "get_npub"})
+			if err != nil {
+				return err
+			}
+			npub, err := stringField(response, "signature")
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(npub, "npub1") {
+				return fmt.Errorf("expected npub1..., got %q", npub)
+			}
+			state.npub = npub
+			return nil
+		},
+	},
+	{
+		Method:      "get_public_key",
+		Description: "returns the active hex pubkey matching get_npub",
+		Run: func(c *conformanceClient, state *conformanceState) error {
+			response, err := c.request(map[string]interface{}{"method": "get_public_key"})
+			if err != nil {
+				return err
+			}
+			pubkey, err := stringField(response, "signature")
+			if err != nil {
+				return err
+			}
+			expected, err := npubToPubkey(state.npub)
+			if err != nil {
+				return fmt.Errorf("cannot decode npub from get_npub: %v", err)
+			}
+			if pubkey != expected {
+				return fmt.Errorf("pubkey %s does not match npub (expected %s)", pubkey, expected)
+			}
+			state.pubkey = pubkey
+			return nil
+		},
+	},
+	{
+		Method:      "get_active_account",
+		Description: "reports the same account as unlocked",
+		Run: func(c *conformanceClient, state *conformanceState) error {
+			response, err := c.request(map[string]interface{}{"method": "get_active_account"})
+			if err != nil {
+				return err
+			}
+			pubkey, err := stringField(response, "pubkey")
+			if err != nil {
+				return err
+			}
+			if pubkey != state.pubkey {
+				return fmt.Errorf("active pubkey %s does not match %s", pubkey, state.pubkey)
+			}
+			if unlocked, _ := response["is_unlocked"].(bool); !unlocked {
+				return fmt.Errorf("is_unlocked is false")
+			}
+			return nil
+		},
+	},
+	{
+		Method:      "list_accounts",
+		Description: "lists the active account",
+		Run: func(c *conformanceClient, state *conformanceState) error {
+			response, err := c.request(map[string]interface{}{"method": "list_accounts"})
+			if err != nil {
+				return err
+			}
+			if active, _ := response["active_pubkey"].(string); active != state.pubkey {
+				return fmt.Errorf("active_pubkey %q does not match %s", active, state.pubkey)
+			}
+			accounts, _ := response["accounts"].([]interface{})
+			for _, entry := range accounts {
+				if account, ok := entry.(map[string]interface{}); ok && account["pubkey"] == state.pubkey {
+					return nil
+				}
+			}
+			return fmt.Errorf("active account missing from accounts list")
+		},
+	},
+	{
+		Method:      "sign_event",
+		Description: "produces a valid BIP-340 signature over the NIP-01 event hash",
+		Run: func(c *conformanceClient, state *conformanceState) error {
+			event := map[string]interface{}{
+				"pubkey":     state.pubkey,
+				"created_at": 1694198400,
+				"kind":       1,
+				"tags":       [][]string{{"t", "conformance"}},
+				"content":    "conformance <test> & \"quotes\" ✓",
+			}
+			eventJSON, _ := json.Marshal(event)
+
+			response, err := c.request(map[string]interface{}{
+				"method":     "sign_event",
+				"event_json": string(eventJSON),
+			})
+			if err != nil {
+				return err
+			}
+			sigHex, err := stringField(response, "signature")
+			if err != nil {
+				return err
+			}
+			return verifyConformanceSignature(string(eventJSON), state.pubkey, sigHex)
+		},
+	},
+	{
+		Method:      "nip44_encrypt",
+		Description: "round-trips through nip44_decrypt (self-encryption)",
+		Run: func(c *conformanceClient, state *conformanceState) error {
+			return conformanceRoundTrip(c, state, "nip44")
+		},
+	},
+	{
+		Method:      "nip04_encrypt",
+		Description: "round-trips through nip04_decrypt (self-encryption)",
+		Run: func(c *conformanceClient, state *conformanceState) error {
+			return conformanceRoundTrip(c, state, "nip04")
+		},
+	},
+	{
+		Method:      "unknown_method",
+		Description: "answers unknown methods with an error, keeping the connection usable",
+		Run: func(c *conformanceClient, state *conformanceState) error {
+			response, err := c.request(map[string]interface{}{"method": "no_such_method"})
+			if err != nil {
+				return err
+			}
+			if errMsg, _ := response["error"].(string); errMsg == "" {
+				return fmt.Errorf("expected an error for an unknown method")
+			}
+			return nil
+		},
+	},
+	{
+		Method:      "duplicate_id",
+		Description: "rejects a request id already used on the connection",
+		Run: func(c *conformanceClient, state *conformanceState) error {
+			line := []byte(`{"id":"conformance-duplicate","method":"get_npub"}` + "\n")
+			if _, err := c.raw(line, "conformance-duplicate"); err != nil {
+				return err
+			}
+			response, err := c.raw(line, "conformance-duplicate")
+			if err != nil {
+				return err
+			}
+			if code, _ := response["code"].(string); code != codeDuplicateID {
+				return fmt.Errorf("expected %s for a reused id, got %v", codeDuplicateID, response)
+			}
+			return nil
+		},
+	},
+	{
+		Method:      "invalid_json",
+		Description: "answers malformed JSON with an error response",
+		Run: func(c *conformanceClient, state *conformanceState) error {
+			response, err := c.raw([]byte("{not json\n"), "")
+			if err != nil {
+				return err
+			}
+			if errMsg, _ := response["error"].(string); errMsg == "" {
+				return fmt.Errorf("expected an error for malformed JSON")
+			}
+			return nil
+		},
+	},
+}
+
+// conformanceRoundTrip encrypts a message to ourselves and decrypts it again
+func conformanceRoundTrip(c *conformanceClient, state *conformanceState, scheme string) error {
+	plaintext := "conformance round trip ✓ " + scheme
+
+	response, err := c.request(map[string]interface{}{
+		"method":           scheme + "_encrypt",
+		"plaintext":        plaintext,
+		"recipient_pubkey": state.pubkey,
+	})
+	if err != nil {
+		return err
+	}
+	payload, err := stringField(response, "signature")
+	if err != nil {
+		return err
+	}
+
+	response, err = c.request(map[string]interface{}{
+		"method":        scheme + "_decrypt",
+		"payload":       payload,
+		"sender_pubkey": state.pubkey,
+	})
+	if err != nil {
+		return err
+	}
+	decrypted, err := stringField(response, "signature")
+	if err != nil {
+		return err
+	}
+	if decrypted != plaintext {
+		return fmt.Errorf("decrypted %q, expected %q", decrypted, plaintext)
+	}
+	return nil
+}
+
+// verifyConformanceSignature checks sigHex against the NIP-01 hash of eventJSON
+func verifyConformanceSignature(eventJSON, pubkeyHex, sigHex string) error {
+	hash, err := createEventHash(eventJSON)
+	if err != nil {
+		return err
+	}
+
+	sigBytes, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return fmt.Errorf("signature is not hex: %v", err)
+	}
+	signature, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	pubkeyBytes, err := hex.DecodeString(pubkeyHex)
+	if err != nil {
+		return fmt.Errorf("pubkey is not hex: %v", err)
+	}
+	pubkey, err := schnorr.ParsePubKey(pubkeyBytes)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %v", err)
+	}
+
+	if !signature.Verify(hash, pubkey) {
+		return fmt.Errorf("signature does not verify (event id %s)", hex.EncodeToString(hash))
+	}
+	return nil
+}
+
+// dialConformanceWebSocket opens the WebSocket of a daemon's HTTP API
+// (daemon --http) as a net.Conn, so the suite runs over it unchanged
+func dialConformanceWebSocket(wsURL, token string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), conformanceTimeout)
+	defer cancel()
+
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	c, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{HTTPHeader: header})
+	if err != nil {
+		return nil, err
+	}
+	return websocket.NetConn(context.Background(), c, websocket.MessageText), nil
+}
+
+// conformanceCmd runs the request corpus against a daemon and reports pass/fail per method
+func conformanceCmd(args []string) error {
+	usage := "Usage: noorsigner conformance [--socket <path> | --ws <url> --token <token>]"
+	socketPath := ""
+	wsURL := ""
+	token := ""
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--socket", "--ws", "--token":
+			if i+1 >= len(args) {
+				return commandFailed(1, "%s", usage)
+			}
+			switch args[i] {
+			case "--socket":
+				socketPath = args[i+1]
+			case "--ws":
+				wsURL = args[i+1]
+			default:
+				token = args[i+1]
+			}
+			i++
+		default:
+			fmt.Printf("Unknown option: %s\n", args[i])
+			return commandFailed(1, "%s", usage)
+		}
+	}
+
+	var conn net.Conn
+	var err error
+	switch {
+	case wsURL != "":
+		conn, err = dialConformanceWebSocket(wsURL, token)
+	case socketPath != "":
+		conn, err = net.Dial("unix", socketPath)
+	default:
+		conn, err = dialConnection()
+	}
+	if err != nil {
+		return commandFailed(1, "❌ Cannot connect: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Println("🧪 Running protocol conformance suite")
+	fmt.Println()
+
+	client := &conformanceClient{conn: conn, reader: bufio.NewReader(conn)}
+	state := &conformanceState{}
+	failures := 0
+
+	for _, testCase := range conformanceCorpus {
+		if err := testCase.Run(client, state); err != nil {
+			failures++
+			fmt.Printf("❌ %-20s %s\n   %v\n", testCase.Method, testCase.Description, err)
+			continue
+		}
+		fmt.Printf("✅ %-20s %s\n", testCase.Method, testCase.Description)
+	}
+
+	fmt.Println()
+	fmt.Printf("%d/%d passed\n", len(conformanceCorpus)-failures, len(conformanceCorpus))
+	if failures > 0 {
+		return &commandError{Code: 1}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultMaxConnections is how many connections are served at once
+	defaultMaxConnections = 64
+
+	// maxConnectionsLimit bounds max_connections in config.json
+	maxConnectionsLimit = 4096
+
+	// connectionQueueSize is how many connections may wait for a free slot
+	connectionQueueSize = 16
+
+	// connectionQueueWait is how long a queued connection waits before it
+	// gets ERR_BUSY
+	connectionQueueWait = 2 * time.Second
+
+	codeBusy = "ERR_BUSY"
+)
+
+// ConnectionStats reports connection counts in get_status
+type ConnectionStats struct {
+	Current int64 `json:"current"`
+	Peak    int64 `json:"peak"`
+	// Limit is max_connections (0 = no limit)
+	Limit int `json:"limit"`
+	// Rejected counts connections turned away with ERR_BUSY
+	Rejected uint64 `json:"rejected"`
+}
+
+// connectionLimiter caps how many connections are served at once. A
+// connection arriving while all slots are taken waits in a short queue;
+// when the queue is full too, it is turned away.
+type connectionLimiter struct {
+	limit int
+	slots chan struct{} // nil = no limit
+	queue chan struct{}
+
+	current  atomic.Int64
+	peak     atomic.Int64
+	rejected atomic.Uint64
+}
+
+// newConnectionLimiter returns a limiter for limit connections (0 = no limit)
+func newConnectionLimiter(limit int) *connectionLimiter {
+	l := &connectionLimiter{limit: limit, queue: make(chan struct{}, connectionQueueSize)}
+	if limit > 0 {
+		l.slots = make(chan struct{}, limit)
+	}
+	return l
+}
+
+// tryAcquire takes a slot if one is free
+func (l *connectionLimiter) tryAcquire() bool {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	l.opened()
+	return true
+}
+
+// acquireWithin waits up to timeout for a slot
+func (l *connectionLimiter) acquireWithin(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		l.opened()
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// opened counts a connection that got a slot
+func (l *connectionLimiter) opened() {
+	current := l.current.Add(1)
+	for {
+		peak := l.peak.Load()
+		if current <= peak || l.peak.CompareAndSwap(peak, current) {
+			return
+		}
+	}
+}
+
+// release frees the slot of a finished connection
+func (l *connectionLimiter) release() {
+	l.current.Add(-1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// stats returns the current counts
+func (l *connectionLimiter) stats() *ConnectionStats {
+	return &ConnectionStats{
+		Current:  l.current.Load(),
+		Peak:     l.peak.Load(),
+		Limit:    l.limit,
+		Rejected: l.rejected.Load(),
+	}
+}
+
+// admitConnection is called by the accept loop for every new connection. It
+// never blocks: a connection without a free slot waits in its own goroutine,
+// and only connectionQueueSize of those exist at a time.
+func (d *Daemon) admitConnection(conn net.Conn) {
+	limiter := d.connections
+	if limiter.tryAcquire() {
+		go d.serveConnection(conn)
+		return
+	}
+
+	select {
+	case limiter.queue <- struct{}{}:
+		go func() {
+			admitted := limiter.acquireWithin(connectionQueueWait)
+			<-limiter.queue
+			if admitted {
+				d.serveConnection(conn)
+			} else {
+				d.rejectBusy(conn)
+			}
+		}()
+	default:
+		d.rejectBusy(conn)
+	}
+}
+
+// serveConnection handles an admitted connection and frees its slot
+func (d *Daemon) serveConnection(conn net.Conn) {
+	defer d.connections.release()
+	d.handleConnection(conn)
+}
+
+// rejectBusy answers a connection that found no free slot with ERR_BUSY
+func (d *Daemon) rejectBusy(conn net.Conn) {
+	defer conn.Close()
+
+	rejected := d.connections.rejected.Add(1)
+	// Log the first rejection and then every 100th, a reconnect loop
+	// must not flood daemon.log
+	if rejected%100 == 1 {
+		logError("⚠️  Connection limit reached (max_connections %d) - %d connection(s) turned away so far", d.connections.limit, rejected)
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	json.NewEncoder(conn).Encode(SignResponse{
+		Error: "daemon is busy - too many connections, retry later",
+		Code:  codeBusy,
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// With max_connections taken, one more connection waits in the queue and
+// then gets ERR_BUSY; a freed slot lets the next one in
+func TestDaemonConnectionCap(t *testing.T) {
+	testHome(t)
+	limit := 4
+	appConfig.MaxConnections = &limit
+	d := testDaemon(t, addTestAccount(t, ""))
+	serveTestDaemon(t, d)
+
+	ping := func(conn *testConn) SignResponse {
+		t.Helper()
+		var response SignResponse
+		if err := conn.request(t, SignRequest{Method: "ping"}, &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	// Each ping comes back once its connection holds a slot
+	held := make([]*testConn, limit)
+	for i := range held {
+		held[i] = dialTestDaemon(t)
+	}
+	var wg sync.WaitGroup
+	for _, conn := range held {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var response SignResponse
+			if err := conn.request(t, SignRequest{Method: "ping"}, &response); err != nil || response.Code != "" {
+				t.Errorf("connection within the limit: %+v, %v", response, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if current := d.connections.stats().Current; current != int64(limit) {
+		t.Fatalf("%d connections served, want %d", current, limit)
+	}
+
+	started := time.Now()
+	if response := ping(dialTestDaemon(t)); response.Code != codeBusy {
+		t.Errorf("connection over the limit: %+v, want %s", response, codeBusy)
+	}
+	if waited := time.Since(started); waited < connectionQueueWait {
+		t.Errorf("turned away after %v, before the queue wait of %v", waited, connectionQueueWait)
+	}
+	if stats := d.connections.stats(); stats.Rejected != 1 || stats.Peak != int64(limit) {
+		t.Errorf("connection stats %+v, want 1 rejected and a peak of %d", stats, limit)
+	}
+
+	held[0].Close()
+	if response := ping(dialTestDaemon(t)); response.Code != "" {
+		t.Errorf("connection after a slot was freed: %+v", response)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/77elements/noorsigner/pkg/client"
+)
+
+// countersFlushInterval is how often the daemon writes the counters it
+// collected. Counting in memory keeps the disk out of the signing path; a
+// crash loses at most this much.
+const countersFlushInterval = 30 * time.Second
+
+// AccountCounters counts what an account's key did over its lifetime, so
+// unnoticed use stands out (counters.json)
+type AccountCounters = client.AccountCounters
+
+// addCounters adds other's counts to c, keeping the later LastUsed
+func addCounters(c *AccountCounters, other AccountCounters) {
+	c.EventsSigned += other.EventsSigned
+	c.Nip44Encrypts += other.Nip44Encrypts
+	c.Nip44Decrypts += other.Nip44Decrypts
+	c.LastUsed = max(c.LastUsed, other.LastUsed)
+}
+
+// loadAccountCounters loads an account's counters (zero value if none were
+// written yet)
+func loadAccountCounters(npub string) (*AccountCounters, error) {
+	counters := &AccountCounters{}
+
+	store, err := accountStore()
+	if err != nil {
+		return counters, err
+	}
+
+	content, _, err := store.ReadRecord(npub, recordCounters)
+	if os.IsNotExist(err) {
+		return counters, nil
+	}
+	if err != nil {
+		return counters, fmt.Errorf("cannot read counters file: %v", err)
+	}
+
+	if err := json.Unmarshal(content, counters); err != nil {
+		return &AccountCounters{}, fmt.Errorf("invalid counters file: %v", err)
+	}
+
+	return counters, nil
+}
+
+// saveAccountCounters persists an account's counters
+func saveAccountCounters(npub string, counters *AccountCounters) error {
+	store, err := accountStore()
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(counters)
+	if err != nil {
+		return err
+	}
+
+	if err := store.WriteRecords(npub, storeRecord{Name: recordCounters, Data: content}); err != nil {
+		return fmt.Errorf("cannot write counters file: %v", err)
+	}
+
+	return nil
+}
+
+// usageCounters collects the daemon's counts per account until the next
+// flush adds them to counters.json in one write per account
+type usageCounters struct {
+	mu      sync.Mutex
+	pending map[string]*AccountCounters
+}
+
+// count records a use of npub's key
+func (u *usageCounters) count(npub string, use AccountCounters) {
+	use.LastUsed = time.Now().Unix()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.pending == nil {
+		u.pending = make(map[string]*AccountCounters)
+	}
+	if u.pending[npub] == nil {
+		u.pending[npub] = &AccountCounters{}
+	}
+	addCounters(u.pending[npub], use)
+}
+
+// flush writes the pending counts. Those that can't be written stay pending
+// for the next flush; those of removed accounts are dropped.
+func (u *usageCounters) flush() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for npub, pending := range u.pending {
+		// Writing would recreate an account removed meanwhile
+		if !accountExists(npub) {
+			delete(u.pending, npub)
+			continue
+		}
+
+		counters, err := loadAccountCounters(npub)
+		if err != nil {
+			// A damaged file starts over rather than blocking every flush
+			logError("⚠️  %s: %v - counting from zero", displayNpub(npub), err)
+		}
+		addCounters(counters, *pending)
+		if err := saveAccountCounters(npub, counters); err != nil {
+			logError("⚠️  %s: %v", displayNpub(npub), err)
+			continue
+		}
+		delete(u.pending, npub)
+	}
+}
+
+// forget drops npub's pending counts. Holding the lock also waits out a
+// flush in progress, so none rewrites the account while it is removed.
+func (u *usageCounters) forget(npub string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.pending, npub)
+}
+
+// totals returns npub's counters including the counts not yet written
+func (u *usageCounters) totals(npub string) *AccountCounters {
+	counters, err := loadAccountCounters(npub)
+	if err != nil {
+		logError("⚠️  %s: %v", displayNpub(npub), err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if pending := u.pending[npub]; pending != nil {
+		addCounters(counters, *pending)
+	}
+	return counters
+}
+
+// countUse records that a served request used the key of the account it
+// acts as
+func (d *Daemon) countUse(req SignRequest, use AccountCounters) {
+	d.counters.count(d.requestNpub(req), use)
+}
+
+// countersLoop writes the collected counters every countersFlushInterval.
+// shutdownDaemon writes the last ones.
+func (d *Daemon) countersLoop() {
+	ticker := time.NewTicker(countersFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.counters.flush()
+	}
+}
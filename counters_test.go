@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// Concurrent counts and flushes lose nothing: run with -race
+func TestUsageCountersConcurrent(t *testing.T) {
+	testHome(t)
+	npubs := []string{addTestAccount(t, ""), addTestAccount(t, "")}
+
+	const goroutines, increments = 16, 200
+	var counters usageCounters
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			npub := npubs[i%len(npubs)]
+			for j := range increments {
+				counters.count(npub, AccountCounters{EventsSigned: 1, Nip44Encrypts: 2, Nip44Decrypts: 3})
+				if j%50 == 0 {
+					counters.flush()
+				}
+			}
+		}()
+	}
+	// Readers while counting
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				counters.totals(npubs[0])
+			}
+		}()
+	}
+	wg.Wait()
+
+	perAccount := uint64(goroutines / len(npubs) * increments)
+	for _, npub := range npubs {
+		totals := counters.totals(npub)
+		if totals.EventsSigned != perAccount || totals.Nip44Encrypts != 2*perAccount || totals.Nip44Decrypts != 3*perAccount {
+			t.Errorf("%s: %+v, want %d signed", npub, totals, perAccount)
+		}
+	}
+
+	// All of it reaches counters.json
+	counters.flush()
+	for _, npub := range npubs {
+		stored, err := loadAccountCounters(npub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.EventsSigned != perAccount {
+			t.Errorf("%s: %d signed in counters.json, want %d", npub, stored.EventsSigned, perAccount)
+		}
+	}
+	if len(counters.pending) != 0 {
+		t.Errorf("%d accounts still pending after the last flush", len(counters.pending))
+	}
+}
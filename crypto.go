@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -49,24 +48,14 @@ func nsecToPrivateKey(nsec string) (*btcec.PrivateKey, error) {
 }
 
 // privateKeyToNpub converts private key to npub (bech32 format)
-func privateKeyToNpub(privateKey *btcec.PrivateKey) string {
-	pubKeyBytes := schnorr.SerializePubKey(privateKey.PubKey())
-	
-	// Convert to 5-bit encoding for bech32
-	converted, err := bech32.ConvertBits(pubKeyBytes, 8, 5, true)
-	if err != nil {
-		// Fallback to hex if conversion fails
-		return hex.EncodeToString(pubKeyBytes)
-	}
-	
-	// Encode as bech32 with "npub" prefix
-	npub, err := bech32.Encode("npub", converted)
-	if err != nil {
-		// Fallback to hex if encoding fails
-		return hex.EncodeToString(pubKeyBytes)
-	}
-	
-	return npub
+func privateKeyToNpub(privateKey *btcec.PrivateKey) (string, error) {
+	return encodeBech32Key("npub", schnorr.SerializePubKey(privateKey.PubKey()))
+}
+
+// keyMatchesNpub reports whether privateKey is the key of npub
+func keyMatchesNpub(privateKey *btcec.PrivateKey, npub string) bool {
+	derived, err := privateKeyToNpub(privateKey)
+	return err == nil && derived == npub
 }
 
 // signNostrEvent signs a Nostr event with Schnorr signature
@@ -124,19 +113,13 @@ func createEventHash(eventJSON string) ([]byte, error) {
 		content,
 	}
 
-	// Marshal to compact JSON (no whitespace, no HTML escaping)
-	// IMPORTANT: Use encoder with SetEscapeHTML(false) to match JavaScript's JSON.stringify
-	// Go's json.Marshal() escapes <, >, & as \u003c, \u003e, \u0026 by default
-	var buf bytes.Buffer
-	encoder := json.NewEncoder(&buf)
-	encoder.SetEscapeHTML(false)
-	if err := encoder.Encode(serialization); err != nil {
+	// Marshal to compact JSON escaped like JavaScript's JSON.stringify
+	// (see marshalCompact)
+	serialized, err := marshalCompact(serialization)
+	if err != nil {
 		return nil, fmt.Errorf("serialization failed: %v", err)
 	}
 
-	// Remove trailing newline added by Encode()
-	serialized := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
-
 	// SHA-256 hash of serialized array
 	hash := sha256.Sum256(serialized)
 	return hash[:], nil
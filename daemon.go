@@ -1,68 +1,58 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/77elements/noorsigner/pkg/client"
 	"github.com/btcsuite/btcd/btcec/v2"
 )
 
-// NOTE: getSocketPath(), createListener(), cleanupListener(), dialConnection()
-// are defined in daemon_unix.go (Unix) and daemon_windows.go (Windows)
+// Machine-readable error codes returned in the "code" response field
+const (
+	codeConfirmationRequired = "ERR_CONFIRMATION_REQUIRED"
+	codeConfirmationInvalid  = "ERR_CONFIRMATION_INVALID"
+	codeAutostartNotManaged  = "ERR_AUTOSTART_NOT_MANAGED"
+	codeActivityDisabled     = "ERR_ACTIVITY_DISABLED"
+	codeDraining             = "ERR_DRAINING"
+)
 
-// SignRequest represents a signing request via IPC
-type SignRequest struct {
-	ID              string `json:"id"`
-	Method          string `json:"method"`
-	EventJSON       string `json:"event_json,omitempty"`
-	Plaintext       string `json:"plaintext,omitempty"`
-	RecipientPubkey string `json:"recipient_pubkey,omitempty"`
-	Payload         string `json:"payload,omitempty"`
-	SenderPubkey    string `json:"sender_pubkey,omitempty"`
-	// Multi-account fields
-	Pubkey    string `json:"pubkey,omitempty"`
-	Npub      string `json:"npub,omitempty"`
-	Nsec      string `json:"nsec,omitempty"`
-	Password  string `json:"password,omitempty"`
-	SetActive bool   `json:"set_active,omitempty"`
-}
+// errDaemonLocked is returned by key-using methods when no key is loaded
+var errDaemonLocked = errors.New("daemon is locked - no account unlocked")
 
-// SignResponse represents a signing response
-type SignResponse struct {
-	ID        string `json:"id"`
-	Signature string `json:"signature,omitempty"`
-	Error     string `json:"error,omitempty"`
-}
+// NOTE: getSocketPath(), createListener(), cleanupListener(), dialConnection()
+// are defined in daemon_unix.go (Unix) and daemon_windows.go (Windows)
 
-// AccountResponse represents an account in list response
-type AccountResponse struct {
-	Pubkey    string `json:"pubkey"`
-	Npub      string `json:"npub"`
-	CreatedAt int64  `json:"created_at"`
-}
+// The request and response types are defined in pkg/client, which the
+// daemon and its clients share
+type (
+	SignRequest          = client.Request
+	SignResponse         = client.Response
+	AccountResponse      = client.Account
+	ListAccountsResponse = client.ListAccountsResponse
+)
 
-// ListAccountsResponse represents list_accounts response
-type ListAccountsResponse struct {
-	ID           string            `json:"id"`
-	Accounts     []AccountResponse `json:"accounts"`
-	ActivePubkey string            `json:"active_pubkey"`
-	Error        string            `json:"error,omitempty"`
-}
+// How switch_account unlocked the account
+const (
+	unlockedViaPassword     = "password"
+	unlockedViaTrustSession = "trust_session"
+)
 
 // AccountActionResponse represents add/switch/remove account response
-type AccountActionResponse struct {
-	ID      string `json:"id"`
-	Success bool   `json:"success"`
-	Pubkey  string `json:"pubkey,omitempty"`
-	Npub    string `json:"npub,omitempty"`
-	Error   string `json:"error,omitempty"`
-}
+type AccountActionResponse = client.AccountActionResponse
 
 // ActiveAccountResponse represents get_active_account response
 type ActiveAccountResponse struct {
@@ -70,7 +60,36 @@ type ActiveAccountResponse struct {
 	Pubkey     string `json:"pubkey"`
 	Npub       string `json:"npub"`
 	IsUnlocked bool   `json:"is_unlocked"`
-	Error      string `json:"error,omitempty"`
+	// When the trust session expires, and when the daemon locks unless
+	// used before (trust_idle_timeout, see trustidle.go)
+	TrustExpiresAt     int64 `json:"trust_expires_at,omitempty"`
+	TrustIdleExpiresAt int64 `json:"trust_idle_expires_at,omitempty"`
+	// How far the expiry may slide with trust_sliding (see trustslide.go)
+	TrustHardLimitAt int64 `json:"trust_hard_limit_at,omitempty"`
+	// Set while the last key health check failed (see health.go)
+	HealthWarning string `json:"health_warning,omitempty"`
+	// Why and when the daemon last locked, while it is locked (see locklog.go)
+	LockReason  LockReason `json:"lock_reason,omitempty"`
+	LockTrigger string     `json:"lock_trigger,omitempty"`
+	LockedAt    int64      `json:"locked_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// AutostartResponse represents enable_autostart response
+type AutostartResponse struct {
+	ID        string `json:"id"`
+	Signature string `json:"signature,omitempty"`
+	Result    string `json:"result,omitempty"`
+	Replaced  bool   `json:"replaced"`
+	Error     string `json:"error,omitempty"`
+	Code      string `json:"code,omitempty"`
+}
+
+// VersionResponse represents get_version response
+type VersionResponse struct {
+	ID string `json:"id"`
+	BuildAttestation
+	Error string `json:"error,omitempty"`
 }
 
 // Daemon holds the daemon state
@@ -79,13 +98,287 @@ type Daemon struct {
 	npub       string
 	pubkey     string
 	listener   net.Listener
+	config     *Config
+	noTrust    bool // --no-trust: never write trust sessions, key lives in memory only
 	shutdown   chan bool
-	mu         sync.RWMutex // Protects privateKey, npub, pubkey during account switch
+	mu         sync.RWMutex // Protects privateKey, npub, pubkey, unlocked during account switch
+
+	// NIP-44 conversation keys for the unlocked account (see nip44batch.go)
+	convKeys conversationKeyCache
+
+	// Accounts unlocked with unlock_account besides the active one, by npub
+	// (see accountselect.go)
+	unlocked map[string]*btcec.PrivateKey
+
+	// Per-connection state
+	nextConnID    atomic.Uint64
+	confirmations map[string]*pendingConfirmation // Strict-confirmation tokens (see confirm.go)
+	confirmMu     sync.Mutex
+
+	// Runs approval_command one request at a time (see approval.go)
+	approvalMu sync.Mutex
+
+	// kind_policy and the requests waiting for confirmation (see policy.go)
+	policy             *signingPolicy
+	pendingApprovals   map[string]*pendingApproval
+	pendingApprovalsMu sync.Mutex
+
+	// Prompts on the terminal with --ask, else nil (see ask.go)
+	asker *terminalAsker
+
+	// Event stream subscribers (see stream.go)
+	subscribers map[chan StreamEvent]struct{}
+	subMu       sync.Mutex
+
+	// Request counters for the metrics textfile and stats (see metrics.go)
+	metrics daemonMetrics
+
+	// Lifetime counts per account, flushed to counters.json (see counters.go)
+	counters usageCounters
+
+	// Pings answered since the start (see ping.go)
+	pings atomic.Uint64
+
+	// Recent audited requests for get_recent_activity (see activity.go)
+	activity *activityRing
+
+	// Desktop notifications (see notify.go)
+	notifier *notifier
+
+	// How long a connection may take to send a request (see requestlimit.go)
+	requestTimeout time.Duration
+
+	// Cap on concurrently served connections (see connlimit.go)
+	connections *connectionLimiter
+
+	// Signing and encryption requests per client (see ratelimit.go)
+	rateLimits *rateLimiter
+
+	// Who asked for what, for get_status (see clientident.go)
+	requests requestHistory
+
+	// Why the daemon locked, for get_status (see locklog.go)
+	locks lockHistory
+
+	// Cached account list, dropped when the accounts change (see accountwatch.go)
+	accountWatch *accountWatcher
+
+	// Optional abstract socket listener and why it isn't up (see abstract.go)
+	abstractListener net.Listener
+	abstractErr      error
+
+	// Optional HTTP API and the SHA-256 of its bearer token (see httpapi.go)
+	httpAddr      string
+	httpServer    *http.Server
+	httpTokenHash []byte
+
+	// Drain mode (see drain.go)
+	inFlight      atomic.Int64 // Requests being handled, drain-exempt methods excluded
+	draining      atomic.Bool
+	drainDeadline time.Time
+	drainMu       sync.Mutex // Protects drainDeadline
+
+	// Locks after trust_idle_timeout without use (see trustidle.go)
+	idle *idleTracker
+
+	// When the trust session was last extended, Unix time (see trustslide.go)
+	trustSlidAt atomic.Int64
+
+	// Locks when the trust session expires (see trustexpiry.go)
+	expiry *trustExpiry
+
+	// Credential requests waiting for an operator (see pending.go)
+	pendingCredentials map[string]*PendingCredential
+	credMu             sync.Mutex
+
+	// NIP-46 clients served over relays, by client pubkey (see nip46.go)
+	nip46Sessions map[string]*nip46Session
+	nip46Mu       sync.Mutex
+
+	// The bunker listener and its unused URIs, under nip46Mu (see bunker.go)
+	bunker       *nip46Session
+	bunkerOffers []bunkerOffer
+}
+
+// connSession holds state scoped to a single client connection
+type connSession struct {
+	id uint64
+	// awaitingConfirmation is set while a confirmation token issued on this
+	// connection may still be redeemed (see requestDeadline)
+	awaitingConfirmation bool
+
+	// Request IDs used on this connection (see requestid.go)
+	ids requestIDs
+
+	// The request being served and whether it counts as in flight, for
+	// recoverConnection
+	request  SignRequest
+	inFlight bool
+
+	// client is the authorized client of the current request (see clients.go)
+	client string
+
+	// The connecting process and its executable (see clientident.go)
+	peer peerCred
+	exe  string
 }
 
-// startDaemon starts the key signing daemon
-func startDaemon() {
-	fmt.Println("🔐 Starting NoorSigner Daemon")
+// startDaemon starts the key signing daemon. prompt asks for the password
+// and, with no account yet, for the first one.
+func startDaemon(prompt prompter, args []string) error {
+	// --foreground: stay attached for process supervisors (launchd, systemd, runit)
+	// --no-trust: keep the key in memory only, never write a trust session
+	// --ask: ask on the terminal before signing or decrypting (needs --foreground)
+	// --strict: strict mode, as with "strict": true in config.json (see strict.go)
+	// --password-file/--password-fd: unlock without a prompt (see password.go)
+	// --http ADDR: also serve the methods over HTTP (see httpapi.go)
+	// --unsafe-bind: allow --http on a non-loopback address
+	usage := "noorsigner daemon [--foreground] [--no-trust] [--ask] [--strict] [--http 127.0.0.1:PORT [--unsafe-bind]] " + passwordFlagsUsage
+	foreground := false
+	noTrust := false
+	ask := false
+	strict := false
+	httpAddr := ""
+	unsafeBind := false
+	rest, err := parsePasswordFlags(args)
+	if err != nil {
+		return commandFailed(1, "%v\nUsage: %s", err, usage)
+	}
+	for i := 0; i < len(rest); i++ {
+		arg := rest[i]
+		if value, ok := strings.CutPrefix(arg, "--http="); ok {
+			httpAddr = value
+			continue
+		}
+		switch arg {
+		case "--http":
+			if i+1 >= len(rest) {
+				return commandFailed(1, "❌ --http needs an address\nUsage: %s", usage)
+			}
+			i++
+			httpAddr = rest[i]
+		case "--unsafe-bind":
+			unsafeBind = true
+		case "--foreground", "-f":
+			foreground = true
+		case "--no-trust":
+			noTrust = true
+		case "--ask":
+			ask = true
+		case "--strict":
+			strict = true
+		default:
+			return commandFailed(1, "Unknown option: %s\nUsage: %s", arg, usage)
+		}
+	}
+	if ask && (!foreground || !stdinIsInteractive()) {
+		return commandFailed(1, "❌ --ask needs --foreground and a terminal - the daemon asks on it before each signature")
+	}
+	if unsafeBind && httpAddr == "" {
+		return commandFailed(1, "❌ --unsafe-bind only applies to --http")
+	}
+	if httpAddr != "" {
+		if err := checkHTTPBind(httpAddr, unsafeBind); err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+	}
+
+	// main() already loaded config.json - it decides where and how much we log.
+	// A broken config must not keep the daemon down.
+	config, configErr := appConfig, appConfigErr
+	var strictOverrides []string
+	if strict || config.strictEnabled() {
+		strictOverrides = applyStrictProfile(config)
+		noTrust = true
+	}
+
+	// The forked background process logs to daemon.log; the parent that
+	// forks it prints to the terminal; a foreground daemon logs to stderr
+	forked := os.Getenv("NOORSIGNER_FORKED") == "1"
+	if forked {
+		passwordSource = nil // The parent already unlocked - never read the password twice
+	}
+	var logErr error
+	switch {
+	case foreground:
+		logErr = initDaemonLog(config.LogLevel, false, os.Stderr)
+	case forked:
+		logErr = initDaemonLog(config.LogLevel, true, nil)
+	default:
+		logErr = initDaemonLog(config.LogLevel, false, os.Stdout)
+	}
+	if logErr != nil {
+		logError("⚠️  %v", logErr)
+	}
+
+	logInfo("🔐 Starting NoorSigner Daemon")
+	if configErr != nil {
+		logError("⚠️  %v - using defaults", configErr)
+	}
+	for _, warning := range config.Warnings {
+		logError("⚠️  config.json: %s", warning)
+	}
+	if config.strictEnabled() {
+		logInfo("🛡️  Strict mode: no trust sessions, memory locked, %s confirmed", describeStrictKinds())
+		for _, override := range strictOverrides {
+			logInfo("   strict mode overrides %s", override)
+		}
+	}
+
+	if err := runDaemon(config, prompt, foreground, forked, noTrust, ask, httpAddr); err != nil {
+		reportStartupFailure(err)
+		return &commandError{Code: startupExitCode(err)}
+	}
+	return nil
+}
+
+// newDaemon returns a daemon with config and no account loaded
+func newDaemon(config *Config, noTrust bool, requestTimeout time.Duration, httpAddr string) *Daemon {
+	daemon := &Daemon{
+		config:        config,
+		noTrust:       noTrust,
+		shutdown:      make(chan bool, 1),
+		subscribers:   make(map[chan StreamEvent]struct{}),
+		confirmations: make(map[string]*pendingConfirmation),
+
+		pendingCredentials: make(map[string]*PendingCredential),
+		metrics:            daemonMetrics{startTime: time.Now()},
+		activity:           newActivityRing(config.recentActivitySize()),
+		notifier:           newNotifier(config, time.Now, func(delay time.Duration, f func()) { time.AfterFunc(delay, f) }, sendDesktopNotification),
+		requestTimeout:     requestTimeout,
+		connections:        newConnectionLimiter(config.maxConnections()),
+		rateLimits:         newRateLimiter(config),
+		policy:             newSigningPolicy(config),
+		pendingApprovals:   make(map[string]*pendingApproval),
+		nip46Sessions:      make(map[string]*nip46Session),
+		httpAddr:           httpAddr,
+	}
+	daemon.accountWatch = newAccountWatcher(daemon.accountsChanged)
+	daemon.idle = newIdleTracker(config.trustIdleTimeout(), time.Now,
+		func(delay time.Duration, f func()) { time.AfterFunc(delay, f) },
+		touchAccountTrustSession, daemon.expireIdle)
+	daemon.expiry = newTrustExpiry(time.Now,
+		func(delay time.Duration, f func()) { time.AfterFunc(delay, f) }, daemon.expireTrust)
+	return daemon
+}
+
+// runDaemon walks through the startup phases and serves until shutdown.
+// Failures come back as *StartupError naming the phase (see startup.go).
+func runDaemon(config *Config, prompt prompter, foreground, forked, noTrust, ask bool, httpAddr string) error {
+	// Refuse to start a second instance (also cleans up stale pidfiles)
+	if err := checkDaemonNotRunning(); err != nil {
+		return &StartupError{Phase: phaseInstanceCheck, Err: err}
+	}
+
+	// Strict mode keeps the key out of swap - before it is ever loaded
+	if config.strictEnabled() {
+		if err := lockMemory(); err != nil {
+			return startupFailure(phaseMemoryLock, errMemoryLock, err)
+		}
+	}
+
+	// Account directories must be named by their lowercase npub
+	fixAccountCase()
 
 	// Get active account
 	activeNpub, err := loadActiveAccount()
@@ -95,7 +388,9 @@ func startDaemon() {
 		if listErr != nil || len(accounts) == 0 {
 			fmt.Println("⚠️  No accounts found - initializing...")
 			fmt.Println()
-			addAccount()
+			if err := addAccount(prompt, ""); err != nil {
+				return startupFailure(phaseActiveAccount, errNoActiveAccount, err)
+			}
 			fmt.Println()
 			fmt.Println("✅ Initialization complete! Starting daemon...")
 			fmt.Println()
@@ -103,144 +398,248 @@ func startDaemon() {
 			// Reload active account
 			activeNpub, err = loadActiveAccount()
 			if err != nil {
-				fmt.Printf("Error loading active account: %v\n", err)
-				return
+				return startupFailure(phaseActiveAccount, errNoActiveAccount, err)
 			}
 		} else {
 			// Accounts exist but no active account - set first one as active
 			activeNpub = accounts[0].Npub
 			if err := saveActiveAccount(activeNpub); err != nil {
-				fmt.Printf("Error setting active account: %v\n", err)
-				return
+				return startupFailure(phaseActiveAccount, errNoActiveAccount, err)
 			}
 		}
 	}
 
+	// A freeze refuses every unlock, autostart included (see freeze.go)
+	if err := checkNotFrozen(); err != nil {
+		return &StartupError{Phase: phaseUnlock, Err: err}
+	}
+
 	// Load encrypted key for active account
 	encryptedKey, err := loadAccountEncryptedKey(activeNpub)
 	if err != nil {
-		fmt.Printf("Error loading account key: %v\n", err)
-		return
+		return startupFailure(phaseLoadKey, errKeyUnreadable, err)
 	}
 
 	// Check for existing trust session first
-	var nsec string
-	fmt.Println("🔍 Checking for existing Trust Mode session...")
-	trustSession, err := loadAccountTrustSession(activeNpub)
-	if err != nil {
-		fmt.Printf("   No trust session found: %v\n", err)
+	var privateKey *btcec.PrivateKey
+	var trustSession *TrustSession
+	awaitCredentials := false
+	handoff := forked && os.Getenv("NOORSIGNER_KEY_HANDOFF") == "1"
+	if noTrust {
+		// Trust Mode disabled - a session left over from earlier runs must go
+		if hasAccountTrustSession(activeNpub) {
+			logInfo("🗑️  Removing existing Trust Mode session (--no-trust)")
+		}
+		if err := clearAccountTrustSession(activeNpub); err != nil {
+			return startupFailure(phaseTrustSession, errTrustSessionInvalid, err)
+		}
 	} else {
-		fmt.Printf("   Trust session found, expires: %s\n", trustSession.ExpiresAt.Format("15:04:05"))
-		valid := isTrustSessionValid(trustSession)
-		fmt.Printf("   Session valid: %v\n", valid)
+		logDebug("🔍 Checking for existing Trust Mode session...")
+		trustSession, err = loadAccountTrustSession(activeNpub)
+		if err != nil {
+			logDebug("   No trust session found: %v", err)
+		} else {
+			logDebug("   Trust session found, expires: %s", trustSession.ExpiresAt.Format("15:04:05"))
+			valid := isTrustSessionValid(trustSession)
+			logDebug("   Session valid: %v", valid)
+			err := checkTrustSessionBinding(trustSession)
+			switch {
+			case !valid || err == nil:
+			case errors.Is(err, errKeyringUnavailable):
+				// The keyring may be back next time - keep the session
+				logInfo("⚠️  Cannot use the trust session: %v", err)
+				trustSession = nil
+			default:
+				// Copied from elsewhere, or its token is gone - useless
+				// here, so ask for the password
+				logInfo("🗑️  Removing the trust session: %v", err)
+				clearAccountTrustSession(activeNpub)
+				trustSession = nil
+			}
+		}
 	}
 
-	if err == nil && isTrustSessionValid(trustSession) {
+	if handoff {
+		// Forked child of a --no-trust daemon - the parent passes the key on stdin
+		privateKey, err = readKeyHandoff(os.Stdin)
+		if err != nil || !keyMatchesNpub(privateKey, activeNpub) {
+			return startupFailure(phaseUnlock, errKeyCorrupted, err)
+		}
+		logInfo("🔓 Key received from parent process (Trust Mode disabled)")
+	} else if trustSession != nil && isTrustSessionValid(trustSession) {
 		// Valid trust session exists - decrypt cached nsec
-		fmt.Printf("✅ Found valid Trust Mode session (expires: %s)\n",
+		logInfo("✅ Found valid Trust Mode session (expires: %s)",
 			trustSession.ExpiresAt.Format("15:04:05"))
-		fmt.Println("🔓 Daemon unlocked via Trust Mode - no password required!")
+		logInfo("🔓 Daemon unlocked via Trust Mode - no password required!")
 
 		// Decrypt cached nsec from trust session
-		nsec, err = decryptTrustSessionNsec(trustSession)
+		nsec, err := decryptTrustSessionNsec(trustSession)
+		if err == nil {
+			privateKey, err = nsecToPrivateKey(nsec)
+		}
 		if err != nil {
-			fmt.Printf("Error decrypting trust session: %v\n", err)
 			// Clear invalid trust session
 			clearAccountTrustSession(activeNpub)
-			return
+			return startupFailure(phaseTrustSession, errTrustSessionInvalid, err)
 		}
+
+		// Clear nsec from memory for security
+		for i := range nsec {
+			nsec = nsec[:i] + "x" + nsec[i+1:]
+		}
+	} else if passwordSource == nil && !canPromptForPassword() {
+		// Headless - nobody to prompt, start locked and queue a credential request
+		logInfo("🔒 No terminal available - starting locked")
+		fmt.Println("   Unlock from any terminal with: noorsigner pending, then noorsigner respond <nonce>")
+		awaitCredentials = true
 	} else {
-		// No valid trust session - create one (Trust Mode is mandatory for daemon)
+		// No valid trust session - create one unless Trust Mode is disabled
 		fmt.Println()
-		fmt.Println("🛡️  NoorSigner uses Trust Mode for background operation")
-		fmt.Println("   Your password will be cached for 24 hours")
+		if noTrust {
+			fmt.Println("🛡️  Trust Mode disabled - the key is kept in memory only")
+			fmt.Println("   You will be asked for your password on every daemon start")
+		} else {
+			fmt.Println("🛡️  NoorSigner uses Trust Mode for background operation")
+			fmt.Printf("   Your password will be cached for %s\n", describeDuration(trustDuration()))
+		}
 		fmt.Println()
 
-		password, err := readPassword("Enter password to unlock NoorSigner daemon: ")
-		if err != nil {
-			fmt.Printf("Error reading password: %v\n", err)
-			return
+		// Don't ask for a password that can't be tried yet
+		if err := checkLockout(activeNpub); err != nil {
+			return &StartupError{Phase: phaseUnlock, Err: err}
 		}
 
-		// Test password first
-		nsec, err = decryptNsec(encryptedKey, password)
-		if err != nil {
-			fmt.Println("❌ Invalid password!")
-			return
+		// An enrolled security key is asked first; the password is the
+		// fallback unless the enrollment turned it off (see fido.go)
+		var nsec string
+		enrollment, _ := loadFidoEnrollment(activeNpub)
+		if enrollment != nil && passwordSource == nil {
+			nsec, privateKey, err = fidoUnlockKey(prompt, activeNpub, enrollment)
+			if err != nil && enrollment.PasswordDisabled {
+				return startupFailure(phaseUnlock, errPasswordDisabled, err)
+			}
+			if err != nil {
+				fmt.Printf("⚠️  %v\n", err)
+				fmt.Println("   Falling back to the password")
+			}
+		}
+
+		if privateKey == nil {
+			var password string
+			password, err = prompt.readAccountPassword("Enter password to unlock NoorSigner daemon: ")
+			if err != nil {
+				return startupFailure(phaseUnlock, errPasswordUnavailable, err)
+			}
+
+			// A wrong password decrypts to garbage, so check it yields this account's key
+			nsec, privateKey, err = decryptAccountKey(encryptedKey, activeNpub, password)
+		}
+		var lockout *lockoutError
+		switch {
+		case errors.Is(err, errBadPassword) && errors.As(err, &lockout):
+			return startupFailure(phaseUnlock, errInvalidPassword, lockout)
+		case errors.Is(err, errBadPassword):
+			return startupFailure(phaseUnlock, errInvalidPassword, nil)
+		case errors.Is(err, errLockedOut), errors.Is(err, errPasswordDisabled):
+			return &StartupError{Phase: phaseUnlock, Err: err}
+		case err != nil:
+			return startupFailure(phaseUnlock, errKeyCorrupted, err)
 		}
 
 		// Create and save trust session with cached nsec
-		session, err := createTrustSession(nsec)
-		if err != nil {
-			fmt.Printf("Error creating trust session: %v\n", err)
-			return
+		var session *TrustSession
+		if !noTrust {
+			session, err = createTrustSession(nsec)
+			if err == nil {
+				err = saveAccountTrustSession(activeNpub, session)
+			}
+			if errors.Is(err, errKeyringUnavailable) {
+				// Not a reason to refuse the key the password just unlocked
+				logInfo("⚠️  Trust Mode off for this run: %v", err)
+				logInfo("   To keep the token in the session file instead: noorsigner config set trust_token_store file")
+				session, err = nil, nil
+			}
+			if err != nil {
+				return startupFailure(phaseTrustSession, errTrustSessionInvalid, err)
+			}
 		}
 
-		if err := saveAccountTrustSession(activeNpub, session); err != nil {
-			fmt.Printf("Error saving trust session: %v\n", err)
-			return
+		// Clear nsec from memory for security
+		for i := range nsec {
+			nsec = nsec[:i] + "x" + nsec[i+1:]
 		}
 
-		fmt.Printf("✅ Trust Mode activated until %s\n", session.ExpiresAt.Format("15:04:05"))
+		if session != nil {
+			logInfo("✅ Trust Mode activated until %s", session.ExpiresAt.Format("15:04:05"))
+		}
 	}
 
-	// Convert to private key and keep in memory
-	privateKey, err := nsecToPrivateKey(nsec)
+	// Get pubkey
+	pubkey, err := npubToPubkey(activeNpub)
 	if err != nil {
-		fmt.Printf("Error with decrypted nsec: %v\n", err)
-		return
+		return startupFailure(phaseActiveAccount, errNoActiveAccount, err)
 	}
 
-	// Clear nsec from memory for security
-	for i := range nsec {
-		nsec = nsec[:i] + "x" + nsec[i+1:]
-	}
-
-	// Get pubkey
-	pubkey, err := npubToPubkey(activeNpub)
+	requestTimeout, err := parseRequestTimeout(config.RequestTimeout)
 	if err != nil {
-		fmt.Printf("Error getting pubkey: %v\n", err)
-		return
+		logError("⚠️  %v - using %s", err, defaultRequestTimeout)
+		requestTimeout = defaultRequestTimeout
 	}
 
 	// Create daemon instance
-	daemon := &Daemon{
-		privateKey: privateKey,
-		npub:       activeNpub,
-		pubkey:     pubkey,
-		shutdown:   make(chan bool, 1),
-	}
+	daemon := newDaemon(config, noTrust, requestTimeout, httpAddr)
+	daemon.privateKey, daemon.npub, daemon.pubkey = privateKey, activeNpub, pubkey
+	loadedKeys.add(privateKey)
 
 	socketPath, err := getSocketPath()
 	if err != nil {
-		fmt.Printf("Error getting socket path: %v\n", err)
-		return
+		return startupFailure(phaseListener, errListenFailed, err)
 	}
 
-	fmt.Printf("✅ Daemon unlocked for: %s\n", activeNpub)
-	fmt.Printf("📡 Listening on: %s\n", socketPath)
+	if awaitCredentials {
+		logInfo("🔒 Daemon locked, waiting for credentials for: %s", displayNpub(activeNpub))
+	} else {
+		logInfo("✅ Daemon unlocked for: %s", displayNpub(activeNpub))
+	}
+	logInfo("📡 Listening on: %s", socketPath)
+	var httpToken string
+	if httpAddr != "" {
+		if httpToken, err = daemon.setupHTTPToken(forked); err != nil {
+			return startupFailure(phaseListener, errListenFailed, err)
+		}
+	}
 	fmt.Println()
 
-	// Fork to background (Trust Mode is always active)
-	shouldFork := os.Getenv("NOORSIGNER_FORKED") != "1"
-
-	if shouldFork {
+	// Fork to background. The child unlocks from the trust session, or
+	// with --no-trust receives the key from us over a pipe.
+	if !forked && !foreground {
 		// Fork to background by re-executing ourselves
 		// Use absolute path to avoid Windows security restrictions
 		exePath, err := os.Executable()
 		if err != nil {
-			fmt.Printf("Failed to get executable path: %v\n", err)
-			return
+			return startupFailure(phaseFork, errForkFailed, err)
 		}
 		cmd := exec.Command(exePath, os.Args[1:]...)
 		cmd.Env = append(os.Environ(), "NOORSIGNER_FORKED=1")
+		if httpAddr != "" {
+			cmd.Env = append(cmd.Env, httpTokenHashEnv+"="+hex.EncodeToString(daemon.httpTokenHash))
+		}
 
 		// Detach from terminal (Unix only)
 		cmd.SysProcAttr = getSysProcAttr()
 
+		if noTrust && privateKey != nil {
+			keyPipe, err := writeKeyHandoff(privateKey)
+			if err != nil {
+				return startupFailure(phaseFork, errForkFailed, err)
+			}
+			defer keyPipe.Close()
+			cmd.Stdin = keyPipe
+			cmd.Env = append(cmd.Env, "NOORSIGNER_KEY_HANDOFF=1")
+		}
+
 		if err := cmd.Start(); err != nil {
-			fmt.Printf("Failed to fork daemon: %v\n", err)
-			return
+			return startupFailure(phaseFork, errForkFailed, err)
 		}
 
 		// Parent process - show success and exit
@@ -248,37 +647,168 @@ func startDaemon() {
 		fmt.Printf("   (PID: %d)\n", cmd.Process.Pid)
 		fmt.Println()
 		fmt.Println("   You can close this window now.")
-		os.Exit(0)
+		if logFile, err := getLogFilePath(); err == nil {
+			fmt.Printf("   Log: %s\n", logFile)
+		}
+		printHTTPToken(httpAddr, httpToken)
+		return nil
+	}
+	printHTTPToken(httpAddr, httpToken)
+
+	if ask {
+		// The password prompt is done - from now on the terminal is the asker's
+		daemon.asker = newTerminalAsker(os.Stdin, os.Stderr, config.askTimeout())
+		logInfo("✋ Asking on this terminal before each signature and decryption (timeout %s)", daemon.asker.timeout)
+	}
+
+	// Start server (in the forked child, or right here with --foreground)
+	return daemon.serve()
+}
+
+// writeKeyHandoff puts a private key into a pipe for the forked child of a
+// --no-trust daemon and returns the read end (used as the child's stdin).
+// The key never touches the disk.
+func writeKeyHandoff(privateKey *btcec.PrivateKey) (*os.File, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create key pipe: %v", err)
+	}
+
+	keyBytes := privateKey.Serialize()
+	keyHex := []byte(hex.EncodeToString(keyBytes))
+	_, err = writer.Write(keyHex)
+	writer.Close()
+
+	// Clear key copies from memory
+	for i := range keyBytes {
+		keyBytes[i] = 0
+	}
+	for i := range keyHex {
+		keyHex[i] = 0
 	}
 
-	// Start server (in background for Trust Mode, foreground for Normal Mode)
-	if err := daemon.serve(); err != nil {
-		fmt.Printf("Daemon error: %v\n", err)
-		os.Exit(1)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("cannot write key pipe: %v", err)
 	}
+
+	return reader, nil
+}
+
+// readKeyHandoff reads the private key passed by writeKeyHandoff
+func readKeyHandoff(r io.Reader) (*btcec.PrivateKey, error) {
+	keyHex, err := io.ReadAll(io.LimitReader(r, 128))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key from parent: %v", err)
+	}
+
+	keyBytes, err := hex.DecodeString(strings.TrimSpace(string(keyHex)))
+	for i := range keyHex {
+		keyHex[i] = 0
+	}
+	if err != nil || len(keyBytes) != 32 {
+		return nil, fmt.Errorf("invalid key from parent")
+	}
+
+	privateKey, _ := btcec.PrivKeyFromBytes(keyBytes)
+	for i := range keyBytes {
+		keyBytes[i] = 0
+	}
+
+	return privateKey, nil
 }
 
 // serve starts the IPC server (Unix socket or Windows Named Pipe)
 func (d *Daemon) serve() error {
+	// Claim the pidfile before binding so a second instance can't hijack the socket
+	if err := writePidFile(); err != nil {
+		return &StartupError{Phase: phaseInstanceCheck, Err: err}
+	}
+
 	// Create platform-specific listener
 	listener, err := createListener()
 	if err != nil {
-		return fmt.Errorf("failed to create listener: %v", err)
+		removePidFile()
+		if errors.Is(err, errDaemonRunning) {
+			return &StartupError{Phase: phaseInstanceCheck, Err: err}
+		}
+		return startupFailure(phaseListener, errListenFailed, err)
 	}
 	d.listener = listener
+	d.startAbstractListener()
+	if err := d.startHTTPListener(); err != nil {
+		listener.Close()
+		cleanupListener()
+		removePidFile()
+		return startupFailure(phaseListener, errListenFailed, err)
+	}
 
 	// Handle shutdown signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
-		<-sigChan
-		fmt.Println("\n🔒 Shutting down daemon...")
-		d.shutdownDaemon()
+		sig := <-sigChan
+		logInfo("🔒 Shutting down daemon...")
+		trigger := "SIGINT"
+		if sig == syscall.SIGTERM {
+			trigger = "SIGTERM"
+		}
+		d.shutdownDaemon(LockEvent{Reason: lockReasonShutdown, Trigger: trigger})
 		os.Exit(0)
 	}()
 
-	fmt.Println("Daemon ready for signing requests")
+	logInfo("Daemon ready for signing requests")
+
+	d.mu.RLock()
+	startedEvent := StreamEvent{Type: "daemon_started", Npub: d.npub, Pubkey: d.pubkey}
+	locked := d.privateKey == nil
+	d.mu.RUnlock()
+	d.emit(startedEvent)
+	if startedEvent.Npub != "" {
+		if locked {
+			// Started headless without a trust session
+			d.requestCredential(startedEvent.Npub, "daemon started without a terminal")
+		} else {
+			d.emit(StreamEvent{Type: "unlocked", Npub: startedEvent.Npub, Pubkey: startedEvent.Pubkey})
+			d.watchTrustSession(startedEvent.Npub)
+		}
+	}
+
+	// Notice account changes made by the CLI (see accountwatch.go)
+	d.accountWatch.start()
+
+	// Per-account signing counters (see counters.go)
+	go d.countersLoop()
+
+	// Periodic key integrity check (opt-in via config)
+	if interval, err := parseHealthCheckInterval(d.config.HealthCheckInterval); err != nil {
+		logError("⚠️  %v - key health check disabled", err)
+	} else if interval > 0 {
+		go d.healthCheckLoop(interval)
+	}
+
+	// Signed daily receipts of the audit log (opt-in via config)
+	if at, err := parseReceiptTime(d.config.ReceiptTime); err != nil {
+		logError("⚠️  %v - receipts disabled", err)
+	} else if at >= 0 {
+		go d.receiptLoop(at)
+	}
+
+	// Keep the autostart entry in line with config.json (if set)
+	applyAutostartPreference(d.config.Autostart)
+
+	// Prometheus textfile output (opt-in via config)
+	if d.config.MetricsTextfile != "" {
+		if interval, err := parseMetricsInterval(d.config.MetricsInterval); err != nil {
+			logError("⚠️  %v - metrics textfile disabled", err)
+		} else {
+			go d.metricsTextfileLoop(d.config.MetricsTextfile, interval)
+		}
+	}
+
+	// NIP-46 clients accepted with 'noorsigner connect'
+	d.startNIP46Sessions()
 
 	// Accept connections
 	for {
@@ -293,80 +823,393 @@ func (d *Daemon) serve() error {
 				case <-d.shutdown:
 					return nil
 				default:
-					fmt.Printf("Accept error: %v\n", err)
+					logError("Accept error: %v", err)
 					continue
 				}
 			}
 
-			// Handle connection in goroutine
-			go d.handleConnection(conn)
+			// Handle connection in goroutine, within max_connections
+			d.admitConnection(conn)
 		}
 	}
 }
 
-// handleConnection handles a single client connection
+// handleConnection handles a client connection. Clients may send several
+// newline-delimited requests over one connection; each gets one response.
 func (d *Daemon) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
-	decoder := json.NewDecoder(conn)
-	encoder := json.NewEncoder(conn)
+	peer, admitted := d.admitPeer(conn)
+	if !admitted {
+		return
+	}
 
-	var req SignRequest
-	if err := decoder.Decode(&req); err != nil {
-		response := SignResponse{
-			ID:    req.ID,
-			Error: fmt.Sprintf("Invalid request format: %v", err),
+	limiter := &requestLimitReader{r: conn}
+	decoder := json.NewDecoder(limiter)
+	recorder := &responseRecorder{w: conn, metrics: &d.metrics}
+	encoder := json.NewEncoder(recorder)
+	session := &connSession{id: d.nextConnID.Add(1), peer: peer, exe: peerExecutable(peer.PID)}
+	logDebug("conn %d: opened by %s", session.id, ClientIdentity{PeerPID: peer.PID, Exe: session.exe})
+	defer logDebug("conn %d: closed", session.id)
+	defer d.recoverConnection(conn, session, encoder)
+
+	for {
+		// A client that stalls or streams an endless document must not hold
+		// the connection (and its memory) forever
+		conn.SetReadDeadline(d.requestDeadline(session))
+		limiter.reset()
+
+		var req SignRequest
+		if err := decoder.Decode(&req); err != nil {
+			if err == io.EOF {
+				return // Client closed the connection
+			}
+			response := SignResponse{
+				ID:    req.ID,
+				Error: fmt.Sprintf("Invalid request format: %v", err),
+				Code:  codeInvalidRequest,
+			}
+			switch {
+			case errors.Is(err, errRequestTooLarge):
+				response.Error = err.Error()
+				response.Code = codeRequestTooLarge
+			case isTimeout(err) && limiter.read == 0 && onlyWhitespace(decoder.Buffered()):
+				logDebug("conn %d: idle for too long", session.id)
+				return
+			case isTimeout(err):
+				response.Error = "request timed out"
+				response.Code = codeRequestTimeout
+			}
+			logError("conn %d: invalid request format: %v", session.id, err)
+			conn.SetWriteDeadline(time.Now().Add(time.Second))
+			encoder.Encode(response)
+			return
 		}
+		// Handlers and subscriptions read without the request deadline
+		conn.SetReadDeadline(time.Time{})
+		received := time.Now()
+		session.awaitingConfirmation = false
+		session.request = req
+
+		d.recordRequest(session, req)
+		d.metrics.countRequest(req.Method)
+
+		// IDs correlate responses, so a connection can't reuse one. A
+		// confirmation resubmission repeats its request on purpose.
+		if req.ID != "" && req.ConfirmationToken == "" && !session.ids.use(req.ID) {
+			encoder.Encode(SignResponse{
+				ID:    req.ID,
+				Error: fmt.Sprintf("request id %q was already used on this connection", req.ID),
+				Code:  codeDuplicateID,
+			})
+			continue
+		}
+
+		// get_protocol_version answers any version so clients can negotiate
+		if response := checkProtocolVersion(req); response != nil && req.Method != "get_protocol_version" {
+			encoder.Encode(response)
+			continue
+		}
+
+		if response := d.authorizeRequest(session, req); response != nil {
+			encoder.Encode(response)
+			continue
+		}
+
+		// While draining only drain-exempt methods are served
+		if !d.beginRequest(req.Method) {
+			encoder.Encode(d.drainingResponse(req.ID))
+			continue
+		}
+		session.inFlight = true
+
+		recorder.capture = auditedMethods[req.Method]
+		if req.Method == "subscribe" {
+			// A subscription lasts as long as the client wants - drain must not wait for it
+			d.endRequest(req.Method)
+			session.inFlight = false
+			d.handleRequest(conn, session, req, encoder)
+			return
+		}
+		d.runRequest(conn, session, req, encoder)
+		d.metrics.latency.record(time.Since(received))
+		if approvalMethods[req.Method] && recorder.last.Error == "" {
+			// Served: extends the trust session with trust_sliding
+			d.slideTrustSession()
+		}
+		d.endRequest(req.Method)
+		session.inFlight = false
+		d.recordActivity(session, req, recorder.last)
+	}
+}
+
+// runRequest serves a request that passed the connection's checks: the
+// rate limit, the signing policy, approval_command and the --ask prompt may
+// each refuse it before handleRequest runs it. A remembered permission (see
+// permissions.go) skips the confirmation, but not the policy's deny.
+func (d *Daemon) runRequest(conn net.Conn, session *connSession, req SignRequest, encoder *json.Encoder) {
+	if response := d.checkRateLimit(session, req); response != nil {
 		encoder.Encode(response)
 		return
 	}
+	if approvalMethods[req.Method] {
+		// Locks first if the trust session expired unnoticed (e.g. suspend)
+		d.expiry.expireIfDue()
+		// Counts as use for trust_idle_timeout, or locks if it came too late
+		d.idle.touch()
+	}
+	permitted := d.permitted(session, req)
+	if response := d.checkSigningPolicy(session, req, permitted); response != nil {
+		encoder.Encode(response)
+	} else if permitted {
+		d.handleRequest(conn, session, req, encoder)
+	} else if response := d.approveRequest(session, req); response != nil {
+		encoder.Encode(response)
+	} else if response := d.askRequest(session, req); response != nil {
+		encoder.Encode(response)
+	} else {
+		d.handleRequest(conn, session, req, encoder)
+	}
+}
 
+// handleRequest dispatches a single request and writes its response
+func (d *Daemon) handleRequest(conn net.Conn, session *connSession, req SignRequest, encoder *json.Encoder) {
 	// Handle requests
 	switch req.Method {
 	case "sign_event":
-		d.mu.RLock()
-		signature, err := d.signEvent(req.EventJSON)
-		d.mu.RUnlock()
+		// pubkey or npub picks the account (see accountselect.go)
+		var event *NostrEvent
+		err := d.withAccountKey(req, func(key *accountKey) (err error) {
+			event, err = d.signEventAs(key, req.EventJSON)
+			return err
+		})
+
+		var response SignResponse
+		if err != nil {
+			response = errorResponse(req, err)
+		} else {
+			d.countUse(req, AccountCounters{EventsSigned: 1})
+			// The signature alone is kept for clients that only read it
+			response = resultResponse(req, event.Sig)
+			response.EventID = event.ID
+			response.Event = event
+		}
+		encoder.Encode(response)
+
+	case "zap_request":
+		// Build and sign a NIP-57 zap request for an LNURL callback
+		if req.Zap == nil {
+			encoder.Encode(invalidRequestResponse(req, "zap parameters required"))
+			return
+		}
+		eventJSON, err := zapUnsignedEvent(req.Zap, time.Now().Unix())
+		if err != nil {
+			encoder.Encode(errorResponse(req, err))
+			return
+		}
+
+		var event *NostrEvent
+		err = d.withAccountKey(req, func(key *accountKey) (err error) {
+			event, err = d.signEventAs(key, eventJSON)
+			return err
+		})
+
+		var response SignResponse
+		if err != nil {
+			response = errorResponse(req, err)
+		} else {
+			d.countUse(req, AccountCounters{EventsSigned: 1})
+			response = resultResponse(req, event.Sig)
+			response.EventID = event.ID
+			response.Event = event
+		}
+		encoder.Encode(response)
+
+	case "sign_relay_list":
+		// Sign the NIP-65 relay list of the account req names (or the
+		// active one) from its relays.json
+		targetNpub, err := requestAccount(req)
+		if err == nil && targetNpub == "" {
+			targetNpub = d.activeNpub()
+		}
+		var eventJSON string
+		if err == nil {
+			var relays map[string]RelayPolicy
+			if relays, err = loadAccountRelays(targetNpub); err == nil {
+				eventJSON, err = relayListUnsignedEvent(relays, time.Now().Unix())
+			}
+		}
+
+		var event *NostrEvent
+		if err == nil {
+			err = d.withAccountKey(req, func(key *accountKey) (err error) {
+				event, err = d.signEventAs(key, eventJSON)
+				return err
+			})
+		}
 
 		var response SignResponse
 		if err != nil {
-			response = SignResponse{
+			response = errorResponse(req, err)
+		} else {
+			d.countUse(req, AccountCounters{EventsSigned: 1})
+			response = resultResponse(req, event.Sig)
+			response.EventID = event.ID
+			response.Event = event
+		}
+		encoder.Encode(response)
+
+	case "gift_wrap":
+		// Seal an unsigned event for a recipient and gift wrap it (NIP-59)
+		encoder.Encode(d.giftWrapRequest(req))
+
+	case "unwrap":
+		// Open a gift wrap addressed to the account
+		encoder.Encode(d.unwrapRequest(req))
+
+	case "send_dm":
+		// Gift wrap a direct message for a recipient and the account (NIP-17)
+		encoder.Encode(d.sendDMRequest(req))
+
+	case "decrypt_dm":
+		// Open a direct message addressed to the account
+		encoder.Encode(d.decryptDMRequest(req))
+
+	case "sign_events":
+		// Sign many events in one round trip (e.g. imports, threads)
+		if err := checkSignBatch(req.Events); err != nil {
+			response := SignBatchResponse{
 				ID:    req.ID,
-				Error: err.Error(),
+				Error: redactedError(err),
+				Code:  codeInvalidRequest,
+			}
+			encoder.Encode(response)
+			return
+		}
+
+		var results []SignBatchResult
+		err := d.withAccountKey(req, func(key *accountKey) error {
+			results = d.signBatch(key, req.Events)
+			return nil
+		})
+
+		var response SignBatchResponse
+		if err != nil {
+			response = SignBatchResponse{
+				ID:    req.ID,
+				Error: redactedError(err),
+				Code:  errorCode(err),
 			}
 		} else {
-			response = SignResponse{
-				ID:        req.ID,
-				Signature: signature,
+			var signed uint64
+			for _, result := range results {
+				if result.Error == "" {
+					signed++
+				}
 			}
+			if signed > 0 {
+				d.countUse(req, AccountCounters{EventsSigned: signed})
+			}
+			response = SignBatchResponse{
+				ID:      req.ID,
+				Results: results,
+			}
+		}
+		encoder.Encode(response)
+
+	case "validate_event":
+		// Check an event against the event limits without signing it,
+		// completed the way sign_event would complete it
+		var eventJSON string
+		d.withKey(func() { eventJSON = normalizeEvent(req.EventJSON, d.pubkey) })
+
+		response := ValidateEventResponse{
+			ID:               req.ID,
+			EventDiagnostics: validateEvent(eventJSON, d.config.eventLimits()),
 		}
 		encoder.Encode(response)
 
+	case "verify_delegation":
+		// Check a NIP-26 delegation tag; needs no key
+		result, err := checkDelegationRequest(req)
+		if err != nil {
+			encoder.Encode(invalidRequestResponse(req, err.Error()))
+			return
+		}
+		encoder.Encode(VerifyDelegationResponse{ID: req.ID, DelegationResult: result})
+
 	case "get_npub":
 		// Return current user's npub
 		d.mu.RLock()
 		npub := d.npub
 		d.mu.RUnlock()
 
-		response := SignResponse{
-			ID:        req.ID,
-			Signature: npub, // Using Signature field for npub response
+		response := resultResponse(req, npub)
+		encoder.Encode(response)
+
+	case "get_public_key":
+		// Return current user's hex pubkey (NIP-46 naming)
+		d.mu.RLock()
+		pubkey := d.pubkey
+		d.mu.RUnlock()
+
+		response := resultResponse(req, pubkey)
+		encoder.Encode(response)
+
+	case "get_version":
+		// Return build attestation so clients can show which build they trust
+		response := VersionResponse{
+			ID:               req.ID,
+			BuildAttestation: readBuildAttestation(),
 		}
 		encoder.Encode(response)
 
+	case "get_status":
+		// Running state plus the effective configuration
+		encoder.Encode(d.status(req.ID))
+
+	case "ping":
+		// Liveness check for watchdogs and isDaemonRunning (see ping.go)
+		encoder.Encode(d.ping(req.ID))
+
+	case "stats":
+		// Request, error and latency counters (see stats.go)
+		encoder.Encode(d.stats(req.ID))
+
+	case "get_protocol_version":
+		// Lets clients pick the newest protocol version both sides speak
+		response := ProtocolVersionResponse{
+			ID:         req.ID,
+			Version:    protocolVersion,
+			MinVersion: minProtocolVersion,
+		}
+		encoder.Encode(response)
+
+	case "get_capabilities":
+		// Version and observed access control (see capabilities.go)
+		encoder.Encode(d.capabilities(req.ID))
+
 	case "enable_autostart":
-		// Enable autostart for daemon
-		err := enableAutostart()
-		var response SignResponse
+		// Enable autostart for daemon (force: overwrite an entry noorsigner didn't write)
+		replaced, err := enableAutostart(req.Force)
+		var response AutostartResponse
 		if err != nil {
-			response = SignResponse{
+			response = AutostartResponse{
 				ID:    req.ID,
-				Error: err.Error(),
+				Error: redactedError(err),
+			}
+			if errors.Is(err, errAutostartNotManaged) {
+				response.Code = codeAutostartNotManaged
 			}
 		} else {
-			response = SignResponse{
-				ID:        req.ID,
-				Signature: "success",
+			response = AutostartResponse{
+				ID:       req.ID,
+				Replaced: replaced,
+			}
+			if protocolVersionOf(req) >= 2 {
+				response.Result = "success"
+			} else {
+				response.Signature = "success"
 			}
 		}
 		encoder.Encode(response)
@@ -376,15 +1219,9 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 		err := disableAutostart()
 		var response SignResponse
 		if err != nil {
-			response = SignResponse{
-				ID:    req.ID,
-				Error: err.Error(),
-			}
+			response = errorResponse(req, err)
 		} else {
-			response = SignResponse{
-				ID:        req.ID,
-				Signature: "success",
-			}
+			response = resultResponse(req, "success")
 		}
 		encoder.Encode(response)
 
@@ -393,76 +1230,100 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 		enabled, err := getAutostartStatus()
 		var response SignResponse
 		if err != nil {
-			response = SignResponse{
-				ID:    req.ID,
-				Error: err.Error(),
-			}
+			response = errorResponse(req, err)
 		} else {
 			status := "disabled"
 			if enabled {
 				status = "enabled"
 			}
-			response = SignResponse{
-				ID:        req.ID,
-				Signature: status,
-			}
+			response = resultResponse(req, status)
 		}
 		encoder.Encode(response)
 
 	case "nip44_encrypt":
 		// Encrypt plaintext using NIP-44
 		if req.Plaintext == "" || req.RecipientPubkey == "" {
-			response := SignResponse{
-				ID:    req.ID,
-				Error: "plaintext and recipient_pubkey required",
-			}
+			response := invalidRequestResponse(req, "plaintext and recipient_pubkey required")
 			encoder.Encode(response)
 			return
 		}
 
-		d.mu.RLock()
-		encrypted, err := nip44Encrypt(req.Plaintext, req.RecipientPubkey, d.privateKey)
-		d.mu.RUnlock()
+		var encrypted string
+		err := d.withAccountKey(req, func(key *accountKey) (err error) {
+			encrypted, err = nip44EncryptCached(key, req.Plaintext, req.RecipientPubkey)
+			return err
+		})
 
 		var response SignResponse
 		if err != nil {
-			response = SignResponse{
-				ID:    req.ID,
-				Error: err.Error(),
-			}
+			response = errorResponse(req, err)
 		} else {
-			response = SignResponse{
-				ID:        req.ID,
-				Signature: encrypted, // Using Signature field for encrypted payload
-			}
+			d.countUse(req, AccountCounters{Nip44Encrypts: 1})
+			response = resultResponse(req, encrypted)
 		}
 		encoder.Encode(response)
 
 	case "nip44_decrypt":
 		// Decrypt NIP-44 payload
 		if req.Payload == "" || req.SenderPubkey == "" {
-			response := SignResponse{
+			response := invalidRequestResponse(req, "payload and sender_pubkey required")
+			encoder.Encode(response)
+			return
+		}
+
+		var plaintext string
+		err := d.withAccountKey(req, func(key *accountKey) (err error) {
+			plaintext, err = nip44DecryptCached(key, req.Payload, req.SenderPubkey)
+			return err
+		})
+
+		var response SignResponse
+		if err != nil {
+			response = errorResponse(req, err)
+		} else {
+			d.countUse(req, AccountCounters{Nip44Decrypts: 1})
+			response = resultResponse(req, plaintext)
+		}
+		encoder.Encode(response)
+
+	case "nip44_decrypt_batch":
+		// Decrypt many NIP-44 payloads in one round trip (e.g. chat history)
+		if err := checkDecryptBatch(req.Items); err != nil {
+			response := DecryptBatchResponse{
 				ID:    req.ID,
-				Error: "payload and sender_pubkey required",
+				Error: redactedError(err),
+				Code:  codeInvalidRequest,
 			}
 			encoder.Encode(response)
 			return
 		}
 
-		d.mu.RLock()
-		plaintext, err := nip44Decrypt(req.Payload, req.SenderPubkey, d.privateKey)
-		d.mu.RUnlock()
+		var results []DecryptBatchResult
+		err := d.withAccountKey(req, func(key *accountKey) error {
+			results = decryptBatch(key, req.Items)
+			return nil
+		})
 
-		var response SignResponse
+		var response DecryptBatchResponse
 		if err != nil {
-			response = SignResponse{
+			response = DecryptBatchResponse{
 				ID:    req.ID,
-				Error: err.Error(),
+				Error: redactedError(err),
+				Code:  errorCode(err),
 			}
 		} else {
-			response = SignResponse{
-				ID:        req.ID,
-				Signature: plaintext, // Using Signature field for decrypted plaintext
+			var decrypted uint64
+			for _, result := range results {
+				if result.Error == "" {
+					decrypted++
+				}
+			}
+			if decrypted > 0 {
+				d.countUse(req, AccountCounters{Nip44Decrypts: decrypted})
+			}
+			response = DecryptBatchResponse{
+				ID:      req.ID,
+				Results: results,
 			}
 		}
 		encoder.Encode(response)
@@ -470,84 +1331,76 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 	case "nip04_encrypt":
 		// Encrypt plaintext using NIP-04 (deprecated but widely compatible)
 		if req.Plaintext == "" || req.RecipientPubkey == "" {
-			response := SignResponse{
-				ID:    req.ID,
-				Error: "plaintext and recipient_pubkey required",
-			}
+			response := invalidRequestResponse(req, "plaintext and recipient_pubkey required")
 			encoder.Encode(response)
 			return
 		}
 
-		d.mu.RLock()
-		encrypted, err := nip04Encrypt(req.Plaintext, req.RecipientPubkey, d.privateKey)
-		d.mu.RUnlock()
+		var encrypted string
+		err := d.withAccountKey(req, func(key *accountKey) (err error) {
+			encrypted, err = nip04Encrypt(req.Plaintext, req.RecipientPubkey, key.privateKey)
+			return err
+		})
 
 		var response SignResponse
 		if err != nil {
-			response = SignResponse{
-				ID:    req.ID,
-				Error: err.Error(),
-			}
+			response = errorResponse(req, err)
 		} else {
-			response = SignResponse{
-				ID:        req.ID,
-				Signature: encrypted, // Using Signature field for encrypted payload
-			}
+			// NIP-04 has no counter of its own, but it is a use of the key
+			d.countUse(req, AccountCounters{})
+			response = resultResponse(req, encrypted)
 		}
 		encoder.Encode(response)
 
 	case "nip04_decrypt":
 		// Decrypt NIP-04 payload (deprecated but widely compatible)
 		if req.Payload == "" || req.SenderPubkey == "" {
-			response := SignResponse{
-				ID:    req.ID,
-				Error: "payload and sender_pubkey required",
-			}
+			response := invalidRequestResponse(req, "payload and sender_pubkey required")
 			encoder.Encode(response)
 			return
 		}
 
-		d.mu.RLock()
-		plaintext, err := nip04Decrypt(req.Payload, req.SenderPubkey, d.privateKey)
-		d.mu.RUnlock()
+		var plaintext string
+		err := d.withAccountKey(req, func(key *accountKey) (err error) {
+			plaintext, err = nip04Decrypt(req.Payload, req.SenderPubkey, key.privateKey)
+			return err
+		})
 
 		var response SignResponse
 		if err != nil {
-			response = SignResponse{
-				ID:    req.ID,
-				Error: err.Error(),
-			}
+			response = errorResponse(req, err)
 		} else {
-			response = SignResponse{
-				ID:        req.ID,
-				Signature: plaintext, // Using Signature field for decrypted plaintext
-			}
+			d.countUse(req, AccountCounters{})
+			response = resultResponse(req, plaintext)
 		}
 		encoder.Encode(response)
 
 	case "shutdown_daemon":
 		// Shutdown daemon gracefully
-		response := SignResponse{
-			ID:        req.ID,
-			Signature: "success",
-		}
+		response := resultResponse(req, "success")
 		encoder.Encode(response)
 
 		// Trigger shutdown after response is sent
+		lock := requestLock(lockReasonShutdown, "shutdown_daemon", session, req)
 		go func() {
-			fmt.Println("\n🔒 Shutdown requested by client...")
-			d.shutdownDaemon()
+			logInfo("🔒 Shutdown requested by client...")
+			d.shutdownDaemon(lock)
 			os.Exit(0)
 		}()
 
+	case "lock":
+		// Drop the key and the trust session, e.g. for a freeze (see freeze.go)
+		d.lockDaemon(requestLock(clientLockReason(req.Reason), "", session, req))
+		encoder.Encode(AccountActionResponse{ID: req.ID, Success: true})
+
 	// ========== Multi-Account API Endpoints ==========
 
 	case "list_accounts":
-		accounts, err := listAccounts()
+		accounts, err := d.accountWatch.list()
 		if err != nil {
 			response := ListAccountsResponse{
 				ID:    req.ID,
-				Error: err.Error(),
+				Error: redactedError(err),
 			}
 			encoder.Encode(response)
 			return
@@ -565,6 +1418,8 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 				Pubkey:    acc.Pubkey,
 				Npub:      acc.Npub,
 				CreatedAt: acc.CreatedAt.Unix(),
+				Label:     acc.Label,
+				Counters:  d.counters.totals(acc.Npub),
 			})
 		}
 
@@ -580,6 +1435,7 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 			response := AccountActionResponse{
 				ID:    req.ID,
 				Error: "nsec and password required",
+				Code:  codeInvalidRequest,
 			}
 			encoder.Encode(response)
 			return
@@ -590,12 +1446,20 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 		if err != nil {
 			response := AccountActionResponse{
 				ID:    req.ID,
-				Error: fmt.Sprintf("invalid nsec: %v", err),
+				Error: redactSecrets("error response", fmt.Sprintf("invalid nsec: %v", err)),
+			}
+			encoder.Encode(response)
+			return
+		}
+		npub, err := privateKeyToNpub(privateKey)
+		if err != nil {
+			response := AccountActionResponse{
+				ID:    req.ID,
+				Error: redactedError(err),
 			}
 			encoder.Encode(response)
 			return
 		}
-		npub := privateKeyToNpub(privateKey)
 
 		// Check if account already exists
 		if accountExists(npub) {
@@ -606,6 +1470,16 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 			encoder.Encode(response)
 			return
 		}
+		label := strings.TrimSpace(req.Label)
+		if err := checkNewAccountLabel(label); err != nil {
+			response := AccountActionResponse{
+				ID:    req.ID,
+				Error: redactedError(err),
+				Code:  errorCode(err),
+			}
+			encoder.Encode(response)
+			return
+		}
 
 		// Encrypt nsec
 		encryptedKey, err := encryptNsec(req.Nsec, req.Password)
@@ -619,7 +1493,9 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 		}
 
 		// Save account
-		if err := saveAccountEncryptedKey(npub, encryptedKey); err != nil {
+		err = saveAccountEncryptedKey(npub, encryptedKey)
+		d.accountWatch.invalidate()
+		if err != nil {
 			response := AccountActionResponse{
 				ID:    req.ID,
 				Error: fmt.Sprintf("failed to save account: %v", err),
@@ -628,6 +1504,13 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 			return
 		}
 
+		if label != "" {
+			if err := setAccountLabel(npub, label); err != nil {
+				logError("⚠️  Cannot label %s: %v", displayNpub(npub), err)
+			}
+			d.accountWatch.invalidate()
+		}
+
 		// Set as active if requested
 		if req.SetActive {
 			saveActiveAccount(npub)
@@ -643,13 +1526,22 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 		encoder.Encode(response)
 
 	case "switch_account":
-		// Accept either pubkey or npub
-		targetNpub := req.Npub
+		// Accept either pubkey or npub (or label)
+		targetNpub, err := resolveAccount(req.Npub)
+		if err != nil {
+			response := AccountActionResponse{
+				ID:    req.ID,
+				Error: redactedError(err),
+				Code:  errorCode(err),
+			}
+			encoder.Encode(response)
+			return
+		}
 		if targetNpub == "" && req.Pubkey != "" {
 			// Find npub by pubkey
-			accounts, _ := listAccounts()
+			accounts, _ := d.accountWatch.list()
 			for _, acc := range accounts {
-				if acc.Pubkey == req.Pubkey {
+				if acc.Pubkey == strings.ToLower(req.Pubkey) {
 					targetNpub = acc.Npub
 					break
 				}
@@ -660,15 +1552,7 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 			response := AccountActionResponse{
 				ID:    req.ID,
 				Error: "pubkey or npub required",
-			}
-			encoder.Encode(response)
-			return
-		}
-
-		if req.Password == "" {
-			response := AccountActionResponse{
-				ID:    req.ID,
-				Error: "password required",
+				Code:  codeInvalidRequest,
 			}
 			encoder.Encode(response)
 			return
@@ -684,33 +1568,40 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 			return
 		}
 
-		// Load and verify password
-		encKey, err := loadAccountEncryptedKey(targetNpub)
-		if err != nil {
-			response := AccountActionResponse{
-				ID:    req.ID,
-				Error: fmt.Sprintf("failed to load account: %v", err),
+		// Without a password, a valid trust session of the account unlocks
+		// it, as it would at daemon start
+		var nsec string
+		var newPrivateKey *btcec.PrivateKey
+		unlockedVia := unlockedViaPassword
+		if req.Password == "" {
+			unlockedVia = unlockedViaTrustSession
+			if d.noTrust {
+				err = errPasswordRequired
+			} else {
+				_, newPrivateKey, err = openAccountTrustSession(targetNpub)
+				if errors.Is(err, errNoTrustSession) {
+					err = errPasswordRequired
+				}
 			}
-			encoder.Encode(response)
-			return
-		}
-
-		nsec, err := decryptNsec(encKey, req.Password)
-		if err != nil {
-			response := AccountActionResponse{
-				ID:    req.ID,
-				Error: "invalid password",
+		} else {
+			// Load and verify password
+			var encKey *EncryptedKey
+			encKey, err = loadAccountEncryptedKey(targetNpub)
+			if err != nil {
+				response := AccountActionResponse{
+					ID:    req.ID,
+					Error: fmt.Sprintf("failed to load account: %v", err),
+				}
+				encoder.Encode(response)
+				return
 			}
-			encoder.Encode(response)
-			return
+			nsec, newPrivateKey, err = decryptAccountKey(encKey, targetNpub, req.Password)
 		}
-
-		// Convert to private key
-		newPrivateKey, err := nsecToPrivateKey(nsec)
 		if err != nil {
 			response := AccountActionResponse{
 				ID:    req.ID,
-				Error: "corrupted key file",
+				Error: redactedError(err),
+				Code:  errorCode(err),
 			}
 			encoder.Encode(response)
 			return
@@ -718,10 +1609,18 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 
 		newPubkey, _ := npubToPubkey(targetNpub)
 
-		// Create trust session for new account
-		session, err := createTrustSession(nsec)
-		if err == nil {
-			saveAccountTrustSession(targetNpub, session)
+		// Create trust session for new account (unless Trust Mode is disabled
+		// or it was unlocked with its session)
+		if d.noTrust {
+			clearAccountTrustSession(targetNpub)
+		} else if unlockedVia == unlockedViaPassword {
+			session, err := createTrustSession(nsec)
+			if err == nil {
+				err = saveAccountTrustSession(targetNpub, session)
+			}
+			if err != nil {
+				logError("⚠️  No trust session for %s: %v", displayNpub(targetNpub), err)
+			}
 		}
 
 		// Clear nsec from memory
@@ -733,12 +1632,15 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 		d.mu.Lock()
 		// Clear old private key from memory
 		if d.privateKey != nil {
+			loadedKeys.remove(d.privateKey)
 			keyBytes := d.privateKey.Serialize()
 			for i := range keyBytes {
 				keyBytes[i] = 0
 			}
 		}
 		d.privateKey = newPrivateKey
+		loadedKeys.add(newPrivateKey)
+		d.convKeys.clear()
 		d.npub = targetNpub
 		d.pubkey = newPubkey
 		d.mu.Unlock()
@@ -746,22 +1648,76 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 		// Update active account file
 		saveActiveAccount(targetNpub)
 
+		d.emit(StreamEvent{Type: "account_switched", Npub: targetNpub, Pubkey: newPubkey})
+		d.emit(StreamEvent{Type: "unlocked", Npub: targetNpub, Pubkey: newPubkey})
+		d.watchTrustSession(targetNpub)
+
+		trustExpiresAt, _, _ := d.trustDeadlines(targetNpub)
+		response := AccountActionResponse{
+			ID:             req.ID,
+			Success:        true,
+			Pubkey:         newPubkey,
+			Npub:           targetNpub,
+			UnlockedVia:    unlockedVia,
+			TrustExpiresAt: trustExpiresAt,
+		}
+		encoder.Encode(response)
+
+	case "unlock_account":
+		// Unlock another account for requests naming it, without switching
+		// (see accountselect.go)
+		targetNpub, err := requestAccount(req)
+		if err == nil && targetNpub == "" {
+			err = fmt.Errorf("%w: pubkey or npub required", errAccountField)
+		}
+		if err == nil && req.Password == "" {
+			err = fmt.Errorf("%w: password required", errAccountField)
+		}
+		if err == nil && req.Trust && d.noTrust {
+			err = fmt.Errorf("%w: Trust Mode is disabled (--no-trust)", errAccountField)
+		}
+		if err == nil {
+			err = d.unlockAccount(targetNpub, req.Password, req.Trust)
+		}
+		if err != nil {
+			response := AccountActionResponse{
+				ID:    req.ID,
+				Npub:  targetNpub,
+				Error: redactedError(err),
+				Code:  errorCode(err),
+			}
+			encoder.Encode(response)
+			return
+		}
+
+		pubkey, _ := npubToPubkey(targetNpub)
+		d.emit(StreamEvent{Type: "account_unlocked", Npub: targetNpub, Pubkey: pubkey})
 		response := AccountActionResponse{
 			ID:      req.ID,
 			Success: true,
-			Pubkey:  newPubkey,
+			Pubkey:  pubkey,
 			Npub:    targetNpub,
 		}
 		encoder.Encode(response)
 
 	case "remove_account":
-		// Accept either pubkey or npub
-		targetNpub := req.Npub
+		// Accept either pubkey or npub (or label). Not a position or an npub
+		// prefix: a removal must name its account exactly.
+		targetNpub, err := resolveNamedAccount(req.Npub)
+		if err != nil {
+			response := AccountActionResponse{
+				ID:    req.ID,
+				Error: redactedError(err),
+				Code:  errorCode(err),
+			}
+			encoder.Encode(response)
+			return
+		}
 		if targetNpub == "" && req.Pubkey != "" {
 			// Find npub by pubkey
-			accounts, _ := listAccounts()
+			accounts, _ := d.accountWatch.list()
 			for _, acc := range accounts {
-				if acc.Pubkey == req.Pubkey {
+				if acc.Pubkey == strings.ToLower(req.Pubkey) {
 					targetNpub = acc.Npub
 					break
 				}
@@ -772,6 +1728,7 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 			response := AccountActionResponse{
 				ID:    req.ID,
 				Error: "pubkey or npub required",
+				Code:  codeInvalidRequest,
 			}
 			encoder.Encode(response)
 			return
@@ -781,6 +1738,7 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 			response := AccountActionResponse{
 				ID:    req.ID,
 				Error: "password required",
+				Code:  codeInvalidRequest,
 			}
 			encoder.Encode(response)
 			return
@@ -791,6 +1749,7 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 			response := AccountActionResponse{
 				ID:    req.ID,
 				Error: "account not found",
+				Code:  codeUnknownAccount,
 			}
 			encoder.Encode(response)
 			return
@@ -807,43 +1766,138 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 			return
 		}
 
-		_, err = decryptNsec(encKey, req.Password)
+		_, removedKey, err := decryptAccountKey(encKey, targetNpub, req.Password)
 		if err != nil {
 			response := AccountActionResponse{
 				ID:    req.ID,
-				Error: "invalid password",
+				Error: redactedError(err),
+				Code:  errorCode(err),
 			}
 			encoder.Encode(response)
 			return
 		}
+		removedKey.Zero()
 
-		// Check if this is the current active account
-		d.mu.RLock()
-		isCurrentAccount := d.npub == targetNpub
-		d.mu.RUnlock()
+		// Strict confirmation: first call hands out a token, resubmission must carry it
+		if d.config != nil && d.config.StrictConfirmation {
+			if response, ok := d.checkConfirmation(session, req, targetNpub); !ok {
+				encoder.Encode(response)
+				return
+			}
+		}
 
-		if isCurrentAccount {
+		// Purge in-memory state, delete files and notify subscribers as one step
+		if err := d.removeAccount(targetNpub, req.Force); err != nil {
 			response := AccountActionResponse{
 				ID:    req.ID,
-				Error: "cannot remove active account - switch to another account first",
+				Error: redactedError(err),
 			}
 			encoder.Encode(response)
 			return
 		}
 
-		// Remove account
-		if err := removeAccount(targetNpub); err != nil {
+		response := AccountActionResponse{
+			ID:      req.ID,
+			Success: true,
+			Npub:    targetNpub,
+		}
+		encoder.Encode(response)
+
+	case "set_label":
+		// Name an account; an empty label removes its label
+		targetNpub, err := requestAccount(req)
+		if err == nil && targetNpub == "" {
+			err = fmt.Errorf("%w: pubkey or npub required", errAccountField)
+		}
+		if err == nil {
+			err = setAccountLabel(targetNpub, strings.TrimSpace(req.Label))
+			d.accountWatch.invalidate()
+		}
+		if err != nil {
 			response := AccountActionResponse{
 				ID:    req.ID,
-				Error: fmt.Sprintf("failed to remove account: %v", err),
+				Npub:  targetNpub,
+				Error: redactedError(err),
+				Code:  errorCode(err),
 			}
 			encoder.Encode(response)
 			return
 		}
 
+		pubkey, _ := npubToPubkey(targetNpub)
 		response := AccountActionResponse{
 			ID:      req.ID,
 			Success: true,
+			Pubkey:  pubkey,
+			Npub:    targetNpub,
+		}
+		encoder.Encode(response)
+
+	case "get_relays", "set_relays":
+		// The relay list of the account req names, or of the active account
+		targetNpub, err := requestAccount(req)
+		if err == nil && targetNpub == "" {
+			targetNpub = d.activeNpub()
+		}
+		var relays map[string]RelayPolicy
+		switch {
+		case err != nil:
+		case targetNpub == "":
+			err = fmt.Errorf("%w: no active account", errUnknownAccount)
+		case req.Method == "get_relays" && !accountExists(targetNpub):
+			err = fmt.Errorf("%w: %s", errUnknownAccount, displayNpub(targetNpub))
+		case req.Method == "get_relays":
+			relays, err = loadAccountRelays(targetNpub)
+		default:
+			relays, err = setAccountRelays(targetNpub, req.RelayList)
+		}
+		if err != nil {
+			encoder.Encode(RelaysResponse{
+				ID:    req.ID,
+				Npub:  targetNpub,
+				Error: redactedError(err),
+				Code:  errorCode(err),
+			})
+			return
+		}
+		encoder.Encode(RelaysResponse{ID: req.ID, Npub: targetNpub, RelayList: relays})
+
+	case "pending_credentials":
+		// List credential requests waiting for an operator
+		response := PendingCredentialsResponse{
+			ID:       req.ID,
+			Requests: d.listPendingCredentials(),
+		}
+		encoder.Encode(response)
+
+	case "respond_credential":
+		// Answer a credential request with the account password
+		if req.Nonce == "" || req.Password == "" {
+			response := AccountActionResponse{
+				ID:    req.ID,
+				Error: "nonce and password required",
+				Code:  codeInvalidRequest,
+			}
+			encoder.Encode(response)
+			return
+		}
+
+		npub, err := d.respondCredential(req.Nonce, req.Password, req.Fido)
+		var response AccountActionResponse
+		if err != nil {
+			response = AccountActionResponse{
+				ID:    req.ID,
+				Error: redactedError(err),
+				Code:  errorCode(err),
+			}
+		} else {
+			pubkey, _ := npubToPubkey(npub)
+			response = AccountActionResponse{
+				ID:      req.ID,
+				Success: true,
+				Pubkey:  pubkey,
+				Npub:    npub,
+			}
 		}
 		encoder.Encode(response)
 
@@ -860,31 +1914,334 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 			Npub:       npub,
 			IsUnlocked: isUnlocked,
 		}
+		if isUnlocked {
+			response.TrustExpiresAt, response.TrustIdleExpiresAt, response.TrustHardLimitAt = d.trustDeadlines(npub)
+		} else if lock, ok := d.locks.last(); ok {
+			response.LockReason, response.LockTrigger, response.LockedAt = lock.Reason, lock.Trigger, lock.Timestamp
+		}
+		if npub != "" {
+			if health, err := loadAccountHealth(npub); err == nil {
+				response.HealthWarning = health.Warning
+			}
+		}
+		encoder.Encode(response)
+
+	case "drain":
+		// Stop taking requests, let in-flight ones finish, then shut down
+		timeout, err := parseDrainTimeout(req.Timeout)
+		if err != nil {
+			response := DrainResponse{
+				ID:    req.ID,
+				Error: redactedError(err),
+			}
+			encoder.Encode(response)
+			return
+		}
+
+		response := d.startDrain(timeout)
+		response.ID = req.ID
 		encoder.Encode(response)
 
+	case "get_recent_activity":
+		// Recent audited requests, for activity feeds in GUI clients
+		encoder.Encode(d.recentActivity(req))
+
+	case "append_audit":
+		// The CLI's own audit entries, so the daemon stays the only writer
+		encoder.Encode(d.appendAuditFromClient(conn, req))
+
+	case "audit":
+		// The newest audit.log entries, for GUI clients
+		encoder.Encode(d.auditEntries(req))
+
+	case "get_policy":
+		encoder.Encode(d.policyResponse(req.ID))
+
+	case "set_policy":
+		// Change kind_policy live and in config.json
+		encoder.Encode(d.setPolicy(req))
+
+	case "pending_approvals":
+		encoder.Encode(PendingApprovalsResponse{ID: req.ID, Requests: d.listPendingApprovals()})
+
+	case "respond_approval":
+		// Confirm or deny a request waiting on kind_policy
+		encoder.Encode(d.respondApproval(session, req))
+
+	case "await_result":
+		// Collect a request parked for confirmation (ERR_PENDING)
+		d.awaitResult(session, req, encoder)
+
+	case "nip46_connect":
+		// Accept a nostrconnect:// URI ('noorsigner connect')
+		encoder.Encode(d.nip46Connect(req))
+
+	case "nip46_bunker":
+		// Hand out a bunker:// URI ('noorsigner bunker')
+		encoder.Encode(d.nip46Bunker(req))
+
+	case "reset_rate_limits":
+		// Refill rate limit buckets and clear their counters
+		encoder.Encode(d.resetRateLimits(session, req))
+
+	case "subscribe":
+		// Acknowledge, then keep the connection open for stream events
+		response := resultResponse(req, "subscribed")
+		if err := encoder.Encode(response); err != nil {
+			return
+		}
+		d.streamEvents(conn, encoder)
+
 	default:
 		response := SignResponse{
 			ID:    req.ID,
 			Error: "Unknown method: " + req.Method,
+			Code:  codeUnknownMethod,
 		}
 		encoder.Encode(response)
 	}
 }
 
-// signEvent signs a Nostr event JSON
-func (d *Daemon) signEvent(eventJSON string) (string, error) {
+// decryptAccountKey decrypts an account's key with password. The key must
+// belong to npub; otherwise the password is reported as wrong. Wrong
+// passwords lock the account out for a while (see lockout.go). A key still
+// in the legacy format is re-encrypted in the current one (see keyfile.go).
+func decryptAccountKey(encKey *EncryptedKey, npub, password string) (string, *btcec.PrivateKey, error) {
+	// The security key may be the only way in (see fido.go)
+	if err := checkPasswordAllowed(npub); err != nil {
+		return "", nil, err
+	}
+	nsec, privateKey, err := openAccountKey(encKey, npub, password)
+	if err == nil && encKey.Version < keyFormatVersion {
+		upgradeAccountKey(npub, nsec, password)
+	}
+	return nsec, privateKey, err
+}
+
+// upgradeAccountKey rewrites an account's keys.encrypted in the current
+// format. A failure leaves the legacy file, which still works, so it is only
+// logged.
+func upgradeAccountKey(npub, nsec, password string) {
+	newKey, err := encryptNsec(nsec, password)
+	if err == nil {
+		err = saveAccountEncryptedKey(npub, newKey)
+	}
+	if err != nil {
+		logError("⚠️  Cannot upgrade keys.encrypted of %s: %v", npub, err)
+		return
+	}
+	logInfo("🔐 Upgraded keys.encrypted of %s to format version %d", npub, keyFormatVersion)
+}
+
+// openAccountKey is decryptAccountKey for any KDF input: a password or a
+// security key's secret. A wrong input fails the cipher's authentication, or
+// with the legacy XOR cipher decrypts to garbage; both count as a wrong
+// password.
+func openAccountKey(encKey *EncryptedKey, npub, input string) (string, *btcec.PrivateKey, error) {
+	nsec, privateKey, err := openAccountKeyBytes(encKey, npub, input)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zeroBytes(nsec)
+	return string(nsec), privateKey, nil
+}
+
+// openAccountKeyBytes is openAccountKey returning the nsec in a buffer the
+// caller clears
+func openAccountKeyBytes(encKey *EncryptedKey, npub, input string) ([]byte, *btcec.PrivateKey, error) {
+	if err := checkLockout(npub); err != nil {
+		return nil, nil, err
+	}
+	nsec, err := decryptNsecBytes(encKey, input)
+	if errors.Is(err, errSignerFrozen) {
+		return nil, nil, err
+	}
+	if err != nil && !errors.Is(err, errKeyDecryptFailed) {
+		return nil, nil, errBadPassword
+	}
+	var privateKey *btcec.PrivateKey
+	if err == nil {
+		privateKey, err = nsecToPrivateKey(string(nsec))
+	}
+	if err != nil || !keyMatchesNpub(privateKey, npub) {
+		zeroBytes(nsec)
+		if privateKey != nil {
+			privateKey.Zero()
+		}
+		if lockout := recordPasswordFailure(npub); lockout != nil {
+			return nil, nil, fmt.Errorf("%w - %w", errBadPassword, lockout)
+		}
+		return nil, nil, errBadPassword
+	}
+	clearPasswordFailures(npub)
+	return nsec, privateKey, nil
+}
+
+// withKey runs f holding the key read lock. The unlock is deferred, so a
+// panic in f (see recoverConnection) doesn't leave the lock held.
+func (d *Daemon) withKey(f func()) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	f()
+}
+
+// requireUnlocked returns errDaemonLocked (or errTrustExpired, see
+// trustexpiry.go) when no key is loaded.
+// Caller must hold d.mu.
+func (d *Daemon) requireUnlocked() error {
+	if d.privateKey == nil {
+		return d.lockedError()
+	}
+	return nil
+}
+
+// signEvent signs a Nostr event JSON with the unlocked account's key and
+// returns it with id and sig filled in. Caller must hold d.mu (read).
+func (d *Daemon) signEvent(eventJSON string) (*NostrEvent, error) {
+	if err := d.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	return d.signEventAs(d.activeKey(), eventJSON)
+}
+
+// signEventAs signs a Nostr event JSON with key (see accountselect.go)
+func (d *Daemon) signEventAs(key *accountKey, eventJSON string) (*NostrEvent, error) {
+	// Light clients may leave created_at and pubkey to the signer
+	eventJSON = normalizeEvent(eventJSON, key.pubkey)
+	if err := checkEventPubkey(eventJSON, key.pubkey); err != nil {
+		return nil, err
+	}
+
+	// Refuse events relays would reject (and anything that can't be hashed)
+	if diagnostics := validateEvent(eventJSON, d.config.eventLimits()); !diagnostics.Valid {
+		return nil, diagnostics.err()
+	}
+
 	// Create hash of the event per NIP-01
 	eventHash, err := createEventHash(eventJSON)
 	if err != nil {
-		return "", fmt.Errorf("failed to hash event: %v", err)
+		return nil, fmt.Errorf("failed to hash event: %v", err)
+	}
+
+	// Sign with the account's private key
+	signature, err := signNostrEvent(key.privateKey, eventHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// The id comes from the same serialization that was signed
+	return completeEvent(eventJSON, eventHash, signature)
+}
+
+// removeAccount removes a stored account as one well-ordered operation:
+// reject if in use, purge in-memory state, securely delete files, then notify
+// subscribers. An account is in use while it is active or unlocked with
+// unlock_account. The caller must already have verified the account password.
+func (d *Daemon) removeAccount(npub string, force bool) error {
+	d.mu.Lock()
+	isCurrentAccount := d.npub == npub
+	if isCurrentAccount && !force {
+		d.mu.Unlock()
+		return fmt.Errorf("cannot remove active account - switch to another account first")
+	}
+	if _, isUnlocked := d.unlocked[npub]; isUnlocked && !force {
+		d.mu.Unlock()
+		return fmt.Errorf("cannot remove unlocked account - lock the daemon first")
+	}
+
+	// Forced removal of the active account locks the daemon
+	wasUnlocked := isCurrentAccount && d.privateKey != nil
+	if isCurrentAccount {
+		d.clearKeyLocked()
+		d.npub = ""
+		d.pubkey = ""
+	}
+	d.dropUnlockedLocked(npub)
+	d.mu.Unlock()
+	if wasUnlocked {
+		d.recordLock(&LockEvent{Reason: lockReasonAccountRemoved}, npub)
+	}
+
+	pubkey, _ := npubToPubkey(npub)
+
+	d.counters.forget(npub)
+	err := removeAccount(npub)
+	d.accountWatch.invalidate()
+	if err != nil {
+		return fmt.Errorf("failed to remove account: %v", err)
+	}
+
+	d.emit(StreamEvent{
+		Type:   "account_removed",
+		Npub:   npub,
+		Pubkey: pubkey,
+	})
+	if isCurrentAccount {
+		d.emit(StreamEvent{Type: "locked", Npub: npub, Pubkey: pubkey})
+	}
+
+	return nil
+}
+
+// watchTrustSession locks the daemon when the trust session of npub runs
+// out while npub is still unlocked (see trustexpiry.go). With
+// trust_idle_timeout set, the daemon also locks once the session goes
+// unused that long.
+func (d *Daemon) watchTrustSession(npub string) {
+	d.idle.stop()
+	d.expiry.stop()
+	if d.noTrust {
+		return
 	}
+	session, err := loadAccountTrustSession(npub)
+	if err != nil || !isTrustSessionValid(session) {
+		return
+	}
+	d.idle.start(npub, session.lastUsed())
+	d.expiry.start(npub, session.ExpiresAt)
+}
 
-	// Sign with stored private key
-	return signNostrEvent(d.privateKey, eventHash)
+// clearKeyLocked zeroes and drops the in-memory private key, along with the
+// keys of accounts unlocked with unlock_account. Caller must hold d.mu.
+func (d *Daemon) clearKeyLocked() {
+	if d.privateKey != nil {
+		loadedKeys.remove(d.privateKey)
+		d.privateKey.Zero()
+		d.privateKey = nil
+	}
+	d.convKeys.clear()
+	d.dropUnlockedLocked("")
 }
 
-// shutdownDaemon cleans up daemon resources
-func (d *Daemon) shutdownDaemon() {
+// shutdownDaemon cleans up daemon resources. lock says what stopped the
+// daemon, for the lock history if it was unlocked.
+func (d *Daemon) shutdownDaemon(lock LockEvent) {
+	d.mu.RLock()
+	npub, pubkey := d.npub, d.pubkey
+	d.mu.RUnlock()
+	d.emit(StreamEvent{Type: "daemon_stopping", Npub: npub, Pubkey: pubkey})
+
+	// Clear private key from memory (security)
+	d.mu.Lock()
+	wasUnlocked := d.privateKey != nil
+	d.clearKeyLocked()
+	d.mu.Unlock()
+
+	if wasUnlocked {
+		d.recordLock(&lock, npub)
+		d.emit(StreamEvent{Type: "locked", Npub: npub, Pubkey: pubkey})
+	}
+	d.counters.flush()
+	d.flushSubscribers(streamFlushTimeout)
+	d.stopHTTPListener()
+
+	// Platform-specific cleanup (removes Unix socket file, no-op on Windows).
+	// Done before signalling the main loop: once serve() returns the process exits.
+	cleanupListener()
+	removePidFile()
+
+	logInfo("Daemon shutdown complete")
+
 	// Signal shutdown to main loop
 	select {
 	case d.shutdown <- true:
@@ -894,20 +2251,7 @@ func (d *Daemon) shutdownDaemon() {
 	if d.listener != nil {
 		d.listener.Close()
 	}
-
-	// Platform-specific cleanup (removes Unix socket file, no-op on Windows)
-	cleanupListener()
-
-	// Clear private key from memory (security)
-	d.mu.Lock()
-	if d.privateKey != nil {
-		// Zero out private key bytes
-		keyBytes := d.privateKey.Serialize()
-		for i := range keyBytes {
-			keyBytes[i] = 0
-		}
+	if d.abstractListener != nil {
+		d.abstractListener.Close()
 	}
-	d.mu.Unlock()
-
-	fmt.Println("Daemon shutdown complete")
 }
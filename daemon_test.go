@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDaemon returns a daemon with npub active and unlocked, as after
+// 'noorsigner daemon --no-trust' with testPassword
+func testDaemon(t *testing.T, npub string) *Daemon {
+	t.Helper()
+	encKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, privateKey, err := decryptAccountKey(encKey, npub, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkey, err := npubToPubkey(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newDaemon(appConfig, true, defaultRequestTimeout, "")
+	d.privateKey, d.npub, d.pubkey = privateKey, npub, pubkey
+	loadedKeys.add(privateKey)
+	t.Cleanup(func() {
+		d.mu.Lock()
+		d.clearKeyLocked()
+		d.mu.Unlock()
+	})
+	return d
+}
+
+// serveTestDaemon serves d on the socket of the test's storage directory
+// until the test ends, as 'noorsigner daemon' does once it is up
+func serveTestDaemon(t *testing.T, d *Daemon) {
+	t.Helper()
+	listener, err := createListener()
+	if err != nil {
+		t.Fatalf("createListener: %v", err)
+	}
+	d.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			d.admitConnection(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		cleanupListener()
+	})
+}
+
+// testConn is a client connection to the daemon under test
+type testConn struct {
+	net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+func dialTestDaemon(t *testing.T) *testConn {
+	t.Helper()
+	conn, err := dialConnection()
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testConn{Conn: conn, encoder: json.NewEncoder(conn), decoder: json.NewDecoder(conn)}
+}
+
+// request sends req and decodes its response into response
+func (c *testConn) request(t *testing.T, req SignRequest, response any) error {
+	t.Helper()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := c.encoder.Encode(req); err != nil {
+		return err
+	}
+	return c.decoder.Decode(response)
+}
+
+func isUnlocked(d *Daemon, npub string) bool {
+	for _, unlocked := range d.unlockedAccounts() {
+		if unlocked == npub {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDaemonRemoveActiveAccount(t *testing.T) {
+	testHome(t)
+	active := addTestAccount(t, "")
+	d := testDaemon(t, active)
+
+	if err := d.removeAccount(active, false); err == nil {
+		t.Fatal("removing the active account succeeded without force")
+	}
+	if !accountExists(active) || d.npub != active || d.privateKey == nil {
+		t.Fatal("a refused removal touched the active account")
+	}
+
+	if err := d.removeAccount(active, true); err != nil {
+		t.Fatalf("forced removal: %v", err)
+	}
+	if accountExists(active) {
+		t.Error("the account still exists after a forced removal")
+	}
+	if d.npub != "" || d.privateKey != nil {
+		t.Error("the daemon still holds the removed account")
+	}
+}
+
+func TestDaemonRemoveUnlockedAccount(t *testing.T) {
+	testHome(t)
+	other := addTestAccount(t, "")
+	active := addTestAccount(t, "")
+	d := testDaemon(t, active)
+	if err := d.unlockAccount(other, testPassword, false); err != nil {
+		t.Fatalf("unlock_account: %v", err)
+	}
+
+	if err := d.removeAccount(other, false); err == nil {
+		t.Fatal("removing an account unlocked with unlock_account succeeded without force")
+	}
+	if !accountExists(other) || !isUnlocked(d, other) {
+		t.Fatal("a refused removal touched the unlocked account")
+	}
+
+	if err := d.removeAccount(other, true); err != nil {
+		t.Fatalf("forced removal: %v", err)
+	}
+	if accountExists(other) {
+		t.Error("the account still exists after a forced removal")
+	}
+	if isUnlocked(d, other) {
+		t.Error("the removed account's key is still in memory")
+	}
+	if d.npub != active || d.privateKey == nil {
+		t.Error("removing an unlocked account locked the active one")
+	}
+}
+
+func TestDaemonRemoveStoredAccount(t *testing.T) {
+	testHome(t)
+	other := addTestAccount(t, "")
+	active := addTestAccount(t, "")
+	d := testDaemon(t, active)
+
+	// Neither active nor unlocked: no force needed
+	if err := d.removeAccount(other, false); err != nil {
+		t.Fatalf("remove_account: %v", err)
+	}
+	if accountExists(other) {
+		t.Error("the account still exists after removal")
+	}
+}
+
+// A removal purges what the daemon kept for the account - counts, cached
+// account list, trust session, conversation keys - and is announced on the
+// stream and in the audit log
+func TestDaemonRemoveAccountCleanup(t *testing.T) {
+	for _, state := range []string{"active", "unlocked", "stored"} {
+		t.Run(state, func(t *testing.T) {
+			testHome(t)
+			appConfig.AuditLog = true
+			appConfig.TrustTokenStore = trustTokenFile
+			other := addTestAccount(t, "")
+			active := addTestAccount(t, "")
+			d := testDaemon(t, active)
+			target := other
+			switch state {
+			case "active":
+				target = active
+			case "unlocked":
+				if err := d.unlockAccount(other, testPassword, false); err != nil {
+					t.Fatalf("unlock_account: %v", err)
+				}
+			}
+
+			encKey, err := loadAccountEncryptedKey(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nsec, privateKey, err := decryptAccountKey(encKey, target, testPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+			privateKey.Zero()
+			session, err := createTrustSession(nsec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := saveAccountTrustSession(target, session); err != nil {
+				t.Fatal(err)
+			}
+			d.counters.count(target, AccountCounters{EventsSigned: 1})
+			if accounts, err := d.accountWatch.list(); err != nil || len(accounts) != 2 {
+				t.Fatalf("account list: %d accounts, %v", len(accounts), err)
+			}
+			peer, _ := npubToPubkey(other)
+			if state == "unlocked" {
+				peer, _ = npubToPubkey(active)
+			}
+			if _, err := d.activeKey().conversationKey(peer); err != nil {
+				t.Fatal(err)
+			}
+
+			events := d.addSubscriber()
+			defer d.removeSubscriber(events)
+			serveTestDaemon(t, d)
+			var response AccountActionResponse
+			if err := dialTestDaemon(t).request(t, SignRequest{Method: "remove_account", Npub: target, Password: testPassword, Force: true}, &response); err != nil {
+				t.Fatal(err)
+			}
+			if !response.Success {
+				t.Fatalf("remove_account: %+v", response)
+			}
+
+			if _, pending := d.counters.pending[target]; pending {
+				t.Error("the removed account's counts are still pending")
+			}
+			d.counters.flush()
+			if accountExists(target) {
+				t.Error("the account exists after removal")
+			}
+			if hasAccountTrustSession(target) {
+				t.Error("the removed account's trust session is left")
+			}
+			if accounts, _ := d.accountWatch.list(); len(accounts) != 1 || accounts[0].Npub == target {
+				t.Errorf("cached account list after removal: %+v", accounts)
+			}
+			if isUnlocked(d, target) {
+				t.Error("the removed account's key is still in memory")
+			}
+			if cached := len(d.convKeys.keys); (cached == 0) != (state == "active") {
+				t.Errorf("%d conversation keys cached after removing the %s account", cached, state)
+			}
+
+			var got []string
+			for len(events) > 0 {
+				event := <-events
+				// The activity event of the request is not the removal's
+				if event.Npub == target && event.Type != "activity" {
+					got = append(got, event.Type)
+				}
+			}
+			want := []string{"account_removed"}
+			if state == "active" {
+				want = append(want, "locked")
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("stream events %v, want %v", got, want)
+			}
+
+			// The request is audited after its response went out
+			audited := func() bool {
+				entries, _, err := readAuditLog()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, entry := range entries {
+					if entry.Action == "remove_account" && entry.Npub == target && entry.Success {
+						return true
+					}
+				}
+				return false
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for !audited() {
+				if time.Now().After(deadline) {
+					t.Fatal("no remove_account entry for the account in the audit log")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// remove_account names its account exactly: by npub or label, never by a
+// list position or an npub prefix
+func TestDaemonRemoveAccountSelector(t *testing.T) {
+	testHome(t)
+	byNpub := addTestAccount(t, "")
+	byLabel := addTestAccount(t, "old")
+	active := addTestAccount(t, "")
+	d := testDaemon(t, active)
+	serveTestDaemon(t, d)
+	conn := dialTestDaemon(t)
+
+	remove := func(selector string) AccountActionResponse {
+		t.Helper()
+		var response AccountActionResponse
+		if err := conn.request(t, SignRequest{Method: "remove_account", Npub: selector, Password: testPassword}, &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	for _, selector := range []string{"1", "2", byNpub[:12], byNpub[:len(byNpub)-1]} {
+		response := remove(selector)
+		if response.Success || response.Code != codeUnknownAccount {
+			t.Errorf("remove_account %q: %+v, want ERR_UNKNOWN_ACCOUNT", selector, response)
+		}
+	}
+	if accounts, _ := listAccounts(); len(accounts) != 3 {
+		t.Fatalf("%d accounts left, want all 3", len(accounts))
+	}
+
+	if response := remove(strings.ToUpper(byNpub)); !response.Success || response.Npub != byNpub {
+		t.Errorf("remove_account by npub: %+v", response)
+	}
+	if response := remove("OLD"); !response.Success || response.Npub != byLabel {
+		t.Errorf("remove_account by label: %+v", response)
+	}
+	if accountExists(byNpub) || accountExists(byLabel) || !accountExists(active) {
+		t.Error("remove_account removed the wrong accounts")
+	}
+}
+
+// collidingTestKeys returns two new keys, as hex, whose npubs share their
+// first minNpubPrefixLen characters, and those npubs
+func collidingTestKeys(t *testing.T) ([2]string, [2]string) {
+	t.Helper()
+	seen := make(map[string][2]string)
+	for {
+		key, npub := testKey(t)
+		prefix := npub[:minNpubPrefixLen]
+		if first, ok := seen[prefix]; ok {
+			return [2]string{first[0], key}, [2]string{first[1], npub}
+		}
+		seen[prefix] = [2]string{key, npub}
+	}
+}
+
+func TestResolveAccount(t *testing.T) {
+	testHome(t)
+	keys, twins := collidingTestKeys(t)
+	for i, key := range keys {
+		if err := addAccount(&scriptedPrompter{answers: []string{key, testPassword, testPassword}}, ""); err != nil {
+			t.Fatalf("add-account %d: %v", i, err)
+		}
+	}
+	labeled := addTestAccount(t, "Work")
+	accounts, err := listAccounts()
+	if err != nil || len(accounts) != 3 {
+		t.Fatalf("listAccounts = %v, %v", accounts, err)
+	}
+	_, stranger := testKey(t)
+
+	// The shortest prefix of twin that no other account's npub starts with
+	unique := func(twin string) string {
+		for n := minNpubPrefixLen; ; n++ {
+			if !strings.HasPrefix(twins[0], twin[:n]) || !strings.HasPrefix(twins[1], twin[:n]) {
+				return twin[:n]
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		selector string
+		want     string
+		err      error
+	}{
+		{"first position", "1", accounts[0].Npub, nil},
+		{"last position", " 3 ", accounts[2].Npub, nil},
+		{"position zero", "0", "", errUnknownAccount},
+		{"position past the end", "4", "", errUnknownAccount},
+		{"exact npub", twins[1], twins[1], nil},
+		{"exact npub in uppercase", strings.ToUpper(twins[0]), twins[0], nil},
+		// An exact npub is taken as it is, never as a prefix, even without an account
+		{"npub without an account", stranger, stranger, nil},
+		{"label", "work", labeled, nil},
+		{"label in other case", "WORK", labeled, nil},
+		{"unknown label", "home", "", errUnknownAccount},
+		{"unique prefix", labeled[:20], labeled, nil},
+		{"unique prefix of a twin", unique(twins[0]), twins[0], nil},
+		{"prefix in uppercase", strings.ToUpper(labeled[:20]), labeled, nil},
+		{"ambiguous prefix", twins[0][:minNpubPrefixLen], "", errAmbiguousAccount},
+		{"no match", "npub1" + strings.Repeat("q", 20), "", errUnknownAccount},
+		{"too short", twins[0][:minNpubPrefixLen-1], "", errAccountField},
+		{"bare npub1", "npub1", "", errAccountField},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveAccount(tt.selector)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("resolveAccount(%q) = %s, %v; want %v", tt.selector, got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("resolveAccount(%q) = %s, %v; want %s", tt.selector, got, err, tt.want)
+			}
+		})
+	}
+
+	// The daemon answers an ambiguous prefix with the twins' npubs
+	d := testDaemon(t, labeled)
+	serveTestDaemon(t, d)
+	conn := dialTestDaemon(t)
+	var response AccountActionResponse
+	if err := conn.request(t, SignRequest{Method: "switch_account", Npub: twins[0][:minNpubPrefixLen], Password: testPassword}, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Success || response.Code != codeInvalidRequest || !strings.Contains(response.Error, twins[0]) || !strings.Contains(response.Error, twins[1]) {
+		t.Errorf("switch_account to an ambiguous prefix: %+v", response)
+	}
+}
+
+// A connection can't reuse a request ID among the last
+// maxTrackedRequestIDs; older IDs, other connections and requests without
+// an ID are not affected
+func TestDaemonDuplicateRequestID(t *testing.T) {
+	testHome(t)
+	d := testDaemon(t, addTestAccount(t, ""))
+	serveTestDaemon(t, d)
+	conn := dialTestDaemon(t)
+
+	ping := func(conn *testConn, id string) SignResponse {
+		t.Helper()
+		var response SignResponse
+		if err := conn.request(t, SignRequest{ID: id, Method: "ping"}, &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	duplicate := func(response SignResponse) bool {
+		return response.Code == codeDuplicateID
+	}
+
+	if response := ping(conn, "first"); duplicate(response) || response.ID != "first" {
+		t.Fatalf("first use of an id: %+v", response)
+	}
+	if response := ping(conn, "first"); !duplicate(response) || response.ID != "first" {
+		t.Errorf("reused id: %+v, want %s", response, codeDuplicateID)
+	}
+	for range 2 {
+		if response := ping(conn, ""); duplicate(response) {
+			t.Errorf("request without an id: %+v", response)
+		}
+	}
+	if response := ping(dialTestDaemon(t), "first"); duplicate(response) {
+		t.Errorf("id of another connection: %+v", response)
+	}
+
+	// Fill the remembered ids: "first" drops out, the newest stay
+	for i := range maxTrackedRequestIDs {
+		if response := ping(conn, fmt.Sprintf("id-%d", i)); duplicate(response) {
+			t.Fatalf("new id %d: %+v", i, response)
+		}
+	}
+	if response := ping(conn, "first"); duplicate(response) {
+		t.Errorf("id older than the last %d: %+v", maxTrackedRequestIDs, response)
+	}
+	if response := ping(conn, fmt.Sprintf("id-%d", maxTrackedRequestIDs-1)); !duplicate(response) {
+		t.Errorf("recent id: %+v, want %s", response, codeDuplicateID)
+	}
+}
@@ -3,10 +3,15 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
 	"syscall"
+	"time"
 )
 
 func getSysProcAttr() *syscall.SysProcAttr {
@@ -15,8 +20,48 @@ func getSysProcAttr() *syscall.SysProcAttr {
 	}
 }
 
-// getSocketPath returns the path to the Unix domain socket
+// checkFileOwnedByUser verifies path is owned by the current user and not world-writable
+func checkFileOwnedByUser(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the current user", path)
+	}
+	if info.Mode().Perm()&0002 != 0 {
+		return fmt.Errorf("%s is world-writable", path)
+	}
+
+	return nil
+}
+
+// getSocketPath returns the path to the Unix domain socket: socket_path
+// from config.json if set, else $XDG_RUNTIME_DIR/noorsigner/noorsigner.sock,
+// falling back to ~/.noorsigner/noorsigner.sock when XDG_RUNTIME_DIR is unset.
+// A relocated storage directory (NOORSIGNER_HOME) always keeps its own socket.
 func getSocketPath() (string, error) {
+	if socketPath := configuredSocketPath(); socketPath != "" {
+		return socketPath, nil
+	}
+	if storageDirOverride() != "" {
+		return getLegacySocketPath()
+	}
+
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		socketDir := filepath.Join(runtimeDir, "noorsigner")
+		if err := os.MkdirAll(socketDir, 0700); err != nil {
+			return "", fmt.Errorf("cannot create runtime directory: %v", err)
+		}
+		return filepath.Join(socketDir, "noorsigner.sock"), nil
+	}
+
+	return getLegacySocketPath()
+}
+
+// getLegacySocketPath returns the socket location inside the storage directory
+func getLegacySocketPath() (string, error) {
 	storageDir, err := getStorageDir()
 	if err != nil {
 		return "", err
@@ -31,8 +76,10 @@ func createListener() (net.Listener, error) {
 		return nil, err
 	}
 
-	// Remove existing socket if it exists
-	os.Remove(socketPath)
+	// Only remove an existing socket if nobody is answering on it
+	if err := removeStaleSocket(socketPath); err != nil {
+		return nil, err
+	}
 
 	// Create Unix Domain Socket
 	listener, err := net.Listen("unix", socketPath)
@@ -49,6 +96,114 @@ func createListener() (net.Listener, error) {
 	return listener, nil
 }
 
+// inspectListener reports the socket's observed mode and ownership
+func inspectListener(socketPath string) ListenerSecurity {
+	listener := ListenerSecurity{
+		Path:            socketPath,
+		Kind:            "unix_socket",
+		PeerCredentials: peerCredentialsMode,
+		Expected:        "0600, owned by the daemon user",
+	}
+
+	info, err := os.Lstat(socketPath)
+	if err != nil {
+		listener.Problems = append(listener.Problems, fmt.Sprintf("cannot stat socket: %v", err))
+		return listener
+	}
+	listener.Mode = fmt.Sprintf("%04o", info.Mode().Perm())
+
+	if info.Mode()&os.ModeSocket == 0 {
+		listener.Problems = append(listener.Problems, "not a socket")
+	}
+	if info.Mode().Perm() != 0600 {
+		listener.Problems = append(listener.Problems, fmt.Sprintf("mode is %s, expected 0600", listener.Mode))
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		listener.Owner = lookupUserName(stat.Uid)
+		listener.Group = lookupGroupName(stat.Gid)
+		if int(stat.Uid) != os.Getuid() {
+			listener.Problems = append(listener.Problems, fmt.Sprintf("owned by %s, not the current user", listener.Owner))
+		}
+	}
+
+	return listener
+}
+
+// fixListenerSecurity resets the socket mode to 0600. Ownership is not
+// changed - a socket owned by someone else needs a look by hand.
+func fixListenerSecurity(socketPath string) error {
+	info, err := os.Lstat(socketPath)
+	if err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the current user - check it by hand", socketPath)
+	}
+	return os.Chmod(socketPath, 0600)
+}
+
+// lookupUserName returns the user name for uid, or the uid itself
+func lookupUserName(uid uint32) string {
+	id := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+	return id
+}
+
+// lookupGroupName returns the group name for gid, or the gid itself
+func lookupGroupName(gid uint32) string {
+	id := strconv.FormatUint(uint64(gid), 10)
+	if g, err := user.LookupGroupId(id); err == nil {
+		return g.Name
+	}
+	return id
+}
+
+// lockFile takes an exclusive advisory lock on f (flock), waiting for it
+func lockFile(f *os.File) (func(), error) {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, err
+	}
+	return func() { syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }, nil
+}
+
+// tryLockFile is lockFile without waiting: ok is false while another
+// process holds the lock
+func tryLockFile(f *os.File) (unlock func(), ok bool, err error) {
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return func() { syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }, true, nil
+}
+
+// removeStaleSocket probes an existing socket file: a live daemon aborts the
+// start, a refused connection means the socket is stale and gets removed
+func removeStaleSocket(socketPath string) error {
+	if _, err := os.Lstat(socketPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%w (socket %s is in use)", errDaemonRunning, socketPath)
+	}
+
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("cannot probe existing socket %s: %v", socketPath, err)
+	}
+
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove stale socket: %v", err)
+	}
+	return nil
+}
+
 // cleanupListener removes the Unix socket file
 func cleanupListener() {
 	if socketPath, err := getSocketPath(); err == nil {
@@ -56,11 +211,31 @@ func cleanupListener() {
 	}
 }
 
-// dialConnection connects to the daemon via Unix socket
+// dialConnection connects to the daemon via Unix socket, using the same
+// resolution order as the daemon (runtime dir first, then storage dir)
 func dialConnection() (net.Conn, error) {
 	socketPath, err := getSocketPath()
 	if err != nil {
 		return nil, err
 	}
-	return net.Dial("unix", socketPath)
+
+	conn, err := net.Dial("unix", socketPath)
+	if err == nil {
+		return conn, nil
+	}
+
+	// Daemon may have been started without XDG_RUNTIME_DIR (e.g. from autostart)
+	legacyPath, legacyErr := getLegacySocketPath()
+	if legacyErr == nil && legacyPath != socketPath && configuredSocketPath() == "" {
+		if legacyConn, legacyErr := net.Dial("unix", legacyPath); legacyErr == nil {
+			return legacyConn, nil
+		}
+	}
+
+	// A sandboxed client may see neither file, only the abstract socket (Linux)
+	if abstractConn, abstractErr := dialAbstractSocket(); abstractErr == nil {
+		return abstractConn, nil
+	}
+
+	return nil, err
 }
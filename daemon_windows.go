@@ -0,0 +1,255 @@
+//go:build windows
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/Microsoft/go-winio"
+	"golang.org/x/sys/windows"
+)
+
+// legacyPipeName is the global pipe name used by older releases
+const legacyPipeName = `\\.\pipe\noorsigner`
+
+// pipeDialTimeout bounds how long a client waits for a busy pipe
+const pipeDialTimeout = 2 * time.Second
+
+func getSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		CreationFlags: windows.CREATE_NEW_PROCESS_GROUP | windows.DETACHED_PROCESS,
+		HideWindow:    true,
+	}
+}
+
+// currentUserSID returns the SID of the user running this process
+func currentUserSID() (*windows.SID, error) {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get current user: %v", err)
+	}
+	return user.User.Sid.Copy()
+}
+
+// getSocketPath returns socket_path from config.json if set, else the per-user
+// named pipe, e.g. \\.\pipe\noorsigner-S-1-5-21-... so daemons of different
+// users on one machine never collide. A relocated storage directory
+// (NOORSIGNER_HOME) gets its own pipe, suffixed with a hash of its path.
+func getSocketPath() (string, error) {
+	if pipeName := configuredSocketPath(); pipeName != "" {
+		return pipeName, nil
+	}
+
+	sid, err := currentUserSID()
+	if err != nil {
+		return "", err
+	}
+	pipeName := legacyPipeName + "-" + sid.String()
+
+	if storageDir := storageDirOverride(); storageDir != "" {
+		sum := sha256.Sum256([]byte(strings.ToLower(storageDir)))
+		pipeName += "-" + hex.EncodeToString(sum[:4])
+	}
+	return pipeName, nil
+}
+
+// createListener creates a named pipe listener only the owning user can open
+func createListener() (net.Listener, error) {
+	pipeName, err := getSocketPath()
+	if err != nil {
+		return nil, err
+	}
+
+	sid, err := currentUserSID()
+	if err != nil {
+		return nil, err
+	}
+
+	// Protected DACL granting full access to the owning user only
+	config := &winio.PipeConfig{
+		SecurityDescriptor: fmt.Sprintf("D:P(A;;GA;;;%s)", sid.String()),
+	}
+
+	return winio.ListenPipe(pipeName, config)
+}
+
+// inspectListener reports the pipe's observed owner and DACL. Reading the
+// security info opens the pipe like a client would; the daemon sees a
+// connection that closes right away.
+func inspectListener(pipeName string) ListenerSecurity {
+	listener := ListenerSecurity{
+		Path:            pipeName,
+		Kind:            "named_pipe",
+		PeerCredentials: peerCredentialsMode,
+		Expected:        "protected DACL granting access to the daemon user only",
+	}
+
+	sd, err := windows.GetNamedSecurityInfo(pipeName, windows.SE_FILE_OBJECT,
+		windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		listener.Problems = append(listener.Problems, fmt.Sprintf("cannot read security info: %v", err))
+		return listener
+	}
+	listener.DACL = sd.String()
+
+	if owner, _, err := sd.Owner(); err == nil {
+		if account, domain, _, err := owner.LookupAccount(""); err == nil {
+			listener.Owner = domain + `\` + account
+		} else {
+			listener.Owner = owner.String()
+		}
+	}
+
+	if err := checkFileOwnedByUser(pipeName); err != nil {
+		listener.Problems = append(listener.Problems, err.Error())
+	}
+	if control, _, err := sd.Control(); err == nil && control&windows.SE_DACL_PROTECTED == 0 {
+		listener.Problems = append(listener.Problems, "DACL is not protected (inherits entries)")
+	}
+
+	return listener
+}
+
+// fixListenerSecurity can't repair a pipe: its DACL is set when the daemon
+// creates it, so restart the daemon
+func fixListenerSecurity(pipeName string) error {
+	return fmt.Errorf("restart the daemon to recreate %s", pipeName)
+}
+
+// peerCredentialsMode is reported as peer_credentials in get_status
+const peerCredentialsMode = "not_checked"
+
+// peerIsSameUser is true for every pipe client: the pipe's DACL admits only
+// the daemon's user, so nobody else can connect. Other connections (the
+// WebSocket of --http) are not vouched for.
+func peerIsSameUser(conn net.Conn) (bool, error) {
+	if _, isPipe := conn.(winio.PipeConn); !isPipe {
+		return false, errors.New("not a named pipe client")
+	}
+	return true, nil
+}
+
+// peerCredentials is not needed on Windows: the pipe's DACL decides who
+// can connect
+func peerCredentials(conn net.Conn) (peerCred, error) {
+	return peerCred{}, errPeerCredentialsUnsupported
+}
+
+// lockFile takes an exclusive lock on f (LockFileEx), waiting for it
+func lockFile(f *os.File) (func(), error) {
+	handle := windows.Handle(f.Fd())
+	overlapped := new(windows.Overlapped)
+	if err := windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, overlapped); err != nil {
+		return nil, err
+	}
+	return func() { windows.UnlockFileEx(handle, 0, 1, 0, overlapped) }, nil
+}
+
+// tryLockFile is lockFile without waiting: ok is false while another
+// process holds the lock
+func tryLockFile(f *os.File) (unlock func(), ok bool, err error) {
+	handle := windows.Handle(f.Fd())
+	overlapped := new(windows.Overlapped)
+	err = windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, overlapped)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return func() { windows.UnlockFileEx(handle, 0, 1, 0, overlapped) }, true, nil
+}
+
+// cleanupListener is a no-op on Windows (pipes disappear with the listener)
+func cleanupListener() {}
+
+// dialConnection connects to the daemon via the per-user named pipe,
+// falling back to the legacy global pipe name used by older daemons
+func dialConnection() (net.Conn, error) {
+	pipeName, err := getSocketPath()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := pipeDialTimeout
+	conn, err := winio.DialPipe(pipeName, &timeout)
+	if err == nil {
+		return conn, nil
+	}
+
+	if configuredSocketPath() != "" || storageDirOverride() != "" {
+		return nil, err
+	}
+	if legacyConn, legacyErr := winio.DialPipe(legacyPipeName, &timeout); legacyErr == nil {
+		return legacyConn, nil
+	}
+
+	return nil, err
+}
+
+// checkFileOwnedByUser verifies path is owned by the current user and that
+// no broad group (Everyone, Authenticated Users, Users) may write to it
+func checkFileOwnedByUser(path string) error {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return fmt.Errorf("cannot read security info for %s: %v", path, err)
+	}
+
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return fmt.Errorf("cannot read owner of %s: %v", path, err)
+	}
+	userSID, err := currentUserSID()
+	if err != nil {
+		return err
+	}
+	if !owner.Equals(userSID) {
+		return fmt.Errorf("%s is not owned by the current user", path)
+	}
+
+	dacl, _, err := sd.DACL()
+	if err != nil || dacl == nil {
+		// A missing DACL grants everyone full access
+		return fmt.Errorf("%s has no access control list", path)
+	}
+
+	var broadSIDs []*windows.SID
+	for _, sidType := range []windows.WELL_KNOWN_SID_TYPE{
+		windows.WinWorldSid,
+		windows.WinAuthenticatedUserSid,
+		windows.WinBuiltinUsersSid,
+	} {
+		if sid, err := windows.CreateWellKnownSid(sidType); err == nil {
+			broadSIDs = append(broadSIDs, sid)
+		}
+	}
+
+	const writeMask = windows.FILE_WRITE_DATA | windows.FILE_APPEND_DATA | windows.GENERIC_WRITE | windows.GENERIC_ALL
+	for i := uint32(0); i < uint32(dacl.AceCount); i++ {
+		var ace *windows.ACCESS_ALLOWED_ACE
+		if err := windows.GetAce(dacl, i, &ace); err != nil {
+			continue
+		}
+		if ace.Header.AceType != windows.ACCESS_ALLOWED_ACE_TYPE || ace.Mask&writeMask == 0 {
+			continue
+		}
+		aceSID := (*windows.SID)(unsafe.Pointer(&ace.SidStart))
+		for _, broad := range broadSIDs {
+			if aceSID.Equals(broad) {
+				return fmt.Errorf("%s is writable by %s", path, broad.String())
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+const (
+	// delegationTagName is the first element of a NIP-26 delegation tag
+	delegationTagName = "delegation"
+
+	// maxDelegationKind is the highest kind a condition can name
+	maxDelegationKind = 65535
+)
+
+// delegationConditions are the parsed conditions of a NIP-26 delegation:
+// the event's kind must be one of Kinds (any kind if empty) and its
+// created_at must lie strictly between Since and Until (0 = no bound)
+type delegationConditions struct {
+	Kinds []int
+	Since int64
+	Until int64
+}
+
+// String renders the conditions as NIP-26 query string: kinds in order,
+// then created_at>since, then created_at<until
+func (c *delegationConditions) String() string {
+	var clauses []string
+	for _, kind := range c.Kinds {
+		clauses = append(clauses, "kind="+strconv.Itoa(kind))
+	}
+	if c.Since != 0 {
+		clauses = append(clauses, "created_at>"+strconv.FormatInt(c.Since, 10))
+	}
+	if c.Until != 0 {
+		clauses = append(clauses, "created_at<"+strconv.FormatInt(c.Until, 10))
+	}
+	return strings.Join(clauses, "&")
+}
+
+// allows returns why an event of kind created at createdAt falls outside
+// the conditions, nil if it doesn't
+func (c *delegationConditions) allows(kind int, createdAt int64) error {
+	if len(c.Kinds) > 0 {
+		delegated := false
+		for _, k := range c.Kinds {
+			delegated = delegated || k == kind
+		}
+		if !delegated {
+			return fmt.Errorf("%s is not delegated", describeKind(kind))
+		}
+	}
+	if c.Since != 0 && createdAt <= c.Since {
+		return fmt.Errorf("created_at %d is not after %d", createdAt, c.Since)
+	}
+	if c.Until != 0 && createdAt >= c.Until {
+		return fmt.Errorf("created_at %d is not before %d", createdAt, c.Until)
+	}
+	return nil
+}
+
+// parseDelegationConditions parses a NIP-26 conditions string. Clauses are
+// kind=<n>, created_at<<t> and created_at><t>, joined by &; anything else
+// is refused rather than ignored, since a verifier that skips a clause
+// grants more than was delegated. Repeated time bounds keep the tightest.
+func parseDelegationConditions(conditions string) (*delegationConditions, error) {
+	var parsed delegationConditions
+	if conditions == "" {
+		return &parsed, nil
+	}
+	for _, clause := range strings.Split(conditions, "&") {
+		var field, value string
+		switch {
+		case strings.HasPrefix(clause, "kind="):
+			field, value = "kind", strings.TrimPrefix(clause, "kind=")
+		case strings.HasPrefix(clause, "created_at<"):
+			field, value = "created_at<", strings.TrimPrefix(clause, "created_at<")
+		case strings.HasPrefix(clause, "created_at>"):
+			field, value = "created_at>", strings.TrimPrefix(clause, "created_at>")
+		default:
+			return nil, fmt.Errorf("unknown condition %q", clause)
+		}
+		if value == "" || strings.Trim(value, "0123456789") != "" {
+			return nil, fmt.Errorf("condition %q: expected a non-negative integer", clause)
+		}
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %v", clause, errors.Unwrap(err))
+		}
+
+		switch field {
+		case "kind":
+			if number > maxDelegationKind {
+				return nil, fmt.Errorf("condition %q: kinds go up to %d", clause, maxDelegationKind)
+			}
+			parsed.Kinds = append(parsed.Kinds, int(number))
+		case "created_at<":
+			if parsed.Until == 0 || number < parsed.Until {
+				parsed.Until = number
+			}
+		case "created_at>":
+			if number > parsed.Since {
+				parsed.Since = number
+			}
+		}
+	}
+	return &parsed, nil
+}
+
+// delegationToken returns the hash the delegator signs:
+// sha256("nostr:delegation:<delegatee>:<conditions>")
+func delegationToken(delegatee, conditions string) []byte {
+	token := sha256.Sum256([]byte("nostr:delegation:" + delegatee + ":" + conditions))
+	return token[:]
+}
+
+// signDelegation returns the delegation tag letting delegatee publish
+// under delegator's pubkey within conditions
+func signDelegation(privateKey *btcec.PrivateKey, delegator, delegatee string, conditions *delegationConditions) ([]string, error) {
+	rendered := conditions.String()
+	signature, err := signNostrEvent(privateKey, delegationToken(delegatee, rendered))
+	if err != nil {
+		return nil, err
+	}
+	return []string{delegationTagName, delegator, rendered, signature}, nil
+}
+
+// DelegationResult is the outcome of checking a NIP-26 delegation tag
+type DelegationResult struct {
+	Valid bool `json:"valid"`
+	// Problem says why the delegation is not valid
+	Problem    string `json:"problem,omitempty"`
+	Delegator  string `json:"delegator,omitempty"`
+	Delegatee  string `json:"delegatee,omitempty"`
+	Conditions string `json:"conditions,omitempty"`
+	Kinds      []int  `json:"kinds,omitempty"`
+	Since      int64  `json:"since,omitempty"`
+	Until      int64  `json:"until,omitempty"`
+	// Expired means until has passed, so no new event can use it
+	Expired bool `json:"expired,omitempty"`
+}
+
+// VerifyDelegationResponse answers verify_delegation
+type VerifyDelegationResponse struct {
+	ID string `json:"id"`
+	DelegationResult
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// verifyDelegation checks a delegation tag for delegatee (hex): its form,
+// its conditions and the delegator's signature. With an event, the event's
+// kind and created_at must also meet the conditions; its own id and
+// signature are not checked.
+func verifyDelegation(tag []string, delegatee string, event *NostrEvent) DelegationResult {
+	result := DelegationResult{Delegatee: delegatee}
+	invalid := func(format string, args ...interface{}) DelegationResult {
+		result.Problem = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	if len(tag) != 4 || tag[0] != delegationTagName {
+		return invalid("not a delegation tag: expected [\"delegation\", <delegator pubkey>, <conditions>, <signature>]")
+	}
+	if pubkey, err := parsePubkey(tag[1]); err != nil || pubkey != tag[1] {
+		return invalid("delegator must be a hex pubkey on the curve")
+	}
+	result.Delegator, result.Conditions = tag[1], tag[2]
+
+	conditions, err := parseDelegationConditions(tag[2])
+	if err != nil {
+		return invalid("invalid conditions: %v", err)
+	}
+	result.Kinds, result.Since, result.Until = conditions.Kinds, conditions.Since, conditions.Until
+	result.Expired = conditions.Until != 0 && time.Now().Unix() >= conditions.Until
+
+	sigBytes, err := hex.DecodeString(tag[3])
+	if err != nil || len(sigBytes) != schnorr.SignatureSize {
+		return invalid("signature must be %d hex characters", 2*schnorr.SignatureSize)
+	}
+	signature, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return invalid("invalid signature: %v", err)
+	}
+	delegatorBytes, _ := hex.DecodeString(result.Delegator)
+	delegatorKey, _ := schnorr.ParsePubKey(delegatorBytes)
+	if !signature.Verify(delegationToken(delegatee, tag[2]), delegatorKey) {
+		return invalid("signature does not match: the delegator didn't sign these conditions for this delegatee")
+	}
+
+	if event != nil {
+		if err := conditions.allows(event.Kind, event.CreatedAt); err != nil {
+			return invalid("event outside the delegation: %v", err)
+		}
+	}
+	result.Valid = true
+	return result
+}
+
+// delegationFromEvent parses a delegated event and returns its delegation
+// tag (nil if it has none)
+func delegationFromEvent(eventJSON string) (*NostrEvent, []string, error) {
+	var event NostrEvent
+	if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
+		return nil, nil, fmt.Errorf("invalid event JSON: %v", err)
+	}
+	if pubkey, err := parsePubkey(event.Pubkey); err != nil || pubkey != event.Pubkey {
+		return nil, nil, fmt.Errorf("event pubkey must be a hex pubkey on the curve")
+	}
+	for _, tag := range event.Tags {
+		if len(tag) > 0 && tag[0] == delegationTagName {
+			return &event, tag, nil
+		}
+	}
+	return &event, nil, nil
+}
+
+// checkDelegationRequest checks the delegation of a verify_delegation
+// request: a signed event carrying its tag in event_json, or delegation and
+// delegatee. Missing parameters are an error; a delegation that doesn't
+// hold is a result.
+func checkDelegationRequest(req SignRequest) (DelegationResult, error) {
+	if req.EventJSON != "" {
+		if req.Delegation != nil || req.Delegatee != "" {
+			return DelegationResult{}, errors.New("event_json carries the delegation - don't also pass delegation or delegatee")
+		}
+		event, tag, err := delegationFromEvent(req.EventJSON)
+		if err != nil {
+			return DelegationResult{}, err
+		}
+		if tag == nil {
+			return DelegationResult{Delegatee: event.Pubkey, Problem: "event has no delegation tag"}, nil
+		}
+		return verifyDelegation(tag, event.Pubkey, event), nil
+	}
+
+	if req.Delegation == nil || req.Delegatee == "" {
+		return DelegationResult{}, errors.New("delegation and delegatee required (or event_json)")
+	}
+	delegatee, err := parsePubkey(req.Delegatee)
+	if err != nil {
+		return DelegationResult{}, fmt.Errorf("invalid delegatee: %v", err)
+	}
+	return verifyDelegation(req.Delegation, delegatee, nil), nil
+}
+
+// parseDelegationTime reads a --since or --until value: a Unix time, a
+// date or date and time (see parseLocalTime), or a duration from now
+func parseDelegationTime(flag, value string, now time.Time) (int64, error) {
+	if value != "" && strings.Trim(value, "0123456789") == "" {
+		return strconv.ParseInt(value, 10, 64)
+	}
+	if t, ok := parseLocalTime(value); ok {
+		return t.Unix(), nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(duration).Unix(), nil
+	}
+	return 0, fmt.Errorf("invalid %s %q: use a Unix time, a date (2025-08-01), a date and time (\"2025-08-01 18:00\") or a duration (720h)", flag, value)
+}
+
+// parseDelegationKinds reads --kinds: a comma-separated list of kinds
+func parseDelegationKinds(value string) ([]int, error) {
+	var kinds []int
+	seen := make(map[int]bool)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		kind, err := strconv.Atoi(field)
+		if err != nil || kind < 0 || kind > maxDelegationKind {
+			return nil, fmt.Errorf("invalid --kinds entry %q: expected kinds 0-%d, e.g. 1,7", field, maxDelegationKind)
+		}
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds, nil
+}
+
+// delegateCmd signs a NIP-26 delegation from the active account to another
+// key and prints the delegation tag on stdout. Kinds and an end are
+// required: an open delegation would let the other key post anything,
+// forever, as the account.
+func delegateCmd(args []string) error {
+	usage := "noorsigner delegate <delegatee npub|hex> --kinds <k,k...> --until <time> [--since <time>] " + passwordFlagsUsage
+	args, err := passwordArgs(args, usage)
+	if err != nil {
+		return err
+	}
+
+	// stdout carries only the tag
+	redirectChatter()
+	activeNpub, activePubkey, err := loadActiveSigner()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var delegatee, kinds, since, until string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--kinds" && i+1 < len(args):
+			i++
+			kinds = args[i]
+		case args[i] == "--since" && i+1 < len(args):
+			i++
+			since = args[i]
+		case args[i] == "--until" && i+1 < len(args):
+			i++
+			until = args[i]
+		case !strings.HasPrefix(args[i], "--") && delegatee == "":
+			delegatee = args[i]
+		default:
+			return commandFailed(1, "Usage: %s", usage)
+		}
+	}
+	if delegatee == "" || kinds == "" || until == "" {
+		return commandFailed(1, "Usage: %s\n--kinds and --until are required: a delegation without them lets the other key post anything as you, forever.", usage)
+	}
+
+	delegateePubkey, err := parsePubkey(delegatee)
+	if err != nil {
+		return commandFailed(1, "❌ Invalid delegatee: %v", err)
+	}
+	if delegateePubkey == activePubkey {
+		return commandFailed(1, "❌ The delegatee is the active account itself")
+	}
+	var conditions delegationConditions
+	if conditions.Kinds, err = parseDelegationKinds(kinds); err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if conditions.Until, err = parseDelegationTime("--until", until, now); err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if conditions.Until <= now.Unix() {
+		return commandFailed(1, "❌ --until %s is not in the future", time.Unix(conditions.Until, 0).Format("2006-01-02 15:04 MST"))
+	}
+	if since != "" {
+		if conditions.Since, err = parseDelegationTime("--since", since, now); err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+		if conditions.Since >= conditions.Until {
+			return commandFailed(1, "❌ --since must be before --until")
+		}
+	}
+
+	// The delegatee's events don't pass through the signing policy, so a
+	// kind it denies can't be delegated either
+	policy := newSigningPolicy(appConfig)
+	for _, kind := range conditions.Kinds {
+		if policy.action(kind) == policyDeny {
+			err := fmt.Errorf("signing policy denies %s", describeKind(kind))
+			auditCLI("delegate", activeNpub, err, &kind)
+			return commandFailed(1, "⛔ Signing policy denies %s - it can't be delegated either", describeKind(kind))
+		}
+	}
+
+	delegateeNpub, _ := pubkeyToNpub(delegateePubkey)
+	fmt.Printf("Delegating to: %s\n", displayNpub(delegateeNpub))
+	for _, kind := range conditions.Kinds {
+		fmt.Printf("   %s\n", describeKind(kind))
+	}
+	fmt.Printf("Until: %s\n", time.Unix(conditions.Until, 0).Format("2006-01-02 15:04"))
+
+	privateKey, err := unlockActiveAccount(activeNpub)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signing as: %s\n", displayNpub(activeNpub))
+
+	tag, err := signDelegation(privateKey, activePubkey, delegateePubkey, &conditions)
+	auditCLI("delegate", activeNpub, err, nil)
+	if err != nil {
+		return commandFailed(1, "Error signing: %v", err)
+	}
+	printJSON(tag)
+	return nil
+}
+
+// verifyDelegationCmd checks a delegation tag, given with its delegatee or
+// inside a delegated event: exit 0 if it holds, 1 if not
+func verifyDelegationCmd(args []string) error {
+	usage := "noorsigner verify-delegation '<tag JSON>' <delegatee npub|hex> | --event <path|->"
+
+	var req SignRequest
+	switch {
+	case len(args) == 2 && args[0] == "--event":
+		input, err := readEventInput(args[1])
+		if err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+		req.EventJSON = string(input)
+	case len(args) == 2:
+		if err := json.Unmarshal([]byte(args[0]), &req.Delegation); err != nil || req.Delegation == nil {
+			return commandFailed(1, "❌ Invalid tag: expected a JSON array like [\"delegation\",\"<pubkey>\",\"<conditions>\",\"<sig>\"]")
+		}
+		req.Delegatee = args[1]
+	default:
+		return commandFailed(1, "Usage: %s", usage)
+	}
+
+	result, err := checkDelegationRequest(req)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if jsonOutput {
+		printJSON(result)
+		if !result.Valid {
+			return &commandError{Code: 1}
+		}
+		return nil
+	}
+
+	if !result.Valid {
+		return commandFailed(1, "❌ Invalid delegation: %s", result.Problem)
+	}
+	delegatorNpub, _ := pubkeyToNpub(result.Delegator)
+	delegateeNpub, _ := pubkeyToNpub(result.Delegatee)
+	fmt.Println("✅ Valid delegation")
+	fmt.Printf("Delegator:  %s\n", delegatorNpub)
+	fmt.Printf("Delegatee:  %s\n", delegateeNpub)
+	if len(result.Kinds) == 0 {
+		fmt.Println("Kinds:      any")
+	}
+	for i, kind := range result.Kinds {
+		prefix := "Kinds:     "
+		if i > 0 {
+			prefix = "           "
+		}
+		fmt.Printf("%s %s\n", prefix, describeKind(kind))
+	}
+	if result.Since != 0 {
+		fmt.Printf("Since:      %s\n", time.Unix(result.Since, 0).Format("2006-01-02 15:04"))
+	}
+	switch {
+	case result.Until == 0:
+		fmt.Println("Until:      no end")
+	case result.Expired:
+		fmt.Printf("Until:      %s (expired)\n", time.Unix(result.Until, 0).Format("2006-01-02 15:04"))
+	default:
+		fmt.Printf("Until:      %s\n", time.Unix(result.Until, 0).Format("2006-01-02 15:04"))
+	}
+	if req.EventJSON != "" {
+		fmt.Println("The event's kind and created_at meet the conditions (its own id and signature were not checked)")
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// The conditions of the NIP-26 example
+const nip26ExampleConditions = "kind=1&created_at>1674834236&created_at<1677426236"
+
+func TestDelegationConditionsString(t *testing.T) {
+	tests := []struct {
+		conditions delegationConditions
+		want       string
+	}{
+		{delegationConditions{}, ""},
+		{delegationConditions{Kinds: []int{1}, Since: 1674834236, Until: 1677426236}, nip26ExampleConditions},
+		{delegationConditions{Kinds: []int{1, 7}}, "kind=1&kind=7"},
+		{delegationConditions{Kinds: []int{0, 65535}, Until: 1}, "kind=0&kind=65535&created_at<1"},
+		{delegationConditions{Since: 1700000000}, "created_at>1700000000"},
+	}
+	for _, tt := range tests {
+		got := tt.conditions.String()
+		if got != tt.want {
+			t.Errorf("%+v renders as %q, want %q", tt.conditions, got, tt.want)
+		}
+		parsed, err := parseDelegationConditions(got)
+		if err != nil {
+			t.Errorf("parseDelegationConditions(%q): %v", got, err)
+			continue
+		}
+		if parsed.String() != got || !reflect.DeepEqual(parsed.Kinds, tt.conditions.Kinds) ||
+			parsed.Since != tt.conditions.Since || parsed.Until != tt.conditions.Until {
+			t.Errorf("%q parses to %+v, want %+v", got, parsed, tt.conditions)
+		}
+	}
+}
+
+func TestParseDelegationConditions(t *testing.T) {
+	tests := []struct {
+		conditions string
+		want       *delegationConditions // nil: refused
+	}{
+		{nip26ExampleConditions, &delegationConditions{Kinds: []int{1}, Since: 1674834236, Until: 1677426236}},
+		{"created_at<1677426236&kind=1", &delegationConditions{Kinds: []int{1}, Until: 1677426236}},
+		{"created_at<200&created_at<100&created_at>5&created_at>10", &delegationConditions{Since: 10, Until: 100}},
+		{"kind=0", &delegationConditions{Kinds: []int{0}}},
+		{"kind=65535", &delegationConditions{Kinds: []int{65535}}},
+		{"kind=65536", nil},
+		{"kind=-1", nil},
+		{"kind=+1", nil},
+		{"kind=1.5", nil},
+		{"kind=abc", nil},
+		{"kind=", nil},
+		{"kind=1&", nil},
+		{"&kind=1", nil},
+		{"kind = 1", nil},
+		{"Kind=1", nil},
+		{"kind=1;created_at<5", nil},
+		{"created_at=5", nil},
+		{"created_at<=5", nil},
+		{"created_at>99999999999999999999", nil},
+		{"pubkey=abc", nil},
+	}
+	for _, tt := range tests {
+		parsed, err := parseDelegationConditions(tt.conditions)
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("%q: accepted as %+v", tt.conditions, parsed)
+		case tt.want != nil && err != nil:
+			t.Errorf("%q: %v", tt.conditions, err)
+		case tt.want != nil && !reflect.DeepEqual(parsed, tt.want):
+			t.Errorf("%q: parsed %+v, want %+v", tt.conditions, parsed, tt.want)
+		}
+	}
+}
+
+func TestDelegationConditionsAllows(t *testing.T) {
+	conditions := &delegationConditions{Kinds: []int{1, 7}, Since: 100, Until: 200}
+	tests := []struct {
+		kind      int
+		createdAt int64
+		allowed   bool
+	}{
+		{1, 150, true},
+		{7, 101, true},
+		{7, 199, true},
+		{0, 150, false},
+		{1, 100, false},
+		{1, 200, false},
+		{1, 50, false},
+	}
+	for _, tt := range tests {
+		if err := conditions.allows(tt.kind, tt.createdAt); (err == nil) != tt.allowed {
+			t.Errorf("kind %d at %d: %v, want allowed %v", tt.kind, tt.createdAt, err, tt.allowed)
+		}
+	}
+	if err := (&delegationConditions{}).allows(30023, 1); err != nil {
+		t.Errorf("empty conditions refuse: %v", err)
+	}
+}
+
+// testDelegation returns a delegator's key and pubkey and a delegatee pubkey
+func testDelegation(t *testing.T) (*btcec.PrivateKey, string, string) {
+	t.Helper()
+	delegator, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delegatee, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delegator, hex.EncodeToString(schnorr.SerializePubKey(delegator.PubKey())),
+		hex.EncodeToString(schnorr.SerializePubKey(delegatee.PubKey()))
+}
+
+// mustNpub returns the npub of a hex pubkey
+func mustNpub(t *testing.T, pubkey string) string {
+	t.Helper()
+	npub, err := pubkeyToNpub(pubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return npub
+}
+
+func TestVerifyDelegationRequest(t *testing.T) {
+	testHome(t)
+	d := testDaemon(t, addTestAccount(t, ""))
+	serveTestDaemon(t, d)
+	conn := dialTestDaemon(t)
+
+	delegator, delegatorPubkey, delegateePubkey := testDelegation(t)
+	until := time.Now().Add(time.Hour).Unix()
+	valid, err := signDelegation(delegator, delegatorPubkey, delegateePubkey, &delegationConditions{Kinds: []int{1, 7}, Until: until})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := signDelegation(delegator, delegatorPubkey, delegateePubkey, &delegationConditions{Kinds: []int{1}, Since: 1674834236, Until: 1677426236})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A condition string that doesn't parse, signed as it is
+	badKindConditions := "kind=note&created_at<" + strings.Repeat("9", 10)
+	badKindSig, err := signNostrEvent(delegator, delegationToken(delegateePubkey, badKindConditions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	badKind := []string{delegationTagName, delegatorPubkey, badKindConditions, badKindSig}
+	widened := append([]string{}, valid...)
+	widened[2] = "kind=1&kind=7&kind=0&created_at<" + strings.Repeat("9", 10)
+	_, _, otherPubkey := testDelegation(t)
+
+	event := func(kind int, createdAt int64, tag []string) string {
+		data, _ := json.Marshal(map[string]any{
+			"pubkey": delegateePubkey, "kind": kind, "created_at": createdAt, "tags": [][]string{tag}, "content": "",
+		})
+		return string(data)
+	}
+
+	tests := []struct {
+		name    string
+		req     SignRequest
+		valid   bool
+		expired bool
+		problem string
+	}{
+		{"valid", SignRequest{Delegation: valid, Delegatee: delegateePubkey}, true, false, ""},
+		{"valid, delegatee as npub", SignRequest{Delegation: valid, Delegatee: mustNpub(t, delegateePubkey)}, true, false, ""},
+		{"expired", SignRequest{Delegation: expired, Delegatee: delegateePubkey}, true, true, ""},
+		{"invalid kind condition", SignRequest{Delegation: badKind, Delegatee: delegateePubkey}, false, false, "invalid conditions"},
+		{"widened conditions", SignRequest{Delegation: widened, Delegatee: delegateePubkey}, false, false, "signature does not match"},
+		{"other delegatee", SignRequest{Delegation: valid, Delegatee: otherPubkey}, false, false, "signature does not match"},
+		{"not a delegation tag", SignRequest{Delegation: []string{"p", delegatorPubkey}, Delegatee: delegateePubkey}, false, false, "not a delegation tag"},
+		{"event within", SignRequest{EventJSON: event(7, until-10, valid)}, true, false, ""},
+		{"event of another kind", SignRequest{EventJSON: event(0, until-10, valid)}, false, false, "event outside the delegation"},
+		{"event after the expired until", SignRequest{EventJSON: event(1, time.Now().Unix(), expired)}, false, true, "event outside the delegation"},
+		{"event without delegation", SignRequest{EventJSON: event(1, until-10, []string{"t", "x"})}, false, false, "no delegation tag"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.ID = fmt.Sprintf("verify-%d", i)
+			tt.req.Method = "verify_delegation"
+			var response VerifyDelegationResponse
+			if err := conn.request(t, tt.req, &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Error != "" {
+				t.Fatalf("verify_delegation: %s", response.Error)
+			}
+			if response.Valid != tt.valid || response.Expired != tt.expired || !strings.Contains(response.Problem, tt.problem) {
+				t.Errorf("got %+v, want valid %v, expired %v, problem %q", response.DelegationResult, tt.valid, tt.expired, tt.problem)
+			}
+			if tt.valid && response.Delegator != delegatorPubkey {
+				t.Errorf("delegator %s, want %s", response.Delegator, delegatorPubkey)
+			}
+		})
+	}
+
+	for _, req := range []SignRequest{
+		{ID: "missing", Method: "verify_delegation"},
+		{ID: "no delegatee", Method: "verify_delegation", Delegation: valid},
+		{ID: "both", Method: "verify_delegation", Delegation: valid, Delegatee: delegateePubkey, EventJSON: event(1, until-10, valid)},
+	} {
+		var response VerifyDelegationResponse
+		if err := conn.request(t, req, &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != codeInvalidRequest {
+			t.Errorf("%s: %+v, want %s", req.ID, response, codeInvalidRequest)
+		}
+	}
+}
+
+func TestVerifyDelegationCmd(t *testing.T) {
+	delegator, delegatorPubkey, delegateePubkey := testDelegation(t)
+	tag, err := signDelegation(delegator, delegatorPubkey, delegateePubkey, &delegationConditions{Kinds: []int{1}, Until: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagJSON, _ := json.Marshal(tag)
+	_, _, otherPubkey := testDelegation(t)
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"valid", []string{string(tagJSON), delegateePubkey}, 0},
+		{"other delegatee", []string{string(tagJSON), otherPubkey}, 1},
+		{"invalid tag JSON", []string{"[delegation", delegateePubkey}, 1},
+		{"no delegatee", []string{string(tagJSON)}, 1},
+	}
+	for _, tt := range tests {
+		var err error
+		captureStdout(t, func() { err = verifyDelegationCmd(tt.args) })
+		if exitCode(err) != tt.code {
+			t.Errorf("%s: %v, want exit code %d", tt.name, err, tt.code)
+		}
+	}
+}
+
+func TestDelegateCmdUsage(t *testing.T) {
+	testHome(t)
+	// delegateCmd sends chatter to stderr and keeps stdout for the tag
+	previous := jsonStdout
+	t.Cleanup(func() { jsonStdout = previous })
+	addTestAccount(t, "")
+	_, _, delegateePubkey := testDelegation(t)
+	for _, args := range [][]string{
+		{delegateePubkey},
+		{delegateePubkey, "--kinds", "1"},
+		{delegateePubkey, "--until", "720h"},
+		{delegateePubkey, "--kinds", "1", "--until", "1677426236"},
+		{delegateePubkey, "--kinds", "70000", "--until", "720h"},
+		{delegateePubkey, "--kinds", "1", "--until", "720h", "--since", "1440h"},
+		{"npub1invalid", "--kinds", "1", "--until", "720h"},
+	} {
+		var err error
+		captureStdout(t, func() { err = delegateCmd(args) })
+		if exitCode(err) != 1 {
+			t.Errorf("delegate %v = %v, want exit code 1", args, err)
+		}
+	}
+}
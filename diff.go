@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// diffOp is one line of an edit script: ' ' (keep), '-' (delete) or '+' (insert)
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns a unified diff between two texts ("" if identical).
+// Inputs are small config files, so a plain LCS table is fine.
+func unifiedDiff(fromName, toName, from, to string) string {
+	a := splitDiffLines(from)
+	b := splitDiffLines(to)
+
+	// lcs[i][j] = length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		default:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		}
+	}
+
+	var out strings.Builder
+	oldLine, newLine := 1, 1
+	for start := 0; start < len(ops); {
+		// Find the next change
+		change := start
+		for change < len(ops) && ops[change].kind == ' ' {
+			change++
+		}
+		if change == len(ops) {
+			break
+		}
+
+		// Extend the hunk while changes are within 2*context lines of each other
+		hunkStart := change - diffContext
+		if hunkStart < start {
+			hunkStart = start
+		}
+		hunkEnd := change
+		for k := change; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				hunkEnd = k + 1
+			} else if k-hunkEnd >= 2*diffContext {
+				break
+			}
+		}
+		hunkEnd += diffContext
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+
+		// Advance line counters over the skipped unchanged lines
+		for k := start; k < hunkStart; k++ {
+			oldLine++
+			newLine++
+		}
+
+		oldCount, newCount := 0, 0
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", diffRange(oldLine, oldCount), diffRange(newLine, newCount))
+		for _, op := range ops[hunkStart:hunkEnd] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.line)
+		}
+
+		oldLine += oldCount
+		newLine += newCount
+		start = hunkEnd
+	}
+
+	return out.String()
+}
+
+// diffRange formats a hunk range ("start,count"; start is 0 for empty ranges)
+func diffRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitDiffLines splits text into lines without a trailing empty element
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// npubDisplayPrefix and npubDisplaySuffix define the standard short form
+// of an npub: "npub1abcde…uvwxyz"
+const (
+	npubDisplayPrefix = 10
+	npubDisplaySuffix = 6
+)
+
+// displayNpub shortens an npub for human-readable output. Machine output
+// (JSON responses, stream events, hook environment) and confirmation prompts
+// for destructive actions always use the full npub instead.
+func displayNpub(npub string) string {
+	if len(npub) <= npubDisplayPrefix+npubDisplaySuffix+1 {
+		return npub
+	}
+	return npub[:npubDisplayPrefix] + "…" + npub[len(npub)-npubDisplaySuffix:]
+}
+
+// npubKeyID returns the key ID of an npub: the first 4 bytes of the
+// SHA-256 of its public key, in hex. Two keys that shorten to the same
+// npub still differ in their key ID. "" if npub is invalid.
+func npubKeyID(npub string) string {
+	pubkey, err := npubToPubkey(npub)
+	if err != nil {
+		return ""
+	}
+	key, err := hex.DecodeString(pubkey)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// labeledNpub shortens npub for display, after its label if it has one,
+// else followed by its key ID, so a shortened npub is never all there is
+// to tell two accounts apart
+func labeledNpub(npub, label string) string {
+	if label != "" {
+		return fmt.Sprintf("%s (%s)", label, displayNpub(npub))
+	}
+	if keyID := npubKeyID(npub); keyID != "" {
+		return fmt.Sprintf("%s (key ID %s)", displayNpub(npub), keyID)
+	}
+	return displayNpub(npub)
+}
+
+// displayAccount is labeledNpub for a stored account, with its own label
+func displayAccount(npub string) string {
+	return labeledNpub(npub, accountLabel(npub))
+}
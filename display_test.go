@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite testdata/*.golden with the current output")
+
+// Fixed keys, so the golden files hold the same npubs on every run
+const (
+	goldenKeyPersonal = "0000000000000000000000000000000000000000000000000000000000000001"
+	goldenKeyWork     = "0000000000000000000000000000000000000000000000000000000000000002"
+)
+
+// checkGolden compares got with testdata/<name>.golden
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *updateGolden {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s:\n--- got\n%s--- want\n%s", path, got, want)
+	}
+}
+
+// captureStdout returns what f prints on stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(reader)
+		output <- string(data)
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	f()
+	writer.Close()
+	return <-output
+}
+
+// addGoldenAccounts stores the two fixed accounts, the first labeled
+// "personal" and active
+func addGoldenAccounts(t *testing.T) (string, string) {
+	t.Helper()
+	var npubs []string
+	for _, key := range []string{goldenKeyWork, goldenKeyPersonal} {
+		prompt := &scriptedPrompter{answers: []string{key, testPassword, testPassword}}
+		captureStdout(t, func() {
+			if err := addAccount(prompt, ""); err != nil {
+				t.Fatalf("add-account: %v", err)
+			}
+		})
+		npub, err := loadActiveAccount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		npubs = append(npubs, npub)
+	}
+	personal, work := npubs[1], npubs[0]
+	if err := setAccountLabel(personal, "personal"); err != nil {
+		t.Fatal(err)
+	}
+	return personal, work
+}
+
+func TestNpubKeyID(t *testing.T) {
+	_, npub := testKey(t)
+	_, other := testKey(t)
+	keyID := npubKeyID(npub)
+	if len(keyID) != 8 {
+		t.Fatalf("npubKeyID = %q, want 8 hex digits", keyID)
+	}
+	if npubKeyID(npub) != keyID {
+		t.Error("npubKeyID is not stable")
+	}
+	if npubKeyID(other) == keyID {
+		t.Error("two keys got the same key ID")
+	}
+	if got := npubKeyID("npub1invalid"); got != "" {
+		t.Errorf("npubKeyID of an invalid npub = %q, want \"\"", got)
+	}
+}
+
+func TestLabeledNpub(t *testing.T) {
+	_, npub := testKey(t)
+	if got, want := labeledNpub(npub, "work"), "work ("+displayNpub(npub)+")"; got != want {
+		t.Errorf("labeledNpub with a label = %q, want %q", got, want)
+	}
+	if got, want := labeledNpub(npub, ""), displayNpub(npub)+" (key ID "+npubKeyID(npub)+")"; got != want {
+		t.Errorf("labeledNpub without a label = %q, want %q", got, want)
+	}
+}
+
+func TestListAccountsGolden(t *testing.T) {
+	testHome(t)
+	addGoldenAccounts(t)
+	output := captureStdout(t, func() {
+		if err := listAccountsCmd(false); err != nil {
+			t.Errorf("list-accounts: %v", err)
+		}
+	})
+	checkGolden(t, "list_accounts", output)
+}
+
+func TestWhoamiGolden(t *testing.T) {
+	testHome(t)
+	personal, work := addGoldenAccounts(t)
+	for name, npub := range map[string]string{"whoami_labeled": personal, "whoami_unlabeled": work} {
+		t.Run(name, func(t *testing.T) {
+			if err := saveActiveAccount(npub); err != nil {
+				t.Fatal(err)
+			}
+			output := captureStdout(t, func() {
+				if err := whoamiCmd(nil); err != nil {
+					t.Errorf("whoami: %v", err)
+				}
+			})
+			checkGolden(t, name, output)
+		})
+	}
+}
+
+// answeringWriter answers y once the prompt asks its question
+type answeringWriter struct {
+	bytes.Buffer
+	lines chan string
+}
+
+func (w *answeringWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("[y/N/a=always]")) {
+		go func() { w.lines <- "y" }()
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestAskPromptGolden(t *testing.T) {
+	testHome(t)
+	personal, work := addGoldenAccounts(t)
+	kind := 1
+	for name, npub := range map[string]string{"ask_prompt_labeled": personal, "ask_prompt_unlabeled": work} {
+		t.Run(name, func(t *testing.T) {
+			out := &answeringWriter{lines: make(chan string)}
+			asker := &terminalAsker{lines: out.lines, out: out, timeout: time.Minute}
+			answer := asker.prompt(&askPrompt{
+				approval: ApprovalContext{
+					Method:         "sign_event",
+					Client:         ClientIdentity{App: "notes", AppVersion: "1.2"},
+					Npub:           npub,
+					Kind:           &kind,
+					Tags:           2,
+					ContentPreview: "hello",
+				},
+				deadline: time.Now().Add(time.Minute),
+			})
+			if !answer.approved {
+				t.Errorf("answer = %+v, want approved", answer)
+			}
+			checkGolden(t, name, out.String())
+		})
+	}
+}
+
+func TestGoldenFilesShowKeyIDs(t *testing.T) {
+	// The unlabeled account must never be shown as a bare short npub
+	for _, name := range []string{"list_accounts", "whoami_unlabeled", "ask_prompt_unlabeled"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name+".golden"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "Key ID") && !strings.Contains(string(data), "key ID") {
+			t.Errorf("%s.golden shows no key ID", name)
+		}
+	}
+}
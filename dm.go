@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/77elements/noorsigner/pkg/client"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// NIP-17 event kinds
+	dmKind       = 14
+	dmRelaysKind = 10050
+
+	codeInvalidRecipient = "ERR_INVALID_RECIPIENT"
+	codeMessageTooLarge  = "ERR_MESSAGE_TOO_LARGE"
+)
+
+// DMResponse answers send_dm and decrypt_dm
+type DMResponse = client.DMResponse
+
+// dmRumor returns the unsigned kind 14 event carrying text to recipient
+func dmRumor(text, recipient string, createdAt int64) (string, error) {
+	event, err := json.Marshal(map[string]interface{}{
+		"kind":       dmKind,
+		"created_at": createdAt,
+		"tags":       [][]string{{"p", recipient}},
+		"content":    text,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(event), nil
+}
+
+// sendDM gift wraps text as a direct message to recipient (hex): one wrap
+// for the recipient, then one for the account so its other clients see
+// what it sent. A message to itself gets one wrap.
+func sendDM(key *accountKey, text, recipient string, limits eventLimits) ([]*NostrEvent, *Rumor, error) {
+	eventJSON, err := dmRumor(text, recipient, time.Now().Unix())
+	if err != nil {
+		return nil, nil, err
+	}
+	rumor, err := newRumor(eventJSON, limits, key.pubkey)
+	if err != nil {
+		return nil, nil, dmSizeError(err)
+	}
+
+	recipients := []string{recipient}
+	if recipient != key.pubkey {
+		recipients = append(recipients, key.pubkey)
+	}
+	var wraps []*NostrEvent
+	for _, pubkey := range recipients {
+		wrap, err := giftWrap(key, rumor, pubkey, limits)
+		if err != nil {
+			return nil, nil, dmSizeError(err)
+		}
+		wraps = append(wraps, wrap)
+	}
+	return wraps, rumor, nil
+}
+
+// dmSizeError reports a message too long for a gift wrap as such; other
+// errors pass through
+func dmSizeError(err error) error {
+	var eventErr *eventError
+	if errors.As(err, &eventErr) && eventErr.Code == codeEventTooLarge {
+		return &eventError{Code: codeMessageTooLarge, Message: "message is too long for a direct message: " + eventErr.Message}
+	}
+	return err
+}
+
+// openDM unwraps a direct message addressed to the account
+func openDM(key *accountKey, wrapJSON string) (*Rumor, string, error) {
+	rumor, sender, err := unwrapGift(key, wrapJSON)
+	if err != nil {
+		return nil, "", err
+	}
+	if rumor.Kind != dmKind {
+		return nil, "", giftWrapError("gift wrap holds %s, not a direct message; use unwrap", describeKind(rumor.Kind))
+	}
+	return rumor, sender, nil
+}
+
+// sendDMRequest handles send_dm: plaintext is gift wrapped for
+// recipient_pubkey and for the account
+func (d *Daemon) sendDMRequest(req SignRequest) DMResponse {
+	if req.Plaintext == "" || req.RecipientPubkey == "" {
+		return DMResponse{ID: req.ID, Error: "plaintext and recipient_pubkey required", Code: codeInvalidRequest}
+	}
+	recipient, err := parsePubkey(req.RecipientPubkey)
+	if err != nil {
+		return DMResponse{ID: req.ID, Error: "invalid recipient_pubkey: " + err.Error(), Code: codeInvalidRecipient}
+	}
+
+	var wraps []*NostrEvent
+	var rumor *Rumor
+	err = d.withAccountKey(req, func(key *accountKey) (err error) {
+		wraps, rumor, err = sendDM(key, req.Plaintext, recipient, d.config.eventLimits())
+		return err
+	})
+	if err != nil {
+		return DMResponse{ID: req.ID, Error: redactedError(err), Code: errorCode(err)}
+	}
+	d.countUse(req, AccountCounters{EventsSigned: uint64(len(wraps)), Nip44Encrypts: uint64(len(wraps))})
+	return DMResponse{ID: req.ID, Wraps: wraps, EventID: wraps[0].ID, MessageID: rumor.ID}
+}
+
+// decryptDMRequest handles decrypt_dm: the gift wrap in event_json is
+// opened and its direct message returned
+func (d *Daemon) decryptDMRequest(req SignRequest) DMResponse {
+	if req.EventJSON == "" {
+		return DMResponse{ID: req.ID, Error: "event_json required", Code: codeInvalidRequest}
+	}
+
+	var rumor *Rumor
+	var sender string
+	err := d.withAccountKey(req, func(key *accountKey) (err error) {
+		rumor, sender, err = openDM(key, req.EventJSON)
+		return err
+	})
+	if err != nil {
+		return DMResponse{ID: req.ID, Error: redactedError(err), Code: errorCode(err)}
+	}
+	d.countUse(req, AccountCounters{Nip44Decrypts: 2})
+	return DMResponse{ID: req.ID, MessageID: rumor.ID, Sender: sender, CreatedAt: rumor.CreatedAt, Plaintext: rumor.Content}
+}
+
+// dmRelays returns the relays in a kind 10050 event, normalized
+func dmRelays(event *nostr.Event) []string {
+	var relays []string
+	seen := make(map[string]bool)
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[0] != "relay" {
+			continue
+		}
+		relay, err := normalizeRelayURL(tag[1])
+		if err == nil && !seen[relay] {
+			seen[relay] = true
+			relays = append(relays, relay)
+		}
+	}
+	sort.Strings(relays)
+	return relays
+}
+
+// dmSendCmd sends a direct message from the active account: it looks up
+// the DM relays (kind 10050) of the recipient and the account, wraps the
+// message for both with the password and publishes each wrap there
+func dmSendCmd(args []string) error {
+	usage := "noorsigner dm send <npub|hex> <message> " + passwordFlagsUsage
+	args, err := passwordArgs(args, usage)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 || args[1] == "" {
+		return commandFailed(1, "Usage: %s", usage)
+	}
+	recipient, err := parsePubkey(args[0])
+	if err != nil {
+		return commandFailed(1, "❌ Invalid recipient: %v", err)
+	}
+	text := args[1]
+	if len(text) > maxNip44Plaintext {
+		return commandFailed(1, "❌ Message is %d bytes; a direct message holds less than %d", len(text), maxNip44Plaintext)
+	}
+
+	activeNpub, activePubkey, err := loadActiveSigner()
+	if err != nil {
+		return err
+	}
+	recipientNpub, _ := pubkeyToNpub(recipient)
+
+	// Refuse before asking for the password
+	ownRelays, err := loadAccountRelays(activeNpub)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	lookup := relayListPublishRelays(ownRelays)
+	fmt.Println("Looking up DM relays...")
+	found, failures := fetchNewestEvents(lookup, dmRelaysKind, []string{recipient, activePubkey})
+	if len(failures) == len(lookup) {
+		for _, failure := range failures {
+			fmt.Printf("⚠️  %v\n", failure)
+		}
+		return commandFailed(1, "❌ No relay answered")
+	}
+	var recipientRelays []string
+	if event := found[recipient]; event != nil {
+		recipientRelays = dmRelays(event)
+	}
+	if len(recipientRelays) == 0 {
+		return commandFailed(1, "❌ %s has no DM relays (kind 10050) - they can't receive NIP-17 messages", displayNpub(recipientNpub))
+	}
+	var selfRelays []string
+	if recipient != activePubkey {
+		if event := found[activePubkey]; event != nil {
+			selfRelays = dmRelays(event)
+		}
+		if len(selfRelays) == 0 {
+			for relay, policy := range ownRelays {
+				if policy.Write {
+					selfRelays = append(selfRelays, relay)
+				}
+			}
+			sort.Strings(selfRelays)
+		}
+	}
+
+	if err := confirmPolicyCLI(dmKind, "send_dm", activeNpub); err != nil {
+		return err
+	}
+	privateKey, err := unlockActiveAccount(activeNpub)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sending as: %s\n", displayNpub(activeNpub))
+	fmt.Printf("To: %s\n", displayNpub(recipientNpub))
+
+	key := &accountKey{privateKey: privateKey, pubkey: activePubkey}
+	wraps, _, err := sendDM(key, text, recipient, appConfig.eventLimits())
+	kind := dmKind
+	auditCLI("send_dm", activeNpub, err, &kind)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	if !publishWrap("message", wraps[0], recipientRelays) {
+		return commandFailed(1, "❌ No relay took the message")
+	}
+	if len(wraps) < 2 {
+		return nil
+	}
+	if len(selfRelays) == 0 {
+		fmt.Println("⚠️  No DM or write relays of your own: your other clients won't see this message (see noorsigner relays)")
+		return nil
+	}
+	if !publishWrap("your copy", wraps[1], selfRelays) {
+		fmt.Println("⚠️  No relay took your copy: your other clients won't see this message")
+	}
+	return nil
+}
+
+// publishWrap publishes a gift wrap, reports each relay and returns
+// whether any took it. what names the wrap in the report.
+func publishWrap(what string, wrap *NostrEvent, relays []string) bool {
+	failures := publishEvent(wrap, relays)
+	for _, relay := range relays {
+		if err := failures[relay]; err != nil {
+			fmt.Printf("⚠️  %s: %v\n", relay, err)
+		} else {
+			fmt.Printf("✅ Sent %s to %s\n", what, relay)
+		}
+	}
+	return len(failures) < len(relays)
+}
+
+// dmCmd dispatches the dm subcommands
+func dmCmd(args []string) error {
+	if len(args) == 0 || args[0] != "send" {
+		return commandFailed(1, "Usage: noorsigner dm send <npub|hex> <message>")
+	}
+	return dmSendCmd(args[1:])
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// sendTestDM sends text to recipient over conn and returns the response
+func sendTestDM(t *testing.T, conn *testConn, recipient, text string) DMResponse {
+	t.Helper()
+	var response DMResponse
+	if err := conn.request(t, SignRequest{Method: "send_dm", RecipientPubkey: recipient, Plaintext: text}, &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// decryptTestDM opens wrap as the account npub ("" for the active one)
+func decryptTestDM(t *testing.T, conn *testConn, wrap *NostrEvent, npub string) DMResponse {
+	t.Helper()
+	wrapJSON, err := json.Marshal(wrap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response DMResponse
+	if err := conn.request(t, SignRequest{Method: "decrypt_dm", EventJSON: string(wrapJSON), Npub: npub}, &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// A message sent with send_dm opens with decrypt_dm for the recipient and
+// from the sender's own copy, and for no one else
+func TestDMRoundTrip(t *testing.T) {
+	testHome(t)
+	recipient := addTestAccount(t, "")
+	sender := addTestAccount(t, "")
+	d := testDaemon(t, sender)
+	if err := d.unlockAccount(recipient, testPassword, false); err != nil {
+		t.Fatal(err)
+	}
+	serveTestDaemon(t, d)
+	conn := dialTestDaemon(t)
+	recipientPubkey, _ := npubToPubkey(recipient)
+	const text = "Meet at 8 <by the \"old\" gate> & bring 🍕"
+
+	sent := sendTestDM(t, conn, recipient, text)
+	if sent.Error != "" || len(sent.Wraps) != 2 {
+		t.Fatalf("send_dm: %+v, want two wraps", sent)
+	}
+	if sent.EventID != sent.Wraps[0].ID || sent.MessageID == "" {
+		t.Errorf("send_dm ids: event %s, message %s", sent.EventID, sent.MessageID)
+	}
+	for i, addressee := range []string{recipientPubkey, d.pubkey} {
+		wrap := sent.Wraps[i]
+		var event nostr.Event
+		data, _ := json.Marshal(wrap)
+		if err := json.Unmarshal(data, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Kind != 1059 || event.Tags.GetFirst([]string{"p", addressee}) == nil {
+			t.Errorf("wrap %d: kind %d, tags %v, want kind 1059 for %s", i, event.Kind, event.Tags, addressee)
+		}
+		if event.PubKey == d.pubkey || strings.Contains(event.Content, "Meet") {
+			t.Errorf("wrap %d reveals the sender or the message", i)
+		}
+		if ok, err := event.CheckSignature(); !ok || event.GetID() != event.ID {
+			t.Errorf("wrap %d: invalid id or signature: %v", i, err)
+		}
+	}
+
+	for _, open := range []struct {
+		name string
+		wrap *NostrEvent
+		as   string
+	}{
+		{"recipient", sent.Wraps[0], recipient},
+		{"own copy", sent.Wraps[1], ""},
+	} {
+		opened := decryptTestDM(t, conn, open.wrap, open.as)
+		if opened.Error != "" {
+			t.Errorf("decrypt_dm of the %s wrap: %s", open.name, opened.Error)
+			continue
+		}
+		if opened.Plaintext != text || opened.Sender != d.pubkey || opened.MessageID != sent.MessageID || opened.CreatedAt == 0 {
+			t.Errorf("decrypt_dm of the %s wrap: %+v", open.name, opened)
+		}
+	}
+
+	// Each wrap is only addressed to one account
+	if opened := decryptTestDM(t, conn, sent.Wraps[0], ""); opened.Code != codeNotRecipient || opened.Plaintext != "" {
+		t.Errorf("decrypt_dm of the recipient's wrap as the sender: %+v, want %s", opened, codeNotRecipient)
+	}
+	if opened := decryptTestDM(t, conn, sent.Wraps[1], recipient); opened.Code != codeNotRecipient {
+		t.Errorf("decrypt_dm of the sender's copy as the recipient: %+v, want %s", opened, codeNotRecipient)
+	}
+
+	// A tampered wrap doesn't open
+	tampered := *sent.Wraps[0]
+	tampered.Content = strings.Repeat("A", len(tampered.Content))
+	if opened := decryptTestDM(t, conn, &tampered, recipient); opened.Error == "" {
+		t.Errorf("decrypt_dm of a tampered wrap: %+v", opened)
+	}
+}
+
+func TestSendDMErrors(t *testing.T) {
+	testHome(t)
+	sender := addTestAccount(t, "")
+	d := testDaemon(t, sender)
+	serveTestDaemon(t, d)
+	conn := dialTestDaemon(t)
+	_, recipient := testKey(t)
+	recipientPubkey, _ := npubToPubkey(recipient)
+
+	// npub and hex name the same recipient
+	for _, form := range []string{recipient, recipientPubkey} {
+		if sent := sendTestDM(t, conn, form, "hi"); sent.Error != "" || len(sent.Wraps) != 2 {
+			t.Errorf("send_dm to %s: %+v", form, sent)
+		}
+	}
+	// A message to oneself needs no second copy
+	if sent := sendTestDM(t, conn, sender, "note to self"); sent.Error != "" || len(sent.Wraps) != 1 {
+		t.Errorf("send_dm to the account itself: %+v, want one wrap", sent)
+	}
+
+	tests := []struct {
+		name      string
+		recipient string
+		text      string
+		code      string
+	}{
+		{"invalid recipient", "npub1notakey", "hi", codeInvalidRecipient},
+		{"short hex recipient", recipientPubkey[:62], "hi", codeInvalidRecipient},
+		{"too long", recipient, strings.Repeat("x", maxNip44Plaintext+1), codeMessageTooLarge},
+		{"no text", recipient, "", codeInvalidRequest},
+	}
+	for _, tt := range tests {
+		if sent := sendTestDM(t, conn, tt.recipient, tt.text); sent.Code != tt.code || len(sent.Wraps) != 0 {
+			t.Errorf("%s: %+v, want %s", tt.name, sent, tt.code)
+		}
+	}
+}
+
+func TestDMCmdUsage(t *testing.T) {
+	testHome(t)
+	addTestAccount(t, "")
+	for _, args := range [][]string{
+		nil,
+		{"receive"},
+		{"send", "npub1notakey", "hi"},
+		{"send", testEventPubkey},
+		{"send", testEventPubkey, strings.Repeat("x", maxNip44Plaintext+1)},
+	} {
+		if err := dmCmd(args); exitCode(err) != 1 {
+			t.Errorf("dm %v = %v, want exit code 1", args, err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// accountDirIssue is an account directory whose name is not the canonical
+// lowercase npub
+type accountDirIssue struct {
+	Name      string // Directory name on disk
+	Npub      string // Canonical npub it belongs to
+	Duplicate bool   // The canonical directory exists as well
+	Fixed     bool   // Renamed to the canonical name
+	Err       error  // Rename failed
+}
+
+// String describes the issue for logs and doctor output
+func (i accountDirIssue) String() string {
+	switch {
+	case i.Duplicate:
+		return fmt.Sprintf("accounts/%s duplicates accounts/%s - move its files away by hand, only accounts/%s is used", i.Name, i.Npub, i.Npub)
+	case i.Fixed:
+		return fmt.Sprintf("accounts/%s renamed to accounts/%s", i.Name, i.Npub)
+	case i.Err != nil:
+		return fmt.Sprintf("accounts/%s could not be renamed to accounts/%s: %v", i.Name, i.Npub, i.Err)
+	default:
+		return fmt.Sprintf("accounts/%s should be named accounts/%s", i.Name, i.Npub)
+	}
+}
+
+// checkAccountDirCase finds account directories named in a case other than
+// the lowercase npub. With fix set, a lone variant is renamed to the
+// canonical name. A variant next to the canonical directory (possible on
+// case-sensitive filesystems only) may hold a different key file, so it is
+// only reported.
+func checkAccountDirCase(fix bool) ([]accountDirIssue, error) {
+	accountsDir, err := getAccountsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	// Names as stored on disk - on case-insensitive filesystems os.Stat
+	// would find the variant under the canonical name too
+	entries, err := os.ReadDir(accountsDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read accounts directory: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+
+	var issues []accountDirIssue
+	for _, entry := range entries {
+		npub := canonicalNpub(entry.Name())
+		if !entry.IsDir() || !strings.HasPrefix(npub, "npub1") || entry.Name() == npub {
+			continue
+		}
+
+		issue := accountDirIssue{Name: entry.Name(), Npub: npub, Duplicate: names[npub]}
+		if fix && !issue.Duplicate {
+			issue.Err = renameAccountDir(accountsDir, entry.Name(), npub)
+			if issue.Err == nil {
+				issue.Fixed = true
+				names[npub] = true // Any further variant is now a duplicate
+			}
+		}
+		issues = append(issues, issue)
+	}
+
+	return issues, nil
+}
+
+// renameAccountDir renames an account directory via a temporary name, since
+// case-insensitive filesystems may ignore a rename that only changes case
+func renameAccountDir(accountsDir, from, to string) error {
+	tmp := filepath.Join(accountsDir, ".rename-"+to)
+	if err := os.Rename(filepath.Join(accountsDir, from), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(accountsDir, to)); err != nil {
+		os.Rename(tmp, filepath.Join(accountsDir, from))
+		return err
+	}
+	return nil
+}
+
+// checkActiveAccountCase rewrites active_account in lowercase if needed
+func checkActiveAccountCase(fix bool) (string, error) {
+	filePath, err := getActiveAccountFilePath()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", nil // No active account - nothing to check
+	}
+	stored := strings.TrimSpace(string(content))
+	if stored == canonicalNpub(stored) {
+		return "", nil
+	}
+
+	if !fix {
+		return "active_account is not lowercase", nil
+	}
+	if err := saveActiveAccount(stored); err != nil {
+		return "", err
+	}
+	return "active_account rewritten in lowercase", nil
+}
+
+// fixAccountCase is run at daemon startup: safe renames are applied,
+// everything else is logged
+func fixAccountCase() {
+	issues, err := checkAccountDirCase(true)
+	if err != nil {
+		logError("⚠️  Account directory check failed: %v", err)
+		return
+	}
+	for _, issue := range issues {
+		if issue.Fixed {
+			logInfo("🔧 %s", issue)
+		} else {
+			logError("⚠️  %s", issue)
+		}
+	}
+
+	if message, err := checkActiveAccountCase(true); err != nil {
+		logError("⚠️  Cannot rewrite active_account: %v", err)
+	} else if message != "" {
+		logInfo("🔧 %s", message)
+	}
+}
+
+// doctorCmd checks the storage directory for problems and, with --fix,
+// repairs what can be repaired safely
+func doctorCmd(args []string) error {
+	fix := len(args) > 0 && args[0] == "--fix"
+	if len(args) > 1 || (len(args) == 1 && !fix) {
+		return commandFailed(1, "Usage: noorsigner doctor [--fix]")
+	}
+
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return commandFailed(1, "Error: %v", err)
+	}
+	fmt.Printf("🩺 Checking %s\n", storageDir)
+
+	problems := 0
+
+	issues, err := checkAccountDirCase(fix)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	for _, issue := range issues {
+		if issue.Fixed {
+			fmt.Printf("🔧 %s\n", issue)
+		} else {
+			fmt.Printf("⚠️  %s\n", issue)
+			problems++
+		}
+	}
+
+	message, err := checkActiveAccountCase(fix)
+	if err != nil {
+		fmt.Printf("❌ Cannot rewrite active_account: %v\n", err)
+		problems++
+	} else if message != "" {
+		if fix {
+			fmt.Printf("🔧 %s\n", message)
+		} else {
+			fmt.Printf("⚠️  %s\n", message)
+			problems++
+		}
+	}
+
+	problems += checkSQLiteStoreFile(fix)
+
+	entropy := entropyProbe()
+	switch {
+	case !entropy.Ready:
+		fmt.Printf("⚠️  entropy: %s - adding or re-encrypting keys is refused until it is\n", entropy.Detail)
+		problems++
+	case entropy.Checked:
+		fmt.Printf("✅ entropy: %s\n", entropy.Detail)
+	default:
+		fmt.Printf("ℹ️  entropy: %s\n", entropy.Detail)
+	}
+
+	socketProblems, err := checkListenerSecurity(false)
+	if err != nil {
+		fmt.Printf("❌ Cannot inspect the socket: %v\n", err)
+		problems++
+	}
+	for _, problem := range socketProblems {
+		fmt.Printf("⚠️  socket: %s\n", problem)
+	}
+	if len(socketProblems) > 0 && fix {
+		socketProblems, err = checkListenerSecurity(true)
+		if err != nil {
+			fmt.Printf("❌ Cannot fix the socket: %v\n", err)
+			problems++
+		} else if len(socketProblems) == 0 {
+			fmt.Println("🔧 socket permissions reset to 0600")
+		}
+	}
+	problems += len(socketProblems)
+
+	if problems == 0 {
+		fmt.Println("✅ No problems found")
+		return nil
+	}
+	if !fix {
+		fmt.Println()
+		fmt.Println("Run 'noorsigner doctor --fix' to repair what can be repaired safely.")
+	}
+	return &commandError{Code: 1}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// defaultDrainTimeout is how long drain waits for in-flight requests
+	defaultDrainTimeout = 30 * time.Second
+
+	// maxDrainTimeout bounds the drain timeout a client may ask for
+	maxDrainTimeout = time.Hour
+
+	// drainPollInterval is how often drain checks the in-flight count
+	drainPollInterval = 50 * time.Millisecond
+)
+
+// drainExempt are the methods still served while draining. They don't use
+// the key and are not counted as in-flight, so status polling and a second
+// drain or shutdown never hold the daemon up.
+var drainExempt = map[string]bool{
+	"get_status":           true,
+	"ping":                 true,
+	"stats":                true,
+	"get_version":          true,
+	"get_capabilities":     true,
+	"get_protocol_version": true,
+	"drain":                true,
+	"shutdown_daemon":      true,
+	"lock":                 true,
+}
+
+// DrainResponse represents drain response
+type DrainResponse struct {
+	ID       string `json:"id"`
+	Draining bool   `json:"draining"`
+	InFlight int64  `json:"in_flight"`
+	// Deadline is when the daemon shuts down even if requests are still running
+	Deadline int64  `json:"deadline"`
+	Error    string `json:"error,omitempty"`
+}
+
+// DrainingErrorResponse is returned instead of the method's own response
+// for requests rejected while draining
+type DrainingErrorResponse struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+	Code  string `json:"code"`
+	// RetryAfter is the number of seconds after which a (restarted) daemon
+	// can be expected to accept requests again
+	RetryAfter int `json:"retry_after"`
+}
+
+// parseDrainTimeout parses the drain timeout (empty = 30s)
+func parseDrainTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultDrainTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid drain timeout %q: %v", value, err)
+	}
+	if timeout < time.Second || timeout > maxDrainTimeout {
+		return 0, fmt.Errorf("drain timeout must be between 1s and %s", maxDrainTimeout)
+	}
+	return timeout, nil
+}
+
+// beginRequest counts a request as in-flight. It returns false (and counts
+// nothing) if the daemon is draining and the method is not exempt.
+func (d *Daemon) beginRequest(method string) bool {
+	if drainExempt[method] {
+		return true
+	}
+
+	// Count first, then check: drain sets the flag before it starts
+	// watching the counter, so no accepted request can slip past it
+	d.inFlight.Add(1)
+	if d.draining.Load() {
+		d.inFlight.Add(-1)
+		return false
+	}
+	return true
+}
+
+// endRequest marks a request started with beginRequest as done
+func (d *Daemon) endRequest(method string) {
+	if !drainExempt[method] {
+		d.inFlight.Add(-1)
+	}
+}
+
+// drainingResponse rejects a request that arrived while draining
+func (d *Daemon) drainingResponse(id string) DrainingErrorResponse {
+	d.drainMu.Lock()
+	deadline := d.drainDeadline
+	d.drainMu.Unlock()
+
+	retryAfter := int(time.Until(deadline).Seconds()) + 1
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	return DrainingErrorResponse{
+		ID:         id,
+		Error:      "daemon is draining for maintenance - retry later",
+		Code:       codeDraining,
+		RetryAfter: retryAfter,
+	}
+}
+
+// startDrain stops taking new requests and shuts down once the in-flight
+// ones have finished or timeout has passed. Draining again does not move
+// the deadline.
+func (d *Daemon) startDrain(timeout time.Duration) DrainResponse {
+	d.drainMu.Lock()
+	defer d.drainMu.Unlock()
+
+	if !d.draining.Load() {
+		d.drainDeadline = time.Now().Add(timeout)
+		d.draining.Store(true)
+
+		deadline := d.drainDeadline
+		logInfo("🚧 Draining: no new requests, shutting down within %s", timeout)
+		d.emit(StreamEvent{
+			Type: "daemon_draining",
+			Data: map[string]string{"deadline": fmt.Sprintf("%d", deadline.Unix())},
+		})
+		go d.drain(deadline)
+	}
+
+	return DrainResponse{
+		Draining: true,
+		InFlight: d.inFlight.Load(),
+		Deadline: d.drainDeadline.Unix(),
+	}
+}
+
+// drain waits for in-flight requests, then performs the normal shutdown
+func (d *Daemon) drain(deadline time.Time) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for d.inFlight.Load() > 0 && time.Now().Before(deadline) {
+		<-ticker.C
+	}
+
+	if remaining := d.inFlight.Load(); remaining > 0 {
+		logError("⚠️  Drain timeout - shutting down with %d request(s) still in flight", remaining)
+	} else {
+		logInfo("✅ Drained - no requests in flight")
+	}
+
+	d.shutdownDaemon(LockEvent{Reason: lockReasonShutdown, Trigger: "drain"})
+	os.Exit(0)
+}
+
+// drainCmd asks the daemon to drain and waits until it has stopped
+func drainCmd(args []string) error {
+	timeoutValue := ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--timeout" && i+1 < len(args):
+			i++
+			timeoutValue = args[i]
+		default:
+			return commandFailed(1, "Usage: noorsigner drain [--timeout 30s]")
+		}
+	}
+
+	timeout, err := parseDrainTimeout(timeoutValue)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	response, err := drainViaDaemon(timeoutValue)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	fmt.Printf("🚧 Daemon is draining - new requests are rejected (timeout %s)\n", timeout)
+
+	// The daemon exits on its own; follow it via get_status until it is gone
+	lastInFlight := int64(-1)
+	giveUp := time.Unix(response.Deadline, 0).Add(10 * time.Second)
+	for time.Now().Before(giveUp) {
+		status, err := getStatusViaDaemon()
+		if err != nil {
+			fmt.Println("✅ Daemon stopped")
+			return nil
+		}
+		if status.InFlight != lastInFlight {
+			fmt.Printf("   %d request(s) in flight\n", status.InFlight)
+			lastInFlight = status.InFlight
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	return commandFailed(1, "❌ Daemon did not stop after the drain timeout")
+}
+
+// DaemonDrainingError is returned by the client helpers when the daemon
+// rejected a request with ERR_DRAINING. The request was not executed and can
+// be retried after RetryAfter, e.g. against the upgraded daemon.
+type DaemonDrainingError struct {
+	RetryAfter time.Duration
+}
+
+// errDaemonDraining matches any *DaemonDrainingError with errors.Is
+var errDaemonDraining = errors.New("daemon is draining")
+
+func (e *DaemonDrainingError) Error() string {
+	return fmt.Sprintf("daemon is draining for maintenance - retry in %s", e.RetryAfter)
+}
+
+func (e *DaemonDrainingError) Is(target error) bool {
+	return target == errDaemonDraining
+}
+
+// Temporary marks the error as retryable
+func (e *DaemonDrainingError) Temporary() bool {
+	return true
+}
@@ -0,0 +1,28 @@
+package main
+
+import "fmt"
+
+// entropyStatus is what the platform says about its random number generator
+type entropyStatus struct {
+	// Checked is false where the platform offers no readiness check
+	Checked bool
+	// Ready is false only when the platform reports an unseeded generator
+	Ready  bool
+	Detail string
+}
+
+// entropyProbe asks the platform whether its generator is seeded
+// (see entropy_linux.go, entropy_other.go)
+var entropyProbe = platformEntropyProbe
+
+// requireEntropy refuses to create key material while the platform reports
+// its random pool as not initialized, e.g. early in boot or in a freshly
+// cloned VM. crypto/rand stays the only source of randomness; this only
+// turns a silent wait or a weak seed into a clear error.
+func requireEntropy() error {
+	status := entropyProbe()
+	if !status.Ready {
+		return fmt.Errorf("the system random pool is not initialized yet (%s) - wait for it or add an entropy source (e.g. virtio-rng, haveged) and try again", status.Detail)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeEntropy makes entropyProbe report status until the test ends
+func fakeEntropy(t *testing.T, status entropyStatus) {
+	t.Helper()
+	probe := entropyProbe
+	entropyProbe = func() entropyStatus { return status }
+	t.Cleanup(func() { entropyProbe = probe })
+}
+
+var (
+	entropyReady    = entropyStatus{Checked: true, Ready: true, Detail: "kernel random pool initialized"}
+	entropyNotReady = entropyStatus{Checked: true, Ready: false, Detail: "getrandom reports the kernel random pool is not initialized"}
+	// The probe itself failed: readiness is unknown, which doesn't block
+	entropyUnknown = entropyStatus{Ready: true, Detail: "getrandom check failed: operation not permitted"}
+)
+
+func TestRequireEntropy(t *testing.T) {
+	tests := []struct {
+		name   string
+		status entropyStatus
+		ok     bool
+	}{
+		{"ready", entropyReady, true},
+		{"not ready", entropyNotReady, false},
+		{"probe failed", entropyUnknown, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeEntropy(t, tt.status)
+			err := requireEntropy()
+			if (err == nil) != tt.ok {
+				t.Fatalf("requireEntropy: %v, want ok %v", err, tt.ok)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.status.Detail) {
+				t.Errorf("error %q does not say what the probe reported", err)
+			}
+		})
+	}
+}
+
+// No key is encrypted while the pool is not initialized, so no account is
+// added
+func TestEntropyRefusesKeys(t *testing.T) {
+	testHome(t)
+	fakeEntropy(t, entropyNotReady)
+
+	if _, err := encryptNsec("nsec1whatever", testPassword); err == nil {
+		t.Error("encryptNsec succeeded without entropy")
+	}
+	key, npub := testKey(t)
+	prompt := &scriptedPrompter{answers: []string{key, testPassword, testPassword}}
+	if err := addAccount(prompt, ""); err == nil {
+		t.Error("add-account succeeded without entropy")
+	}
+	if accountExists(npub) {
+		t.Error("the account was stored without entropy")
+	}
+}
+
+// doctor reports the probe's answer: a warning that counts as a problem
+// when the pool is not ready, otherwise just the detail
+func TestDoctorEntropy(t *testing.T) {
+	tests := []struct {
+		name   string
+		status entropyStatus
+		line   string
+	}{
+		{"ready", entropyReady, "✅ entropy: " + entropyReady.Detail},
+		{"not ready", entropyNotReady, "⚠️  entropy: " + entropyNotReady.Detail + " - adding or re-encrypting keys is refused until it is"},
+		{"probe failed", entropyUnknown, "ℹ️  entropy: " + entropyUnknown.Detail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHome(t)
+			addTestAccount(t, "")
+			fakeEntropy(t, tt.status)
+			var err error
+			output := captureStdout(t, func() { err = doctorCmd(nil) })
+			if !strings.Contains(output, tt.line+"\n") {
+				t.Errorf("doctor output lacks %q:\n%s", tt.line, output)
+			}
+			if problem := !tt.status.Ready; (err != nil) != problem {
+				t.Errorf("doctor: %v, want a problem %v\n%s", err, problem, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/77elements/noorsigner/pkg/client"
+)
+
+// Default event limits, mirroring strfry's defaults (maxEventSize 65536,
+// maxNumTags 2000, maxTagValSize 1024). strfry has no limit on the tags as a
+// whole; tag bytes default to the event size so only the event limit bites.
+const (
+	defaultMaxEventBytes      = 65536
+	defaultMaxEventTags       = 2000
+	defaultMaxEventTagBytes   = 65536
+	defaultMaxTagElementBytes = 1024
+
+	// maxEventLimit bounds the event limits in config.json
+	maxEventLimit = 1 << 24
+)
+
+// Event problem codes, returned by validate_event and by sign_event when it
+// refuses an event
+const (
+	codeInvalidEvent      = "ERR_INVALID_EVENT"
+	codeEventTooLarge     = "ERR_EVENT_TOO_LARGE"
+	codeTooManyTags       = "ERR_TOO_MANY_TAGS"
+	codeTagsTooLarge      = "ERR_TAGS_TOO_LARGE"
+	codeTagElementTooLong = "ERR_TAG_ELEMENT_TOO_LONG"
+)
+
+// NostrEvent is a signed Nostr event (NIP-01)
+type NostrEvent = client.Event
+
+// completeEvent returns the event that was hashed with id and sig filled in
+func completeEvent(eventJSON string, eventHash []byte, signature string) (*NostrEvent, error) {
+	var event NostrEvent
+	if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
+		return nil, err
+	}
+	event.ID = encodeHex(eventHash)
+	event.Sig = signature
+	return &event, nil
+}
+
+// eventKind returns the event's kind, or nil if it has none
+func eventKind(eventJSON string) *int {
+	var event struct {
+		Kind *int `json:"kind"`
+	}
+	if json.Unmarshal([]byte(eventJSON), &event) != nil {
+		return nil
+	}
+	return event.Kind
+}
+
+// fillEventDefaults stamps created_at (now) and pubkey onto an event that
+// lacks them and reports whether anything was added. Fields that are present
+// are never touched. An empty pubkey leaves pubkey alone.
+func fillEventDefaults(event map[string]interface{}, pubkey string) bool {
+	filled := false
+	if createdAt, ok := event["created_at"]; !ok || createdAt == nil {
+		event["created_at"] = time.Now().Unix()
+		filled = true
+	}
+	if current, ok := event["pubkey"]; pubkey != "" && (!ok || current == nil) {
+		event["pubkey"] = pubkey
+		filled = true
+	}
+	return filled
+}
+
+// normalizeEvent returns eventJSON with missing created_at and pubkey
+// filled in. Complete (or unparseable) events are returned unchanged, byte
+// for byte.
+func normalizeEvent(eventJSON, pubkey string) string {
+	var event map[string]interface{}
+	// JSON null decodes to a nil map
+	if err := json.Unmarshal([]byte(eventJSON), &event); err != nil || event == nil || !fillEventDefaults(event, pubkey) {
+		return eventJSON
+	}
+	normalized, err := marshalCompact(event)
+	if err != nil {
+		return eventJSON
+	}
+	return string(normalized)
+}
+
+// checkEventPubkey refuses an event that names another pubkey than the
+// signing account's: its signature would not verify
+func checkEventPubkey(eventJSON, pubkey string) error {
+	var event struct {
+		Pubkey *string `json:"pubkey"`
+	}
+	if json.Unmarshal([]byte(eventJSON), &event) != nil || event.Pubkey == nil || *event.Pubkey == pubkey {
+		return nil // Malformed events are left to validateEvent
+	}
+	return &eventError{
+		Code:    codePubkeyMismatch,
+		Message: fmt.Sprintf("event pubkey %q is not the unlocked account (%s)", *event.Pubkey, pubkey),
+	}
+}
+
+// eventLimits are the limits an event must stay within to be signed (0 = no limit)
+type eventLimits struct {
+	// MaxEventBytes bounds the event as published, including id and sig
+	MaxEventBytes int `json:"max_event_bytes"`
+	// MaxTags bounds the number of tags
+	MaxTags int `json:"max_event_tags"`
+	// MaxTagBytes bounds the serialized tags array
+	MaxTagBytes int `json:"max_event_tag_bytes"`
+	// MaxTagElementBytes bounds each string inside a tag
+	MaxTagElementBytes int `json:"max_tag_element_bytes"`
+}
+
+// EventMeasurements are the sizes validateEvent measured, in bytes of JSON
+type EventMeasurements struct {
+	EventBytes        int `json:"event_bytes"`
+	ContentBytes      int `json:"content_bytes"`
+	TagCount          int `json:"tag_count"`
+	TagBytes          int `json:"tag_bytes"`
+	LongestTagElement int `json:"longest_tag_element"`
+}
+
+// EventProblem is one reason an event would not be signed
+type EventProblem struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// EventDiagnostics is the result of validateEvent
+type EventDiagnostics struct {
+	Valid bool `json:"valid"`
+	// EventID is the NIP-01 id the event would get (empty if it can't be hashed)
+	EventID  string            `json:"event_id,omitempty"`
+	Measured EventMeasurements `json:"measured"`
+	Limits   eventLimits       `json:"limits"`
+	Problems []EventProblem    `json:"problems"`
+}
+
+// ValidateEventResponse represents validate_event response
+type ValidateEventResponse struct {
+	ID string `json:"id"`
+	EventDiagnostics
+}
+
+// eventError is a refused event. Code is one of the event problem codes.
+type eventError struct {
+	Code    string
+	Message string
+}
+
+func (e *eventError) Error() string {
+	return e.Message
+}
+
+// validateEvent checks an unsigned event against limits without signing it.
+// sign_event, validate_event and the sign command all go through here.
+func validateEvent(eventJSON string, limits eventLimits) EventDiagnostics {
+	diagnostics := EventDiagnostics{Limits: limits, Problems: []EventProblem{}}
+	problem := func(code, format string, args ...interface{}) {
+		diagnostics.Problems = append(diagnostics.Problems, EventProblem{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
+		problem(codeInvalidEvent, "invalid event JSON: %v", err)
+		return diagnostics
+	}
+
+	if eventHash, err := createEventHash(eventJSON); err != nil {
+		problem(codeInvalidEvent, "invalid event: %v", err)
+	} else {
+		diagnostics.EventID = encodeHex(eventHash)
+	}
+
+	content, _ := event["content"].(string)
+	diagnostics.Measured.ContentBytes = len(content)
+
+	tags, _ := event["tags"].([]interface{})
+	diagnostics.Measured.TagCount = len(tags)
+	tagJSON, _ := marshalCompact(tags)
+	diagnostics.Measured.TagBytes = len(tagJSON)
+
+	longestTag := 0
+	for i, tag := range tags {
+		elements, ok := tag.([]interface{})
+		if !ok {
+			problem(codeInvalidEvent, "invalid event: tag %d is not an array", i)
+			continue
+		}
+		for j, element := range elements {
+			value, ok := element.(string)
+			if !ok {
+				problem(codeInvalidEvent, "invalid event: tag %d element %d is not a string", i, j)
+				continue
+			}
+			if len(value) > diagnostics.Measured.LongestTagElement {
+				diagnostics.Measured.LongestTagElement = len(value)
+				longestTag = i
+			}
+		}
+	}
+
+	// Size as relays see it: with the 64 hex id and 128 hex sig filled in
+	published := make(map[string]interface{}, len(event)+2)
+	for key, value := range event {
+		published[key] = value
+	}
+	published["id"] = strings.Repeat("0", 64)
+	published["sig"] = strings.Repeat("0", 128)
+	publishedJSON, _ := marshalCompact(published)
+	diagnostics.Measured.EventBytes = len(publishedJSON)
+
+	measured := diagnostics.Measured
+	if limits.MaxTags > 0 && measured.TagCount > limits.MaxTags {
+		problem(codeTooManyTags, "event has %d tags (limit %d)", measured.TagCount, limits.MaxTags)
+	}
+	if limits.MaxTagBytes > 0 && measured.TagBytes > limits.MaxTagBytes {
+		problem(codeTagsTooLarge, "event tags are %d bytes (limit %d)", measured.TagBytes, limits.MaxTagBytes)
+	}
+	if limits.MaxTagElementBytes > 0 && measured.LongestTagElement > limits.MaxTagElementBytes {
+		problem(codeTagElementTooLong, "tag %d has an element of %d bytes (limit %d)", longestTag, measured.LongestTagElement, limits.MaxTagElementBytes)
+	}
+	// The overall size last: tag problems are the more specific diagnosis
+	if limits.MaxEventBytes > 0 && measured.EventBytes > limits.MaxEventBytes {
+		problem(codeEventTooLarge, "event is %d bytes once signed (limit %d)", measured.EventBytes, limits.MaxEventBytes)
+	}
+
+	diagnostics.Valid = len(diagnostics.Problems) == 0
+	return diagnostics
+}
+
+// err returns the first problem as an *eventError (nil if the event is valid)
+func (d EventDiagnostics) err() error {
+	if len(d.Problems) == 0 {
+		return nil
+	}
+	return &eventError{Code: d.Problems[0].Code, Message: d.Problems[0].Message}
+}
+
+// marshalCompact encodes v the way JavaScript's JSON.stringify and NIP-01
+// do. Go's encoder escapes <, > and & unless told not to, and always escapes
+// U+2028 and U+2029; either changes the event id.
+func marshalCompact(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	encoded := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+	if !bytes.Contains(encoded, []byte(`\u202`)) {
+		return encoded, nil
+	}
+	out := make([]byte, 0, len(encoded))
+	for i := 0; i < len(encoded); i++ {
+		if encoded[i] != '\\' || i+1 >= len(encoded) {
+			out = append(out, encoded[i])
+			continue
+		}
+		// Escapes come in whole: an escaped backslash can't start a \u2028
+		escape := string(encoded[i:min(i+6, len(encoded))])
+		switch escape {
+		case `\u2028`:
+			out = append(out, "\u2028"...)
+			i += 5
+		case `\u2029`:
+			out = append(out, "\u2029"...)
+			i += 5
+		default:
+			out = append(out, encoded[i], encoded[i+1])
+			i++
+		}
+	}
+	return out, nil
+}
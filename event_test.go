@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const testEventPubkey = "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+
+func TestMarshalCompact(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"html characters", "<b>Tom & Jerry</b>", `"<b>Tom & Jerry</b>"`},
+		{"script tag", "</script><script>alert(1)</script>", `"</script><script>alert(1)</script>"`},
+		{"line and paragraph separators", "a\u2028b\u2029c", "\"a\u2028b\u2029c\""},
+		{"escaped backslash before u2028", `\u2028`, `"\\u2028"`},
+		{"quotes and backslashes", `say "hi" \o/`, `"say \"hi\" \\o/"`},
+		{"control characters", "tab\there\nnew line", `"tab\there\nnew line"`},
+		{"nested", []interface{}{0, "a&b", []interface{}{[]interface{}{"t", "<>"}}}, `[0,"a&b",[["t","<>"]]]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := marshalCompact(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("marshalCompact = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateEventHashDoesNotEscapeHTML(t *testing.T) {
+	eventJSON := `{"pubkey":"` + testEventPubkey + `","created_at":1700000000,"kind":1,` +
+		`"tags":[["t","a&b"],["r","https://example.com/?x=<y>"]],"content":"<b>bold</b> & more"}`
+	serialized := `[0,"` + testEventPubkey + `",1700000000,1,` +
+		`[["t","a&b"],["r","https://example.com/?x=<y>"]],"<b>bold</b> & more"]`
+	want := sha256.Sum256([]byte(serialized))
+
+	hash, err := createEventHash(eventJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(hash); got != hex.EncodeToString(want[:]) {
+		t.Errorf("createEventHash = %s, want the hash of %s", got, serialized)
+	}
+}
+
+func TestCreateEventHashMatchesGoNostr(t *testing.T) {
+	contents := []string{
+		"plain",
+		"<script>alert('x')</script>",
+		"Tom & Jerry > Itchy & Scratchy",
+		"line\u2028separator\u2029paragraph",
+		"emoji 🤙 and ünïcödé",
+		"quotes \" and backslashes \\",
+		"control \x01\x1f characters",
+	}
+	for _, content := range contents {
+		event := nostr.Event{
+			PubKey:    testEventPubkey,
+			CreatedAt: 1700000000,
+			Kind:      1,
+			Tags:      nostr.Tags{{"t", content}},
+			Content:   content,
+		}
+		eventJSON, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := createEventHash(string(eventJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := hex.EncodeToString(hash), event.GetID(); got != want {
+			t.Errorf("content %q: createEventHash = %s, go-nostr = %s", content, got, want)
+		}
+	}
+}
+
+// An event that already has created_at and pubkey is passed on exactly as
+// the client wrote it: spacing, key order, escapes and extra fields included
+func TestNormalizeEventKeepsCompleteEvents(t *testing.T) {
+	complete := []string{
+		`{"pubkey":"` + testEventPubkey + `","created_at":1700000000,"kind":1,"tags":[],"content":"hi"}`,
+		"{\n  \"content\" : \"hi\",\n  \"tags\" : [ [\"t\", \"x\"] ],\n  \"kind\" : 1,\n  \"created_at\" : 1700000000,\n  \"pubkey\" : \"" + testEventPubkey + "\"\n}",
+		`{"pubkey":"` + testEventPubkey + `","created_at":1700000000,"kind":1,"tags":[],"content":"café \/ 🤙 <b>&amp;</b>"}`,
+		`{"id":"","sig":"","pubkey":"` + testEventPubkey + `","created_at":1.7e9,"kind":1,"tags":[],"content":"","client":"x"}`,
+		`{"pubkey":"` + testEventPubkey + `","created_at":0,"kind":1,"tags":[],"content":""}`,
+		`{"pubkey":"` + testEventPubkey + `","created_at":1700000000,"kind":1,"tags":[],"content":"a","content":"b"}`,
+	}
+	for _, raw := range complete {
+		if got := normalizeEvent(raw, testEventPubkey); got != raw {
+			t.Errorf("normalizeEvent changed a complete event:\n got %s\nwant %s", got, raw)
+		}
+	}
+
+	// Only a missing or null field is filled in
+	for _, raw := range []string{
+		`{"created_at":1700000000,"kind":1,"tags":[],"content":"hi"}`,
+		`{"pubkey":null,"created_at":1700000000,"kind":1,"tags":[],"content":"hi"}`,
+		`{"pubkey":"` + testEventPubkey + `","kind":1,"tags":[],"content":"hi"}`,
+	} {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(normalizeEvent(raw, testEventPubkey)), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event["pubkey"] != testEventPubkey || event["created_at"] == nil {
+			t.Errorf("normalizeEvent(%s) = %v, want pubkey and created_at filled in", raw, event)
+		}
+	}
+}
+
+// sign_event signs the client's bytes: the id is the hash of exactly the
+// event it sent
+func TestSignEventKeepsCompleteEvents(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	d := testDaemon(t, npub)
+	raw := "{\n  \"content\" : \"caf\\u00e9 \\/ <b>&amp;</b>\",\n  \"tags\" : [ [\"t\", \"x\"] ],\n  \"kind\" : 1,\n" +
+		"  \"created_at\" : 1700000000,\n  \"pubkey\" : \"" + d.pubkey + "\"\n}"
+	want, err := createEventHash(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.mu.RLock()
+	event, err := d.signEvent(raw)
+	d.mu.RUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != hex.EncodeToString(want) {
+		t.Errorf("id %s, want the hash of the event as sent (%x)", event.ID, want)
+	}
+	if event.Content != "café / <b>&amp;</b>" || event.CreatedAt != 1700000000 || event.Pubkey != d.pubkey {
+		t.Errorf("signed event %+v differs from the one sent", event)
+	}
+}
@@ -0,0 +1,69 @@
+#!/usr/bin/env node
+// Minimal NoorSigner client (Node.js, no dependencies).
+
+const fs = require('fs');
+const net = require('net');
+const os = require('os');
+const path = require('path');
+const readline = require('readline');
+
+function socketPath() {
+  if (process.env.NOORSIGNER_SOCKET) return process.env.NOORSIGNER_SOCKET;
+  const candidates = [];
+  if (process.env.XDG_RUNTIME_DIR) {
+    candidates.push(path.join(process.env.XDG_RUNTIME_DIR, 'noorsigner', 'noorsigner.sock'));
+  }
+  candidates.push(path.join(os.homedir(), '.noorsigner', 'noorsigner.sock'));
+  return candidates.find((p) => fs.existsSync(p)) || candidates[candidates.length - 1];
+}
+
+class NoorSigner {
+  constructor(socket = socketPath()) {
+    this.conn = net.createConnection(socket);
+    this.lines = readline.createInterface({ input: this.conn })[Symbol.asyncIterator]();
+    this.seq = 0;
+  }
+
+  async request(method, params = {}) {
+    const id = `node-${++this.seq}`;
+    this.conn.write(JSON.stringify({ id, method, ...params }) + '\n');
+    const { value } = await this.lines.next();
+    const response = JSON.parse(value);
+    if (response.error) throw new Error(`${method}: ${response.error}`);
+    return response;
+  }
+
+  close() {
+    this.conn.end();
+  }
+}
+
+async function main() {
+  const signer = new NoorSigner();
+  try {
+    const pubkey = (await signer.request('get_public_key')).signature;
+    console.log('pubkey:    ', pubkey);
+
+    const event = {
+      pubkey,
+      created_at: Math.floor(Date.now() / 1000),
+      kind: 1,
+      tags: [],
+      content: 'Hello from Node',
+    };
+    const { signature } = await signer.request('sign_event', { event_json: JSON.stringify(event) });
+    console.log('signature: ', signature);
+
+    const payload = (await signer.request('nip44_encrypt', { plaintext: 'secret', recipient_pubkey: pubkey })).signature;
+    const plaintext = (await signer.request('nip44_decrypt', { payload, sender_pubkey: pubkey })).signature;
+    console.log('nip44:     ', plaintext === 'secret' ? 'ok' : 'MISMATCH');
+    process.exitCode = plaintext === 'secret' ? 0 : 1;
+  } finally {
+    signer.close();
+  }
+}
+
+main().catch((err) => {
+  console.error(err.message);
+  process.exit(1);
+});
@@ -0,0 +1,71 @@
+#!/usr/bin/env python3
+"""Minimal NoorSigner client (Python 3, standard library only)."""
+
+import json
+import os
+import socket
+import sys
+import time
+
+
+def socket_path():
+    if os.environ.get("NOORSIGNER_SOCKET"):
+        return os.environ["NOORSIGNER_SOCKET"]
+    candidates = []
+    if os.environ.get("XDG_RUNTIME_DIR"):
+        candidates.append(os.path.join(os.environ["XDG_RUNTIME_DIR"], "noorsigner", "noorsigner.sock"))
+    candidates.append(os.path.join(os.path.expanduser("~"), ".noorsigner", "noorsigner.sock"))
+    for path in candidates:
+        if os.path.exists(path):
+            return path
+    return candidates[-1]
+
+
+class NoorSigner:
+    def __init__(self, path=None):
+        self.sock = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+        self.sock.connect(path or socket_path())
+        self.reader = self.sock.makefile("r", encoding="utf-8")
+        self.seq = 0
+
+    def request(self, method, **params):
+        self.seq += 1
+        req = {"id": "py-%d" % self.seq, "method": method}
+        req.update(params)
+        self.sock.sendall((json.dumps(req) + "\n").encode("utf-8"))
+        response = json.loads(self.reader.readline())
+        if response.get("error"):
+            raise RuntimeError("%s: %s" % (method, response["error"]))
+        return response
+
+    def close(self):
+        self.reader.close()
+        self.sock.close()
+
+
+def main():
+    signer = NoorSigner()
+    try:
+        pubkey = signer.request("get_public_key")["signature"]
+        print("pubkey:    ", pubkey)
+
+        event = {
+            "pubkey": pubkey,
+            "created_at": int(time.time()),
+            "kind": 1,
+            "tags": [],
+            "content": "Hello from Python",
+        }
+        sig = signer.request("sign_event", event_json=json.dumps(event))["signature"]
+        print("signature: ", sig)
+
+        payload = signer.request("nip44_encrypt", plaintext="secret", recipient_pubkey=pubkey)["signature"]
+        plaintext = signer.request("nip44_decrypt", payload=payload, sender_pubkey=pubkey)["signature"]
+        print("nip44:     ", "ok" if plaintext == "secret" else "MISMATCH")
+        return 0 if plaintext == "secret" else 1
+    finally:
+        signer.close()
+
+
+if __name__ == "__main__":
+    sys.exit(main())
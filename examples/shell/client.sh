@@ -0,0 +1,37 @@
+#!/bin/sh
+# Minimal NoorSigner client using socat (one request per connection).
+set -e
+
+if [ -n "$NOORSIGNER_SOCKET" ]; then
+    SOCKET="$NOORSIGNER_SOCKET"
+elif [ -n "$XDG_RUNTIME_DIR" ] && [ -S "$XDG_RUNTIME_DIR/noorsigner/noorsigner.sock" ]; then
+    SOCKET="$XDG_RUNTIME_DIR/noorsigner/noorsigner.sock"
+else
+    SOCKET="$HOME/.noorsigner/noorsigner.sock"
+fi
+
+request() {
+    printf '%s\n' "$1" | socat -t 5 - "UNIX-CONNECT:$SOCKET"
+}
+
+# Extract a top-level string field without jq (values are plain strings here)
+field() {
+    sed -n "s/.*\"$1\":\"\([^\"]*\)\".*/\1/p"
+}
+
+PUBKEY=$(request '{"id":"sh-1","method":"get_public_key"}' | field signature)
+echo "pubkey:     $PUBKEY"
+
+NOW=$(date +%s)
+EVENT="{\\\"pubkey\\\":\\\"$PUBKEY\\\",\\\"created_at\\\":$NOW,\\\"kind\\\":1,\\\"tags\\\":[],\\\"content\\\":\\\"Hello from shell\\\"}"
+SIG=$(request "{\"id\":\"sh-2\",\"method\":\"sign_event\",\"event_json\":\"$EVENT\"}" | field signature)
+echo "signature:  $SIG"
+
+PAYLOAD=$(request "{\"id\":\"sh-3\",\"method\":\"nip44_encrypt\",\"plaintext\":\"secret\",\"recipient_pubkey\":\"$PUBKEY\"}" | field signature)
+PLAIN=$(request "{\"id\":\"sh-4\",\"method\":\"nip44_decrypt\",\"payload\":\"$PAYLOAD\",\"sender_pubkey\":\"$PUBKEY\"}" | field signature)
+if [ "$PLAIN" = "secret" ]; then
+    echo "nip44:      ok"
+else
+    echo "nip44:      MISMATCH"
+    exit 1
+fi
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The example clients run against a daemon on a temporary socket. Each is
+// skipped when its interpreter is not installed.
+func TestExampleClients(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the examples speak to a Unix socket")
+	}
+	testHome(t)
+	npub := addTestAccount(t, "")
+	d := testDaemon(t, npub)
+	serveTestDaemon(t, d)
+	socketPath, err := getSocketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	examples := []struct {
+		name  string
+		needs []string // Programs that must be on PATH
+		run   []string
+	}{
+		{"python", []string{"python3"}, []string{"python3", "examples/python/client.py"}},
+		{"node", []string{"node"}, []string{"node", "examples/node/client.js"}},
+		{"shell", []string{"sh", "socat"}, []string{"sh", "examples/shell/client.sh"}},
+	}
+	signature := regexp.MustCompile(`(?m)^signature:\s+[0-9a-f]{128}$`)
+	for _, example := range examples {
+		t.Run(example.name, func(t *testing.T) {
+			for _, program := range example.needs {
+				if _, err := exec.LookPath(program); err != nil {
+					t.Skipf("%s is not installed", program)
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			cmd := exec.CommandContext(ctx, example.run[0], example.run[1:]...)
+			cmd.Env = append(os.Environ(), "NOORSIGNER_SOCKET="+socketPath)
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("%s: %v\n%s", strings.Join(example.run, " "), err, output)
+			}
+			if !regexp.MustCompile(`(?m)^pubkey:\s+` + d.pubkey + `$`).Match(output) {
+				t.Errorf("output lacks the pubkey %s:\n%s", d.pubkey, output)
+			}
+			if !signature.Match(output) {
+				t.Errorf("output lacks a signature:\n%s", output)
+			}
+			if !regexp.MustCompile(`(?m)^nip44:\s+ok$`).Match(output) {
+				t.Errorf("NIP-44 round trip failed:\n%s", output)
+			}
+		})
+	}
+}
+
+// The conformance command passes against the daemon
+func TestConformanceCommand(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	serveTestDaemon(t, testDaemon(t, npub))
+
+	var err error
+	output := captureStdout(t, func() { err = conformanceCmd(nil) })
+	if err != nil {
+		t.Fatalf("conformance: %v\n%s", err, output)
+	}
+	for _, testCase := range conformanceCorpus {
+		if !strings.Contains(output, testCase.Method) {
+			t.Errorf("output does not report %s:\n%s", testCase.Method, output)
+		}
+	}
+}
+
+// corpusExchange is one line of examples/protocol/corpus.jsonl: requests
+// sent in order on one connection and the responses they must get
+type corpusExchange struct {
+	Name     string `json:"name"`
+	Exchange []struct {
+		Send   any `json:"send"`   // A request object, or a raw line
+		Expect any `json:"expect"` // The response (see matchCorpus)
+	} `json:"exchange"`
+}
+
+var corpusHex = map[string]*regexp.Regexp{
+	"<hex64>":  regexp.MustCompile(`^[0-9a-f]{64}$`),
+	"<hex128>": regexp.MustCompile(`^[0-9a-f]{128}$`),
+}
+
+// substituteCorpus replaces the ${...} placeholders in the string values of
+// value
+func substituteCorpus(value any, replacer *strings.Replacer) any {
+	switch value := value.(type) {
+	case string:
+		return replacer.Replace(value)
+	case map[string]any:
+		substituted := make(map[string]any, len(value))
+		for key, field := range value {
+			substituted[key] = substituteCorpus(field, replacer)
+		}
+		return substituted
+	case []any:
+		substituted := make([]any, len(value))
+		for i, element := range value {
+			substituted[i] = substituteCorpus(element, replacer)
+		}
+		return substituted
+	}
+	return value
+}
+
+// matchCorpus compares a response with what the corpus expects: objects
+// have exactly the expected fields, and a string value may be a marker -
+// <hex64>, <hex128>, <string> (any non-empty string), <number> or <any>
+func matchCorpus(path string, want, got any) error {
+	switch want := want.(type) {
+	case string:
+		switch want {
+		case "<any>":
+			return nil
+		case "<number>":
+			if _, ok := got.(float64); !ok {
+				return fmt.Errorf("%s: %v is not a number", path, got)
+			}
+			return nil
+		case "<string>":
+			if s, ok := got.(string); !ok || s == "" {
+				return fmt.Errorf("%s: %v is not a non-empty string", path, got)
+			}
+			return nil
+		}
+		if pattern := corpusHex[want]; pattern != nil {
+			if s, ok := got.(string); !ok || !pattern.MatchString(s) {
+				return fmt.Errorf("%s: %v is not %s", path, got, want)
+			}
+			return nil
+		}
+	case map[string]any:
+		object, ok := got.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an object", path, got)
+		}
+		for key := range object {
+			if _, expected := want[key]; !expected {
+				return fmt.Errorf("%s: unexpected field %q", path, key)
+			}
+		}
+		for key, field := range want {
+			value, present := object[key]
+			if !present {
+				return fmt.Errorf("%s: field %q missing", path, key)
+			}
+			if err := matchCorpus(path+"."+key, field, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []any:
+		array, ok := got.([]any)
+		if !ok || len(array) != len(want) {
+			return fmt.Errorf("%s: %v is not an array of %d", path, got, len(want))
+		}
+		for i := range want {
+			if err := matchCorpus(fmt.Sprintf("%s[%d]", path, i), want[i], array[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !reflect.DeepEqual(want, got) {
+		return fmt.Errorf("%s: %v, want %v", path, got, want)
+	}
+	return nil
+}
+
+// Every exchange of the checked-in protocol corpus gets the documented
+// responses, each on a connection of its own
+func TestProtocolCorpus(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "corpus")
+	d := testDaemon(t, npub)
+	serveTestDaemon(t, d)
+
+	file, err := os.Open(filepath.Join("examples", "protocol", "corpus.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var exchange corpusExchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			t.Fatalf("corpus.jsonl:%d: %v", line, err)
+		}
+		t.Run(exchange.Name, func(t *testing.T) {
+			conn := dialTestDaemon(t)
+			reader := bufio.NewReader(conn)
+			previous := map[string]any{}
+			for step, pair := range exchange.Exchange {
+				previousSignature, _ := previous["signature"].(string)
+				replacer := strings.NewReplacer("${pubkey}", d.pubkey, "${npub}", npub, "${previous.signature}", previousSignature)
+
+				request, raw := pair.Send.(string)
+				if !raw {
+					data, err := json.Marshal(substituteCorpus(pair.Send, replacer))
+					if err != nil {
+						t.Fatal(err)
+					}
+					request = string(data)
+				}
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				if _, err := conn.Write([]byte(request + "\n")); err != nil {
+					t.Fatal(err)
+				}
+				responseLine, err := reader.ReadBytes('\n')
+				if err != nil {
+					t.Fatalf("step %d: %v", step+1, err)
+				}
+				var response map[string]any
+				if err := json.Unmarshal(responseLine, &response); err != nil {
+					t.Fatalf("step %d: response %s: %v", step+1, responseLine, err)
+				}
+				if err := matchCorpus("response", substituteCorpus(pair.Expect, replacer), response); err != nil {
+					t.Fatalf("step %d: %v\nsent %s\ngot  %s", step+1, err, request, responseLine)
+				}
+				if signed, ok := response["event"]; ok {
+					verifyCorpusEvent(t, signed, response)
+				}
+				previous = response
+			}
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verifyCorpusEvent checks a signed event with go-nostr and against the
+// signature and event_id of its response
+func verifyCorpusEvent(t *testing.T, signed any, response map[string]any) {
+	t.Helper()
+	data, _ := json.Marshal(signed)
+	var event nostr.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.GetID() != event.ID {
+		t.Errorf("id %s, go-nostr computes %s", event.ID, event.GetID())
+	}
+	if ok, err := event.CheckSignature(); !ok {
+		t.Errorf("invalid signature: %v", err)
+	}
+	if response["signature"] != event.Sig || response["event_id"] != event.ID {
+		t.Errorf("signature %v and event_id %v do not match the event", response["signature"], response["event_id"])
+	}
+}
@@ -0,0 +1,661 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+const (
+	// fidoRPID is the relying party of every credential noorsigner creates
+	fidoRPID = "noorsigner"
+
+	codePasswordDisabled = "ERR_PASSWORD_DISABLED"
+)
+
+var (
+	// errPasswordDisabled is returned when a password is offered for an
+	// account that only unlocks with its security key
+	errPasswordDisabled = errors.New("password unlock is turned off for this account - use the security key (noorsigner fido unlock)")
+
+	// errNoSecurityKey is returned when no FIDO2 authenticator is attached
+	errNoSecurityKey = errors.New("no security key found")
+)
+
+// FidoEnrollment is an account's fido.json: the credential on the security
+// key and the salt it turns into the unlock secret. The secret itself is
+// never stored; the key recomputes it from the credential ID and salt on
+// every tap.
+//
+// The account key encrypted with the secret is a second key file,
+// keys.fido, while the password still works. Turning the password off
+// replaces keys.encrypted with that file, so no copy the password opens is
+// left.
+type FidoEnrollment struct {
+	CredentialID []byte `json:"credential_id"`
+	HMACSalt     []byte `json:"hmac_salt"`
+	RPID         string `json:"rp_id"`
+	// PIN: a PIN is combined with the secret, so the key alone doesn't unlock
+	PIN bool `json:"pin,omitempty"`
+	// PasswordDisabled: keys.encrypted is encrypted with the secret, and a
+	// password is refused without trying it
+	PasswordDisabled bool  `json:"password_disabled,omitempty"`
+	EnrolledAt       int64 `json:"enrolled_at"`
+}
+
+// fidoAuthenticator is the CTAP2 layer: a FIDO2 authenticator supporting
+// the hmac-secret extension
+type fidoAuthenticator interface {
+	// MakeCredential creates a credential with hmac-secret and returns its ID
+	MakeCredential(rpID string, userID []byte, userName string) ([]byte, error)
+	// HMACSecret returns the credential's hmac-secret output for salt. Another
+	// authenticator doesn't know the credential and fails.
+	HMACSecret(rpID string, credentialID, salt []byte) ([]byte, error)
+}
+
+// openFidoAuthenticator returns the attached security key. It is a variable
+// so the CTAP layer can be swapped out.
+var openFidoAuthenticator = func() (fidoAuthenticator, error) {
+	device, err := findFidoDevice()
+	if err != nil {
+		return nil, err
+	}
+	return fidoTools{device: device}, nil
+}
+
+// fidoTools talks to an authenticator through libfido2's command-line
+// tools (fido2-token, fido2-cred, fido2-assert), which keeps the build free
+// of cgo. The tools prompt for the authenticator's own PIN themselves.
+type fidoTools struct {
+	device string
+}
+
+// findFidoDevice returns the path of the first authenticator fido2-token lists
+func findFidoDevice() (string, error) {
+	output, err := exec.Command("fido2-token", "-L").Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("%w: fido2-token is not installed (install libfido2's tools, e.g. the fido2-tools package)", errNoSecurityKey)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: fido2-token: %v", errNoSecurityKey, err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if device, _, ok := strings.Cut(line, ": "); ok && device != "" {
+			return device, nil
+		}
+	}
+	return "", errNoSecurityKey
+}
+
+// run feeds input to a fido2 tool, one parameter per line, and returns its
+// output lines
+func (t fidoTools) run(tool string, flags []string, input ...string) ([]string, error) {
+	cmd := exec.Command(tool, append(flags, t.device)...)
+	cmd.Stdin = strings.NewReader(strings.Join(input, "\n") + "\n")
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v", tool, err)
+	}
+	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
+}
+
+// MakeCredential runs fido2-cred -M -h. The credential ID is the fifth
+// line of its output.
+func (t fidoTools) MakeCredential(rpID string, userID []byte, userName string) ([]byte, error) {
+	lines, err := t.run("fido2-cred", []string{"-M", "-h"},
+		base64.StdEncoding.EncodeToString(randomClientDataHash()), rpID, userName,
+		base64.StdEncoding.EncodeToString(userID))
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) < 5 {
+		return nil, fmt.Errorf("fido2-cred: unexpected output")
+	}
+	return base64.StdEncoding.DecodeString(lines[4])
+}
+
+// HMACSecret runs fido2-assert -G -h. The hmac-secret output is the fifth
+// line of its output.
+func (t fidoTools) HMACSecret(rpID string, credentialID, salt []byte) ([]byte, error) {
+	lines, err := t.run("fido2-assert", []string{"-G", "-h"},
+		base64.StdEncoding.EncodeToString(randomClientDataHash()), rpID,
+		base64.StdEncoding.EncodeToString(credentialID), base64.StdEncoding.EncodeToString(salt))
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) < 5 {
+		return nil, fmt.Errorf("fido2-assert: unexpected output (does the key support hmac-secret?)")
+	}
+	return base64.StdEncoding.DecodeString(lines[4])
+}
+
+// randomClientDataHash returns a throwaway client data hash: nothing checks
+// the signatures, only the hmac-secret output is used
+func randomClientDataHash() []byte {
+	hash := make([]byte, 32)
+	rand.Read(hash)
+	return hash
+}
+
+// fidoKeyInput is what the KDF gets in place of the password: the
+// hmac-secret output and the PIN, if the enrollment has one. The hex part
+// has a fixed length, so the two can't run into each other.
+func fidoKeyInput(secret []byte, pin string) string {
+	return "fido:" + hex.EncodeToString(secret) + pin
+}
+
+// loadFidoEnrollment reads npub's fido.json (nil, nil if not enrolled)
+func loadFidoEnrollment(npub string) (*FidoEnrollment, error) {
+	store, err := accountStore()
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := store.ReadRecord(npub, recordFido)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", recordFido, err)
+	}
+	var enrollment FidoEnrollment
+	if err := json.Unmarshal(content, &enrollment); err != nil {
+		return nil, fmt.Errorf("%s is damaged: %v", recordFido, err)
+	}
+	return &enrollment, nil
+}
+
+// saveFidoEnrollment writes npub's fido.json, after the records that go
+// with it
+func saveFidoEnrollment(npub string, enrollment *FidoEnrollment, records ...storeRecord) error {
+	store, err := accountStore()
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(enrollment, "", "  ")
+	if err != nil {
+		return err
+	}
+	records = append(records, storeRecord{Name: recordFido, Data: append(content, '\n')})
+	return store.WriteRecords(npub, records...)
+}
+
+// loadFidoKey returns npub's key file for the security key: keys.fido, or
+// keys.encrypted itself once the password is off. keys.fido is removed
+// last when the password goes off and written first when it comes back,
+// so a tap works at every step in between.
+func loadFidoKey(npub string) (*EncryptedKey, error) {
+	store, err := accountStore()
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := store.ReadRecord(npub, recordFidoKey)
+	if os.IsNotExist(err) {
+		return loadAccountEncryptedKey(npub)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", recordFidoKey, err)
+	}
+	encKey, err := parseEncryptedKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", recordFidoKey, err)
+	}
+	return encKey, nil
+}
+
+// checkPasswordAllowed refuses a password for an account whose enrollment
+// turned password unlock off. A fido.json that can't be read doesn't
+// refuse: the password then fails on keys.encrypted by itself.
+func checkPasswordAllowed(npub string) error {
+	enrollment, err := loadFidoEnrollment(npub)
+	if err == nil && enrollment != nil && enrollment.PasswordDisabled {
+		return errPasswordDisabled
+	}
+	return nil
+}
+
+// tapSecurityKey asks for a tap (and the PIN, if the enrollment has one)
+// and returns the key input for the enrollment
+func tapSecurityKey(prompt prompter, enrollment *FidoEnrollment) (string, error) {
+	authenticator, err := openFidoAuthenticator()
+	if err != nil {
+		return "", err
+	}
+	fmt.Println("👆 Touch your security key...")
+	secret, err := authenticator.HMACSecret(enrollment.RPID, enrollment.CredentialID, enrollment.HMACSalt)
+	if err != nil {
+		return "", fmt.Errorf("security key did not answer (wrong key?): %v", err)
+	}
+	defer zeroBytes(secret)
+	var pin string
+	if enrollment.PIN {
+		if pin, err = prompt.readPassword("Enter PIN: "); err != nil {
+			return "", err
+		}
+	}
+	return fidoKeyInput(secret, pin), nil
+}
+
+// fidoKeyFile returns npub's key file for the security key, or an error if
+// npub has no enrollment
+func fidoKeyFile(npub string) (*EncryptedKey, error) {
+	enrollment, err := loadFidoEnrollment(npub)
+	if err != nil {
+		return nil, err
+	}
+	if enrollment == nil {
+		return nil, fmt.Errorf("no security key enrolled for %s", displayNpub(npub))
+	}
+	return loadFidoKey(npub)
+}
+
+// openFidoKey decrypts npub's key with a key input. Like a wrong password,
+// a wrong PIN or secret counts toward the lockout (see lockout.go).
+func openFidoKey(npub, input string) (string, *btcec.PrivateKey, error) {
+	encKey, err := fidoKeyFile(npub)
+	if err != nil {
+		return "", nil, err
+	}
+	nsec, privateKey, err := openAccountKey(encKey, npub, input)
+	if errors.Is(err, errBadPassword) {
+		return "", nil, fmt.Errorf("%w (wrong security key or PIN)", err)
+	}
+	return nsec, privateKey, err
+}
+
+// fidoUnlockKey unlocks npub's key with a tap on its enrolled security key
+func fidoUnlockKey(prompt prompter, npub string, enrollment *FidoEnrollment) (string, *btcec.PrivateKey, error) {
+	if err := checkLockout(npub); err != nil {
+		return "", nil, err
+	}
+	input, err := tapSecurityKey(prompt, enrollment)
+	if err != nil {
+		return "", nil, err
+	}
+	return openFidoKey(npub, input)
+}
+
+// fidoOpenKeyBytes unlocks npub's key file for the security key with a tap,
+// returning the nsec in a buffer the caller clears
+func fidoOpenKeyBytes(prompt prompter, npub string, enrollment *FidoEnrollment) (*EncryptedKey, []byte, error) {
+	if err := checkLockout(npub); err != nil {
+		return nil, nil, err
+	}
+	encKey, err := loadFidoKey(npub)
+	if err != nil {
+		return nil, nil, err
+	}
+	input, err := tapSecurityKey(prompt, enrollment)
+	if err != nil {
+		return nil, nil, err
+	}
+	nsec, privateKey, err := openAccountKeyBytes(encKey, npub, input)
+	if errors.Is(err, errBadPassword) {
+		return nil, nil, fmt.Errorf("%w (wrong security key or PIN)", err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKey.Zero()
+	return encKey, nsec, nil
+}
+
+// fidoCmd handles noorsigner fido
+func fidoCmd(prompt prompter, args []string) error {
+	usage := "Usage: noorsigner fido enroll <npub> | unlock [nonce] | password on|off <npub> | remove <npub> | status"
+	switch {
+	case len(args) == 2 && args[0] == "enroll":
+		return fidoEnrollCmd(prompt, args[1])
+	case len(args) <= 2 && len(args) > 0 && args[0] == "unlock":
+		return fidoRespondCmd(prompt, args[1:])
+	case len(args) == 3 && args[0] == "password" && (args[1] == "on" || args[1] == "off"):
+		return fidoPasswordCmd(prompt, args[2], args[1] == "off")
+	case len(args) == 2 && args[0] == "remove":
+		return fidoRemoveCmd(prompt, args[1])
+	case len(args) == 1 && args[0] == "status":
+		return fidoStatusCmd()
+	default:
+		return commandFailed(1, "%s", usage)
+	}
+}
+
+// fidoEnrollCmd enrolls a security key for npub after checking its password
+func fidoEnrollCmd(prompt prompter, npub string) error {
+	if !accountExists(npub) {
+		return commandFailed(1, "Account not found: %s", displayNpub(npub))
+	}
+	if err := refuseIfFrozen(); err != nil {
+		return err
+	}
+	if enrollment, err := loadFidoEnrollment(npub); err != nil {
+		return commandFailed(1, "❌ %v", err)
+	} else if enrollment != nil {
+		return commandFailed(1, "A security key is already enrolled for %s. Remove it first: noorsigner fido remove <npub>", displayNpub(npub))
+	}
+
+	encKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		return commandFailed(1, "Error loading account: %v", err)
+	}
+	if err := refuseIfLockedOut(npub); err != nil {
+		return err
+	}
+	password, err := prompt.readAccountPassword("Enter password for this account: ")
+	if err != nil {
+		return commandFailed(1, "Error reading password: %v", err)
+	}
+	nsec, privateKey, err := openAccountKeyBytes(encKey, npub, password)
+	if err != nil {
+		return commandFailed(1, "%s", passwordFailureMessage(err))
+	}
+	defer zeroBytes(nsec)
+	privateKey.Zero()
+
+	authenticator, err := openFidoAuthenticator()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	userID := make([]byte, 32)
+	salt := make([]byte, 32)
+	if _, err := rand.Read(userID); err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if _, err := rand.Read(salt); err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	fmt.Println("👆 Touch your security key to create a credential...")
+	credentialID, err := authenticator.MakeCredential(fidoRPID, userID, npub)
+	if err != nil {
+		return commandFailed(1, "❌ Cannot create a credential: %v", err)
+	}
+	fmt.Println("👆 Touch it again to derive the unlock secret...")
+	secret, err := authenticator.HMACSecret(fidoRPID, credentialID, salt)
+	if err != nil {
+		return commandFailed(1, "❌ The security key does not support hmac-secret: %v", err)
+	}
+	defer zeroBytes(secret)
+
+	fmt.Println()
+	fmt.Println("Optionally set a PIN: then the key alone doesn't unlock the account.")
+	pin, err := prompt.readPassword("PIN (empty for none): ")
+	if err != nil {
+		return commandFailed(1, "Error reading PIN: %v", err)
+	}
+	if pin != "" {
+		confirm, err := prompt.readPassword("Repeat PIN: ")
+		if err != nil {
+			return commandFailed(1, "Error reading PIN: %v", err)
+		}
+		if confirm != pin {
+			return commandFailed(1, "❌ PINs do not match")
+		}
+	}
+
+	fidoKey, err := sealNsec(nsec, fidoKeyInput(secret, pin))
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	fidoContent, err := marshalEncryptedKey(fidoKey)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	enrollment := &FidoEnrollment{
+		CredentialID: credentialID,
+		HMACSalt:     salt,
+		RPID:         fidoRPID,
+		PIN:          pin != "",
+		EnrolledAt:   time.Now().Unix(),
+	}
+	err = saveFidoEnrollment(npub, enrollment, storeRecord{Name: recordFidoKey, Data: fidoContent})
+	auditCLI("fido_enroll", npub, err, nil)
+	if err != nil {
+		return commandFailed(1, "❌ Cannot save the enrollment: %v", err)
+	}
+
+	fmt.Println()
+	fmt.Printf("✅ Security key enrolled for %s\n", displayNpub(npub))
+	fmt.Println("   The daemon asks for a tap when it starts; the password still works.")
+	fmt.Println("   To allow the security key only: noorsigner fido password off <npub>")
+	return nil
+}
+
+// fidoRespondCmd answers the running daemon's credential request with a
+// tap: the first request for an enrolled account, or the one with nonce
+func fidoRespondCmd(prompt prompter, args []string) error {
+	requests, err := listPendingCredentialsViaDaemon()
+	if err != nil {
+		return commandFailed(1, "Error: %v", err)
+	}
+
+	var request *PendingCredential
+	var enrollment *FidoEnrollment
+	for i := range requests {
+		if len(args) == 1 && requests[i].Nonce != args[0] {
+			continue
+		}
+		if enrollment, err = loadFidoEnrollment(requests[i].Npub); err == nil && enrollment != nil {
+			request = &requests[i]
+			break
+		}
+	}
+	if request == nil {
+		return commandFailed(1, "No pending credential request for an account with a security key.\nUse 'noorsigner pending' to see open requests.")
+	}
+
+	fmt.Printf("Daemon requests the key for: %s\n", request.Npub)
+	fmt.Printf("Reason: %s\n", request.Reason)
+	input, err := tapSecurityKey(prompt, enrollment)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if err := respondCredentialViaDaemon(request.Nonce, input, true); err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	fmt.Printf("✅ Daemon unlocked for: %s\n", displayNpub(request.Npub))
+	return nil
+}
+
+// fidoPasswordCmd turns password unlock of npub off or back on. Both take a
+// tap, so the password is never turned off for a key that doesn't work.
+// Off makes keys.encrypted the security key's file; on asks for a new
+// password and encrypts keys.encrypted with it again.
+func fidoPasswordCmd(prompt prompter, npub string, disable bool) error {
+	if err := refuseIfFrozen(); err != nil {
+		return err
+	}
+	enrollment, err := loadFidoEnrollment(npub)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if enrollment == nil {
+		return commandFailed(1, "No security key enrolled for %s. Enroll one with: noorsigner fido enroll <npub>", displayNpub(npub))
+	}
+	if enrollment.PasswordDisabled == disable {
+		state := "on"
+		if disable {
+			state = "off"
+		}
+		fmt.Printf("Password unlock is already %s for %s\n", state, displayNpub(npub))
+		return nil
+	}
+
+	fidoKey, nsec, err := fidoOpenKeyBytes(prompt, npub, enrollment)
+	if err != nil {
+		return commandFailed(1, "%s", passwordFailureMessage(err))
+	}
+	defer zeroBytes(nsec)
+
+	if disable {
+		err = fidoPasswordOff(npub, enrollment, fidoKey)
+	} else {
+		err = fidoPasswordOn(prompt, npub, enrollment, fidoKey, nsec)
+	}
+	auditCLI("fido_password", npub, err, nil)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if disable {
+		fmt.Printf("✅ Password unlock turned off for %s - only the security key unlocks it now\n", displayNpub(npub))
+		fmt.Println("   keys.encrypted is now encrypted with the security key; the old password no longer opens it.")
+		fmt.Println("   Backups of the old keys.encrypted still open with the old password.")
+	} else {
+		fmt.Printf("✅ Password unlock turned back on for %s\n", displayNpub(npub))
+	}
+	return nil
+}
+
+// fidoPasswordOff replaces keys.encrypted with the security key's file,
+// then removes keys.fido
+func fidoPasswordOff(npub string, enrollment *FidoEnrollment, fidoKey *EncryptedKey) error {
+	if err := saveAccountEncryptedKey(npub, fidoKey); err != nil {
+		return err
+	}
+	enrollment.PasswordDisabled = true
+	if err := saveFidoEnrollment(npub, enrollment); err != nil {
+		return err
+	}
+	store, err := accountStore()
+	if err != nil {
+		return err
+	}
+	return store.DeleteRecord(npub, recordFidoKey)
+}
+
+// fidoPasswordOn asks for a new password, keeps the security key's file as
+// keys.fido and encrypts keys.encrypted with the password
+func fidoPasswordOn(prompt prompter, npub string, enrollment *FidoEnrollment, fidoKey *EncryptedKey, nsec []byte) error {
+	password, err := prompt.readPassword("New password for this account: ")
+	if err != nil {
+		return fmt.Errorf("cannot read password: %v", err)
+	}
+	if len(password) < 8 {
+		return fmt.Errorf("password must be at least 8 characters")
+	}
+	confirm, err := prompt.readPassword("Confirm password: ")
+	if err != nil {
+		return fmt.Errorf("cannot read password: %v", err)
+	}
+	if confirm != password {
+		return fmt.Errorf("passwords do not match")
+	}
+
+	passwordKey, err := sealNsec(nsec, password)
+	if err != nil {
+		return err
+	}
+	fidoContent, err := marshalEncryptedKey(fidoKey)
+	if err != nil {
+		return err
+	}
+	store, err := accountStore()
+	if err != nil {
+		return err
+	}
+	if err := store.WriteRecords(npub, storeRecord{Name: recordFidoKey, Data: fidoContent}); err != nil {
+		return err
+	}
+	if err := saveAccountEncryptedKey(npub, passwordKey); err != nil {
+		return err
+	}
+	enrollment.PasswordDisabled = false
+	return saveFidoEnrollment(npub, enrollment)
+}
+
+// fidoRemoveCmd removes npub's enrollment after checking its password, so
+// it can't leave an account that unlocks with neither
+func fidoRemoveCmd(prompt prompter, npub string) error {
+	if err := refuseIfFrozen(); err != nil {
+		return err
+	}
+	enrollment, err := loadFidoEnrollment(npub)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if enrollment == nil {
+		return commandFailed(1, "No security key enrolled for %s", displayNpub(npub))
+	}
+	if enrollment.PasswordDisabled {
+		return commandFailed(1, "Password unlock is off for %s. Turn it back on first: noorsigner fido password on <npub>", displayNpub(npub))
+	}
+
+	encKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		return commandFailed(1, "Error loading account: %v", err)
+	}
+	if err := refuseIfLockedOut(npub); err != nil {
+		return err
+	}
+	password, err := prompt.readAccountPassword("Enter password to confirm removal: ")
+	if err != nil {
+		return commandFailed(1, "Error reading password: %v", err)
+	}
+	_, privateKey, err := decryptAccountKey(encKey, npub, password)
+	if err != nil {
+		return commandFailed(1, "%s", passwordFailureMessage(err))
+	}
+	privateKey.Zero()
+
+	store, err := accountStore()
+	if err == nil {
+		err = store.DeleteRecord(npub, recordFido)
+	}
+	if err == nil {
+		err = store.DeleteRecord(npub, recordFidoKey)
+	}
+	auditCLI("fido_remove", npub, err, nil)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	fmt.Printf("✅ Security key removed from %s - the password unlocks it as before\n", displayNpub(npub))
+	fmt.Println("   The credential stays on the key; delete it there with your key's tools if you like.")
+	return nil
+}
+
+// fidoStatusCmd lists the accounts with a security key
+func fidoStatusCmd() error {
+	accounts, err := listAccounts()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	found := false
+	for _, account := range accounts {
+		enrollment, err := loadFidoEnrollment(account.Npub)
+		if err != nil {
+			fmt.Printf("  %s  ⚠️  %v\n", displayNpub(account.Npub), err)
+			continue
+		}
+		if enrollment == nil {
+			continue
+		}
+		if !found {
+			fmt.Println("Accounts with a security key:")
+			found = true
+		}
+		var notes []string
+		if enrollment.PIN {
+			notes = append(notes, "PIN")
+		}
+		if enrollment.PasswordDisabled {
+			notes = append(notes, "password off")
+		} else {
+			notes = append(notes, "password fallback")
+		}
+		fmt.Printf("  %s  enrolled %s, %s\n", displayNpub(account.Npub),
+			time.Unix(enrollment.EnrolledAt, 0).Format("2006-01-02 15:04"), strings.Join(notes, ", "))
+	}
+	if !found {
+		fmt.Println("No security keys enrolled. Enroll one with: noorsigner fido enroll <npub>")
+	}
+	return nil
+}
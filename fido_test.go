@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeAuthenticator is a security key in memory: its hmac-secret output is
+// an HMAC over the credential and salt under a key only it has
+type fakeAuthenticator struct {
+	deviceKey   []byte
+	credentials map[string]bool
+}
+
+func newFakeAuthenticator() *fakeAuthenticator {
+	deviceKey := make([]byte, 32)
+	rand.Read(deviceKey)
+	return &fakeAuthenticator{deviceKey: deviceKey, credentials: make(map[string]bool)}
+}
+
+func (a *fakeAuthenticator) MakeCredential(rpID string, userID []byte, userName string) ([]byte, error) {
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+	a.credentials[rpID+string(credentialID)] = true
+	return credentialID, nil
+}
+
+func (a *fakeAuthenticator) HMACSecret(rpID string, credentialID, salt []byte) ([]byte, error) {
+	if !a.credentials[rpID+string(credentialID)] {
+		return nil, errors.New("no credentials")
+	}
+	mac := hmac.New(sha256.New, a.deviceKey)
+	mac.Write(credentialID)
+	mac.Write(salt)
+	return mac.Sum(nil), nil
+}
+
+// attachAuthenticator makes a the security key openFidoAuthenticator
+// returns for the rest of the test (nil: none attached)
+func attachAuthenticator(t *testing.T, a *fakeAuthenticator) {
+	t.Helper()
+	previous := openFidoAuthenticator
+	openFidoAuthenticator = func() (fidoAuthenticator, error) {
+		if a == nil {
+			return nil, errNoSecurityKey
+		}
+		return a, nil
+	}
+	t.Cleanup(func() { openFidoAuthenticator = previous })
+}
+
+// enrollTestKey enrolls a new fake security key for npub, with pin ("" for
+// none), and returns it with the enrollment
+func enrollTestKey(t *testing.T, npub, pin string) (*fakeAuthenticator, *FidoEnrollment) {
+	t.Helper()
+	authenticator := newFakeAuthenticator()
+	attachAuthenticator(t, authenticator)
+	answers := []string{testPassword, pin}
+	if pin != "" {
+		answers = append(answers, pin)
+	}
+	if err := fidoEnrollCmd(&scriptedPrompter{answers: answers}, npub); err != nil {
+		t.Fatalf("fido enroll: %v", err)
+	}
+	enrollment, err := loadFidoEnrollment(npub)
+	if err != nil || enrollment == nil {
+		t.Fatalf("loadFidoEnrollment = %v, %v", enrollment, err)
+	}
+	return authenticator, enrollment
+}
+
+// passwordFailures returns npub's count of wrong passwords in lockout.json
+func passwordFailures(t *testing.T, npub string) int {
+	t.Helper()
+	lockouts, err := loadLockouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockout := lockouts.Accounts[npub]; lockout != nil {
+		return lockout.Failures
+	}
+	return 0
+}
+
+func TestFidoEnroll(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	store, _ := accountStore()
+	passwordFile, _, _ := store.ReadRecord(npub, recordKey)
+	_, enrollment := enrollTestKey(t, npub, "")
+
+	// fido.json holds the credential and salt, nothing encrypted
+	content, _, err := store.ReadRecord(npub, recordFido)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"encrypted_nsec", "key_salt", "key_nonce", "ciphertext"} {
+		if strings.Contains(string(content), field) {
+			t.Errorf("fido.json has %s:\n%s", field, content)
+		}
+	}
+	if len(enrollment.CredentialID) == 0 || len(enrollment.HMACSalt) != 32 || enrollment.RPID != fidoRPID || enrollment.PIN || enrollment.PasswordDisabled {
+		t.Errorf("enrollment %+v", enrollment)
+	}
+
+	// keys.encrypted is untouched, keys.fido is a second key file
+	if after, _, _ := store.ReadRecord(npub, recordKey); !bytes.Equal(after, passwordFile) {
+		t.Error("enrolling changed keys.encrypted")
+	}
+	fidoFile, _, err := store.ReadRecord(npub, recordFidoKey)
+	if err != nil {
+		t.Fatalf("keys.fido: %v", err)
+	}
+	fidoKey, err := parseEncryptedKey(fidoFile)
+	if err != nil || fidoKey.Version != keyFormatVersion {
+		t.Fatalf("keys.fido = %+v, %v; want the current key format", fidoKey, err)
+	}
+	if _, err := decryptNsec(fidoKey, testPassword); err == nil {
+		t.Error("the password opens keys.fido")
+	}
+
+	if err := fidoEnrollCmd(&scriptedPrompter{answers: []string{testPassword, ""}}, npub); exitCode(err) != 1 {
+		t.Errorf("a second enrollment = %v, want exit code 1", err)
+	}
+}
+
+func TestFidoEnrollWrongPassword(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	attachAuthenticator(t, newFakeAuthenticator())
+	if err := fidoEnrollCmd(&scriptedPrompter{answers: []string{"wrong password"}}, npub); exitCode(err) != 1 {
+		t.Fatalf("enroll with a wrong password = %v, want exit code 1", err)
+	}
+	if enrollment, _ := loadFidoEnrollment(npub); enrollment != nil {
+		t.Error("a wrong password enrolled a key")
+	}
+	store, _ := accountStore()
+	if _, _, err := store.ReadRecord(npub, recordFidoKey); !os.IsNotExist(err) {
+		t.Errorf("keys.fido after a failed enrollment: %v", err)
+	}
+	if failures := passwordFailures(t, npub); failures != 1 {
+		t.Errorf("%d password failures, want 1", failures)
+	}
+}
+
+func TestFidoUnlock(t *testing.T) {
+	tests := []struct {
+		name     string
+		pin      string
+		tapPIN   []string
+		other    bool // tap a security key without the credential
+		wantErr  error
+		failures int
+	}{
+		{"enrolled key", "", nil, false, nil, 0},
+		{"enrolled key and PIN", "2468", []string{"2468"}, false, nil, 0},
+		{"wrong PIN", "2468", []string{"1357"}, false, errBadPassword, 1},
+		{"other security key", "", nil, true, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHome(t)
+			npub := addTestAccount(t, "")
+			_, enrollment := enrollTestKey(t, npub, tt.pin)
+			if tt.other {
+				attachAuthenticator(t, newFakeAuthenticator())
+			}
+
+			nsec, privateKey, err := fidoUnlockKey(&scriptedPrompter{answers: tt.tapPIN}, npub, enrollment)
+			switch {
+			case tt.other:
+				// Fails at the key, before anything is decrypted
+				if err == nil || errors.Is(err, errBadPassword) {
+					t.Errorf("unlock with another security key = %v, want the key to fail", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("unlock = %v, want %v", err, tt.wantErr)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unlock: %v", err)
+				}
+				if !keyMatchesNpub(privateKey, npub) || !looksLikeNsec(nsec) {
+					t.Error("the security key unlocked another key")
+				}
+			}
+			if failures := passwordFailures(t, npub); failures != tt.failures {
+				t.Errorf("%d password failures, want %d", failures, tt.failures)
+			}
+		})
+	}
+}
+
+func TestFidoUnlockWithoutKey(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	_, enrollment := enrollTestKey(t, npub, "")
+	attachAuthenticator(t, nil)
+	if _, _, err := fidoUnlockKey(&scriptedPrompter{}, npub, enrollment); !errors.Is(err, errNoSecurityKey) {
+		t.Errorf("unlock without a security key = %v, want %v", err, errNoSecurityKey)
+	}
+	// The password is the fallback while it is on
+	if _, _, err := decryptAccountKey(mustLoadKey(t, npub), npub, testPassword); err != nil {
+		t.Errorf("password fallback: %v", err)
+	}
+}
+
+func mustLoadKey(t *testing.T, npub string) *EncryptedKey {
+	t.Helper()
+	encKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encKey
+}
+
+// Turning the password off leaves no file the password opens; turning it
+// back on encrypts keys.encrypted with a new password
+func TestFidoPasswordOffAndOn(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	_, enrollment := enrollTestKey(t, npub, "")
+	store, _ := accountStore()
+	fidoFile, _, _ := store.ReadRecord(npub, recordFidoKey)
+
+	if err := fidoPasswordCmd(&scriptedPrompter{}, npub, true); err != nil {
+		t.Fatalf("fido password off: %v", err)
+	}
+	if enrollment, _ = loadFidoEnrollment(npub); !enrollment.PasswordDisabled {
+		t.Error("password_disabled is not set")
+	}
+	if _, _, err := store.ReadRecord(npub, recordFidoKey); !os.IsNotExist(err) {
+		t.Errorf("keys.fido is left after turning the password off: %v", err)
+	}
+	if keyFile, _, _ := store.ReadRecord(npub, recordKey); !bytes.Equal(keyFile, fidoFile) {
+		t.Error("keys.encrypted is not the security key's file")
+	}
+	if actual, err := checksumAccountKeyFile(npub); err != nil || actual != keyChecksum(fidoFile) {
+		t.Errorf("keys.sha256 doesn't match the new keys.encrypted: %v", err)
+	}
+	// The password is refused, and wouldn't open the file anyway
+	if _, err := decryptNsec(mustLoadKey(t, npub), testPassword); !errors.Is(err, errKeyDecryptFailed) {
+		t.Errorf("the old password on keys.encrypted = %v, want %v", err, errKeyDecryptFailed)
+	}
+	if _, _, err := decryptAccountKey(mustLoadKey(t, npub), npub, testPassword); !errors.Is(err, errPasswordDisabled) {
+		t.Errorf("password unlock = %v, want %v", err, errPasswordDisabled)
+	}
+	if _, privateKey, err := fidoUnlockKey(&scriptedPrompter{}, npub, enrollment); err != nil || !keyMatchesNpub(privateKey, npub) {
+		t.Fatalf("unlock with the security key after password off: %v", err)
+	}
+	if err := fidoRemoveCmd(&scriptedPrompter{answers: []string{testPassword}}, npub); exitCode(err) != 1 {
+		t.Errorf("fido remove with the password off = %v, want exit code 1", err)
+	}
+
+	// Back on, with a new password
+	const newPassword = "Another9Password!"
+	if err := fidoPasswordCmd(&scriptedPrompter{answers: []string{newPassword, "mismatch"}}, npub, false); exitCode(err) != 1 {
+		t.Errorf("password on with mismatched passwords = %v, want exit code 1", err)
+	}
+	if err := fidoPasswordCmd(&scriptedPrompter{answers: []string{newPassword, newPassword}}, npub, false); err != nil {
+		t.Fatalf("fido password on: %v", err)
+	}
+	if enrollment, _ = loadFidoEnrollment(npub); enrollment.PasswordDisabled {
+		t.Error("password_disabled is still set")
+	}
+	if _, _, err := decryptAccountKey(mustLoadKey(t, npub), npub, newPassword); err != nil {
+		t.Errorf("unlock with the new password: %v", err)
+	}
+	if keyFile, _, _ := store.ReadRecord(npub, recordFidoKey); !bytes.Equal(keyFile, fidoFile) {
+		t.Error("keys.fido is not the security key's file again")
+	}
+	if _, _, err := fidoUnlockKey(&scriptedPrompter{}, npub, enrollment); err != nil {
+		t.Errorf("unlock with the security key after password on: %v", err)
+	}
+
+	// Removing it leaves the password alone
+	if err := fidoRemoveCmd(&scriptedPrompter{answers: []string{newPassword}}, npub); err != nil {
+		t.Fatalf("fido remove: %v", err)
+	}
+	for _, name := range []string{recordFido, recordFidoKey} {
+		if _, _, err := store.ReadRecord(npub, name); !os.IsNotExist(err) {
+			t.Errorf("%s is left after fido remove: %v", name, err)
+		}
+	}
+	if _, _, err := decryptAccountKey(mustLoadKey(t, npub), npub, newPassword); err != nil {
+		t.Errorf("unlock with the password after fido remove: %v", err)
+	}
+}
+
+// Turning the password off takes a tap on the enrolled key
+func TestFidoPasswordOffWrongKey(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	enrollTestKey(t, npub, "")
+	attachAuthenticator(t, newFakeAuthenticator())
+	if err := fidoPasswordCmd(&scriptedPrompter{}, npub, true); exitCode(err) != 1 {
+		t.Fatalf("password off with another security key = %v, want exit code 1", err)
+	}
+	if _, _, err := decryptAccountKey(mustLoadKey(t, npub), npub, testPassword); err != nil {
+		t.Errorf("the password stopped working: %v", err)
+	}
+}
+
+func TestFidoKeyInput(t *testing.T) {
+	secret := bytes.Repeat([]byte{0xab}, 32)
+	if fidoKeyInput(secret, "") == fidoKeyInput(secret, "1") {
+		t.Error("the PIN doesn't change the key input")
+	}
+	if got, want := fidoKeyInput(secret, "12"), fmt.Sprintf("fido:%x12", secret); got != want {
+		t.Errorf("fidoKeyInput = %q, want %q", got, want)
+	}
+}
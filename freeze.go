@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// codeFrozen rejects unlocking while a freeze is in effect
+const codeFrozen = "ERR_FROZEN"
+
+// errSignerFrozen is the class of every refusal caused by a freeze
+var errSignerFrozen = errors.New("signer is frozen")
+
+// FreezeState is ~/.noorsigner/freeze.json. Only a scrypt hash of the
+// unfreeze passphrase is stored.
+type FreezeState struct {
+	Until          int64  `json:"until"`
+	CreatedAt      int64  `json:"created_at"`
+	Salt           string `json:"salt"`
+	PassphraseHash string `json:"passphrase_hash"`
+}
+
+// getFreezePath returns the path of freeze.json
+func getFreezePath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storageDir, "freeze.json"), nil
+}
+
+// loadFreezeState reads freeze.json (nil if there is none)
+func loadFreezeState() (*FreezeState, error) {
+	path, err := getFreezePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read freeze.json: %v", err)
+	}
+
+	var state FreezeState
+	if err := json.Unmarshal(data, &state); err != nil || state.Until == 0 {
+		return nil, fmt.Errorf("freeze.json is damaged")
+	}
+	return &state, nil
+}
+
+// untilTime returns the end of the freeze
+func (s *FreezeState) untilTime() time.Time {
+	return time.Unix(s.Until, 0)
+}
+
+// active reports whether the freeze is still in effect at now
+func (s *FreezeState) active(now time.Time) bool {
+	return s != nil && now.Before(s.untilTime())
+}
+
+// freezeStatus reports whether a freeze is in effect and until when. An
+// unreadable freeze.json counts as frozen with until 0, since it refuses
+// unlocking all the same.
+func freezeStatus() (bool, int64) {
+	state, err := loadFreezeState()
+	if err != nil {
+		return true, 0
+	}
+	if !state.active(time.Now()) {
+		return false, 0
+	}
+	return true, state.Until
+}
+
+// checkNotFrozen refuses while a freeze is in effect. A freeze.json that
+// can't be read refuses too: failing open would defeat the freeze.
+func checkNotFrozen() error {
+	state, err := loadFreezeState()
+	if err != nil {
+		return fmt.Errorf("%w: %v - remove it by hand if you are sure no freeze was set", errSignerFrozen, err)
+	}
+	if !state.active(time.Now()) {
+		return nil
+	}
+	return fmt.Errorf("%w until %s - run 'noorsigner unfreeze' with the unfreeze passphrase to lift it early",
+		errSignerFrozen, state.untilTime().Format("2006-01-02 15:04 MST"))
+}
+
+// printFreezeLine prints the freeze for status
+func printFreezeLine(until int64) {
+	if until == 0 {
+		fmt.Println("   Frozen:     freeze.json is unreadable - every unlock is refused")
+		return
+	}
+	fmt.Printf("   Frozen:     until %s - every unlock is refused\n", time.Unix(until, 0).Format("2006-01-02 15:04 MST"))
+}
+
+// refuseIfFrozen fails a CLI command that would unlock a key, before it
+// asks for a password
+func refuseIfFrozen() error {
+	if err := checkNotFrozen(); err != nil {
+		return commandFailed(1, "❄️  %v", err)
+	}
+	return nil
+}
+
+// hashFreezePassphrase derives the stored hash of an unfreeze passphrase
+func hashFreezePassphrase(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keyLen)
+}
+
+// newFreezeState returns a freeze lasting until until, liftable with passphrase
+func newFreezeState(until time.Time, passphrase string) (*FreezeState, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("cannot generate salt: %v", err)
+	}
+	hash, err := hashFreezePassphrase(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &FreezeState{
+		Until:          until.Unix(),
+		CreatedAt:      time.Now().Unix(),
+		Salt:           hex.EncodeToString(salt),
+		PassphraseHash: hex.EncodeToString(hash),
+	}, nil
+}
+
+// checkPassphrase reports whether passphrase lifts this freeze
+func (s *FreezeState) checkPassphrase(passphrase string) bool {
+	salt, err := hex.DecodeString(s.Salt)
+	if err != nil {
+		return false
+	}
+	expected, err := hex.DecodeString(s.PassphraseHash)
+	if err != nil {
+		return false
+	}
+	hash, err := hashFreezePassphrase(passphrase, salt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hash, expected) == 1
+}
+
+// saveFreezeState writes freeze.json (mode 0600)
+func saveFreezeState(state *FreezeState) error {
+	path, err := getFreezePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0600)
+}
+
+// removeFreezeState deletes freeze.json
+func removeFreezeState() error {
+	path, err := getFreezePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// parseFreezeUntil accepts a duration ("72h") or a point in time (see
+// parseLocalTime). The result must lie after now.
+func parseFreezeUntil(value string, now time.Time) (time.Time, error) {
+	var until time.Time
+	if duration, err := time.ParseDuration(value); err == nil {
+		until = now.Add(duration)
+	} else if t, ok := parseLocalTime(value); ok {
+		until = t
+	} else {
+		return time.Time{}, fmt.Errorf("invalid --until %q: use a date (2025-08-01), a date and time (\"2025-08-01 18:00\") or a duration (72h)", value)
+	}
+
+	if !until.After(now) {
+		return time.Time{}, fmt.Errorf("--until %s is not in the future", until.Format("2006-01-02 15:04 MST"))
+	}
+	return until, nil
+}
+
+// clearAllTrustSessions removes the trust session of every account, so no
+// restart can unlock without a password
+func clearAllTrustSessions() error {
+	accounts, err := listAccounts()
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		if err := clearAccountTrustSession(account.Npub); err != nil {
+			return fmt.Errorf("cannot remove trust session of %s: %v", displayNpub(account.Npub), err)
+		}
+	}
+	// Single-account installs from before multi-account support
+	if err := clearTrustSession(); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove legacy trust session: %v", err)
+	}
+	return nil
+}
+
+// freezeCmd freezes the signer until a point in time: the running daemon
+// is locked, every trust session is removed, and any attempt to unlock a
+// key is refused until then, unless the unfreeze passphrase is given
+func freezeCmd(prompt prompter, args []string) error {
+	usage := "Usage: noorsigner freeze --until <date|duration>"
+	if len(args) != 2 || args[0] != "--until" {
+		return commandFailed(1, "%s", usage)
+	}
+	until, err := parseFreezeUntil(args[1], time.Now())
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	if state, err := loadFreezeState(); err == nil && state.active(time.Now()) {
+		return commandFailed(1, "❌ Already frozen until %s - run 'noorsigner unfreeze' first to change it",
+			state.untilTime().Format("2006-01-02 15:04 MST"))
+	}
+
+	fmt.Printf("❄️  Freeze until %s\n", until.Format("2006-01-02 15:04 MST"))
+	fmt.Println("   Until then no account can be unlocked - not by the daemon, not by the CLI.")
+	fmt.Println("   Choose an unfreeze passphrase to lift the freeze early. Keep it apart from")
+	fmt.Println("   your account passwords; without it you wait until the freeze ends.")
+	fmt.Println()
+
+	var passphrase string
+	for {
+		passphrase, err = prompt.readPassword("Unfreeze passphrase: ")
+		if err != nil {
+			return commandFailed(1, "Error reading passphrase: %v", err)
+		}
+		if len(passphrase) < 8 {
+			fmt.Println("❌ Passphrase must be at least 8 characters! Please try again.")
+			continue
+		}
+		confirm, err := prompt.readPassword("Confirm passphrase: ")
+		if err != nil {
+			return commandFailed(1, "Error reading passphrase: %v", err)
+		}
+		if confirm != passphrase {
+			fmt.Println("❌ Passphrases do not match! Please try again.")
+			continue
+		}
+		break
+	}
+
+	state, err := newFreezeState(until, passphrase)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	// The marker goes first: from here on no unlock succeeds, whatever fails below
+	if err := saveFreezeState(state); err != nil {
+		return commandFailed(1, "❌ Error saving freeze.json: %v", err)
+	}
+
+	if isDaemonRunning() {
+		if err := lockViaDaemon(string(lockReasonFreeze)); err != nil {
+			fmt.Printf("⚠️  Could not lock the daemon: %v\n", err)
+			fmt.Println("   Stop it by hand: pkill noorsigner")
+		} else {
+			fmt.Println("🔒 Daemon locked")
+		}
+	}
+	if err := clearAllTrustSessions(); err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+	} else {
+		fmt.Println("🗑️  Trust sessions removed")
+	}
+	auditCLI("freeze", "", nil, nil)
+
+	fmt.Println()
+	fmt.Printf("✅ Signer frozen until %s\n", until.Format("2006-01-02 15:04 MST"))
+	return nil
+}
+
+// unfreezeCmd lifts a freeze early with the unfreeze passphrase
+func unfreezeCmd(prompt prompter, args []string) error {
+	if len(args) != 0 {
+		return commandFailed(1, "Usage: noorsigner unfreeze")
+	}
+
+	state, err := loadFreezeState()
+	if err != nil {
+		return commandFailed(1, "❌ %v - remove it by hand if you are sure no freeze was set", err)
+	}
+	if !state.active(time.Now()) {
+		// An expired marker has no effect; tidy it up
+		removeFreezeState()
+		fmt.Println("Not frozen.")
+		return nil
+	}
+
+	fmt.Printf("❄️  Frozen until %s\n", state.untilTime().Format("2006-01-02 15:04 MST"))
+	passphrase, err := prompt.readPassword("Unfreeze passphrase: ")
+	if err != nil {
+		return commandFailed(1, "Error reading passphrase: %v", err)
+	}
+	if !state.checkPassphrase(passphrase) {
+		auditCLI("unfreeze", "", errors.New("wrong unfreeze passphrase"), nil)
+		return commandFailed(1, "❌ Wrong unfreeze passphrase")
+	}
+
+	if err := removeFreezeState(); err != nil {
+		return commandFailed(1, "❌ Error removing freeze.json: %v", err)
+	}
+	auditCLI("unfreeze", "", nil, nil)
+	fmt.Println("✅ Freeze lifted. Unlock as usual, e.g. 'noorsigner daemon'.")
+	return nil
+}
+
+// lockDaemon answers lock: the key leaves memory and the active account's
+// trust session is removed. Unless a freeze is in effect, a credential
+// request is queued so an operator can unlock again (see pending.go).
+// lock says why; it is recorded if the daemon was unlocked (see locklog.go).
+func (d *Daemon) lockDaemon(lock LockEvent) {
+	d.idle.stop()
+	d.expiry.stop()
+	d.mu.Lock()
+	npub, pubkey := d.npub, d.pubkey
+	wasUnlocked := d.privateKey != nil
+	d.clearKeyLocked()
+	d.mu.Unlock()
+
+	if npub != "" {
+		if err := clearAccountTrustSession(npub); err != nil {
+			logError("⚠️  Cannot remove trust session of %s: %v", displayNpub(npub), err)
+		}
+	}
+	if !wasUnlocked {
+		return
+	}
+
+	d.recordLock(&lock, npub)
+	d.emit(StreamEvent{Type: "locked", Npub: npub, Pubkey: pubkey})
+	if err := checkNotFrozen(); err != nil {
+		logInfo("❄️  %v", err)
+		return
+	}
+	if npub != "" {
+		d.requestCredential(npub, lock.describe())
+	}
+}
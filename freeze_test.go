@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+const testPassphrase = "unfreeze-me-later"
+
+// freezeTestAccount saves a trust session for npub, as the daemon does on
+// unlock, and returns the account's encrypted key
+func freezeTestAccount(t *testing.T, npub string) *EncryptedKey {
+	t.Helper()
+	appConfig.TrustTokenStore = trustTokenFile
+	encKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nsec, err := decryptNsec(encKey, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := createTrustSession(nsec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveAccountTrustSession(npub, session); err != nil {
+		t.Fatal(err)
+	}
+	return encKey
+}
+
+// A freeze removes every trust session and refuses every unlock until it
+// is lifted with the passphrase
+func TestFreeze(t *testing.T) {
+	testHome(t)
+	other := addTestAccount(t, "")
+	npub := addTestAccount(t, "")
+	encKey := freezeTestAccount(t, npub)
+	freezeTestAccount(t, other)
+	d := testDaemon(t, npub)
+
+	// Too short, then not confirmed, then accepted
+	prompt := &scriptedPrompter{answers: []string{"short", testPassphrase, "other", testPassphrase, testPassphrase}}
+	if err := freezeCmd(prompt, []string{"--until", "72h"}); err != nil {
+		t.Fatalf("freeze: %v", err)
+	}
+	if len(prompt.answers) != 0 {
+		t.Errorf("%d answers left", len(prompt.answers))
+	}
+
+	if frozen, until := freezeStatus(); !frozen || time.Until(time.Unix(until, 0)) < 71*time.Hour {
+		t.Errorf("freezeStatus = %v, %d; want frozen for 72h", frozen, until)
+	}
+	for _, account := range []string{npub, other} {
+		if hasAccountTrustSession(account) {
+			t.Errorf("trust session of %s left after the freeze", account)
+		}
+	}
+	if _, err := decryptNsec(encKey, testPassword); !errors.Is(err, errSignerFrozen) {
+		t.Errorf("decryptNsec while frozen: %v, want %v", err, errSignerFrozen)
+	}
+	if err := d.unlockAccount(other, testPassword, false); !errors.Is(err, errSignerFrozen) {
+		t.Errorf("unlock_account while frozen: %v, want %v", err, errSignerFrozen)
+	}
+	if err := freezeCmd(&scriptedPrompter{}, []string{"--until", "1h"}); exitCode(err) != 1 {
+		t.Errorf("second freeze: %v, want refused", err)
+	}
+
+	if err := unfreezeCmd(&scriptedPrompter{answers: []string{"wrong passphrase"}}, nil); exitCode(err) != 1 {
+		t.Errorf("unfreeze with the wrong passphrase: %v", err)
+	}
+	if frozen, _ := freezeStatus(); !frozen {
+		t.Fatal("the wrong passphrase lifted the freeze")
+	}
+	if err := unfreezeCmd(&scriptedPrompter{answers: []string{testPassphrase}}, nil); err != nil {
+		t.Fatalf("unfreeze: %v", err)
+	}
+	if frozen, _ := freezeStatus(); frozen {
+		t.Error("still frozen after unfreeze")
+	}
+	if _, err := decryptNsec(encKey, testPassword); err != nil {
+		t.Errorf("decryptNsec after unfreeze: %v", err)
+	}
+}
+
+// A freeze ends by itself at its end; an unfreeze then only tidies up
+func TestFreezeExpiry(t *testing.T) {
+	testHome(t)
+	encKey := freezeTestAccount(t, addTestAccount(t, ""))
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		until  time.Time
+		frozen bool
+	}{
+		{"ends in a day", now.Add(24 * time.Hour), true},
+		{"ends in a minute", now.Add(time.Minute), true},
+		{"ended a second ago", now.Add(-time.Second), false},
+		{"ended a day ago", now.Add(-24 * time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := newFreezeState(tt.until, testPassphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := saveFreezeState(state); err != nil {
+				t.Fatal(err)
+			}
+			defer removeFreezeState()
+
+			if frozen, _ := freezeStatus(); frozen != tt.frozen {
+				t.Errorf("freezeStatus = %v, want %v", frozen, tt.frozen)
+			}
+			if _, err := decryptNsec(encKey, testPassword); errors.Is(err, errSignerFrozen) != tt.frozen {
+				t.Errorf("decryptNsec: %v, frozen %v", err, tt.frozen)
+			}
+			if tt.frozen {
+				return
+			}
+			// No passphrase asked: the marker is just removed
+			if err := unfreezeCmd(&scriptedPrompter{}, nil); err != nil {
+				t.Fatalf("unfreeze after the end: %v", err)
+			}
+			if state, err := loadFreezeState(); err != nil || state != nil {
+				t.Error("expired freeze.json left after unfreeze")
+			}
+		})
+	}
+}
+
+// A damaged freeze.json refuses unlocking rather than failing open
+func TestFreezeDamaged(t *testing.T) {
+	testHome(t)
+	encKey := freezeTestAccount(t, addTestAccount(t, ""))
+	path, err := getFreezePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if frozen, until := freezeStatus(); !frozen || until != 0 {
+		t.Errorf("freezeStatus = %v, %d; want frozen with until 0", frozen, until)
+	}
+	if _, err := decryptNsec(encKey, testPassword); !errors.Is(err, errSignerFrozen) {
+		t.Errorf("decryptNsec with a damaged freeze.json: %v, want %v", err, errSignerFrozen)
+	}
+}
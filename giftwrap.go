@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/77elements/noorsigner/pkg/client"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+const (
+	// NIP-59 event kinds
+	sealKind     = 13
+	giftWrapKind = 1059
+
+	// giftWrapTimeSkew is how far into the past seals and wraps are dated,
+	// at random, so their created_at doesn't tell when the message was sent
+	giftWrapTimeSkew = 2 * 24 * time.Hour
+
+	// maxNip44Plaintext is the longest plaintext NIP-44 encrypts, in bytes
+	maxNip44Plaintext = 65535
+
+	codeInvalidGiftWrap = "ERR_INVALID_GIFT_WRAP"
+	codeNotRecipient    = "ERR_NOT_RECIPIENT"
+)
+
+// Rumor is an unsigned event inside a gift wrap
+type Rumor = client.Rumor
+
+// GiftWrapResponse answers gift_wrap and unwrap
+type GiftWrapResponse = client.GiftWrapResponse
+
+// giftWrapError reports a gift wrap that can't be opened or trusted
+func giftWrapError(format string, args ...interface{}) error {
+	return &eventError{Code: codeInvalidGiftWrap, Message: fmt.Sprintf(format, args...)}
+}
+
+// randomPastTime returns a time up to giftWrapTimeSkew before now
+func randomPastTime(now time.Time) int64 {
+	skew, err := rand.Int(rand.Reader, big.NewInt(int64(giftWrapTimeSkew/time.Second)))
+	if err != nil {
+		return now.Unix()
+	}
+	return now.Unix() - skew.Int64()
+}
+
+// checkNip44Plaintext refuses what is too long for one NIP-44 payload.
+// what names the layer for the error.
+func checkNip44Plaintext(what string, plaintext string) error {
+	if len(plaintext) > maxNip44Plaintext {
+		return &eventError{
+			Code:    codeEventTooLarge,
+			Message: fmt.Sprintf("%s is %d bytes once encrypted for the next layer; NIP-44 encrypts at most %d", what, len(plaintext), maxNip44Plaintext),
+		}
+	}
+	return nil
+}
+
+// newRumor completes an unsigned event as the account's rumor: pubkey and
+// created_at filled in if missing, the event limits checked and the id set.
+// A sig, if any, is dropped.
+func newRumor(eventJSON string, limits eventLimits, pubkey string) (*Rumor, error) {
+	eventJSON = normalizeEvent(eventJSON, pubkey)
+	if err := checkEventPubkey(eventJSON, pubkey); err != nil {
+		return nil, err
+	}
+	if diagnostics := validateEvent(eventJSON, limits); !diagnostics.Valid {
+		return nil, diagnostics.err()
+	}
+	eventHash, err := createEventHash(eventJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash event: %v", err)
+	}
+
+	var rumor Rumor
+	if err := json.Unmarshal([]byte(eventJSON), &rumor); err != nil {
+		return nil, err
+	}
+	rumor.ID = encodeHex(eventHash)
+	if rumor.Tags == nil {
+		rumor.Tags = [][]string{}
+	}
+	return &rumor, nil
+}
+
+// signWithKey completes an event with privateKey's signature; pubkey must
+// be privateKey's
+func signWithKey(privateKey *btcec.PrivateKey, pubkey string, kind int, createdAt int64, tags [][]string, content string) (*NostrEvent, error) {
+	eventJSON, err := json.Marshal(map[string]interface{}{
+		"pubkey":     pubkey,
+		"kind":       kind,
+		"created_at": createdAt,
+		"tags":       tags,
+		"content":    content,
+	})
+	if err != nil {
+		return nil, err
+	}
+	eventHash, err := createEventHash(string(eventJSON))
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signNostrEvent(privateKey, eventHash)
+	if err != nil {
+		return nil, err
+	}
+	return completeEvent(string(eventJSON), eventHash, signature)
+}
+
+// giftWrap seals rumor for recipient (hex) with the account's key and wraps
+// the seal with a fresh ephemeral key (NIP-59). The ephemeral key is zeroed
+// before returning. The wrap must stay within limits like any signed event.
+func giftWrap(key *accountKey, rumor *Rumor, recipient string, limits eventLimits) (*NostrEvent, error) {
+	rumorJSON, err := json.Marshal(rumor)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNip44Plaintext("the rumor", string(rumorJSON)); err != nil {
+		return nil, err
+	}
+	sealed, err := nip44EncryptCached(key, string(rumorJSON), recipient)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	seal, err := signWithKey(key.privateKey, key.pubkey, sealKind, randomPastTime(now), [][]string{}, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	sealJSON, err := json.Marshal(seal)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNip44Plaintext("the seal", string(sealJSON)); err != nil {
+		return nil, err
+	}
+	ephemeral, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create an ephemeral key: %v", err)
+	}
+	defer ephemeral.Zero()
+	wrapped, err := nip44Encrypt(string(sealJSON), recipient, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPubkey := encodeHex(schnorr.SerializePubKey(ephemeral.PubKey()))
+	wrap, err := signWithKey(ephemeral, ephemeralPubkey, giftWrapKind, randomPastTime(now), [][]string{{"p", recipient}}, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapJSON, _ := json.Marshal(wrap)
+	if diagnostics := validateEvent(string(wrapJSON), limits); !diagnostics.Valid {
+		return nil, diagnostics.err()
+	}
+	return wrap, nil
+}
+
+// checkSignedEvent parses a signed event of kind and checks its id and
+// signature. what names it for errors.
+func checkSignedEvent(eventJSON string, kind int, what string) (*NostrEvent, error) {
+	var event NostrEvent
+	if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
+		return nil, giftWrapError("invalid %s JSON: %v", what, err)
+	}
+	if event.Kind != kind {
+		return nil, giftWrapError("%s has kind %d, expected %d", what, event.Kind, kind)
+	}
+	if pubkey, err := parsePubkey(event.Pubkey); err != nil || pubkey != event.Pubkey {
+		return nil, giftWrapError("%s pubkey must be a hex pubkey on the curve", what)
+	}
+	nostrEvent := toNostrEvent(&event)
+	if !nostrEvent.CheckID() {
+		return nil, giftWrapError("%s id does not match its content", what)
+	}
+	if ok, _ := nostrEvent.CheckSignature(); !ok {
+		return nil, giftWrapError("%s signature is not valid", what)
+	}
+	return &event, nil
+}
+
+// unwrapGift opens a gift wrap addressed to the account: it decrypts the
+// wrap and the seal inside, checks both signatures and that the rumor's
+// author is the seal's signer, and returns the rumor and that author
+func unwrapGift(key *accountKey, wrapJSON string) (*Rumor, string, error) {
+	wrap, err := checkSignedEvent(wrapJSON, giftWrapKind, "gift wrap")
+	if err != nil {
+		return nil, "", err
+	}
+	addressed := false
+	for _, tag := range wrap.Tags {
+		addressed = addressed || (len(tag) >= 2 && tag[0] == "p" && tag[1] == key.pubkey)
+	}
+	if !addressed {
+		return nil, "", &eventError{Code: codeNotRecipient, Message: "gift wrap is not addressed to this account"}
+	}
+
+	// The wrap's key is used once, so its conversation key isn't cached
+	sealJSON, err := nip44Decrypt(wrap.Content, wrap.Pubkey, key.privateKey)
+	if err != nil {
+		return nil, "", giftWrapError("cannot decrypt the gift wrap: %v", err)
+	}
+	seal, err := checkSignedEvent(sealJSON, sealKind, "seal")
+	if err != nil {
+		return nil, "", err
+	}
+	rumorJSON, err := nip44DecryptCached(key, seal.Content, seal.Pubkey)
+	if err != nil {
+		return nil, "", giftWrapError("cannot decrypt the seal: %v", err)
+	}
+
+	var rumor Rumor
+	if err := json.Unmarshal([]byte(rumorJSON), &rumor); err != nil {
+		return nil, "", giftWrapError("invalid rumor JSON: %v", err)
+	}
+	if rumor.Pubkey != seal.Pubkey {
+		return nil, "", giftWrapError("rumor's author is not the seal's signer: the sender is forged")
+	}
+	rumorEvent := toNostrEvent(&NostrEvent{Pubkey: rumor.Pubkey, CreatedAt: rumor.CreatedAt, Kind: rumor.Kind, Tags: rumor.Tags, Content: rumor.Content})
+	if id := rumorEvent.GetID(); rumor.ID != id {
+		if rumor.ID != "" {
+			return nil, "", giftWrapError("rumor id does not match its content")
+		}
+		rumor.ID = id
+	}
+	return &rumor, seal.Pubkey, nil
+}
+
+// giftWrapRequest handles gift_wrap: the rumor in event_json is sealed
+// with the account's key for recipient_pubkey and wrapped
+func (d *Daemon) giftWrapRequest(req SignRequest) GiftWrapResponse {
+	if req.EventJSON == "" || req.RecipientPubkey == "" {
+		return GiftWrapResponse{ID: req.ID, Error: "event_json and recipient_pubkey required", Code: codeInvalidRequest}
+	}
+	recipient, err := parsePubkey(req.RecipientPubkey)
+	if err != nil {
+		return GiftWrapResponse{ID: req.ID, Error: "invalid recipient_pubkey: " + err.Error(), Code: codeInvalidRequest}
+	}
+
+	var wrap *NostrEvent
+	var rumor *Rumor
+	err = d.withAccountKey(req, func(key *accountKey) (err error) {
+		if rumor, err = newRumor(req.EventJSON, d.config.eventLimits(), key.pubkey); err != nil {
+			return err
+		}
+		wrap, err = giftWrap(key, rumor, recipient, d.config.eventLimits())
+		return err
+	})
+	if err != nil {
+		return GiftWrapResponse{ID: req.ID, Error: redactedError(err), Code: errorCode(err)}
+	}
+	d.countUse(req, AccountCounters{EventsSigned: 1, Nip44Encrypts: 1})
+	return GiftWrapResponse{ID: req.ID, EventID: wrap.ID, Event: wrap, Rumor: rumor}
+}
+
+// unwrapRequest handles unwrap: the gift wrap in event_json is opened with
+// the account's key
+func (d *Daemon) unwrapRequest(req SignRequest) GiftWrapResponse {
+	if req.EventJSON == "" {
+		return GiftWrapResponse{ID: req.ID, Error: "event_json required", Code: codeInvalidRequest}
+	}
+
+	var rumor *Rumor
+	var sender string
+	err := d.withAccountKey(req, func(key *accountKey) (err error) {
+		rumor, sender, err = unwrapGift(key, req.EventJSON)
+		return err
+	})
+	if err != nil {
+		return GiftWrapResponse{ID: req.ID, Error: redactedError(err), Code: errorCode(err)}
+	}
+	d.countUse(req, AccountCounters{Nip44Decrypts: 2})
+	return GiftWrapResponse{ID: req.ID, Rumor: rumor, Sender: sender}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nip46Methods are the NIP-46 methods a grant can permit. connect, ping and
+// get_public_key are always allowed: clients need them to work at all.
+var nip46Methods = map[string]bool{
+	"sign_event":    true,
+	"nip04_encrypt": true,
+	"nip04_decrypt": true,
+	"nip44_encrypt": true,
+	"nip44_decrypt": true,
+}
+
+// hexPubkeyPattern matches a lowercase hex public key
+var hexPubkeyPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Grant is one entry of grants.json: a NIP-46 client accepted with
+// 'noorsigner connect'. The daemon serves it for Npub over Relays.
+type Grant struct {
+	Name         string   `json:"name"`
+	ClientPubkey string   `json:"client_pubkey"`
+	Npub         string   `json:"npub"`
+	Relays       []string `json:"relays"`
+	// Perms as requested in the URI, e.g. "sign_event:1"; empty permits
+	// only the methods every client gets (see permits)
+	Perms     []string `json:"perms,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+// grantsFile is the layout of ~/.noorsigner/grants.json
+type grantsFile struct {
+	Grants []Grant `json:"grants"`
+}
+
+// getGrantsPath returns the path of grants.json
+func getGrantsPath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storageDir, "grants.json"), nil
+}
+
+// loadGrants reads grants.json (a missing file means no grants)
+func loadGrants() ([]Grant, error) {
+	path, err := getGrantsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read grants.json: %v", err)
+	}
+
+	var file grantsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid grants.json: %v", err)
+	}
+	return file.Grants, nil
+}
+
+// saveGrants writes grants.json (mode 0600)
+func saveGrants(grants []Grant) error {
+	path, err := getGrantsPath()
+	if err != nil {
+		return err
+	}
+	if grants == nil {
+		grants = []Grant{}
+	}
+
+	data, err := json.MarshalIndent(grantsFile{Grants: grants}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0600)
+}
+
+// findGrant returns the grant for a client pubkey
+func findGrant(grants []Grant, clientPubkey string) (Grant, bool) {
+	for _, grant := range grants {
+		if grant.ClientPubkey == clientPubkey {
+			return grant, true
+		}
+	}
+	return Grant{}, false
+}
+
+// storeGrant adds grant to grants.json, replacing an earlier grant for the
+// same client. A name taken by another client gets the client's pubkey
+// prefix appended. It returns the grant as stored.
+func storeGrant(grant Grant) (Grant, error) {
+	grants, err := loadGrants()
+	if err != nil {
+		return grant, err
+	}
+
+	kept := grants[:0]
+	for _, existing := range grants {
+		if existing.ClientPubkey != grant.ClientPubkey {
+			kept = append(kept, existing)
+		}
+	}
+	for _, existing := range kept {
+		if existing.Name == grant.Name {
+			grant.Name += "-" + grant.ClientPubkey[:6]
+			break
+		}
+	}
+
+	if err := saveGrants(append(kept, grant)); err != nil {
+		return grant, err
+	}
+	return grant, nil
+}
+
+// NostrConnectURI is a parsed nostrconnect:// URI (NIP-46): a client asking
+// a signer to connect to it
+type NostrConnectURI struct {
+	ClientPubkey string
+	Relays       []string
+	Secret       string
+	Perms        []string
+	Name         string
+	URL          string
+}
+
+// parseNostrConnectURI parses and checks a nostrconnect:// URI
+func parseNostrConnectURI(uri string) (*NostrConnectURI, error) {
+	parsed, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URI: %v", err)
+	}
+	if parsed.Scheme != "nostrconnect" {
+		return nil, fmt.Errorf("not a nostrconnect:// URI")
+	}
+	if !hexPubkeyPattern.MatchString(parsed.Host) {
+		return nil, fmt.Errorf("invalid client pubkey %q: expected 64 lowercase hex characters", parsed.Host)
+	}
+
+	query := parsed.Query()
+	connect := &NostrConnectURI{
+		ClientPubkey: parsed.Host,
+		Secret:       query.Get("secret"),
+		Name:         cleanAppField(query.Get("name")),
+		URL:          cleanAppField(query.Get("url")),
+	}
+	if connect.Secret == "" {
+		return nil, fmt.Errorf("the URI has no secret - the client could not tell our answer from a forged one")
+	}
+
+	seen := make(map[string]bool)
+	for _, relay := range query["relay"] {
+		relayURL, err := url.Parse(relay)
+		if err != nil || (relayURL.Scheme != "wss" && relayURL.Scheme != "ws") || relayURL.Host == "" {
+			return nil, fmt.Errorf("invalid relay %q: expected a ws:// or wss:// URL", relay)
+		}
+		if !seen[relay] {
+			seen[relay] = true
+			connect.Relays = append(connect.Relays, relay)
+		}
+	}
+	if len(connect.Relays) == 0 {
+		return nil, fmt.Errorf("the URI lists no relay")
+	}
+
+	for _, perm := range strings.Split(query.Get("perms"), ",") {
+		if perm = strings.TrimSpace(perm); perm != "" {
+			connect.Perms = append(connect.Perms, perm)
+		}
+	}
+	return connect, nil
+}
+
+// grantName derives a grant name from the app's name: the characters
+// client names allow, or the client pubkey prefix if none are left
+func (c *NostrConnectURI) grantName() string {
+	name := strings.Map(func(r rune) rune {
+		if r < 128 && clientNamePattern.MatchString(string(r)) {
+			return r
+		}
+		if r == ' ' {
+			return '-'
+		}
+		return -1
+	}, c.Name)
+	name = strings.Trim(name, "-")
+	if name == "" {
+		return "nip46-" + c.ClientPubkey[:8]
+	}
+	return name
+}
+
+// describePerm words a NIP-46 permission for the confirmation prompt
+func describePerm(perm string) string {
+	method, param, _ := strings.Cut(perm, ":")
+	if !nip46Methods[method] {
+		return perm + " (not supported - ignored)"
+	}
+	switch method {
+	case "sign_event":
+		if param == "" {
+			return "sign events of any kind"
+		}
+		if kind, err := strconv.Atoi(param); err == nil {
+			return "sign " + describeKind(kind)
+		}
+		return perm + " (invalid kind - ignored)"
+	case "nip04_encrypt", "nip44_encrypt":
+		return "encrypt messages (" + strings.ToUpper(method[:3]) + "-" + method[3:5] + ")"
+	default:
+		return "decrypt messages (" + strings.ToUpper(method[:3]) + "-" + method[3:5] + ")"
+	}
+}
+
+// permits reports whether the grant allows method. kind is the event kind
+// for sign_event.
+func (g Grant) permits(method string, kind *int) bool {
+	switch method {
+	case "connect", "ping", "get_public_key", "get_relays":
+		return true
+	}
+	if !nip46Methods[method] {
+		return false
+	}
+
+	for _, perm := range g.Perms {
+		permMethod, param, _ := strings.Cut(perm, ":")
+		if permMethod != method {
+			continue
+		}
+		if method != "sign_event" || param == "" {
+			return true
+		}
+		if permKind, err := strconv.Atoi(param); err == nil && kind != nil && *kind == permKind {
+			return true
+		}
+	}
+	return false
+}
+
+// grantCmd lists or revokes NIP-46 grants. grants.json is read for every
+// request, so a revoked client is refused and disconnected without a
+// restart.
+func grantCmd(args []string) error {
+	usage := "Usage: noorsigner grant list|revoke <name>"
+	if len(args) == 0 {
+		return commandFailed(1, "%s", usage)
+	}
+
+	grants, err := loadGrants()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		if len(grants) == 0 {
+			fmt.Println("No NIP-46 grants. Use 'noorsigner connect <nostrconnect-uri>' to add one.")
+			return nil
+		}
+		fmt.Printf("NIP-46 grants (%d):\n", len(grants))
+		for _, grant := range grants {
+			fmt.Printf("  %-24s %s, created %s\n", grant.Name, displayNpub(grant.Npub), time.Unix(grant.CreatedAt, 0).Format("2006-01-02 15:04"))
+			fmt.Printf("    client:  %s\n", grant.ClientPubkey)
+			if grant.URL != "" {
+				fmt.Printf("    url:     %s\n", grant.URL)
+			}
+			fmt.Printf("    relays:  %s\n", strings.Join(grant.Relays, ", "))
+			if len(grant.Perms) == 0 {
+				fmt.Println("    perms:   none (public key and relay list only)")
+			} else {
+				fmt.Printf("    perms:   %s\n", strings.Join(grant.Perms, ", "))
+			}
+		}
+
+	case args[0] == "revoke" && len(args) == 2:
+		name := args[1]
+		var kept []Grant
+		var revokedGrant Grant
+		found := false
+		for _, grant := range grants {
+			if grant.Name == name || grant.ClientPubkey == name {
+				revokedGrant, found = grant, true
+				continue
+			}
+			kept = append(kept, grant)
+		}
+		if !found {
+			return commandFailed(1, "❌ No grant named %q", name)
+		}
+		if err := saveGrants(kept); err != nil {
+			auditCLI("grant_revoke", revokedGrant.Npub, err, nil)
+			return commandFailed(1, "❌ Error saving grants.json: %v", err)
+		}
+		auditCLI("grant_revoke", revokedGrant.Npub, nil, nil)
+		fmt.Printf("✅ Grant %q revoked. The daemon refuses its requests and disconnects it.\n", revokedGrant.Name)
+
+	default:
+		return commandFailed(1, "%s", usage)
+	}
+	return nil
+}
+
+// connectCmd accepts a nostrconnect:// URI after showing what the client
+// asks for. The daemon then serves the client for the active account.
+func connectCmd(args []string) error {
+	if len(args) != 1 {
+		return commandFailed(1, "Usage: noorsigner connect <nostrconnect-uri>")
+	}
+
+	connect, err := parseNostrConnectURI(args[0])
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if !isDaemonRunning() {
+		return commandFailed(1, "❌ Daemon not running - start it first with 'noorsigner daemon'")
+	}
+	npub, err := loadActiveAccount()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	fmt.Println("🔌 NIP-46 connection request")
+	if connect.Name != "" {
+		fmt.Printf("   App:     %s\n", connect.Name)
+	}
+	if connect.URL != "" {
+		fmt.Printf("   URL:     %s\n", connect.URL)
+	}
+	fmt.Printf("   Client:  %s\n", connect.ClientPubkey)
+	fmt.Printf("   Relays:  %s\n", strings.Join(connect.Relays, ", "))
+	fmt.Printf("   Account: %s\n", displayNpub(npub))
+	fmt.Println("   It may:")
+	fmt.Println("   - read your public key")
+	if len(connect.Perms) == 0 {
+		fmt.Println("   (it asked for nothing else - it can't sign, encrypt or decrypt)")
+	}
+	for _, perm := range connect.Perms {
+		fmt.Printf("   - %s\n", describePerm(perm))
+	}
+	fmt.Println("   Signing policy, approval_command and --ask still apply to each request.")
+	fmt.Println()
+
+	answer, err := readInput("Accept this client? [y/N]: ")
+	if err != nil || !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		fmt.Println("Cancelled.")
+		return nil
+	}
+
+	response, err := nip46ConnectViaDaemon(args[0])
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	fmt.Printf("✅ Connected %q via %s\n", response.Grant.Name, strings.Join(response.Connected, ", "))
+	fmt.Printf("   Revoke it with: noorsigner grant revoke %s\n", response.Grant.Name)
+	return nil
+}
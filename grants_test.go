@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestGrantPermits(t *testing.T) {
+	kind := func(k int) *int { return &k }
+	tests := []struct {
+		name   string
+		perms  []string
+		method string
+		kind   *int
+		want   bool
+	}{
+		{"no perms, public key", nil, "get_public_key", nil, true},
+		{"no perms, connect", nil, "connect", nil, true},
+		{"no perms, ping", nil, "ping", nil, true},
+		{"no perms, relays", nil, "get_relays", nil, true},
+		{"no perms, sign", nil, "sign_event", kind(1), false},
+		{"no perms, encrypt", nil, "nip44_encrypt", nil, false},
+		{"no perms, decrypt", nil, "nip04_decrypt", nil, false},
+		{"any kind", []string{"sign_event"}, "sign_event", kind(30023), true},
+		{"listed kind", []string{"sign_event:1", "sign_event:7"}, "sign_event", kind(7), true},
+		{"unlisted kind", []string{"sign_event:1"}, "sign_event", kind(4), false},
+		{"invalid kind perm", []string{"sign_event:x"}, "sign_event", kind(1), false},
+		{"listed method", []string{"nip44_encrypt"}, "nip44_encrypt", nil, true},
+		{"other method", []string{"nip44_encrypt"}, "nip44_decrypt", nil, false},
+		{"unsupported method", []string{"switch_relays"}, "switch_relays", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grant := Grant{Name: "app", Perms: tt.perms}
+			if got := grant.permits(tt.method, tt.kind); got != tt.want {
+				t.Errorf("permits(%s) with perms %v = %v, want %v", tt.method, tt.perms, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// healthCheckTick is how often the daemon looks at whether a check is due
+const healthCheckTick = time.Hour
+
+// errNoHealthSession means the account has no usable trust session, so there
+// is no credential to check its key material with
+var errNoHealthSession = errors.New("no active trust session")
+
+// KeyHealth is the persisted outcome of the last key integrity check.
+// A non-empty Warning stays until a later check passes.
+type KeyHealth struct {
+	LastCheck int64  `json:"last_check"`
+	OK        bool   `json:"ok"`
+	Warning   string `json:"warning,omitempty"`
+}
+
+// parseHealthCheckInterval parses "daily", "weekly" or a Go duration.
+// An empty value disables the check.
+func parseHealthCheckInterval(value string) (time.Duration, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "off", "never":
+		return 0, nil
+	case "daily":
+		return 24 * time.Hour, nil
+	case "weekly":
+		return 7 * 24 * time.Hour, nil
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid health_check_interval %q: %v", value, err)
+	}
+	if interval < healthCheckTick {
+		return 0, fmt.Errorf("health_check_interval must be at least %s", healthCheckTick)
+	}
+	return interval, nil
+}
+
+// keyChecksum returns the hex SHA-256 of the contents of keys.encrypted
+func keyChecksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return encodeHex(sum[:])
+}
+
+// checksumAccountKeyFile returns the checksum of an account's keys.encrypted
+func checksumAccountKeyFile(npub string) (string, error) {
+	store, err := accountStore()
+	if err != nil {
+		return "", err
+	}
+
+	content, _, err := store.ReadRecord(npub, recordKey)
+	if err != nil {
+		return "", fmt.Errorf("cannot read account key file: %v", err)
+	}
+
+	return keyChecksum(content), nil
+}
+
+// saveAccountKeyChecksum records the checksum of the current keys.encrypted
+func saveAccountKeyChecksum(npub string) error {
+	checksum, err := checksumAccountKeyFile(npub)
+	if err != nil {
+		return err
+	}
+
+	store, err := accountStore()
+	if err != nil {
+		return err
+	}
+	if err := store.WriteRecords(npub, storeRecord{Name: recordKeyChecksum, Data: []byte(checksum)}); err != nil {
+		return fmt.Errorf("cannot write key checksum file: %v", err)
+	}
+
+	return nil
+}
+
+// loadAccountKeyChecksum loads the stored checksum ("" if none was recorded yet)
+func loadAccountKeyChecksum(npub string) (string, error) {
+	store, err := accountStore()
+	if err != nil {
+		return "", err
+	}
+
+	content, _, err := store.ReadRecord(npub, recordKeyChecksum)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot read key checksum file: %v", err)
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// loadAccountHealth loads the last health check result (zero value if never checked)
+func loadAccountHealth(npub string) (*KeyHealth, error) {
+	health := &KeyHealth{}
+
+	store, err := accountStore()
+	if err != nil {
+		return health, err
+	}
+
+	content, _, err := store.ReadRecord(npub, recordHealth)
+	if os.IsNotExist(err) {
+		return health, nil
+	}
+	if err != nil {
+		return health, fmt.Errorf("cannot read health file: %v", err)
+	}
+
+	if err := json.Unmarshal(content, health); err != nil {
+		return &KeyHealth{}, fmt.Errorf("invalid health file: %v", err)
+	}
+
+	return health, nil
+}
+
+// saveAccountHealth persists a health check result
+func saveAccountHealth(npub string, health *KeyHealth) error {
+	store, err := accountStore()
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(health)
+	if err != nil {
+		return err
+	}
+
+	if err := store.WriteRecords(npub, storeRecord{Name: recordHealth, Data: content}); err != nil {
+		return fmt.Errorf("cannot write health file: %v", err)
+	}
+
+	return nil
+}
+
+// verifyAccountKeyHealth re-reads an account's key material from disk and
+// checks it still decodes to the expected npub. It needs a valid trust
+// session: without a cached credential nothing on disk can be decrypted.
+func verifyAccountKeyHealth(npub string) error {
+	session, err := loadAccountTrustSession(npub)
+	if err != nil || !isTrustSessionValid(session) {
+		return errNoHealthSession
+	}
+
+	nsec, err := decryptTrustSessionNsec(session)
+	if err != nil {
+		return fmt.Errorf("trust session does not decrypt: %v", err)
+	}
+
+	privateKey, err := nsecToPrivateKey(nsec)
+	if err != nil {
+		return fmt.Errorf("trust session holds an invalid key: %v", err)
+	}
+	defer privateKey.Zero()
+
+	derived, err := privateKeyToNpub(privateKey)
+	if err != nil {
+		return fmt.Errorf("trust session holds an invalid key: %v", err)
+	}
+	if derived != npub {
+		return fmt.Errorf("trust session key does not match account (got %s)", derived)
+	}
+
+	encKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		return fmt.Errorf("keys.encrypted unreadable: %v", err)
+	}
+	// The ciphertext is as long as the nsec it was made from, plus the GCM
+	// tag in the current format
+	if len(encKey.Salt) != saltLen || len(encKey.EncryptedNsec) != encKey.sealedLen(len(nsec)) {
+		return fmt.Errorf("keys.encrypted is truncated or corrupted")
+	}
+
+	expected, err := loadAccountKeyChecksum(npub)
+	if err != nil {
+		return err
+	}
+	if expected == "" {
+		// Accounts created before checksums existed: record a baseline now
+		return saveAccountKeyChecksum(npub)
+	}
+
+	actual, err := checksumAccountKeyFile(npub)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("keys.encrypted checksum mismatch - file has changed on disk")
+	}
+
+	return nil
+}
+
+// healthCheckLoop runs the periodic key integrity check for the unlocked account
+func (d *Daemon) healthCheckLoop(interval time.Duration) {
+	ticker := time.NewTicker(healthCheckTick)
+	defer ticker.Stop()
+
+	d.runHealthCheckIfDue(interval)
+	for range ticker.C {
+		d.runHealthCheckIfDue(interval)
+	}
+}
+
+// runHealthCheckIfDue checks the unlocked account if its last check is older than interval
+func (d *Daemon) runHealthCheckIfDue(interval time.Duration) {
+	d.mu.RLock()
+	npub := d.npub
+	pubkey := d.pubkey
+	unlocked := d.privateKey != nil
+	d.mu.RUnlock()
+
+	// Only accounts the daemon holds a session for can be checked
+	if !unlocked || npub == "" {
+		return
+	}
+
+	health, err := loadAccountHealth(npub)
+	if err != nil {
+		logError("⚠️  Key health: %v", err)
+	}
+	if time.Since(time.Unix(health.LastCheck, 0)) < interval {
+		return
+	}
+
+	err = verifyAccountKeyHealth(npub)
+	if err == errNoHealthSession {
+		return
+	}
+
+	health.LastCheck = time.Now().Unix()
+	if err != nil {
+		health.OK = false
+		health.Warning = err.Error()
+		logError("❌ Key health check failed for %s: %v", displayNpub(npub), err)
+		logError("   Restore keys.encrypted from backup while the daemon still holds the key")
+		d.emit(StreamEvent{
+			Type:   "key_health_failed",
+			Npub:   npub,
+			Pubkey: pubkey,
+			Data:   map[string]string{"error": err.Error()},
+		})
+	} else {
+		health.OK = true
+		health.Warning = ""
+		logInfo("✅ Key health check passed for %s", displayNpub(npub))
+	}
+
+	if err := saveAccountHealth(npub, health); err != nil {
+		logError("⚠️  Key health: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/77elements/noorsigner/pkg/client"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// mainHelperEnv makes TestMainHelper run the command line given to it
+const mainHelperEnv = "NOORSIGNER_TEST_MAIN"
+
+// TestMainHelper is not a test: run as a child process by noorsignerCmd,
+// it runs main with the arguments after "--"
+func TestMainHelper(t *testing.T) {
+	if os.Getenv(mainHelperEnv) == "" {
+		t.Skip("started by noorsignerCmd")
+	}
+	for i, arg := range os.Args {
+		if arg == "--" {
+			os.Args = append([]string{"noorsigner"}, os.Args[i+1:]...)
+			break
+		}
+	}
+	main()
+	os.Exit(0)
+}
+
+// noorsignerCmd returns noorsigner run with args on the storage directory
+// home, with a home directory of its own that it must not touch
+func noorsignerCmd(t *testing.T, home, userHome string, args ...string) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestMainHelper$", "--"}, args...)...)
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, passwordEnv+"=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		mainHelperEnv+"=1",
+		storageHomeEnv+"="+home,
+		"HOME="+userHome,
+		"USERPROFILE="+userHome,
+	)
+	return cmd
+}
+
+// add-account, the daemon and signing all work inside $NOORSIGNER_HOME
+// (or --home), and nothing is written to ~/.noorsigner
+func TestStorageHomeEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts the daemon as a separate process")
+	}
+	home := testHome(t) // This process dials the daemon's socket there
+	userHome := t.TempDir()
+	key, npub := testKey(t)
+	const password = "Correct-Horse-Battery-9"
+
+	add := noorsignerCmd(t, home, userHome, "add-account", "--label", "e2e")
+	add.Stdin = strings.NewReader(key + "\n" + password + "\n" + password + "\n")
+	output, err := add.CombinedOutput()
+	if err != nil || !strings.Contains(string(output), npub) {
+		t.Fatalf("add-account: %v\n%s", err, output)
+	}
+
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte(password+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	daemon := noorsignerCmd(t, home, userHome, "daemon", "--foreground", "--no-trust", "--password-file", passwordFile)
+	var daemonOutput logCapture
+	daemon.Stdout, daemon.Stderr = &daemonOutput, &daemonOutput
+	if err := daemon.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- daemon.Wait() }()
+	t.Cleanup(func() {
+		daemon.Process.Kill()
+		<-exited
+	})
+
+	signer := client.New(func(ctx context.Context) (net.Conn, error) { return dialConnection() })
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for {
+		pubkey, err := signer.GetPublicKey(ctx)
+		if err == nil {
+			if want, _ := npubToPubkey(npub); pubkey != want {
+				t.Fatalf("daemon signs as %s, want %s", pubkey, want)
+			}
+			break
+		}
+		select {
+		case err := <-exited:
+			t.Fatalf("daemon exited: %v\n%s", err, daemonOutput.String())
+		case <-ctx.Done():
+			t.Fatalf("daemon not ready: %v\n%s", err, daemonOutput.String())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	signed, err := signer.SignEvent(ctx, `{"kind":1,"content":"hello from NOORSIGNER_HOME","tags":[],"created_at":1700000000}`)
+	if err != nil {
+		t.Fatalf("sign_event: %v", err)
+	}
+	var event nostr.Event
+	data, _ := json.Marshal(signed)
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.GetID() != event.ID {
+		t.Errorf("id %s, go-nostr computes %s", event.ID, event.GetID())
+	}
+	if ok, err := event.CheckSignature(); !ok {
+		t.Errorf("invalid signature: %v", err)
+	}
+
+	if runtime.GOOS != "windows" {
+		daemon.Process.Signal(os.Interrupt)
+		select {
+		case err := <-exited:
+			exited <- err // For the cleanup
+			if err != nil {
+				t.Errorf("daemon shutdown: %v\n%s", err, daemonOutput.String())
+			}
+		case <-time.After(10 * time.Second):
+			t.Errorf("daemon did not shut down on SIGINT")
+		}
+	}
+
+	if !accountExists(npub) {
+		t.Errorf("account %s not stored in %s", npub, home)
+	}
+	if label := accountLabel(npub); label != "e2e" {
+		t.Errorf("label %q, want e2e", label)
+	}
+	// --home takes precedence over the variable
+	list := noorsignerCmd(t, t.TempDir(), userHome, "--home", home, "list-accounts")
+	if output, err := list.CombinedOutput(); err != nil || !strings.Contains(string(output), npub) {
+		t.Errorf("list-accounts with --home: %v\n%s", err, output)
+	}
+	if entries, err := os.ReadDir(userHome); err != nil || len(entries) != 0 {
+		t.Errorf("home directory written to: %v %v", entries, err)
+	}
+}
+
+// A failed migration of the old single-account format is reported on
+// stderr, so stdout stays a clean document
+func TestMigrationWarningOnStderr(t *testing.T) {
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, "keys.encrypted"), []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"list-accounts"}, {"--json", "list-accounts"}} {
+		cmd := noorsignerCmd(t, home, t.TempDir(), args...)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		cmd.Run()
+		if !strings.Contains(stderr.String(), "Migration warning:") {
+			t.Errorf("%v: stderr lacks the migration warning:\n%s", args, stderr.String())
+		}
+		if strings.Contains(stdout.String(), "Migrat") {
+			t.Errorf("%v: stdout reports on the migration:\n%s", args, stdout.String())
+		}
+		if args[0] == "--json" && !json.Valid(stdout.Bytes()) {
+			t.Errorf("%v: stdout is not JSON:\n%s", args, stdout.String())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// hookTimeout bounds how long a hook may run before it is killed
+const hookTimeout = 10 * time.Second
+
+// hookEvents are the lifecycle events that can trigger user hooks
+var hookEvents = map[string]bool{
+	"account_switched":  true,
+	"locked":            true,
+	"unlocked":          true,
+	"daemon_started":    true,
+	"daemon_stopping":   true,
+	"key_health_failed": true,
+}
+
+// runHook starts the hook configured for event, if any. The process is
+// started synchronously (so hooks fire even right before exit) but never
+// waited on by the caller.
+func (d *Daemon) runHook(event StreamEvent) {
+	if !hookEvents[event.Type] || d.config == nil {
+		return
+	}
+
+	hookPath := d.config.Hooks[event.Type]
+	if hookPath == "" {
+		return
+	}
+
+	// Hooks run arbitrary code - only honor them if nobody else could have configured them
+	configFile, err := getConfigFilePath()
+	if err != nil {
+		return
+	}
+	if err := checkFileOwnedByUser(configFile); err != nil {
+		logError("Hook %s disabled: %v", event.Type, err)
+		return
+	}
+	if err := checkFileOwnedByUser(hookPath); err != nil {
+		logError("Hook %s disabled: %v", event.Type, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	cmd := exec.CommandContext(ctx, hookPath)
+	cmd.Env = append(os.Environ(),
+		"NOORSIGNER_EVENT="+event.Type,
+		"NOORSIGNER_NPUB="+event.Npub,
+		"NOORSIGNER_PUBKEY="+event.Pubkey,
+		fmt.Sprintf("NOORSIGNER_TIMESTAMP=%d", event.Timestamp),
+		fmt.Sprintf("NOORSIGNER_DAEMON_PID=%d", os.Getpid()),
+	)
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		logError("Hook %s failed to start: %v", event.Type, err)
+		return
+	}
+
+	go func() {
+		defer cancel()
+		if err := cmd.Wait(); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				logError("Hook %s timed out after %s", event.Type, hookTimeout)
+				return
+			}
+			logError("Hook %s failed: %v", event.Type, err)
+		}
+	}()
+}
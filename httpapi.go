@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+const (
+	// httpTokenPrefix marks the bearer token of the HTTP API, so it is not
+	// mistaken for a client token (nst_)
+	httpTokenPrefix = "nsh_"
+
+	// httpTokenHashEnv hands the token's hash to the forked child; the
+	// token itself only ever reaches the terminal
+	httpTokenHashEnv = "NOORSIGNER_HTTP_TOKEN_HASH"
+
+	// httpShutdownTimeout bounds how long shutdown waits for HTTP requests
+	httpShutdownTimeout = 5 * time.Second
+)
+
+// httpMethodAliases are the REST-style paths for socket methods
+var httpMethodAliases = map[string]string{
+	"accounts": "list_accounts",
+	"status":   "get_status",
+}
+
+// socketOnlyMethods need a connection that stays open, which the HTTP API and
+// the native messaging host don't have
+var socketOnlyMethods = map[string]bool{
+	"subscribe": true,
+}
+
+// checkHTTPBind refuses addresses other clients on the network could reach,
+// unless --unsafe-bind says so
+func checkHTTPBind(addr string, unsafeBind bool) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" {
+		return fmt.Errorf("invalid --http address %q: use 127.0.0.1:PORT", addr)
+	}
+	if unsafeBind || host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("refusing to serve HTTP on %q: not a loopback address - anyone who can reach it could ask for signatures (pass --unsafe-bind to allow it anyway)", addr)
+}
+
+// generateHTTPToken returns a new random bearer token for the HTTP API
+func generateHTTPToken() (string, error) {
+	token, err := generateClientToken()
+	if err != nil {
+		return "", err
+	}
+	return httpTokenPrefix + strings.TrimPrefix(token, clientTokenPrefix), nil
+}
+
+// setupHTTPToken gives the daemon the hash of the HTTP API's bearer token.
+// The process that prints the daemon's startup messages generates the token
+// and returns it to be shown once; a forked child receives only the hash.
+func (d *Daemon) setupHTTPToken(forked bool) (string, error) {
+	if forked {
+		hash, err := hex.DecodeString(os.Getenv(httpTokenHashEnv))
+		os.Unsetenv(httpTokenHashEnv) // Not for approval_command and friends
+		if err != nil || len(hash) != sha256.Size {
+			return "", fmt.Errorf("no HTTP token from the parent process")
+		}
+		d.httpTokenHash = hash
+		return "", nil
+	}
+
+	token, err := generateHTTPToken()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(token))
+	d.httpTokenHash = sum[:]
+	return token, nil
+}
+
+// printHTTPToken shows the HTTP API's bearer token. This is the only time it
+// is shown: the daemon keeps just its hash.
+func printHTTPToken(addr, token string) {
+	if token == "" {
+		return
+	}
+	fmt.Println()
+	fmt.Printf("🌐 HTTP API: http://%s\n", addr)
+	fmt.Printf("   Bearer token (shown once): %s\n", token)
+}
+
+// httpAuthorized checks the request's bearer token against the stored hash.
+// Browsers can't set headers on a WebSocket, so an upgrade may pass it as
+// ?token= instead.
+func (d *Daemon) httpAuthorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && isWebSocketUpgrade(r) {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return subtle.ConstantTimeCompare(sum[:], d.httpTokenHash) == 1
+}
+
+// startHTTPListener serves the socket protocol's methods over HTTP on
+// --http's address
+func (d *Daemon) startHTTPListener() error {
+	if d.httpAddr == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", d.httpAddr)
+	if err != nil {
+		return err
+	}
+	d.httpServer = &http.Server{
+		Handler:           http.HandlerFunc(d.serveHTTP),
+		ReadHeaderTimeout: d.requestTimeout,
+		ReadTimeout:       d.requestTimeout,
+	}
+	logInfo("🌐 Also serving HTTP on: http://%s", listener.Addr())
+
+	go func() {
+		if err := d.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logError("HTTP server on %s stopped: %v", d.httpAddr, err)
+		}
+	}()
+	return nil
+}
+
+// stopHTTPListener lets HTTP requests in progress finish, briefly
+func (d *Daemon) stopHTTPListener() {
+	if d.httpServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if err := d.httpServer.Shutdown(ctx); err != nil {
+		d.httpServer.Close()
+	}
+}
+
+// serveHTTP answers POST /<method> with the response the socket would give.
+// The body is a SignRequest without "method"; an empty body is an empty
+// request. A WebSocket upgrade of / gets a connection like the socket's.
+func (d *Daemon) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	session := &connSession{id: d.nextConnID.Add(1)}
+	defer d.recoverHTTP(w, session)
+
+	if !d.httpAuthorized(r) {
+		logInfo("🚫 conn %d: HTTP %s without a valid bearer token", session.id, r.URL.Path)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeHTTPResponse(w, http.StatusUnauthorized, SignResponse{
+			Error: "unauthorized: bearer token required",
+			Code:  codeUnauthorized,
+		})
+		return
+	}
+	if r.URL.Path == "/" && isWebSocketUpgrade(r) {
+		d.serveWebSocket(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPResponse(w, http.StatusMethodNotAllowed, SignResponse{
+			Error: "use POST",
+			Code:  codeInvalidRequest,
+		})
+		return
+	}
+
+	var req SignRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeHTTPResponse(w, http.StatusRequestEntityTooLarge, SignResponse{
+			Error: errRequestTooLarge.Error(),
+			Code:  codeRequestTooLarge,
+		})
+		return
+	case err != nil && err != io.EOF:
+		writeHTTPResponse(w, http.StatusBadRequest, SignResponse{
+			ID:    req.ID,
+			Error: fmt.Sprintf("Invalid request format: %v", err),
+			Code:  codeInvalidRequest,
+		})
+		return
+	}
+
+	// The path names the method
+	req.Method = strings.Trim(r.URL.Path, "/")
+	if alias, ok := httpMethodAliases[req.Method]; ok {
+		req.Method = alias
+	}
+	if socketOnlyMethods[req.Method] {
+		writeHTTPResponse(w, http.StatusBadRequest, invalidRequestResponse(req, req.Method+" needs a socket connection"))
+		return
+	}
+
+	output := d.serveBridged(session, req, true)
+
+	var result struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	json.Unmarshal(output, &result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus(result.Error, result.Code))
+	w.Write(output)
+}
+
+// httpStatus maps a response to the HTTP status it is sent with
+func httpStatus(errorMessage, code string) int {
+	switch {
+	case code == codePending:
+		return http.StatusAccepted
+	case errorMessage == "":
+		return http.StatusOK
+	}
+	switch code {
+	case codeUnauthorized:
+		return http.StatusUnauthorized
+	case codeUnknownMethod:
+		return http.StatusNotFound
+	case codeInvalidRequest, codeUnsupportedVersion:
+		return http.StatusBadRequest
+	case codeRateLimited:
+		return http.StatusTooManyRequests
+	case codeDraining:
+		return http.StatusServiceUnavailable
+	case codeInternal:
+		return http.StatusInternalServerError
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// writeHTTPResponse sends a response the request never reached a handler for
+func writeHTTPResponse(w http.ResponseWriter, status int, response SignResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// recoverHTTP turns a panic while serving an HTTP request into a 500, like
+// recoverConnection does for the socket
+func (d *Daemon) recoverHTTP(w http.ResponseWriter, session *connSession) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	req := session.request
+	logError("conn %d: panic while handling HTTP id=%q method=%q: %v\n%s", session.id, req.ID, req.Method, r, debug.Stack())
+	if session.inFlight {
+		d.endRequest(req.Method)
+	}
+	writeHTTPResponse(w, http.StatusInternalServerError, SignResponse{
+		ID:    req.ID,
+		Error: "internal error - the request was not completed",
+		Code:  codeInternal,
+	})
+}
+
+// inspectHTTPListener reports the HTTP listener for the security summary
+func (d *Daemon) inspectHTTPListener() ListenerSecurity {
+	listener := ListenerSecurity{
+		Path:            "http://" + d.httpAddr,
+		Kind:            "http",
+		PeerCredentials: "not_checked",
+		Expected:        "loopback address, bearer token on every request or WebSocket",
+	}
+	if checkHTTPBind(d.httpAddr, false) != nil {
+		listener.Problems = append(listener.Problems, "bound to a non-loopback address (--unsafe-bind)")
+	}
+	return listener
+}
@@ -2,42 +2,187 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"strings"
 	"syscall"
-
-	"golang.org/x/term"
+	"time"
 )
 
+// errInputInterrupted is returned when the user presses Ctrl-C at a hidden prompt
+var errInputInterrupted = errors.New("input interrupted")
+
+// passwordConsole is the platform terminal used for hidden input
+// (input_unix.go, input_windows.go). Keeping the terminal calls behind this
+// interface lets the prompt logic below run against a fake console.
+type passwordConsole interface {
+	// IsConsole reports whether input can be read without echo
+	IsConsole() bool
+	// DisableEcho switches the console to unechoed, unbuffered input and
+	// returns a function that restores the previous mode
+	DisableEcho() (restore func(), err error)
+	// Read reads raw input bytes from the console
+	Read(p []byte) (int, error)
+	// Close releases the console handle
+	Close() error
+}
+
+// prompter asks the user for secrets. Commands take one rather than reading
+// the terminal themselves, so a caller can supply the answers.
+type prompter interface {
+	// readPassword reads a secret the user types: an nsec, a new password
+	readPassword(prompt string) (string, error)
+	// readAccountPassword reads an existing account's password, from
+	// --password-file, --password-fd or $NOORSIGNER_PASSWORD if given
+	readAccountPassword(prompt string) (string, error)
+}
+
+// terminalPrompter is the prompter of the CLI: the terminal, without echo
+type terminalPrompter struct{}
+
+func (terminalPrompter) readPassword(prompt string) (string, error) {
+	return readPassword(prompt)
+}
+
+func (terminalPrompter) readAccountPassword(prompt string) (string, error) {
+	return readAccountPassword(prompt)
+}
+
 // readPassword reads password from terminal without echo
 func readPassword(prompt string) (string, error) {
+	console := openPasswordConsole()
+	defer console.Close()
+
+	return readPasswordFrom(console, os.Stdin, prompt)
+}
+
+// readPasswordFrom prompts on stdout and reads a hidden line from console.
+// Without a console it warns and reads a visible line from fallback instead.
+func readPasswordFrom(console passwordConsole, fallback io.Reader, prompt string) (string, error) {
+	if !console.IsConsole() {
+		fmt.Fprintln(os.Stderr, "⚠️  No console attached - input will not be hidden")
+		fmt.Print(prompt)
+		password, err := readLine(fallback, false)
+		if err != nil {
+			return "", fmt.Errorf("error reading password: %v", err)
+		}
+		return password, nil
+	}
+
 	fmt.Print(prompt)
-	
-	// Read password without echoing to terminal
-	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
+
+	restore, err := console.DisableEcho()
 	if err != nil {
 		return "", fmt.Errorf("error reading password: %v", err)
 	}
-	
+
+	// Put the console back even if we are killed mid-prompt
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			restore()
+			fmt.Println()
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	password, err := readLine(console, true)
+
+	restore()
+	signal.Stop(signals)
+	close(done)
 	fmt.Println() // Print newline after password input
-	return string(bytePassword), nil
+
+	if err != nil {
+		if errors.Is(err, errInputInterrupted) {
+			os.Exit(130)
+		}
+		return "", fmt.Errorf("error reading password: %v", err)
+	}
+
+	return password, nil
+}
+
+// readLine reads up to the end of a line one byte at a time, so nothing past
+// the line is consumed. In raw mode it also handles the control keys a
+// cooked terminal would: backspace, Ctrl-C and Ctrl-D/Ctrl-Z.
+func readLine(r io.Reader, raw bool) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+
+	for {
+		n, err := r.Read(buf)
+		if n == 0 {
+			if err == io.EOF && len(line) > 0 {
+				return string(line), nil
+			}
+			if err == nil {
+				continue
+			}
+			return "", err
+		}
+
+		switch c := buf[0]; {
+		case c == '\n' || (raw && c == '\r'):
+			return strings.TrimSuffix(string(line), "\r"), nil
+		case raw && c == 3: // Ctrl-C
+			return "", errInputInterrupted
+		case raw && (c == 4 || c == 26) && len(line) == 0: // Ctrl-D, Ctrl-Z
+			return "", io.EOF
+		case raw && (c == 8 || c == 127): // Backspace
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		default:
+			line = append(line, c)
+		}
+	}
 }
 
 // readInput reads normal input with prompt
 func readInput(prompt string) (string, error) {
 	fmt.Print(prompt)
-	
+
 	reader := bufio.NewReader(os.Stdin)
 	input, err := reader.ReadString('\n')
 	if err != nil {
 		return "", fmt.Errorf("error reading input: %v", err)
 	}
-	
+
 	return strings.TrimSpace(input), nil
 }
 
 // readPasswordWithTrustMode reads password with trust mode indication
 func readPasswordWithTrustMode(prompt string) (string, error) {
 	return readPassword(prompt)
-}
\ No newline at end of file
+}
+
+// canPromptForPassword reports whether a password prompt can reach a user
+func canPromptForPassword() bool {
+	console := openPasswordConsole()
+	defer console.Close()
+
+	return console.IsConsole() || stdinIsInteractive()
+}
+
+// parseLocalTime parses a point in time given on the command line: a date
+// ("2025-08-01", the start of that day in local time), a local date and
+// time ("2025-08-01 18:00") or RFC 3339
+func parseLocalTime(value string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeConsole is a passwordConsole fed with scripted keystrokes
+type fakeConsole struct {
+	console  bool
+	input    *strings.Reader
+	echoErr  error // Returned by DisableEcho
+	echoOff  bool  // Echo is disabled right now
+	disabled bool  // Echo was disabled at some point
+}
+
+func (c *fakeConsole) IsConsole() bool { return c.console }
+
+func (c *fakeConsole) DisableEcho() (func(), error) {
+	if c.echoErr != nil {
+		return nil, c.echoErr
+	}
+	c.echoOff, c.disabled = true, true
+	return func() { c.echoOff = false }, nil
+}
+
+func (c *fakeConsole) Read(p []byte) (int, error) {
+	if !c.echoOff {
+		return 0, errors.New("read while echo is on")
+	}
+	return c.input.Read(p)
+}
+
+func (c *fakeConsole) Close() error { return nil }
+
+func TestReadPasswordFrom(t *testing.T) {
+	tests := []struct {
+		name     string
+		console  bool
+		keys     string // Typed at the console, or given on stdin without one
+		echoErr  error
+		want     string
+		wantErr  bool
+		leftOver string // What must remain unread
+	}{
+		{name: "enter", console: true, keys: "secret\r", want: "secret"},
+		{name: "newline", console: true, keys: "secret\n", want: "secret"},
+		{name: "crlf", console: true, keys: "secret\r\n", want: "secret", leftOver: "\n"},
+		{name: "backspace", console: true, keys: "secrex\x7ft\r", want: "secret"},
+		{name: "ctrl-h", console: true, keys: "secrex\bt\r", want: "secret"},
+		{name: "backspace on an empty line", console: true, keys: "\x7f\x7fsecret\r", want: "secret"},
+		{name: "ctrl-d on an empty line", console: true, keys: "\x04", wantErr: true},
+		{name: "ctrl-d inside a line", console: true, keys: "sec\x04ret\r", want: "sec\x04ret"},
+		{name: "ctrl-z on an empty line", console: true, keys: "\x1a", wantErr: true},
+		{name: "end of input mid-line", console: true, keys: "secret", want: "secret"},
+		{name: "stops at the line end", console: true, keys: "first\rsecond\r", want: "first", leftOver: "second\r"},
+		{name: "echo cannot be disabled", console: true, keys: "secret\r", echoErr: errors.New("not a terminal"), wantErr: true, leftOver: "secret\r"},
+		{name: "no console", keys: "visible\nnext\n", want: "visible", leftOver: "next\n"},
+		{name: "no console, crlf", keys: "visible\r\n", want: "visible"},
+		{name: "no console, no input", keys: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			console := &fakeConsole{console: tt.console, input: strings.NewReader(""), echoErr: tt.echoErr}
+			fallback := strings.NewReader("")
+			if tt.console {
+				console.input = strings.NewReader(tt.keys)
+			} else {
+				fallback = strings.NewReader(tt.keys)
+			}
+
+			var got string
+			var err error
+			output := captureStdout(t, func() { got, err = readPasswordFrom(console, fallback, "Password: ") })
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("readPasswordFrom: %q, %v; want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+			if !strings.HasPrefix(output, "Password: ") {
+				t.Errorf("output %q does not start with the prompt", output)
+			}
+			if strings.Contains(output, "secret") {
+				t.Errorf("output %q echoes the input", output)
+			}
+			if console.echoOff {
+				t.Error("echo was not restored")
+			}
+			if console.disabled != (tt.console && tt.echoErr == nil) {
+				t.Errorf("echo disabled %v", console.disabled)
+			}
+			left := console.input
+			if !tt.console {
+				left = fallback
+			}
+			if rest, _ := io.ReadAll(left); string(rest) != tt.leftOver {
+				t.Errorf("left unread %q, want %q", rest, tt.leftOver)
+			}
+		})
+	}
+}
+
+// Ctrl-C ends a raw line with errInputInterrupted; readPasswordFrom exits
+// on it, so it is checked on readLine
+func TestReadLineInterrupt(t *testing.T) {
+	if _, err := readLine(strings.NewReader("sec\x03ret\r"), true); !errors.Is(err, errInputInterrupted) {
+		t.Errorf("readLine: %v, want %v", err, errInputInterrupted)
+	}
+	// Outside raw mode the terminal handles control keys itself
+	if line, err := readLine(strings.NewReader("sec\x03\x7fret\n"), false); err != nil || line != "sec\x03\x7fret" {
+		t.Errorf("readLine: %q, %v", line, err)
+	}
+}
+
+// add-account asks for the nsec, then for the password until it is long
+// enough and confirmed, saying what was wrong each time
+func TestAddAccountPrompts(t *testing.T) {
+	testHome(t)
+	key, npub := testKey(t)
+	const password = "Correct-Horse-Battery-9"
+	prompt := &scriptedPrompter{answers: []string{
+		key,
+		"short",
+		password, "Correct-Horse-Battery-8",
+		password, password,
+	}}
+
+	var err error
+	output := captureStdout(t, func() { err = addAccount(prompt, "work") })
+	if err != nil {
+		t.Fatalf("add-account: %v\n%s", err, output)
+	}
+	wantPrompts := []string{
+		"",
+		"Enter password for encryption: ",
+		"Enter password for encryption: ", "Confirm password: ",
+		"Enter password for encryption: ", "Confirm password: ",
+	}
+	if !reflect.DeepEqual(prompt.prompts, wantPrompts) {
+		t.Errorf("prompts %q, want %q", prompt.prompts, wantPrompts)
+	}
+
+	// The messages come in the order of the answers that caused them
+	last := 0
+	for _, want := range []string{
+		"Enter your nsec (nsec1... or hex):",
+		"❌ Password must be at least 8 characters!",
+		"❌ Passwords do not match!",
+		npub,
+	} {
+		at := strings.Index(output[last:], want)
+		if at < 0 {
+			t.Fatalf("output lacks %q after byte %d:\n%s", want, last, output)
+		}
+		last += at + len(want)
+	}
+	for _, secret := range []string{key, password} {
+		if strings.Contains(output, secret) {
+			t.Errorf("output shows a secret:\n%s", output)
+		}
+	}
+
+	encryptedKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptNsec(encryptedKey, password); err != nil {
+		t.Errorf("the key does not open with the confirmed password: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// unixConsole reads hidden input from the terminal on stdin
+type unixConsole struct {
+	fd int
+}
+
+// openPasswordConsole returns the terminal attached to stdin
+func openPasswordConsole() passwordConsole {
+	return &unixConsole{fd: int(os.Stdin.Fd())}
+}
+
+func (c *unixConsole) IsConsole() bool {
+	return term.IsTerminal(c.fd)
+}
+
+// DisableEcho puts the terminal in raw mode (no echo, no line buffering, no
+// signal keys - readLine handles Ctrl-C itself)
+func (c *unixConsole) DisableEcho() (func(), error) {
+	state, err := term.MakeRaw(c.fd)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { term.Restore(c.fd, state) }, nil
+}
+
+func (c *unixConsole) Read(p []byte) (int, error) {
+	return os.Stdin.Read(p)
+}
+
+// Close is a no-op - stdin stays open
+func (c *unixConsole) Close() error {
+	return nil
+}
+
+// stdinIsInteractive reports whether stdin is a terminal
+func stdinIsInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
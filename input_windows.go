@@ -0,0 +1,129 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"strings"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// windowsConsole reads hidden input from CONIN$ using the console mode APIs
+// directly. This works even when stdin is redirected (Windows Terminal,
+// `cmd < file`), where int(syscall.Stdin) is no console descriptor.
+type windowsConsole struct {
+	handle  windows.Handle
+	mode    uint32 // Mode before DisableEcho
+	ok      bool
+	pending []byte   // UTF-8 bytes decoded but not yet returned by Read
+	high    []uint16 // Unpaired high surrogate from the previous read
+}
+
+// openPasswordConsole opens the process console (CONIN$). Under Git Bash /
+// MSYS mintty keystrokes go to a pty pipe, not the hidden console, so that
+// case is reported as "no console".
+func openPasswordConsole() passwordConsole {
+	if isMsysPty(windows.Handle(os.Stdin.Fd())) {
+		return &windowsConsole{}
+	}
+
+	name, err := windows.UTF16PtrFromString("CONIN$")
+	if err != nil {
+		return &windowsConsole{}
+	}
+	handle, err := windows.CreateFile(name,
+		windows.GENERIC_READ|windows.GENERIC_WRITE,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE,
+		nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return &windowsConsole{}
+	}
+
+	console := &windowsConsole{handle: handle}
+	if windows.GetConsoleMode(handle, &console.mode) == nil {
+		console.ok = true
+	}
+	return console
+}
+
+func (c *windowsConsole) IsConsole() bool {
+	return c.ok
+}
+
+// DisableEcho turns off echo and line input. Processed input stays on, so
+// Ctrl-C still raises an interrupt (readPasswordFrom restores the mode then).
+func (c *windowsConsole) DisableEcho() (func(), error) {
+	mode := c.mode&^(windows.ENABLE_ECHO_INPUT|windows.ENABLE_LINE_INPUT) | windows.ENABLE_PROCESSED_INPUT
+	if err := windows.SetConsoleMode(c.handle, mode); err != nil {
+		return nil, err
+	}
+
+	return func() { windows.SetConsoleMode(c.handle, c.mode) }, nil
+}
+
+// Read returns console input as UTF-8, independent of the console code page
+func (c *windowsConsole) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		units := make([]uint16, 16)
+		var read uint32
+		if err := windows.ReadConsole(c.handle, &units[0], uint32(len(units)), &read, nil); err != nil {
+			return 0, err
+		}
+		if read == 0 {
+			continue
+		}
+
+		units = append(c.high, units[:read]...)
+		c.high = nil
+		if last := units[len(units)-1]; utf16.IsSurrogate(rune(last)) && last < 0xdc00 {
+			c.high = []uint16{last}
+			units = units[:len(units)-1]
+		}
+		c.pending = []byte(string(utf16.Decode(units)))
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *windowsConsole) Close() error {
+	if c.handle == 0 {
+		return nil
+	}
+	return windows.CloseHandle(c.handle)
+}
+
+// stdinIsInteractive reports whether stdin is a console or an MSYS/Cygwin pty
+func stdinIsInteractive() bool {
+	stdin := windows.Handle(os.Stdin.Fd())
+
+	var mode uint32
+	return windows.GetConsoleMode(stdin, &mode) == nil || isMsysPty(stdin)
+}
+
+// isMsysPty reports whether handle is a mintty pty pipe
+// (\msys-<hash>-pty<N>-from-master or the cygwin- equivalent)
+func isMsysPty(handle windows.Handle) bool {
+	fileType, err := windows.GetFileType(handle)
+	if err != nil || fileType != windows.FILE_TYPE_PIPE {
+		return false
+	}
+
+	// FILE_NAME_INFO: DWORD length in bytes followed by the UTF-16 name
+	buf := make([]byte, 4+2*windows.MAX_PATH)
+	if err := windows.GetFileInformationByHandleEx(handle, windows.FileNameInfo, &buf[0], uint32(len(buf))); err != nil {
+		return false
+	}
+	length := *(*uint32)(unsafe.Pointer(&buf[0])) / 2
+	if int(length) > (len(buf)-4)/2 {
+		return false
+	}
+	name := string(utf16.Decode(unsafe.Slice((*uint16)(unsafe.Pointer(&buf[4])), length)))
+
+	return (strings.HasPrefix(name, `\msys-`) || strings.HasPrefix(name, `\cygwin-`)) &&
+		strings.Contains(name, "-pty")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// keys.encrypted format versions
+const (
+	// keyFormatLegacy is "salthex:ciphertexthex", the nsec XORed with the
+	// scrypt key. It is still read, and rewritten on the next unlock.
+	keyFormatLegacy = 1
+	// keyFormatVersion is the JSON format written now
+	keyFormatVersion = 2
+)
+
+// Upper bounds for the scrypt parameters a key file may name, so a tampered
+// file can't make an unlock take gigabytes of memory
+const (
+	maxScryptN = 1 << 20
+	maxScryptR = 32
+	maxScryptP = 16
+)
+
+// encryptedKeyFile is keys.encrypted from format version 2 on, with hex
+// encoded salt, nonce and ciphertext:
+//
+//	{"version":2,"kdf":"scrypt","kdf_params":{"n":16384,"r":8,"p":1},
+//	 "salt":"...","cipher":"aes-256-gcm","nonce":"...","ciphertext":"..."}
+type encryptedKeyFile struct {
+	Version    int       `json:"version"`
+	KDF        string    `json:"kdf"`
+	KDFParams  KDFParams `json:"kdf_params"`
+	Salt       string    `json:"salt"`
+	Cipher     string    `json:"cipher"`
+	Nonce      string    `json:"nonce"`
+	Ciphertext string    `json:"ciphertext"`
+}
+
+// legacyEncryptedKey returns a key in format version 1
+func legacyEncryptedKey(salt, ciphertext []byte) *EncryptedKey {
+	return &EncryptedKey{
+		Version:       keyFormatLegacy,
+		KDF:           kdfScrypt,
+		KDFParams:     defaultKDFParams,
+		Salt:          salt,
+		Cipher:        cipherXOR,
+		EncryptedNsec: ciphertext,
+	}
+}
+
+// marshalEncryptedKey encodes a key for keys.encrypted. Only keys in the
+// current format are written; legacy keys are re-encrypted instead.
+func marshalEncryptedKey(encKey *EncryptedKey) ([]byte, error) {
+	if encKey.Cipher != cipherAESGCM {
+		return nil, fmt.Errorf("cannot write a key encrypted with %q - re-encrypt it first", encKey.Cipher)
+	}
+	content, err := json.Marshal(encryptedKeyFile{
+		Version:    keyFormatVersion,
+		KDF:        encKey.KDF,
+		KDFParams:  encKey.KDFParams,
+		Salt:       encodeHex(encKey.Salt),
+		Cipher:     encKey.Cipher,
+		Nonce:      encodeHex(encKey.Nonce),
+		Ciphertext: encodeHex(encKey.EncryptedNsec),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
+
+// parseEncryptedKey decodes keys.encrypted in either format
+func parseEncryptedKey(content []byte) (*EncryptedKey, error) {
+	content = bytes.TrimSpace(content)
+	if !bytes.HasPrefix(content, []byte("{")) {
+		return parseLegacyEncryptedKey(string(content))
+	}
+
+	var file encryptedKeyFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if file.Version != keyFormatVersion {
+		return nil, fmt.Errorf("unsupported format version %d (written by a newer noorsigner?)", file.Version)
+	}
+	if file.KDF != kdfScrypt {
+		return nil, fmt.Errorf("unsupported kdf %q", file.KDF)
+	}
+	params := file.KDFParams
+	if params.N < 2 || params.N&(params.N-1) != 0 || params.N > maxScryptN ||
+		params.R < 1 || params.R > maxScryptR || params.P < 1 || params.P > maxScryptP {
+		return nil, fmt.Errorf("invalid kdf_params n=%d r=%d p=%d", params.N, params.R, params.P)
+	}
+	if file.Cipher != cipherAESGCM {
+		return nil, fmt.Errorf("unsupported cipher %q", file.Cipher)
+	}
+
+	salt, err := decodeHex(file.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt: %v", err)
+	}
+	nonce, err := decodeHex(file.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %v", err)
+	}
+	if len(nonce) != aesGCMNonceSize {
+		return nil, fmt.Errorf("nonce should be %d bytes, not %d", aesGCMNonceSize, len(nonce))
+	}
+	ciphertext, err := decodeHex(file.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %v", err)
+	}
+
+	return &EncryptedKey{
+		Version:       file.Version,
+		KDF:           file.KDF,
+		KDFParams:     params,
+		Salt:          salt,
+		Cipher:        file.Cipher,
+		Nonce:         nonce,
+		EncryptedNsec: ciphertext,
+	}, nil
+}
+
+// parseLegacyEncryptedKey decodes format version 1
+func parseLegacyEncryptedKey(content string) (*EncryptedKey, error) {
+	parts := strings.SplitN(content, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("no salt:ciphertext separator")
+	}
+
+	salt, err := decodeHex(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt: %v", err)
+	}
+
+	encrypted, err := decodeHex(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted data: %v", err)
+	}
+
+	return legacyEncryptedKey(salt, encrypted), nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// testNsec returns a new key as nsec and its npub
+func testNsec(t *testing.T) (string, string) {
+	t.Helper()
+	hexKey, npub := testKey(t)
+	key, _ := hex.DecodeString(hexKey)
+	nsec, err := encodeBech32Key("nsec", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nsec, npub
+}
+
+// legacyKeyFile returns keys.encrypted in format version 1 for nsec:
+// "salthex:ciphertexthex", the nsec XORed with the scrypt key
+func legacyKeyFile(t *testing.T, nsec, password string) []byte {
+	t.Helper()
+	salt := make([]byte, saltLen)
+	rand.Read(salt)
+	derivedKey, err := scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, keyLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := make([]byte, len(nsec))
+	for i := range ciphertext {
+		ciphertext[i] = nsec[i] ^ derivedKey[i%len(derivedKey)]
+	}
+	return []byte(hex.EncodeToString(salt) + ":" + hex.EncodeToString(ciphertext) + "\n")
+}
+
+func TestEncryptedKeyRoundTrip(t *testing.T) {
+	testHome(t)
+	nsec, _ := testNsec(t)
+	encKey, err := encryptNsec(nsec, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := marshalEncryptedKey(encKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var file map[string]any
+	if err := json.Unmarshal(content, &file); err != nil {
+		t.Fatalf("keys.encrypted is not JSON: %v\n%s", err, content)
+	}
+	for field, want := range map[string]any{"version": float64(2), "kdf": "scrypt", "cipher": "aes-256-gcm"} {
+		if file[field] != want {
+			t.Errorf("%s = %v, want %v", field, file[field], want)
+		}
+	}
+	for _, field := range []string{"kdf_params", "salt", "nonce", "ciphertext"} {
+		if file[field] == nil {
+			t.Errorf("%s is missing", field)
+		}
+	}
+
+	parsed, err := parseEncryptedKey(content)
+	if err != nil {
+		t.Fatalf("parseEncryptedKey: %v", err)
+	}
+	if parsed.Version != keyFormatVersion || parsed.KDF != encKey.KDF || parsed.KDFParams != encKey.KDFParams ||
+		parsed.Cipher != encKey.Cipher || !bytes.Equal(parsed.Salt, encKey.Salt) ||
+		!bytes.Equal(parsed.Nonce, encKey.Nonce) || !bytes.Equal(parsed.EncryptedNsec, encKey.EncryptedNsec) {
+		t.Errorf("parsed %+v, wrote %+v", parsed, encKey)
+	}
+	decrypted, err := decryptNsec(parsed, testPassword)
+	if err != nil || decrypted != nsec {
+		t.Errorf("decryptNsec = %v, want the nsec", err)
+	}
+	if _, err := decryptNsec(parsed, "wrong password"); err == nil {
+		t.Error("a wrong password decrypted the key")
+	}
+
+	again, err := marshalEncryptedKey(parsed)
+	if err != nil || !bytes.Equal(again, content) {
+		t.Errorf("re-marshalled key differs:\n%s\n%s", again, content)
+	}
+}
+
+func TestParseLegacyEncryptedKey(t *testing.T) {
+	testHome(t)
+	nsec, _ := testNsec(t)
+	parsed, err := parseEncryptedKey(legacyKeyFile(t, nsec, testPassword))
+	if err != nil {
+		t.Fatalf("parseEncryptedKey: %v", err)
+	}
+	if parsed.Version != keyFormatLegacy || parsed.Cipher != cipherXOR || parsed.KDFParams != defaultKDFParams {
+		t.Errorf("parsed %+v, want a legacy key", parsed)
+	}
+	if decrypted, err := decryptNsec(parsed, testPassword); err != nil || decrypted != nsec {
+		t.Errorf("decryptNsec = %v, want the nsec", err)
+	}
+	if _, err := marshalEncryptedKey(parsed); err == nil {
+		t.Error("a legacy key was written without re-encrypting it")
+	}
+}
+
+func TestParseEncryptedKeyRejects(t *testing.T) {
+	valid := encryptedKeyFile{
+		Version:    keyFormatVersion,
+		KDF:        kdfScrypt,
+		KDFParams:  defaultKDFParams,
+		Salt:       strings.Repeat("00", saltLen),
+		Cipher:     cipherAESGCM,
+		Nonce:      strings.Repeat("00", aesGCMNonceSize),
+		Ciphertext: strings.Repeat("00", 79),
+	}
+	tests := []struct {
+		name   string
+		modify func(f *encryptedKeyFile)
+	}{
+		{"newer version", func(f *encryptedKeyFile) { f.Version = 3 }},
+		{"other kdf", func(f *encryptedKeyFile) { f.KDF = "argon2id" }},
+		{"n not a power of two", func(f *encryptedKeyFile) { f.KDFParams.N = 10000 }},
+		{"n too large", func(f *encryptedKeyFile) { f.KDFParams.N = maxScryptN * 2 }},
+		{"r too large", func(f *encryptedKeyFile) { f.KDFParams.R = maxScryptR + 1 }},
+		{"p zero", func(f *encryptedKeyFile) { f.KDFParams.P = 0 }},
+		{"xor cipher", func(f *encryptedKeyFile) { f.Cipher = cipherXOR }},
+		{"short nonce", func(f *encryptedKeyFile) { f.Nonce = "0000" }},
+		{"invalid salt", func(f *encryptedKeyFile) { f.Salt = "zz" }},
+		{"invalid ciphertext", func(f *encryptedKeyFile) { f.Ciphertext = "0" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := valid
+			tt.modify(&file)
+			content, _ := json.Marshal(file)
+			if _, err := parseEncryptedKey(content); err == nil {
+				t.Errorf("parseEncryptedKey accepted %s", content)
+			}
+		})
+	}
+
+	content, _ := json.Marshal(valid)
+	if _, err := parseEncryptedKey(content); err != nil {
+		t.Errorf("the unmodified file: %v", err)
+	}
+	for _, malformed := range []string{"0011", "zz:00", "00:zz", `{"version":`} {
+		if _, err := parseEncryptedKey([]byte(malformed)); err == nil {
+			t.Errorf("parseEncryptedKey accepted %q", malformed)
+		}
+	}
+}
+
+// An account whose keys.encrypted is in the legacy format is rewritten in
+// format version 2 by its next successful unlock, and only then
+func TestLegacyKeyUpgrade(t *testing.T) {
+	testHome(t)
+	nsec, npub := testNsec(t)
+	store, err := accountStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := legacyKeyFile(t, nsec, testPassword)
+	if err := store.WriteRecords(npub, storeRecord{Name: recordKey, Data: legacy}); err != nil {
+		t.Fatal(err)
+	}
+
+	encKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := decryptAccountKey(encKey, npub, "wrong password"); err == nil {
+		t.Fatal("a wrong password unlocked the legacy key")
+	}
+	if data, _, _ := store.ReadRecord(npub, recordKey); !bytes.Equal(data, legacy) {
+		t.Fatal("a failed unlock rewrote the legacy key file")
+	}
+
+	decrypted, privateKey, err := decryptAccountKey(encKey, npub, testPassword)
+	if err != nil {
+		t.Fatalf("unlock of the legacy key: %v", err)
+	}
+	if decrypted != nsec || !keyMatchesNpub(privateKey, npub) {
+		t.Fatal("the legacy key decrypted to another key")
+	}
+
+	upgraded, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgraded.Version != keyFormatVersion || upgraded.Cipher != cipherAESGCM {
+		t.Fatalf("after the unlock keys.encrypted is %+v, want format version 2", upgraded)
+	}
+	if data, _, _ := store.ReadRecord(npub, recordKey); !bytes.HasPrefix(data, []byte("{")) {
+		t.Errorf("keys.encrypted is not JSON after the upgrade: %s", data)
+	}
+	if decrypted, _, err := decryptAccountKey(upgraded, npub, testPassword); err != nil || decrypted != nsec {
+		t.Errorf("unlock of the upgraded key: %v", err)
+	}
+}
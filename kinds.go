@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// kindDescriptions names well-known event kinds in prompts, audit output and
+// notifications. GUIs get the same table from get_capabilities, so keep the
+// wording short and plain.
+var kindDescriptions = map[int]string{
+	0:     "profile metadata",
+	1:     "note",
+	3:     "contacts",
+	4:     "legacy DM",
+	5:     "deletion request",
+	6:     "repost",
+	7:     "reaction",
+	8:     "badge award",
+	13:    "seal",
+	14:    "direct message",
+	16:    "generic repost",
+	40:    "channel creation",
+	41:    "channel metadata",
+	42:    "channel message",
+	1059:  "gift wrap",
+	1063:  "file metadata",
+	1984:  "report",
+	9734:  "zap request",
+	9735:  "zap receipt",
+	10000: "mute list",
+	10002: "relay list",
+	10050: "DM relay list",
+	13194: "wallet info",
+	22242: "relay authentication",
+	23194: "wallet request",
+	23195: "wallet response",
+	24133: "remote signer message",
+	27235: "HTTP authentication",
+	30000: "follow set",
+	30008: "profile badges",
+	30009: "badge definition",
+	30023: "long-form article",
+	30078: "app data",
+}
+
+// KindDescription is one entry of the kind table in get_capabilities
+type KindDescription struct {
+	Kind        int    `json:"kind"`
+	Description string `json:"description"`
+}
+
+// describeKind renders a kind for people: "kind 1 (note)", or
+// "kind 31234 (unknown)" for kinds not in the table
+func describeKind(kind int) string {
+	description, known := kindDescriptions[kind]
+	if !known {
+		description = "unknown"
+	}
+	return fmt.Sprintf("kind %d (%s)", kind, description)
+}
+
+// kindTable returns the kind descriptions ordered by kind
+func kindTable() []KindDescription {
+	table := make([]KindDescription, 0, len(kindDescriptions))
+	for kind, description := range kindDescriptions {
+		table = append(table, KindDescription{Kind: kind, Description: description})
+	}
+	sort.Slice(table, func(i, j int) bool { return table[i].Kind < table[j].Kind })
+	return table
+}
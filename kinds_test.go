@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Every kind in the table appears once, in order, with a description of
+// its own that is set and fits a prompt line
+func TestKindTable(t *testing.T) {
+	table := kindTable()
+	if len(table) != len(kindDescriptions) {
+		t.Fatalf("kindTable has %d entries, the descriptions %d", len(table), len(kindDescriptions))
+	}
+	descriptions := make(map[string]int)
+	for i, entry := range table {
+		if i > 0 && entry.Kind <= table[i-1].Kind {
+			t.Errorf("kind %d follows kind %d: repeated or out of order", entry.Kind, table[i-1].Kind)
+		}
+		if entry.Kind < 0 || entry.Kind > 65535 {
+			t.Errorf("kind %d is outside 0-65535", entry.Kind)
+		}
+		if entry.Description != kindDescriptions[entry.Kind] {
+			t.Errorf("kind %d: table says %q, descriptions %q", entry.Kind, entry.Description, kindDescriptions[entry.Kind])
+		}
+		description := entry.Description
+		if strings.TrimSpace(description) == "" || description != strings.TrimSpace(description) || len(description) > 30 {
+			t.Errorf("kind %d: description %q is empty, padded or too long", entry.Kind, description)
+		}
+		if other, dup := descriptions[description]; dup {
+			t.Errorf("kinds %d and %d are both described as %q", other, entry.Kind, description)
+		}
+		descriptions[description] = entry.Kind
+	}
+}
+
+func TestDescribeKind(t *testing.T) {
+	tests := []struct {
+		kind int
+		want string
+	}{
+		{0, "kind 0 (profile metadata)"},
+		{1, "kind 1 (note)"},
+		{9734, "kind 9734 (zap request)"},
+		{30023, "kind 30023 (long-form article)"},
+		{2, "kind 2 (unknown)"},
+		{31234, "kind 31234 (unknown)"},
+		{-1, "kind -1 (unknown)"},
+	}
+	for _, tt := range tests {
+		if got := describeKind(tt.kind); got != tt.want {
+			t.Errorf("describeKind(%d) = %q, want %q", tt.kind, got, tt.want)
+		}
+	}
+}
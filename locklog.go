@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// lockHistorySize is how many locks get_status lists
+const lockHistorySize = 20
+
+// LockReason says why the daemon dropped its key
+type LockReason string
+
+const (
+	// lockReasonClient: a client sent lock
+	lockReasonClient LockReason = "client"
+	// lockReasonFreeze: noorsigner freeze sent lock (see freeze.go)
+	lockReasonFreeze LockReason = "freeze"
+	// lockReasonIdle: the trust session went unused for trust_idle_timeout
+	lockReasonIdle LockReason = "idle_timeout"
+	// lockReasonTrustExpired: the trust session reached its expiry
+	lockReasonTrustExpired LockReason = "trust_expired"
+	// lockReasonShutdown: a signal, shutdown_daemon or drain stopped the daemon
+	lockReasonShutdown LockReason = "shutdown"
+	// lockReasonAccountRemoved: the active account was removed with force
+	lockReasonAccountRemoved LockReason = "account_removed"
+)
+
+// LockEvent records one time the daemon went from unlocked to locked
+type LockEvent struct {
+	Timestamp int64      `json:"timestamp"`
+	Reason    LockReason `json:"reason"`
+	Npub      string     `json:"npub,omitempty"`
+	// Trigger details the reason, e.g. "SIGTERM" or "unused for 15m0s"
+	Trigger string `json:"trigger,omitempty"`
+	// Client is the authorized client's name (only with require_auth)
+	Client string `json:"client,omitempty"`
+	// Who sent the request, for locks a request caused (see clientident.go)
+	ClientIdentity
+}
+
+// describe phrases the lock for the log and credential requests
+func (e LockEvent) describe() string {
+	var text string
+	switch e.Reason {
+	case lockReasonClient:
+		text = "locked by client"
+	case lockReasonFreeze:
+		text = "locked for a freeze"
+	case lockReasonIdle:
+		// The trigger says for how long, e.g. "unused for 15m0s"
+		return "locked - " + displayNpub(e.Npub) + " " + e.Trigger
+	case lockReasonTrustExpired:
+		return "locked - trust session of " + displayNpub(e.Npub) + " expired " + e.Trigger
+	case lockReasonShutdown:
+		text = "locked on shutdown"
+	case lockReasonAccountRemoved:
+		text = "locked - active account removed"
+	default:
+		text = "locked (" + string(e.Reason) + ")"
+	}
+	if detail := lockDetail(e); detail != "" {
+		text += " (" + detail + ")"
+	}
+	return text
+}
+
+// lockHistory is a bounded buffer of the most recent locks
+type lockHistory struct {
+	mu      sync.Mutex
+	entries []LockEvent // Oldest first, at most lockHistorySize
+}
+
+// add records a lock, dropping the oldest when full
+func (h *lockHistory) add(entry LockEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) >= lockHistorySize {
+		copy(h.entries, h.entries[1:])
+		h.entries = h.entries[:len(h.entries)-1]
+	}
+	h.entries = append(h.entries, entry)
+}
+
+// snapshot returns the buffered locks, oldest first
+func (h *lockHistory) snapshot() []LockEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := make([]LockEvent, len(h.entries))
+	copy(entries, h.entries)
+	return entries
+}
+
+// last returns the most recent lock, if any
+func (h *lockHistory) last() (LockEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) == 0 {
+		return LockEvent{}, false
+	}
+	return h.entries[len(h.entries)-1], true
+}
+
+// requestLock returns the lock a request causes, naming its sender
+func requestLock(reason LockReason, trigger string, session *connSession, req SignRequest) LockEvent {
+	return LockEvent{
+		Reason:         reason,
+		Trigger:        trigger,
+		Client:         session.client,
+		ClientIdentity: session.identity(req),
+	}
+}
+
+// clientLockReason maps lock's reason field to a LockReason. Clients may
+// only say the lock is for a freeze; anything else is a plain client lock.
+func clientLockReason(reason string) LockReason {
+	if LockReason(reason) == lockReasonFreeze {
+		return lockReasonFreeze
+	}
+	return lockReasonClient
+}
+
+// recordLock logs a lock and adds it to the history and the audit log
+func (d *Daemon) recordLock(event *LockEvent, npub string) {
+	event.Timestamp = time.Now().Unix()
+	event.Npub = npub
+	d.locks.add(*event)
+	logInfo("🔒 Daemon %s", event.describe())
+
+	if !d.config.auditEnabled() {
+		return
+	}
+	err := appendAuditEntry(AuditEntry{
+		Timestamp:      event.Timestamp,
+		Source:         "daemon",
+		PID:            os.Getpid(),
+		Action:         "locked",
+		Npub:           npub,
+		Client:         event.Client,
+		Success:        true,
+		Reason:         lockAuditReason(*event),
+		ClientIdentity: event.ClientIdentity,
+	})
+	if err != nil {
+		logError("⚠️  %v", err)
+	}
+}
+
+// lockAuditReason is the audit entry's reason: the LockReason and trigger
+func lockAuditReason(event LockEvent) string {
+	if event.Trigger == "" {
+		return string(event.Reason)
+	}
+	return fmt.Sprintf("%s: %s", event.Reason, event.Trigger)
+}
+
+// printLockHistory prints the locks, newest last, for status --verbose
+func printLockHistory(locks []LockEvent) {
+	fmt.Println()
+	if len(locks) == 0 {
+		fmt.Println("Lock history: none since the daemon started")
+		return
+	}
+	fmt.Println("Lock history:")
+	for _, lock := range locks {
+		npub := "-"
+		if lock.Npub != "" {
+			npub = displayNpub(lock.Npub)
+		}
+		fmt.Printf("  %s  %-16s %-18s %s\n", time.Unix(lock.Timestamp, 0).Format("2006-01-02 15:04:05"),
+			lock.Reason, npub, lockDetail(lock))
+	}
+}
+
+// lockDetail is a lock's trigger and requesting client, for status
+func lockDetail(lock LockEvent) string {
+	detail := lock.Trigger
+	if lock.ClientIdentity != (ClientIdentity{}) {
+		if detail != "" {
+			detail += " "
+		}
+		detail += "from " + lock.ClientIdentity.String()
+	}
+	return detail
+}
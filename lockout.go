@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// lockoutFreeFailures is how many wrong passwords in a row are let go
+	// before each further one costs a delay
+	lockoutFreeFailures = 3
+
+	// lockoutMaxDelay caps the doubling delay
+	lockoutMaxDelay = 15 * time.Minute
+
+	codeLockedOut = "ERR_LOCKED_OUT"
+)
+
+// errLockedOut is returned while an account waits out a lockout
+var errLockedOut = errors.New("too many wrong passwords")
+
+// lockoutError says how long an account stays locked out
+type lockoutError struct {
+	Remaining time.Duration
+}
+
+func (e *lockoutError) Error() string {
+	return fmt.Sprintf("%v - try again in %s", errLockedOut, describeDuration(e.Remaining))
+}
+
+func (e *lockoutError) Is(target error) bool {
+	return target == errLockedOut
+}
+
+// AccountLockout is one account's entry in lockout.json
+type AccountLockout struct {
+	// Failures counts wrong passwords since the last right one
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	// LockedUntil is when the next attempt is accepted
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// lockoutFile is the layout of ~/.noorsigner/lockout.json. The daemon and
+// the CLI share it, so neither a restart nor switching between them skips
+// a delay.
+type lockoutFile struct {
+	Accounts map[string]*AccountLockout `json:"accounts"`
+}
+
+// getLockoutPath returns the path of lockout.json
+func getLockoutPath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storageDir, "lockout.json"), nil
+}
+
+// lockoutDelay returns the delay after the given number of failures in a
+// row: none for the first few, then 1s, 2s, 4s ... up to lockoutMaxDelay
+func lockoutDelay(failures int) time.Duration {
+	if failures < lockoutFreeFailures {
+		return 0
+	}
+	delay := time.Second
+	for i := lockoutFreeFailures; i < failures && delay < lockoutMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, lockoutMaxDelay)
+}
+
+// loadLockouts reads lockout.json (empty if there is none)
+func loadLockouts() (*lockoutFile, error) {
+	path, err := getLockoutPath()
+	if err != nil {
+		return nil, err
+	}
+	lockouts := &lockoutFile{Accounts: make(map[string]*AccountLockout)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return lockouts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read lockout.json: %v", err)
+	}
+	if err := json.Unmarshal(data, lockouts); err != nil {
+		return nil, fmt.Errorf("lockout.json is damaged - remove it by hand: %v", err)
+	}
+	if lockouts.Accounts == nil {
+		lockouts.Accounts = make(map[string]*AccountLockout)
+	}
+	return lockouts, nil
+}
+
+// updateLockouts changes lockout.json under an exclusive lock, so the
+// daemon and CLI processes don't lose each other's failures
+func updateLockouts(update func(lockouts *lockoutFile)) error {
+	path, err := getLockoutPath()
+	if err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot open lockout lock: %v", err)
+	}
+	defer lock.Close()
+	unlock, err := lockFile(lock)
+	if err != nil {
+		return fmt.Errorf("cannot lock lockout.json: %v", err)
+	}
+	defer unlock()
+
+	lockouts, err := loadLockouts()
+	if err != nil {
+		return err
+	}
+	update(lockouts)
+	data, err := json.MarshalIndent(lockouts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0600)
+}
+
+// checkLockout refuses while npub waits out a lockout. A lockout.json that
+// can't be read refuses too, like freeze.json: failing open would make the
+// delays easy to skip.
+func checkLockout(npub string) error {
+	lockouts, err := loadLockouts()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errLockedOut, err)
+	}
+	entry, ok := lockouts.Accounts[npub]
+	if !ok {
+		return nil
+	}
+	if remaining := time.Until(entry.LockedUntil); remaining > 0 {
+		// Whole seconds, rounded up, so "try again in 0s" never shows
+		return &lockoutError{Remaining: (remaining + time.Second - 1).Truncate(time.Second)}
+	}
+	return nil
+}
+
+// recordPasswordFailure counts a wrong password for npub and starts the
+// next delay. It returns the lockout that now applies, if any.
+func recordPasswordFailure(npub string) error {
+	var locked time.Duration
+	now := time.Now()
+	err := updateLockouts(func(lockouts *lockoutFile) {
+		entry, ok := lockouts.Accounts[npub]
+		if !ok {
+			entry = &AccountLockout{}
+			lockouts.Accounts[npub] = entry
+		}
+		entry.Failures++
+		entry.LastFailure = now
+		locked = lockoutDelay(entry.Failures)
+		if locked > 0 {
+			entry.LockedUntil = now.Add(locked)
+		}
+	})
+	if err != nil {
+		logError("⚠️  Cannot record a wrong password for %s: %v", displayNpub(npub), err)
+	}
+	if locked > 0 {
+		return &lockoutError{Remaining: locked}
+	}
+	return nil
+}
+
+// clearPasswordFailures forgets npub's failures after a right password
+func clearPasswordFailures(npub string) {
+	lockouts, err := loadLockouts()
+	if err == nil {
+		if _, ok := lockouts.Accounts[npub]; !ok {
+			return
+		}
+	}
+	err = updateLockouts(func(lockouts *lockoutFile) {
+		delete(lockouts.Accounts, npub)
+	})
+	if err != nil {
+		logError("⚠️  Cannot reset the wrong password count for %s: %v", displayNpub(npub), err)
+	}
+}
+
+// refuseIfLockedOut fails a CLI command before it asks for a password that
+// can't be tried yet
+func refuseIfLockedOut(npub string) error {
+	if err := checkLockout(npub); err != nil {
+		return commandFailed(1, "🚫 %v", err)
+	}
+	return nil
+}
+
+// passwordFailureMessage is the CLI's message for a password that didn't
+// decrypt an account's key
+func passwordFailureMessage(err error) string {
+	var lockout *lockoutError
+	switch {
+	case errors.Is(err, errBadPassword) && errors.As(err, &lockout):
+		return fmt.Sprintf("❌ Invalid password! %v", lockout)
+	case errors.Is(err, errBadPassword):
+		return "❌ Invalid password!"
+	case errors.Is(err, errLockedOut):
+		return fmt.Sprintf("🚫 %v", err)
+	case errors.Is(err, errSignerFrozen):
+		return fmt.Sprintf("❄️  %v", err)
+	}
+	return fmt.Sprintf("❌ %v", err)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log rotation: daemon.log is rotated to daemon.log.1 ... daemon.log.N
+const (
+	logMaxSize  = 5 * 1024 * 1024
+	logMaxFiles = 5
+)
+
+// Log levels, from least to most verbose
+const (
+	logLevelError = iota
+	logLevelInfo
+	logLevelDebug
+)
+
+var logLevelNames = map[int]string{
+	logLevelError: "ERROR",
+	logLevelInfo:  "INFO",
+	logLevelDebug: "DEBUG",
+}
+
+// Logger writes timestamped daemon log entries to a size-rotated file and/or
+// echoes them to a terminal stream.
+//
+// Never pass passwords, nsecs, decrypted plaintexts or encrypted payloads to
+// the logger - request logging is limited to IDs and method names. Messages
+// still go through redactSecrets, in case one slips through.
+type Logger struct {
+	mu    sync.Mutex
+	level int
+	echo  io.Writer
+	path  string
+	file  *os.File
+	size  int64
+}
+
+// daemonLog is the process-wide daemon logger. Until initDaemonLog runs it
+// only echoes to stdout.
+var daemonLog = &Logger{level: logLevelInfo, echo: os.Stdout}
+
+// parseLogLevel parses "error", "info" or "debug" (empty = info)
+func parseLogLevel(value string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "info":
+		return logLevelInfo, nil
+	case "error":
+		return logLevelError, nil
+	case "debug":
+		return logLevelDebug, nil
+	default:
+		return logLevelInfo, fmt.Errorf("invalid log_level %q (use error, info or debug)", value)
+	}
+}
+
+// getLogFilePath returns path to daemon.log
+func getLogFilePath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(storageDir, "daemon.log"), nil
+}
+
+// initDaemonLog configures daemonLog. toFile opens daemon.log for appending;
+// a non-nil echo also receives every message.
+func initDaemonLog(level string, toFile bool, echo io.Writer) error {
+	parsedLevel, levelErr := parseLogLevel(level)
+
+	daemonLog.mu.Lock()
+	defer daemonLog.mu.Unlock()
+
+	daemonLog.level = parsedLevel
+	daemonLog.echo = echo
+
+	if toFile {
+		logFile, err := getLogFilePath()
+		if err != nil {
+			return err
+		}
+		daemonLog.path = logFile
+		if err := daemonLog.openLocked(); err != nil {
+			return err
+		}
+	}
+
+	return levelErr
+}
+
+// openLocked opens the log file for appending (caller holds mu)
+func (l *Logger) openLocked() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot open log file: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot stat log file: %v", err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotateLocked shifts daemon.log -> daemon.log.1 -> ... and drops the oldest
+func (l *Logger) rotateLocked() error {
+	l.file.Close()
+	l.file = nil
+
+	os.Remove(fmt.Sprintf("%s.%d", l.path, logMaxFiles))
+	for i := logMaxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot rotate log file: %v", err)
+	}
+
+	return l.openLocked()
+}
+
+// log writes one entry if level is enabled
+func (l *Logger) log(level int, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if level > l.level {
+		return
+	}
+
+	message := redactSecrets("daemon.log", fmt.Sprintf(format, args...))
+
+	if l.echo != nil {
+		fmt.Fprintln(l.echo, message)
+	}
+
+	if l.file == nil {
+		return
+	}
+
+	entry := fmt.Sprintf("%s %-5s %s\n",
+		time.Now().Format(time.RFC3339), logLevelNames[level], strings.TrimSpace(message))
+
+	if l.size+int64(len(entry)) > logMaxSize {
+		if err := l.rotateLocked(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+	}
+
+	n, _ := l.file.WriteString(entry)
+	l.size += int64(n)
+}
+
+// logError logs a failure
+func logError(format string, args ...interface{}) {
+	daemonLog.log(logLevelError, format, args...)
+}
+
+// logInfo logs a normal daemon event
+func logInfo(format string, args ...interface{}) {
+	daemonLog.log(logLevelInfo, format, args...)
+}
+
+// logDebug logs per-request detail
+func logDebug(format string, args ...interface{}) {
+	daemonLog.log(logLevelDebug, format, args...)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// logCapture collects what the logger echoes; daemon goroutines may log
+// while the test reads
+type logCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *logCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+func (c *logCapture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String()
+}
+
+// captureDaemonLog logs at level to daemon.log and to the returned capture
+// until the test ends
+func captureDaemonLog(t *testing.T, level string) *logCapture {
+	t.Helper()
+	daemonLog.mu.Lock()
+	savedLevel, echo, path, file, size := daemonLog.level, daemonLog.echo, daemonLog.path, daemonLog.file, daemonLog.size
+	daemonLog.mu.Unlock()
+
+	capture := &logCapture{}
+	if err := initDaemonLog(level, true, capture); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		daemonLog.mu.Lock()
+		defer daemonLog.mu.Unlock()
+		if daemonLog.file != nil {
+			daemonLog.file.Close()
+		}
+		daemonLog.level, daemonLog.echo, daemonLog.path = savedLevel, echo, path
+		daemonLog.file, daemonLog.size = file, size
+	})
+	return capture
+}
+
+// At debug level the daemon logs every request, and still no password,
+// nsec, key or token reaches the log
+func TestDaemonLogNoSecrets(t *testing.T) {
+	testHome(t)
+	t.Setenv(redactPanicEnv, "")
+	capture := captureDaemonLog(t, "debug")
+
+	const (
+		wrongPassword = "Wrong-Horse-Battery-7"
+		clientToken   = "c2VjcmV0LWNsaWVudC10b2tlbg"
+	)
+	other := addTestAccount(t, "")
+	active := addTestAccount(t, "")
+	newKey, newNpub := testKey(t)
+	newKeyBytes, _ := hex.DecodeString(newKey)
+	newNsec, err := encodeBech32Key("nsec", newKeyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := testDaemon(t, active)
+	serveTestDaemon(t, d)
+
+	requests := []SignRequest{
+		{Method: "add_account", Nsec: newNsec, Password: testPassword},
+		{Method: "add_account", Nsec: newNsec + "x", Password: testPassword},
+		{Method: "unlock_account", Npub: other, Password: wrongPassword},
+		{Method: "unlock_account", Npub: other, Password: testPassword},
+		{Method: "switch_account", Npub: newNpub, Password: testPassword},
+		{Method: "sign_event", EventJSON: `{"kind":1,"content":"hi","tags":[],"created_at":1700000000}`},
+	}
+	for _, req := range requests {
+		req.ID = req.Method
+		var response SignResponse
+		if err := dialTestDaemon(t).request(t, req, &response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// With require_auth an unknown token is logged, masked
+	appConfig.RequireAuth = true
+	var response SignResponse
+	if err := dialTestDaemon(t).request(t, SignRequest{ID: "auth", Method: "sign_event", Token: clientToken}, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != codeUnauthorized {
+		t.Fatalf("request with an unknown token: %+v", response)
+	}
+
+	// A secret passed to the logger by mistake is redacted as well
+	privateKey, hexKey := loadTestKey(t)
+	privateKey.Zero()
+	logDebug("decoding %s", newNsec)
+	logDebug("request %s", fmt.Sprintf(`{"method":"unlock_account","password":%q,"token":%q}`, testPassword, clientToken))
+	logDebug("key %s", hexKey)
+
+	logFile, err := getLogFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, output := range map[string]string{"echo": capture.String(), "daemon.log": string(written)} {
+		if !strings.Contains(output, `method="unlock_account"`) || !strings.Contains(output, "decoding [REDACTED") {
+			t.Fatalf("%s lacks the debug entries:\n%s", name, output)
+		}
+		for secretName, value := range map[string]string{
+			"nsec":           newNsec,
+			"hex key":        newKey,
+			"loaded hex key": hexKey,
+			"password":       testPassword,
+			"wrong password": wrongPassword,
+			"client token":   clientToken,
+		} {
+			if strings.Contains(strings.ToLower(output), strings.ToLower(value)) {
+				t.Errorf("%s contains the %s:\n%s", name, secretName, output)
+			}
+		}
+	}
+}
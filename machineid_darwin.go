@@ -0,0 +1,23 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// platformMachineID returns the IOPlatformUUID of the Mac
+func platformMachineID() (string, error) {
+	output, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return "", fmt.Errorf("ioreg: %v", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if _, value, ok := strings.Cut(line, `"IOPlatformUUID" = `); ok {
+			return strings.Trim(strings.TrimSpace(value), `"`), nil
+		}
+	}
+	return "", fmt.Errorf("no IOPlatformUUID")
+}
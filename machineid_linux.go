@@ -0,0 +1,21 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// platformMachineID returns systemd's machine-id, or D-Bus's copy of it on
+// systems without systemd
+func platformMachineID() (string, error) {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		content, err := os.ReadFile(path)
+		if id := strings.TrimSpace(string(content)); err == nil && id != "" {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no machine-id")
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "errors"
+
+// platformMachineID has no source on other systems; the install secret
+// alone binds trust sessions there
+func platformMachineID() (string, error) {
+	return "", errors.New("no machine ID on this system")
+}
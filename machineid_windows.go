@@ -0,0 +1,18 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows/registry"
+
+// platformMachineID returns the MachineGuid Windows sets up on install
+func platformMachineID() (string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Cryptography`,
+		registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return "", err
+	}
+	defer key.Close()
+
+	id, _, err := key.GetStringValue("MachineGuid")
+	return id, err
+}
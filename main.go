@@ -1,14 +1,29 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 )
 
 func main() {
-	// Run migration from old single-account format if needed
+	// A browser starts us with its own arguments (see nativehost.go), and
+	// stdout then carries native messages only
+	if len(os.Args) >= 2 && isNativeHostInvocation(os.Args[1]) {
+		os.Args = append([]string{os.Args[0], "native-host"}, os.Args[1:]...)
+	}
+	parseGlobalFlags()
+	if len(os.Args) >= 2 && os.Args[1] == "native-host" {
+		redirectChatter()
+	}
+
+	// Run migration from old single-account format if needed. It reports on
+	// stderr: stdout may be a --json document or a signed event.
 	if err := migrateToMultiAccount(); err != nil {
-		fmt.Printf("Migration warning: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Migration warning: %v\n", err)
 	}
 
 	if len(os.Args) < 2 {
@@ -17,73 +32,307 @@ func main() {
 	}
 
 	command := os.Args[1]
+
+	if jsonOutput && !jsonCommands[command] {
+		exitOnError(commandFailed(2, "--json is not supported by '%s'", command))
+	}
+
+	// Shared by CLI commands and the daemon; the daemon logs problems itself
+	appConfig, appConfigErr = loadConfig()
+	if command != "daemon" {
+		printConfigWarnings()
+	}
+
+	exitOnError(runCommand(command, os.Args[2:]))
+}
+
+// runCommand runs a CLI command. Commands that fail return an error, and
+// main alone decides how the process exits.
+func runCommand(command string, args []string) error {
+	prompt := terminalPrompter{}
+
 	switch command {
 	case "init":
 		// Backwards compatibility: init = add-account for first account
 		accounts, _ := listAccounts()
 		if len(accounts) > 0 {
-			fmt.Println("Account already exists. Use 'add-account' to add more accounts.")
-			fmt.Printf("Current accounts: %d\n", len(accounts))
-			os.Exit(1)
+			return commandFailed(1, "Account already exists. Use 'add-account' to add more accounts.\nCurrent accounts: %d", len(accounts))
 		}
-		addAccount()
+		return addAccount(prompt, "")
 	case "add-account":
-		addAccount()
+		label := ""
+		if len(args) == 2 && args[0] == "--label" {
+			label = args[1]
+		} else if len(args) != 0 {
+			return commandFailed(1, "Usage: noorsigner add-account [--label <name>]")
+		}
+		return addAccount(prompt, label)
 	case "list-accounts":
-		listAccountsCmd()
+		refresh := false
+		if len(args) == 1 && args[0] == "--refresh" {
+			refresh = true
+		} else if len(args) != 0 {
+			return commandFailed(1, "Usage: noorsigner list-accounts [--refresh]")
+		}
+		return listAccountsCmd(refresh)
+	case "profile":
+		return profileCmd(args)
+	case "relays":
+		return relaysCmd(args)
+	case "label":
+		if len(args) != 2 {
+			return commandFailed(1, "Usage: noorsigner label <account> <name>  (\"\" removes the label)")
+		}
+		return labelCmd(args[0], args[1])
 	case "switch":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: noorsigner switch <npub>")
-			os.Exit(1)
+		npub, err := npubArg(args, "noorsigner switch <account> "+passwordFlagsUsage)
+		if err != nil {
+			return err
 		}
-		switchAccount(os.Args[2])
+		return switchAccount(prompt, npub)
 	case "remove-account":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: noorsigner remove-account <npub>")
-			os.Exit(1)
+		npub, err := npubArg(args, "noorsigner remove-account <account> "+passwordFlagsUsage)
+		if err != nil {
+			return err
 		}
-		removeAccountCmd(os.Args[2])
+		return removeAccountCmd(prompt, npub)
 	case "daemon":
-		startDaemon()
+		return startDaemon(prompt, args)
+	case "autostart":
+		return autostartCmd(args)
+	case "status":
+		return statusCmd(args)
+	case "ping":
+		return pingCmd(args)
+	case "whoami":
+		return whoamiCmd(args)
+	case "authorize":
+		return authorizeCmd(args)
+	case "clients":
+		return clientsCmd(args)
+	case "freeze":
+		return freezeCmd(prompt, args)
+	case "unfreeze":
+		return unfreezeCmd(prompt, args)
+	case "config":
+		return configCmd(args)
+	case "pending":
+		return pendingCmd()
+	case "respond":
+		if len(args) < 1 {
+			return commandFailed(1, "Usage: noorsigner respond <nonce>")
+		}
+		return respondCmd(args[0])
+	case "approve", "deny", "reject":
+		remember := command == "approve" && len(args) == 2 && args[1] == "--remember"
+		if len(args) != 1 && !remember {
+			return commandFailed(1, "Usage: noorsigner %s <id>", command)
+		}
+		return approveCmd(args[0], command == "approve", remember)
+	case "permissions":
+		return permissionsCmd(args)
+	case "policy":
+		return policyCmd(args)
 	case "sign":
-		signWithStoredKey()
+		return signCmd(args)
+	case "zap":
+		return zapCmd(args)
+	case "decrypt":
+		return decryptCmd(args)
+	case "delegate":
+		return delegateCmd(args)
+	case "verify-delegation":
+		return verifyDelegationCmd(args)
+	case "dm":
+		return dmCmd(args)
 	case "test-daemon":
-		testDaemonSigning()
+		return testDaemonSigning()
+	case "conformance":
+		return conformanceCmd(args)
+	case "selftest":
+		return selftestCmd(args)
+	case "drain":
+		return drainCmd(args)
+	case "doctor":
+		return doctorCmd(args)
+	case "recover":
+		return recoverCmd(args)
+	case "storage":
+		return storageCmd(args)
+	case "connect":
+		return connectCmd(args)
+	case "bunker":
+		return bunkerCmd(args)
+	case "native-host":
+		return nativeHostCmd(args)
+	case "install-native-host":
+		return installNativeHostCmd(args)
+	case "grant":
+		return grantCmd(args)
+	case "audit":
+		return auditCmd(args)
+	case "receipts":
+		return receiptsCmd(args)
+	case "fido":
+		return fidoCmd(prompt, args)
+	case "version":
+		return versionCmd(args)
 	case "test":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: noorsigner test <nsec>")
-			os.Exit(1)
+		if len(args) < 1 {
+			return commandFailed(1, "Usage: noorsigner test <nsec>")
 		}
-		nsec := os.Args[2]
-		testSigning(nsec)
+		return testSigning(args[0])
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		printUsage()
-		os.Exit(1)
+		return &commandError{Code: 1}
+	}
+}
+
+// npubArg parses the arguments of a command that takes one account (npub
+// or label) and the password flags
+func npubArg(args []string, usage string) (string, error) {
+	args, err := parsePasswordFlags(args)
+	if err != nil {
+		return "", commandFailed(1, "%v\nUsage: %s", err, usage)
+	}
+	if len(args) != 1 {
+		return "", commandFailed(1, "Usage: %s", usage)
+	}
+	npub, err := resolveAccount(args[0])
+	if err != nil {
+		return "", commandFailed(1, "%v\nUse 'list-accounts' to see available accounts.", err)
+	}
+	return npub, nil
+}
+
+// jsonCommands are the commands that support the global --json flag
+var jsonCommands = map[string]bool{
+	"list-accounts":     true,
+	"status":            true,
+	"ping":              true,
+	"whoami":            true,
+	"audit":             true,
+	"sign":              true,
+	"zap":               true,
+	"verify-delegation": true,
+	"test-daemon":       true,
+}
+
+// parseGlobalFlags consumes flags given before the command from os.Args
+func parseGlobalFlags() {
+	for len(os.Args) >= 2 && strings.HasPrefix(os.Args[1], "--") {
+		flag, rest := os.Args[1], os.Args[2:]
+
+		switch {
+		case flag == "--json":
+			enableJSONOutput()
+		case flag == "--home" || strings.HasPrefix(flag, "--home="):
+			// Relocates the storage directory; passed on via the
+			// environment so the forked daemon inherits it
+			home := strings.TrimPrefix(flag, "--home=")
+			if flag == "--home" {
+				if len(rest) == 0 {
+					fmt.Println("Usage: noorsigner --home <dir> <command>")
+					os.Exit(1)
+				}
+				home, rest = rest[0], rest[1:]
+			}
+			os.Setenv(storageHomeEnv, home)
+		default:
+			return // Not a global flag - leave it for the command
+		}
+
+		os.Args = append([]string{os.Args[0]}, rest...)
 	}
 }
 
 func printUsage() {
-	fmt.Println("Usage: noorsigner <command>")
+	fmt.Println("Usage: noorsigner [--home <dir>] [--json] <command>")
+	fmt.Println()
+	fmt.Println("  --home <dir>    - Use <dir> instead of ~/.noorsigner (or set NOORSIGNER_HOME)")
+	fmt.Println("  --json          - One JSON document on stdout (list-accounts, status, ping, whoami, sign, zap, verify-delegation, test-daemon, audit)")
+	fmt.Println()
+	fmt.Println("sign, zap, delegate, dm send, switch, remove-account and daemon read the password without a prompt from")
+	fmt.Println("--password-file <path>, --password-fd <n> or $NOORSIGNER_PASSWORD (in that order).")
 	fmt.Println()
 	fmt.Println("Account Management:")
-	fmt.Println("  add-account     - Add a new account (nsec + password)")
-	fmt.Println("  list-accounts   - List all stored accounts")
-	fmt.Println("  switch <npub>   - Switch to a different account")
-	fmt.Println("  remove-account <npub> - Remove an account")
+	fmt.Println("  add-account [--label <name>] - Add a new account (nsec + password)")
+	fmt.Println("  list-accounts [--refresh] - List all stored accounts (--refresh: fetch their profile names first)")
+	fmt.Println("  profile [account] - Fetch and show the kind 0 profile of one account, or of all")
+	fmt.Println("  relays list|add <url> [--read|--write]|remove <url> - The active account's relay list")
+	fmt.Println("  whoami          - Show the active account and whether the daemon is unlocked for it (exit 1 without one)")
+	fmt.Println("  label <account> <name> - Name an account, so commands accept the name for the npub (\"\" removes it)")
+	fmt.Println("  switch <account> - Switch to a different account")
+	fmt.Println("  remove-account <account> - Remove an account")
+	fmt.Println("  <account> is an npub, a label, a number from list-accounts or an npub prefix (8+ characters)")
+	fmt.Println("  fido enroll <npub> - Unlock an account with a FIDO2 security key tap (hmac-secret, optional PIN)")
+	fmt.Println("  fido unlock [nonce] - Answer the daemon's credential request with the security key")
+	fmt.Println("  fido password on|off <npub> - Allow or refuse the password as a fallback to the security key")
+	fmt.Println("  fido remove <npub> | fido status - Remove an enrollment or list enrolled accounts")
 	fmt.Println()
 	fmt.Println("Daemon:")
-	fmt.Println("  daemon          - Start signing daemon")
+	fmt.Println("  daemon [--foreground] [--no-trust] [--ask] [--strict] [--http 127.0.0.1:PORT [--unsafe-bind]] - Start signing daemon (-f: don't fork, log to stderr; --no-trust: no cached session; --ask: y/N on the terminal before each signature; --strict: strict mode; --http: also serve over HTTP with a bearer token)")
+	fmt.Println("  autostart enable [--dry-run] [--force]|disable|status - Manage daemon autostart on login")
+	fmt.Println("  status [--verbose] [--stats] - Show daemon status and effective configuration (--verbose: why the daemon locked, --stats: request counts, errors and latency)")
+	fmt.Println("  ping            - Check the daemon answers (exit 0 unlocked, 1 unreachable, 2 locked)")
+	fmt.Println("  drain [--timeout 30s] - Finish in-flight requests, reject new ones, then stop the daemon")
+	fmt.Println("  pending         - List credential requests and requests held by the signing policy")
+	fmt.Println("  respond <nonce> - Enter the password for a pending credential request")
+	fmt.Println("  approve|reject <id> - Confirm or refuse a request held by the signing policy (deny works too)")
+	fmt.Println("  approve <id> --remember - Confirm it and let the app skip confirmation for the same method and kinds")
+	fmt.Println("  permissions list|revoke <id|all> - Show or revoke remembered per-app permissions")
+	fmt.Println()
+	fmt.Println("Signing Policy:")
+	fmt.Println("  policy          - Show what is allowed, denied or confirmed per event kind")
+	fmt.Println("  policy set <kind|default> allow|deny|confirm - Change it (applies to a running daemon at once)")
+	fmt.Println("  policy reset <kind|default> - Remove a kind's entry")
+	fmt.Println()
+	fmt.Println("Travel:")
+	fmt.Println("  freeze --until <date|duration> - Lock the signer and refuse every unlock until then")
+	fmt.Println("  unfreeze        - Lift a freeze early with the unfreeze passphrase")
+	fmt.Println()
+	fmt.Println("Client Authorization:")
+	fmt.Println("  authorize <name> - Create a client token for require_auth (printed once)")
+	fmt.Println("  clients list|revoke <name> - Show or revoke authorized clients")
+	fmt.Println("  connect <nostrconnect-uri> - Accept a NIP-46 client (e.g. a web app) for the active account")
+	fmt.Println("  bunker [--relay <url>]... [--perms <perm,...>] [--name <name>] - Print a one-time bunker:// URI for a NIP-46 app")
+	fmt.Println("  grant list|revoke <name> - Show or revoke NIP-46 clients")
+	fmt.Println("  install-native-host --browser chrome|firefox --extension-id <id>... - Let a browser extension sign through noorsigner")
+	fmt.Println("  native-host     - Native messaging host (started by the browser)")
+	fmt.Println()
+	fmt.Println("Configuration:")
+	fmt.Println("  config get [key] - Show effective settings (or one key)")
+	fmt.Println("  config set <key> <value> - Change a setting in config.json")
 	fmt.Println()
 	fmt.Println("Other:")
+	fmt.Println("  version [--verify] - Show version (--verify: full build attestation)")
 	fmt.Println("  init            - Initialize (alias for add-account, first account only)")
-	fmt.Println("  sign            - Sign event with stored key (requires password)")
+	fmt.Println("  sign [--file <path>] - Sign an unsigned event (stdin or file) with the active account")
+	fmt.Println("  sign --test     - Sign a test hash with stored key (requires password)")
+	fmt.Println("  zap --to <npub> --amount <msats> --relay <url> - Sign a NIP-57 zap request (--lnurl, --event, --comment)")
+	fmt.Println("  delegate <npub|hex> --kinds <k,k...> --until <time> [--since <time>] - Sign a NIP-26 delegation to another key and print its tag")
+	fmt.Println("  verify-delegation '<tag JSON>' <delegatee> | --event <path|-> - Check a NIP-26 delegation tag (exit 0 valid, 1 not)")
+	fmt.Println("  dm send <npub|hex> <message> - Send a NIP-17 direct message to the recipient's DM relays, with a copy for you")
+	fmt.Println("  decrypt <sender_pubkey> <payload> - Decrypt a NIP-44 payload via daemon")
+	fmt.Println("  decrypt --batch-file <file|-> - Decrypt JSON lines ({payload, sender_pubkey}) via daemon")
 	fmt.Println("  test-daemon     - Test signing via daemon")
+	fmt.Println("  conformance [--socket <path> | --ws <url> --token <token>] - Run protocol conformance suite against a daemon")
+	fmt.Println("  selftest        - Check signing and encryption against go-nostr with a throwaway key")
+	fmt.Println("  doctor [--fix]  - Check the storage directory and socket (--fix: repair what is safe to repair)")
+	fmt.Println("  recover <npub>  - Check and repair an account offline, step by step (daemon must be stopped)")
+	fmt.Println("  storage [migrate --to sqlite|files] - Show the storage backend or migrate to the other one (daemon must be stopped)")
+	fmt.Println("  audit [--since <duration|date>] [--limit <n>] - Show the newest audit.log entries (audit_log must be on)")
+	fmt.Println("  receipts [list|verify] - Show the signed daily receipts, or check them against audit.log")
 	fmt.Println("  test <nsec>     - Test signing with direct nsec input")
 }
 
-// addAccount adds a new account
-func addAccount() {
+// addAccount adds a new account, labeled label unless it is ""
+func addAccount(prompt prompter, label string) error {
+	if err := checkNewAccountLabel(label); err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
 	fmt.Println("🔐 Add Account")
 	fmt.Println("Setting up secure nsec storage with password protection")
 	fmt.Println()
@@ -91,34 +340,33 @@ func addAccount() {
 	// Get nsec from user (masked like password)
 	fmt.Println("Enter your nsec (nsec1... or hex):")
 	fmt.Println("(Input is hidden for security - paste and press Enter)")
-	nsec, err := readPassword("")
+	nsec, err := prompt.readPassword("")
 	if err != nil {
-		fmt.Printf("Error reading nsec: %v\n", err)
-		os.Exit(1)
+		return commandFailed(1, "Error reading nsec: %v", err)
 	}
 
 	// Validate nsec format and get npub
 	privateKey, err := nsecToPrivateKey(nsec)
 	if err != nil {
-		fmt.Printf("Invalid nsec format: %v\n", err)
-		os.Exit(1)
+		return commandFailed(1, "Invalid nsec format: %v", err)
+	}
+	npub, err := privateKeyToNpub(privateKey)
+	if err != nil {
+		return commandFailed(1, "Invalid nsec format: %v", err)
 	}
-	npub := privateKeyToNpub(privateKey)
 
 	// Check if account already exists
 	if accountExists(npub) {
-		fmt.Printf("Account already exists: %s\n", npub)
-		os.Exit(1)
+		return commandFailed(1, "Account already exists: %s", displayNpub(npub))
 	}
 
 	// Get password (loop until valid)
 	var password1 string
 	for {
 		var err error
-		password1, err = readPassword("Enter password for encryption: ")
+		password1, err = prompt.readPassword("Enter password for encryption: ")
 		if err != nil {
-			fmt.Printf("Error reading password: %v\n", err)
-			os.Exit(1)
+			return commandFailed(1, "Error reading password: %v", err)
 		}
 
 		if len(password1) < 8 {
@@ -127,10 +375,9 @@ func addAccount() {
 			continue
 		}
 
-		password2, err := readPassword("Confirm password: ")
+		password2, err := prompt.readPassword("Confirm password: ")
 		if err != nil {
-			fmt.Printf("Error reading password confirmation: %v\n", err)
-			os.Exit(1)
+			return commandFailed(1, "Error reading password confirmation: %v", err)
 		}
 
 		if password1 != password2 {
@@ -146,113 +393,165 @@ func addAccount() {
 	// Encrypt nsec
 	encryptedKey, err := encryptNsec(nsec, password1)
 	if err != nil {
-		fmt.Printf("Error encrypting nsec: %v\n", err)
-		os.Exit(1)
+		return commandFailed(1, "Error encrypting nsec: %v", err)
 	}
 
 	// Save to account directory
 	err = saveAccountEncryptedKey(npub, encryptedKey)
 	if err != nil {
-		fmt.Printf("Error saving encrypted key: %v\n", err)
-		os.Exit(1)
+		return commandFailed(1, "Error saving encrypted key: %v", err)
+	}
+
+	if label != "" {
+		if err := setAccountLabel(npub, label); err != nil {
+			fmt.Printf("⚠️  Could not label the account: %v\n", err)
+		}
 	}
 
 	// Set as active account
 	err = saveActiveAccount(npub)
 	if err != nil {
-		fmt.Printf("Error setting active account: %v\n", err)
-		os.Exit(1)
+		return commandFailed(1, "Error setting active account: %v", err)
 	}
 
+	auditCLI("add_account", npub, nil, nil)
+
 	fmt.Println()
 	fmt.Println("✅ Account added successfully!")
 	fmt.Printf("Your npub: %s\n", npub)
 	fmt.Println("This account is now active.")
 
-	accountDir, _ := getAccountDir(npub)
-	fmt.Printf("Encrypted key saved to: %s\n", accountDir)
+	if store, err := accountStore(); err == nil {
+		fmt.Printf("Encrypted key saved to: %s\n", store.Location(npub))
+	}
+	return nil
 }
 
 // listAccountsCmd lists all stored accounts
-func listAccountsCmd() {
+func listAccountsCmd(refresh bool) error {
 	accounts, err := listAccounts()
 	if err != nil {
-		fmt.Printf("Error listing accounts: %v\n", err)
-		os.Exit(1)
+		return commandFailed(1, "Error listing accounts: %v", err)
+	}
+	if refresh && len(accounts) > 0 {
+		refreshProfiles(accounts)
+		if accounts, err = listAccounts(); err != nil {
+			return commandFailed(1, "Error listing accounts: %v", err)
+		}
+	}
+
+	if jsonOutput {
+		activeNpub, _ := loadActiveAccount()
+		output := AccountsOutput{Accounts: []AccountOutput{}, ActiveNpub: activeNpub}
+		for _, acc := range accounts {
+			account := AccountOutput{
+				Npub:      acc.Npub,
+				Pubkey:    acc.Pubkey,
+				KeyID:     npubKeyID(acc.Npub),
+				CreatedAt: acc.CreatedAt.Unix(),
+				Label:     acc.Label,
+				Profile:   acc.Profile,
+				Active:    acc.Npub == activeNpub,
+			}
+			if health, err := loadAccountHealth(acc.Npub); err == nil {
+				account.HealthWarning = health.Warning
+			}
+			if counters, err := loadAccountCounters(acc.Npub); err == nil {
+				account.Counters = counters
+			}
+			output.Accounts = append(output.Accounts, account)
+		}
+		printJSON(output)
+		return nil
 	}
 
 	if len(accounts) == 0 {
 		fmt.Println("No accounts found. Use 'add-account' to add one.")
-		return
+		return nil
 	}
 
 	activeNpub, _ := loadActiveAccount()
 
 	fmt.Println("Stored accounts:")
 	fmt.Println()
-	for _, acc := range accounts {
+	for i, acc := range accounts {
 		marker := "  "
 		if acc.Npub == activeNpub {
 			marker = "* "
 		}
-		fmt.Printf("%s%s\n", marker, acc.Npub)
+		// Full npub - this is where users copy it from for switch/remove-account;
+		// the number selects the account too. The key ID matches the one
+		// shown next to a shortened npub.
+		line := fmt.Sprintf("%s%d. %s  key ID %s", marker, i+1, acc.Npub, npubKeyID(acc.Npub))
+		if acc.Label != "" {
+			line += fmt.Sprintf("  (%s)", acc.Label)
+		}
+		if name := acc.Profile.displayName(); name != "" {
+			line += " — " + name
+		}
+		fmt.Println(line)
+		if health, err := loadAccountHealth(acc.Npub); err == nil && health.Warning != "" {
+			fmt.Printf("    ⚠️  Key health check failed: %s\n", health.Warning)
+		}
+		if counters, err := loadAccountCounters(acc.Npub); err == nil && counters.LastUsed != 0 {
+			fmt.Printf("    Used: %d events signed, %d NIP-44 encryptions, %d NIP-44 decryptions (last %s)\n",
+				counters.EventsSigned, counters.Nip44Encrypts, counters.Nip44Decrypts,
+				time.Unix(counters.LastUsed, 0).Format("2006-01-02 15:04"))
+		}
 	}
 	fmt.Println()
 	fmt.Printf("Total: %d account(s)\n", len(accounts))
 	if activeNpub != "" {
 		fmt.Println("* = active account")
 	}
+	return nil
 }
 
 // switchAccount switches to a different account
-func switchAccount(npub string) {
+func switchAccount(prompt prompter, npub string) error {
 	// Check if account exists
 	if !accountExists(npub) {
-		fmt.Printf("Account not found: %s\n", npub)
-		fmt.Println("Use 'list-accounts' to see available accounts.")
-		os.Exit(1)
+		return commandFailed(1, "Account not found: %s\nUse 'list-accounts' to see available accounts.", displayNpub(npub))
+	}
+
+	if err := checkNotFrozen(); err != nil {
+		return commandFailed(1, "❄️  %v", err)
 	}
 
 	// Check if already active
 	activeNpub, _ := loadActiveAccount()
 	if activeNpub == npub {
 		fmt.Println("This account is already active.")
-		return
+		return nil
 	}
 
 	// Load and verify account can be decrypted
 	encKey, err := loadAccountEncryptedKey(npub)
 	if err != nil {
-		fmt.Printf("Error loading account: %v\n", err)
-		os.Exit(1)
+		return commandFailed(1, "Error loading account: %v", err)
 	}
 
-	// Ask for password to verify
-	password, err := readPassword("Enter password for this account: ")
-	if err != nil {
-		fmt.Printf("Error reading password: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Try to decrypt to verify password
-	nsec, err := decryptNsec(encKey, password)
-	if err != nil {
-		fmt.Println("❌ Invalid password!")
-		os.Exit(1)
-	}
-
-	// Verify nsec is valid
-	_, err = nsecToPrivateKey(nsec)
-	if err != nil {
-		fmt.Println("❌ Corrupted key file!")
-		os.Exit(1)
+	// A valid trust session unlocks the account without the password, as
+	// it would for the daemon
+	password := ""
+	session, privateKey, err := openAccountTrustSession(npub)
+	if err == nil {
+		privateKey.Zero()
+		fmt.Printf("🔓 Unlocked via Trust Mode - no password required (session expires: %s)\n",
+			session.ExpiresAt.Format("2006-01-02 15:04"))
+	} else {
+		if !errors.Is(err, errNoTrustSession) {
+			fmt.Printf("⚠️  Cannot use the trust session: %v\n", err)
+		}
+		if password, err = readSwitchPassword(prompt, npub, encKey); err != nil {
+			return err
+		}
 	}
 
 	// Set as active account (file)
 	err = saveActiveAccount(npub)
 	if err != nil {
-		fmt.Printf("Error setting active account: %v\n", err)
-		os.Exit(1)
+		return commandFailed(1, "Error setting active account: %v", err)
 	}
 
 	fmt.Println()
@@ -261,57 +560,94 @@ func switchAccount(npub string) {
 	if isDaemonRunning() {
 		fmt.Println("🔄 Daemon detected, switching live...")
 		err = switchAccountViaDaemon(npub, password)
+		if errors.Is(err, errPasswordRequired) {
+			// The daemon doesn't use trust sessions (--no-trust)
+			if password, err = readSwitchPassword(prompt, npub, encKey); err != nil {
+				return err
+			}
+			err = switchAccountViaDaemon(npub, password)
+		}
 		if err != nil {
 			fmt.Printf("⚠️  Could not switch daemon: %v\n", err)
 			fmt.Println("   Restart daemon manually: pkill noorsigner && noorsigner daemon")
 		} else {
-			fmt.Printf("✅ Switched to account: %s\n", npub)
+			fmt.Printf("✅ Switched to account: %s\n", displayNpub(npub))
 			fmt.Println("   Daemon updated - no restart needed!")
 		}
 	} else {
-		fmt.Printf("✅ Switched to account: %s\n", npub)
+		fmt.Printf("✅ Switched to account: %s\n", displayNpub(npub))
 		fmt.Println("   Daemon not running. Start with: noorsigner daemon")
 	}
+	return nil
+}
+
+// readSwitchPassword asks for npub's password and verifies it
+func readSwitchPassword(prompt prompter, npub string, encKey *EncryptedKey) (string, error) {
+	if err := checkLockout(npub); err != nil {
+		return "", commandFailed(1, "🚫 %v", err)
+	}
+
+	// Ask for password to verify
+	password, err := prompt.readAccountPassword("Enter password for this account: ")
+	if err != nil {
+		return "", commandFailed(1, "Error reading password: %v", err)
+	}
+
+	// Try to decrypt to verify password
+	_, privateKey, err := decryptAccountKey(encKey, npub, password)
+	if err != nil {
+		return "", commandFailed(1, "%s", passwordFailureMessage(err))
+	}
+	privateKey.Zero()
+	return password, nil
 }
 
 // removeAccountCmd removes an account
-func removeAccountCmd(npub string) {
+func removeAccountCmd(prompt prompter, npub string) error {
 	// Check if account exists
 	if !accountExists(npub) {
-		fmt.Printf("Account not found: %s\n", npub)
-		os.Exit(1)
+		return commandFailed(1, "Account not found: %s", displayNpub(npub))
+	}
+
+	// Verifying the password decrypts the key
+	if err := checkNotFrozen(); err != nil {
+		return commandFailed(1, "❄️  %v", err)
 	}
 
 	// Load account to verify password
 	encKey, err := loadAccountEncryptedKey(npub)
 	if err != nil {
-		fmt.Printf("Error loading account: %v\n", err)
-		os.Exit(1)
+		return commandFailed(1, "Error loading account: %v", err)
 	}
+	if err := checkLockout(npub); err != nil {
+		return commandFailed(1, "🚫 %v", err)
+	}
+
+	// Destructive - always show the full npub
+	fmt.Printf("Removing account: %s\n", npub)
 
 	// Ask for password to confirm
-	password, err := readPassword("Enter password to confirm removal: ")
+	password, err := prompt.readAccountPassword("Enter password to confirm removal: ")
 	if err != nil {
-		fmt.Printf("Error reading password: %v\n", err)
-		os.Exit(1)
+		return commandFailed(1, "Error reading password: %v", err)
 	}
 
 	// Verify password
-	_, err = decryptNsec(encKey, password)
+	_, privateKey, err := decryptAccountKey(encKey, npub, password)
 	if err != nil {
-		fmt.Println("❌ Invalid password!")
-		os.Exit(1)
+		return commandFailed(1, "%s", passwordFailureMessage(err))
 	}
+	privateKey.Zero()
 
 	// Remove account
 	err = removeAccount(npub)
+	auditCLI("remove_account", npub, err, nil)
 	if err != nil {
-		fmt.Printf("Error removing account: %v\n", err)
-		os.Exit(1)
+		return commandFailed(1, "Error removing account: %v", err)
 	}
 
 	fmt.Println()
-	fmt.Printf("✅ Account removed: %s\n", npub)
+	fmt.Printf("✅ Account removed: %s\n", displayNpub(npub))
 
 	// Check if there are remaining accounts
 	accounts, _ := listAccounts()
@@ -322,93 +658,108 @@ func removeAccountCmd(npub string) {
 		if err != nil || activeNpub == "" {
 			// Set first remaining account as active
 			saveActiveAccount(accounts[0].Npub)
-			fmt.Printf("Active account set to: %s\n", accounts[0].Npub)
+			fmt.Printf("Active account set to: %s\n", displayNpub(accounts[0].Npub))
 		}
 	}
+	return nil
+}
+
+// labelCmd sets or removes an account's label
+func labelCmd(selector, label string) error {
+	npub, err := resolveAccount(selector)
+	if err != nil {
+		return commandFailed(1, "%v\nUse 'list-accounts' to see available accounts.", err)
+	}
+	label = strings.TrimSpace(label)
+	err = setAccountLabel(npub, label)
+	auditCLI("set_label", npub, err, nil)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	if label == "" {
+		fmt.Printf("✅ Label removed from %s\n", displayNpub(npub))
+	} else {
+		fmt.Printf("✅ %s is now labeled %q\n", displayNpub(npub), label)
+	}
+	return nil
 }
 
 // initKeySigner is kept for backwards compatibility (calls addAccount)
 func initKeySigner() bool {
-	addAccount()
-	return true
+	return addAccount(terminalPrompter{}, "") == nil
 }
 
-func testSigning(nsec string) {
+func testSigning(nsec string) error {
 	fmt.Println("Testing key signer...")
 
 	// Convert nsec to private key
 	privateKey, err := nsecToPrivateKey(nsec)
 	if err != nil {
-		fmt.Printf("Error converting nsec: %v\n", err)
-		return
+		return commandFailed(1, "Error converting nsec: %v", err)
 	}
 
 	// Generate npub from private key
-	npub := privateKeyToNpub(privateKey)
+	npub, err := privateKeyToNpub(privateKey)
+	if err != nil {
+		return commandFailed(1, "Error converting nsec: %v", err)
+	}
 	fmt.Printf("Your npub: %s\n", npub)
 
 	// Create test event hash and sign it
 	testHash := generateTestEventHash()
 	signature, err := signNostrEvent(privateKey, testHash)
 	if err != nil {
-		fmt.Printf("Error signing: %v\n", err)
-		return
+		return commandFailed(1, "Error signing: %v", err)
 	}
 
 	fmt.Printf("Test signature: %s\n", signature)
 	fmt.Println("✅ Key signer working correctly!")
+	return nil
 }
 
-func signWithStoredKey() {
+// signWithStoredKey signs a fixed test hash (sign --test)
+func signWithStoredKey() error {
 	fmt.Println("🔐 Signing with stored key")
 
 	// Get active account
 	activeNpub, err := loadActiveAccount()
 	if err != nil {
-		fmt.Println("No active account. Use 'add-account' to add one.")
-		os.Exit(1)
-	}
-
-	// Load encrypted key for active account
-	encryptedKey, err := loadAccountEncryptedKey(activeNpub)
-	if err != nil {
-		fmt.Printf("Error loading key: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Get password
-	password, err := readPassword("Enter password: ")
-	if err != nil {
-		fmt.Printf("Error reading password: %v\n", err)
-		return
+		return commandFailed(1, "No active account. Use 'add-account' to add one.")
 	}
 
-	// Decrypt nsec
-	nsec, err := decryptNsec(encryptedKey, password)
+	privateKey, err := unlockActiveAccount(activeNpub)
 	if err != nil {
-		fmt.Println("❌ Invalid password or corrupted key file!")
-		return
+		return err
 	}
 
-	// Convert to private key
-	privateKey, err := nsecToPrivateKey(nsec)
+	// Show npub
+	npub, err := privateKeyToNpub(privateKey)
 	if err != nil {
-		fmt.Printf("Error with decrypted nsec: %v\n", err)
-		return
+		return commandFailed(1, "❌ Invalid key: %v", err)
 	}
-
-	// Show npub
-	npub := privateKeyToNpub(privateKey)
-	fmt.Printf("Signing as: %s\n", npub)
+	fmt.Printf("Signing as: %s\n", displayNpub(npub))
 
 	// Create test signature
 	testHash := generateTestEventHash()
 	signature, err := signNostrEvent(privateKey, testHash)
+	auditCLI("sign_test", npub, err, nil)
 	if err != nil {
-		fmt.Printf("Error signing: %v\n", err)
-		return
+		return commandFailed(1, "Error signing: %v", err)
+	}
+
+	if jsonOutput {
+		pubkey, _ := npubToPubkey(npub)
+		printJSON(SignOutput{
+			Npub:      npub,
+			Pubkey:    pubkey,
+			Hash:      hex.EncodeToString(testHash),
+			Signature: signature,
+		})
+		return nil
 	}
 
 	fmt.Printf("Test signature: %s\n", signature)
 	fmt.Println("✅ Signing successful!")
+	return nil
 }
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+const testPassword = "CorrectHorse9Battery!"
+
+// testBackend is the store testHome sets up. TestSuitesOnSQLite sets it to
+// storeSQLite to run the account and daemon tests on that backend too.
+var testBackend = storeFiles
+
+// testHome points the storage directory at a fresh temporary directory
+// for the rest of the test, with the default configuration
+func testHome(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv(storageHomeEnv, dir)
+
+	storeMu.Lock()
+	if sqlite, ok := cachedStore.(*sqliteStore); ok {
+		sqlite.close()
+	}
+	cachedStore, cachedPath = nil, ""
+	storeMu.Unlock()
+
+	if testBackend == storeSQLite {
+		dbPath, err := getSQLiteStorePath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		created, err := openSQLiteStore(dbPath, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		created.close()
+		// The database must be closed before the directory is removed
+		t.Cleanup(func() {
+			storeMu.Lock()
+			defer storeMu.Unlock()
+			if sqlite, ok := cachedStore.(*sqliteStore); ok && cachedPath == dbPath {
+				sqlite.close()
+				cachedStore, cachedPath = nil, ""
+			}
+		})
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	previous := appConfig
+	appConfig = config
+	t.Cleanup(func() { appConfig = previous })
+	return dir
+}
+
+// testKey returns a new private key as hex (add-account takes hex as
+// well as nsec) and its npub
+func testKey(t *testing.T) (string, string) {
+	t.Helper()
+	privateKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	npub, err := privateKeyToNpub(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(privateKey.Serialize()), npub
+}
+
+// scriptedPrompter answers prompts with its answers, in order, and records
+// the prompts it was asked
+type scriptedPrompter struct {
+	answers []string
+	prompts []string
+}
+
+func (p *scriptedPrompter) next(prompt string) (string, error) {
+	p.prompts = append(p.prompts, prompt)
+	if len(p.answers) == 0 {
+		return "", errors.New("unexpected prompt")
+	}
+	answer := p.answers[0]
+	p.answers = p.answers[1:]
+	return answer, nil
+}
+
+func (p *scriptedPrompter) readPassword(prompt string) (string, error) { return p.next(prompt) }
+func (p *scriptedPrompter) readAccountPassword(prompt string) (string, error) {
+	return p.next(prompt)
+}
+
+// addTestAccount adds a new account protected by testPassword and
+// returns its npub
+func addTestAccount(t *testing.T, label string) string {
+	t.Helper()
+	key, npub := testKey(t)
+	prompt := &scriptedPrompter{answers: []string{key, testPassword, testPassword}}
+	if err := addAccount(prompt, label); err != nil {
+		t.Fatalf("add-account: %v", err)
+	}
+	return npub
+}
+
+func activeAccount(t *testing.T) string {
+	t.Helper()
+	npub, err := loadActiveAccount()
+	if err != nil {
+		t.Fatalf("loadActiveAccount: %v", err)
+	}
+	return npub
+}
+
+func exitCode(err error) int {
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+func TestAddAccount(t *testing.T) {
+	testHome(t)
+
+	first := addTestAccount(t, "main")
+	if got := activeAccount(t); got != first {
+		t.Errorf("active account = %s, want the added %s", got, first)
+	}
+	second := addTestAccount(t, "")
+	if got := activeAccount(t); got != second {
+		t.Errorf("active account = %s, want the newest %s", got, second)
+	}
+
+	accounts, err := listAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 {
+		t.Fatalf("listAccounts returned %d accounts, want 2", len(accounts))
+	}
+	labels := map[string]string{}
+	for _, account := range accounts {
+		labels[account.Npub] = account.Label
+	}
+	if labels[first] != "main" || labels[second] != "" {
+		t.Errorf("labels = %v, want %s labeled main and %s unlabeled", labels, first, second)
+	}
+	if err := listAccountsCmd(false); err != nil {
+		t.Errorf("list-accounts: %v", err)
+	}
+}
+
+func TestAddAccountRefuses(t *testing.T) {
+	testHome(t)
+	key, npub := testKey(t)
+	prompt := &scriptedPrompter{answers: []string{key, testPassword, testPassword}}
+	if err := addAccount(prompt, ""); err != nil {
+		t.Fatalf("add-account: %v", err)
+	}
+
+	if err := setAccountLabel(npub, "work"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		label   string
+		answers []string
+	}{
+		{"existing account", "", []string{key}},
+		{"invalid nsec", "", []string{"nsec1notakey"}},
+		{"prompt fails", "", nil},
+		{"label taken", "work", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := addAccount(&scriptedPrompter{answers: tt.answers}, tt.label)
+			if exitCode(err) != 1 {
+				t.Errorf("add-account = %v, want exit code 1", err)
+			}
+		})
+	}
+}
+
+func TestAddAccountRetriesPassword(t *testing.T) {
+	testHome(t)
+	key, npub := testKey(t)
+	// Too short, then mismatched, then confirmed
+	prompt := &scriptedPrompter{answers: []string{key, "short", testPassword, "other password", testPassword, testPassword}}
+	if err := addAccount(prompt, ""); err != nil {
+		t.Fatalf("add-account: %v", err)
+	}
+	if len(prompt.answers) != 0 {
+		t.Errorf("%d answers left over", len(prompt.answers))
+	}
+	encKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := decryptAccountKey(encKey, npub, testPassword); err != nil {
+		t.Errorf("the confirmed password doesn't decrypt the key: %v", err)
+	}
+}
+
+func TestSwitchAccount(t *testing.T) {
+	testHome(t)
+	first := addTestAccount(t, "")
+	second := addTestAccount(t, "")
+
+	if err := switchAccount(&scriptedPrompter{answers: []string{testPassword}}, first); err != nil {
+		t.Fatalf("switch: %v", err)
+	}
+	if got := activeAccount(t); got != first {
+		t.Errorf("active account = %s, want %s", got, first)
+	}
+
+	// Already active: no password asked
+	if err := switchAccount(&scriptedPrompter{}, first); err != nil {
+		t.Errorf("switch to the active account: %v", err)
+	}
+
+	err := switchAccount(&scriptedPrompter{answers: []string{"wrong password"}}, second)
+	if exitCode(err) != 1 {
+		t.Errorf("switch with a wrong password = %v, want exit code 1", err)
+	}
+	if got := activeAccount(t); got != first {
+		t.Errorf("a failed switch changed the active account to %s", got)
+	}
+
+	_, unknown := testKey(t)
+	if err := switchAccount(&scriptedPrompter{}, unknown); exitCode(err) != 1 {
+		t.Errorf("switch to an unknown account = %v, want exit code 1", err)
+	}
+}
+
+func TestRemoveAccount(t *testing.T) {
+	testHome(t)
+	first := addTestAccount(t, "")
+	second := addTestAccount(t, "")
+
+	err := removeAccountCmd(&scriptedPrompter{answers: []string{"wrong password"}}, second)
+	if exitCode(err) != 1 {
+		t.Errorf("remove-account with a wrong password = %v, want exit code 1", err)
+	}
+	if !accountExists(second) {
+		t.Fatal("a wrong password removed the account")
+	}
+
+	// Removing the active account makes the remaining one active
+	if err := removeAccountCmd(&scriptedPrompter{answers: []string{testPassword}}, second); err != nil {
+		t.Fatalf("remove-account: %v", err)
+	}
+	if accountExists(second) {
+		t.Error("the account still exists after remove-account")
+	}
+	if got := activeAccount(t); got != first {
+		t.Errorf("active account = %s, want the remaining %s", got, first)
+	}
+
+	if err := removeAccountCmd(&scriptedPrompter{answers: []string{testPassword}}, first); err != nil {
+		t.Fatalf("remove-account: %v", err)
+	}
+	accounts, err := listAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 0 {
+		t.Errorf("%d accounts left, want none", len(accounts))
+	}
+	if err := removeAccountCmd(&scriptedPrompter{}, first); exitCode(err) != 1 {
+		t.Errorf("removing a removed account = %v, want exit code 1", err)
+	}
+}
+
+func TestRunCommandUsage(t *testing.T) {
+	testHome(t)
+	tests := [][]string{
+		{"add-account", "extra"},
+		{"list-accounts", "--bogus"},
+		{"switch"},
+		{"remove-account"},
+		{"label", "1"},
+	}
+	for _, args := range tests {
+		t.Run(fmt.Sprint(args), func(t *testing.T) {
+			if err := runCommand(args[0], args[1:]); exitCode(err) != 1 {
+				t.Errorf("runCommand = %v, want exit code 1", err)
+			}
+		})
+	}
+}
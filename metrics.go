@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMetricsInterval is how often the textfile is rewritten
+const defaultMetricsInterval = 15 * time.Second
+
+// metricsMaxMethods caps distinct method labels so clients sending garbage
+// method names can't blow up the series count
+const metricsMaxMethods = 64
+
+// daemonMetrics holds counters updated on the request path. Updates are a
+// map increment under a mutex; rendering happens off the request path.
+type daemonMetrics struct {
+	startTime time.Time
+
+	mu       sync.Mutex
+	requests map[string]uint64
+	errors   map[string]uint64 // Error responses by code
+
+	latency latencyHistogram
+}
+
+// countRequest records one request for method
+func (m *daemonMetrics) countRequest(method string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.requests == nil {
+		m.requests = make(map[string]uint64)
+	}
+	if _, known := m.requests[method]; !known && len(m.requests) >= metricsMaxMethods {
+		method = "other"
+	}
+	m.requests[method]++
+}
+
+// countError records one error response with code ("other" if it has none)
+func (m *daemonMetrics) countError(code string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.errors == nil {
+		m.errors = make(map[string]uint64)
+	}
+	if _, known := m.errors[code]; code == "" || !known && len(m.errors) >= metricsMaxMethods {
+		code = "other"
+	}
+	m.errors[code]++
+}
+
+// renderMetrics returns the daemon metrics in Prometheus text exposition format
+func (d *Daemon) renderMetrics() string {
+	var b strings.Builder
+
+	writeMetric := func(name, help, kind string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	d.mu.RLock()
+	unlocked := 0
+	if d.privateKey != nil {
+		unlocked = 1
+	}
+	d.mu.RUnlock()
+
+	d.subMu.Lock()
+	subscribers := len(d.subscribers)
+	d.subMu.Unlock()
+
+	writeMetric("noorsigner_build_info", "Build information of the running daemon.", "gauge")
+	fmt.Fprintf(&b, "noorsigner_build_info{version=\"%s\"} 1\n", escapeLabelValue(version))
+
+	writeMetric("noorsigner_start_time_seconds", "Unix time the daemon started.", "gauge")
+	fmt.Fprintf(&b, "noorsigner_start_time_seconds %d\n", d.metrics.startTime.Unix())
+
+	writeMetric("noorsigner_unlocked", "Whether the daemon currently holds a key (1) or is locked (0).", "gauge")
+	fmt.Fprintf(&b, "noorsigner_unlocked %d\n", unlocked)
+
+	writeMetric("noorsigner_connections_total", "Client connections accepted.", "counter")
+	fmt.Fprintf(&b, "noorsigner_connections_total %d\n", d.nextConnID.Load())
+
+	connections := d.connections.stats()
+	writeMetric("noorsigner_connections_open", "Connections being served.", "gauge")
+	fmt.Fprintf(&b, "noorsigner_connections_open %d\n", connections.Current)
+
+	writeMetric("noorsigner_connections_rejected_total", "Connections turned away because max_connections was reached.", "counter")
+	fmt.Fprintf(&b, "noorsigner_connections_rejected_total %d\n", connections.Rejected)
+
+	writeMetric("noorsigner_stream_subscribers", "Clients subscribed to the event stream.", "gauge")
+	fmt.Fprintf(&b, "noorsigner_stream_subscribers %d\n", subscribers)
+
+	d.metrics.mu.Lock()
+	methods := make([]string, 0, len(d.metrics.requests))
+	for method := range d.metrics.requests {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	writeMetric("noorsigner_requests_total", "Requests received, by method.", "counter")
+	for _, method := range methods {
+		fmt.Fprintf(&b, "noorsigner_requests_total{method=\"%s\"} %d\n",
+			escapeLabelValue(method), d.metrics.requests[method])
+	}
+	d.metrics.mu.Unlock()
+
+	// Lets dashboards detect a daemon that died without cleaning up the file
+	writeMetric("noorsigner_textfile_timestamp_seconds", "Unix time this file was written.", "gauge")
+	fmt.Fprintf(&b, "noorsigner_textfile_timestamp_seconds %d\n", time.Now().Unix())
+
+	return b.String()
+}
+
+// escapeLabelValue escapes a Prometheus label value
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+// metricsTextfileLoop periodically writes metrics for node_exporter's
+// textfile collector. Writes go through a rename so the collector never
+// reads a partial file.
+func (d *Daemon) metricsTextfileLoop(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := writeFileAtomic(path, []byte(d.renderMetrics()), 0644); err != nil {
+			logError("⚠️  Cannot write metrics textfile: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// parseMetricsInterval parses the textfile write interval (empty = default)
+func parseMetricsInterval(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultMetricsInterval, nil
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid metrics_interval %q: %v", value, err)
+	}
+	if interval < time.Second {
+		return 0, fmt.Errorf("metrics_interval must be at least 1s")
+	}
+	return interval, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// metricSample is one sample line of the exposition
+type metricSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	metricTypes       = map[string]bool{"counter": true, "gauge": true, "histogram": true, "summary": true, "untyped": true}
+)
+
+// parseExposition parses the Prometheus text format strictly: every
+// metric has HELP then TYPE before its samples, once; label values are
+// properly escaped; no series repeats. It returns the types by name and
+// the samples.
+func parseExposition(t *testing.T, text string) (map[string]string, []metricSample) {
+	t.Helper()
+	if !strings.HasSuffix(text, "\n") {
+		t.Fatal("exposition does not end with a newline")
+	}
+	types := make(map[string]string)
+	helped := make(map[string]bool)
+	series := make(map[string]bool)
+	var samples []metricSample
+	current := ""
+
+	for n, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		fail := func(format string, args ...any) {
+			t.Helper()
+			t.Fatalf("line %d %q: %s", n+1, line, fmt.Sprintf(format, args...))
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 4 || !metricNamePattern.MatchString(fields[2]) || fields[3] == "" {
+				fail("malformed comment")
+			}
+			name := fields[2]
+			switch fields[1] {
+			case "HELP":
+				if helped[name] || types[name] != "" {
+					fail("HELP repeated or after TYPE")
+				}
+				helped[name] = true
+			case "TYPE":
+				if !helped[name] || types[name] != "" || !metricTypes[fields[3]] {
+					fail("TYPE repeated, unknown or without HELP")
+				}
+				types[name] = fields[3]
+				current = name
+			default:
+				fail("unknown comment")
+			}
+			continue
+		}
+
+		sample := metricSample{labels: make(map[string]string)}
+		rest := line
+		end := strings.IndexAny(rest, "{ ")
+		if end < 0 {
+			fail("no value")
+		}
+		sample.name, rest = rest[:end], rest[end:]
+		if !metricNamePattern.MatchString(sample.name) {
+			fail("invalid metric name")
+		}
+		if sample.name != current {
+			fail("sample outside the block of its TYPE (%q)", current)
+		}
+		if strings.HasPrefix(rest, "{") {
+			rest = rest[1:]
+			for !strings.HasPrefix(rest, "}") {
+				eq := strings.Index(rest, `="`)
+				if eq < 0 || !labelNamePattern.MatchString(rest[:eq]) {
+					fail("invalid label")
+				}
+				label := rest[:eq]
+				rest = rest[eq+2:]
+				var value strings.Builder
+				for {
+					if rest == "" {
+						fail("unterminated label value")
+					}
+					c := rest[0]
+					rest = rest[1:]
+					if c == '"' {
+						break
+					}
+					if c == '\n' {
+						fail("raw newline in label value")
+					}
+					if c == '\\' {
+						if rest == "" {
+							fail("dangling escape")
+						}
+						switch rest[0] {
+						case '\\', '"':
+							value.WriteByte(rest[0])
+						case 'n':
+							value.WriteByte('\n')
+						default:
+							fail("invalid escape \\%c", rest[0])
+						}
+						rest = rest[1:]
+						continue
+					}
+					value.WriteByte(c)
+				}
+				if _, dup := sample.labels[label]; dup {
+					fail("label %s repeated", label)
+				}
+				sample.labels[label] = value.String()
+				rest = strings.TrimPrefix(rest, ",")
+			}
+			rest = rest[1:]
+		}
+		if !strings.HasPrefix(rest, " ") {
+			fail("no space before the value")
+		}
+		value, err := strconv.ParseFloat(strings.TrimPrefix(rest, " "), 64)
+		if err != nil {
+			fail("invalid value: %v", err)
+		}
+		sample.value = value
+
+		key := sample.name + fmt.Sprint(sample.labels)
+		if series[key] {
+			fail("series repeated")
+		}
+		series[key] = true
+		samples = append(samples, sample)
+	}
+	return types, samples
+}
+
+func TestRenderMetrics(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	d := testDaemon(t, npub)
+	d.metrics.countRequest("sign_event")
+	d.metrics.countRequest("sign_event")
+	d.metrics.countRequest("get_public_key")
+	// Method names come from clients: label values must be escaped
+	odd := "evil\"method\\with\nnewline"
+	d.metrics.countRequest(odd)
+
+	types, samples := parseExposition(t, d.renderMetrics())
+
+	wantTypes := map[string]string{
+		"noorsigner_build_info":                 "gauge",
+		"noorsigner_start_time_seconds":         "gauge",
+		"noorsigner_unlocked":                   "gauge",
+		"noorsigner_connections_total":          "counter",
+		"noorsigner_connections_open":           "gauge",
+		"noorsigner_connections_rejected_total": "counter",
+		"noorsigner_stream_subscribers":         "gauge",
+		"noorsigner_requests_total":             "counter",
+		"noorsigner_textfile_timestamp_seconds": "gauge",
+	}
+	for name, want := range wantTypes {
+		if types[name] != want {
+			t.Errorf("%s: type %q, want %q", name, types[name], want)
+		}
+	}
+	for name, kind := range types {
+		if _, ok := wantTypes[name]; !ok {
+			t.Errorf("unexpected metric %s (%s)", name, kind)
+		}
+		if kind == "counter" && !strings.HasSuffix(name, "_total") {
+			t.Errorf("counter %s does not end in _total", name)
+		}
+	}
+
+	requests := make(map[string]float64)
+	values := make(map[string]float64)
+	for _, sample := range samples {
+		switch sample.name {
+		case "noorsigner_requests_total":
+			requests[sample.labels["method"]] = sample.value
+		case "noorsigner_build_info":
+			if sample.labels["version"] != version {
+				t.Errorf("build_info version %q, want %q", sample.labels["version"], version)
+			}
+		default:
+			values[sample.name] = sample.value
+		}
+	}
+	wantRequests := map[string]float64{"sign_event": 2, "get_public_key": 1, odd: 1}
+	if fmt.Sprint(requests) != fmt.Sprint(wantRequests) {
+		t.Errorf("requests_total %v, want %v", requests, wantRequests)
+	}
+	if values["noorsigner_unlocked"] != 1 {
+		t.Errorf("unlocked %v, want 1", values["noorsigner_unlocked"])
+	}
+}
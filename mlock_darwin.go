@@ -0,0 +1,9 @@
+package main
+
+import "syscall"
+
+// lockMemory locks every page of the process, present and future, into
+// RAM so the key is never written to swap (strict mode)
+func lockMemory() error {
+	return syscall.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockMemory locks every page of the process, present and future, into
+// RAM so the key is never written to swap (strict mode). MCL_ONFAULT locks
+// pages as they are touched instead of faulting in every reservation of the
+// Go runtime; kernels before 4.4 don't know it and lock everything.
+func lockMemory() error {
+	err := unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE | unix.MCL_ONFAULT)
+	if errors.Is(err, unix.EINVAL) {
+		err = unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE)
+	}
+	return err
+}
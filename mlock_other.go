@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// lockMemory can't lock the process's memory on this platform, so strict
+// mode refuses to start
+func lockMemory() error {
+	return errors.New("memory locking is not supported on this platform")
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/77elements/noorsigner/pkg/client"
+)
+
+const (
+	// nativeHostName names the host in manifests and browser.runtime.connectNative
+	nativeHostName = "noorsigner"
+
+	// nativeMessageMaxOut is the largest message browsers accept from a host
+	nativeMessageMaxOut = 1024 * 1024
+
+	codeResponseTooLarge = "ERR_RESPONSE_TOO_LARGE"
+)
+
+// errNativeMessageTooLarge is a browser message over maxRequestSize; it is
+// skipped and answered with an error
+var errNativeMessageTooLarge = fmt.Errorf("message too large (max %d bytes)", maxRequestSize)
+
+// nativeHostBrowser is where a browser looks for native messaging hosts
+type nativeHostBrowser struct {
+	name string
+	// Manifest directories relative to the home directory
+	darwinDir string
+	linuxDir  string
+	// registryKey under HKCU names the manifest on Windows
+	registryKey string
+	// Firefox allows extensions by ID, Chrome by origin
+	firefox bool
+}
+
+var nativeHostBrowsers = map[string]nativeHostBrowser{
+	"chrome": {
+		name:        "chrome",
+		darwinDir:   "Library/Application Support/Google/Chrome/NativeMessagingHosts",
+		linuxDir:    ".config/google-chrome/NativeMessagingHosts",
+		registryKey: `Software\Google\Chrome\NativeMessagingHosts\` + nativeHostName,
+	},
+	"firefox": {
+		name:        "firefox",
+		darwinDir:   "Library/Application Support/Mozilla/NativeMessagingHosts",
+		linuxDir:    ".mozilla/native-messaging-hosts",
+		registryKey: `Software\Mozilla\NativeMessagingHosts\` + nativeHostName,
+		firefox:     true,
+	},
+}
+
+var (
+	// chromeExtensionIDPattern matches Chrome extension IDs (32 letters a-p)
+	chromeExtensionIDPattern = regexp.MustCompile(`^[a-p]{32}$`)
+	// firefoxExtensionIDPattern matches Firefox add-on IDs: a GUID in braces
+	// or an email-like ID
+	firefoxExtensionIDPattern = regexp.MustCompile(`^(\{[0-9a-fA-F-]{36}\}|[A-Za-z0-9._+-]*@[A-Za-z0-9._-]+)$`)
+)
+
+// nativeHostManifest is the JSON file that tells a browser how to start the host
+type nativeHostManifest struct {
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Path              string   `json:"path"`
+	Type              string   `json:"type"`
+	AllowedOrigins    []string `json:"allowed_origins,omitempty"`
+	AllowedExtensions []string `json:"allowed_extensions,omitempty"`
+}
+
+// isNativeHostInvocation reports whether a browser started us as its native
+// messaging host: Chrome passes the calling extension's origin, Firefox the
+// path of our manifest. The manifest can't add a command, so this stands in
+// for "native-host".
+func isNativeHostInvocation(arg string) bool {
+	return strings.HasPrefix(arg, "chrome-extension://") || filepath.Base(arg) == nativeHostName+".json"
+}
+
+// nativeHostCaller names the extension that started the host, for the
+// activity feed and audit log
+func nativeHostCaller(args []string) string {
+	switch {
+	case len(args) > 0 && strings.HasPrefix(args[0], "chrome-extension://"):
+		return args[0]
+	case len(args) > 1 && !strings.HasPrefix(args[1], "--"):
+		return args[1] // Firefox: manifest path, then the add-on ID
+	}
+	return "native-host"
+}
+
+// readNativeMessage reads one message: a 4-byte little-endian length, then
+// that much JSON
+func readNativeMessage(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxRequestSize {
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			return nil, err
+		}
+		return nil, errNativeMessageTooLarge
+	}
+
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// writeNativeMessage writes one length-prefixed message
+func writeNativeMessage(w io.Writer, message []byte) error {
+	frame := make([]byte, 4, 4+len(message))
+	binary.LittleEndian.PutUint32(frame, uint32(len(message)))
+	_, err := w.Write(append(frame, message...))
+	return err
+}
+
+// nativeHostResponse encodes a response the host gives itself
+func nativeHostResponse(response SignResponse) []byte {
+	message, _ := json.Marshal(response)
+	return message
+}
+
+// forwardNativeMessage passes one request to the daemon on a connection of
+// its own and returns the daemon's response
+func forwardNativeMessage(message []byte, caller string) []byte {
+	var req SignRequest
+	if err := json.Unmarshal(message, &req); err != nil {
+		return nativeHostResponse(SignResponse{
+			Error: fmt.Sprintf("Invalid request format: %v", err),
+			Code:  codeInvalidRequest,
+		})
+	}
+	if socketOnlyMethods[req.Method] {
+		return nativeHostResponse(invalidRequestResponse(req, req.Method+" needs a socket connection"))
+	}
+	if req.AppName == "" {
+		req.AppName = caller
+	}
+
+	// Error responses are passed on as the daemon sent them
+	var response json.RawMessage
+	err := daemonClient().Do(context.Background(), req, &response)
+	var daemonErr *client.Error
+	if err != nil && !errors.As(err, &daemonErr) {
+		return nativeHostResponse(SignResponse{ID: req.ID, Error: err.Error()})
+	}
+	if len(response) > nativeMessageMaxOut {
+		return nativeHostResponse(SignResponse{
+			ID:    req.ID,
+			Error: fmt.Sprintf("response too large for native messaging (max %d bytes)", nativeMessageMaxOut),
+			Code:  codeResponseTooLarge,
+		})
+	}
+	return response
+}
+
+// nativeHostCmd serves a browser extension over native messaging: each
+// message on stdin is a request for the daemon, answered on stdout. Requests
+// run concurrently, so one waiting for confirmation doesn't hold up the
+// rest. The host keeps nothing but its daemon connections, which close with
+// it, so a browser killing it leaves nothing behind.
+func nativeHostCmd(args []string) error {
+	// stdout carries messages only (main already sent chatter to stderr)
+	out := jsonStdout
+	caller := nativeHostCaller(args)
+
+	var writeMu sync.Mutex
+	respond := func(message []byte) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := writeNativeMessage(out, message); err != nil {
+			os.Exit(0) // The browser is gone
+		}
+	}
+
+	for {
+		message, err := readNativeMessage(os.Stdin)
+		if errors.Is(err, errNativeMessageTooLarge) {
+			respond(nativeHostResponse(SignResponse{Error: err.Error(), Code: codeRequestTooLarge}))
+			continue
+		}
+		if err != nil {
+			// EOF: the extension disconnected. Requests still running are
+			// dropped; nobody is left to read their answers.
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				fmt.Fprintf(os.Stderr, "native-host: %v\n", err)
+			}
+			return nil
+		}
+		go func() {
+			respond(forwardNativeMessage(message, caller))
+		}()
+	}
+}
+
+// nativeHostManifestPath returns where the browser looks for our manifest.
+// On Windows the manifest can live anywhere; the registry points to it.
+func nativeHostManifestPath(browser nativeHostBrowser) (string, error) {
+	var dir string
+	switch runtime.GOOS {
+	case "darwin", "linux":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, filepath.FromSlash(browser.linuxDir))
+		if runtime.GOOS == "darwin" {
+			dir = filepath.Join(home, filepath.FromSlash(browser.darwinDir))
+		}
+	case "windows":
+		storageDir, err := getStorageDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(storageDir, "native-messaging", browser.name)
+	default:
+		return "", fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+	return filepath.Join(dir, nativeHostName+".json"), nil
+}
+
+// installNativeHost writes the browser's manifest for the running
+// executable and returns its path
+func installNativeHost(browser nativeHostBrowser, extensionIDs []string) (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("cannot find the noorsigner executable: %v", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exePath); err == nil {
+		exePath = resolved
+	}
+
+	manifest := nativeHostManifest{
+		Name:        nativeHostName,
+		Description: "NoorSigner - Nostr signing without keys in the browser",
+		Path:        exePath,
+		Type:        "stdio",
+	}
+	for _, id := range extensionIDs {
+		if browser.firefox {
+			manifest.AllowedExtensions = append(manifest.AllowedExtensions, id)
+		} else {
+			manifest.AllowedOrigins = append(manifest.AllowedOrigins, "chrome-extension://"+id+"/")
+		}
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path, err := nativeHostManifestPath(browser)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, append(content, '\n'), 0644); err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" {
+		if err := registerNativeHostWindows(browser.registryKey, path); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// installNativeHostCmd installs the native messaging manifest for a browser
+func installNativeHostCmd(args []string) error {
+	usage := "Usage: noorsigner install-native-host --browser chrome|firefox --extension-id <id> [--extension-id <id>...]"
+	browserName := ""
+	var extensionIDs []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--browser", "--extension-id":
+			if i+1 >= len(args) {
+				return commandFailed(1, "%s", usage)
+			}
+			if args[i] == "--browser" {
+				browserName = args[i+1]
+			} else {
+				extensionIDs = append(extensionIDs, args[i+1])
+			}
+			i++
+		default:
+			return commandFailed(1, "Unknown option: %s\n%s", args[i], usage)
+		}
+	}
+
+	browser, ok := nativeHostBrowsers[browserName]
+	if !ok || len(extensionIDs) == 0 {
+		return commandFailed(1, "%s", usage)
+	}
+	for _, id := range extensionIDs {
+		if browser.firefox && !firefoxExtensionIDPattern.MatchString(id) {
+			return commandFailed(1, "❌ Invalid Firefox add-on ID %q: expected name@example.com or {GUID}", id)
+		}
+		if !browser.firefox && !chromeExtensionIDPattern.MatchString(id) {
+			return commandFailed(1, "❌ Invalid Chrome extension ID %q: expected 32 letters a-p (see chrome://extensions)", id)
+		}
+	}
+
+	path, err := installNativeHost(browser, extensionIDs)
+	if err != nil {
+		return commandFailed(1, "❌ Cannot install the native messaging host: %v", err)
+	}
+
+	fmt.Printf("✅ Native messaging host %q installed for %s\n", nativeHostName, browser.name)
+	fmt.Printf("   Manifest: %s\n", path)
+	for _, id := range extensionIDs {
+		fmt.Printf("   Allowed:  %s\n", id)
+	}
+	fmt.Println("   Restart the browser if the extension doesn't find it yet.")
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// Browsers read native messaging hosts from the registry on Windows only
+
+func registerNativeHostWindows(keyPath, manifestPath string) error {
+	return fmt.Errorf("windows registry not available on this platform")
+}
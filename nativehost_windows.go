@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows/registry"
+
+// registerNativeHostWindows points the browser's HKCU key at the manifest
+func registerNativeHostWindows(keyPath, manifestPath string) error {
+	key, _, err := registry.CreateKey(registry.CURRENT_USER, keyPath, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	return key.SetStringValue("", manifestPath)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// ncryptsecLogN is the NIP-49 scrypt cost (2^16, about 64 MiB and a
+	// second or so to decrypt)
+	ncryptsecLogN = 16
+
+	// ncryptsecKeySecurity is the NIP-49 key security byte: 0x02, the client
+	// does not track whether the key was handled insecurely
+	ncryptsecKeySecurity = 0x02
+)
+
+// encryptNcryptsec encrypts a 32-byte private key as a NIP-49 ncryptsec.
+// NIP-49 wants the password NFKC-normalized; without a Unicode normalizer in
+// this build only ASCII passwords are accepted, so the backup decrypts the
+// same everywhere.
+func encryptNcryptsec(secretKey []byte, password string) (string, error) {
+	if len(secretKey) != 32 {
+		return "", fmt.Errorf("invalid secret key length %d", len(secretKey))
+	}
+	for _, c := range password {
+		if c > 0x7e || c < 0x20 {
+			return "", fmt.Errorf("the backup password must be printable ASCII")
+		}
+	}
+	if err := requireEntropy(); err != nil {
+		return "", err
+	}
+
+	// version, log_n, salt (16), nonce (24), key security byte, ciphertext (48)
+	data := make([]byte, 0, 91)
+	data = append(data, 0x02, ncryptsecLogN)
+
+	salt := make([]byte, 16)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("cannot generate salt: %v", err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("cannot generate nonce: %v", err)
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<ncryptsecLogN, 8, 1, 32)
+	if err != nil {
+		return "", fmt.Errorf("scrypt key derivation failed: %v", err)
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+
+	associatedData := []byte{ncryptsecKeySecurity}
+	data = append(data, salt...)
+	data = append(data, nonce...)
+	data = append(data, associatedData...)
+	data = aead.Seal(data, nonce, secretKey, associatedData)
+
+	bits, err := bech32.ConvertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	return bech32.Encode("ncryptsec", bits)
+}
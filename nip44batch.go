@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/77elements/noorsigner/pkg/client"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// Limits for nip44_decrypt_batch. Clients with longer histories split them
+// into several batches (the decrypt CLI does this automatically).
+const (
+	maxDecryptBatchItems = 1000
+	maxDecryptBatchBytes = 4 * 1024 * 1024 // Sum of payload and pubkey lengths
+	maxConversationKeys  = 512
+)
+
+// DecryptBatchItem is one payload of a nip44_decrypt_batch request
+type DecryptBatchItem = client.DecryptBatchItem
+
+// DecryptBatchResult holds the plaintext or the error for one item
+type DecryptBatchResult struct {
+	Plaintext string `json:"plaintext,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DecryptBatchResponse represents nip44_decrypt_batch response. Results are
+// in the same order as the request items.
+type DecryptBatchResponse struct {
+	ID      string               `json:"id"`
+	Results []DecryptBatchResult `json:"results,omitempty"`
+	Error   string               `json:"error,omitempty"`
+	Code    string               `json:"code,omitempty"`
+}
+
+// conversationKeyCache remembers NIP-44 conversation keys (the ECDH step is
+// the expensive part of a decrypt) per peer pubkey for the unlocked account.
+// It must be cleared whenever the daemon's private key changes.
+type conversationKeyCache struct {
+	mu   sync.Mutex
+	keys map[string][32]byte
+}
+
+// get returns the cached conversation key for a peer, deriving it on a miss
+func (c *conversationKeyCache) get(peerPubkey string, privateKeyHex string) ([32]byte, error) {
+	c.mu.Lock()
+	key, ok := c.keys[peerPubkey]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	key, err := nip44.GenerateConversationKey(peerPubkey, privateKeyHex)
+	if err != nil {
+		return key, fmt.Errorf("failed to generate conversation key: %v", err)
+	}
+
+	c.mu.Lock()
+	if c.keys == nil || len(c.keys) >= maxConversationKeys {
+		c.clearLocked()
+		c.keys = make(map[string][32]byte)
+	}
+	c.keys[peerPubkey] = key
+	c.mu.Unlock()
+
+	return key, nil
+}
+
+// clear zeroes and drops all cached conversation keys
+func (c *conversationKeyCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clearLocked()
+}
+
+// clearLocked zeroes and drops all cached keys (caller holds mu)
+func (c *conversationKeyCache) clearLocked() {
+	for peer, key := range c.keys {
+		for i := range key {
+			key[i] = 0
+		}
+		c.keys[peer] = key
+	}
+	c.keys = nil
+}
+
+// nip44EncryptCached encrypts for a recipient with key's conversation key
+// (cached for the unlocked account)
+func nip44EncryptCached(key *accountKey, plaintext string, recipientPubkey string) (string, error) {
+	conversationKey, err := key.conversationKey(recipientPubkey)
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := nip44.Encrypt(plaintext, conversationKey)
+	if err != nil {
+		return "", fmt.Errorf("encryption failed: %v", err)
+	}
+
+	return encrypted, nil
+}
+
+// nip44DecryptCached decrypts a payload from a sender with key's
+// conversation key (cached for the unlocked account)
+func nip44DecryptCached(key *accountKey, payload string, senderPubkey string) (string, error) {
+	conversationKey, err := key.conversationKey(senderPubkey)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := nip44.Decrypt(payload, conversationKey)
+	if err != nil {
+		return "", fmt.Errorf("decryption failed: %v", err)
+	}
+
+	return plaintext, nil
+}
+
+// checkDecryptBatch enforces the batch size limits
+func checkDecryptBatch(items []DecryptBatchItem) error {
+	if len(items) == 0 {
+		return fmt.Errorf("items required")
+	}
+	if len(items) > maxDecryptBatchItems {
+		return fmt.Errorf("batch too large: %d items (max %d)", len(items), maxDecryptBatchItems)
+	}
+
+	total := 0
+	for _, item := range items {
+		total += len(item.Payload) + len(item.SenderPubkey)
+	}
+	if total > maxDecryptBatchBytes {
+		return fmt.Errorf("batch too large: %d bytes (max %d)", total, maxDecryptBatchBytes)
+	}
+
+	return nil
+}
+
+// decryptBatch decrypts all items with key on a worker pool. A failing item
+// only sets its own error.
+func decryptBatch(key *accountKey, items []DecryptBatchItem) []DecryptBatchResult {
+	results := make([]DecryptBatchResult, len(items))
+
+	workers := runtime.NumCPU()
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				item := items[i]
+				if item.Payload == "" || item.SenderPubkey == "" {
+					results[i].Error = "payload and sender_pubkey required"
+					continue
+				}
+				plaintext, err := nip44DecryptCached(key, item.Payload, item.SenderPubkey)
+				if err != nil {
+					results[i].Error = redactedError(err)
+					continue
+				}
+				results[i].Plaintext = plaintext
+			}
+		}()
+	}
+
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// decryptLine is one JSON line of a decrypt --batch-file input. The optional
+// id is echoed in the output so scripts can match results to messages.
+type decryptLine struct {
+	ID           string `json:"id,omitempty"`
+	Payload      string `json:"payload"`
+	SenderPubkey string `json:"sender_pubkey"`
+}
+
+// decryptLineResult is one JSON line of decrypt --batch-file output
+type decryptLineResult struct {
+	ID        string `json:"id,omitempty"`
+	Plaintext string `json:"plaintext,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// decryptCmd decrypts NIP-44 payloads via the daemon
+func decryptCmd(args []string) error {
+	if len(args) == 2 && args[0] == "--batch-file" {
+		if err := decryptBatchFile(args[1], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return &commandError{Code: 1}
+		}
+		return nil
+	}
+
+	if len(args) != 2 || args[0] == "" || args[0][0] == '-' {
+		return commandFailed(1, "Usage: noorsigner decrypt <sender_pubkey> <payload>\n       noorsigner decrypt --batch-file <file|->")
+	}
+
+	results, err := decryptBatchViaDaemon([]DecryptBatchItem{{SenderPubkey: args[0], Payload: args[1]}})
+	if err != nil {
+		return commandFailed(1, "Error: %v", err)
+	}
+	if results[0].Error != "" {
+		return commandFailed(1, "Error: %s", results[0].Error)
+	}
+
+	fmt.Println(results[0].Plaintext)
+	return nil
+}
+
+// decryptBatchFile reads {"payload","sender_pubkey"[,"id"]} JSON lines from
+// path ("-" = stdin) and writes one result line per input line to out.
+// Input is sent in batches that fit the daemon's limits; a malformed line
+// only fails itself.
+func decryptBatchFile(path string, out io.Writer) error {
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("cannot open batch file: %v", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), maxDecryptBatchBytes)
+	encoder := json.NewEncoder(out)
+
+	var pending []decryptLineResult
+	var items []DecryptBatchItem
+	var itemIndex []int // pending index for each item
+	batchBytes := 0
+
+	flush := func() error {
+		if len(items) > 0 {
+			results, err := decryptBatchViaDaemon(items)
+			if err != nil {
+				return err
+			}
+			for i, result := range results {
+				pending[itemIndex[i]].Plaintext = result.Plaintext
+				pending[itemIndex[i]].Error = result.Error
+			}
+		}
+		for _, result := range pending {
+			if err := encoder.Encode(result); err != nil {
+				return err
+			}
+		}
+		pending, items, itemIndex, batchBytes = nil, nil, nil, 0
+		return nil
+	}
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		text := scanner.Bytes()
+		if len(text) == 0 {
+			continue
+		}
+
+		var line decryptLine
+		if err := json.Unmarshal(text, &line); err != nil {
+			pending = append(pending, decryptLineResult{Error: fmt.Sprintf("line %d: invalid JSON: %v", lineNumber, err)})
+			continue
+		}
+
+		size := len(line.Payload) + len(line.SenderPubkey)
+		if len(items) == maxDecryptBatchItems || (len(items) > 0 && batchBytes+size > maxDecryptBatchBytes) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		pending = append(pending, decryptLineResult{ID: line.ID})
+		items = append(items, DecryptBatchItem{Payload: line.Payload, SenderPubkey: line.SenderPubkey})
+		itemIndex = append(itemIndex, len(pending)-1)
+		batchBytes += size
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read batch file: %v", err)
+	}
+
+	return flush()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// newTestAccountKey returns a fresh account key with a conversation key
+// cache, as the daemon's active account has
+func newTestAccountKey(tb testing.TB) *accountKey {
+	tb.Helper()
+	privateKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &accountKey{
+		privateKey: privateKey,
+		pubkey:     hex.EncodeToString(privateKey.PubKey().SerializeCompressed()[1:]),
+		convKeys:   &conversationKeyCache{},
+	}
+}
+
+// decryptBatchItems returns n messages to key, sent round robin by the
+// given number of peers
+func decryptBatchItems(tb testing.TB, key *accountKey, n, peers int) []DecryptBatchItem {
+	tb.Helper()
+	type peer struct {
+		pubkey          string
+		conversationKey [32]byte
+	}
+	senders := make([]peer, peers)
+	for i := range senders {
+		privateKey, err := btcec.NewPrivateKey()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		conversationKey, err := nip44.GenerateConversationKey(key.pubkey, hex.EncodeToString(privateKey.Serialize()))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		senders[i] = peer{hex.EncodeToString(privateKey.PubKey().SerializeCompressed()[1:]), conversationKey}
+	}
+
+	items := make([]DecryptBatchItem, n)
+	for i := range items {
+		sender := senders[i%peers]
+		payload, err := nip44.Encrypt(fmt.Sprintf("message %d", i), sender.conversationKey)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		items[i] = DecryptBatchItem{Payload: payload, SenderPubkey: sender.pubkey}
+	}
+	return items
+}
+
+func TestDecryptBatch(t *testing.T) {
+	key := newTestAccountKey(t)
+	items := decryptBatchItems(t, key, 50, 3)
+	items[7].Payload = "not a payload"
+	items[9].SenderPubkey = ""
+
+	results := decryptBatch(key, items)
+	if len(results) != len(items) {
+		t.Fatalf("%d results for %d items", len(results), len(items))
+	}
+	for i, result := range results {
+		switch i {
+		case 7, 9:
+			if result.Error == "" || result.Plaintext != "" {
+				t.Errorf("item %d: %+v, want only an error", i, result)
+			}
+		default:
+			if want := fmt.Sprintf("message %d", i); result.Plaintext != want || result.Error != "" {
+				t.Errorf("item %d: %+v, want plaintext %q", i, result, want)
+			}
+		}
+	}
+	if cached := len(key.convKeys.keys); cached != 3 {
+		t.Errorf("%d conversation keys cached, want one per peer (3)", cached)
+	}
+}
+
+func TestCheckDecryptBatch(t *testing.T) {
+	large := make([]DecryptBatchItem, maxDecryptBatchItems+1)
+	tests := []struct {
+		name  string
+		items []DecryptBatchItem
+		ok    bool
+	}{
+		{"empty", nil, false},
+		{"one item", []DecryptBatchItem{{Payload: "x", SenderPubkey: "y"}}, true},
+		{"too many items", large, false},
+		{"too many bytes", []DecryptBatchItem{{Payload: string(make([]byte, maxDecryptBatchBytes)), SenderPubkey: "y"}}, false},
+	}
+	for _, tt := range tests {
+		if err := checkDecryptBatch(tt.items); (err == nil) != tt.ok {
+			t.Errorf("%s: checkDecryptBatch = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+// benchmarkDecryptBatch decrypts a batch of size messages from peers
+// senders per iteration. Without cache, every item derives its
+// conversation key, as for an account opened on demand.
+func benchmarkDecryptBatch(b *testing.B, size, peers int, cache bool) {
+	key := newTestAccountKey(b)
+	items := decryptBatchItems(b, key, size, peers)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if cache {
+			key.convKeys = &conversationKeyCache{}
+		} else {
+			key.convKeys = nil
+		}
+		decryptBatch(key, items)
+	}
+}
+
+func BenchmarkDecryptBatch100From5(b *testing.B)     { benchmarkDecryptBatch(b, 100, 5, true) }
+func BenchmarkDecryptBatch1000From5(b *testing.B)    { benchmarkDecryptBatch(b, 1000, 5, true) }
+func BenchmarkDecryptBatch1000From500(b *testing.B)  { benchmarkDecryptBatch(b, 1000, 500, true) }
+func BenchmarkDecryptBatch1000Uncached(b *testing.B) { benchmarkDecryptBatch(b, 1000, 5, false) }
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// nip46Kind is the kind of NIP-46 requests and responses
+	nip46Kind = 24133
+
+	// nip46ConnectTimeout bounds how long nip46_connect waits for a relay
+	nip46ConnectTimeout = 10 * time.Second
+
+	// nip46GrantCheckInterval is how often an idle session looks for its
+	// grant in grants.json, so a revoked client is disconnected
+	nip46GrantCheckInterval = 30 * time.Second
+
+	// nip46MaxBackoff caps the wait between reconnects to a relay
+	nip46MaxBackoff = 5 * time.Minute
+
+	codeRelayUnreachable = "ERR_RELAY_UNREACHABLE"
+)
+
+// nip46Request is the encrypted content of a NIP-46 request event
+type nip46Request struct {
+	ID     string   `json:"id"`
+	Method string   `json:"method"`
+	Params []string `json:"params"`
+}
+
+// nip46Response is the encrypted content of a NIP-46 response event
+type nip46Response struct {
+	ID     string `json:"id"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// GrantResponse answers nip46_connect
+type GrantResponse struct {
+	ID    string `json:"id"`
+	Grant *Grant `json:"grant,omitempty"`
+	// Relays that were reached and sent the connect answer
+	Connected []string `json:"connected,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Code      string   `json:"code,omitempty"`
+}
+
+// nip46Session serves one grant over its relays. Its connSession makes its
+// requests go through the same policy, approval, audit and activity
+// machinery as a socket client's.
+type nip46Session struct {
+	grant   Grant
+	pubkey  string // The account's hex pubkey
+	session *connSession
+	cancel  context.CancelFunc
+	mu      sync.Mutex // One request at a time, like a socket connection
+
+	relaysMu  sync.Mutex
+	relays    map[string]*nostr.Relay // Connected relays by URL
+	connected chan struct{}           // Closed when the first relay is connected
+	once      sync.Once
+}
+
+// startNIP46Sessions starts serving every grant in grants.json
+func (d *Daemon) startNIP46Sessions() {
+	grants, err := loadGrants()
+	if err != nil {
+		logError("⚠️  %v - NIP-46 clients are not served", err)
+		return
+	}
+	for _, grant := range grants {
+		d.startNIP46Session(grant)
+	}
+}
+
+// startNIP46Session connects to the grant's relays, replacing a running
+// session for the same client
+func (d *Daemon) startNIP46Session(grant Grant) *nip46Session {
+	pubkey, err := npubToPubkey(grant.Npub)
+	if err != nil {
+		logError("⚠️  NIP-46 grant %q: %v", grant.Name, err)
+		return nil
+	}
+
+	ctx, s := d.newNIP46Session(grant, pubkey)
+
+	d.nip46Mu.Lock()
+	if previous := d.nip46Sessions[grant.ClientPubkey]; previous != nil {
+		previous.cancel()
+	}
+	d.nip46Sessions[grant.ClientPubkey] = s
+	d.nip46Mu.Unlock()
+
+	logInfo("🔌 NIP-46 client %q for %s on %d relays (conn %d)", grant.Name, displayNpub(grant.Npub), len(grant.Relays), s.session.id)
+	for _, relayURL := range grant.Relays {
+		go d.runNIP46Relay(ctx, s, relayURL)
+	}
+	go d.watchNIP46Grant(ctx, s)
+	return s
+}
+
+// newNIP46Session creates the session of grant for the account with pubkey
+func (d *Daemon) newNIP46Session(grant Grant, pubkey string) (context.Context, *nip46Session) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return ctx, &nip46Session{
+		grant:     grant,
+		pubkey:    pubkey,
+		session:   &connSession{id: d.nextConnID.Add(1), client: "nip46:" + grant.Name},
+		cancel:    cancel,
+		relays:    make(map[string]*nostr.Relay),
+		connected: make(chan struct{}),
+	}
+}
+
+// stopNIP46Session disconnects a session, unless another has replaced it
+func (d *Daemon) stopNIP46Session(s *nip46Session) {
+	s.cancel()
+	d.nip46Mu.Lock()
+	if d.nip46Sessions[s.grant.ClientPubkey] == s {
+		delete(d.nip46Sessions, s.grant.ClientPubkey)
+	}
+	d.nip46Mu.Unlock()
+}
+
+// watchNIP46Grant stops the session once its grant is revoked
+func (d *Daemon) watchNIP46Grant(ctx context.Context, s *nip46Session) {
+	ticker := time.NewTicker(nip46GrantCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !d.nip46GrantActive(s) {
+				return
+			}
+		}
+	}
+}
+
+// nip46GrantActive reports whether the session's grant is still in
+// grants.json, and stops the session if not
+func (d *Daemon) nip46GrantActive(s *nip46Session) bool {
+	grants, err := loadGrants()
+	if err != nil {
+		logError("⚠️  %v", err)
+		return true // Keep serving - a broken file is not a revocation
+	}
+	if _, ok := findGrant(grants, s.grant.ClientPubkey); ok {
+		return true
+	}
+	logInfo("🔌 NIP-46 client %q was revoked - disconnecting", s.grant.Name)
+	d.stopNIP46Session(s)
+	return false
+}
+
+// runNIP46Relay keeps a subscription for the client's requests open on one
+// relay, reconnecting with backoff until the session stops. The bunker
+// listener, which has no client yet, takes requests from anyone.
+func (d *Daemon) runNIP46Relay(ctx context.Context, s *nip46Session, relayURL string) {
+	backoff := time.Second
+	since := nostr.Now()
+	for ctx.Err() == nil {
+		relay := nostr.NewRelay(ctx, relayURL)
+		connectCtx, cancel := context.WithTimeout(ctx, nip46ConnectTimeout)
+		err := relay.Connect(connectCtx)
+		cancel()
+
+		var sub *nostr.Subscription
+		if err == nil {
+			filter := nostr.Filter{
+				Kinds: []int{nip46Kind},
+				Tags:  nostr.TagMap{"p": []string{s.pubkey}},
+				Since: &since,
+			}
+			if s.grant.ClientPubkey != "" {
+				filter.Authors = []string{s.grant.ClientPubkey}
+			}
+			sub, err = relay.Subscribe(ctx, nostr.Filters{filter})
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logError("⚠️  NIP-46 %q: %s: %v (retrying in %s)", s.grant.Name, relayURL, err, backoff)
+			relay.Close()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, nip46MaxBackoff)
+			continue
+		}
+
+		logDebug("NIP-46 %q: connected to %s", s.grant.Name, relayURL)
+		backoff = time.Second
+		s.relaysMu.Lock()
+		s.relays[relayURL] = relay
+		s.relaysMu.Unlock()
+		s.once.Do(func() { close(s.connected) })
+
+		for event := range sub.Events {
+			if event.CreatedAt > since {
+				since = event.CreatedAt
+			}
+			d.handleNIP46Event(s, event)
+		}
+
+		s.relaysMu.Lock()
+		delete(s.relays, relayURL)
+		s.relaysMu.Unlock()
+		relay.Close()
+		if ctx.Err() == nil {
+			logInfo("⚠️  NIP-46 %q: lost %s - reconnecting", s.grant.Name, relayURL)
+		}
+	}
+}
+
+// handleNIP46Event decrypts a request, serves it and publishes the answer
+func (d *Daemon) handleNIP46Event(s *nip46Session, event *nostr.Event) {
+	if s.grant.ClientPubkey == "" {
+		d.handleBunkerEvent(s, event)
+		return
+	}
+	if event.Kind != nip46Kind || event.PubKey != s.grant.ClientPubkey {
+		return
+	}
+	if ok, _ := event.CheckSignature(); !ok {
+		logDebug("NIP-46 %q: event %s has a bad signature - ignored", s.grant.Name, event.ID)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Every relay delivers the same request
+	if !s.session.ids.use(event.ID) {
+		return
+	}
+	defer d.recoverNIP46(s)
+
+	// grants.json is read for every request, like clients.json
+	if !d.nip46GrantActive(s) {
+		return
+	}
+
+	// Requests are encrypted to the account's key, so only its daemon can read them
+	d.mu.RLock()
+	serving := d.npub == s.grant.Npub && d.privateKey != nil
+	d.mu.RUnlock()
+	if !serving {
+		logInfo("🔌 NIP-46 request from %q ignored: %s is not the unlocked account", s.grant.Name, displayNpub(s.grant.Npub))
+		return
+	}
+
+	plaintext, nip04, err := d.nip46Decrypt(event.Content, s.grant.ClientPubkey)
+	if err != nil {
+		logError("⚠️  NIP-46 %q: cannot decrypt request: %v", s.grant.Name, err)
+		return
+	}
+	var request nip46Request
+	if err := json.Unmarshal([]byte(plaintext), &request); err != nil || request.ID == "" {
+		logError("⚠️  NIP-46 %q: invalid request", s.grant.Name)
+		return
+	}
+
+	response := d.serveNIP46Request(s, request)
+	if _, err := d.publishNIP46Response(s, s.grant.ClientPubkey, response, nip04); err != nil {
+		logError("⚠️  NIP-46 %q: cannot answer %s: %v", s.grant.Name, request.Method, err)
+	}
+}
+
+// recoverNIP46 logs a panic while serving a NIP-46 request. The session
+// stays up; the client gets no answer and times out.
+func (d *Daemon) recoverNIP46(s *nip46Session) {
+	if r := recover(); r != nil {
+		req := s.session.request
+		logError("conn %d: panic while handling NIP-46 id=%q method=%q: %v", s.session.id, req.ID, req.Method, r)
+		if s.session.inFlight {
+			d.endRequest(req.Method)
+			s.session.inFlight = false
+		}
+	}
+}
+
+// serveNIP46Request maps a NIP-46 request to the daemon's own method and
+// runs it like a socket request
+func (d *Daemon) serveNIP46Request(s *nip46Session, request nip46Request) nip46Response {
+	response := nip46Response{ID: request.ID}
+	req := SignRequest{
+		ID:      request.ID,
+		Method:  request.Method,
+		Version: protocolVersion,
+		AppName: s.grant.Name,
+	}
+
+	params := request.Params
+	switch request.Method {
+	case "connect":
+		// Already connected by 'noorsigner connect'
+		response.Result = "ack"
+		return response
+	case "ping":
+		response.Result = "pong"
+		return response
+	case "get_relays":
+		// The relay list is public (NIP-65), like the pubkey
+		relays, err := loadAccountRelays(s.grant.Npub)
+		if err != nil {
+			response.Error = err.Error()
+			return response
+		}
+		relaysJSON, _ := json.Marshal(relays)
+		response.Result = string(relaysJSON)
+		return response
+	case "get_public_key":
+	case "sign_event":
+		if len(params) < 1 {
+			response.Error = "sign_event needs the event as its parameter"
+			return response
+		}
+		req.EventJSON = params[0]
+	case "nip04_encrypt", "nip44_encrypt":
+		if len(params) < 2 {
+			response.Error = request.Method + " needs a pubkey and a plaintext"
+			return response
+		}
+		req.RecipientPubkey, req.Plaintext = params[0], params[1]
+	case "nip04_decrypt", "nip44_decrypt":
+		if len(params) < 2 {
+			response.Error = request.Method + " needs a pubkey and a ciphertext"
+			return response
+		}
+		req.SenderPubkey, req.Payload = params[0], params[1]
+	default:
+		response.Error = "unsupported method: " + request.Method
+		return response
+	}
+
+	if !s.grant.permits(request.Method, eventKind(req.EventJSON)) {
+		logInfo("🚫 NIP-46 %q: %s is not in its permissions", s.grant.Name, request.Method)
+		response.Error = "not permitted: " + request.Method
+		return response
+	}
+
+	result := d.runBridgedRequest(s.session, req)
+	switch {
+	case result.Error != "":
+		response.Error = result.Error
+	case result.Event != nil:
+		eventJSON, _ := json.Marshal(result.Event)
+		response.Result = string(eventJSON)
+	default:
+		response.Result = result.Result
+	}
+	return response
+}
+
+// runBridgedRequest runs a request from a client that is not on the socket
+// through the same steps as handleConnection and returns its response
+func (d *Daemon) runBridgedRequest(session *connSession, req SignRequest) SignResponse {
+	output := d.serveBridged(session, req, false)
+
+	var response SignResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return SignResponse{ID: req.ID, Error: "internal error - the request was not completed", Code: codeInternal}
+	}
+	if response.Code == codePending {
+		// A NIP-46 client can't poll: wait for the operator on its behalf
+		return d.runBridgedRequest(session, SignRequest{
+			ID:      req.ID,
+			Method:  "await_result",
+			Nonce:   response.ApprovalID,
+			Timeout: policyConfirmTTL.String(),
+		})
+	}
+	return response
+}
+
+// serveBridged runs a request that did not come over the socket (NIP-46,
+// HTTP) through the steps handleConnection takes and returns the response
+// written for it. With authorize the protocol version and client token are
+// checked as for socket clients; NIP-46 clients are authorized by their
+// grant.
+func (d *Daemon) serveBridged(session *connSession, req SignRequest, authorize bool) []byte {
+	received := time.Now()
+	session.request = req
+	d.recordRequest(session, req)
+	d.metrics.countRequest(req.Method)
+
+	var output bytes.Buffer
+	recorder := &responseRecorder{w: &output, capture: auditedMethods[req.Method], metrics: &d.metrics}
+	encoder := json.NewEncoder(recorder)
+
+	if authorize {
+		if response := checkProtocolVersion(req); response != nil && req.Method != "get_protocol_version" {
+			encoder.Encode(response)
+			return output.Bytes()
+		}
+		if response := d.authorizeRequest(session, req); response != nil {
+			encoder.Encode(response)
+			return output.Bytes()
+		}
+	}
+
+	if !d.beginRequest(req.Method) {
+		encoder.Encode(d.drainingResponse(req.ID))
+		return output.Bytes()
+	}
+	session.inFlight = true
+
+	d.runRequest(nil, session, req, encoder)
+	d.metrics.latency.record(time.Since(received))
+	if approvalMethods[req.Method] && recorder.last.Error == "" {
+		d.slideTrustSession()
+	}
+	d.endRequest(req.Method)
+	session.inFlight = false
+	d.recordActivity(session, req, recorder.last)
+	return output.Bytes()
+}
+
+// nip46Decrypt decrypts a request's content. Current clients use NIP-44,
+// older ones NIP-04; the answer goes back the same way.
+func (d *Daemon) nip46Decrypt(content, clientPubkey string) (plaintext string, nip04 bool, err error) {
+	nip04 = strings.Contains(content, "?iv=")
+	d.withKey(func() {
+		if err = d.requireUnlocked(); err != nil {
+			return
+		}
+		if nip04 {
+			plaintext, err = nip04Decrypt(content, clientPubkey, d.privateKey)
+		} else {
+			plaintext, err = nip44DecryptCached(d.activeKey(), content, clientPubkey)
+		}
+	})
+	return plaintext, nip04, err
+}
+
+// publishNIP46Response encrypts and signs an answer to clientPubkey,
+// publishes it to every connected relay of the session and returns those
+// that accepted it
+func (d *Daemon) publishNIP46Response(s *nip46Session, clientPubkey string, response nip46Response, nip04 bool) ([]string, error) {
+	plaintext, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+
+	var signed *NostrEvent
+	d.withKey(func() {
+		if err = d.requireUnlocked(); err != nil {
+			return
+		}
+		var content string
+		if nip04 {
+			content, err = nip04Encrypt(string(plaintext), clientPubkey, d.privateKey)
+		} else {
+			content, err = nip44EncryptCached(d.activeKey(), string(plaintext), clientPubkey)
+		}
+		if err != nil {
+			return
+		}
+		eventJSON, _ := json.Marshal(map[string]interface{}{
+			"kind":       nip46Kind,
+			"created_at": time.Now().Unix(),
+			"tags":       [][]string{{"p", clientPubkey}},
+			"content":    content,
+		})
+		// The transport event itself is not subject to the signing policy
+		signed, err = d.signEvent(string(eventJSON))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	published := s.publish(toNostrEvent(signed))
+	if len(published) == 0 {
+		return nil, fmt.Errorf("no relay accepted the answer")
+	}
+	return published, nil
+}
+
+// publish sends event to every connected relay and returns those that
+// accepted it
+func (s *nip46Session) publish(event nostr.Event) []string {
+	s.relaysMu.Lock()
+	relays := make(map[string]*nostr.Relay, len(s.relays))
+	for url, relay := range s.relays {
+		relays[url] = relay
+	}
+	s.relaysMu.Unlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var published []string
+	for url, relay := range relays {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), nip46ConnectTimeout)
+			defer cancel()
+			if err := relay.Publish(ctx, event); err != nil {
+				logDebug("NIP-46 %q: %s did not accept the answer: %v", s.grant.Name, url, err)
+				return
+			}
+			mu.Lock()
+			published = append(published, url)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return published
+}
+
+// nip46Connect answers nip46_connect: it stores a grant for the URI's
+// client, connects to its relays and sends the client the secret from the
+// URI, which completes the connection on the client's side
+func (d *Daemon) nip46Connect(req SignRequest) GrantResponse {
+	connect, err := parseNostrConnectURI(req.ConnectURI)
+	if err != nil {
+		return GrantResponse{ID: req.ID, Error: redactedError(err), Code: codeInvalidRequest}
+	}
+
+	d.mu.RLock()
+	npub, locked := d.npub, d.privateKey == nil
+	d.mu.RUnlock()
+	if locked {
+		return GrantResponse{ID: req.ID, Error: redactedError(errDaemonLocked), Code: errorCode(errDaemonLocked)}
+	}
+
+	grant, err := storeGrant(Grant{
+		Name:         connect.grantName(),
+		ClientPubkey: connect.ClientPubkey,
+		Npub:         npub,
+		Relays:       connect.Relays,
+		Perms:        connect.Perms,
+		URL:          connect.URL,
+		CreatedAt:    time.Now().Unix(),
+	})
+	if err != nil {
+		return GrantResponse{ID: req.ID, Error: redactedError(err)}
+	}
+
+	s := d.startNIP46Session(grant)
+	if s == nil {
+		return GrantResponse{ID: req.ID, Error: "cannot serve the grant"}
+	}
+
+	timer := time.NewTimer(nip46ConnectTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.connected:
+	case <-timer.C:
+	}
+
+	// The secret tells the client that this answer comes from us
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	response := nip46Response{ID: hex.EncodeToString(idBytes), Result: connect.Secret}
+	published, err := d.publishNIP46Response(s, grant.ClientPubkey, response, false)
+	if err != nil {
+		// Nobody will ever use a grant the client never heard of
+		d.stopNIP46Session(s)
+		removeGrant(grant.ClientPubkey)
+		return GrantResponse{
+			ID:    req.ID,
+			Error: fmt.Sprintf("none of the client's relays could be reached (%s)", strings.Join(grant.Relays, ", ")),
+			Code:  codeRelayUnreachable,
+		}
+	}
+
+	logInfo("🔌 NIP-46 client %q connected for %s via %s", grant.Name, displayNpub(npub), strings.Join(published, ", "))
+	return GrantResponse{ID: req.ID, Grant: &grant, Connected: published}
+}
+
+// removeGrant deletes the grant of a client from grants.json
+func removeGrant(clientPubkey string) error {
+	grants, err := loadGrants()
+	if err != nil {
+		return err
+	}
+	var kept []Grant
+	for _, grant := range grants {
+		if grant.ClientPubkey != clientPubkey {
+			kept = append(kept, grant)
+		}
+	}
+	return saveGrants(kept)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// testRelay is an in-process relay speaking just enough NIP-01 for NIP-46:
+// it passes events on to matching subscriptions and, like relays do with
+// ephemeral kinds, keeps none of them
+type testRelay struct {
+	URL string
+
+	mu   sync.Mutex
+	subs map[*testRelaySub]bool
+}
+
+type testRelaySub struct {
+	conn    *testRelayConn
+	id      string
+	filters nostr.Filters
+}
+
+type testRelayConn struct {
+	mu sync.Mutex // One writer at a time
+	ws *websocket.Conn
+}
+
+func (c *testRelayConn) send(message ...any) {
+	data, _ := json.Marshal(message)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ws.Write(context.Background(), websocket.MessageText, data)
+}
+
+// startTestRelay serves a testRelay until the test ends
+func startTestRelay(t *testing.T) *testRelay {
+	t.Helper()
+	relay := &testRelay{subs: make(map[*testRelaySub]bool)}
+	server := httptest.NewServer(http.HandlerFunc(relay.serve))
+	t.Cleanup(server.Close)
+	relay.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+	return relay
+}
+
+func (r *testRelay) serve(w http.ResponseWriter, req *http.Request) {
+	ws, err := websocket.Accept(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer ws.CloseNow()
+	conn := &testRelayConn{ws: ws}
+	defer r.drop(conn, "")
+
+	for {
+		_, data, err := ws.Read(req.Context())
+		if err != nil {
+			return
+		}
+		var message []json.RawMessage
+		var kind string
+		if json.Unmarshal(data, &message) != nil || len(message) < 2 || json.Unmarshal(message[0], &kind) != nil {
+			conn.send("NOTICE", "invalid message")
+			continue
+		}
+		switch kind {
+		case "EVENT":
+			var event nostr.Event
+			if json.Unmarshal(message[1], &event) != nil {
+				conn.send("NOTICE", "invalid event")
+				continue
+			}
+			if ok, _ := event.CheckSignature(); !ok {
+				conn.send("OK", event.ID, false, "invalid: bad signature")
+				continue
+			}
+			conn.send("OK", event.ID, true, "")
+			r.broadcast(&event)
+		case "REQ":
+			sub := &testRelaySub{conn: conn}
+			json.Unmarshal(message[1], &sub.id)
+			for _, raw := range message[2:] {
+				var filter nostr.Filter
+				if json.Unmarshal(raw, &filter) == nil {
+					sub.filters = append(sub.filters, filter)
+				}
+			}
+			r.drop(conn, sub.id)
+			r.mu.Lock()
+			r.subs[sub] = true
+			r.mu.Unlock()
+			conn.send("EOSE", sub.id)
+		case "CLOSE":
+			var id string
+			json.Unmarshal(message[1], &id)
+			r.drop(conn, id)
+		}
+	}
+}
+
+// drop removes conn's subscription id, or all of them for ""
+func (r *testRelay) drop(conn *testRelayConn, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for sub := range r.subs {
+		if sub.conn == conn && (id == "" || sub.id == id) {
+			delete(r.subs, sub)
+		}
+	}
+}
+
+func (r *testRelay) broadcast(event *nostr.Event) {
+	r.mu.Lock()
+	var matching []*testRelaySub
+	for sub := range r.subs {
+		if sub.filters.Match(event) {
+			matching = append(matching, sub)
+		}
+	}
+	r.mu.Unlock()
+	for _, sub := range matching {
+		sub.conn.send("EVENT", sub.id, event)
+	}
+}
+
+// waitSubscription waits until some subscription has a filter for which
+// match is true. Relays keep no NIP-46 events, so a request sent before
+// the signer subscribed would be lost.
+func (r *testRelay) waitSubscription(t *testing.T, match func(nostr.Filter) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		for sub := range r.subs {
+			for _, filter := range sub.filters {
+				if match(filter) {
+					r.mu.Unlock()
+					return
+				}
+			}
+		}
+		r.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the signer did not subscribe on the relay")
+}
+
+// nip46TestClient is a NIP-46 client app: it sends requests to the signer
+// over a relay and reads the answers
+type nip46TestClient struct {
+	secretKey    string
+	pubkey       string
+	signerPubkey string
+	relay        *nostr.Relay
+	answers      chan *nostr.Event
+	next         int
+}
+
+func newNIP46TestClient(t *testing.T, relayURL, signerPubkey string) *nip46TestClient {
+	t.Helper()
+	privateKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &nip46TestClient{secretKey: hex.EncodeToString(privateKey.Serialize()), signerPubkey: signerPubkey}
+	if c.pubkey, err = nostr.GetPublicKey(c.secretKey); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if c.relay, err = nostr.RelayConnect(ctx, relayURL); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.relay.Close() })
+	sub, err := c.relay.Subscribe(ctx, nostr.Filters{{
+		Kinds:   []int{nip46Kind},
+		Authors: []string{signerPubkey},
+		Tags:    nostr.TagMap{"p": []string{c.pubkey}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.answers = sub.Events
+	return c
+}
+
+// request sends method to the signer and returns its answer
+func (c *nip46TestClient) request(t *testing.T, method string, params ...string) nip46Response {
+	t.Helper()
+	c.next++
+	request := nip46Request{ID: strconv.Itoa(c.next), Method: method, Params: params}
+	if params == nil {
+		request.Params = []string{}
+	}
+	plaintext, _ := json.Marshal(request)
+
+	conversationKey, err := nip44.GenerateConversationKey(c.signerPubkey, c.secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := nip44.Encrypt(string(plaintext), conversationKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := nostr.Event{
+		Kind:      nip46Kind,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", c.signerPubkey}},
+		Content:   content,
+	}
+	if err := event.Sign(c.secretKey); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.relay.Publish(ctx, event); err != nil {
+		t.Fatalf("publish %s: %v", method, err)
+	}
+
+	for {
+		select {
+		case answer := <-c.answers:
+			if ok, _ := answer.CheckSignature(); !ok {
+				t.Fatalf("%s: answer with a bad signature", method)
+			}
+			plaintext, err := nip44.Decrypt(answer.Content, conversationKey)
+			if err != nil {
+				t.Fatalf("%s: cannot decrypt the answer: %v", method, err)
+			}
+			var response nip46Response
+			if err := json.Unmarshal([]byte(plaintext), &response); err != nil {
+				t.Fatalf("%s: invalid answer %q", method, plaintext)
+			}
+			if response.ID == request.ID {
+				return response
+			}
+		case <-ctx.Done():
+			t.Fatalf("%s: no answer from the signer", method)
+		}
+	}
+}
+
+// stopNIP46 disconnects the bunker listener and every session of d
+func stopNIP46(d *Daemon) {
+	d.nip46Mu.Lock()
+	defer d.nip46Mu.Unlock()
+	if d.bunker != nil {
+		d.bunker.cancel()
+		d.bunker = nil
+	}
+	for _, s := range d.nip46Sessions {
+		s.cancel()
+	}
+}
+
+func TestNIP46Bunker(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "main")
+	d := testDaemon(t, npub)
+	relay := startTestRelay(t)
+	t.Cleanup(func() { stopNIP46(d) })
+
+	bunker := d.nip46Bunker(SignRequest{ID: "1", Relays: []string{relay.URL}, Perms: []string{"sign_event:1"}, AppName: "test-app"})
+	if bunker.Error != "" {
+		t.Fatalf("nip46_bunker: %s", bunker.Error)
+	}
+	uri, err := url.Parse(bunker.URI)
+	if err != nil || uri.Scheme != "bunker" || uri.Host != d.pubkey {
+		t.Fatalf("bunker URI %q: want bunker://%s", bunker.URI, d.pubkey)
+	}
+	if got := uri.Query()["relay"]; len(got) != 1 || got[0] != relay.URL {
+		t.Fatalf("bunker URI relays %v, want [%s]", got, relay.URL)
+	}
+	secret := uri.Query().Get("secret")
+	if secret == "" {
+		t.Fatalf("bunker URI %q has no secret", bunker.URI)
+	}
+
+	// The bunker listens for anyone writing to the account
+	relay.waitSubscription(t, func(f nostr.Filter) bool { return len(f.Authors) == 0 })
+	client := newNIP46TestClient(t, relay.URL, d.pubkey)
+
+	t.Run("wrong secret", func(t *testing.T) {
+		stranger := newNIP46TestClient(t, relay.URL, d.pubkey)
+		response := stranger.request(t, "connect", d.pubkey, "not-the-secret")
+		if response.Error == "" || response.Result != "" {
+			t.Fatalf("connect with a wrong secret: %+v, want an error", response)
+		}
+		if _, ok := findTestGrant(t, stranger.pubkey); ok {
+			t.Fatal("a grant was stored for a wrong secret")
+		}
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		response := client.request(t, "connect", d.pubkey, secret)
+		if response.Error != "" || response.Result != "ack" {
+			t.Fatalf("connect: %+v, want ack", response)
+		}
+		grant, ok := findTestGrant(t, client.pubkey)
+		if !ok {
+			t.Fatal("connect stored no grant")
+		}
+		if grant.Name != "test-app" || grant.Npub != npub || len(grant.Perms) != 1 || grant.Perms[0] != "sign_event:1" {
+			t.Fatalf("grant %+v: want test-app for %s with sign_event:1", grant, npub)
+		}
+		// Its own session serves the client from now on
+		relay.waitSubscription(t, func(f nostr.Filter) bool {
+			return len(f.Authors) == 1 && f.Authors[0] == client.pubkey
+		})
+
+		// The secret was for one client only: with no unused URI left the
+		// bunker stops listening once the answer is out
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			d.nip46Mu.Lock()
+			listening, offers := d.bunker != nil, len(d.bunkerOffers)
+			d.nip46Mu.Unlock()
+			if !listening && offers == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("after connect: bunker listening %v with %d unused URIs, want stopped with none", listening, offers)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("get_public_key", func(t *testing.T) {
+		response := client.request(t, "get_public_key")
+		if response.Error != "" || response.Result != d.pubkey {
+			t.Fatalf("get_public_key: %+v, want %s", response, d.pubkey)
+		}
+	})
+
+	t.Run("sign_event", func(t *testing.T) {
+		unsigned := `{"kind":1,"created_at":1700000000,"tags":[],"content":"signed over NIP-46"}`
+		response := client.request(t, "sign_event", unsigned)
+		if response.Error != "" {
+			t.Fatalf("sign_event: %s", response.Error)
+		}
+		var event nostr.Event
+		if err := json.Unmarshal([]byte(response.Result), &event); err != nil {
+			t.Fatalf("sign_event result %q: %v", response.Result, err)
+		}
+		if event.PubKey != d.pubkey || event.Kind != 1 || event.Content != "signed over NIP-46" || event.CreatedAt != 1700000000 {
+			t.Fatalf("signed event %+v does not match the request", event)
+		}
+		if ok, err := event.CheckSignature(); !ok {
+			t.Fatalf("signed event does not verify: %v", err)
+		}
+	})
+
+	t.Run("sign_event kind not permitted", func(t *testing.T) {
+		response := client.request(t, "sign_event", `{"kind":4,"created_at":1700000000,"tags":[],"content":"x"}`)
+		if !strings.HasPrefix(response.Error, "not permitted") || response.Result != "" {
+			t.Fatalf("sign_event kind 4: %+v, want not permitted", response)
+		}
+	})
+}
+
+// findTestGrant returns the stored grant for clientPubkey
+func findTestGrant(t *testing.T, clientPubkey string) (Grant, bool) {
+	t.Helper()
+	grants, err := loadGrants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return findGrant(grants, clientPubkey)
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notificationTimeout bounds how long the desktop notification command may run
+const notificationTimeout = 10 * time.Second
+
+// notificationCategory is a group of desktop notifications that is switched
+// on and off and throttled together
+type notificationCategory struct {
+	Name            string
+	Help            string
+	DefaultInterval string
+}
+
+// notificationCategories are the categories in config.json
+// (notifications.categories.<name>)
+var notificationCategories = []notificationCategory{
+	{Name: "signing", Help: "Events signed", DefaultInterval: "1m"},
+	{Name: "locking", Help: "Daemon locked, unlocked or waiting for a password", DefaultInterval: "1m"},
+	{Name: "trust_expiry", Help: "Trust session expired", DefaultInterval: "1h"},
+	{Name: "anomaly", Help: "Key health check failures", DefaultInterval: "5m"},
+}
+
+// maxNotificationInterval bounds the per-category interval
+const maxNotificationInterval = 24 * time.Hour
+
+// NotificationConfig is the notifications section of config.json
+type NotificationConfig struct {
+	// Enabled turns desktop notifications on (off by default)
+	Enabled bool `json:"enabled,omitempty"`
+	// QuietHours is a local time window without notifications, e.g. "22:00-07:00"
+	QuietHours string `json:"quiet_hours,omitempty"`
+	// Categories holds per-category preferences, keyed by category name
+	Categories map[string]NotificationPreference `json:"categories,omitempty"`
+}
+
+// NotificationPreference are the settings of one category
+type NotificationPreference struct {
+	// Enabled switches the category off with false (default on)
+	Enabled *bool `json:"enabled,omitempty"`
+	// Interval is the minimum time between two notifications of the
+	// category; notifications in between are collapsed
+	Interval string `json:"interval,omitempty"`
+}
+
+// findNotificationCategory returns the category named name, or nil
+func findNotificationCategory(name string) *notificationCategory {
+	for i := range notificationCategories {
+		if notificationCategories[i].Name == name {
+			return &notificationCategories[i]
+		}
+	}
+	return nil
+}
+
+// notificationPreference returns the preference for category (zero if unset)
+func (c *Config) notificationPreference(category string) NotificationPreference {
+	if c == nil || c.Notifications == nil {
+		return NotificationPreference{}
+	}
+	return c.Notifications.Categories[category]
+}
+
+// updateNotifications applies update to the notifications section and drops
+// whatever is left empty, so a reset removes the key from config.json
+func (c *Config) updateNotifications(update func(n *NotificationConfig)) {
+	if c.Notifications == nil {
+		c.Notifications = &NotificationConfig{}
+	}
+	update(c.Notifications)
+
+	for name, pref := range c.Notifications.Categories {
+		if pref.Enabled == nil && pref.Interval == "" {
+			delete(c.Notifications.Categories, name)
+		}
+	}
+	if len(c.Notifications.Categories) == 0 {
+		c.Notifications.Categories = nil
+	}
+	if !c.Notifications.Enabled && c.Notifications.QuietHours == "" && c.Notifications.Categories == nil {
+		c.Notifications = nil
+	}
+}
+
+// updateNotificationPreference applies update to one category's preference
+func (c *Config) updateNotificationPreference(category string, update func(p *NotificationPreference)) {
+	c.updateNotifications(func(n *NotificationConfig) {
+		if n.Categories == nil {
+			n.Categories = make(map[string]NotificationPreference)
+		}
+		pref := n.Categories[category]
+		update(&pref)
+		n.Categories[category] = pref
+	})
+}
+
+// notificationConfigOptions returns the config keys of the notifications
+// section: notifications.enabled, notifications.quiet_hours and an enabled
+// and interval key per category
+func notificationConfigOptions() []configOption {
+	options := []configOption{
+		{
+			Key:     "notifications.enabled",
+			Help:    "Desktop notifications",
+			Default: "false",
+			get: func(c *Config) string {
+				if c.Notifications == nil || !c.Notifications.Enabled {
+					return ""
+				}
+				return "true"
+			},
+			set: func(c *Config, value string) error {
+				enabled := false
+				if value != "" {
+					var err error
+					if enabled, err = strconv.ParseBool(value); err != nil {
+						return fmt.Errorf("invalid notifications.enabled %q (use true or false)", value)
+					}
+				}
+				c.updateNotifications(func(n *NotificationConfig) { n.Enabled = enabled })
+				return nil
+			},
+		},
+		{
+			Key:     "notifications.quiet_hours",
+			Help:    "No notifications in this local time window (e.g. 22:00-07:00)",
+			Default: "off",
+			get: func(c *Config) string {
+				if c.Notifications == nil {
+					return ""
+				}
+				return c.Notifications.QuietHours
+			},
+			set: func(c *Config, value string) error {
+				if _, err := parseQuietHours(value); err != nil {
+					return err
+				}
+				c.updateNotifications(func(n *NotificationConfig) { n.QuietHours = value })
+				return nil
+			},
+		},
+	}
+
+	for _, category := range notificationCategories {
+		name := category.Name
+		enabledKey := "notifications." + name + ".enabled"
+		intervalKey := "notifications." + name + ".interval"
+		options = append(options,
+			configOption{
+				Key:     enabledKey,
+				Help:    category.Help,
+				Default: "true",
+				get: func(c *Config) string {
+					if enabled := c.notificationPreference(name).Enabled; enabled != nil {
+						return strconv.FormatBool(*enabled)
+					}
+					return ""
+				},
+				set: func(c *Config, value string) error {
+					var enabled *bool
+					if value != "" {
+						parsed, err := strconv.ParseBool(value)
+						if err != nil {
+							return fmt.Errorf("invalid %s %q (use true or false)", enabledKey, value)
+						}
+						enabled = &parsed
+					}
+					c.updateNotificationPreference(name, func(p *NotificationPreference) { p.Enabled = enabled })
+					return nil
+				},
+			},
+			configOption{
+				Key:     intervalKey,
+				Help:    "Minimum time between " + strings.ReplaceAll(name, "_", " ") + " notifications",
+				Default: category.DefaultInterval,
+				get:     func(c *Config) string { return c.notificationPreference(name).Interval },
+				set: func(c *Config, value string) error {
+					if value != "" {
+						if _, err := parseNotificationInterval(intervalKey, value); err != nil {
+							return err
+						}
+					}
+					c.updateNotificationPreference(name, func(p *NotificationPreference) { p.Interval = value })
+					return nil
+				},
+			},
+		)
+	}
+	return options
+}
+
+// parseNotificationInterval parses a category interval (0 = no throttling)
+func parseNotificationInterval(key, value string) (time.Duration, error) {
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", key, value, err)
+	}
+	if interval < 0 || interval > maxNotificationInterval {
+		return 0, fmt.Errorf("%s must be between 0 and 24h", key)
+	}
+	return interval, nil
+}
+
+// quietHours is a daily local time window, in minutes after midnight. The
+// window wraps around midnight when start is after end.
+type quietHours struct {
+	start, end int
+}
+
+// parseQuietHours parses "HH:MM-HH:MM" (empty = no quiet hours)
+func parseQuietHours(value string) (*quietHours, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid notifications.quiet_hours %q (use HH:MM-HH:MM, e.g. 22:00-07:00)", value)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return nil, fmt.Errorf("invalid notifications.quiet_hours %q (use HH:MM-HH:MM, e.g. 22:00-07:00)", value)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return nil, fmt.Errorf("invalid notifications.quiet_hours %q (use HH:MM-HH:MM, e.g. 22:00-07:00)", value)
+	}
+
+	window := &quietHours{
+		start: start.Hour()*60 + start.Minute(),
+		end:   end.Hour()*60 + end.Minute(),
+	}
+	if window.start == window.end {
+		return nil, fmt.Errorf("notifications.quiet_hours %q is empty - start and end are the same", value)
+	}
+	return window, nil
+}
+
+// contains reports whether t falls into the window
+func (q *quietHours) contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if q.start < q.end {
+		return minute >= q.start && minute < q.end
+	}
+	return minute >= q.start || minute < q.end
+}
+
+// notificationText renders a notification that stands for count collapsed
+// ones, window being the category interval
+type notificationText func(count int, window time.Duration) string
+
+// pendingNotification collects identical notifications until the category
+// may notify again
+type pendingNotification struct {
+	count int
+	text  notificationText
+}
+
+// categoryState is the throttling state of one category
+type categoryState struct {
+	lastSent  time.Time
+	pending   map[string]*pendingNotification
+	order     []string // Keys of pending, first seen first
+	scheduled bool     // A flush is scheduled
+}
+
+// notifier is the daemon's notification manager. Every desktop notification
+// goes through it: it applies the category preferences and quiet hours,
+// and collapses identical notifications within a category's interval into
+// one with a count. The clock, timer and delivery are injected.
+type notifier struct {
+	enabled   bool
+	quiet     *quietHours
+	enabledBy map[string]bool
+	intervals map[string]time.Duration
+
+	now       func() time.Time
+	afterFunc func(time.Duration, func())
+	send      func(text string)
+
+	mu     sync.Mutex
+	states map[string]*categoryState
+}
+
+// newNotifier builds the notification manager from config. Invalid values
+// were already reported (and reset) when config.json was loaded.
+func newNotifier(config *Config, now func() time.Time, afterFunc func(time.Duration, func()), send func(string)) *notifier {
+	n := &notifier{
+		enabledBy: make(map[string]bool),
+		intervals: make(map[string]time.Duration),
+		now:       now,
+		afterFunc: afterFunc,
+		send:      send,
+		states:    make(map[string]*categoryState),
+	}
+	if config != nil && config.Notifications != nil {
+		n.enabled = config.Notifications.Enabled
+		n.quiet, _ = parseQuietHours(config.Notifications.QuietHours)
+	}
+
+	for _, category := range notificationCategories {
+		pref := config.notificationPreference(category.Name)
+		n.enabledBy[category.Name] = pref.Enabled == nil || *pref.Enabled
+
+		interval := pref.Interval
+		if interval == "" {
+			interval = category.DefaultInterval
+		}
+		n.intervals[category.Name], _ = parseNotificationInterval(category.Name, interval)
+	}
+	return n
+}
+
+// notify shows a notification, or collapses it into the next one if the
+// category notified less than its interval ago. Notifications with the same
+// key are identical; count says how many this one stands for.
+func (n *notifier) notify(category, key string, count int, text notificationText) {
+	if n == nil || !n.enabled || !n.enabledBy[category] {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	if n.quiet.contains(now) {
+		return
+	}
+
+	state := n.states[category]
+	if state == nil {
+		state = &categoryState{pending: make(map[string]*pendingNotification)}
+		n.states[category] = state
+	}
+
+	interval := n.intervals[category]
+	if len(state.pending) == 0 && (state.lastSent.IsZero() || now.Sub(state.lastSent) >= interval) {
+		state.lastSent = now
+		n.send(text(count, interval))
+		return
+	}
+
+	pending := state.pending[key]
+	if pending == nil {
+		pending = &pendingNotification{text: text}
+		state.pending[key] = pending
+		state.order = append(state.order, key)
+	}
+	pending.count += count
+
+	if !state.scheduled {
+		state.scheduled = true
+		n.afterFunc(state.lastSent.Add(interval).Sub(now), func() { n.flush(category) })
+	}
+}
+
+// flush shows the notifications a category collected during its interval
+func (n *notifier) flush(category string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	state := n.states[category]
+	if state == nil {
+		return
+	}
+	pending, order := state.pending, state.order
+	state.pending = make(map[string]*pendingNotification)
+	state.order = nil
+	state.scheduled = false
+
+	now := n.now()
+	if n.quiet.contains(now) {
+		return
+	}
+	for _, key := range order {
+		n.send(pending[key].text(pending[key].count, n.intervals[category]))
+	}
+	state.lastSent = now
+}
+
+// handleEvent turns a stream event into a notification, if it has one
+func (n *notifier) handleEvent(event StreamEvent) {
+	if n == nil || !n.enabled {
+		return
+	}
+
+	who := displayNpub(event.Npub)
+	switch event.Type {
+	case "activity":
+		entry := event.Activity
+		if entry == nil || !entry.Success || (entry.Method != "sign_event" && entry.Method != "sign_events" && entry.Method != "zap_request" && entry.Method != "sign_relay_list" && entry.Method != "gift_wrap" && entry.Method != "send_dm") {
+			return
+		}
+		count := 1
+		if entry.Method == "sign_events" && entry.Items > 0 {
+			count = entry.Items
+		}
+		n.notify("signing", "signed "+entry.Npub, count, func(count int, window time.Duration) string {
+			if count == 1 && entry.Kind != nil {
+				return fmt.Sprintf("Signed %s as %s", describeKind(*entry.Kind), displayNpub(entry.Npub))
+			}
+			if count == 1 {
+				return fmt.Sprintf("Signed an event as %s", displayNpub(entry.Npub))
+			}
+			return fmt.Sprintf("Signed %d events as %s in the last %s", count, displayNpub(entry.Npub), describeWindow(window))
+		})
+
+	case "locked":
+		n.notify("locking", "locked "+event.Npub, 1, repeatedText("🔒 Locked: "+who))
+	case "unlocked":
+		n.notify("locking", "unlocked "+event.Npub, 1, repeatedText("🔓 Unlocked for "+who))
+	case "credential_requested":
+		n.notify("locking", "credential "+event.Npub, 1, repeatedText("🔑 Waiting for the password of "+who+" - run: noorsigner respond"))
+
+	case "trust_expired":
+		n.notify("trust_expiry", "expired "+event.Npub, 1, repeatedText("⏰ Trust session of "+who+" expired - locked until the password is entered again"))
+
+	case "key_health_failed":
+		n.notify("anomaly", "health "+event.Npub, 1, repeatedText("❌ Key health check failed for "+who+" - restore keys.encrypted from backup"))
+	}
+}
+
+// repeatedText renders a fixed notification, with a count once it repeats
+func repeatedText(text string) notificationText {
+	return func(count int, window time.Duration) string {
+		if count == 1 {
+			return text
+		}
+		return fmt.Sprintf("%s (%d times in the last %s)", text, count, describeWindow(window))
+	}
+}
+
+// describeWindow formats an interval for notification texts ("minute", "5m0s")
+func describeWindow(window time.Duration) string {
+	switch window {
+	case time.Minute:
+		return "minute"
+	case time.Hour:
+		return "hour"
+	}
+	return window.String()
+}
+
+// sendDesktopNotification shows text with the platform's notification
+// tool: notify-send (Linux, BSD), osascript (macOS) or a PowerShell balloon
+// tip (Windows). The text is passed in the environment, never through a
+// shell or script source.
+func sendDesktopNotification(text string) {
+	text = redactSecrets("notification", text)
+	var cmd *exec.Cmd
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.CommandContext(ctx, "osascript", "-e",
+			`display notification (system attribute "NOORSIGNER_NOTIFICATION") with title "NoorSigner"`)
+	case "windows":
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command",
+			`Add-Type -AssemblyName System.Windows.Forms, System.Drawing; `+
+				`$n = New-Object System.Windows.Forms.NotifyIcon; `+
+				`$n.Icon = [System.Drawing.SystemIcons]::Information; $n.Visible = $true; `+
+				`$n.ShowBalloonTip(5000, 'NoorSigner', $env:NOORSIGNER_NOTIFICATION, 'Info'); `+
+				`Start-Sleep -Seconds 6; $n.Dispose()`)
+	default:
+		cmd = exec.CommandContext(ctx, "notify-send", "--app-name=NoorSigner", "NoorSigner", text)
+	}
+	cmd.Env = append(os.Environ(), "NOORSIGNER_NOTIFICATION="+text)
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		logDebug("Desktop notification failed: %v", err)
+		return
+	}
+	go func() {
+		defer cancel()
+		if err := cmd.Wait(); err != nil {
+			logDebug("Desktop notification failed: %v", err)
+		}
+	}()
+}
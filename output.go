@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// jsonOutput is set by the global --json flag. Commands that support it
+// print exactly one single-line JSON document on stdout; prompts, progress
+// and warnings go to stderr.
+var jsonOutput bool
+
+// jsonStdout is the real stdout. With --json, os.Stdout points at stderr so
+// human-oriented output never mixes with the JSON document.
+var jsonStdout = os.Stdout
+
+// The --json schemas below are part of the CLI's public interface: add
+// fields, but don't rename or remove them.
+
+// ErrorOutput is printed by any --json command that fails
+type ErrorOutput struct {
+	Error string `json:"error"`
+}
+
+// AccountOutput is one account of list-accounts --json
+type AccountOutput struct {
+	Npub          string `json:"npub"`
+	Pubkey        string `json:"pubkey"`
+	KeyID         string `json:"key_id"`
+	CreatedAt     int64  `json:"created_at"`
+	Label         string `json:"label,omitempty"`
+	Active        bool   `json:"active"`
+	HealthWarning string `json:"health_warning,omitempty"`
+	// Profile is the cached kind 0 profile (see profile.go)
+	Profile *AccountProfile `json:"profile,omitempty"`
+	// Counters as last written by the daemon (see counters.go)
+	Counters *AccountCounters `json:"counters,omitempty"`
+}
+
+// AccountsOutput is the list-accounts --json document
+type AccountsOutput struct {
+	Accounts   []AccountOutput `json:"accounts"`
+	ActiveNpub string          `json:"active_npub,omitempty"`
+}
+
+// StatusOutput is the status --json document. Without a running daemon only
+// Running (false) and the CLI's view of Config are set.
+type StatusOutput struct {
+	Running            bool              `json:"running"`
+	Version            string            `json:"version,omitempty"`
+	PID                int               `json:"pid,omitempty"`
+	StartedAt          int64             `json:"started_at,omitempty"`
+	Npub               string            `json:"npub,omitempty"`
+	Label              string            `json:"label,omitempty"`
+	IsUnlocked         bool              `json:"is_unlocked"`
+	TrustMode          bool              `json:"trust_mode"`
+	TrustExpiresAt     int64             `json:"trust_expires_at,omitempty"`
+	TrustIdleExpiresAt int64             `json:"trust_idle_expires_at,omitempty"`
+	TrustHardLimitAt   int64             `json:"trust_hard_limit_at,omitempty"`
+	Socket             string            `json:"socket,omitempty"`
+	Draining           bool              `json:"draining"`
+	InFlight           int64             `json:"in_flight"`
+	Connections        *ConnectionStats  `json:"connections,omitempty"`
+	Frozen             bool              `json:"frozen"`
+	FrozenUntil        int64             `json:"frozen_until,omitempty"`
+	Config             map[string]string `json:"config"`
+	ConfigWarnings     []string          `json:"config_warnings,omitempty"`
+	Security           *SecuritySummary  `json:"security,omitempty"`
+	RecentRequests     []RecentRequest   `json:"recent_requests,omitempty"`
+	RateLimits         []RateLimitStats  `json:"rate_limits,omitempty"`
+	AccountWatch       string            `json:"account_watch,omitempty"`
+	LockHistory        []LockEvent       `json:"lock_history,omitempty"`
+	Stats              *StatsResponse    `json:"stats,omitempty"`
+}
+
+// PingOutput is the ping --json document
+type PingOutput struct {
+	Reachable bool   `json:"reachable"`
+	Unlocked  bool   `json:"unlocked"`
+	Version   int    `json:"version,omitempty"`
+	Seq       uint64 `json:"seq,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// WhoamiOutput is the whoami --json document. DaemonRunning is also true
+// for a daemon that refused to answer (require_auth); Unlocked is then
+// false, as the CLI can't tell.
+type WhoamiOutput struct {
+	Npub           string `json:"npub"`
+	Pubkey         string `json:"pubkey"`
+	KeyID          string `json:"key_id"`
+	Label          string `json:"label,omitempty"`
+	DaemonRunning  bool   `json:"daemon_running"`
+	Unlocked       bool   `json:"unlocked"`
+	TrustExpiresAt int64  `json:"trust_expires_at,omitempty"`
+	refused        bool
+}
+
+// SignOutput is the sign --json document
+type SignOutput struct {
+	Npub      string `json:"npub"`
+	Pubkey    string `json:"pubkey"`
+	Hash      string `json:"hash"`
+	Signature string `json:"signature"`
+}
+
+// TestDaemonOutput is the test-daemon --json document
+type TestDaemonOutput struct {
+	Signature string `json:"signature"`
+}
+
+// enableJSONOutput switches the CLI into --json mode
+func enableJSONOutput() {
+	jsonOutput = true
+	redirectChatter()
+}
+
+// redirectChatter sends human-oriented output to stderr, for commands whose
+// stdout is a single document (--json, sign)
+func redirectChatter() {
+	if os.Stdout != os.Stderr {
+		jsonStdout = os.Stdout
+		os.Stdout = os.Stderr
+	}
+}
+
+// printJSON writes a document as one line on the real stdout
+func printJSON(document interface{}) {
+	encoder := json.NewEncoder(jsonStdout)
+	encoder.SetEscapeHTML(false) // Event content stays as written
+	encoder.Encode(document)
+}
+
+// commandError is a failed command: main prints Message and exits with
+// Code. An empty Message was already reported.
+type commandError struct {
+	Code    int
+	Message string
+}
+
+func (e *commandError) Error() string {
+	return e.Message
+}
+
+// commandFailed returns the error a command fails with; main reports it
+// with exitOnError
+func commandFailed(code int, format string, args ...interface{}) error {
+	return &commandError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// exitOnError reports a command's error and exits: with its code for a
+// *commandError, 1 otherwise. In --json mode the message becomes
+// {"error": "..."} on stdout. A nil error returns.
+func exitOnError(err error) {
+	if err == nil {
+		return
+	}
+
+	code, message := 1, "❌ "+err.Error()
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		code, message = cmdErr.Code, cmdErr.Message
+	}
+	switch {
+	case message == "":
+	case jsonOutput:
+		printJSON(ErrorOutput{Error: strings.TrimPrefix(message, "❌ ")})
+	default:
+		fmt.Println(message)
+	}
+	os.Exit(code)
+}
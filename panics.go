@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"runtime/debug"
+	"time"
+)
+
+// codeInternal answers a request whose handler panicked
+const codeInternal = "ERR_INTERNAL"
+
+// recoverConnection is deferred by handleConnection. A panic while serving a
+// request is logged with its stack and answered with ERR_INTERNAL, and only
+// that connection is closed; the daemon keeps serving everyone else.
+func (d *Daemon) recoverConnection(conn net.Conn, session *connSession, encoder *json.Encoder) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	req := session.request
+	logError("conn %d: panic while handling id=%q method=%q: %v\n%s", session.id, req.ID, req.Method, r, debug.Stack())
+
+	// Drain must not wait for a request that will never finish
+	if session.inFlight {
+		d.endRequest(req.Method)
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	encoder.Encode(SignResponse{
+		ID:    req.ID,
+		Error: "internal error - the request was not completed",
+		Code:  codeInternal,
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestPanicClosesOnlyItsConnection(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	d := testDaemon(t, npub)
+	// A nil request makes respond_credential panic for this nonce only
+	d.pendingCredentials["panic"] = nil
+	serveTestDaemon(t, d)
+
+	// Connections opened before the panic keep working after it
+	var others []*testConn
+	for i := 0; i < 3; i++ {
+		others = append(others, dialTestDaemon(t))
+	}
+
+	panicking := dialTestDaemon(t)
+	var response SignResponse
+	err := panicking.request(t, SignRequest{ID: "boom", Method: "respond_credential", Nonce: "panic", Password: "x"}, &response)
+	if err != nil {
+		t.Fatalf("no answer from the panicking request: %v", err)
+	}
+	if response.ID != "boom" || response.Code != codeInternal {
+		t.Fatalf("response = %+v, want %s for id boom", response, codeInternal)
+	}
+	var next SignResponse
+	if err := panicking.decoder.Decode(&next); err != io.EOF {
+		t.Errorf("the panicking connection stayed open: %v %+v", err, next)
+	}
+
+	var wg sync.WaitGroup
+	for i, conn := range append(others, dialTestDaemon(t)) {
+		wg.Add(1)
+		go func(i int, conn *testConn) {
+			defer wg.Done()
+			var response SignResponse
+			if err := conn.request(t, SignRequest{ID: "pk", Method: "get_public_key"}, &response); err != nil {
+				t.Errorf("connection %d: %v", i, err)
+				return
+			}
+			if response.Error != "" || response.Signature != d.pubkey {
+				t.Errorf("connection %d: response = %+v, want the public key", i, response)
+			}
+		}(i, conn)
+	}
+	wg.Wait()
+
+	if inFlight := d.inFlight.Load(); inFlight != 0 {
+		t.Errorf("%d requests still in flight after the panic; drain would wait for them", inFlight)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// passwordEnv supplies the account password to scripts. Other processes of
+// the same user can read it (/proc/<pid>/environ), so using it prints a warning.
+const passwordEnv = "NOORSIGNER_PASSWORD"
+
+// passwordFlagsUsage is appended to the usage of commands that accept
+// non-interactive passwords
+const passwordFlagsUsage = "[--password-file <path> | --password-fd <n>]"
+
+// passwordSource reads the account password without a prompt. It is set by
+// parsePasswordFlags; nil means ask interactively.
+var passwordSource func() (string, error)
+
+// parsePasswordFlags removes --password-file and --password-fd from args and
+// selects where readAccountPassword gets the password from: the file, then
+// the descriptor, then $NOORSIGNER_PASSWORD, in that order.
+func parsePasswordFlags(args []string) ([]string, error) {
+	var file, fd string
+	var rest []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--password-file" || arg == "--password-fd":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			if arg == "--password-file" {
+				file = args[i]
+			} else {
+				fd = args[i]
+			}
+		case strings.HasPrefix(arg, "--password-file="):
+			file = strings.TrimPrefix(arg, "--password-file=")
+		case strings.HasPrefix(arg, "--password-fd="):
+			fd = strings.TrimPrefix(arg, "--password-fd=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	// Take the variable out of the environment so the forked daemon and
+	// hooks don't inherit it
+	envPassword, envSet := os.LookupEnv(passwordEnv)
+	os.Unsetenv(passwordEnv)
+
+	switch {
+	case file != "":
+		passwordSource = func() (string, error) { return readPasswordFile(file) }
+	case fd != "":
+		n, err := strconv.Atoi(fd)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid --password-fd %q", fd)
+		}
+		passwordSource = func() (string, error) { return readPasswordFD(n) }
+	case envSet:
+		passwordSource = func() (string, error) {
+			fmt.Fprintf(os.Stderr, "⚠️  WARNING: using the password from $%s\n", passwordEnv)
+			fmt.Fprintln(os.Stderr, "   Environment variables can leak via /proc, ps and crash reports.")
+			fmt.Fprintln(os.Stderr, "   Prefer --password-file or --password-fd.")
+			if envPassword == "" {
+				return "", fmt.Errorf("$%s is empty", passwordEnv)
+			}
+			return envPassword, nil
+		}
+	}
+
+	return rest, nil
+}
+
+// readAccountPassword asks for an existing account's password, or takes it
+// from the source selected by parsePasswordFlags
+func readAccountPassword(prompt string) (string, error) {
+	if passwordSource == nil {
+		return readPassword(prompt)
+	}
+
+	password, err := passwordSource()
+	passwordSource = nil // Files and descriptors are read once
+	return password, err
+}
+
+// readPasswordFile returns the first line of path without its line ending
+func readPasswordFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot open password file: %v", err)
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "⚠️  Password file %s is readable by other users (chmod 600 it)\n", path)
+	}
+
+	password, err := readLine(file, false)
+	if err != nil || password == "" {
+		return "", fmt.Errorf("password file %s is empty", path)
+	}
+	return password, nil
+}
+
+// readPasswordFD reads one line from an inherited file descriptor (on
+// Windows: an inherited handle)
+func readPasswordFD(fd int) (string, error) {
+	file := os.NewFile(uintptr(fd), "password-fd")
+	if file == nil {
+		return "", fmt.Errorf("invalid --password-fd %d", fd)
+	}
+	defer file.Close()
+
+	password, err := readLine(file, false)
+	if err != nil {
+		return "", fmt.Errorf("cannot read password from fd %d: %v", fd, err)
+	}
+	if password == "" {
+		return "", fmt.Errorf("no password on fd %d", fd)
+	}
+	return password, nil
+}
+
+// passwordArgs is parsePasswordFlags for CLI commands: a bad flag fails
+// the command with its usage
+func passwordArgs(args []string, usage string) ([]string, error) {
+	rest, err := parsePasswordFlags(args)
+	if err != nil {
+		return nil, commandFailed(1, "%v\nUsage: %s", err, usage)
+	}
+	return rest, nil
+}
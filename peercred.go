@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"net"
+	"os"
+)
+
+// peerIsSameUser reports whether the process on the other end of conn runs
+// as the daemon's user
+func peerIsSameUser(conn net.Conn) (bool, error) {
+	cred, err := peerCredentials(conn)
+	if err != nil {
+		return false, err
+	}
+	return cred.UID == os.Getuid(), nil
+}
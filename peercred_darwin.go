@@ -0,0 +1,41 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredentialsMode is reported as peer_credentials in get_status
+const peerCredentialsMode = "uid_checked"
+
+// peerCredentials returns the user and process on the other end of conn
+// (LOCAL_PEERCRED, LOCAL_PEERPID)
+func peerCredentials(conn net.Conn) (peerCred, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return peerCred{}, fmt.Errorf("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return peerCred{}, err
+	}
+
+	var cred *unix.Xucred
+	var credErr error
+	pid := 0
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+		// The PID is only for logs; 0 when unavailable
+		pid, _ = unix.GetsockoptInt(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+	}); err != nil {
+		return peerCred{}, err
+	}
+	if credErr != nil {
+		return peerCred{}, fmt.Errorf("cannot read peer credentials: %v", credErr)
+	}
+	return peerCred{UID: int(cred.Uid), PID: pid}, nil
+}
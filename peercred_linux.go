@@ -0,0 +1,38 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredentialsMode is reported as peer_credentials in get_status
+const peerCredentialsMode = "uid_checked"
+
+// peerCredentials returns the user and process on the other end of conn
+// (SO_PEERCRED)
+func peerCredentials(conn net.Conn) (peerCred, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return peerCred{}, fmt.Errorf("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return peerCred{}, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return peerCred{}, err
+	}
+	if credErr != nil {
+		return peerCred{}, fmt.Errorf("cannot read peer credentials: %v", credErr)
+	}
+	return peerCred{UID: int(cred.Uid), PID: int(cred.Pid)}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !windows && !linux && !darwin
+
+package main
+
+import "net"
+
+// peerCredentialsMode is reported as peer_credentials in get_status
+const peerCredentialsMode = "not_checked"
+
+// peerCredentials can't read peer credentials on this platform
+func peerCredentials(conn net.Conn) (peerCred, error) {
+	return peerCred{}, errPeerCredentialsUnsupported
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// credentialTTL is how long an operator has to answer a credential request
+// before it is replaced by a fresh one
+const credentialTTL = 15 * time.Minute
+
+// PendingCredential is a request for an account password that the daemon
+// could not prompt for itself (no TTY)
+type PendingCredential struct {
+	Nonce     string `json:"nonce"`
+	Npub      string `json:"npub"`
+	Reason    string `json:"reason"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// PendingCredentialsResponse represents pending_credentials response
+type PendingCredentialsResponse struct {
+	ID       string              `json:"id"`
+	Requests []PendingCredential `json:"requests"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// requestCredential queues a credential request for npub and announces it
+// on the event stream
+func (d *Daemon) requestCredential(npub, reason string) {
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		logError("❌ Cannot create credential request: %v", err)
+		return
+	}
+
+	now := time.Now()
+	request := &PendingCredential{
+		Nonce:     hex.EncodeToString(nonceBytes),
+		Npub:      npub,
+		Reason:    reason,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(credentialTTL).Unix(),
+	}
+
+	d.credMu.Lock()
+	d.pendingCredentials[request.Nonce] = request
+	d.credMu.Unlock()
+
+	time.AfterFunc(credentialTTL, func() { d.expireCredential(request) })
+
+	logInfo("🔑 Waiting for credentials for %s (%s) - run: noorsigner respond %s",
+		displayNpub(npub), reason, request.Nonce)
+
+	pubkey, _ := npubToPubkey(npub)
+	d.emit(StreamEvent{
+		Type:   "credential_requested",
+		Npub:   npub,
+		Pubkey: pubkey,
+		Data: map[string]string{
+			"nonce":      request.Nonce,
+			"reason":     reason,
+			"expires_at": fmt.Sprintf("%d", request.ExpiresAt),
+		},
+	})
+}
+
+// expireCredential drops an unanswered request and issues a new one if the
+// daemon is still waiting for that account
+func (d *Daemon) expireCredential(request *PendingCredential) {
+	d.credMu.Lock()
+	_, stillPending := d.pendingCredentials[request.Nonce]
+	delete(d.pendingCredentials, request.Nonce)
+	d.credMu.Unlock()
+
+	if !stillPending {
+		return
+	}
+
+	logInfo("⌛ Credential request %s expired", request.Nonce)
+
+	d.mu.RLock()
+	stillLocked := d.privateKey == nil && d.npub == request.Npub
+	d.mu.RUnlock()
+
+	if stillLocked {
+		d.requestCredential(request.Npub, request.Reason)
+	}
+}
+
+// listPendingCredentials returns unexpired credential requests, oldest first
+func (d *Daemon) listPendingCredentials() []PendingCredential {
+	d.credMu.Lock()
+	defer d.credMu.Unlock()
+
+	now := time.Now().Unix()
+	requests := []PendingCredential{}
+	for _, request := range d.pendingCredentials {
+		if request.ExpiresAt > now {
+			requests = append(requests, *request)
+		}
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt < requests[j].CreatedAt
+	})
+	return requests
+}
+
+// respondCredential answers a pending request with a password, or with a
+// security key's secret if fido is set, and unlocks the daemon. A request is
+// consumed by its first successful answer; a wrong password leaves it
+// pending.
+func (d *Daemon) respondCredential(nonce, password string, fido bool) (string, error) {
+	d.credMu.Lock()
+	request, ok := d.pendingCredentials[nonce]
+	d.credMu.Unlock()
+
+	if !ok || request.ExpiresAt <= time.Now().Unix() {
+		return "", fmt.Errorf("unknown or expired credential request")
+	}
+
+	// A wrong password decrypts to garbage, so check it yields the expected key
+	var nsec string
+	var privateKey *btcec.PrivateKey
+	var err error
+	if fido {
+		nsec, privateKey, err = openFidoKey(request.Npub, password)
+	} else {
+		var encKey *EncryptedKey
+		encKey, err = loadAccountEncryptedKey(request.Npub)
+		if err != nil {
+			return "", fmt.Errorf("failed to load account: %v", err)
+		}
+		nsec, privateKey, err = decryptAccountKey(encKey, request.Npub, password)
+	}
+	if errors.Is(err, errBadPassword) {
+		logError("⚠️  Credential request %s: invalid password", nonce)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Single use - whoever removes it first wins
+	d.credMu.Lock()
+	_, ok = d.pendingCredentials[nonce]
+	delete(d.pendingCredentials, nonce)
+	d.credMu.Unlock()
+	if !ok {
+		privateKey.Zero()
+		return "", fmt.Errorf("unknown or expired credential request")
+	}
+
+	// Cache the credential unless Trust Mode is disabled (--no-trust)
+	if !d.noTrust {
+		session, err := createTrustSession(nsec)
+		if err == nil {
+			err = saveAccountTrustSession(request.Npub, session)
+		}
+		if err != nil {
+			logError("⚠️  No trust session for %s: %v", displayNpub(request.Npub), err)
+		}
+	}
+
+	// Clear nsec from memory
+	for i := range nsec {
+		nsec = nsec[:i] + "x" + nsec[i+1:]
+	}
+
+	pubkey, _ := npubToPubkey(request.Npub)
+
+	d.mu.Lock()
+	if d.privateKey != nil {
+		d.mu.Unlock()
+		privateKey.Zero()
+		return "", fmt.Errorf("daemon is already unlocked")
+	}
+	d.privateKey = privateKey
+	loadedKeys.add(privateKey)
+	d.npub = request.Npub
+	d.pubkey = pubkey
+	d.mu.Unlock()
+
+	logInfo("✅ Credential request %s granted - daemon unlocked for %s", nonce, displayNpub(request.Npub))
+	d.emit(StreamEvent{
+		Type:   "credential_granted",
+		Npub:   request.Npub,
+		Pubkey: pubkey,
+		Data:   map[string]string{"nonce": nonce},
+	})
+	d.emit(StreamEvent{Type: "unlocked", Npub: request.Npub, Pubkey: pubkey})
+	d.watchTrustSession(request.Npub)
+
+	return request.Npub, nil
+}
+
+// pendingCmd lists credential requests waiting on the running daemon
+func pendingCmd() error {
+	requests, err := listPendingCredentialsViaDaemon()
+	if err != nil {
+		return commandFailed(1, "Error: %v", err)
+	}
+	approvals, err := listPendingApprovalsViaDaemon()
+	if err != nil {
+		return commandFailed(1, "Error: %v", err)
+	}
+
+	if len(requests) == 0 && len(approvals) == 0 {
+		fmt.Println("No pending credential requests.")
+		return nil
+	}
+
+	if len(requests) > 0 {
+		fmt.Println("Pending credential requests:")
+		fmt.Println()
+		for _, request := range requests {
+			fmt.Printf("  %s\n", request.Nonce)
+			fmt.Printf("    Account: %s\n", displayAccount(request.Npub))
+			fmt.Printf("    Reason:  %s\n", request.Reason)
+			fmt.Printf("    Expires: %s\n", time.Unix(request.ExpiresAt, 0).Format("15:04:05"))
+		}
+		fmt.Println()
+		fmt.Println("Answer with: noorsigner respond <nonce>")
+	}
+
+	if len(approvals) > 0 {
+		if len(requests) > 0 {
+			fmt.Println()
+		}
+		fmt.Println("Requests waiting for confirmation (signing policy):")
+		fmt.Println()
+		for _, approval := range approvals {
+			fmt.Printf("  %s\n", approval.TraceID)
+			fmt.Printf("    Request: %s\n", describeApproval(approval.ApprovalContext))
+			if approval.Npub != "" {
+				fmt.Printf("    Account: %s\n", displayAccount(approval.Npub))
+			}
+			fmt.Printf("    Client:  %s\n", approval.Client)
+			if approval.ContentPreview != "" {
+				fmt.Printf("    Content: %s\n", approval.ContentPreview)
+			}
+			fmt.Printf("    Expires: %s\n", time.Unix(approval.ExpiresAt, 0).Format("15:04:05"))
+		}
+		fmt.Println()
+		fmt.Println("Answer with: noorsigner approve <id>  or  noorsigner reject <id>")
+	}
+	return nil
+}
+
+// respondCmd prompts for a password and delivers it for a pending request
+func respondCmd(nonce string) error {
+	requests, err := listPendingCredentialsViaDaemon()
+	if err != nil {
+		return commandFailed(1, "Error: %v", err)
+	}
+
+	var request *PendingCredential
+	for i := range requests {
+		if requests[i].Nonce == nonce {
+			request = &requests[i]
+			break
+		}
+	}
+	if request == nil {
+		return commandFailed(1, "No pending credential request: %s\nUse 'noorsigner pending' to see open requests.", nonce)
+	}
+
+	fmt.Printf("Daemon requests the password for: %s\n", request.Npub)
+	fmt.Printf("Reason: %s\n", request.Reason)
+	password, err := readPassword("Enter password: ")
+	if err != nil {
+		return commandFailed(1, "Error reading password: %v", err)
+	}
+
+	if err := respondCredentialViaDaemon(nonce, password, false); err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	fmt.Printf("✅ Daemon unlocked for: %s\n", displayNpub(request.Npub))
+	return nil
+}
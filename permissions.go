@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// permissionsMu serializes the daemon's read-modify-write of permissions.json
+var permissionsMu sync.Mutex
+
+// Permission is one entry of permissions.json: an approval an operator
+// asked to remember ("always allow kind 1 for app X"). Requests it covers
+// skip the confirmation - the signing policy's deny still applies.
+type Permission struct {
+	ID string `json:"id"`
+	// Identity is who it was given to: "client:<name>" for a client token,
+	// "exe:<path>" for an executable (see permissionIdentity)
+	Identity string `json:"identity"`
+	// Label names the app for people
+	Label  string `json:"label"`
+	Npub   string `json:"npub"`
+	Method string `json:"method"`
+	// Kinds are the event kinds it covers (signing methods only)
+	Kinds     []int `json:"kinds,omitempty"`
+	CreatedAt int64 `json:"created_at"`
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// permissionsFile is the layout of ~/.noorsigner/permissions.json
+type permissionsFile struct {
+	Permissions []Permission `json:"permissions"`
+}
+
+// getPermissionsPath returns the path of permissions.json
+func getPermissionsPath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storageDir, "permissions.json"), nil
+}
+
+// loadPermissions reads permissions.json (a missing file means none)
+func loadPermissions() ([]Permission, error) {
+	path, err := getPermissionsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read permissions.json: %v", err)
+	}
+
+	var file permissionsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid permissions.json: %v", err)
+	}
+	return file.Permissions, nil
+}
+
+// savePermissions writes permissions.json (mode 0600)
+func savePermissions(permissions []Permission) error {
+	path, err := getPermissionsPath()
+	if err != nil {
+		return err
+	}
+	if permissions == nil {
+		permissions = []Permission{}
+	}
+
+	data, err := json.MarshalIndent(permissionsFile{Permissions: permissions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0600)
+}
+
+// permissionIdentity names who a permission can be given to: the client
+// token's name, else the executable. App names are chosen by the client
+// itself, so they don't count. "" means the client can't be told apart
+// from others and gets no permissions.
+func permissionIdentity(session *connSession) string {
+	switch {
+	case session.client != "":
+		return "client:" + session.client
+	case session.exe != "":
+		return "exe:" + session.exe
+	}
+	return ""
+}
+
+// permissionKinds returns the distinct kinds of a signing request, sorted
+func permissionKinds(req SignRequest) []int {
+	seen := make(map[int]bool)
+	var kinds []int
+	for _, kind := range signingKinds(req) {
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Ints(kinds)
+	return kinds
+}
+
+// covers reports whether the permission allows method with kinds for
+// identity on account npub
+func (p Permission) covers(identity, npub, method string, kinds []int) bool {
+	if p.Identity != identity || p.Npub != npub || p.Method != method {
+		return false
+	}
+	allowed := make(map[int]bool, len(p.Kinds))
+	for _, kind := range p.Kinds {
+		allowed[kind] = true
+	}
+	for _, kind := range kinds {
+		if !allowed[kind] {
+			return false
+		}
+	}
+	return true
+}
+
+// describe words what the permission allows
+func (p Permission) describe() string {
+	if len(p.Kinds) == 0 {
+		return p.Method
+	}
+	kinds := make([]string, len(p.Kinds))
+	for i, kind := range p.Kinds {
+		kinds[i] = describeKind(kind)
+	}
+	return p.Method + " " + strings.Join(kinds, ", ")
+}
+
+// activePermissions returns the permissions that still hold for npub. The
+// expired ones and those given while another account was active are
+// removed from permissions.json.
+func activePermissions(npub string) ([]Permission, error) {
+	permissionsMu.Lock()
+	defer permissionsMu.Unlock()
+
+	permissions, err := loadPermissions()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	var kept []Permission
+	for _, permission := range permissions {
+		switch {
+		case permission.ExpiresAt <= now:
+			logInfo("⌛ Permission %s (%s for %s) expired", permission.ID, permission.describe(), permission.Label)
+		case permission.Npub != npub:
+			logInfo("🔄 Permission %s (%s for %s) invalidated - the active account changed", permission.ID, permission.describe(), permission.Label)
+		default:
+			kept = append(kept, permission)
+		}
+	}
+	if len(kept) != len(permissions) {
+		if err := savePermissions(kept); err != nil {
+			return nil, err
+		}
+	}
+	return kept, nil
+}
+
+// invalidatePermissions removes the permissions given while another
+// account than npub was active. The consent was given for that account.
+func invalidatePermissions(npub string) {
+	if _, err := activePermissions(npub); err != nil {
+		logError("❌ %v", err)
+	}
+}
+
+// permission returns the remembered permission that covers req, if any.
+// Strict mode remembers nothing.
+func (d *Daemon) permission(session *connSession, req SignRequest) (Permission, bool) {
+	identity := permissionIdentity(session)
+	if identity == "" || d.config.strictEnabled() {
+		return Permission{}, false
+	}
+	npub := d.requestNpub(req)
+
+	permissions, err := activePermissions(npub)
+	if err != nil {
+		logError("❌ %v", err)
+		return Permission{}, false
+	}
+	kinds := permissionKinds(req)
+	for _, permission := range permissions {
+		if permission.covers(identity, npub, req.Method, kinds) {
+			return permission, true
+		}
+	}
+	return Permission{}, false
+}
+
+// needsConfirmation reports whether anything would ask about req: the
+// signing policy's confirm, approval_command or the --ask prompt
+func (d *Daemon) needsConfirmation(req SignRequest) bool {
+	if kinds := signingKinds(req); len(kinds) > 0 {
+		if action, _ := d.policy.strictest(kinds); action == policyConfirm {
+			return true
+		}
+	}
+	return (d.config.approvalCommand() != "" && approvalMethods[req.Method]) ||
+		(d.asker != nil && askMethods[req.Method])
+}
+
+// permitted reports whether a remembered permission lets req skip its
+// confirmation, and logs it. permissions.json is only read for requests
+// that would otherwise be confirmed.
+func (d *Daemon) permitted(session *connSession, req SignRequest) bool {
+	if !d.needsConfirmation(req) {
+		return false
+	}
+	permission, ok := d.permission(session, req)
+	if ok {
+		logInfo("🎫 %s from %s allowed by permission %s", req.Method, session.identity(req), permission.ID)
+	}
+	return ok
+}
+
+// rememberPermission stores a permission for the client of an approved
+// request. A permission for the same client and method is widened to the
+// request's kinds and renewed.
+func (d *Daemon) rememberPermission(session *connSession, req SignRequest, npub string) (Permission, error) {
+	identity := permissionIdentity(session)
+	if identity == "" {
+		return Permission{}, fmt.Errorf("the client can't be identified (no client token or executable)")
+	}
+	if d.config.strictEnabled() {
+		return Permission{}, fmt.Errorf("strict mode remembers no permissions")
+	}
+
+	permissionsMu.Lock()
+	defer permissionsMu.Unlock()
+
+	permissions, err := loadPermissions()
+	if err != nil {
+		return Permission{}, err
+	}
+
+	client := session.identity(req)
+	client.PeerPID = 0
+	now := time.Now()
+	permission := Permission{
+		ID:        newTraceID(),
+		Identity:  identity,
+		Label:     client.String(),
+		Npub:      npub,
+		Method:    req.Method,
+		Kinds:     permissionKinds(req),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(d.config.permissionDuration()).Unix(),
+	}
+	if session.client != "" {
+		permission.Label = session.client
+	}
+
+	var kept []Permission
+	for _, existing := range permissions {
+		if existing.Identity == identity && existing.Npub == npub && existing.Method == req.Method {
+			permission.ID = existing.ID
+			permission.Kinds = mergeKinds(existing.Kinds, permission.Kinds)
+			continue
+		}
+		kept = append(kept, existing)
+	}
+	if err := savePermissions(append(kept, permission)); err != nil {
+		return Permission{}, err
+	}
+
+	logInfo("🎫 Permission %s: %s for %s until %s", permission.ID, permission.describe(), permission.Label,
+		time.Unix(permission.ExpiresAt, 0).Format("2006-01-02 15:04"))
+	d.auditPermission(permission, session.client, client)
+	return permission, nil
+}
+
+// rememberApproved stores a permission after an approval that asked for
+// one. A permission that can't be stored does not undo the approval.
+func (d *Daemon) rememberApproved(session *connSession, req SignRequest, npub string) {
+	if _, err := d.rememberPermission(session, req, npub); err != nil {
+		logError("⚠️  Approved, but not remembered: %v", err)
+	}
+}
+
+// mergeKinds returns the sorted union of two kind lists
+func mergeKinds(a, b []int) []int {
+	seen := make(map[int]bool)
+	var kinds []int
+	for _, kind := range append(append([]int{}, a...), b...) {
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Ints(kinds)
+	return kinds
+}
+
+// auditPermission writes a permission_granted entry to audit.log
+func (d *Daemon) auditPermission(permission Permission, clientName string, client ClientIdentity) {
+	if !d.config.auditEnabled() {
+		return
+	}
+	err := appendAuditEntry(AuditEntry{
+		Timestamp:      time.Now().Unix(),
+		Source:         "daemon",
+		PID:            os.Getpid(),
+		Action:         "permission_granted",
+		Npub:           permission.Npub,
+		Client:         clientName,
+		Success:        true,
+		Items:          len(permission.Kinds),
+		ClientIdentity: client,
+	})
+	if err != nil {
+		logError("❌ Cannot write audit log: %v", err)
+	}
+}
+
+// permissionsCmd lists or revokes remembered permissions. permissions.json
+// is read for every request, so a revoked permission ends at once.
+func permissionsCmd(args []string) error {
+	usage := "Usage: noorsigner permissions list|revoke <id|all>"
+	if len(args) == 0 {
+		return commandFailed(1, "%s", usage)
+	}
+
+	permissions, err := loadPermissions()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		if len(permissions) == 0 {
+			fmt.Println("No remembered permissions. Use 'noorsigner approve <id> --remember' or answer 'a' at the --ask prompt to add one.")
+			return nil
+		}
+		active, _ := loadActiveAccount()
+		now := time.Now().Unix()
+		fmt.Printf("Remembered permissions (%d):\n", len(permissions))
+		for _, permission := range permissions {
+			state := "until " + time.Unix(permission.ExpiresAt, 0).Format("2006-01-02 15:04")
+			switch {
+			case permission.ExpiresAt <= now:
+				state = "expired"
+			case permission.Npub != active:
+				state = "invalid - given for another account"
+			}
+			fmt.Printf("  %s  %s\n", permission.ID, permission.Label)
+			fmt.Printf("    allows:   %s\n", permission.describe())
+			fmt.Printf("    account:  %s\n", displayNpub(permission.Npub))
+			fmt.Printf("    identity: %s\n", permission.Identity)
+			fmt.Printf("    %s\n", state)
+		}
+
+	case args[0] == "revoke" && len(args) == 2:
+		var kept []Permission
+		revoked := 0
+		for _, permission := range permissions {
+			if args[1] == "all" || permission.ID == args[1] {
+				revoked++
+				continue
+			}
+			kept = append(kept, permission)
+		}
+		if revoked == 0 {
+			return commandFailed(1, "❌ No permission with id %q", args[1])
+		}
+		if err := savePermissions(kept); err != nil {
+			auditCLI("permission_revoke", "", err, nil)
+			return commandFailed(1, "❌ Error saving permissions.json: %v", err)
+		}
+		auditCLI("permission_revoke", "", nil, nil)
+		fmt.Printf("✅ %d permission(s) revoked\n", revoked)
+
+	default:
+		return commandFailed(1, "%s", usage)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// daemonLock is this process's hold on daemon.lock while it serves as the
+// daemon. The lock, not the pid in daemon.pid, says a daemon is running:
+// the kernel drops it when the daemon dies, while a leftover pid may
+// belong to an unrelated process after a reboot.
+var daemonLock struct {
+	sync.Mutex
+	file   *os.File
+	unlock func()
+}
+
+// getPidFilePath returns path to the daemon pidfile
+func getPidFilePath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(storageDir, "daemon.pid"), nil
+}
+
+// getDaemonLockPath returns path to the lock the running daemon holds
+func getDaemonLockPath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(storageDir, "daemon.lock"), nil
+}
+
+// openDaemonLock opens (creating it) daemon.lock. The file is never
+// removed, so every process locks the same inode.
+func openDaemonLock() (*os.File, error) {
+	lockPath, err := getDaemonLockPath()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open daemon lock: %v", err)
+	}
+	return file, nil
+}
+
+// readPidFile returns the pid recorded in the pidfile (0 if none)
+func readPidFile() (int, error) {
+	pidFile, err := getPidFilePath()
+	if err != nil {
+		return 0, err
+	}
+
+	content, err := os.ReadFile(pidFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot read pidfile: %v", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		// Garbage content is treated like a stale pidfile
+		return 0, nil
+	}
+
+	return pid, nil
+}
+
+// runningDaemon reports whether a daemon holds daemon.lock, and its pid
+// from the pidfile (0 if that can't be read)
+func runningDaemon() (bool, int, error) {
+	file, err := openDaemonLock()
+	if err != nil {
+		return false, 0, err
+	}
+	defer file.Close()
+
+	unlock, ok, err := tryLockFile(file)
+	if err != nil {
+		return false, 0, fmt.Errorf("cannot check daemon lock: %v", err)
+	}
+	if ok {
+		unlock()
+		return false, 0, nil
+	}
+
+	pid, _ := readPidFile()
+	return true, pid, nil
+}
+
+// checkDaemonNotRunning returns an error if another daemon instance holds
+// the daemon lock. A pidfile left behind by a crashed daemon doesn't count.
+func checkDaemonNotRunning() error {
+	running, pid, err := runningDaemon()
+	if err != nil {
+		return err
+	}
+	if !running {
+		return nil
+	}
+	if pid == 0 {
+		return errDaemonRunning
+	}
+	return fmt.Errorf("%w (pid %d)", errDaemonRunning, pid)
+}
+
+// writePidFile takes the daemon lock for the rest of the process's life and
+// records the current process as the running daemon. Holding the lock, any
+// pidfile still there is stale and is replaced; another starter fails on
+// the lock before it touches the pidfile.
+func writePidFile() error {
+	daemonLock.Lock()
+	defer daemonLock.Unlock()
+	if daemonLock.file != nil {
+		return fmt.Errorf("cannot create pidfile: this process already holds the daemon lock")
+	}
+
+	pidFile, err := getPidFilePath()
+	if err != nil {
+		return err
+	}
+	file, err := openDaemonLock()
+	if err != nil {
+		return err
+	}
+	unlock, ok, err := tryLockFile(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot lock daemon lock: %v", err)
+	}
+	if !ok {
+		file.Close()
+		if pid, _ := readPidFile(); pid != 0 {
+			return fmt.Errorf("%w (pid %d)", errDaemonRunning, pid)
+		}
+		return errDaemonRunning
+	}
+
+	release := func() {
+		unlock()
+		file.Close()
+	}
+	if err := os.Remove(pidFile); err != nil && !os.IsNotExist(err) {
+		release()
+		return fmt.Errorf("cannot remove stale pidfile: %v", err)
+	}
+	pid, err := os.OpenFile(pidFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		release()
+		return fmt.Errorf("cannot create pidfile: %v", err)
+	}
+	_, writeErr := pid.WriteString(strconv.Itoa(os.Getpid()))
+	closeErr := pid.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		os.Remove(pidFile)
+		release()
+		return fmt.Errorf("cannot write pidfile: %v", writeErr)
+	}
+
+	daemonLock.file, daemonLock.unlock = file, unlock
+	return nil
+}
+
+// removePidFile removes the pidfile and releases the daemon lock, if this
+// process holds it
+func removePidFile() {
+	daemonLock.Lock()
+	defer daemonLock.Unlock()
+	if daemonLock.file == nil {
+		return
+	}
+
+	// Removed while still locked, so the next daemon's pidfile is safe
+	if pidFile, err := getPidFilePath(); err == nil {
+		os.Remove(pidFile)
+	}
+	daemonLock.unlock()
+	daemonLock.file.Close()
+	daemonLock.file, daemonLock.unlock = nil, nil
+}
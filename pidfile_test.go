@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A pidfile naming a live process that doesn't hold the daemon lock (a pid
+// reused after a reboot) doesn't stop the daemon from starting
+func TestStalePidFile(t *testing.T) {
+	testHome(t)
+	pidFile, err := getPidFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{strconv.Itoa(os.Getppid()), "1", "garbage"} {
+		if err := os.WriteFile(pidFile, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkDaemonNotRunning(); err != nil {
+			t.Errorf("pidfile %q: %v, want no daemon running", content, err)
+		}
+	}
+
+	if err := writePidFile(); err != nil {
+		t.Fatalf("writePidFile over a stale pidfile: %v", err)
+	}
+	if pid, _ := readPidFile(); pid != os.Getpid() {
+		t.Errorf("pidfile names %d, want %d", pid, os.Getpid())
+	}
+	if running, pid, err := runningDaemon(); err != nil || !running || pid != os.Getpid() {
+		t.Errorf("runningDaemon = %v, %d, %v; want this process", running, pid, err)
+	}
+	if err := checkDaemonNotRunning(); !errors.Is(err, errDaemonRunning) {
+		t.Errorf("checkDaemonNotRunning while the lock is held = %v, want %v", err, errDaemonRunning)
+	}
+
+	removePidFile()
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Errorf("pidfile left after removePidFile: %v", err)
+	}
+	if running, _, err := runningDaemon(); err != nil || running {
+		t.Errorf("runningDaemon after removePidFile = %v, %v; want none", running, err)
+	}
+}
+
+// instanceHelperEnv makes TestDaemonInstanceHelper start up like a daemon
+const instanceHelperEnv = "NOORSIGNER_TEST_INSTANCE"
+
+// TestDaemonInstanceHelper is not a test: run as a child process by
+// startTestInstance, it claims the pidfile and the socket as the daemon
+// does on startup, prints "ready" or why it can't, and holds both until its
+// stdin closes
+func TestDaemonInstanceHelper(t *testing.T) {
+	if os.Getenv(instanceHelperEnv) == "" {
+		t.Skip("started by TestDaemonSingleInstance")
+	}
+	fail := func(err error) {
+		if errors.Is(err, errDaemonRunning) {
+			fmt.Println("running:", err)
+		} else {
+			fmt.Println("error:", err)
+		}
+		os.Exit(1)
+	}
+	if err := writePidFile(); err != nil {
+		fail(err)
+	}
+	listener, err := createListener()
+	if err != nil {
+		removePidFile()
+		fail(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	fmt.Println("ready")
+
+	io.Copy(io.Discard, os.Stdin)
+	listener.Close()
+	cleanupListener()
+	removePidFile()
+	os.Exit(0)
+}
+
+// testInstance is a child process started by startTestInstance
+type testInstance struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	// result is the first line the instance printed
+	result chan string
+}
+
+// startTestInstance starts a daemon instance on the test's storage directory
+func startTestInstance(t *testing.T) *testInstance {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDaemonInstanceHelper$")
+	cmd.Env = append(os.Environ(), instanceHelperEnv+"=1")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	instance := &testInstance{cmd: cmd, stdin: stdin, result: make(chan string, 1)}
+	go func() {
+		line, _ := bufio.NewReader(stdout).ReadString('\n')
+		instance.result <- strings.TrimSpace(line)
+		io.Copy(io.Discard, stdout)
+	}()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return instance
+}
+
+// started waits for the instance's startup and reports whether it became
+// the daemon
+func (i *testInstance) started(t *testing.T) bool {
+	t.Helper()
+	result := <-i.result
+	switch {
+	case result == "ready":
+		return true
+	case strings.HasPrefix(result, "running:"):
+		return false
+	}
+	t.Fatalf("instance %d: %q", i.cmd.Process.Pid, result)
+	return false
+}
+
+// stop shuts the instance down cleanly
+func (i *testInstance) stop(t *testing.T) {
+	t.Helper()
+	i.stdin.Close()
+	if err := i.cmd.Wait(); err != nil {
+		t.Fatalf("instance %d: %v", i.cmd.Process.Pid, err)
+	}
+}
+
+// checkDaemon verifies that instance is the running daemon: it holds the
+// lock, the pidfile names it, and it answers on the socket
+func checkDaemon(t *testing.T, instance *testInstance) {
+	t.Helper()
+	running, pid, err := runningDaemon()
+	if err != nil || !running || pid != instance.cmd.Process.Pid {
+		t.Errorf("runningDaemon = %v, %d, %v; want %d", running, pid, err, instance.cmd.Process.Pid)
+	}
+	conn, err := dialConnection()
+	if err != nil {
+		t.Errorf("the daemon's socket doesn't answer: %v", err)
+		return
+	}
+	conn.Close()
+}
+
+// Only one daemon runs per storage directory, whether the second starts
+// while the first runs, at the same moment, or after the first crashed
+func TestDaemonSingleInstance(t *testing.T) {
+	testHome(t)
+
+	t.Run("one after another", func(t *testing.T) {
+		first := startTestInstance(t)
+		if !first.started(t) {
+			t.Fatal("the first instance didn't start")
+		}
+		if startTestInstance(t).started(t) {
+			t.Fatal("a second instance started while the first runs")
+		}
+		checkDaemon(t, first)
+
+		first.stop(t)
+		if running, _, err := runningDaemon(); err != nil || running {
+			t.Fatalf("runningDaemon after shutdown = %v, %v", running, err)
+		}
+		next := startTestInstance(t)
+		if !next.started(t) {
+			t.Fatal("no instance could start after the first shut down")
+		}
+		checkDaemon(t, next)
+		next.stop(t)
+	})
+
+	t.Run("at the same time", func(t *testing.T) {
+		instances := make([]*testInstance, 4)
+		for i := range instances {
+			instances[i] = startTestInstance(t)
+		}
+		var winners []*testInstance
+		for _, instance := range instances {
+			if instance.started(t) {
+				winners = append(winners, instance)
+			}
+		}
+		if len(winners) != 1 {
+			t.Fatalf("%d of %d instances started, want 1", len(winners), len(instances))
+		}
+		checkDaemon(t, winners[0])
+		winners[0].stop(t)
+	})
+
+	t.Run("after a crash", func(t *testing.T) {
+		crashed := startTestInstance(t)
+		if !crashed.started(t) {
+			t.Fatal("the first instance didn't start")
+		}
+		crashed.cmd.Process.Kill()
+		crashed.cmd.Wait()
+
+		// Its pidfile is left behind, and on Unix its socket
+		pidFile, _ := getPidFilePath()
+		if _, err := os.Stat(pidFile); err != nil {
+			t.Fatalf("the crashed instance left no pidfile: %v", err)
+		}
+		next := startTestInstance(t)
+		if !next.started(t) {
+			t.Fatal("a crashed daemon's leftovers stopped the next one")
+		}
+		checkDaemon(t, next)
+		next.stop(t)
+	})
+}
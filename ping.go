@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/77elements/noorsigner/pkg/client"
+)
+
+// pingTimeout bounds how long a client waits for a ping answer. A daemon
+// that accepts the connection but doesn't answer in time counts as down.
+const pingTimeout = 2 * time.Second
+
+// errDaemonUnreachable is returned by pingDaemon when no daemon answered:
+// nothing listens on the socket, or the daemon is hung
+var errDaemonUnreachable = errors.New("daemon not reachable")
+
+// PingResponse represents ping response
+type PingResponse struct {
+	ID string `json:"id"`
+	// Version is the newest protocol version the daemon speaks
+	Version  int  `json:"version"`
+	Unlocked bool `json:"unlocked"`
+	// Seq counts the pings the daemon answered since it started, this one
+	// included. A watchdog that sees it go down knows the daemon restarted.
+	Seq   uint64 `json:"seq"`
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// ping answers a ping without touching the key or any file
+func (d *Daemon) ping(id string) PingResponse {
+	d.mu.RLock()
+	unlocked := d.privateKey != nil
+	d.mu.RUnlock()
+
+	return PingResponse{
+		ID:       id,
+		Version:  protocolVersion,
+		Unlocked: unlocked,
+		Seq:      d.pings.Add(1),
+	}
+}
+
+// pingDaemon pings the daemon, waiting at most pingTimeout. No answer
+// fails with errDaemonUnreachable; any other error means the daemon
+// answered but refused (e.g. ERR_UNAUTHORIZED, or an older daemon
+// without ping).
+func pingDaemon() (*PingResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	var response PingResponse
+	err := daemonRequest(ctx, SignRequest{Method: "ping"}, &response)
+	var daemonErr *client.Error
+	switch {
+	case err == nil:
+		return &response, nil
+	case errors.Is(err, errDaemonUnauthorized), errors.As(err, &daemonErr):
+		return nil, err
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, fmt.Errorf("%w: no answer within %s", errDaemonUnreachable, pingTimeout)
+	}
+	return nil, fmt.Errorf("%w: %v", errDaemonUnreachable, err)
+}
+
+// pingCmd checks that the daemon answers, for scripts: exit 0 healthy and
+// unlocked, 1 unreachable (or refusing), 2 running but locked
+func pingCmd(args []string) error {
+	if len(args) > 0 {
+		return commandFailed(1, "Usage: noorsigner ping")
+	}
+
+	start := time.Now()
+	response, err := pingDaemon()
+	latency := time.Since(start)
+	if err != nil {
+		if jsonOutput {
+			printJSON(PingOutput{Reachable: !errors.Is(err, errDaemonUnreachable), Error: err.Error()})
+			return &commandError{Code: 1}
+		}
+		if errors.Is(err, errDaemonUnreachable) {
+			fmt.Printf("❌ %v\n", err)
+		} else {
+			fmt.Printf("🔒 Daemon running, but it refused the ping: %v\n", err)
+		}
+		return &commandError{Code: 1}
+	}
+
+	if jsonOutput {
+		printJSON(PingOutput{
+			Reachable: true,
+			Unlocked:  response.Unlocked,
+			Version:   response.Version,
+			Seq:       response.Seq,
+			LatencyMs: latency.Milliseconds(),
+		})
+	} else if response.Unlocked {
+		fmt.Printf("✅ Daemon healthy and unlocked (%s)\n", latency.Round(time.Millisecond))
+	} else {
+		fmt.Printf("🔒 Daemon healthy but locked (%s)\n", latency.Round(time.Millisecond))
+	}
+	if !response.Unlocked {
+		return &commandError{Code: 2}
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+// Package client talks to a running NoorSigner daemon over its socket
+// protocol: one JSON request per line, answered by one JSON response. The
+// daemon uses the same Request and Response types, so both sides share one
+// definition of the protocol.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Machine-readable error codes clients commonly act on. The README lists
+// them all.
+const (
+	CodeDraining         = "ERR_DRAINING"
+	CodeUnauthorized     = "ERR_UNAUTHORIZED"
+	CodePending          = "ERR_PENDING"
+	CodePasswordRequired = "ERR_PASSWORD_REQUIRED"
+	CodeRateLimited      = "ERR_RATE_LIMITED"
+	CodeLocked           = "ERR_LOCKED"
+)
+
+// ErrNotRunning is returned when no daemon accepted the connection
+var ErrNotRunning = errors.New("daemon not running")
+
+// Error is a request the daemon answered with an error
+type Error struct {
+	// Code is the machine-readable error code, e.g. ERR_LOCKED (may be
+	// empty for older daemons)
+	Code    string
+	Message string
+	// RetryAfter is set with ERR_DRAINING and ERR_RATE_LIMITED
+	RetryAfter time.Duration
+	// ApprovalID is set with ERR_PENDING, for await_result
+	ApprovalID string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Client sends requests to a daemon, one connection per request. Its zero
+// value is not usable; create it with New or NewUnix.
+type Client struct {
+	dial func(ctx context.Context) (net.Conn, error)
+
+	// Token is sent with every request; the daemon requires it with
+	// require_auth (see 'noorsigner authorize')
+	Token string
+	// AppName and AppVersion name the client in the daemon's activity
+	// feed and audit log
+	AppName    string
+	AppVersion string
+}
+
+// New returns a client that connects with dial, e.g. to a Windows named pipe
+func New(dial func(ctx context.Context) (net.Conn, error)) *Client {
+	return &Client{dial: dial}
+}
+
+// NewUnix returns a client for the daemon's Unix socket at path
+func NewUnix(path string) *Client {
+	return New(func(ctx context.Context) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", path)
+	})
+}
+
+// Do sends req on a new connection and decodes the response into v (if not
+// nil). An error response is decoded as well and returned as *Error. The
+// request gets a fresh id if it has none, and the client's token and app
+// name unless it sets its own. ctx bounds the whole exchange.
+func (c *Client) Do(ctx context.Context, req Request, v any) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotRunning, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Unblocks the read below when ctx is canceled
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if req.ID == "" {
+		req.ID = NewRequestID()
+	}
+	if req.Token == "" {
+		req.Token = c.Token
+	}
+	if req.AppName == "" {
+		req.AppName, req.AppVersion = c.AppName, c.AppVersion
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return exchangeError(ctx, "failed to send request", err)
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(conn).Decode(&raw); err != nil {
+		return exchangeError(ctx, "failed to read response", err)
+	}
+
+	if v != nil {
+		if err := json.Unmarshal(raw, v); err != nil {
+			return fmt.Errorf("failed to read response: %v", err)
+		}
+	}
+	var status struct {
+		Error      string `json:"error"`
+		Code       string `json:"code"`
+		RetryAfter int    `json:"retry_after"`
+		ApprovalID string `json:"approval_id"`
+	}
+	if json.Unmarshal(raw, &status) == nil && status.Error != "" {
+		return &Error{
+			Code:       status.Code,
+			Message:    status.Error,
+			RetryAfter: time.Duration(status.RetryAfter) * time.Second,
+			ApprovalID: status.ApprovalID,
+		}
+	}
+	return nil
+}
+
+// exchangeError reports a failed write or read, blaming ctx if it ended.
+// The connection's deadline may pass just before ctx's.
+func exchangeError(ctx context.Context, what string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", what, ctx.Err())
+	}
+	if _, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%s: %w", what, context.DeadlineExceeded)
+	}
+	return fmt.Errorf("%s: %v", what, err)
+}
+
+// SignEvent signs an unsigned event (JSON without id, pubkey and sig) with
+// the active account and returns the complete signed event
+func (c *Client) SignEvent(ctx context.Context, eventJSON string) (*Event, error) {
+	var response Response
+	if err := c.Do(ctx, Request{Method: "sign_event", EventJSON: eventJSON}, &response); err != nil {
+		return nil, err
+	}
+	if response.Event == nil {
+		return nil, fmt.Errorf("daemon returned no signed event")
+	}
+	return response.Event, nil
+}
+
+// GetPublicKey returns the active account's hex public key
+func (c *Client) GetPublicKey(ctx context.Context) (string, error) {
+	return c.result(ctx, Request{Method: "get_public_key"})
+}
+
+// Nip44Encrypt encrypts plaintext for recipientPubkey (hex) with NIP-44
+func (c *Client) Nip44Encrypt(ctx context.Context, plaintext, recipientPubkey string) (string, error) {
+	return c.result(ctx, Request{Method: "nip44_encrypt", Plaintext: plaintext, RecipientPubkey: recipientPubkey})
+}
+
+// Nip44Decrypt decrypts a NIP-44 payload from senderPubkey (hex)
+func (c *Client) Nip44Decrypt(ctx context.Context, payload, senderPubkey string) (string, error) {
+	return c.result(ctx, Request{Method: "nip44_decrypt", Payload: payload, SenderPubkey: senderPubkey})
+}
+
+// Nip04Encrypt encrypts plaintext for recipientPubkey (hex) with NIP-04
+func (c *Client) Nip04Encrypt(ctx context.Context, plaintext, recipientPubkey string) (string, error) {
+	return c.result(ctx, Request{Method: "nip04_encrypt", Plaintext: plaintext, RecipientPubkey: recipientPubkey})
+}
+
+// Nip04Decrypt decrypts a NIP-04 payload from senderPubkey (hex)
+func (c *Client) Nip04Decrypt(ctx context.Context, payload, senderPubkey string) (string, error) {
+	return c.result(ctx, Request{Method: "nip04_decrypt", Payload: payload, SenderPubkey: senderPubkey})
+}
+
+// ListAccounts lists the daemon's accounts and which one is active
+func (c *Client) ListAccounts(ctx context.Context) (*ListAccountsResponse, error) {
+	var response ListAccountsResponse
+	if err := c.Do(ctx, Request{Method: "list_accounts"}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// SwitchAccount makes npub the active account. An empty password lets the
+// daemon use the account's trust session; without one the error is an
+// *Error with CodePasswordRequired.
+func (c *Client) SwitchAccount(ctx context.Context, npub, password string) (*AccountActionResponse, error) {
+	var response AccountActionResponse
+	if err := c.Do(ctx, Request{Method: "switch_account", Npub: npub, Password: password}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// SetLabel names the account npub (an npub or its current label); an empty
+// label removes its label
+func (c *Client) SetLabel(ctx context.Context, npub, label string) error {
+	return c.Do(ctx, Request{Method: "set_label", Npub: npub, Label: label}, nil)
+}
+
+// GetRelays returns the relay list of the account npub ("" for the active
+// account)
+func (c *Client) GetRelays(ctx context.Context, npub string) (map[string]RelayPolicy, error) {
+	var response RelaysResponse
+	if err := c.Do(ctx, Request{Method: "get_relays", Npub: npub}, &response); err != nil {
+		return nil, err
+	}
+	return response.RelayList, nil
+}
+
+// SetRelays replaces the relay list of the account npub ("" for the active
+// account)
+func (c *Client) SetRelays(ctx context.Context, npub string, relays map[string]RelayPolicy) error {
+	return c.Do(ctx, Request{Method: "set_relays", Npub: npub, RelayList: relays}, nil)
+}
+
+// GiftWrap seals an unsigned event for recipientPubkey and gift wraps it
+// (NIP-59). It returns the wrap, ready to publish, and the wrapped rumor.
+func (c *Client) GiftWrap(ctx context.Context, rumorJSON, recipientPubkey string) (*Event, *Rumor, error) {
+	var response GiftWrapResponse
+	if err := c.Do(ctx, Request{Method: "gift_wrap", EventJSON: rumorJSON, RecipientPubkey: recipientPubkey}, &response); err != nil {
+		return nil, nil, err
+	}
+	return response.Event, response.Rumor, nil
+}
+
+// Unwrap opens a gift wrap addressed to the active account and returns the
+// rumor inside and its sender's pubkey
+func (c *Client) Unwrap(ctx context.Context, wrapJSON string) (*Rumor, string, error) {
+	var response GiftWrapResponse
+	if err := c.Do(ctx, Request{Method: "unwrap", EventJSON: wrapJSON}, &response); err != nil {
+		return nil, "", err
+	}
+	return response.Rumor, response.Sender, nil
+}
+
+// SendDM encrypts text as a NIP-17 direct message to recipientPubkey (hex
+// or npub) and returns the gift wraps to publish: the recipient's, then
+// the account's own copy
+func (c *Client) SendDM(ctx context.Context, recipientPubkey, text string) ([]*Event, error) {
+	var response DMResponse
+	if err := c.Do(ctx, Request{Method: "send_dm", RecipientPubkey: recipientPubkey, Plaintext: text}, &response); err != nil {
+		return nil, err
+	}
+	return response.Wraps, nil
+}
+
+// DecryptDM opens a NIP-17 direct message's gift wrap
+func (c *Client) DecryptDM(ctx context.Context, wrapJSON string) (*DMResponse, error) {
+	var response DMResponse
+	if err := c.Do(ctx, Request{Method: "decrypt_dm", EventJSON: wrapJSON}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// result sends a request whose answer is a single string
+func (c *Client) result(ctx context.Context, req Request) (string, error) {
+	var response Response
+	if err := c.Do(ctx, req, &response); err != nil {
+		return "", err
+	}
+	return response.result(), nil
+}
+
+// NewRequestID returns a random UUID (version 4) to use as a request id
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("req-%d", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
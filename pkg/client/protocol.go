@@ -0,0 +1,252 @@
+package client
+
+import "encoding/json"
+
+// Request is one request to the daemon: a JSON object on its own line. The
+// daemon and its clients share this definition.
+type Request struct {
+	ID     string `json:"id"`
+	Method string `json:"method"`
+	// Protocol version; omitted means v1
+	Version int `json:"version,omitempty"`
+	// Client token, required with require_auth
+	Token string `json:"token,omitempty"`
+	// Self-declared client identity
+	AppName         string `json:"app_name,omitempty"`
+	AppVersion      string `json:"app_version,omitempty"`
+	EventJSON       string `json:"event_json,omitempty"`
+	Plaintext       string `json:"plaintext,omitempty"`
+	RecipientPubkey string `json:"recipient_pubkey,omitempty"`
+	Payload         string `json:"payload,omitempty"`
+	SenderPubkey    string `json:"sender_pubkey,omitempty"`
+	// Multi-account fields
+	Pubkey    string `json:"pubkey,omitempty"`
+	Npub      string `json:"npub,omitempty"`
+	Nsec      string `json:"nsec,omitempty"`
+	Password  string `json:"password,omitempty"`
+	SetActive bool   `json:"set_active,omitempty"`
+	Force     bool   `json:"force,omitempty"`
+	// add_account and set_label: the account's label ("" removes it)
+	Label string `json:"label,omitempty"`
+	// unlock_account: also create a trust session
+	Trust bool `json:"trust,omitempty"`
+	// Strict confirmation fields
+	ConfirmationToken string `json:"confirmation_token,omitempty"`
+	ConfirmNpub       string `json:"confirm_npub,omitempty"`
+	// Credential request nonce
+	Nonce string `json:"nonce,omitempty"`
+	// nip44_decrypt_batch items
+	Items []DecryptBatchItem `json:"items,omitempty"`
+	// sign_events event JSONs
+	Events []string `json:"events,omitempty"`
+	// zap_request parameters
+	Zap *ZapRequest `json:"zap,omitempty"`
+	// append_audit entry from the CLI, an audit.log line
+	Audit json.RawMessage `json:"audit,omitempty"`
+	// drain timeout, e.g. "30s"
+	Timeout string `json:"timeout,omitempty"`
+	// get_recent_activity paging
+	AfterSeq  uint64 `json:"after_seq,omitempty"`
+	BeforeSeq uint64 `json:"before_seq,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+	// audit: only entries from this Unix time on
+	Since int64 `json:"since,omitempty"`
+	// set_policy change and respond_approval answer
+	Policy  *PolicyUpdate `json:"policy,omitempty"`
+	Approve bool          `json:"approve,omitempty"`
+	// Remember an approval as a permission for the client
+	Remember bool `json:"remember,omitempty"`
+	// nip46_connect URI
+	ConnectURI string `json:"connect_uri,omitempty"`
+	// nip46_bunker relays and grant permissions; app_name names the grant
+	Relays []string `json:"relays,omitempty"`
+	Perms  []string `json:"perms,omitempty"`
+	// set_relays: the account's whole relay list
+	RelayList map[string]RelayPolicy `json:"relay_list,omitempty"`
+	// verify_delegation: a NIP-26 delegation tag and the pubkey it delegates to
+	Delegation []string `json:"delegation,omitempty"`
+	Delegatee  string   `json:"delegatee,omitempty"`
+	// reset_rate_limits: only this client's buckets
+	Client string `json:"client,omitempty"`
+	// lock: "freeze" when noorsigner freeze locks
+	Reason string `json:"reason,omitempty"`
+	// respond_credential: password is a security key's secret
+	Fido bool `json:"fido,omitempty"`
+}
+
+// Response is the daemon's answer to most methods
+type Response struct {
+	ID string `json:"id"`
+	// Signature carries single string results for v1 clients, Result from
+	// protocol v2 on
+	Signature string `json:"signature,omitempty"`
+	Result    string `json:"result,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Code is the machine-readable error code
+	Code string `json:"code,omitempty"`
+	// sign_event also returns the id and the complete signed event, so
+	// clients don't have to serialize the event themselves
+	EventID string `json:"event_id,omitempty"`
+	Event   *Event `json:"event,omitempty"`
+	// ApprovalID is the id a request is parked under (ERR_PENDING), for
+	// await_result
+	ApprovalID string `json:"approval_id,omitempty"`
+	// RetryAfter is how many seconds to wait after ERR_RATE_LIMITED
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// result returns the string result, whichever protocol version sent it
+func (r *Response) result() string {
+	if r.Result != "" {
+		return r.Result
+	}
+	return r.Signature
+}
+
+// Event is a signed Nostr event (NIP-01)
+type Event struct {
+	ID        string     `json:"id"`
+	Pubkey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// Rumor is an unsigned event inside a NIP-59 gift wrap. It has an id but no
+// signature, so it proves nothing if it leaks.
+type Rumor struct {
+	ID        string     `json:"id"`
+	Pubkey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+}
+
+// GiftWrapResponse is the gift_wrap and unwrap response
+type GiftWrapResponse struct {
+	ID string `json:"id"`
+	// EventID and Event are the gift wrap (kind 1059) gift_wrap created
+	EventID string `json:"event_id,omitempty"`
+	Event   *Event `json:"event,omitempty"`
+	// Rumor is the event that was wrapped, or that unwrap found inside
+	Rumor *Rumor `json:"rumor,omitempty"`
+	// Sender is the rumor's author, proven by the seal's signature (unwrap)
+	Sender string `json:"sender,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+// DMResponse is the send_dm and decrypt_dm response
+type DMResponse struct {
+	ID string `json:"id"`
+	// Wraps are the gift wraps send_dm created: the recipient's first, then
+	// the account's own copy (none if the recipient is the account)
+	Wraps []*Event `json:"wraps,omitempty"`
+	// EventID is the id of the recipient's wrap
+	EventID string `json:"event_id,omitempty"`
+	// MessageID is the kind 14 rumor's id, which replies refer to
+	MessageID string `json:"message_id,omitempty"`
+	// decrypt_dm: who sent the message, when, and what it says
+	Sender    string `json:"sender,omitempty"`
+	CreatedAt int64  `json:"created_at,omitempty"`
+	Plaintext string `json:"plaintext,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Code      string `json:"code,omitempty"`
+}
+
+// DecryptBatchItem is one payload of a nip44_decrypt_batch request
+type DecryptBatchItem struct {
+	Payload      string `json:"payload"`
+	SenderPubkey string `json:"sender_pubkey"`
+}
+
+// ZapRequest holds the parameters of a NIP-57 zap request
+type ZapRequest struct {
+	// Recipient is the zapped user's pubkey (hex or npub)
+	Recipient string `json:"recipient"`
+	// Amount is in millisats, as passed to the LNURL callback
+	Amount int64 `json:"amount"`
+	// Relays receive the zap receipt
+	Relays []string `json:"relays"`
+	// LNURL is the recipient's bech32 lnurl (optional)
+	LNURL string `json:"lnurl,omitempty"`
+	// EventID is the zapped event (optional)
+	EventID string `json:"event_id,omitempty"`
+	// Comment becomes the event content (optional)
+	Comment string `json:"comment,omitempty"`
+}
+
+// PolicyUpdate is the policy parameter of set_policy
+type PolicyUpdate struct {
+	// Kind is the kind to change; omitted changes the default
+	Kind *int `json:"kind,omitempty"`
+	// Action is allow, deny or confirm; "" removes the kind's entry
+	// (resets the default to allow)
+	Action string `json:"action"`
+}
+
+// Account is an account in the list_accounts response
+type Account struct {
+	Pubkey    string           `json:"pubkey"`
+	Npub      string           `json:"npub"`
+	CreatedAt int64            `json:"created_at"`
+	Label     string           `json:"label,omitempty"`
+	Counters  *AccountCounters `json:"counters,omitempty"`
+}
+
+// AccountCounters counts what an account's key did over its lifetime, so
+// unnoticed use stands out
+type AccountCounters struct {
+	EventsSigned  uint64 `json:"events_signed"`
+	Nip44Encrypts uint64 `json:"nip44_encrypts"`
+	Nip44Decrypts uint64 `json:"nip44_decrypts"`
+	// LastUsed is when the key last signed, encrypted or decrypted for a
+	// client (0 = never)
+	LastUsed int64 `json:"last_used,omitempty"`
+}
+
+// ListAccountsResponse is the list_accounts response
+type ListAccountsResponse struct {
+	ID           string    `json:"id"`
+	Accounts     []Account `json:"accounts"`
+	ActivePubkey string    `json:"active_pubkey"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// RelayPolicy says what an account uses a relay for, as the markers of a
+// NIP-65 relay list do
+type RelayPolicy struct {
+	Read  bool `json:"read"`
+	Write bool `json:"write"`
+}
+
+// RelaysResponse is the get_relays and set_relays response
+type RelaysResponse struct {
+	ID        string                 `json:"id"`
+	Npub      string                 `json:"npub,omitempty"`
+	RelayList map[string]RelayPolicy `json:"relay_list"`
+	Error     string                 `json:"error,omitempty"`
+	Code      string                 `json:"code,omitempty"`
+}
+
+// AccountActionResponse is the add/switch/remove account response
+type AccountActionResponse struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	Pubkey  string `json:"pubkey,omitempty"`
+	Npub    string `json:"npub,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
+	// switch_account: how the account was unlocked ("password" or
+	// "trust_session") and when its trust session ends (0 = none, so the
+	// next switch needs the password)
+	UnlockedVia    string `json:"unlocked_via,omitempty"`
+	TrustExpiresAt int64  `json:"trust_expires_at,omitempty"`
+	// Set with ERR_CONFIRMATION_REQUIRED
+	ConfirmationToken     string `json:"confirmation_token,omitempty"`
+	ConfirmationPrompt    string `json:"confirmation_prompt,omitempty"`
+	ConfirmationExpiresAt int64  `json:"confirmation_expires_at,omitempty"`
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// plistEntry is one key of a plist's top-level dict. Raw holds the value
+// element exactly as written, so user values survive a merge untouched.
+type plistEntry struct {
+	Key string
+	Raw string
+}
+
+// parsePlistDict returns the entries of the top-level <dict> of an XML plist
+func parsePlistDict(content string) ([]plistEntry, error) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+
+	var entries []plistEntry
+	depth := 0
+	inDict := false
+	pendingKey := ""
+
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid plist: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+
+			// <plist><dict> is depth 2, its children depth 3
+			if depth == 2 && t.Name.Local == "dict" {
+				inDict = true
+				continue
+			}
+			if !inDict || depth != 3 {
+				continue
+			}
+
+			if t.Name.Local == "key" {
+				var key string
+				if err := decoder.DecodeElement(&key, &t); err != nil {
+					return nil, fmt.Errorf("invalid plist key: %v", err)
+				}
+				depth--
+				pendingKey = strings.TrimSpace(key)
+				continue
+			}
+
+			if pendingKey == "" {
+				return nil, fmt.Errorf("invalid plist: <%s> without <key>", t.Name.Local)
+			}
+			if err := decoder.Skip(); err != nil {
+				return nil, fmt.Errorf("invalid plist value for %s: %v", pendingKey, err)
+			}
+			depth--
+			entries = append(entries, plistEntry{
+				Key: pendingKey,
+				Raw: content[offset:decoder.InputOffset()],
+			})
+			pendingKey = ""
+
+		case xml.EndElement:
+			depth--
+			if depth == 1 && inDict {
+				return entries, nil
+			}
+		}
+	}
+
+	if !inDict {
+		return nil, fmt.Errorf("invalid plist: no top-level <dict>")
+	}
+	return entries, nil
+}
+
+// renderPlist writes a plist whose top-level dict holds entries, preceded
+// by an optional XML comment
+func renderPlist(comment string, entries []plistEntry) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	if comment != "" {
+		fmt.Fprintf(&b, "<!-- %s -->\n", comment)
+	}
+	b.WriteString(`<plist version="1.0">` + "\n<dict>\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "    <key>%s</key>\n    %s\n", entry.Key, entry.Raw)
+	}
+	b.WriteString("</dict>\n</plist>\n")
+	return b.String()
+}
+
+// mergePlistEntries overlays managed keys from generated onto existing.
+// Keys the user added or changed are kept in their original order; keys
+// only present in generated are appended.
+func mergePlistEntries(existing, generated []plistEntry, managed map[string]bool) []plistEntry {
+	var merged []plistEntry
+	seen := make(map[string]bool)
+
+	for _, entry := range generated {
+		if managed[entry.Key] {
+			merged = append(merged, entry)
+			seen[entry.Key] = true
+		}
+	}
+	for _, entry := range existing {
+		if !seen[entry.Key] {
+			merged = append(merged, entry)
+			seen[entry.Key] = true
+		}
+	}
+	for _, entry := range generated {
+		if !seen[entry.Key] {
+			merged = append(merged, entry)
+			seen[entry.Key] = true
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,815 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/77elements/noorsigner/pkg/client"
+)
+
+const (
+	policyAllow   = "allow"
+	policyDeny    = "deny"
+	policyConfirm = "confirm"
+
+	// policyDefaultKey is the kind_policy entry for kinds without their own
+	policyDefaultKey = "default"
+
+	// policyConfirmTTL is how long a request waits for an operator to
+	// confirm it before it is denied
+	policyConfirmTTL = 2 * time.Minute
+
+	// policyResultTTL is how long a decision is kept for the client to
+	// collect (await_result) or re-send the request
+	policyResultTTL = 5 * time.Minute
+
+	// defaultAwaitTimeout is how long await_result waits for a decision
+	defaultAwaitTimeout = 30 * time.Second
+
+	// maxPolicyKind bounds the kinds kind_policy accepts (NIP-01 kinds are 0-65535)
+	maxPolicyKind = 65535
+
+	codePolicy  = "ERR_POLICY"
+	codePending = "ERR_PENDING"
+)
+
+// PolicyUpdate is the policy parameter of set_policy
+type PolicyUpdate = client.PolicyUpdate
+
+// PolicyResponse represents get_policy and set_policy responses
+type PolicyResponse struct {
+	ID      string            `json:"id"`
+	Default string            `json:"default,omitempty"`
+	Kinds   map[string]string `json:"kinds,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Code    string            `json:"code,omitempty"`
+}
+
+// PendingApproval is a request waiting for an operator to confirm it
+// (see pending_approvals)
+type PendingApproval struct {
+	ApprovalContext
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// PendingApprovalsResponse represents pending_approvals response
+type PendingApprovalsResponse struct {
+	ID       string            `json:"id"`
+	Requests []PendingApproval `json:"requests"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// pendingApproval is a parked request and who sent it, so that sender
+// can't confirm it itself but can collect the result
+type pendingApproval struct {
+	info    PendingApproval
+	connID  uint64
+	peerPID int
+	client  string
+
+	// The request as sent and a copy of its session, to run it once approved
+	req     SignRequest
+	session *connSession
+	kind    int
+
+	// Set once by decideApproval, which then closes done
+	decided  bool
+	approved bool
+	outcome  string
+	done     chan struct{}
+
+	// redeemed is set when a re-sent request used the approval; run and
+	// result belong to await_result, which runs the request at most once
+	redeemed bool
+	run      sync.Once
+	result   []byte
+}
+
+// signingPolicy is the kind_policy the daemon runs with. set_policy changes
+// it without a restart.
+type signingPolicy struct {
+	mu            sync.RWMutex
+	defaultAction string
+	kinds         map[int]string
+	// strict holds the kinds strict mode keeps at confirm or deny
+	strict map[int]bool
+}
+
+// validatePolicyAction checks a kind_policy action
+func validatePolicyAction(action string) error {
+	switch action {
+	case policyAllow, policyDeny, policyConfirm:
+		return nil
+	}
+	return fmt.Errorf("invalid policy action %q (use allow, deny or confirm)", action)
+}
+
+// parsePolicyKey parses a kind_policy key: a kind, or "default" (nil)
+func parsePolicyKey(key string) (*int, error) {
+	if key == policyDefaultKey {
+		return nil, nil
+	}
+	kind, err := strconv.Atoi(key)
+	if err != nil || kind < 0 || kind > maxPolicyKind {
+		return nil, fmt.Errorf("invalid policy kind %q (use 0-%d or default)", key, maxPolicyKind)
+	}
+	return &kind, nil
+}
+
+// policyKey is the kind_policy key for kind (nil = the default)
+func policyKey(kind *int) string {
+	if kind == nil {
+		return policyDefaultKey
+	}
+	return strconv.Itoa(*kind)
+}
+
+// describePolicyKey names a kind_policy key for people
+func describePolicyKey(kind *int) string {
+	if kind == nil {
+		return "other kinds"
+	}
+	return describeKind(*kind)
+}
+
+// newSigningPolicy builds the policy from kind_policy. loadConfig has
+// already dropped invalid entries.
+func newSigningPolicy(c *Config) *signingPolicy {
+	policy := &signingPolicy{defaultAction: policyAllow, kinds: make(map[int]string)}
+	if c == nil {
+		return policy
+	}
+	for key, action := range c.KindPolicy {
+		kind, err := parsePolicyKey(key)
+		if err != nil || validatePolicyAction(action) != nil {
+			continue
+		}
+		policy.set(kind, action)
+	}
+	if c.strictEnabled() {
+		policy.strict = make(map[int]bool)
+		for _, kind := range strictConfirmKinds {
+			kind := kind
+			policy.strict[kind] = true
+			policy.set(&kind, "")
+		}
+	}
+	return policy
+}
+
+// action returns what the policy says for kind
+func (p *signingPolicy) action(kind int) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if action, ok := p.kinds[kind]; ok {
+		return action
+	}
+	return p.defaultAction
+}
+
+// strictest returns the strictest action over kinds (deny before confirm
+// before allow) and the kind it applies to
+func (p *signingPolicy) strictest(kinds []int) (string, int) {
+	action, which := policyAllow, 0
+	for _, kind := range kinds {
+		switch p.action(kind) {
+		case policyDeny:
+			return policyDeny, kind
+		case policyConfirm:
+			if action == policyAllow {
+				action, which = policyConfirm, kind
+			}
+		}
+	}
+	return action, which
+}
+
+// set changes the action for kind (nil = the default). An empty action
+// removes the kind's entry, or resets the default to allow.
+func (p *signingPolicy) set(kind *int, action string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case kind == nil && action == "":
+		p.defaultAction = policyAllow
+	case kind == nil:
+		p.defaultAction = action
+	case action == "":
+		delete(p.kinds, *kind)
+	default:
+		p.kinds[*kind] = action
+	}
+	if kind != nil && p.strict[*kind] && p.kinds[*kind] != policyDeny {
+		p.kinds[*kind] = policyConfirm
+	}
+}
+
+// weakens reports whether setting action for kind would lift strict mode's
+// confirmation
+func (p *signingPolicy) weakens(kind *int, action string) bool {
+	return kind != nil && p.strict[*kind] && action == policyAllow
+}
+
+// snapshot returns the default and the per-kind actions keyed by kind
+func (p *signingPolicy) snapshot() (string, map[string]string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	kinds := make(map[string]string, len(p.kinds))
+	for kind, action := range p.kinds {
+		kinds[strconv.Itoa(kind)] = action
+	}
+	return p.defaultAction, kinds
+}
+
+// fillConfigValues replaces the kind_policy.* entries of an effective
+// config with the live policy, which set_policy may have changed
+func (p *signingPolicy) fillConfigValues(values map[string]string) {
+	for key := range values {
+		if strings.HasPrefix(key, "kind_policy.") {
+			delete(values, key)
+		}
+	}
+	defaultAction, kinds := p.snapshot()
+	values["kind_policy."+policyDefaultKey] = defaultAction
+	for key, action := range kinds {
+		values["kind_policy."+key] = action
+	}
+}
+
+// signingKinds returns the kinds a request would sign (none for requests
+// that don't sign, or whose kinds the handler will reject anyway)
+func signingKinds(req SignRequest) []int {
+	switch req.Method {
+	case "sign_event":
+		if kind := eventKind(req.EventJSON); kind != nil {
+			return []int{*kind}
+		}
+	case "zap_request":
+		if req.Zap != nil {
+			return []int{zapRequestKind}
+		}
+	case "sign_relay_list":
+		return []int{relayListKind}
+	case "send_dm":
+		return []int{dmKind}
+	case "gift_wrap":
+		// The rumor's kind: the seal only carries it
+		if kind := eventKind(req.EventJSON); kind != nil {
+			return []int{*kind}
+		}
+	case "sign_events":
+		var kinds []int
+		for _, eventJSON := range req.Events {
+			if kind := eventKind(eventJSON); kind != nil {
+				kinds = append(kinds, *kind)
+			}
+		}
+		return kinds
+	}
+	return nil
+}
+
+// checkSigningPolicy applies kind_policy to a signing request. It returns
+// nil when the request may go ahead, else the response that refuses it. A
+// batch is refused as a whole if any of its kinds is denied. With
+// approval_command set, confirm is left to approveRequest; otherwise the
+// request is parked for an operator (see parkApproval), or, re-sent with
+// the id it was parked under, goes ahead once approved. permitted skips
+// the confirmation (a remembered permission covers the request).
+func (d *Daemon) checkSigningPolicy(session *connSession, req SignRequest, permitted bool) *SignResponse {
+	kinds := signingKinds(req)
+	if len(kinds) == 0 {
+		return nil
+	}
+
+	action, kind := d.policy.strictest(kinds)
+	switch action {
+	case policyDeny:
+		logInfo("⛔ Signing policy denies %s (%s from %s)", describeKind(kind), req.Method, session.identity(req))
+		return &SignResponse{
+			ID:    req.ID,
+			Error: fmt.Sprintf("signing policy denies %s", describeKind(kind)),
+			Code:  codePolicy,
+		}
+	case policyConfirm:
+		if permitted || d.config.approvalCommand() != "" || d.asker != nil {
+			// approval_command or the --ask prompt confirms it
+			return nil
+		}
+		if req.Nonce != "" {
+			return d.redeemApproval(session, req)
+		}
+		return d.parkApproval(session, req, kind)
+	}
+	return nil
+}
+
+// parkApproval queues a request for confirmation, announces it on the
+// event stream and answers ERR_PENDING with its id at once. The operator
+// answers with respond_approval ('noorsigner approve' / 'noorsigner
+// reject') within policyConfirmTTL; the client then collects the result
+// with await_result or re-sends the request with the id as nonce.
+func (d *Daemon) parkApproval(session *connSession, req SignRequest, kind int) *SignResponse {
+	traceID := newTraceID()
+	expiresAt := time.Now().Add(policyConfirmTTL)
+	parked := req
+	parked.Token = ""
+	pending := &pendingApproval{
+		info: PendingApproval{
+			ApprovalContext: d.approvalContext(session, req, traceID),
+			ExpiresAt:       expiresAt.Unix(),
+		},
+		connID:  session.id,
+		peerPID: session.peer.PID,
+		client:  session.client,
+		req:     parked,
+		session: &connSession{
+			id:     session.id,
+			client: session.client,
+			peer:   session.peer,
+			exe:    session.exe,
+		},
+		kind: kind,
+		done: make(chan struct{}),
+	}
+
+	d.pendingApprovalsMu.Lock()
+	d.pendingApprovals[traceID] = pending
+	d.pendingApprovalsMu.Unlock()
+
+	time.AfterFunc(policyConfirmTTL, func() { d.expireApproval(pending) })
+
+	client := pending.info.Client
+	logInfo("✋ %s from %s needs confirmation (%s) - run: noorsigner approve %s",
+		req.Method, client, describeKind(kind), traceID)
+	d.emit(StreamEvent{
+		Type: "approval_requested",
+		Npub: pending.info.Npub,
+		Data: map[string]string{
+			"id":         traceID,
+			"method":     req.Method,
+			"kind":       strconv.Itoa(kind),
+			"client":     client.String(),
+			"expires_at": strconv.FormatInt(expiresAt.Unix(), 10),
+		},
+	})
+
+	return &SignResponse{
+		ID:         req.ID,
+		Error:      fmt.Sprintf("%s requires confirmation - pending as %s", describeKind(kind), traceID),
+		Code:       codePending,
+		ApprovalID: traceID,
+	}
+}
+
+// decideApproval settles a parked request. Only the first decision counts;
+// it returns false for any later one. The decision is kept for
+// policyResultTTL, then purged.
+func (d *Daemon) decideApproval(pending *pendingApproval, approved bool, outcome string) bool {
+	d.pendingApprovalsMu.Lock()
+	if pending.decided {
+		d.pendingApprovalsMu.Unlock()
+		return false
+	}
+	pending.decided, pending.approved, pending.outcome = true, approved, outcome
+	close(pending.done)
+	d.pendingApprovalsMu.Unlock()
+
+	logInfo("✋ Approval %s: %s from %s %s", pending.info.TraceID, pending.req.Method, pending.info.Client, outcome)
+	time.AfterFunc(policyResultTTL, func() { d.purgeApproval(pending) })
+	return true
+}
+
+// expireApproval denies a request nobody answered within policyConfirmTTL
+func (d *Daemon) expireApproval(pending *pendingApproval) {
+	if d.decideApproval(pending, false, "not confirmed in time") {
+		d.auditApprovalExpired(pending, "not confirmed in time")
+	}
+}
+
+// purgeApproval drops a decision once policyResultTTL has passed. An
+// approval the client never used is audited, like an unanswered request.
+func (d *Daemon) purgeApproval(pending *pendingApproval) {
+	d.pendingApprovalsMu.Lock()
+	delete(d.pendingApprovals, pending.info.TraceID)
+	unused := pending.approved && !pending.redeemed && pending.result == nil
+	d.pendingApprovalsMu.Unlock()
+
+	if unused {
+		logInfo("⌛ Approval %s was never collected - purged", pending.info.TraceID)
+		d.auditApprovalExpired(pending, "approved, but the client never collected the result")
+	}
+}
+
+// auditApprovalExpired writes an approval_expired entry to audit.log
+func (d *Daemon) auditApprovalExpired(pending *pendingApproval, reason string) {
+	if !d.config.auditEnabled() {
+		return
+	}
+	kind := pending.kind
+	err := appendAuditEntry(AuditEntry{
+		Timestamp:      time.Now().Unix(),
+		Source:         "daemon",
+		PID:            os.Getpid(),
+		Action:         "approval_expired",
+		Npub:           pending.info.Npub,
+		Client:         pending.client,
+		Error:          fmt.Sprintf("%s %s: %s", pending.req.Method, pending.info.TraceID, reason),
+		Kind:           &kind,
+		Items:          pending.info.Items,
+		ClientIdentity: pending.info.Client,
+	})
+	if err != nil {
+		logError("❌ Cannot write audit log: %v", err)
+	}
+}
+
+// findApproval returns the parked request id for the client that sent it
+// (with require_auth, the same client token must ask)
+func (d *Daemon) findApproval(session *connSession, id string) (*pendingApproval, *SignResponse) {
+	d.pendingApprovalsMu.Lock()
+	pending, ok := d.pendingApprovals[id]
+	d.pendingApprovalsMu.Unlock()
+
+	if !ok || (pending.client != "" && pending.client != session.client) {
+		return nil, &SignResponse{Error: "unknown or expired approval request", Code: codeInvalidRequest}
+	}
+	return pending, nil
+}
+
+// undecidedResponse answers a request whose approval is still open or was
+// denied
+func undecidedResponse(req SignRequest, pending *pendingApproval) *SignResponse {
+	if !pending.decided {
+		return &SignResponse{
+			ID:         req.ID,
+			Error:      fmt.Sprintf("%s is still waiting for confirmation (id %s)", describeKind(pending.kind), pending.info.TraceID),
+			Code:       codePending,
+			ApprovalID: pending.info.TraceID,
+		}
+	}
+	return &SignResponse{
+		ID:    req.ID,
+		Error: fmt.Sprintf("%s requires confirmation: %s (id %s)", describeKind(pending.kind), pending.outcome, pending.info.TraceID),
+		Code:  codeApprovalDenied,
+	}
+}
+
+// redeemApproval lets a re-sent request go ahead if the request parked
+// under its nonce was approved. It must be the same request, and an
+// approval is used once.
+func (d *Daemon) redeemApproval(session *connSession, req SignRequest) *SignResponse {
+	pending, response := d.findApproval(session, req.Nonce)
+	if response != nil {
+		response.ID = req.ID
+		return response
+	}
+	if !sameSigningRequest(req, pending.req) {
+		return &SignResponse{
+			ID:    req.ID,
+			Error: fmt.Sprintf("request differs from the one pending as %s", req.Nonce),
+			Code:  codeInvalidRequest,
+		}
+	}
+
+	d.pendingApprovalsMu.Lock()
+	defer d.pendingApprovalsMu.Unlock()
+	if !pending.decided || !pending.approved {
+		return undecidedResponse(req, pending)
+	}
+	if pending.redeemed || pending.result != nil {
+		return &SignResponse{ID: req.ID, Error: "approval was already used", Code: codeInvalidRequest}
+	}
+	pending.redeemed = true
+	return nil
+}
+
+// sameSigningRequest reports whether a and b ask to sign the same thing
+func sameSigningRequest(a, b SignRequest) bool {
+	type signing struct {
+		Method    string
+		EventJSON string
+		Events    []string
+		Zap       *ZapRequest
+	}
+	first, _ := json.Marshal(signing{a.Method, a.EventJSON, a.Events, a.Zap})
+	second, _ := json.Marshal(signing{b.Method, b.EventJSON, b.Events, b.Zap})
+	return bytes.Equal(first, second)
+}
+
+// awaitResult answers await_result: it waits up to timeout (default 30s)
+// for the decision on a parked request and, once approved, runs it and
+// returns its response. The response is kept, so asking again returns it
+// again instead of signing twice.
+func (d *Daemon) awaitResult(session *connSession, req SignRequest, encoder *json.Encoder) {
+	timeout, err := parseAwaitTimeout(req.Timeout)
+	if err != nil {
+		encoder.Encode(invalidRequestResponse(req, redactedError(err)))
+		return
+	}
+	pending, response := d.findApproval(session, req.Nonce)
+	if response != nil {
+		response.ID = req.ID
+		encoder.Encode(response)
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	select {
+	case <-pending.done:
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	d.pendingApprovalsMu.Lock()
+	redeemed := pending.redeemed
+	if !pending.decided || !pending.approved {
+		response := undecidedResponse(req, pending)
+		d.pendingApprovalsMu.Unlock()
+		encoder.Encode(response)
+		return
+	}
+	d.pendingApprovalsMu.Unlock()
+	if redeemed {
+		encoder.Encode(SignResponse{ID: req.ID, Error: "approval was already used by a re-sent request", Code: codeInvalidRequest})
+		return
+	}
+
+	pending.run.Do(func() {
+		var output bytes.Buffer
+		recorder := &responseRecorder{w: &output, capture: auditedMethods[pending.req.Method]}
+		// A request naming its account doesn't depend on the active one
+		if d.requestNpub(pending.req) == pending.info.Npub {
+			d.handleRequest(nil, pending.session, pending.req, json.NewEncoder(recorder))
+		} else {
+			json.NewEncoder(recorder).Encode(SignResponse{
+				ID:    pending.req.ID,
+				Error: "the active account changed since the request was sent",
+				Code:  codePubkeyMismatch,
+			})
+		}
+		d.recordActivity(pending.session, pending.req, recorder.last)
+
+		d.pendingApprovalsMu.Lock()
+		pending.result = output.Bytes()
+		d.pendingApprovalsMu.Unlock()
+	})
+
+	// The stored response answers this request: give it this request's id
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(pending.result, &result); err != nil {
+		encoder.Encode(SignResponse{ID: req.ID, Error: "internal error - the request was not completed", Code: codeInternal})
+		return
+	}
+	result["id"], _ = json.Marshal(req.ID)
+	encoder.Encode(result)
+}
+
+// npubMatches reports whether npub is the active account
+func (d *Daemon) npubMatches(npub string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.npub == npub
+}
+
+// parseAwaitTimeout parses await_result's timeout (empty = 30s, 0 = don't wait)
+func parseAwaitTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultAwaitTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %v", value, err)
+	}
+	if timeout < 0 || timeout > policyConfirmTTL {
+		return 0, fmt.Errorf("timeout must be between 0s and %s", policyConfirmTTL)
+	}
+	return timeout, nil
+}
+
+// listPendingApprovals returns the requests still waiting, oldest first
+func (d *Daemon) listPendingApprovals() []PendingApproval {
+	d.pendingApprovalsMu.Lock()
+	defer d.pendingApprovalsMu.Unlock()
+
+	requests := []PendingApproval{}
+	for _, pending := range d.pendingApprovals {
+		if !pending.decided {
+			requests = append(requests, pending.info)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Timestamp < requests[j].Timestamp
+	})
+	return requests
+}
+
+// respondApproval answers respond_approval. The client that sent a request
+// can't confirm it: not on the same connection, not from the same process
+// and not with the same client token.
+func (d *Daemon) respondApproval(session *connSession, req SignRequest) AccountActionResponse {
+	d.pendingApprovalsMu.Lock()
+	pending, ok := d.pendingApprovals[req.Nonce]
+	d.pendingApprovalsMu.Unlock()
+
+	if !ok {
+		return AccountActionResponse{ID: req.ID, Error: "unknown or expired approval request"}
+	}
+	if pending.connID == session.id ||
+		(pending.peerPID != 0 && pending.peerPID == session.peer.PID) ||
+		(pending.client != "" && pending.client == session.client) {
+		return AccountActionResponse{
+			ID:    req.ID,
+			Error: "a client can't confirm its own request",
+			Code:  codePolicy,
+		}
+	}
+
+	outcome := "denied by operator"
+	if req.Approve {
+		outcome = "approved by operator"
+	}
+	if !d.decideApproval(pending, req.Approve, outcome) {
+		return AccountActionResponse{ID: req.ID, Error: "approval request was already answered or has expired"}
+	}
+	if req.Approve && req.Remember {
+		d.rememberApproved(pending.session, pending.req, pending.info.Npub)
+	}
+	return AccountActionResponse{ID: req.ID, Success: true}
+}
+
+// policyResponse returns the running policy
+func (d *Daemon) policyResponse(id string) PolicyResponse {
+	defaultAction, kinds := d.policy.snapshot()
+	return PolicyResponse{ID: id, Default: defaultAction, Kinds: kinds}
+}
+
+// setPolicy answers set_policy: the change is written to config.json first,
+// so it survives a restart, then applied to the running daemon
+func (d *Daemon) setPolicy(req SignRequest) PolicyResponse {
+	if req.Policy == nil {
+		return PolicyResponse{ID: req.ID, Error: "policy required", Code: codeInvalidRequest}
+	}
+	update := req.Policy
+	if update.Kind != nil && (*update.Kind < 0 || *update.Kind > maxPolicyKind) {
+		return PolicyResponse{ID: req.ID, Error: fmt.Sprintf("invalid kind %d", *update.Kind), Code: codeInvalidRequest}
+	}
+	if update.Action != "" {
+		if err := validatePolicyAction(update.Action); err != nil {
+			return PolicyResponse{ID: req.ID, Error: redactedError(err), Code: codeInvalidRequest}
+		}
+	}
+	if d.policy.weakens(update.Kind, update.Action) {
+		return PolicyResponse{
+			ID:    req.ID,
+			Error: fmt.Sprintf("strict mode requires confirmation for %s", describeKind(*update.Kind)),
+			Code:  codeStrict,
+		}
+	}
+
+	if err := setConfigValue("kind_policy."+policyKey(update.Kind), update.Action); err != nil {
+		return PolicyResponse{ID: req.ID, Error: fmt.Sprintf("cannot save policy: %v", err)}
+	}
+	d.policy.set(update.Kind, update.Action)
+
+	action := update.Action
+	if action == "" {
+		action = "reset"
+	}
+	logInfo("📜 Signing policy: %s → %s", describePolicyKey(update.Kind), action)
+	return d.policyResponse(req.ID)
+}
+
+// confirmPolicyCLI applies kind_policy to the CLI's own signing. confirm
+// asks on the terminal; without one the event is refused.
+func confirmPolicyCLI(kind int, auditAction, npub string) error {
+	policy := newSigningPolicy(appConfig)
+	switch policy.action(kind) {
+	case policyDeny:
+		auditCLI(auditAction, npub, fmt.Errorf("signing policy denies %s", describeKind(kind)), &kind)
+		return commandFailed(1, "⛔ Signing policy denies %s", describeKind(kind))
+	case policyConfirm:
+		if !stdinIsInteractive() {
+			return commandFailed(1, "✋ Signing policy requires confirmation for %s - pass the event with --file and run in a terminal", describeKind(kind))
+		}
+		answer, err := readInput(fmt.Sprintf("✋ Signing policy: sign %s as %s? [y/N] ", describeKind(kind), displayAccount(npub)))
+		if err != nil || !strings.EqualFold(answer, "y") {
+			auditCLI(auditAction, npub, fmt.Errorf("not confirmed"), &kind)
+			return commandFailed(1, "❌ Not signed")
+		}
+	}
+	return nil
+}
+
+// printPolicy prints a policy for people
+func printPolicy(defaultAction string, kinds map[string]string) {
+	keys := make([]int, 0, len(kinds))
+	for key := range kinds {
+		if kind, err := strconv.Atoi(key); err == nil {
+			keys = append(keys, kind)
+		}
+	}
+	sort.Ints(keys)
+
+	fmt.Println("Signing policy:")
+	for _, kind := range keys {
+		fmt.Printf("  %-40s %s\n", describeKind(kind), kinds[strconv.Itoa(kind)])
+	}
+	fmt.Printf("  %-40s %s\n", "other kinds", defaultAction)
+}
+
+// policyCmd shows or changes kind_policy. With a daemon running, changes go
+// through set_policy and apply at once; otherwise config.json is edited.
+func policyCmd(args []string) error {
+	usage := "Usage: noorsigner policy [set <kind|default> allow|deny|confirm | reset <kind|default>]"
+
+	switch {
+	case len(args) == 0:
+		if isDaemonRunning() {
+			policy, err := getPolicyViaDaemon()
+			if err != nil {
+				return commandFailed(1, "❌ %v", err)
+			}
+			printPolicy(policy.Default, policy.Kinds)
+			return nil
+		}
+		defaultAction, kinds := newSigningPolicy(appConfig).snapshot()
+		printPolicy(defaultAction, kinds)
+
+	case len(args) == 3 && args[0] == "set", len(args) == 2 && args[0] == "reset":
+		kind, err := parsePolicyKey(args[1])
+		if err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+		action := ""
+		if args[0] == "set" {
+			action = args[2]
+			if err := validatePolicyAction(action); err != nil {
+				return commandFailed(1, "❌ %v", err)
+			}
+		}
+		if newSigningPolicy(appConfig).weakens(kind, action) {
+			return commandFailed(1, "❌ Strict mode requires confirmation for %s", describeKind(*kind))
+		}
+
+		if isDaemonRunning() {
+			if _, err := setPolicyViaDaemon(PolicyUpdate{Kind: kind, Action: action}); err != nil {
+				return commandFailed(1, "❌ %v", err)
+			}
+		} else if err := setConfigValue("kind_policy."+policyKey(kind), action); err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+
+		if action == "" {
+			fmt.Printf("✅ %s: reset\n", describePolicyKey(kind))
+		} else {
+			fmt.Printf("✅ %s: %s\n", describePolicyKey(kind), action)
+		}
+
+	default:
+		return commandFailed(1, "%s", usage)
+	}
+	return nil
+}
+
+// describeApproval names what a waiting request would do
+func describeApproval(approval ApprovalContext) string {
+	switch {
+	case approval.Kind != nil:
+		return fmt.Sprintf("%s %s", approval.Method, describeKind(*approval.Kind))
+	case len(approval.Kinds) > 0:
+		kinds := make([]string, len(approval.Kinds))
+		for i, kind := range approval.Kinds {
+			kinds[i] = strconv.Itoa(kind)
+		}
+		return fmt.Sprintf("%s (%d events, kinds %s)", approval.Method, approval.Items, strings.Join(kinds, ", "))
+	}
+	return approval.Method
+}
+
+// approveCmd answers a pending approval request. remember also lets the
+// client skip confirmation for the same method and kinds from now on.
+func approveCmd(id string, approve, remember bool) error {
+	if err := respondApprovalViaDaemon(id, approve, remember); err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if approve && remember {
+		fmt.Printf("✅ Request %s approved and remembered (see: noorsigner permissions list)\n", id)
+	} else if approve {
+		fmt.Printf("✅ Request %s approved\n", id)
+	} else {
+		fmt.Printf("🚫 Request %s rejected\n", id)
+	}
+	return nil
+}
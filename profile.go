@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// defaultProfileRelays are asked for kind 0 events when profile_relays
+	// is unset
+	defaultProfileRelays = "wss://purplepag.es,wss://relay.damus.io,wss://nos.lol"
+
+	// profileFetchTimeout bounds a whole fetch, all relays together
+	profileFetchTimeout = 10 * time.Second
+
+	// Longest profile fields kept, in characters; the rest is cut
+	maxProfileFieldLen = 64
+	maxProfileURLLen   = 512
+)
+
+// AccountProfile is the part of an account's kind 0 event noorsigner keeps
+// in metadata.json, so list-accounts can show a name without a relay.
+// Fields come from whoever published the event and are only cleaned for
+// display; nip05 is not verified.
+type AccountProfile struct {
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Picture     string `json:"picture,omitempty"`
+	Nip05       string `json:"nip05,omitempty"`
+	// EventCreatedAt is the kind 0 event's created_at; an older event
+	// doesn't replace a newer one
+	EventCreatedAt int64 `json:"event_created_at"`
+	FetchedAt      int64 `json:"fetched_at"`
+}
+
+// displayName returns the name to show: display_name, else name
+func (p *AccountProfile) displayName() string {
+	if p == nil {
+		return ""
+	}
+	if p.DisplayName != "" {
+		return p.DisplayName
+	}
+	return p.Name
+}
+
+// parseProfileRelays parses profile_relays (empty = defaultProfileRelays)
+func parseProfileRelays(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		value = defaultProfileRelays
+	}
+	var relays []string
+	for _, relay := range strings.Split(value, ",") {
+		relay = strings.TrimSpace(relay)
+		if relay == "" {
+			continue
+		}
+		if err := checkZapRelay(relay); err != nil {
+			return nil, fmt.Errorf("invalid profile relay %q: expected a wss:// or ws:// URL", relay)
+		}
+		relays = append(relays, relay)
+	}
+	return relays, nil
+}
+
+// profileRelays returns the configured profile relays
+func (c *Config) profileRelays() []string {
+	value := ""
+	if c != nil {
+		value = c.ProfileRelays
+	}
+	relays, err := parseProfileRelays(value)
+	if err != nil {
+		relays, _ = parseProfileRelays("")
+	}
+	return relays
+}
+
+// cleanProfileField drops control characters (a name must not move the
+// terminal's cursor) and cuts the field to max characters
+func cleanProfileField(value string, max int) string {
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, value)
+	value = strings.TrimSpace(value)
+	if utf8.RuneCountInString(value) > max {
+		value = string([]rune(value)[:max])
+	}
+	return value
+}
+
+// parseProfileEvent reads the fields we keep from a kind 0 event
+func parseProfileEvent(event *nostr.Event) (*AccountProfile, error) {
+	var content struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+		// Older clients wrote displayName
+		DisplayNameCamel string `json:"displayName"`
+		Picture          string `json:"picture"`
+		Nip05            string `json:"nip05"`
+	}
+	if err := json.Unmarshal([]byte(event.Content), &content); err != nil {
+		return nil, fmt.Errorf("invalid kind 0 content: %v", err)
+	}
+	if content.DisplayName == "" {
+		content.DisplayName = content.DisplayNameCamel
+	}
+	return &AccountProfile{
+		Name:           cleanProfileField(content.Name, maxProfileFieldLen),
+		DisplayName:    cleanProfileField(content.DisplayName, maxProfileFieldLen),
+		Picture:        cleanProfileField(content.Picture, maxProfileURLLen),
+		Nip05:          cleanProfileField(content.Nip05, maxProfileFieldLen),
+		EventCreatedAt: int64(event.CreatedAt),
+	}, nil
+}
+
+// fetchNewestEvents asks every relay for the events of kind by pubkeys
+// (kind 0 profiles, kind 10050 DM relays...) and returns the newest validly
+// signed one per pubkey, with an error for each relay that failed. Relays
+// are asked in parallel; one that doesn't answer within
+// profileFetchTimeout counts as failed.
+func fetchNewestEvents(relays []string, kind int, pubkeys []string) (map[string]*nostr.Event, []error) {
+	ctx, cancel := context.WithTimeout(context.Background(), profileFetchTimeout)
+	defer cancel()
+
+	wanted := make(map[string]bool, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		wanted[pubkey] = true
+	}
+	newest := make(map[string]*nostr.Event)
+	var failures []error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, relayURL := range relays {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			events, err := queryRelay(ctx, relayURL, nostr.Filter{Kinds: []int{kind}, Authors: pubkeys})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures = append(failures, fmt.Errorf("%s: %v", relayURL, err))
+				return
+			}
+			for _, event := range events {
+				if event.Kind != kind || !wanted[event.PubKey] {
+					continue
+				}
+				if ok, _ := event.CheckSignature(); !ok {
+					continue
+				}
+				if current := newest[event.PubKey]; current == nil || event.CreatedAt > current.CreatedAt {
+					newest[event.PubKey] = event
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return newest, failures
+}
+
+// queryRelay fetches the events matching filter from one relay
+func queryRelay(ctx context.Context, relayURL string, filter nostr.Filter) ([]*nostr.Event, error) {
+	relay, err := nostr.RelayConnect(ctx, relayURL)
+	if err != nil {
+		return nil, err
+	}
+	defer relay.Close()
+	return relay.QuerySync(ctx, filter)
+}
+
+// saveAccountProfile caches a fetched profile in the account's
+// metadata.json under storage.lock. A cached profile from a newer event is
+// kept; only its fetched_at moves.
+func saveAccountProfile(npub string, profile *AccountProfile) error {
+	store, err := accountStore()
+	if err != nil {
+		return err
+	}
+	return withStorageLock(func() error {
+		if _, _, err := store.ReadRecord(npub, recordKey); err != nil {
+			return err
+		}
+		meta, err := loadAccountMetadata(npub)
+		if err != nil {
+			return err
+		}
+		if meta.Profile != nil && meta.Profile.EventCreatedAt > profile.EventCreatedAt {
+			meta.Profile.FetchedAt = profile.FetchedAt
+		} else {
+			meta.Profile = profile
+		}
+		content, err := marshalAccountMetadata(meta)
+		if err != nil {
+			return err
+		}
+		return store.WriteRecords(npub, storeRecord{Name: recordMetadata, Data: content})
+	})
+}
+
+// refreshProfiles fetches the profiles of accounts from the profile relays
+// and caches those found. Failed relays are reported and otherwise
+// ignored: accounts keep their cached profile, or none.
+func refreshProfiles(accounts []AccountInfo) {
+	relays := appConfig.profileRelays()
+	pubkeys := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		pubkeys = append(pubkeys, account.Pubkey)
+	}
+
+	events, failures := fetchNewestEvents(relays, nostr.KindProfileMetadata, pubkeys)
+	for _, failure := range failures {
+		fmt.Fprintf(os.Stderr, "⚠️  Profile relay %v\n", failure)
+	}
+	if len(failures) == len(relays) {
+		fmt.Fprintln(os.Stderr, "⚠️  No profile relay answered - showing cached profiles")
+		return
+	}
+
+	now := time.Now().Unix()
+	for _, account := range accounts {
+		event := events[account.Pubkey]
+		if event == nil {
+			continue
+		}
+		profile, err := parseProfileEvent(event)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  %s: %v\n", labeledNpub(account.Npub, account.Label), err)
+			continue
+		}
+		profile.FetchedAt = now
+		if err := saveAccountProfile(account.Npub, profile); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Cannot cache the profile of %s: %v\n", labeledNpub(account.Npub, account.Label), err)
+		}
+	}
+}
+
+// profileCmd fetches and shows the kind 0 profile of one account, or of
+// every account without an argument
+func profileCmd(args []string) error {
+	if len(args) > 1 {
+		return commandFailed(1, "Usage: noorsigner profile [account]")
+	}
+
+	accounts, err := listAccounts()
+	if err != nil {
+		return commandFailed(1, "Error listing accounts: %v", err)
+	}
+	if len(args) == 1 {
+		npub, err := resolveAccount(args[0])
+		if err != nil {
+			return commandFailed(1, "%v\nUse 'list-accounts' to see available accounts.", err)
+		}
+		selected := accounts[:0]
+		for _, account := range accounts {
+			if account.Npub == npub {
+				selected = append(selected, account)
+			}
+		}
+		if len(selected) == 0 {
+			return commandFailed(1, "Account not found: %s\nUse 'list-accounts' to see available accounts.", displayNpub(npub))
+		}
+		accounts = selected
+	}
+	if len(accounts) == 0 {
+		fmt.Println("No accounts found. Use 'add-account' to add one.")
+		return nil
+	}
+
+	refreshProfiles(accounts)
+	for i, account := range accounts {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(labeledNpub(account.Npub, account.Label))
+		meta, err := loadAccountMetadata(account.Npub)
+		if err != nil || meta.Profile == nil {
+			fmt.Println("   No profile found")
+			continue
+		}
+		printAccountProfile(meta.Profile)
+	}
+	return nil
+}
+
+// printAccountProfile prints a cached profile's fields
+func printAccountProfile(profile *AccountProfile) {
+	if profile.Name != "" {
+		fmt.Printf("   Name:     %s\n", profile.Name)
+	}
+	if profile.DisplayName != "" {
+		fmt.Printf("   Display:  %s\n", profile.DisplayName)
+	}
+	if profile.Nip05 != "" {
+		fmt.Printf("   NIP-05:   %s (not verified)\n", profile.Nip05)
+	}
+	if profile.Picture != "" {
+		fmt.Printf("   Picture:  %s\n", profile.Picture)
+	}
+	fmt.Printf("   Fetched:  %s\n", time.Unix(profile.FetchedAt, 0).Format("2006-01-02 15:04"))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// IPC protocol versions. A request without "version" is v1: single string
+// results come back in "signature". From v2 on they come back in "result".
+const (
+	protocolVersion    = 2
+	minProtocolVersion = 1
+)
+
+// Error codes of protocol v2. Earlier codes are listed where their methods
+// are handled.
+const (
+	codeLocked             = "ERR_LOCKED"
+	codeBadPassword        = "ERR_BAD_PASSWORD"
+	codePasswordRequired   = "ERR_PASSWORD_REQUIRED"
+	codeUnknownMethod      = "ERR_UNKNOWN_METHOD"
+	codePubkeyMismatch     = "ERR_PUBKEY_MISMATCH"
+	codeInvalidRequest     = "ERR_INVALID_REQUEST"
+	codeUnsupportedVersion = "ERR_UNSUPPORTED_VERSION"
+)
+
+// errBadPassword is returned when a password does not unlock an account
+var errBadPassword = errors.New("invalid password")
+
+// errPasswordRequired is returned by switch_account without a password when
+// the account has no trust session to unlock it with
+var errPasswordRequired = errors.New("password required")
+
+// ProtocolVersionResponse represents get_protocol_version response
+type ProtocolVersionResponse struct {
+	ID string `json:"id"`
+	// Version is the newest protocol version the daemon speaks
+	Version int `json:"version"`
+	// MinVersion is the oldest one it still answers
+	MinVersion int `json:"min_version"`
+}
+
+// protocolVersionOf returns the protocol version a request asked for (v1 if
+// it didn't say)
+func protocolVersionOf(req SignRequest) int {
+	if req.Version == 0 {
+		return 1
+	}
+	return req.Version
+}
+
+// checkProtocolVersion rejects versions the daemon doesn't speak
+func checkProtocolVersion(req SignRequest) *SignResponse {
+	version := protocolVersionOf(req)
+	if version >= minProtocolVersion && version <= protocolVersion {
+		return nil
+	}
+	return &SignResponse{
+		ID:    req.ID,
+		Error: fmt.Sprintf("unsupported protocol version %d (this daemon speaks %d to %d)", req.Version, minProtocolVersion, protocolVersion),
+		Code:  codeUnsupportedVersion,
+	}
+}
+
+// resultResponse answers a method that returns a single string: in
+// "signature" for v1 clients, in "result" from v2 on
+func resultResponse(req SignRequest, result string) SignResponse {
+	if protocolVersionOf(req) >= 2 {
+		return SignResponse{ID: req.ID, Result: result}
+	}
+	return SignResponse{ID: req.ID, Signature: result}
+}
+
+// errorResponse reports err with its error code, if it has one
+func errorResponse(req SignRequest, err error) SignResponse {
+	return SignResponse{ID: req.ID, Error: redactedError(err), Code: errorCode(err)}
+}
+
+// invalidRequestResponse reports a request that lacks required fields
+func invalidRequestResponse(req SignRequest, message string) SignResponse {
+	return SignResponse{ID: req.ID, Error: redactSecrets("error response", message), Code: codeInvalidRequest}
+}
+
+// errorCode maps an error to its machine-readable code ("" if it has none)
+func errorCode(err error) string {
+	var refused *eventError
+	switch {
+	case errors.Is(err, errDaemonLocked):
+		return codeLocked
+	case errors.Is(err, errTrustExpired):
+		return codeTrustExpired
+	case errors.Is(err, errBadPassword):
+		return codeBadPassword
+	case errors.Is(err, errPasswordRequired):
+		return codePasswordRequired
+	case errors.Is(err, errLockedOut):
+		return codeLockedOut
+	case errors.Is(err, errPasswordDisabled):
+		return codePasswordDisabled
+	case errors.Is(err, errSignerFrozen):
+		return codeFrozen
+	case errors.Is(err, errAccountLocked):
+		return codeAccountLocked
+	case errors.Is(err, errUnknownAccount):
+		return codeUnknownAccount
+	case errors.Is(err, errAccountField), errors.Is(err, errInvalidLabel), errors.Is(err, errAmbiguousAccount), errors.Is(err, errInvalidRelay):
+		return codeInvalidRequest
+	case errors.Is(err, errLabelTaken):
+		return codeLabelTaken
+	case errors.As(err, &refused):
+		return refused.Code
+	}
+	return ""
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRateLimit bounds sign_rate_limit and encrypt_rate_limit
+	maxRateLimit = 100000
+
+	// rateLimitGlobal is the bucket of clients that can't be told apart
+	rateLimitGlobal = "global"
+
+	codeRateLimited = "ERR_RATE_LIMITED"
+)
+
+// rateLimitClasses maps each limited method to its class: signing methods
+// share sign_rate_limit, the NIP-04/NIP-44 methods encrypt_rate_limit
+var rateLimitClasses = map[string]string{
+	"sign_event":          "sign",
+	"sign_events":         "sign",
+	"zap_request":         "sign",
+	"sign_relay_list":     "sign",
+	"gift_wrap":           "sign",
+	"unwrap":              "encrypt",
+	"send_dm":             "sign",
+	"decrypt_dm":          "encrypt",
+	"nip44_encrypt":       "encrypt",
+	"nip44_decrypt":       "encrypt",
+	"nip44_decrypt_batch": "encrypt",
+	"nip04_encrypt":       "encrypt",
+	"nip04_decrypt":       "encrypt",
+}
+
+// RateLimitStats reports one client's bucket in get_status
+type RateLimitStats struct {
+	// Client is the permission identity (see permissionIdentity), or
+	// "global" for clients that can't be told apart
+	Client string `json:"client"`
+	Class  string `json:"class"`
+	// Limit is the configured requests per minute
+	Limit   int    `json:"limit"`
+	Allowed uint64 `json:"allowed"`
+	Limited uint64 `json:"limited"`
+	// LastLimitedAt is when a request was last refused (Unix time, 0 = never)
+	LastLimitedAt int64 `json:"last_limited_at,omitempty"`
+}
+
+// rateBucket is a token bucket holding up to a minute's worth of requests
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+
+	allowed     uint64
+	limited     uint64
+	lastLimited time.Time
+	refusing    bool // Refused the last request
+}
+
+// rateLimiter keeps a token bucket per client and class. A bucket refills
+// at its limit per minute and holds at most one minute's worth, so a
+// client may burst up to the limit and then keeps to the rate.
+type rateLimiter struct {
+	limits map[string]int // Requests per minute by class, 0 = no limit
+	now    func() time.Time
+
+	mu      sync.Mutex
+	buckets map[rateKey]*rateBucket
+}
+
+// rateKey identifies a bucket
+type rateKey struct {
+	client string
+	class  string
+}
+
+// newRateLimiter returns a limiter for sign_rate_limit and encrypt_rate_limit
+func newRateLimiter(config *Config) *rateLimiter {
+	return &rateLimiter{
+		limits: map[string]int{
+			"sign":    config.signRateLimit(),
+			"encrypt": config.encryptRateLimit(),
+		},
+		now:     time.Now,
+		buckets: make(map[rateKey]*rateBucket),
+	}
+}
+
+// rateLimitClient names the bucket a session's requests count against
+func rateLimitClient(session *connSession) string {
+	if identity := permissionIdentity(session); identity != "" {
+		return identity
+	}
+	return rateLimitGlobal
+}
+
+// rateLimitCost returns how many requests a request counts as: one per
+// event or payload of a batch
+func rateLimitCost(req SignRequest) int {
+	switch req.Method {
+	case "sign_events":
+		return max(len(req.Events), 1)
+	case "nip44_decrypt_batch":
+		return max(len(req.Items), 1)
+	}
+	return 1
+}
+
+// take spends cost tokens from the client's bucket. If there aren't enough
+// it returns how long until there will be, and whether this is the first
+// refusal since the client was last let through. A batch larger than the
+// limit needs a full bucket.
+func (l *rateLimiter) take(client, class string, cost int) (allowed bool, wait time.Duration, first bool) {
+	limit := l.limits[class]
+	if limit <= 0 {
+		return true, 0, false
+	}
+	need := float64(min(cost, limit))
+	rate := float64(limit) / float64(time.Minute) // Tokens per nanosecond
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := rateKey{client: client, class: class}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &rateBucket{tokens: float64(limit), updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(limit), bucket.tokens+float64(now.Sub(bucket.updated))*rate)
+	bucket.updated = now
+
+	if bucket.tokens < need {
+		first = !bucket.refusing
+		bucket.limited++
+		bucket.lastLimited = now
+		bucket.refusing = true
+		return false, time.Duration((need - bucket.tokens) / rate), first
+	}
+	bucket.tokens -= need
+	bucket.allowed++
+	bucket.refusing = false
+	return true, 0, false
+}
+
+// stats returns the buckets sorted by client and class
+func (l *rateLimiter) stats() []RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var stats []RateLimitStats
+	for key, bucket := range l.buckets {
+		entry := RateLimitStats{
+			Client:  key.client,
+			Class:   key.class,
+			Limit:   l.limits[key.class],
+			Allowed: bucket.allowed,
+			Limited: bucket.limited,
+		}
+		if !bucket.lastLimited.IsZero() {
+			entry.LastLimitedAt = bucket.lastLimited.Unix()
+		}
+		stats = append(stats, entry)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Client != stats[j].Client {
+			return stats[i].Client < stats[j].Client
+		}
+		return stats[i].Class < stats[j].Class
+	})
+	return stats
+}
+
+// reset drops the buckets of client ("" = all clients), which refills them
+// and clears their counters. The caller's own buckets are kept, so a
+// client can't lift its own limit. It returns how many were dropped.
+func (l *rateLimiter) reset(client, caller string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	dropped := 0
+	for key := range l.buckets {
+		if key.client == caller || (client != "" && key.client != client) {
+			continue
+		}
+		delete(l.buckets, key)
+		dropped++
+	}
+	return dropped
+}
+
+// checkRateLimit refuses a signing or encryption request with
+// ERR_RATE_LIMITED once its client has used up its requests per minute
+func (d *Daemon) checkRateLimit(session *connSession, req SignRequest) *SignResponse {
+	class, ok := rateLimitClasses[req.Method]
+	if !ok {
+		return nil
+	}
+	client := rateLimitClient(session)
+	allowed, wait, first := d.rateLimits.take(client, class, rateLimitCost(req))
+	if allowed {
+		return nil
+	}
+
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	// Log when a client runs into the limit, not every refusal after
+	if first {
+		logInfo("🚦 conn %d: %s from %s rate limited (%s_rate_limit %d/min)", session.id, req.Method, session.identity(req), class, d.rateLimits.limits[class])
+	}
+	return &SignResponse{
+		ID:         req.ID,
+		Error:      fmt.Sprintf("rate limit exceeded: %d %s requests per minute - retry in %ds", d.rateLimits.limits[class], class, retryAfter),
+		Code:       codeRateLimited,
+		RetryAfter: retryAfter,
+	}
+}
+
+// ResetRateLimitsResponse represents reset_rate_limits response
+type ResetRateLimitsResponse struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	// Reset is how many buckets were refilled and their counters cleared
+	Reset int    `json:"reset"`
+	Error string `json:"error,omitempty"`
+}
+
+// resetRateLimits answers reset_rate_limits
+func (d *Daemon) resetRateLimits(session *connSession, req SignRequest) ResetRateLimitsResponse {
+	caller := rateLimitClient(session)
+	if req.Client != "" && req.Client == caller {
+		return ResetRateLimitsResponse{ID: req.ID, Error: "a client can't reset its own rate limit"}
+	}
+	reset := d.rateLimits.reset(req.Client, caller)
+	target := req.Client
+	if target == "" {
+		target = "all clients"
+	}
+	logInfo("🚦 Rate limits reset for %s (%d bucket(s))", target, reset)
+	return ResetRateLimitsResponse{ID: req.ID, Success: true, Reset: reset}
+}
+
+// printRateLimits prints the clients that ran into a rate limit, for status
+func printRateLimits(stats []RateLimitStats) {
+	var limited []RateLimitStats
+	for _, entry := range stats {
+		if entry.Limited > 0 {
+			limited = append(limited, entry)
+		}
+	}
+	if len(limited) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println("Rate limited:")
+	for _, entry := range limited {
+		fmt.Printf("  %-30s %-7s %d refused, %d allowed (limit %d/min, last %s)\n", entry.Client, entry.Class,
+			entry.Limited, entry.Allowed, entry.Limit, time.Unix(entry.LastLimitedAt, 0).Format("15:04:05"))
+	}
+}
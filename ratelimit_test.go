@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testRateLimiter returns a limiter on clock allowing 60 signing and 120
+// encryption requests per minute
+func testRateLimiter(clock *fakeClock) *rateLimiter {
+	return &rateLimiter{
+		limits:  map[string]int{"sign": 60, "encrypt": 120},
+		now:     clock.Now,
+		buckets: make(map[rateKey]*rateBucket),
+	}
+}
+
+// takeAll spends requests of cost one until the first refusal and returns
+// how many were let through
+func takeAll(l *rateLimiter, client, class string) int {
+	for n := 0; ; n++ {
+		if allowed, _, _ := l.take(client, class, 1); !allowed {
+			return n
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name string
+		// run spends and waits, then reports how many requests the "app"
+		// client gets for signing
+		run  func(clock *fakeClock, l *rateLimiter) int
+		want int
+	}{
+		{"burst up to the limit", func(clock *fakeClock, l *rateLimiter) int {
+			return takeAll(l, "app", "sign")
+		}, 60},
+		{"refill of one per second", func(clock *fakeClock, l *rateLimiter) int {
+			takeAll(l, "app", "sign")
+			clock.advance(time.Second)
+			return takeAll(l, "app", "sign")
+		}, 1},
+		{"refill within a second", func(clock *fakeClock, l *rateLimiter) int {
+			takeAll(l, "app", "sign")
+			clock.advance(999 * time.Millisecond)
+			return takeAll(l, "app", "sign")
+		}, 0},
+		{"refill of half a minute", func(clock *fakeClock, l *rateLimiter) int {
+			takeAll(l, "app", "sign")
+			clock.advance(30 * time.Second)
+			return takeAll(l, "app", "sign")
+		}, 30},
+		{"refill capped at a minute's worth", func(clock *fakeClock, l *rateLimiter) int {
+			takeAll(l, "app", "sign")
+			clock.advance(time.Hour)
+			return takeAll(l, "app", "sign")
+		}, 60},
+		{"other client used up", func(clock *fakeClock, l *rateLimiter) int {
+			takeAll(l, "other", "sign")
+			takeAll(l, rateLimitGlobal, "sign")
+			return takeAll(l, "app", "sign")
+		}, 60},
+		{"other class used up", func(clock *fakeClock, l *rateLimiter) int {
+			takeAll(l, "app", "encrypt")
+			return takeAll(l, "app", "sign")
+		}, 60},
+		{"batch takes its size", func(clock *fakeClock, l *rateLimiter) int {
+			l.take("app", "sign", 50)
+			return takeAll(l, "app", "sign")
+		}, 10},
+		{"reset by another client", func(clock *fakeClock, l *rateLimiter) int {
+			takeAll(l, "app", "sign")
+			l.reset("app", "admin")
+			return takeAll(l, "app", "sign")
+		}, 60},
+		{"reset by the client itself", func(clock *fakeClock, l *rateLimiter) int {
+			takeAll(l, "app", "sign")
+			l.reset("", "app")
+			return takeAll(l, "app", "sign")
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			if got := tt.run(clock, testRateLimiter(clock)); got != tt.want {
+				t.Errorf("%d requests let through, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// about reports whether a wait is want, give or take the bucket's rounding
+func about(wait, want time.Duration) bool {
+	return (wait - want).Abs() < time.Millisecond
+}
+
+// A refusal says how long until the request would be let through, and
+// only the first of a run of refusals is reported as such
+func TestRateLimiterRefusal(t *testing.T) {
+	clock := newFakeClock()
+	l := testRateLimiter(clock)
+	takeAll(l, "app", "sign")
+	clock.advance(500 * time.Millisecond)
+
+	allowed, wait, first := l.take("app", "sign", 1)
+	if allowed || first || !about(wait, 500*time.Millisecond) {
+		t.Errorf("take = %v, %v, %v; want refused for 500ms, not the first", allowed, wait, first)
+	}
+
+	// A batch over the limit waits for a full bucket
+	clock.advance(time.Minute)
+	l.take("app", "sign", 30)
+	if allowed, wait, _ := l.take("app", "sign", 100); allowed || !about(wait, 30*time.Second) {
+		t.Errorf("batch over the limit: %v, %v; want refused for 30s", allowed, wait)
+	}
+	clock.advance(30 * time.Second)
+	if allowed, _, _ := l.take("app", "sign", 100); !allowed {
+		t.Error("batch over the limit refused with a full bucket")
+	}
+	if allowed, _, first := l.take("app", "sign", 1); allowed || !first {
+		t.Errorf("first refusal after a request was let through: %v, first %v", allowed, first)
+	}
+
+	stats := l.stats()
+	if len(stats) != 1 || stats[0].Allowed != 62 || stats[0].Limited != 4 || stats[0].LastLimitedAt != clock.Now().Unix() {
+		t.Errorf("stats %+v, want 62 allowed and 4 limited", stats)
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// receiptKind is NIP-78 application-specific data
+	receiptKind = 30078
+
+	// receiptDTagPrefix starts the d tag of a receipt, followed by its date
+	receiptDTagPrefix = "noorsigner-receipt:"
+
+	// receiptCheckTick is how often the daemon looks at whether a receipt is due
+	receiptCheckTick = time.Minute
+
+	// receiptPublishTimeout bounds connecting and publishing to receipt_relay
+	receiptPublishTimeout = 15 * time.Second
+)
+
+// ReceiptSummary is the content of a daily receipt: what the signer did
+// since the receipt before, and where the audit log's hash chain stood
+type ReceiptSummary struct {
+	Date  string `json:"date"`
+	From  int64  `json:"from"`
+	Until int64  `json:"until"`
+	// Signed counts signed events per account and kind
+	Signed map[string]map[string]int `json:"signed"`
+	// Operations counts successful audit entries per action, Failed the
+	// failed ones
+	Operations map[string]int `json:"operations"`
+	Failed     int            `json:"failed"`
+	// AuditEntries is how many audit entries the receipt covers,
+	// AuditStart the hash of the oldest and AuditHead of the newest of them
+	AuditEntries int    `json:"audit_entries"`
+	AuditStart   string `json:"audit_start,omitempty"`
+	AuditHead    string `json:"audit_head,omitempty"`
+	// Anomalies flags what deserves a look (not checked by verify)
+	Anomalies []string `json:"anomalies,omitempty"`
+	// PrevReceipt is the event id of the receipt before
+	PrevReceipt string `json:"prev_receipt,omitempty"`
+}
+
+// storedReceipt is a receipt from the receipts directory
+type storedReceipt struct {
+	Event   NostrEvent
+	Summary ReceiptSummary
+}
+
+// parseReceiptTime parses receipt_time as minutes after midnight (-1 = off)
+func parseReceiptTime(value string) (int, error) {
+	if value == "" {
+		return -1, nil
+	}
+	at, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return -1, fmt.Errorf("invalid receipt_time %q (use HH:MM, e.g. 23:55)", value)
+	}
+	return at.Hour()*60 + at.Minute(), nil
+}
+
+// getReceiptsDir returns the directory daily receipts are stored in
+func getReceiptsDir() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storageDir, "receipts"), nil
+}
+
+// loadReceipts returns the stored receipts, oldest first
+func loadReceipts() ([]storedReceipt, error) {
+	dir, err := getReceiptsDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var receipts []storedReceipt
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read receipt: %v", err)
+		}
+		var receipt storedReceipt
+		if err := json.Unmarshal(data, &receipt.Event); err != nil {
+			return nil, fmt.Errorf("invalid receipt %s: %v", filepath.Base(file), err)
+		}
+		if err := json.Unmarshal([]byte(receipt.Event.Content), &receipt.Summary); err != nil {
+			return nil, fmt.Errorf("invalid receipt %s: %v", filepath.Base(file), err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, nil
+}
+
+// auditEntryIndex returns the position of the entry with hash, or -1
+func auditEntryIndex(entries []AuditEntry, hash string) int {
+	if hash == "" {
+		return -1
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Hash == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// receiptWindow returns the entries after the previous receipt's head.
+// If that head was rotated away, it goes by the previous receipt's time.
+func receiptWindow(entries []AuditEntry, prev *ReceiptSummary) []AuditEntry {
+	if prev == nil {
+		return entries
+	}
+	if index := auditEntryIndex(entries, prev.AuditHead); index >= 0 {
+		return entries[index+1:]
+	}
+	var window []AuditEntry
+	for _, entry := range entries {
+		if entry.Timestamp > prev.Until {
+			window = append(window, entry)
+		}
+	}
+	return window
+}
+
+// countReceipt fills in the counts of a receipt covering window
+func countReceipt(summary *ReceiptSummary, window []AuditEntry) {
+	summary.Signed = make(map[string]map[string]int)
+	summary.Operations = make(map[string]int)
+	summary.Failed = 0
+	summary.AuditEntries = len(window)
+	for _, entry := range window {
+		if !entry.Success {
+			summary.Failed++
+			continue
+		}
+		summary.Operations[entry.Action]++
+		if entry.Kind != nil && entry.EventID != "" && entry.Npub != "" {
+			if summary.Signed[entry.Npub] == nil {
+				summary.Signed[entry.Npub] = make(map[string]int)
+			}
+			summary.Signed[entry.Npub][fmt.Sprintf("%d", *entry.Kind)]++
+		}
+	}
+	if len(window) > 0 {
+		summary.AuditStart = window[0].Hash
+		summary.AuditHead = window[len(window)-1].Hash
+	}
+}
+
+// receiptAnomalies flags what in a receipt's window deserves a look
+func receiptAnomalies(summary ReceiptSummary, window []AuditEntry, corrupt []string, prev *storedReceipt) []string {
+	var anomalies []string
+	anomalies = append(anomalies, verifyAuditChain(window)...)
+	if len(corrupt) > 0 {
+		anomalies = append(anomalies, fmt.Sprintf("%d corrupt audit log line(s)", len(corrupt)))
+	}
+	if summary.Failed > 0 {
+		anomalies = append(anomalies, fmt.Sprintf("%d failed operation(s)", summary.Failed))
+	}
+	if expired := summary.Operations["approval_expired"]; expired > 0 {
+		anomalies = append(anomalies, fmt.Sprintf("%d request(s) not confirmed in time", expired))
+	}
+	if prev != nil && summary.From-prev.Summary.Until > 36*3600 {
+		anomalies = append(anomalies, fmt.Sprintf("no receipt since %s", prev.Summary.Date))
+	}
+	if summary.AuditHead == "" && len(window) > 0 {
+		anomalies = append(anomalies, "audit entries without hash chain")
+	}
+	return anomalies
+}
+
+// makeReceipt composes the receipt for the audit entries since the last
+// one, signs it with the active account, stores it in the receipts
+// directory and, with receipt_relay set, publishes it there. There is one
+// receipt per day.
+func (d *Daemon) makeReceipt(now time.Time) error {
+	if !d.config.auditEnabled() {
+		return fmt.Errorf("audit_log is off")
+	}
+	date := now.Format("2006-01-02")
+	dir, err := getReceiptsDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, date+".json")
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	receipts, err := loadReceipts()
+	if err != nil {
+		return err
+	}
+	entries, corrupt, err := readAuditLog()
+	if err != nil {
+		return err
+	}
+
+	summary := ReceiptSummary{Date: date, From: now.Add(-24 * time.Hour).Unix(), Until: now.Unix()}
+	var prev *storedReceipt
+	var prevSummary *ReceiptSummary
+	if len(receipts) > 0 {
+		prev = &receipts[len(receipts)-1]
+		prevSummary = &prev.Summary
+		summary.From = prev.Summary.Until
+		summary.PrevReceipt = prev.Event.ID
+		summary.AuditHead = prev.Summary.AuditHead
+	}
+	window := receiptWindow(entries, prevSummary)
+	if prev == nil && len(window) > 0 {
+		summary.From = window[0].Timestamp
+	}
+	countReceipt(&summary, window)
+	summary.Anomalies = receiptAnomalies(summary, window, corrupt, prev)
+
+	content, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	eventJSON, err := json.Marshal(map[string]interface{}{
+		"kind":       receiptKind,
+		"created_at": now.Unix(),
+		"tags": [][]string{
+			{"d", receiptDTagPrefix + date},
+			{"alt", "noorsigner daily key usage receipt"},
+		},
+		"content": string(content),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The receipt is the signer's own event, not subject to the signing policy
+	var signed *NostrEvent
+	d.withKey(func() { signed, err = d.signEvent(string(eventJSON)) })
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(signed, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("cannot create receipts directory: %v", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+
+	kind := receiptKind
+	if err := appendAuditEntry(AuditEntry{
+		Timestamp: now.Unix(),
+		Source:    "daemon",
+		PID:       os.Getpid(),
+		Action:    "receipt",
+		Npub:      d.activeNpub(),
+		Success:   true,
+		Kind:      &kind,
+		Items:     summary.AuditEntries,
+		EventID:   signed.ID,
+	}); err != nil {
+		logError("❌ Cannot write audit log: %v", err)
+	}
+	logInfo("🧾 Receipt %s: %d audit entries, %d anomalies (event %s)", date, summary.AuditEntries, len(summary.Anomalies), signed.ID)
+
+	if relay := d.config.ReceiptRelay; relay != "" {
+		if err := publishReceipt(relay, signed); err != nil {
+			logError("⚠️  Receipt %s not published to %s: %v", date, relay, err)
+		} else {
+			logInfo("🧾 Receipt %s published to %s", date, relay)
+		}
+	}
+	return nil
+}
+
+// activeNpub returns the active account
+func (d *Daemon) activeNpub() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.npub
+}
+
+// toNostrEvent converts a signed event for go-nostr
+func toNostrEvent(signed *NostrEvent) nostr.Event {
+	event := nostr.Event{
+		ID:        signed.ID,
+		PubKey:    signed.Pubkey,
+		CreatedAt: nostr.Timestamp(signed.CreatedAt),
+		Kind:      signed.Kind,
+		Content:   signed.Content,
+		Sig:       signed.Sig,
+	}
+	for _, tag := range signed.Tags {
+		event.Tags = append(event.Tags, nostr.Tag(tag))
+	}
+	return event
+}
+
+// publishReceipt sends a receipt to relayURL
+func publishReceipt(relayURL string, signed *NostrEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), receiptPublishTimeout)
+	defer cancel()
+	relay, err := nostr.RelayConnect(ctx, relayURL)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+	return relay.Publish(ctx, toNostrEvent(signed))
+}
+
+// receiptLoop makes the daily receipt once receipt_time has passed. It
+// checks the wall clock every minute, so a receipt missed while the
+// machine slept is made on waking. A day is tried once; a locked daemon
+// leaves it to the next day's receipt, which then covers both.
+func (d *Daemon) receiptLoop(at int) {
+	ticker := time.NewTicker(receiptCheckTick)
+	defer ticker.Stop()
+
+	tried := ""
+	for range ticker.C {
+		now := time.Now()
+		date := now.Format("2006-01-02")
+		if date == tried || now.Hour()*60+now.Minute() < at {
+			continue
+		}
+		tried = date
+		if err := d.makeReceipt(now); err != nil {
+			logError("⚠️  No receipt for %s: %v", date, err)
+		}
+	}
+}
+
+// verifyReceipt checks one receipt against the audit log and the receipt
+// before it and returns what doesn't hold, and what could not be checked
+func verifyReceipt(receipt storedReceipt, prev *storedReceipt, entries []AuditEntry) (problems, unchecked []string) {
+	event := receipt.Event
+	eventJSON, _ := json.Marshal(event)
+	hash, err := createEventHash(string(eventJSON))
+	switch {
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("event does not hash: %v", err))
+	case encodeHex(hash) != event.ID:
+		problems = append(problems, "event id does not match its content")
+	default:
+		if err := verifyConformanceSignature(string(eventJSON), event.Pubkey, event.Sig); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	summary := receipt.Summary
+	if prev != nil && summary.PrevReceipt != prev.Event.ID {
+		problems = append(problems, fmt.Sprintf("does not follow the receipt of %s", prev.Summary.Date))
+	}
+	if summary.AuditEntries == 0 {
+		return problems, unchecked
+	}
+
+	start := auditEntryIndex(entries, summary.AuditStart)
+	head := auditEntryIndex(entries, summary.AuditHead)
+	switch {
+	case summary.AuditStart == "" || summary.AuditHead == "":
+		unchecked = append(unchecked, "it covers audit entries from before hash chaining")
+		return problems, unchecked
+	case head < 0 && len(entries) > 0 && entries[0].Timestamp > summary.Until:
+		unchecked = append(unchecked, "its audit entries were rotated away")
+		return problems, unchecked
+	case head < 0:
+		problems = append(problems, "the audit log no longer holds the entry the receipt ends with - entries were altered or removed")
+		return problems, unchecked
+	case start < 0 && len(entries) > 0 && entries[0].Timestamp >= summary.From:
+		unchecked = append(unchecked, "counts not checked: its first audit entries were rotated away")
+		return problems, unchecked
+	case start < 0 || start > head:
+		problems = append(problems, "the audit log no longer holds the entry the receipt starts with - entries were altered or removed")
+		return problems, unchecked
+	}
+	if prev != nil && prev.Summary.AuditHead != "" && entries[start].Prev != prev.Summary.AuditHead {
+		problems = append(problems, fmt.Sprintf("its audit entries do not continue where the receipt of %s ended", prev.Summary.Date))
+	}
+
+	var recount ReceiptSummary
+	countReceipt(&recount, entries[start:head+1])
+	recorded, _ := json.Marshal([]interface{}{summary.Signed, summary.Operations, summary.Failed, summary.AuditEntries})
+	actual, _ := json.Marshal([]interface{}{recount.Signed, recount.Operations, recount.Failed, recount.AuditEntries})
+	if string(recorded) != string(actual) {
+		problems = append(problems, "the audit log no longer matches the receipt's counts")
+	}
+	return problems, unchecked
+}
+
+// receiptsCmd lists the daily receipts or verifies them against the audit log
+func receiptsCmd(args []string) error {
+	usage := "Usage: noorsigner receipts [list|verify]"
+	if len(args) > 1 {
+		return commandFailed(1, "%s", usage)
+	}
+
+	receipts, err := loadReceipts()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	switch {
+	case len(args) == 0 || args[0] == "list":
+		if len(receipts) == 0 {
+			fmt.Println("No receipts. Turn them on with: noorsigner config set receipt_time 23:55 (needs audit_log)")
+			return nil
+		}
+		for _, receipt := range receipts {
+			summary := receipt.Summary
+			signed := 0
+			for _, kinds := range summary.Signed {
+				for _, count := range kinds {
+					signed += count
+				}
+			}
+			signer := receipt.Event.Pubkey
+			if npub, err := pubkeyToNpub(signer); err == nil {
+				signer = displayNpub(npub)
+			}
+			fmt.Printf("🧾 %s  %d signed, %d audit entries, signed by %s\n", summary.Date, signed, summary.AuditEntries, signer)
+			for _, anomaly := range summary.Anomalies {
+				fmt.Printf("     ⚠️  %s\n", anomaly)
+			}
+		}
+
+	case args[0] == "verify":
+		entries, _, err := readAuditLog()
+		if err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+		failed := false
+		for _, problem := range verifyAuditChain(entries) {
+			fmt.Printf("❌ audit.log: %s\n", problem)
+			failed = true
+		}
+		for i, receipt := range receipts {
+			var prev *storedReceipt
+			if i > 0 {
+				prev = &receipts[i-1]
+			}
+			problems, unchecked := verifyReceipt(receipt, prev, entries)
+			switch {
+			case len(problems) > 0:
+				failed = true
+				for _, problem := range problems {
+					fmt.Printf("❌ %s: %s\n", receipt.Summary.Date, problem)
+				}
+			case len(unchecked) > 0:
+				fmt.Printf("⚪ %s: signature ok, %s\n", receipt.Summary.Date, strings.Join(unchecked, "; "))
+			default:
+				fmt.Printf("✅ %s: ok (%d audit entries)\n", receipt.Summary.Date, receipt.Summary.AuditEntries)
+			}
+		}
+		if failed {
+			return commandFailed(1, "❌ Receipts and audit log do not agree")
+		}
+		fmt.Printf("✅ %d receipt(s) verified\n", len(receipts))
+
+	default:
+		return commandFailed(1, "%s", usage)
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// recoverCmd is the last-resort tool for an account the daemon can't use.
+// It works offline on the files alone, reports each step and writes
+// nothing without a confirmation.
+func recoverCmd(args []string) error {
+	if len(args) != 1 {
+		return commandFailed(1, "Usage: noorsigner recover <npub>")
+	}
+	npub := canonicalNpub(args[0])
+	if _, err := npubToPubkey(npub); err != nil {
+		return commandFailed(1, "❌ Invalid npub: %v", err)
+	}
+
+	// A running daemon may hold the key and rewrite the files under us
+	if running, pid, err := runningDaemon(); err == nil && running {
+		return commandFailed(1, "❌ The daemon is running (pid %d). Stop it first: noorsigner drain", pid)
+	}
+	if isDaemonRunning() {
+		return commandFailed(1, "❌ A daemon is answering on the socket. Stop it first: noorsigner drain")
+	}
+
+	fmt.Printf("🛟 Recovering %s\n", npub)
+	fmt.Println("   Nothing is written unless you confirm it.")
+	fmt.Println()
+
+	// Step 1: the account's records
+	fmt.Println("1. Account")
+	store, err := accountStore()
+	if err != nil {
+		return recoverFail("cannot open the account store: %v", err)
+	}
+	if names, err := store.RecordNames(npub); err != nil || len(names) == 0 {
+		return recoverFail("%s: no such account (%s backend)", store.Location(npub), store.Backend())
+	}
+	fmt.Printf("   ✅ %s\n", store.Location(npub))
+	for _, name := range []string{recordKey, recordKeyChecksum, recordMetadata, recordHealth, recordTrustSession} {
+		if data, modified, err := store.ReadRecord(npub, name); err == nil {
+			fmt.Printf("      %-15s %d bytes, modified %s\n", name, len(data), modified.Format("2006-01-02 15:04:05"))
+		} else {
+			fmt.Printf("      %-15s missing\n", name)
+		}
+	}
+
+	// Step 2: the key file parses
+	fmt.Println("2. Key file")
+	encKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		return recoverFail("keys.encrypted does not parse: %v", err)
+	}
+	fmt.Printf("   ✅ Parses: format version %d (%s, %s), %d byte salt, %d byte ciphertext\n",
+		encKey.Version, encKey.KDF, encKey.Cipher, len(encKey.Salt), len(encKey.EncryptedNsec))
+	if encKey.Version < keyFormatVersion {
+		fmt.Printf("   ⚠️  Legacy format - re-saving it (step 5) upgrades it to version %d\n", keyFormatVersion)
+	}
+	if len(encKey.Salt) != saltLen {
+		fmt.Printf("   ⚠️  Salt should be %d bytes\n", saltLen)
+	}
+	checksumOK := recoverReportChecksum(npub)
+
+	// Step 3: decryption, one stage at a time
+	fmt.Println("3. Decryption")
+	if err := refuseIfFrozen(); err != nil {
+		return err
+	}
+	if err := refuseIfLockedOut(npub); err != nil {
+		return err
+	}
+	password, err := readPassword("   Enter password: ")
+	if err != nil {
+		return recoverFail("cannot read password: %v", err)
+	}
+	nsec, err := decryptNsec(encKey, password)
+	if err != nil && !errors.Is(err, errKeyDecryptFailed) {
+		return recoverFail("key derivation (scrypt) failed: %v", err)
+	}
+	fmt.Println("   ✅ Key derivation (scrypt)")
+	// A wrong password fails authentication, or with the legacy XOR cipher
+	// decrypts to garbage
+	if err != nil || !looksLikeNsec(nsec) {
+		problem := "decrypted data is not an nsec"
+		if err != nil {
+			problem = "the key does not decrypt"
+		}
+		// Counts as a wrong password, or recover would sidestep the lockout
+		if lockout := recordPasswordFailure(npub); lockout != nil {
+			return recoverFail("%s - the password is wrong (or the ciphertext is damaged); %v", problem, lockout)
+		}
+		return recoverFail("%s - the password is wrong (or the ciphertext is damaged)", problem)
+	}
+	fmt.Println("   ✅ Decrypted data looks like an nsec")
+	privateKey, err := nsecToPrivateKey(nsec)
+	if err != nil {
+		return recoverFail("decrypted nsec is invalid (%v) - the password is right but keys.encrypted is damaged", err)
+	}
+	defer privateKey.Zero()
+	fmt.Println("   ✅ nsec decodes to a valid private key")
+
+	// Step 4: npub consistency
+	fmt.Println("4. Account consistency")
+	derived, err := privateKeyToNpub(privateKey)
+	if err != nil {
+		return recoverFail("cannot derive the npub: %v", err)
+	}
+	if derived != npub {
+		return recoverFail("the key belongs to %s, not %s - the directory is misnamed; move it by hand", derived, npub)
+	}
+	fmt.Println("   ✅ The key matches the npub")
+	clearPasswordFailures(npub)
+
+	// Step 5: repairs, each confirmed
+	fmt.Println("5. Repairs")
+	if err := recoverTrustSession(npub); err != nil {
+		return err
+	}
+
+	if recoverConfirm("Re-save keys.encrypted in the current format with a new salt (the old file is kept as keys.encrypted.bak)?") {
+		if err := backupRecord(store, npub, recordKey); err != nil {
+			return recoverFail("cannot back up keys.encrypted: %v", err)
+		}
+		newKey, err := encryptNsec(nsec, password)
+		if err != nil {
+			return recoverFail("cannot encrypt: %v", err)
+		}
+		if err := saveAccountEncryptedKey(npub, newKey); err != nil {
+			return recoverFail("cannot write keys.encrypted: %v", err)
+		}
+		auditCLI("recover_resave_key", npub, nil, nil)
+		fmt.Println("   🔧 keys.encrypted re-saved, checksum recorded")
+		checksumOK = true
+	}
+
+	if !checksumOK && recoverConfirm("Rebuild metadata (record the key checksum, reset the health state)?") {
+		if err := saveAccountKeyChecksum(npub); err != nil {
+			return recoverFail("cannot write keys.sha256: %v", err)
+		}
+		store.DeleteRecord(npub, recordHealth)
+		auditCLI("recover_rebuild_metadata", npub, nil, nil)
+		fmt.Println("   🔧 Metadata rebuilt")
+	}
+
+	if recoverConfirm("Export an ncryptsec (NIP-49) backup?") {
+		if err := recoverExportNcryptsec(privateKey.Serialize(), password); err != nil {
+			return err
+		}
+		auditCLI("recover_export_ncryptsec", npub, nil, nil)
+	}
+
+	fmt.Println()
+	fmt.Println("✅ Recovery finished. Start the daemon with: noorsigner daemon")
+	return nil
+}
+
+// looksLikeNsec reports whether s has the shape of a stored key: bech32
+// nsec1 characters or 64 hex digits (add-account stores the key as entered)
+func looksLikeNsec(s string) bool {
+	if strings.HasPrefix(s, "nsec1") {
+		return strings.Trim(s[5:], "023456789acdefghjklmnpqrstuvwxyz") == ""
+	}
+	return len(s) == 64 && strings.Trim(strings.ToLower(s), "0123456789abcdef") == ""
+}
+
+// recoverReportChecksum compares keys.encrypted with keys.sha256 and
+// reports whether they agree
+func recoverReportChecksum(npub string) bool {
+	expected, err := loadAccountKeyChecksum(npub)
+	if err != nil {
+		fmt.Printf("   ⚠️  %v\n", err)
+		return false
+	}
+	if expected == "" {
+		fmt.Println("   ⚠️  No key checksum recorded")
+		return false
+	}
+	actual, err := checksumAccountKeyFile(npub)
+	if err != nil || actual != expected {
+		fmt.Println("   ⚠️  keys.encrypted does not match its recorded checksum")
+		return false
+	}
+	fmt.Println("   ✅ Matches its recorded checksum")
+	return true
+}
+
+// recoverTrustSession inspects the trust session and offers to clear it if
+// it is broken, expired, from the future or for another key
+func recoverTrustSession(npub string) error {
+	if !hasAccountTrustSession(npub) {
+		fmt.Println("   ✅ No trust session")
+		return nil
+	}
+
+	problem := ""
+	session, err := loadAccountTrustSession(npub)
+	switch {
+	case err != nil:
+		problem = fmt.Sprintf("does not parse: %v", err)
+	case session.CreatedAt.After(time.Now().Add(time.Minute)):
+		problem = fmt.Sprintf("was created in the future (%s) - check the system clock", session.CreatedAt.Format(time.RFC3339))
+	case !isTrustSessionValid(session):
+		problem = fmt.Sprintf("expired at %s", session.ExpiresAt.Format(time.RFC3339))
+	default:
+		if sessionNsec, err := decryptTrustSessionNsec(session); err != nil {
+			problem = fmt.Sprintf("does not decrypt: %v", err)
+		} else if key, err := nsecToPrivateKey(sessionNsec); err != nil {
+			problem = "holds an invalid key"
+		} else {
+			if !keyMatchesNpub(key, npub) {
+				problem = "holds the key of another account"
+			}
+			key.Zero()
+		}
+	}
+
+	if problem == "" {
+		fmt.Printf("   ✅ Trust session valid until %s\n", session.ExpiresAt.Format(time.RFC3339))
+		return nil
+	}
+	fmt.Printf("   ⚠️  Trust session %s\n", problem)
+	if recoverConfirm("Clear the trust session?") {
+		if err := clearAccountTrustSession(npub); err != nil {
+			return recoverFail("cannot clear the trust session: %v", err)
+		}
+		auditCLI("recover_clear_trust_session", npub, nil, nil)
+		fmt.Println("   🔧 Trust session cleared")
+	}
+	return nil
+}
+
+// recoverExportNcryptsec prints an ncryptsec backup of the key
+func recoverExportNcryptsec(secretKey []byte, accountPassword string) error {
+	password, err := readPassword("   Backup password (Enter = account password): ")
+	if err != nil {
+		return recoverFail("cannot read password: %v", err)
+	}
+	if password == "" {
+		password = accountPassword
+	} else {
+		confirm, err := readPassword("   Repeat backup password: ")
+		if err != nil {
+			return recoverFail("cannot read password: %v", err)
+		}
+		if confirm != password {
+			return recoverFail("passwords don't match - nothing exported")
+		}
+	}
+
+	fmt.Println("   Encrypting (takes a few seconds)...")
+	ncryptsec, err := encryptNcryptsec(secretKey, password)
+	if err != nil {
+		return recoverFail("cannot export: %v", err)
+	}
+	fmt.Println("   🔑 Backup (store it safely; it is only as strong as its password):")
+	fmt.Printf("   %s\n", ncryptsec)
+	return nil
+}
+
+// recoverConfirm asks a yes/no question; anything but "y" is no
+func recoverConfirm(question string) bool {
+	answer, err := readInput(fmt.Sprintf("   %s [y/N]: ", question))
+	return err == nil && strings.ToLower(answer) == "y"
+}
+
+// recoverFail returns the error of the step that failed
+func recoverFail(format string, args ...interface{}) error {
+	return commandFailed(1, "   ❌ %s", fmt.Sprintf(format, args...))
+}
+
+// backupRecord copies a record to <name>.bak, refusing to overwrite a backup
+func backupRecord(store Store, npub, name string) error {
+	content, _, err := store.ReadRecord(npub, name)
+	if err != nil {
+		return err
+	}
+	if _, _, err := store.ReadRecord(npub, name+".bak"); err == nil {
+		return fmt.Errorf("%s.bak already exists - move it away first", name)
+	}
+	return store.WriteRecords(npub, storeRecord{Name: name + ".bak", Data: content})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// redactPanicEnv makes a found secret panic instead of being redacted. Set
+// it while developing: a secret that reaches an emitter unredacted is a bug
+// at the call site, and a panic points straight at it.
+const redactPanicEnv = "NOORSIGNER_REDACT_PANIC"
+
+// nsecPattern matches bech32 private keys that might end up in a message.
+// Bech32 is case-insensitive, so NSEC1... is a key just the same.
+var nsecPattern = regexp.MustCompile(`(?i)nsec1[02-9ac-hj-np-z]*`)
+
+// hexScalarPattern finds runs of 64 or more hex digits. Most are event
+// ids, pubkeys and signatures; only runs holding a loaded private key get
+// redacted, which includes a key inside a longer run, e.g. behind the 02
+// of a compressed pubkey.
+var hexScalarPattern = regexp.MustCompile(`(?i)[0-9a-f]{64,}`)
+
+// maxScannedHexRun bounds the runs searched for a key at every offset.
+// Longer runs (hex content of an echoed event) are only checked at both
+// ends.
+const maxScannedHexRun = 512
+
+// secretFieldPattern matches the JSON fields a request carries passwords
+// and client tokens in, e.g. a request echoed into an error. The value is
+// the second submatch.
+var secretFieldPattern = regexp.MustCompile(`(?i)("(?:password|passphrase|token)"\s*:\s*)"((?:[^"\\]|\\.)*)"`)
+
+// secretRegistry remembers the private keys the daemon holds, by the
+// SHA-256 of their hex form, so no second copy of a key is kept in memory
+type secretRegistry struct {
+	mu     sync.RWMutex
+	hashes map[[sha256.Size]byte]int
+}
+
+// loadedKeys are the private keys currently in daemon memory
+var loadedKeys = &secretRegistry{hashes: make(map[[sha256.Size]byte]int)}
+
+// scalarHash returns the registry hash of a private key
+func scalarHash(key *btcec.PrivateKey) [sha256.Size]byte {
+	keyBytes := key.Serialize()
+	defer zeroBytes(keyBytes)
+	hexKey := make([]byte, hex.EncodedLen(len(keyBytes)))
+	defer zeroBytes(hexKey)
+	hex.Encode(hexKey, keyBytes)
+	return sha256.Sum256(hexKey)
+}
+
+// zeroBytes overwrites b
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// add registers a key (nil is ignored)
+func (r *secretRegistry) add(key *btcec.PrivateKey) {
+	if key == nil {
+		return
+	}
+	hash := scalarHash(key)
+	r.mu.Lock()
+	r.hashes[hash]++
+	r.mu.Unlock()
+}
+
+// remove forgets a key once as often as it was added (nil is ignored)
+func (r *secretRegistry) remove(key *btcec.PrivateKey) {
+	if key == nil {
+		return
+	}
+	hash := scalarHash(key)
+	r.mu.Lock()
+	if r.hashes[hash]--; r.hashes[hash] <= 0 {
+		delete(r.hashes, hash)
+	}
+	r.mu.Unlock()
+}
+
+// containsIn reports whether a run of hex digits holds a registered key
+func (r *secretRegistry) containsIn(run string) bool {
+	const keyLen = 2 * btcec.PrivKeyBytesLen
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.hashes) == 0 || len(run) < keyLen {
+		return false
+	}
+
+	run = strings.ToLower(run)
+	offsets := []int{0, len(run) - keyLen}
+	if len(run) <= maxScannedHexRun {
+		offsets = offsets[:0]
+		for i := 0; i+keyLen <= len(run); i++ {
+			offsets = append(offsets, i)
+		}
+	}
+	for _, i := range offsets {
+		if r.hashes[sha256.Sum256([]byte(run[i:i+keyLen]))] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// redactionMarker replaces a secret. The hash prefix lets two places that
+// held the same secret be matched up without revealing it.
+func redactionMarker(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return "[REDACTED:" + hex.EncodeToString(sum[:4]) + "]"
+}
+
+// secret tags a value at the call site: however it is formatted or
+// marshaled, only its redaction marker comes out
+type secret string
+
+// String returns the redaction marker (used by %v, %s and %q)
+func (s secret) String() string {
+	return redactionMarker(string(s))
+}
+
+// GoString returns the redaction marker for %#v
+func (s secret) GoString() string {
+	return s.String()
+}
+
+// MarshalJSON keeps secrets out of JSON payloads too
+func (s secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.String() + `"`), nil
+}
+
+// redactSecrets is the last step before text leaves the process through
+// emitter (daemon.log, audit.log, error responses, stream events, hooks,
+// notifications, approval_command): nsecs, loaded private keys and the
+// values of password and token fields are replaced by their redaction marker
+func redactSecrets(emitter, text string) string {
+	found := false
+	redact := func(match string) string {
+		found = true
+		return redactionMarker(match)
+	}
+
+	text = secretFieldPattern.ReplaceAllStringFunc(text, func(match string) string {
+		field := secretFieldPattern.FindStringSubmatch(match)
+		if field[2] == "" {
+			return match
+		}
+		return field[1] + `"` + redact(field[2]) + `"`
+	})
+	text = nsecPattern.ReplaceAllStringFunc(text, func(match string) string {
+		if strings.EqualFold(match, "nsec1") {
+			// The bare prefix, e.g. in "expected nsec1..."
+			return match
+		}
+		return redact(match)
+	})
+	text = hexScalarPattern.ReplaceAllStringFunc(text, func(match string) string {
+		if !loadedKeys.containsIn(match) {
+			return match
+		}
+		return redact(match)
+	})
+
+	if found && os.Getenv(redactPanicEnv) != "" {
+		panic(fmt.Sprintf("unredacted secret reached %s", emitter))
+	}
+	return text
+}
+
+// redactStreamEvent redacts every text field of an event before it goes to
+// subscribers, hooks and notifications
+func redactStreamEvent(event StreamEvent) StreamEvent {
+	event.Npub = redactSecrets("stream event", event.Npub)
+	event.Pubkey = redactSecrets("stream event", event.Pubkey)
+	if len(event.Data) > 0 {
+		data := make(map[string]string, len(event.Data))
+		for key, value := range event.Data {
+			data[key] = redactSecrets("stream event", value)
+		}
+		event.Data = data
+	}
+	if event.Activity != nil {
+		activity := *event.Activity
+		activity.Npub = redactSecrets("stream event", activity.Npub)
+		activity.Error = redactSecrets("stream event", activity.Error)
+		event.Activity = &activity
+	}
+	return event
+}
+
+// redactedError returns err's message for an error response
+func redactedError(err error) string {
+	return redactSecrets("error response", err.Error())
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// loadTestKey registers a new private key as loaded for the rest of the
+// test and returns it with its hex form
+func loadTestKey(t *testing.T) (*btcec.PrivateKey, string) {
+	t.Helper()
+	privateKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedKeys.add(privateKey)
+	t.Cleanup(func() { loadedKeys.remove(privateKey) })
+	return privateKey, hex.EncodeToString(privateKey.Serialize())
+}
+
+func TestRedactSecrets(t *testing.T) {
+	t.Setenv(redactPanicEnv, "")
+	privateKey, hexKey := loadTestKey(t)
+	nsec, err := encodeBech32Key("nsec", privateKey.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHex := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name   string
+		text   string
+		secret string // Must not survive; "" if nothing is redacted
+		keep   string // Must survive
+	}{
+		{"nsec", "bad key " + nsec + " here", nsec, "bad key "},
+		{"uppercase nsec", "key " + strings.ToUpper(nsec), strings.ToUpper(nsec), "key "},
+		{"mixed case nsec", "key Nsec1" + nsec[5:], "Nsec1" + nsec[5:], "key "},
+		{"bare nsec prefix", "expected nsec1...", "", "expected nsec1..."},
+		{"bare uppercase prefix", "expected NSEC1...", "", "expected NSEC1..."},
+		{"loaded hex key", "key=" + hexKey, hexKey, "key="},
+		{"uppercase loaded hex key", "key=" + strings.ToUpper(hexKey), strings.ToUpper(hexKey), "key="},
+		{"loaded hex key in a longer run", "pubkey '02" + hexKey + "'", "02" + hexKey, "pubkey '"},
+		{"other hex", "event " + otherHex, "", otherHex},
+		{"other long hex", "sig " + otherHex + otherHex, "", otherHex + otherHex},
+		{"password field", `{"method":"unlock_account","password":"hunter2 \"quoted\""}`, `hunter2 \"quoted\"`, `"method":"unlock_account"`},
+		{"password field spacing", `{"Password" : "CorrectHorse9Battery!"}`, "CorrectHorse9Battery!", `"Password" : "[REDACTED:`},
+		{"passphrase field", `{"passphrase":"travel safe"}`, "travel safe", `"passphrase":"[REDACTED:`},
+		{"token field", `{"token":"c2VjcmV0"}`, "c2VjcmV0", `"token":"[REDACTED:`},
+		{"empty password", `{"password":""}`, "", `{"password":""}`},
+		{"confirmation token", `{"confirmation_token":"4f1c"}`, "", `"confirmation_token":"4f1c"`},
+		{"plain text", "invalid password for npub1abc", "", "invalid password for npub1abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactSecrets("test", tt.text)
+			if tt.secret != "" {
+				if strings.Contains(got, tt.secret) {
+					t.Errorf("redactSecrets(%q) = %q, secret left in", tt.text, got)
+				}
+				if !strings.Contains(got, redactionMarker(tt.secret)) {
+					t.Errorf("redactSecrets(%q) = %q, want marker %s", tt.text, got, redactionMarker(tt.secret))
+				}
+			} else if got != tt.text {
+				t.Errorf("redactSecrets(%q) = %q, want it unchanged", tt.text, got)
+			}
+			if !strings.Contains(got, tt.keep) {
+				t.Errorf("redactSecrets(%q) = %q, lost %q", tt.text, got, tt.keep)
+			}
+		})
+	}
+}
+
+func TestRedactSecretsPanicMode(t *testing.T) {
+	_, hexKey := loadTestKey(t)
+	t.Setenv(redactPanicEnv, "1")
+
+	tests := []struct {
+		name   string
+		text   string
+		panics bool
+	}{
+		{"nsec", "nsec1" + strings.Repeat("q", 58), true},
+		{"uppercase nsec", "NSEC1" + strings.Repeat("Q", 58), true},
+		{"loaded hex key", hexKey, true},
+		{"password field", `{"password":"hunter2"}`, true},
+		{"clean text", "signed event " + strings.Repeat("ab", 32), false},
+		{"bare prefix", "expected nsec1...", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if (r != nil) != tt.panics {
+					t.Errorf("panic = %v, want panic %v", r, tt.panics)
+				}
+				if r != nil && !strings.Contains(fmt.Sprint(r), "test emitter") {
+					t.Errorf("panic %q doesn't name the emitter", r)
+				}
+			}()
+			redactSecrets("test emitter", tt.text)
+		})
+	}
+}
+
+func TestSecretFormatting(t *testing.T) {
+	password := secret("CorrectHorse9Battery!")
+	marker := redactionMarker("CorrectHorse9Battery!")
+	for _, format := range []string{"%v", "%s", "%q", "%#v", "%+v"} {
+		got := fmt.Sprintf(format, password)
+		if strings.Contains(got, "CorrectHorse") || !strings.Contains(got, marker) {
+			t.Errorf("Sprintf(%s) = %q, want only the marker", format, got)
+		}
+	}
+	data, err := json.Marshal(struct {
+		Password secret `json:"password"`
+	}{password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "CorrectHorse") || !strings.Contains(string(data), marker) {
+		t.Errorf("json.Marshal = %s, want only the marker", data)
+	}
+}
+
+func TestRedactStreamEvent(t *testing.T) {
+	t.Setenv(redactPanicEnv, "")
+	nsec := "nsec1" + strings.Repeat("q", 58)
+	event := redactStreamEvent(StreamEvent{
+		Type:     "activity",
+		Data:     map[string]string{"error": "bad " + nsec},
+		Activity: &ActivityEntry{Error: `{"password":"hunter2"}`},
+	})
+	if strings.Contains(event.Data["error"], nsec) {
+		t.Errorf("Data = %v, nsec left in", event.Data)
+	}
+	if strings.Contains(event.Activity.Error, "hunter2") {
+		t.Errorf("Activity.Error = %q, password left in", event.Activity.Error)
+	}
+}
+
+// A secret pushed through every way text leaves the daemon - daemon.log,
+// error responses, audit.log, the stream, notifications and hooks - comes
+// out redacted
+func TestSecretsNeverEmitted(t *testing.T) {
+	testHome(t)
+	t.Setenv(redactPanicEnv, "")
+	appConfig.AuditLog = true
+	appConfig.Notifications = &NotificationConfig{Enabled: true}
+	logged := captureDaemonLog(t, "debug")
+	npub := addTestAccount(t, "")
+	d := testDaemon(t, npub)
+
+	keyBytes := d.privateKey.Serialize()
+	hexKey := hex.EncodeToString(keyBytes)
+	nsec, err := encodeBech32Key("nsec", keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwordJSON := fmt.Sprintf(`{"password":%q}`, testPassword)
+	secrets := map[string]string{"nsec": nsec, "hex key": hexKey, "password": testPassword}
+
+	notified := &logCapture{}
+	d.notifier = newNotifier(appConfig, time.Now, func(time.Duration, func()) {}, func(text string) {
+		fmt.Fprintln(notified, text)
+	})
+	hookOutput := filepath.Join(t.TempDir(), "hook.env")
+	if runtime.GOOS != "windows" {
+		configFile, err := getConfigFilePath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(configFile, []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		hook := filepath.Join(t.TempDir(), "hook.sh")
+		if err := os.WriteFile(hook, []byte("#!/bin/sh\nenv > "+hookOutput+".tmp && mv "+hookOutput+".tmp "+hookOutput+"\n"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		appConfig.Hooks = map[string]string{"locked": hook}
+	}
+	events := d.addSubscriber()
+	defer d.removeSubscriber(events)
+	serveTestDaemon(t, d)
+
+	// daemon.log
+	logError("cannot decode %s", nsec)
+	logDebug("key %s in %s", hexKey, passwordJSON)
+
+	// Error responses, which the daemon also audits
+	var responses strings.Builder
+	auditEntry, _ := json.Marshal(AuditEntry{Action: "import", Npub: nsec, Error: "bad key " + hexKey + " " + passwordJSON})
+	for _, req := range []SignRequest{
+		{Method: "add_account", Nsec: nsec + "q", Password: testPassword},
+		{Method: "switch_account", Npub: nsec, Password: testPassword},
+		{Method: "sign_event", Npub: nsec, EventJSON: `{"kind":1,"content":"","tags":[]}`},
+		{Method: "sign_event", EventJSON: "not an event " + nsec},
+		{Method: "nip44_encrypt", RecipientPubkey: hexKey + nsec, Plaintext: "hi"},
+		{Method: "append_audit", Audit: auditEntry},
+	} {
+		var response map[string]any
+		if err := dialTestDaemon(t).request(t, req, &response); err != nil {
+			t.Fatal(err)
+		}
+		line, _ := json.Marshal(response)
+		fmt.Fprintf(&responses, "%s\n", line)
+	}
+
+	// The stream, notifications and hooks
+	d.emit(StreamEvent{Type: "locked", Npub: nsec, Pubkey: hexKey, Data: map[string]string{"reason": passwordJSON}})
+	d.emit(StreamEvent{Type: "activity", Activity: &ActivityEntry{Method: "sign_event", Npub: nsec, Success: true, Error: nsec}})
+	var streamed strings.Builder
+	for len(events) > 0 {
+		line, _ := json.Marshal(<-events)
+		fmt.Fprintf(&streamed, "%s\n", line)
+	}
+
+	outputs := map[string]func() string{
+		"echoed log":      logged.String,
+		"error responses": responses.String,
+		"stream":          streamed.String,
+		"notifications":   notified.String,
+		"daemon.log": func() string {
+			path, _ := getLogFilePath()
+			data, _ := os.ReadFile(path)
+			return string(data)
+		},
+	}
+	auditPath, err := getAuditLogPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs["audit.log"] = func() string {
+		// Audit entries are written after the response
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			data, _ := os.ReadFile(auditPath)
+			if strings.Contains(string(data), `"action":"import"`) || time.Now().After(deadline) {
+				return string(data)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		outputs["hook"] = func() string {
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				data, err := os.ReadFile(hookOutput)
+				if err == nil || time.Now().After(deadline) {
+					return string(data)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+
+	for emitter, read := range outputs {
+		output := read()
+		if !strings.Contains(output, "[REDACTED") {
+			t.Errorf("%s shows nothing redacted - did the secret reach it?\n%s", emitter, output)
+		}
+		for name, value := range secrets {
+			if strings.Contains(strings.ToLower(output), strings.ToLower(value)) {
+				t.Errorf("%s contains the %s:\n%s", emitter, name, output)
+			}
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/77elements/noorsigner/pkg/client"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// relayListVersion is the format of relays.json
+	relayListVersion = 1
+
+	// maxAccountRelays caps an account's relay list
+	maxAccountRelays = 64
+
+	// relayListKind is the NIP-65 relay list event kind
+	relayListKind = 10002
+
+	// relayListPublishTimeout bounds publishing the relay list, all relays
+	// together
+	relayListPublishTimeout = 10 * time.Second
+)
+
+// RelayPolicy says what an account reads from and writes to a relay
+type RelayPolicy = client.RelayPolicy
+
+// RelaysResponse answers get_relays and set_relays
+type RelaysResponse = client.RelaysResponse
+
+// errInvalidRelay is returned for a relay list entry that can't be stored
+var errInvalidRelay = errors.New("invalid relay")
+
+// accountRelayList is an account's relays.json. Relays maps a relay URL to
+// its read and write markers, so the file converts to and from a NIP-65
+// relay list (kind 10002) without losing anything.
+type accountRelayList struct {
+	Version   int                    `json:"version"`
+	Relays    map[string]RelayPolicy `json:"relays"`
+	UpdatedAt int64                  `json:"updated_at"`
+}
+
+// normalizeRelayURL checks a relay URL and returns it in the form it is
+// stored in: wss:// only, lowercase host, no default port and no trailing
+// slash, so the same relay isn't listed twice
+func normalizeRelayURL(relay string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(relay))
+	if err != nil || !strings.EqualFold(parsed.Scheme, "wss") || parsed.Host == "" {
+		return "", fmt.Errorf("%w %q: expected a wss:// URL", errInvalidRelay, relay)
+	}
+	if parsed.User != nil || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", fmt.Errorf("%w %q: no credentials, query or fragment", errInvalidRelay, relay)
+	}
+	host := strings.ToLower(parsed.Host)
+	host = strings.TrimSuffix(host, ":443")
+	return "wss://" + host + strings.TrimRight(parsed.EscapedPath(), "/"), nil
+}
+
+// normalizeRelayList checks a relay list and normalizes its URLs. Entries
+// that normalize to the same URL are merged.
+func normalizeRelayList(relays map[string]RelayPolicy) (map[string]RelayPolicy, error) {
+	normalized := make(map[string]RelayPolicy, len(relays))
+	for relay, policy := range relays {
+		if !policy.Read && !policy.Write {
+			return nil, fmt.Errorf("%w %q: must be read, write or both", errInvalidRelay, relay)
+		}
+		relayURL, err := normalizeRelayURL(relay)
+		if err != nil {
+			return nil, err
+		}
+		merged := normalized[relayURL]
+		normalized[relayURL] = RelayPolicy{Read: merged.Read || policy.Read, Write: merged.Write || policy.Write}
+	}
+	if len(normalized) > maxAccountRelays {
+		return nil, fmt.Errorf("%w list: at most %d relays", errInvalidRelay, maxAccountRelays)
+	}
+	return normalized, nil
+}
+
+// loadAccountRelays reads an account's relays.json. An account without one
+// has no relays.
+func loadAccountRelays(npub string) (map[string]RelayPolicy, error) {
+	store, err := accountStore()
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := store.ReadRecord(npub, recordRelays)
+	if os.IsNotExist(err) {
+		return map[string]RelayPolicy{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", recordRelays, err)
+	}
+
+	var list accountRelayList
+	if err := json.Unmarshal(content, &list); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", recordRelays, err)
+	}
+	if list.Version > relayListVersion {
+		return nil, fmt.Errorf("unsupported %s version %d", recordRelays, list.Version)
+	}
+	if list.Relays == nil {
+		list.Relays = map[string]RelayPolicy{}
+	}
+	return list.Relays, nil
+}
+
+// updateAccountRelays changes an account's relay list under storage.lock:
+// update gets the stored list and returns the new one. It returns the list
+// as stored.
+func updateAccountRelays(npub string, update func(map[string]RelayPolicy) (map[string]RelayPolicy, error)) (map[string]RelayPolicy, error) {
+	store, err := accountStore()
+	if err != nil {
+		return nil, err
+	}
+
+	var stored map[string]RelayPolicy
+	err = withStorageLock(func() error {
+		if _, _, err := store.ReadRecord(npub, recordKey); err != nil {
+			return fmt.Errorf("%w: %s", errUnknownAccount, displayNpub(npub))
+		}
+		relays, err := loadAccountRelays(npub)
+		if err != nil {
+			return err
+		}
+		if relays, err = update(relays); err != nil {
+			return err
+		}
+		if relays, err = normalizeRelayList(relays); err != nil {
+			return err
+		}
+		content, err := json.MarshalIndent(accountRelayList{
+			Version:   relayListVersion,
+			Relays:    relays,
+			UpdatedAt: time.Now().Unix(),
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		stored = relays
+		return store.WriteRecords(npub, storeRecord{Name: recordRelays, Data: append(content, '\n')})
+	})
+	return stored, err
+}
+
+// setAccountRelays replaces an account's relay list and returns it as stored
+func setAccountRelays(npub string, relays map[string]RelayPolicy) (map[string]RelayPolicy, error) {
+	return updateAccountRelays(npub, func(map[string]RelayPolicy) (map[string]RelayPolicy, error) {
+		return relays, nil
+	})
+}
+
+// relayListUnsignedEvent builds the NIP-65 relay list (kind 10002) of
+// relays without pubkey, id and sig: an "r" tag per relay, with a "read"
+// or "write" marker unless it is both, sorted by URL. An empty list is
+// refused: published, it would tell everyone the account has no relays.
+func relayListUnsignedEvent(relays map[string]RelayPolicy, createdAt int64) (string, error) {
+	relays, err := normalizeRelayList(relays)
+	if err != nil {
+		return "", err
+	}
+	if len(relays) == 0 {
+		return "", fmt.Errorf("%w list: no relays to announce - add some first", errInvalidRelay)
+	}
+
+	urls := make([]string, 0, len(relays))
+	for relay := range relays {
+		urls = append(urls, relay)
+	}
+	sort.Strings(urls)
+	tags := make([][]string, 0, len(urls))
+	for _, relay := range urls {
+		switch policy := relays[relay]; {
+		case policy.Read && policy.Write:
+			tags = append(tags, []string{"r", relay})
+		case policy.Read:
+			tags = append(tags, []string{"r", relay, "read"})
+		default:
+			tags = append(tags, []string{"r", relay, "write"})
+		}
+	}
+
+	event, err := json.Marshal(map[string]interface{}{
+		"kind":       relayListKind,
+		"created_at": createdAt,
+		"tags":       tags,
+		"content":    "",
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(event), nil
+}
+
+// relayListPublishRelays returns where a relay list is published: the
+// account's write relays, where its followers look, and the profile
+// relays, where clients that don't know them yet look
+func relayListPublishRelays(relays map[string]RelayPolicy) []string {
+	var targets []string
+	seen := make(map[string]bool)
+	for relay, policy := range relays {
+		if policy.Write && !seen[relay] {
+			seen[relay] = true
+			targets = append(targets, relay)
+		}
+	}
+	for _, relay := range appConfig.profileRelays() {
+		if !seen[relay] {
+			seen[relay] = true
+			targets = append(targets, relay)
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// publishEvent sends a signed event to relays in parallel and returns the
+// error of each relay that didn't take it, by URL
+func publishEvent(signed *NostrEvent, relays []string) map[string]error {
+	ctx, cancel := context.WithTimeout(context.Background(), relayListPublishTimeout)
+	defer cancel()
+
+	failures := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, relayURL := range relays {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := func() error {
+				relay, err := nostr.RelayConnect(ctx, relayURL)
+				if err != nil {
+					return err
+				}
+				defer relay.Close()
+				return relay.Publish(ctx, toNostrEvent(signed))
+			}()
+			if err != nil {
+				mu.Lock()
+				failures[relayURL] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return failures
+}
+
+// relaysAnnounceCmd signs the active account's relay list as a NIP-65
+// event with its password and prints it; --publish also sends it to the
+// relays
+func relaysAnnounceCmd(args []string) error {
+	usage := "noorsigner relays announce [--publish] " + passwordFlagsUsage
+	args, err := passwordArgs(args, usage)
+	if err != nil {
+		return err
+	}
+	publish := false
+	for _, arg := range args {
+		if arg != "--publish" {
+			return commandFailed(1, "Usage: %s", usage)
+		}
+		publish = true
+	}
+
+	// stdout carries only the signed event
+	redirectChatter()
+	activeNpub, activePubkey, err := loadActiveSigner()
+	if err != nil {
+		return err
+	}
+	relays, err := loadAccountRelays(activeNpub)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	eventJSON, err := relayListUnsignedEvent(relays, time.Now().Unix())
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	event, err := signEventInput(activeNpub, activePubkey, []byte(eventJSON), "sign_relay_list")
+	if err != nil {
+		return err
+	}
+	if !publish {
+		return nil
+	}
+
+	targets := relayListPublishRelays(relays)
+	failures := publishEvent(event, targets)
+	for _, relay := range targets {
+		if err := failures[relay]; err != nil {
+			fmt.Printf("⚠️  %s: %v\n", relay, err)
+		} else {
+			fmt.Printf("✅ Published to %s\n", relay)
+		}
+	}
+	if len(failures) == len(targets) {
+		return commandFailed(1, "❌ No relay took the relay list")
+	}
+	return nil
+}
+
+// relayPolicyString describes a relay's markers for the CLI
+func relayPolicyString(policy RelayPolicy) string {
+	switch {
+	case policy.Read && policy.Write:
+		return "read, write"
+	case policy.Read:
+		return "read"
+	}
+	return "write"
+}
+
+// relaysCmd lists and edits the active account's relay list
+func relaysCmd(args []string) error {
+	usage := "Usage: noorsigner relays list | add <url> [--read | --write] | remove <url> | announce [--publish]"
+	if len(args) == 0 {
+		return commandFailed(1, "%s", usage)
+	}
+
+	npub, err := loadActiveAccount()
+	if err != nil || !accountExists(npub) {
+		return commandFailed(1, "❌ No active account - add one with 'noorsigner add-account'")
+	}
+
+	switch args[0] {
+	case "announce":
+		return relaysAnnounceCmd(args[1:])
+	case "list":
+		if len(args) != 1 {
+			return commandFailed(1, "%s", usage)
+		}
+		relays, err := loadAccountRelays(npub)
+		if err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+		if len(relays) == 0 {
+			fmt.Printf("No relays for %s. Add one with 'noorsigner relays add <url>'.\n", labeledNpub(npub, accountLabel(npub)))
+			return nil
+		}
+		fmt.Printf("Relays of %s:\n", labeledNpub(npub, accountLabel(npub)))
+		urls := make([]string, 0, len(relays))
+		for relay := range relays {
+			urls = append(urls, relay)
+		}
+		sort.Strings(urls)
+		for _, relay := range urls {
+			fmt.Printf("  %-40s %s\n", relay, relayPolicyString(relays[relay]))
+		}
+		return nil
+
+	case "add":
+		policy := RelayPolicy{Read: true, Write: true}
+		switch {
+		case len(args) == 3 && args[2] == "--read":
+			policy.Write = false
+		case len(args) == 3 && args[2] == "--write":
+			policy.Read = false
+		case len(args) != 2:
+			return commandFailed(1, "%s", usage)
+		}
+		relay, err := normalizeRelayURL(args[1])
+		if err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+		// Adding a listed relay again changes its markers
+		_, err = updateAccountRelays(npub, func(relays map[string]RelayPolicy) (map[string]RelayPolicy, error) {
+			relays[relay] = policy
+			return relays, nil
+		})
+		auditCLI("set_relays", npub, err, nil)
+		if err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+		fmt.Printf("✅ %s added (%s)\n", relay, relayPolicyString(policy))
+		return nil
+
+	case "remove":
+		if len(args) != 2 {
+			return commandFailed(1, "%s", usage)
+		}
+		relay, err := normalizeRelayURL(args[1])
+		if err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+		_, err = updateAccountRelays(npub, func(relays map[string]RelayPolicy) (map[string]RelayPolicy, error) {
+			if _, ok := relays[relay]; !ok {
+				return nil, fmt.Errorf("%s is not in the relay list", relay)
+			}
+			delete(relays, relay)
+			return relays, nil
+		})
+		auditCLI("set_relays", npub, err, nil)
+		if err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+		fmt.Printf("✅ %s removed\n", relay)
+		return nil
+	}
+	return commandFailed(1, "%s", usage)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The relays of the NIP-65 example, as relays.json holds them
+var nip65ExampleRelays = map[string]RelayPolicy{
+	"wss://alicerelay.example.com":       {Read: true, Write: true},
+	"wss://brando-relay.com":             {Read: true, Write: true},
+	"wss://expensive-relay.example2.com": {Write: true},
+	"wss://nostr-relay.example.com":      {Read: true},
+}
+
+// nip65ExampleTags are the tags of the NIP-65 example, sorted by URL
+var nip65ExampleTags = [][]string{
+	{"r", "wss://alicerelay.example.com"},
+	{"r", "wss://brando-relay.com"},
+	{"r", "wss://expensive-relay.example2.com", "write"},
+	{"r", "wss://nostr-relay.example.com", "read"},
+}
+
+func TestRelayListUnsignedEvent(t *testing.T) {
+	eventJSON, err := relayListUnsignedEvent(nip65ExampleRelays, 1700000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event struct {
+		Kind      int        `json:"kind"`
+		CreatedAt int64      `json:"created_at"`
+		Tags      [][]string `json:"tags"`
+		Content   *string    `json:"content"`
+	}
+	if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Kind != 10002 || event.CreatedAt != 1700000000 || event.Content == nil || *event.Content != "" {
+		t.Errorf("event %s, want kind 10002 at 1700000000 with empty content", eventJSON)
+	}
+	if !reflect.DeepEqual(event.Tags, nip65ExampleTags) {
+		t.Errorf("tags %v, want %v", event.Tags, nip65ExampleTags)
+	}
+
+	// Stored URLs are normalized; others are normalized the same way
+	eventJSON, err = relayListUnsignedEvent(map[string]RelayPolicy{"WSS://Relay.Example.com:443/": {Read: true}}, 1700000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte(eventJSON), &event)
+	if want := [][]string{{"r", "wss://relay.example.com", "read"}}; !reflect.DeepEqual(event.Tags, want) {
+		t.Errorf("tags %v, want %v", event.Tags, want)
+	}
+
+	for name, relays := range map[string]map[string]RelayPolicy{
+		"empty":       {},
+		"no markers":  {"wss://relay.example.com": {}},
+		"not wss":     {"http://relay.example.com": {Read: true}},
+		"invalid URL": {"wss://": {Read: true}},
+	} {
+		if _, err := relayListUnsignedEvent(relays, 1700000000); err == nil {
+			t.Errorf("%s: relay list accepted", name)
+		}
+	}
+}
+
+func TestSignRelayList(t *testing.T) {
+	testHome(t)
+	other := addTestAccount(t, "")
+	active := addTestAccount(t, "")
+	d := testDaemon(t, active)
+	serveTestDaemon(t, d)
+	conn := dialTestDaemon(t)
+	if _, err := setAccountRelays(active, nip65ExampleRelays); err != nil {
+		t.Fatal(err)
+	}
+
+	var response SignResponse
+	if err := conn.request(t, SignRequest{ID: "1", Method: "sign_relay_list"}, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error != "" || response.Event == nil {
+		t.Fatalf("sign_relay_list: %+v", response)
+	}
+	var event nostr.Event
+	data, _ := json.Marshal(response.Event)
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Kind != 10002 || event.PubKey != d.pubkey || event.ID != response.EventID || event.Sig != response.Signature {
+		t.Errorf("signed %+v, want the active account's kind 10002", event)
+	}
+	tags := make([][]string, len(event.Tags))
+	for i, tag := range event.Tags {
+		tags[i] = tag
+	}
+	if !reflect.DeepEqual(tags, nip65ExampleTags) {
+		t.Errorf("tags %v, want %v", tags, nip65ExampleTags)
+	}
+	if event.GetID() != event.ID {
+		t.Errorf("id %s, go-nostr computes %s", event.ID, event.GetID())
+	}
+	if ok, err := event.CheckSignature(); !ok {
+		t.Errorf("invalid signature: %v", err)
+	}
+
+	// Another account has no relays and its key is not in memory
+	response = SignResponse{}
+	if err := conn.request(t, SignRequest{ID: "2", Method: "sign_relay_list", Npub: other}, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error == "" || response.Event != nil {
+		t.Errorf("sign_relay_list of an account without relays: %+v", response)
+	}
+	if _, err := setAccountRelays(other, nip65ExampleRelays); err != nil {
+		t.Fatal(err)
+	}
+	response = SignResponse{}
+	if err := conn.request(t, SignRequest{ID: "3", Method: "sign_relay_list", Npub: other}, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != codeAccountLocked {
+		t.Errorf("sign_relay_list of a locked account: %+v, want %s", response, codeAccountLocked)
+	}
+}
+
+func TestRelaysAnnounceUsage(t *testing.T) {
+	testHome(t)
+	addTestAccount(t, "")
+	if err := relaysCmd([]string{"announce", "--bogus"}); exitCode(err) != 1 {
+		t.Errorf("relays announce --bogus = %v, want a usage error", err)
+	}
+}
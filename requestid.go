@@ -0,0 +1,36 @@
+package main
+
+// maxTrackedRequestIDs bounds the request IDs remembered per connection.
+// Older IDs are forgotten first, so a long-lived connection can't grow the
+// set without limit.
+const maxTrackedRequestIDs = 1024
+
+// codeDuplicateID rejects a request ID already used on the connection
+const codeDuplicateID = "ERR_DUPLICATE_ID"
+
+// requestIDs remembers the request IDs used on one connection
+type requestIDs struct {
+	seen  map[string]struct{}
+	order []string // Ring of remembered IDs, oldest at next once full
+	next  int
+}
+
+// use records id and reports whether it was unused
+func (r *requestIDs) use(id string) bool {
+	if _, used := r.seen[id]; used {
+		return false
+	}
+	if r.seen == nil {
+		r.seen = make(map[string]struct{})
+	}
+
+	if len(r.order) < maxTrackedRequestIDs {
+		r.order = append(r.order, id)
+	} else {
+		delete(r.seen, r.order[r.next])
+		r.order[r.next] = id
+		r.next = (r.next + 1) % maxTrackedRequestIDs
+	}
+	r.seen[id] = struct{}{}
+	return true
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// maxRequestSize bounds one request line. The largest legitimate request
+	// is a full sign_events or nip44_decrypt_batch batch (4 MB of payload,
+	// more once escaped as JSON strings).
+	maxRequestSize = 16 * 1024 * 1024
+
+	// defaultRequestTimeout is how long a connection may take to send its
+	// next request
+	defaultRequestTimeout = 30 * time.Second
+
+	codeRequestTooLarge = "ERR_REQUEST_TOO_LARGE"
+	codeRequestTimeout  = "ERR_REQUEST_TIMEOUT"
+)
+
+// errRequestTooLarge ends a connection whose request exceeds maxRequestSize
+var errRequestTooLarge = fmt.Errorf("request too large (max %d bytes)", maxRequestSize)
+
+// requestLimitReader caps how much is read for one request. The connection
+// loop resets it before each request.
+type requestLimitReader struct {
+	r    io.Reader
+	read int64
+}
+
+func (l *requestLimitReader) Read(p []byte) (int, error) {
+	if l.read >= maxRequestSize {
+		return 0, errRequestTooLarge
+	}
+	if remaining := maxRequestSize - l.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	return n, err
+}
+
+// reset starts counting for the next request
+func (l *requestLimitReader) reset() {
+	l.read = 0
+}
+
+// parseRequestTimeout parses request_timeout (empty = 30s)
+func parseRequestTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultRequestTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid request_timeout %q: %v", value, err)
+	}
+	if timeout < time.Second || timeout > 10*time.Minute {
+		return 0, fmt.Errorf("request_timeout must be between 1s and 10m")
+	}
+	return timeout, nil
+}
+
+// requestDeadline returns when the session's next request must have arrived.
+// After a confirmation token was issued the client gets the token's lifetime
+// to resubmit.
+func (d *Daemon) requestDeadline(session *connSession) time.Time {
+	timeout := d.requestTimeout
+	if session.awaitingConfirmation && timeout < confirmationTTL {
+		timeout = confirmationTTL
+	}
+	return time.Now().Add(timeout)
+}
+
+// onlyWhitespace reports whether r holds nothing but whitespace, i.e. no
+// request has started yet
+func onlyWhitespace(r io.Reader) bool {
+	rest, _ := io.ReadAll(r)
+	return len(bytes.TrimSpace(rest)) == 0
+}
+
+// isTimeout reports whether err is a read deadline expiring
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// readUntilClosed reads what the daemon sends until it closes the
+// connection, failing if that takes longer than within
+func readUntilClosed(t *testing.T, conn *testConn, within time.Duration) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(within))
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("connection still open after %v: %v", within, err)
+	}
+	return data
+}
+
+// A connection that drips its request in slower than request_timeout is cut
+// off with ERR_REQUEST_TIMEOUT; one that sends nothing is closed quietly
+func TestDaemonSlowRequest(t *testing.T) {
+	testHome(t)
+	d := testDaemon(t, addTestAccount(t, ""))
+	d.requestTimeout = 200 * time.Millisecond
+	serveTestDaemon(t, d)
+
+	t.Run("drip-fed", func(t *testing.T) {
+		conn := dialTestDaemon(t)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			// Each byte comes well within the timeout, the request doesn't
+			for _, b := range []byte(`{"method":"ping","id":"slow"}`) {
+				if _, err := conn.Write([]byte{b}); err != nil {
+					return
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		}()
+		defer func() { <-done }()
+
+		// The rest of the drip may arrive after the daemon hung up, which
+		// resets the connection instead of closing it cleanly
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var response SignResponse
+		if err := conn.decoder.Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != codeRequestTimeout {
+			t.Errorf("drip-fed request: %+v, want %s", response, codeRequestTimeout)
+		}
+		if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+			t.Errorf("connection still open after the timeout: %v", err)
+		}
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		if data := readUntilClosed(t, dialTestDaemon(t), 5*time.Second); len(data) != 0 {
+			t.Errorf("idle connection got %q", data)
+		}
+	})
+
+	t.Run("idle between requests", func(t *testing.T) {
+		conn := dialTestDaemon(t)
+		var response SignResponse
+		if err := conn.request(t, SignRequest{Method: "ping"}, &response); err != nil || response.Error != "" {
+			t.Fatalf("ping: %+v, %v", response, err)
+		}
+		if data := readUntilClosed(t, conn, 5*time.Second); len(data) != 0 {
+			t.Errorf("idle connection got %q", data)
+		}
+	})
+}
+
+// A request over maxRequestSize ends the connection with
+// ERR_REQUEST_TOO_LARGE; one just under is read whole
+func TestDaemonOversizedRequest(t *testing.T) {
+	testHome(t)
+	d := testDaemon(t, addTestAccount(t, ""))
+	serveTestDaemon(t, d)
+
+	request := func(size int) (*testConn, chan error) {
+		conn := dialTestDaemon(t)
+		prefix := []byte(`{"method":"ping","id":"big","content":"`)
+		suffix := []byte("\"}\n")
+		payload := bytes.Repeat([]byte("a"), size-len(prefix)-len(suffix))
+		written := make(chan error, 1)
+		go func() {
+			_, err := conn.Write(bytes.Join([][]byte{prefix, payload, suffix}, nil))
+			written <- err
+		}()
+		return conn, written
+	}
+
+	// The daemon hangs up on the rest of the request: the response may be
+	// followed by a reset instead of a clean close
+	conn, written := request(maxRequestSize + 1024)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var response SignResponse
+	if err := conn.decoder.Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != codeRequestTooLarge {
+		t.Errorf("oversized request: %+v, want %s", response, codeRequestTooLarge)
+	}
+	conn.Close()
+	<-written
+
+	conn, written = request(maxRequestSize)
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	response = SignResponse{}
+	if err := conn.decoder.Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error != "" || response.ID != "big" {
+		t.Errorf("request of maxRequestSize: %+v", response)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// codeForbidden rejects a connection from another user's process
+const codeForbidden = "ERR_FORBIDDEN"
+
+// errPeerCredentialsUnsupported is returned where the platform can't tell
+// who is connecting
+var errPeerCredentialsUnsupported = errors.New("peer credentials are not supported on this platform")
+
+// peerCred identifies the process on the other end of a connection
+type peerCred struct {
+	UID int
+	PID int // 0 if the platform doesn't report it
+}
+
+// admitPeer checks who is connecting before any request is read. Socket
+// permissions can be loosened or ignored, so on Linux and macOS the peer's
+// UID must also be the daemon's. Elsewhere the listener's permissions alone
+// decide. WebSocket connections are admitted by their origin (ws_origins).
+// The credentials of an admitted peer identify it in logs and
+// recent_requests (zero where the platform doesn't report them).
+func (d *Daemon) admitPeer(conn net.Conn) (peerCred, bool) {
+	if ws, ok := conn.(*wsConn); ok {
+		// The HTTP API's bearer token was checked on the upgrade; the origin
+		// must (still) be in ws_origins
+		if d.config.wsOriginAllowed(ws.origin) {
+			return peerCred{}, true
+		}
+		logError("🚫 Connection refused: %s", wsOriginError(ws.origin))
+		conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+		json.NewEncoder(conn).Encode(SignResponse{
+			Error: "connection refused: " + wsOriginError(ws.origin),
+			Code:  codeForbidden,
+		})
+		return peerCred{}, false
+	}
+	cred, err := peerCredentials(conn)
+	if errors.Is(err, errPeerCredentialsUnsupported) {
+		return peerCred{}, true
+	}
+	if err == nil && cred.UID == os.Getuid() {
+		return cred, true
+	}
+
+	if err != nil {
+		logError("🚫 Connection refused: %v", err)
+	} else {
+		logError("🚫 Connection refused: peer UID %d (PID %d) is not the daemon's UID %d", cred.UID, cred.PID, os.Getuid())
+	}
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	json.NewEncoder(conn).Encode(SignResponse{
+		Error: "connection refused: only processes of the daemon's user may connect",
+		Code:  codeForbidden,
+	})
+	return cred, false
+}
+
+// SecuritySummary describes who can reach the daemon. It is assembled from
+// live queries on the listeners, not from config.json, so drift shows up.
+type SecuritySummary struct {
+	Listeners []ListenerSecurity `json:"listeners"`
+	// Policy is "trust" (Trust Mode sessions) or "no_trust" (--no-trust)
+	Policy             string `json:"policy"`
+	StrictConfirmation bool   `json:"strict_confirmation"`
+	// RequireAuth is set when every request needs a client token
+	RequireAuth bool `json:"require_auth"`
+	// Strict is set in strict mode (see strict.go)
+	Strict bool `json:"strict"`
+}
+
+// ListenerSecurity is the observed access control of one listener
+type ListenerSecurity struct {
+	Path string `json:"path"`
+	// Kind is "unix_socket", "named_pipe", "abstract_socket" or "http"
+	Kind string `json:"kind"`
+	// Mode and Group are set for Unix sockets
+	Mode  string `json:"mode,omitempty"`
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+	// DACL is the pipe's security descriptor in SDDL (Windows)
+	DACL string `json:"dacl,omitempty"`
+	// Restricted listeners serve a single account (Account)
+	Restricted bool   `json:"restricted"`
+	Account    string `json:"account,omitempty"`
+	// PeerCredentials is "uid_checked" where every connection's peer UID
+	// must be the daemon's, "not_checked" where the listener's permissions
+	// alone decide (see admitPeer)
+	PeerCredentials string `json:"peer_credentials"`
+	// Expected is the access control noorsigner sets up
+	Expected string `json:"expected"`
+	// Problems lists differences between Expected and what was observed
+	Problems []string `json:"problems,omitempty"`
+}
+
+// securitySummary inspects the daemon's listener(s)
+func (d *Daemon) securitySummary() *SecuritySummary {
+	summary := &SecuritySummary{
+		Policy:             "trust",
+		StrictConfirmation: d.config != nil && d.config.StrictConfirmation,
+		RequireAuth:        d.config.authRequired(),
+		Strict:             d.config.strictEnabled(),
+	}
+	if d.noTrust {
+		summary.Policy = "no_trust"
+	}
+
+	// One listener serving every account; restricted listeners would be added here
+	socketPath, err := getSocketPath()
+	if err != nil {
+		summary.Listeners = append(summary.Listeners, ListenerSecurity{
+			PeerCredentials: peerCredentialsMode,
+			Problems:        []string{fmt.Sprintf("cannot resolve socket path: %v", err)},
+		})
+		return summary
+	}
+	summary.Listeners = append(summary.Listeners, inspectListener(socketPath))
+	if d.config != nil && d.config.AbstractSocket != "" {
+		summary.Listeners = append(summary.Listeners, d.inspectAbstractListener())
+	}
+	if d.httpServer != nil {
+		summary.Listeners = append(summary.Listeners, d.inspectHTTPListener())
+	}
+	return summary
+}
+
+// printListenerSecurity prints the security summary for status
+func printListenerSecurity(summary *SecuritySummary) {
+	if summary == nil {
+		return
+	}
+	for _, listener := range summary.Listeners {
+		label, access := "Access:", listener.DACL
+		if listener.Mode != "" {
+			access = fmt.Sprintf("%s %s:%s", listener.Mode, listener.Owner, listener.Group)
+		}
+		if listener.Kind == "abstract_socket" {
+			// No file permissions - the peer check is the only guard
+			label, access = "Abstract:", listener.Path+" (any local user can connect)"
+		}
+		if listener.Kind == "http" {
+			label, access = "HTTP:", listener.Path+" (bearer token)"
+		}
+		if listener.PeerCredentials == "uid_checked" {
+			access += ", peer UID checked"
+		}
+		fmt.Printf("   %-11s %s\n", label, access)
+		for _, problem := range listener.Problems {
+			fmt.Printf("⚠️  %s: %s\n", listener.Path, problem)
+		}
+	}
+	if summary.RequireAuth {
+		fmt.Println("   Auth:       client token required (require_auth)")
+	}
+	if summary.Strict {
+		fmt.Printf("   Strict:     on - no trust sessions, memory locked, %s confirmed\n", describeStrictKinds())
+	}
+}
+
+// checkListenerSecurity is doctor's check of the socket permissions. With
+// fix set, what fixListenerSecurity can repair is repaired.
+func checkListenerSecurity(fix bool) ([]string, error) {
+	socketPath, err := getSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(socketPath); err != nil {
+		return nil, nil // Daemon not running - nothing to inspect
+	}
+
+	listener := inspectListener(socketPath)
+	if fix && len(listener.Problems) > 0 {
+		if err := fixListenerSecurity(socketPath); err != nil {
+			return nil, err
+		}
+		listener = inspectListener(socketPath)
+	}
+	return listener.Problems, nil
+}
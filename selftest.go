@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip44"
+	"github.com/nbd-wtf/go-nostr/nip49"
+)
+
+// selftestPassword encrypts the NIP-49 round trip
+const selftestPassword = "noorsigner-selftest"
+
+// selftestEvent is an unsigned event the self-test signs both ways
+type selftestEvent struct {
+	Name    string
+	Kind    int
+	Tags    [][]string
+	Content string
+}
+
+// selftestEvents covers what has broken serializers before: escaping,
+// non-ASCII, empty and large fields
+var selftestEvents = []selftestEvent{
+	{Name: "kind 1 plain text", Kind: 1, Tags: [][]string{}, Content: "hello nostr"},
+	{Name: "empty content and tags", Kind: 1, Tags: [][]string{}, Content: ""},
+	{Name: "unicode", Kind: 1, Tags: [][]string{{"t", "üñïçødé"}},
+		Content: "emoji 🤙🏽 RTL مرحبا CJK 你好 combining é zero-width \u200b\u200d"},
+	{Name: "HTML characters", Kind: 1, Tags: [][]string{{"r", "https://example.com/?a=1&b=<2>"}},
+		Content: `<script>alert("x")</script> & 'quotes' > <`},
+	{Name: "escapes and control chars", Kind: 1, Tags: [][]string{{"x", "tab\tnl\n"}},
+		Content: "line\nbreak\ttab\rcr\bback\ffeed\\slash\"quote\x00nul\x1funit\x7fdel \u2028ls \u2029ps"},
+	{Name: "kind 0 metadata JSON", Kind: 0, Tags: [][]string{},
+		Content: `{"name":"noor","about":"signer \"test\"\nline two","picture":"https://example.com/a.png?x=1&y=2"}`},
+	{Name: "kind 3 with 2000 tags", Kind: 3, Tags: selftestContactTags(2000), Content: ""},
+	{Name: "huge tag element", Kind: 30023, Tags: [][]string{{"d", "article"}, {"summary", strings.Repeat("long tag ✓ ", 3000)}},
+		Content: strings.Repeat("# Long-form\n\nParagraph with *markdown* and `code`.\n", 500)},
+	{Name: "replaceable kind 30078", Kind: 30078, Tags: [][]string{{"d", "app:settings"}, {"empty", ""}, {"single"}},
+		Content: `{"theme":"dark"}`},
+}
+
+// selftestPlaintexts are encrypted both ways
+var selftestPlaintexts = []struct {
+	Name string
+	Text string
+}{
+	{"1 byte", "a"},
+	{"short text", "hello nostr"},
+	{"unicode", "🤙🏽 مرحبا 你好 \u2028 \u2029 \x00"},
+	{"JSON", `{"method":"sign_event","params":["<tag>&"]}`},
+	{"64 KiB - 1", strings.Repeat("x", 65535)},
+}
+
+// selftestContactTags returns n distinct p tags
+func selftestContactTags(n int) [][]string {
+	tags := make([][]string, n)
+	for i := range tags {
+		tags[i] = []string{"p", fmt.Sprintf("%064x", i+1), "wss://relay.example.com", fmt.Sprintf("petname %d", i)}
+	}
+	return tags
+}
+
+// selftestCell is one check of the matrix (skip: not applicable)
+type selftestCell struct {
+	skip bool
+	err  error
+}
+
+// selftestRow is one case and its checks, in the group's column order
+type selftestRow struct {
+	Name  string
+	Cells []selftestCell
+}
+
+// selftestGroup is one table of the matrix
+type selftestGroup struct {
+	Name    string
+	Columns []string
+	Rows    []selftestRow
+}
+
+// selftestKeys are the two random keys the self-test uses: ours signs and
+// encrypts, peer is the other side of the encryption checks
+type selftestKeys struct {
+	ours    *btcec.PrivateKey
+	peer    *btcec.PrivateKey
+	pubkey  string // ours, x-only hex
+	peerHex string // peer's secret, hex
+	peerPub string
+}
+
+// newSelftestKeys generates two random keys
+func newSelftestKeys() (*selftestKeys, error) {
+	ours, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	peer, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &selftestKeys{
+		ours:    ours,
+		peer:    peer,
+		pubkey:  hex.EncodeToString(ours.PubKey().SerializeCompressed()[1:]),
+		peerHex: hex.EncodeToString(peer.Serialize()),
+		peerPub: hex.EncodeToString(peer.PubKey().SerializeCompressed()[1:]),
+	}, nil
+}
+
+// runSelftest runs every check and returns the matrix
+func runSelftest(keys *selftestKeys) []selftestGroup {
+	createdAt := time.Now().Unix()
+
+	events := selftestGroup{Name: "Events", Columns: []string{"id", "sig", "reverse"}}
+	for _, event := range selftestEvents {
+		idErr, sigErr := selftestSignOurs(keys, event, createdAt)
+		events.Rows = append(events.Rows, selftestRow{Name: event.Name, Cells: []selftestCell{
+			{err: idErr}, {err: sigErr}, {err: selftestSignTheirs(keys, event, createdAt)},
+		}})
+	}
+
+	nip44Group := selftestGroup{Name: "NIP-44", Columns: []string{"ours→go-nostr", "go-nostr→ours"}}
+	nip04Group := selftestGroup{Name: "NIP-04", Columns: []string{"ours→go-nostr", "go-nostr→ours"}}
+	for _, plaintext := range selftestPlaintexts {
+		nip44Group.Rows = append(nip44Group.Rows, selftestRow{Name: plaintext.Name, Cells: []selftestCell{
+			{err: selftestNip44Ours(keys, plaintext.Text)}, {err: selftestNip44Theirs(keys, plaintext.Text)},
+		}})
+		nip04Group.Rows = append(nip04Group.Rows, selftestRow{Name: plaintext.Name, Cells: []selftestCell{
+			{err: selftestNip04Ours(keys, plaintext.Text)}, {err: selftestNip04Theirs(keys, plaintext.Text)},
+		}})
+	}
+
+	// noorsigner only writes ncryptsec backups, so there is no reverse
+	// direction to check
+	nip49Group := selftestGroup{Name: "NIP-49", Columns: []string{"ours→go-nostr", "go-nostr→ours"}}
+	nip49Group.Rows = append(nip49Group.Rows, selftestRow{Name: "ncryptsec backup", Cells: []selftestCell{
+		{err: selftestNcryptsec(keys)}, {skip: true},
+	}})
+
+	return []selftestGroup{events, nip44Group, nip04Group, nip49Group}
+}
+
+// selftestSignOurs signs event with noorsigner's own hashing and signing,
+// then has go-nostr recompute the id and check the signature of the event
+// as it goes out on the wire
+func selftestSignOurs(keys *selftestKeys, event selftestEvent, createdAt int64) (idErr, sigErr error) {
+	unsigned, err := json.Marshal(map[string]interface{}{
+		"pubkey":     keys.pubkey,
+		"created_at": createdAt,
+		"kind":       event.Kind,
+		"tags":       event.Tags,
+		"content":    event.Content,
+	})
+	if err != nil {
+		return err, err
+	}
+	hash, err := createEventHash(string(unsigned))
+	if err != nil {
+		return err, err
+	}
+	signature, err := signNostrEvent(keys.ours, hash)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := completeEvent(string(unsigned), hash, signature)
+	if err != nil {
+		return err, err
+	}
+	wire, err := json.Marshal(signed)
+	if err != nil {
+		return err, err
+	}
+
+	var theirs nostr.Event
+	if err := json.Unmarshal(wire, &theirs); err != nil {
+		return fmt.Errorf("go-nostr cannot parse the event: %v", err), nil
+	}
+	if id := theirs.GetID(); id != signed.ID {
+		idErr = fmt.Errorf("id %s, go-nostr computes %s", signed.ID, id)
+	}
+	if ok, err := theirs.CheckSignature(); err != nil {
+		sigErr = fmt.Errorf("go-nostr rejects the signature: %v", err)
+	} else if !ok {
+		sigErr = fmt.Errorf("go-nostr: signature does not verify")
+	}
+	return idErr, sigErr
+}
+
+// selftestSignTheirs signs event with go-nostr and checks that noorsigner
+// computes the same id and accepts the signature
+func selftestSignTheirs(keys *selftestKeys, event selftestEvent, createdAt int64) error {
+	theirs := nostr.Event{
+		CreatedAt: nostr.Timestamp(createdAt),
+		Kind:      event.Kind,
+		Tags:      make(nostr.Tags, len(event.Tags)),
+		Content:   event.Content,
+	}
+	for i, tag := range event.Tags {
+		theirs.Tags[i] = nostr.Tag(tag)
+	}
+	if err := theirs.Sign(keys.peerHex); err != nil {
+		return fmt.Errorf("go-nostr cannot sign: %v", err)
+	}
+	wire, err := json.Marshal(theirs)
+	if err != nil {
+		return err
+	}
+
+	hash, err := createEventHash(string(wire))
+	if err != nil {
+		return err
+	}
+	if id := encodeHex(hash); id != theirs.ID {
+		return fmt.Errorf("go-nostr id %s, noorsigner computes %s", theirs.ID, id)
+	}
+	return verifyConformanceSignature(string(wire), theirs.PubKey, theirs.Sig)
+}
+
+// selftestNip44Ours encrypts with noorsigner and decrypts with go-nostr
+func selftestNip44Ours(keys *selftestKeys, plaintext string) error {
+	payload, err := nip44Encrypt(plaintext, keys.peerPub, keys.ours)
+	if err != nil {
+		return err
+	}
+	conversationKey, err := nip44.GenerateConversationKey(keys.pubkey, keys.peerHex)
+	if err != nil {
+		return err
+	}
+	decrypted, err := nip44.Decrypt(payload, conversationKey)
+	if err != nil {
+		return fmt.Errorf("go-nostr cannot decrypt: %v", err)
+	}
+	return selftestSame(plaintext, decrypted)
+}
+
+// selftestNip44Theirs encrypts with go-nostr and decrypts with noorsigner
+func selftestNip44Theirs(keys *selftestKeys, plaintext string) error {
+	conversationKey, err := nip44.GenerateConversationKey(keys.pubkey, keys.peerHex)
+	if err != nil {
+		return err
+	}
+	payload, err := nip44.Encrypt(plaintext, conversationKey)
+	if err != nil {
+		return fmt.Errorf("go-nostr cannot encrypt: %v", err)
+	}
+	decrypted, err := nip44Decrypt(payload, keys.peerPub, keys.ours)
+	if err != nil {
+		return err
+	}
+	return selftestSame(plaintext, decrypted)
+}
+
+// selftestNip04Ours encrypts with noorsigner and decrypts with go-nostr
+func selftestNip04Ours(keys *selftestKeys, plaintext string) error {
+	payload, err := nip04Encrypt(plaintext, keys.peerPub, keys.ours)
+	if err != nil {
+		return err
+	}
+	shared, err := nip04.ComputeSharedSecret(keys.pubkey, keys.peerHex)
+	if err != nil {
+		return err
+	}
+	decrypted, err := nip04.Decrypt(payload, shared)
+	if err != nil {
+		return fmt.Errorf("go-nostr cannot decrypt: %v", err)
+	}
+	return selftestSame(plaintext, decrypted)
+}
+
+// selftestNip04Theirs encrypts with go-nostr and decrypts with noorsigner
+func selftestNip04Theirs(keys *selftestKeys, plaintext string) error {
+	shared, err := nip04.ComputeSharedSecret(keys.pubkey, keys.peerHex)
+	if err != nil {
+		return err
+	}
+	payload, err := nip04.Encrypt(plaintext, shared)
+	if err != nil {
+		return fmt.Errorf("go-nostr cannot encrypt: %v", err)
+	}
+	decrypted, err := nip04Decrypt(payload, keys.peerPub, keys.ours)
+	if err != nil {
+		return err
+	}
+	return selftestSame(plaintext, decrypted)
+}
+
+// selftestNcryptsec writes an ncryptsec backup and decrypts it with go-nostr
+func selftestNcryptsec(keys *selftestKeys) error {
+	secretKey := keys.ours.Serialize()
+	ncryptsec, err := encryptNcryptsec(secretKey, selftestPassword)
+	if err != nil {
+		return err
+	}
+	decrypted, err := nip49.Decrypt(ncryptsec, selftestPassword)
+	if err != nil {
+		return fmt.Errorf("go-nostr cannot decrypt: %v", err)
+	}
+	if decrypted != hex.EncodeToString(secretKey) {
+		return fmt.Errorf("go-nostr decrypts a different key")
+	}
+	return nil
+}
+
+// selftestSame compares a round trip's result with its input
+func selftestSame(want, got string) error {
+	if got != want {
+		return fmt.Errorf("round trip changed the text (%d bytes in, %d out)", len(want), len(got))
+	}
+	return nil
+}
+
+// printSelftest prints the matrix and the failures; it returns the number
+// of failed checks
+func printSelftest(groups []selftestGroup) int {
+	var failures []string
+	for _, group := range groups {
+		fmt.Printf("%-30s", group.Name)
+		for _, column := range group.Columns {
+			fmt.Printf(" %-15s", column)
+		}
+		fmt.Println()
+		for _, row := range group.Rows {
+			fmt.Printf("  %-28s", row.Name)
+			for i, cell := range row.Cells {
+				// The marks are two columns wide on the terminal, "-" one
+				mark := "✅"
+				switch {
+				case cell.skip:
+					mark = "- "
+				case cell.err != nil:
+					mark = "❌"
+					failures = append(failures, fmt.Sprintf("%s / %s / %s: %v", group.Name, row.Name, group.Columns[i], cell.err))
+				}
+				fmt.Printf(" %s%13s", mark, "")
+			}
+			fmt.Println()
+		}
+		fmt.Println()
+	}
+
+	for _, failure := range failures {
+		fmt.Printf("❌ %s\n", failure)
+	}
+	return len(failures)
+}
+
+// selftestCmd checks noorsigner's event serialization, signing and
+// encryption against go-nostr with a throwaway key. No account is touched.
+func selftestCmd(args []string) error {
+	if len(args) != 0 {
+		return commandFailed(1, "Usage: noorsigner selftest")
+	}
+
+	keys, err := newSelftestKeys()
+	if err != nil {
+		return commandFailed(1, "❌ Cannot generate a test key: %v", err)
+	}
+	fmt.Println("🧪 Interoperability self-test against go-nostr (random throwaway keys)")
+	fmt.Println()
+
+	groups := runSelftest(keys)
+	failures := printSelftest(groups)
+	keys.ours.Zero()
+	keys.peer.Zero()
+
+	checks := 0
+	for _, group := range groups {
+		for _, row := range group.Rows {
+			for _, cell := range row.Cells {
+				if !cell.skip {
+					checks++
+				}
+			}
+		}
+	}
+	if failures > 0 {
+		fmt.Println()
+		return commandFailed(1, "❌ %d of %d checks failed", failures, checks)
+	}
+	fmt.Printf("✅ All %d checks passed\n", checks)
+	return nil
+}
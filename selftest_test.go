@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Every check of the self-test passes, for several random key pairs (half
+// of all keys have an odd y coordinate, which x-only encodings drop)
+func TestSelftest(t *testing.T) {
+	for round := 0; round < 4; round++ {
+		keys, err := newSelftestKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, group := range runSelftest(keys) {
+			for _, row := range group.Rows {
+				if len(row.Cells) != len(group.Columns) {
+					t.Fatalf("%s / %s: %d checks for %d columns", group.Name, row.Name, len(row.Cells), len(group.Columns))
+				}
+				for i, cell := range row.Cells {
+					if cell.err != nil {
+						t.Errorf("%s / %s / %s: %v", group.Name, row.Name, group.Columns[i], cell.err)
+					}
+				}
+			}
+		}
+		keys.ours.Zero()
+		keys.peer.Zero()
+	}
+}
+
+func TestSelftestCmd(t *testing.T) {
+	var err error
+	output := captureStdout(t, func() { err = selftestCmd(nil) })
+	if err != nil {
+		t.Fatalf("selftest: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "✅ All ") || strings.Contains(output, "❌") {
+		t.Errorf("selftest output:\n%s", output)
+	}
+	if err := selftestCmd([]string{"extra"}); exitCode(err) != 1 {
+		t.Errorf("selftest with an argument: %v, want exit code 1", err)
+	}
+}
+
+// A failed check is marked in the matrix and listed with its error;
+// skipped checks are not counted
+func TestPrintSelftestFailures(t *testing.T) {
+	groups := []selftestGroup{{
+		Name:    "Events",
+		Columns: []string{"id", "sig"},
+		Rows: []selftestRow{
+			{Name: "good", Cells: []selftestCell{{}, {skip: true}}},
+			{Name: "bad", Cells: []selftestCell{{err: errors.New("id mismatch")}, {}}},
+		},
+	}}
+	var failures int
+	output := captureStdout(t, func() { failures = printSelftest(groups) })
+	if failures != 1 {
+		t.Errorf("printSelftest counted %d failures, want 1", failures)
+	}
+	if !strings.Contains(output, "❌ Events / bad / id: id mismatch") {
+		t.Errorf("failure not listed:\n%s", output)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// maxEventInputSize bounds the event JSON read by sign
+const maxEventInputSize = 1 << 20
+
+// signCmd signs an unsigned event from stdin or --file with the active
+// account and prints the completed event. --test signs a fixed test hash.
+func signCmd(args []string) error {
+	usage := "noorsigner sign [--file <path|->] [--test] " + passwordFlagsUsage
+	args, err := passwordArgs(args, usage)
+	if err != nil {
+		return err
+	}
+
+	file := "-"
+	test := false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--file" && i+1 < len(args):
+			i++
+			file = args[i]
+		case strings.HasPrefix(args[i], "--file="):
+			file = strings.TrimPrefix(args[i], "--file=")
+		case args[i] == "--test":
+			test = true
+		default:
+			return commandFailed(1, "Usage: %s", usage)
+		}
+	}
+
+	if test {
+		return signWithStoredKey()
+	}
+	return signEventCmd(file)
+}
+
+// signEventCmd reads an unsigned event, fills in pubkey, id and sig and
+// prints it as one line of JSON on stdout
+func signEventCmd(file string) error {
+	// stdout carries only the signed event
+	redirectChatter()
+
+	activeNpub, activePubkey, err := loadActiveSigner()
+	if err != nil {
+		return err
+	}
+
+	if file == "-" {
+		// The event takes stdin, so the password has to come from elsewhere
+		if passwordSource == nil && !stdinIsInteractive() {
+			return commandFailed(1, "The event is read from stdin, so the password can't be.\n"+
+				"Use --password-file, --password-fd or NOORSIGNER_PASSWORD, or pass the event with --file.")
+		}
+		if stdinIsInteractive() {
+			fmt.Println("Paste the unsigned event JSON, then press Ctrl-D (Ctrl-Z, Enter on Windows):")
+		}
+	}
+
+	input, err := readEventInput(file)
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if _, err := signEventInput(activeNpub, activePubkey, input, "sign"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadActiveSigner returns the active account's npub and hex pubkey
+func loadActiveSigner() (string, string, error) {
+	activeNpub, err := loadActiveAccount()
+	if err != nil {
+		return "", "", commandFailed(1, "No active account. Use 'add-account' to add one.")
+	}
+	activePubkey, err := npubToPubkey(activeNpub)
+	if err != nil {
+		return "", "", commandFailed(1, "Invalid active account: %v", err)
+	}
+	return activeNpub, activePubkey, nil
+}
+
+// signEventInput validates an unsigned event, signs it with the active
+// account after asking for its password, prints the completed event and
+// returns it. action names the operation in audit.log.
+func signEventInput(activeNpub, activePubkey string, input []byte, action string) (*NostrEvent, error) {
+	// Refuse before asking for the password
+	eventJSON, err := prepareEventForSigning(input, activePubkey)
+	if err != nil {
+		return nil, commandFailed(1, "❌ %v", err)
+	}
+	diagnostics := validateEvent(eventJSON, appConfig.eventLimits())
+	if !diagnostics.Valid {
+		var messages []string
+		for _, problem := range diagnostics.Problems {
+			messages = append(messages, problem.Message)
+		}
+		auditCLI(action, activeNpub, diagnostics.err(), eventKind(eventJSON))
+		return nil, commandFailed(1, "❌ Refusing to sign: %s", strings.Join(messages, "; "))
+	}
+	eventHash, err := createEventHash(eventJSON)
+	if err != nil {
+		return nil, commandFailed(1, "❌ Invalid event: %v", err)
+	}
+
+	if kind := eventKind(eventJSON); kind != nil {
+		fmt.Printf("Event: %s\n", describeKind(*kind))
+		if err := confirmPolicyCLI(*kind, action, activeNpub); err != nil {
+			return nil, err
+		}
+	}
+	privateKey, err := unlockActiveAccount(activeNpub)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Signing as: %s\n", displayNpub(activeNpub))
+
+	signature, err := signNostrEvent(privateKey, eventHash)
+	auditCLI(action, activeNpub, err, eventKind(eventJSON))
+	if err != nil {
+		return nil, commandFailed(1, "Error signing: %v", err)
+	}
+
+	event, err := completeEvent(eventJSON, eventHash, signature)
+	if err != nil {
+		return nil, commandFailed(1, "❌ Invalid event: %v", err)
+	}
+	printJSON(event)
+	return event, nil
+}
+
+// readEventInput reads the event JSON from path ("-" = stdin)
+func readEventInput(path string) ([]byte, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open event file: %v", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	input, err := io.ReadAll(io.LimitReader(r, maxEventInputSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read event: %v", err)
+	}
+	if len(input) > maxEventInputSize {
+		return nil, fmt.Errorf("event is larger than %d bytes", maxEventInputSize)
+	}
+	return input, nil
+}
+
+// prepareEventForSigning fills in the active account's pubkey (and
+// created_at if missing) and refuses events that name a different pubkey.
+// Existing id and sig are dropped.
+func prepareEventForSigning(input []byte, activePubkey string) (string, error) {
+	var event map[string]interface{}
+	if err := json.Unmarshal(input, &event); err != nil {
+		return "", fmt.Errorf("invalid event JSON: %v", err)
+	}
+	if event == nil {
+		return "", fmt.Errorf("invalid event JSON: not an object")
+	}
+
+	if pubkey, present := event["pubkey"]; present {
+		pubkeyString, ok := pubkey.(string)
+		if !ok || !strings.EqualFold(pubkeyString, activePubkey) {
+			return "", fmt.Errorf("event pubkey %v is not the active account (%s) - refusing to sign", pubkey, activePubkey)
+		}
+	}
+	event["pubkey"] = activePubkey
+	fillEventDefaults(event, activePubkey)
+	delete(event, "id")
+	delete(event, "sig")
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("invalid event JSON: %v", err)
+	}
+	return string(eventJSON), nil
+}
+
+// unlockActiveAccount asks for the active account's password and returns
+// its private key. A wrong password fails the command.
+func unlockActiveAccount(activeNpub string) (*btcec.PrivateKey, error) {
+	if err := refuseIfFrozen(); err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := loadAccountEncryptedKey(activeNpub)
+	if err != nil {
+		return nil, commandFailed(1, "Error loading key: %v", err)
+	}
+
+	if err := refuseIfLockedOut(activeNpub); err != nil {
+		return nil, err
+	}
+
+	password, err := readAccountPassword("Enter password: ")
+	if err != nil {
+		return nil, commandFailed(1, "Error reading password: %v", err)
+	}
+
+	// A wrong password decrypts to garbage
+	_, privateKey, err := decryptAccountKey(encryptedKey, activeNpub, password)
+	if err != nil {
+		return nil, commandFailed(1, "%s", passwordFailureMessage(err))
+	}
+	return privateKey, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import "fmt"
+
+// Limits for sign_events. Larger imports are split into several batches.
+const (
+	maxSignBatchEvents = 500
+	maxSignBatchBytes  = 4 * 1024 * 1024 // Sum of the event JSON lengths
+)
+
+// SignBatchResult is the outcome for one event of a sign_events request:
+// id, sig and the completed event, or the error (and code) for that event
+type SignBatchResult struct {
+	ID    string      `json:"id,omitempty"`
+	Sig   string      `json:"sig,omitempty"`
+	Event *NostrEvent `json:"event,omitempty"`
+	Error string      `json:"error,omitempty"`
+	Code  string      `json:"code,omitempty"`
+}
+
+// SignBatchResponse represents sign_events response. Results are in the
+// same order as the request events.
+type SignBatchResponse struct {
+	ID      string            `json:"id"`
+	Results []SignBatchResult `json:"results,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Code    string            `json:"code,omitempty"`
+}
+
+// checkSignBatch enforces the batch size limits
+func checkSignBatch(events []string) error {
+	if len(events) == 0 {
+		return fmt.Errorf("events required")
+	}
+	if len(events) > maxSignBatchEvents {
+		return fmt.Errorf("batch too large: %d events (max %d)", len(events), maxSignBatchEvents)
+	}
+
+	total := 0
+	for _, event := range events {
+		total += len(event)
+	}
+	if total > maxSignBatchBytes {
+		return fmt.Errorf("batch too large: %d bytes (max %d)", total, maxSignBatchBytes)
+	}
+
+	return nil
+}
+
+// signBatch signs each event with key as sign_event would. A failing event
+// only sets its own error.
+func (d *Daemon) signBatch(key *accountKey, events []string) []SignBatchResult {
+	results := make([]SignBatchResult, len(events))
+	for i, eventJSON := range events {
+		event, err := d.signEventAs(key, eventJSON)
+		if err != nil {
+			results[i].Error = redactedError(err)
+			results[i].Code = errorCode(err)
+			continue
+		}
+		results[i] = SignBatchResult{ID: event.ID, Sig: event.Sig, Event: event}
+	}
+	return results
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// signBatchEvents returns n unsigned kind 1 events with tags tags each
+func signBatchEvents(tb testing.TB, n, tags int) []string {
+	tb.Helper()
+	events := make([]string, n)
+	for i := range events {
+		eventTags := make([][]string, tags)
+		for j := range eventTags {
+			eventTags[j] = []string{"p", fmt.Sprintf("%064x", j)}
+		}
+		data, err := json.Marshal(map[string]interface{}{
+			"kind":       1,
+			"created_at": 1700000000 + i,
+			"tags":       eventTags,
+			"content":    fmt.Sprintf("note %d <with> & html", i),
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		events[i] = string(data)
+	}
+	return events
+}
+
+func TestSignBatch(t *testing.T) {
+	d := newDaemon(&Config{}, true, defaultRequestTimeout, "")
+	key := newTestAccountKey(t)
+	events := signBatchEvents(t, 20, 3)
+	events[5] = `{"kind":1,"content":"not an event"`
+	events[8] = `{"kind":1,"created_at":1700000000,"tags":[],"content":"someone else","pubkey":"` + testEventPubkey + `"}`
+
+	results := d.signBatch(key, events)
+	if len(results) != len(events) {
+		t.Fatalf("%d results for %d events", len(results), len(events))
+	}
+	for i, result := range results {
+		if i == 5 || i == 8 {
+			if result.Error == "" || result.Code == "" || result.Event != nil {
+				t.Errorf("event %d: %+v, want only an error and its code", i, result)
+			}
+			continue
+		}
+		if result.Error != "" {
+			t.Errorf("event %d: %s", i, result.Error)
+			continue
+		}
+		if result.ID != result.Event.ID || result.Sig != result.Event.Sig {
+			t.Errorf("event %d: id and sig differ from the event's", i)
+		}
+
+		// go-nostr must compute the same id and accept the signature
+		var event nostr.Event
+		data, _ := json.Marshal(result.Event)
+		if err := json.Unmarshal(data, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.PubKey != key.pubkey {
+			t.Errorf("event %d: pubkey %s, want the account's", i, event.PubKey)
+		}
+		if event.GetID() != event.ID {
+			t.Errorf("event %d: id %s, go-nostr computes %s", i, event.ID, event.GetID())
+		}
+		if ok, err := event.CheckSignature(); !ok {
+			t.Errorf("event %d: invalid signature: %v", i, err)
+		}
+	}
+}
+
+func TestCheckSignBatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		ok     bool
+	}{
+		{"empty", nil, false},
+		{"one event", []string{"{}"}, true},
+		{"too many events", make([]string, maxSignBatchEvents+1), false},
+		{"too many bytes", []string{string(make([]byte, maxSignBatchBytes+1))}, false},
+	}
+	for _, tt := range tests {
+		if err := checkSignBatch(tt.events); (err == nil) != tt.ok {
+			t.Errorf("%s: checkSignBatch = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+// benchmarkSignBatch signs a batch of size events with tags tags each per
+// iteration
+func benchmarkSignBatch(b *testing.B, size, tags int) {
+	d := newDaemon(&Config{}, true, defaultRequestTimeout, "")
+	key := newTestAccountKey(b)
+	events := signBatchEvents(b, size, tags)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, result := range d.signBatch(key, events) {
+			if result.Error != "" {
+				b.Fatal(result.Error)
+			}
+		}
+	}
+}
+
+func BenchmarkSignBatch10(b *testing.B)             { benchmarkSignBatch(b, 10, 2) }
+func BenchmarkSignBatch500(b *testing.B)            { benchmarkSignBatch(b, maxSignBatchEvents, 2) }
+func BenchmarkSignBatch500With100Tags(b *testing.B) { benchmarkSignBatch(b, maxSignBatchEvents, 100) }
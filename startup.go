@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// startupPhase names one step of daemon startup. Each phase has its own exit
+// code so supervisors can tell e.g. "bad password" from "socket bind failed"
+// (systemd: RestartPreventExitStatus=, SuccessExitStatus=).
+type startupPhase struct {
+	Name     string
+	ExitCode int
+}
+
+var (
+	phaseInstanceCheck = startupPhase{"instance check", 10}
+	phaseActiveAccount = startupPhase{"load active account", 11}
+	phaseLoadKey       = startupPhase{"load key", 12}
+	phaseTrustSession  = startupPhase{"trust session", 13}
+	phaseUnlock        = startupPhase{"unlock", 14}
+	phaseListener      = startupPhase{"listener", 15}
+	phaseFork          = startupPhase{"fork", 16}
+	phaseMemoryLock    = startupPhase{"memory lock", 17}
+)
+
+// Known startup failure classes. Phases wrap them with %w so callers can
+// use errors.Is to pick a remediation.
+var (
+	errDaemonRunning       = errors.New("daemon already running")
+	errNoActiveAccount     = errors.New("no usable active account")
+	errKeyUnreadable       = errors.New("cannot load account key")
+	errTrustSessionInvalid = errors.New("trust session is corrupted")
+	errPasswordUnavailable = errors.New("cannot read password")
+	errInvalidPassword     = errors.New("invalid password")
+	errKeyCorrupted        = errors.New("decrypted key is invalid")
+	errListenFailed        = errors.New("cannot listen for clients")
+	errForkFailed          = errors.New("cannot start background process")
+	errMemoryLock          = errors.New("cannot lock memory")
+)
+
+// startupRemediations suggests a fix for each known failure class
+var startupRemediations = []struct {
+	err  error
+	hint string
+}{
+	{errDaemonRunning, "Another daemon is serving already. Stop it first (pkill noorsigner) or just use it."},
+	{errNoActiveAccount, "Add an account with 'noorsigner add-account' or pick one with 'noorsigner switch <npub>'."},
+	{errKeyUnreadable, "Check ~/.noorsigner/accounts/<npub>/keys.encrypted exists and is readable; restore it from backup if it is damaged."},
+	{errTrustSessionInvalid, "The cached trust session was removed - start the daemon again and enter your password."},
+	{errPasswordUnavailable, "Run the daemon from a terminal, or use 'noorsigner pending' / 'noorsigner respond <nonce>' to unlock a headless daemon."},
+	{errInvalidPassword, "Re-run 'noorsigner daemon' and enter the password for this account."},
+	{errKeyCorrupted, "The key file does not decrypt to a valid key - restore keys.encrypted from backup."},
+	{errPasswordDisabled, "Password unlock is off for this account: start the daemon from a terminal with the security key attached, or unlock a headless daemon with 'noorsigner fido unlock'."},
+	{errLockedOut, "Wait until the lockout ends - every further wrong password doubles it (see lockout.json)."},
+	{errSignerFrozen, "Wait until the freeze ends, or lift it with 'noorsigner unfreeze' and the unfreeze passphrase."},
+	{errListenFailed, "Check the socket directory is writable and no other process holds the socket or pipe."},
+	{errForkFailed, "Start with 'noorsigner daemon --foreground' to run without forking."},
+	{errMemoryLock, "Strict mode locks the daemon's memory: raise the memlock limit (ulimit -l unlimited, systemd LimitMEMLOCK=infinity) or run on Linux or macOS."},
+}
+
+// StartupError is a failure in a named startup phase
+type StartupError struct {
+	Phase startupPhase
+	Err   error
+}
+
+func (e *StartupError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Phase.Name, e.Err)
+}
+
+func (e *StartupError) Unwrap() error {
+	return e.Err
+}
+
+// startupFailure wraps a failure class and its cause into a StartupError
+func startupFailure(phase startupPhase, class error, cause error) error {
+	if cause == nil {
+		return &StartupError{Phase: phase, Err: class}
+	}
+	return &StartupError{Phase: phase, Err: fmt.Errorf("%w: %v", class, cause)}
+}
+
+// startupExitCode returns the exit code for a startup failure (1 if unknown)
+func startupExitCode(err error) int {
+	var startupErr *StartupError
+	if errors.As(err, &startupErr) {
+		return startupErr.Phase.ExitCode
+	}
+	return 1
+}
+
+// reportStartupFailure logs a phase-labeled summary with a suggested fix
+func reportStartupFailure(err error) {
+	var startupErr *StartupError
+	if !errors.As(err, &startupErr) {
+		logError("❌ Daemon failed to start: %v", err)
+		return
+	}
+
+	logError("❌ Daemon failed to start")
+	logError("   Phase:  %s (exit code %d)", startupErr.Phase.Name, startupErr.Phase.ExitCode)
+	logError("   Reason: %v", startupErr.Err)
+	for _, remediation := range startupRemediations {
+		if errors.Is(err, remediation.err) {
+			logError("   Fix:    %s", remediation.hint)
+			break
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// Each way startup fails ends in its phase's exit code, with a class that
+// has a suggested fix. The memory lock and the fork can't be made to fail
+// from a test.
+func TestStartupFailureExitCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, npub string) // npub is the active account
+		answers []string                        // Prompted for
+		http    string
+		phase   startupPhase
+		class   error
+	}{
+		{
+			name: "already running",
+			setup: func(t *testing.T, npub string) {
+				if err := writePidFile(); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(removePidFile)
+			},
+			phase: phaseInstanceCheck, class: errDaemonRunning,
+		},
+		{
+			name: "no account and init fails",
+			setup: func(t *testing.T, npub string) {
+				testHome(t)
+			},
+			phase: phaseActiveAccount, class: errNoActiveAccount,
+		},
+		{
+			name: "key file damaged",
+			setup: func(t *testing.T, npub string) {
+				store, err := accountStore()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := store.WriteRecords(npub, storeRecord{Name: recordKey, Data: []byte("garbage")}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			phase: phaseLoadKey, class: errKeyUnreadable,
+		},
+		{
+			name: "trust session holds no key",
+			setup: func(t *testing.T, npub string) {
+				session, err := createTrustSession("not an nsec")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := saveAccountTrustSession(npub, session); err != nil {
+					t.Fatal(err)
+				}
+			},
+			phase: phaseTrustSession, class: errTrustSessionInvalid,
+		},
+		{
+			name: "frozen",
+			setup: func(t *testing.T, npub string) {
+				if err := freezeCmd(&scriptedPrompter{answers: []string{testPassphrase, testPassphrase}}, []string{"--until", "1h"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			phase: phaseUnlock, class: errSignerFrozen,
+		},
+		{
+			name:    "wrong password",
+			answers: []string{"Wrong-Horse-Battery-7"},
+			phase:   phaseUnlock, class: errInvalidPassword,
+		},
+		{
+			name: "locked out",
+			setup: func(t *testing.T, npub string) {
+				for checkLockout(npub) == nil {
+					if err := recordPasswordFailure(npub); err != nil && !errors.Is(err, errLockedOut) {
+						t.Fatal(err)
+					}
+				}
+			},
+			phase: phaseUnlock, class: errLockedOut,
+		},
+		{
+			name:  "password unreadable",
+			phase: phaseUnlock, class: errPasswordUnavailable,
+		},
+		{
+			name: "socket directory missing",
+			setup: func(t *testing.T, npub string) {
+				if runtime.GOOS == "windows" {
+					t.Skip("a named pipe needs no directory")
+				}
+				freezeTestAccount(t, npub)
+				appConfig.SocketPath = filepath.Join(t.TempDir(), "missing", "noorsigner.sock")
+			},
+			phase: phaseListener, class: errListenFailed,
+		},
+		{
+			name: "HTTP address unusable",
+			setup: func(t *testing.T, npub string) {
+				freezeTestAccount(t, npub)
+			},
+			http:  "127.0.0.1:99999",
+			phase: phaseListener, class: errListenFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHome(t)
+			appConfig.TrustTokenStore = trustTokenFile
+			npub := addTestAccount(t, "")
+			// As if started from a terminal, which the prompter stands for
+			passwordSource = func() (string, error) { return "", errors.New("not used") }
+			t.Cleanup(func() { passwordSource = nil })
+			if tt.setup != nil {
+				tt.setup(t, npub)
+			}
+
+			prompt := &scriptedPrompter{answers: tt.answers}
+			err := runDaemon(appConfig, prompt, true, false, false, false, tt.http)
+			var startupErr *StartupError
+			if !errors.As(err, &startupErr) {
+				t.Fatalf("runDaemon: %v, want a StartupError", err)
+			}
+			if startupErr.Phase != tt.phase || !errors.Is(err, tt.class) {
+				t.Fatalf("runDaemon: %v in phase %q, want %v in %q", err, startupErr.Phase.Name, tt.class, tt.phase.Name)
+			}
+			if code := startupExitCode(err); code != tt.phase.ExitCode {
+				t.Errorf("exit code %d, want %d", code, tt.phase.ExitCode)
+			}
+			hinted := false
+			for _, remediation := range startupRemediations {
+				hinted = hinted || errors.Is(err, remediation.err)
+			}
+			if !hinted {
+				t.Errorf("no suggested fix for %v", err)
+			}
+		})
+	}
+
+	// Each phase has its own code, and other errors exit with 1
+	codes := make(map[int]string)
+	for _, phase := range []startupPhase{phaseInstanceCheck, phaseActiveAccount, phaseLoadKey, phaseTrustSession, phaseUnlock, phaseListener, phaseFork, phaseMemoryLock} {
+		if other, dup := codes[phase.ExitCode]; dup {
+			t.Errorf("phases %q and %q share exit code %d", other, phase.Name, phase.ExitCode)
+		}
+		codes[phase.ExitCode] = phase.Name
+	}
+	if code := startupExitCode(errors.New("something else")); code != 1 {
+		t.Errorf("exit code of an unphased error %d, want 1", code)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBounds are the upper bounds of the latency histogram's buckets. A
+// last bucket takes everything slower.
+var latencyBounds = [...]time.Duration{
+	100 * time.Microsecond, 200 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+}
+
+// latencyHistogram counts request handling times per bucket. Recording is
+// one atomic increment, so the request path never waits on a lock for it.
+type latencyHistogram struct {
+	buckets [len(latencyBounds) + 1]atomic.Uint64
+}
+
+// record adds one handling time
+func (h *latencyHistogram) record(elapsed time.Duration) {
+	i := sort.Search(len(latencyBounds), func(i int) bool { return elapsed <= latencyBounds[i] })
+	h.buckets[i].Add(1)
+}
+
+// stats returns the count and the p50 and p95 latencies. A percentile is
+// the upper bound of the bucket it falls into, so it is at most 2.5 times
+// too high; past the last bound it is reported as that bound.
+func (h *latencyHistogram) stats() LatencyStats {
+	var counts [len(latencyBounds) + 1]uint64
+	var total uint64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+
+	percentile := func(q float64) float64 {
+		rank := uint64(q*float64(total) + 0.5)
+		var seen uint64
+		for i, count := range counts {
+			seen += count
+			if seen >= rank && seen > 0 {
+				return durationMs(latencyBounds[min(i, len(latencyBounds)-1)])
+			}
+		}
+		return 0
+	}
+	return LatencyStats{Count: total, P50Ms: percentile(0.50), P95Ms: percentile(0.95)}
+}
+
+// durationMs converts d to milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// LatencyStats summarizes how long requests took to handle
+type LatencyStats struct {
+	Count uint64  `json:"count"`
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+}
+
+// StatsResponse represents stats response
+type StatsResponse struct {
+	ID            string `json:"id"`
+	StartedAt     int64  `json:"started_at"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	// Requests counts requests by method, Errors failed responses by code
+	// ("other" for errors without one)
+	TotalRequests uint64            `json:"total_requests"`
+	Requests      map[string]uint64 `json:"requests"`
+	TotalErrors   uint64            `json:"total_errors"`
+	Errors        map[string]uint64 `json:"errors"`
+	Connections   *ConnectionStats  `json:"connections"`
+	// Latency is the handling time of requests, from the request being read
+	// to its response being written
+	Latency LatencyStats `json:"latency"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// stats reports the request counters since the daemon started
+func (d *Daemon) stats(id string) StatsResponse {
+	response := StatsResponse{
+		ID:            id,
+		StartedAt:     d.metrics.startTime.Unix(),
+		UptimeSeconds: int64(time.Since(d.metrics.startTime) / time.Second),
+		Requests:      make(map[string]uint64),
+		Errors:        make(map[string]uint64),
+		Connections:   d.connections.stats(),
+		Latency:       d.metrics.latency.stats(),
+	}
+
+	d.metrics.mu.Lock()
+	for method, count := range d.metrics.requests {
+		response.Requests[method] = count
+		response.TotalRequests += count
+	}
+	for code, count := range d.metrics.errors {
+		response.Errors[code] = count
+		response.TotalErrors += count
+	}
+	d.metrics.mu.Unlock()
+
+	return response
+}
+
+// getStatsViaDaemon asks the daemon for its statistics
+func getStatsViaDaemon() (*StatsResponse, error) {
+	var response StatsResponse
+	if err := daemonRequest(context.Background(), SignRequest{Method: "stats"}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// printStats prints the daemon's statistics for status --stats
+func printStats(stats *StatsResponse) {
+	fmt.Println()
+	fmt.Printf("Statistics (up %s):\n", describeDuration(time.Duration(stats.UptimeSeconds)*time.Second))
+	fmt.Printf("  Requests:   %d", stats.TotalRequests)
+	if stats.Latency.Count > 0 {
+		fmt.Printf(" (p50 %s, p95 %s)", formatMs(stats.Latency.P50Ms), formatMs(stats.Latency.P95Ms))
+	}
+	fmt.Println()
+	printCounts(stats.Requests)
+	fmt.Printf("  Errors:     %d\n", stats.TotalErrors)
+	printCounts(stats.Errors)
+}
+
+// printCounts prints counters, largest first
+func printCounts(counts map[string]uint64) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
+		fmt.Printf("    %-28s %d\n", key, counts[key])
+	}
+}
+
+// formatMs renders a latency in milliseconds
+func formatMs(ms float64) string {
+	if ms < 1 {
+		return fmt.Sprintf("%.1fms", ms)
+	}
+	return fmt.Sprintf("%.0fms", ms)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// StatusResponse represents get_status response
+type StatusResponse struct {
+	ID         string `json:"id"`
+	Version    string `json:"version"`
+	PID        int    `json:"pid"`
+	StartedAt  int64  `json:"started_at"`
+	Npub       string `json:"npub,omitempty"`
+	Label      string `json:"label,omitempty"`
+	IsUnlocked bool   `json:"is_unlocked"`
+	TrustMode  bool   `json:"trust_mode"`
+	// UnlockedAccounts are kept unlocked besides npub (see accountselect.go)
+	UnlockedAccounts []string `json:"unlocked_accounts,omitempty"`
+	// Trust session deadlines (see trustidle.go)
+	TrustExpiresAt     int64  `json:"trust_expires_at,omitempty"`
+	TrustIdleExpiresAt int64  `json:"trust_idle_expires_at,omitempty"`
+	TrustHardLimitAt   int64  `json:"trust_hard_limit_at,omitempty"`
+	Socket             string `json:"socket"`
+	// Drain mode (see drain.go)
+	Draining bool  `json:"draining"`
+	InFlight int64 `json:"in_flight"`
+	// Connection counts against max_connections (see connlimit.go)
+	Connections *ConnectionStats `json:"connections,omitempty"`
+	// Freeze in effect (see freeze.go); frozen_until 0 = freeze.json unreadable
+	Frozen      bool  `json:"frozen"`
+	FrozenUntil int64 `json:"frozen_until,omitempty"`
+	// Settings the daemon is running with, defaults filled in
+	Config         map[string]string `json:"config"`
+	ConfigWarnings []string          `json:"config_warnings,omitempty"`
+	// Security is the observed access control (see security.go)
+	Security *SecuritySummary `json:"security,omitempty"`
+	// RecentRequests lists who asked for what, oldest first (see clientident.go)
+	RecentRequests []RecentRequest `json:"recent_requests,omitempty"`
+	// RateLimits counts each client's requests against the rate limits
+	// (see ratelimit.go)
+	RateLimits []RateLimitStats `json:"rate_limits,omitempty"`
+	// AccountWatch is how the daemon notices account changes: "fsnotify"
+	// or "polling" (see accountwatch.go)
+	AccountWatch string `json:"account_watch,omitempty"`
+	// LockHistory lists why the daemon locked, oldest first (see locklog.go)
+	LockHistory []LockEvent `json:"lock_history,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// status reports the daemon's state and effective configuration
+func (d *Daemon) status(id string) StatusResponse {
+	d.mu.RLock()
+	npub := d.npub
+	unlocked := d.privateKey != nil
+	d.mu.RUnlock()
+
+	socketPath, _ := getSocketPath()
+	frozen, frozenUntil := freezeStatus()
+	config := d.config.effective()
+	d.policy.fillConfigValues(config)
+	var trustExpiresAt, trustIdleExpiresAt, trustHardLimitAt int64
+	if unlocked {
+		trustExpiresAt, trustIdleExpiresAt, trustHardLimitAt = d.trustDeadlines(npub)
+	}
+
+	return StatusResponse{
+		ID:                 id,
+		Version:            version,
+		PID:                os.Getpid(),
+		StartedAt:          d.metrics.startTime.Unix(),
+		Npub:               npub,
+		Label:              accountLabel(npub),
+		IsUnlocked:         unlocked,
+		UnlockedAccounts:   d.unlockedAccounts(),
+		TrustMode:          !d.noTrust,
+		TrustExpiresAt:     trustExpiresAt,
+		TrustIdleExpiresAt: trustIdleExpiresAt,
+		TrustHardLimitAt:   trustHardLimitAt,
+		Socket:             socketPath,
+		Draining:           d.draining.Load(),
+		InFlight:           d.inFlight.Load(),
+		Connections:        d.connections.stats(),
+		Frozen:             frozen,
+		FrozenUntil:        frozenUntil,
+		Config:             config,
+		ConfigWarnings:     d.config.Warnings,
+		Security:           d.securitySummary(),
+		RecentRequests:     d.requests.snapshot(),
+		RateLimits:         d.rateLimits.stats(),
+		AccountWatch:       d.accountWatch.watchMode(),
+		LockHistory:        d.locks.snapshot(),
+	}
+}
+
+// statusCmd prints the running daemon's status and effective configuration.
+// --verbose adds the lock history, --stats the request statistics.
+func statusCmd(args []string) error {
+	var verbose, withStats bool
+	for _, arg := range args {
+		switch arg {
+		case "--verbose":
+			verbose = true
+		case "--stats":
+			withStats = true
+		default:
+			return commandFailed(1, "Usage: noorsigner status [--verbose] [--stats]")
+		}
+	}
+
+	status, err := getStatusViaDaemon()
+	if errors.Is(err, errDaemonUnauthorized) {
+		// Running, but this CLI can't ask it anything
+		return commandFailed(1, "🔒 Daemon running, but it refused the request: %v", err)
+	}
+	var stats *StatsResponse
+	if err == nil && withStats {
+		if stats, err = getStatsViaDaemon(); err != nil {
+			return commandFailed(1, "❌ Cannot get statistics: %v", err)
+		}
+	}
+	if jsonOutput {
+		if err != nil {
+			frozen, frozenUntil := freezeStatus()
+			printJSON(StatusOutput{
+				Frozen:         frozen,
+				FrozenUntil:    frozenUntil,
+				Config:         appConfig.effective(),
+				ConfigWarnings: appConfig.Warnings,
+			})
+			return nil
+		}
+		printJSON(StatusOutput{
+			Running:            true,
+			Version:            status.Version,
+			PID:                status.PID,
+			StartedAt:          status.StartedAt,
+			Npub:               status.Npub,
+			Label:              status.Label,
+			IsUnlocked:         status.IsUnlocked,
+			TrustMode:          status.TrustMode,
+			TrustExpiresAt:     status.TrustExpiresAt,
+			TrustIdleExpiresAt: status.TrustIdleExpiresAt,
+			TrustHardLimitAt:   status.TrustHardLimitAt,
+			Socket:             status.Socket,
+			Draining:           status.Draining,
+			InFlight:           status.InFlight,
+			Connections:        status.Connections,
+			Frozen:             status.Frozen,
+			FrozenUntil:        status.FrozenUntil,
+			Config:             status.Config,
+			ConfigWarnings:     status.ConfigWarnings,
+			Security:           status.Security,
+			RecentRequests:     status.RecentRequests,
+			RateLimits:         status.RateLimits,
+			AccountWatch:       status.AccountWatch,
+			LockHistory:        status.LockHistory,
+			Stats:              stats,
+		})
+		return nil
+	}
+	if err != nil {
+		fmt.Println("⚪ Daemon not running")
+		if frozen, frozenUntil := freezeStatus(); frozen {
+			printFreezeLine(frozenUntil)
+		} else {
+			fmt.Println("   Start with: noorsigner daemon")
+		}
+		fmt.Println()
+		configListCmd()
+		return nil
+	}
+
+	lockState := "🔒 locked"
+	if status.IsUnlocked {
+		lockState = "🔓 unlocked"
+	}
+	var lastLock *LockEvent
+	if n := len(status.LockHistory); n > 0 && !status.IsUnlocked {
+		lastLock = &status.LockHistory[n-1]
+	}
+	trustMode := "on"
+	if !status.TrustMode {
+		trustMode = "off (--no-trust)"
+		if status.Security != nil && status.Security.Strict {
+			trustMode = "off (strict mode)"
+		}
+	}
+
+	fmt.Printf("🟢 Daemon running (PID %d, version %s)\n", status.PID, status.Version)
+	fmt.Printf("   Since:      %s\n", time.Unix(status.StartedAt, 0).Format("2006-01-02 15:04:05"))
+	if status.Npub != "" {
+		fmt.Printf("   Account:    %s (%s)\n", labeledNpub(status.Npub, status.Label), lockState)
+	} else {
+		fmt.Printf("   Account:    none (%s)\n", lockState)
+	}
+	if lastLock != nil {
+		fmt.Printf("   Locked:     %s at %s", lastLock.Reason, time.Unix(lastLock.Timestamp, 0).Format("2006-01-02 15:04:05"))
+		if detail := lockDetail(*lastLock); detail != "" {
+			fmt.Printf(" (%s)", detail)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("   Trust Mode: %s\n", trustMode)
+	if status.TrustExpiresAt != 0 {
+		fmt.Printf("   Trusted:    until %s\n", time.Unix(status.TrustExpiresAt, 0).Format("2006-01-02 15:04"))
+	}
+	if status.TrustHardLimitAt != 0 {
+		fmt.Printf("   Hard limit: %s, however often it is used\n", time.Unix(status.TrustHardLimitAt, 0).Format("2006-01-02 15:04"))
+	}
+	if status.TrustIdleExpiresAt != 0 {
+		fmt.Printf("   Idle lock:  %s unless used before\n", time.Unix(status.TrustIdleExpiresAt, 0).Format("2006-01-02 15:04"))
+	}
+	if status.Frozen {
+		printFreezeLine(status.FrozenUntil)
+	}
+	fmt.Printf("   Socket:     %s\n", status.Socket)
+	printListenerSecurity(status.Security)
+	if connections := status.Connections; connections != nil {
+		limit := "no limit"
+		if connections.Limit > 0 {
+			limit = fmt.Sprintf("limit %d", connections.Limit)
+		}
+		fmt.Printf("   Connections: %d open (peak %d, %s, %d turned away)\n",
+			connections.Current, connections.Peak, limit, connections.Rejected)
+	}
+	if status.Draining {
+		fmt.Printf("   Draining:   yes, %d request(s) in flight\n", status.InFlight)
+	}
+	printRateLimits(status.RateLimits)
+	printRecentRequests(status.RecentRequests, 10)
+	if verbose {
+		printLockHistory(status.LockHistory)
+	}
+	if stats != nil {
+		printStats(stats)
+	}
+	fmt.Println()
+	fmt.Println("Effective configuration:")
+	printEffectiveConfig(status.Config)
+	for _, warning := range status.ConfigWarnings {
+		fmt.Printf("⚠️  config.json: %s\n", warning)
+	}
+	return nil
+}
+
+// printEffectiveConfig prints settings sorted by key
+func printEffectiveConfig(values map[string]string) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
+		if value == "" {
+			value = "-"
+		}
+		fmt.Printf("  %-36s %s\n", key, value)
+	}
+}
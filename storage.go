@@ -1,13 +1,15 @@
 package main
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
+	"runtime"
 	"time"
 
 	"golang.org/x/crypto/scrypt"
@@ -20,22 +22,85 @@ const (
 	scryptP = 1
 	keyLen  = 32
 	saltLen = 16
+
+	// AES-256-GCM nonce and tag sizes
+	aesGCMNonceSize = 12
+	aesGCMTagSize   = 16
+)
+
+// Ciphers of keys.encrypted. Keys are encrypted with AES-256-GCM; the XOR
+// of format version 1 is only read, and upgraded on the next unlock.
+const (
+	cipherAESGCM = "aes-256-gcm"
+	cipherXOR    = "xor"
 )
 
-// EncryptedKey represents encrypted nsec storage
+// errKeyDecryptFailed means the cipher rejected the ciphertext: the password
+// is wrong or the ciphertext is damaged
+var errKeyDecryptFailed = errors.New("key does not decrypt (wrong password or damaged ciphertext)")
+
+// kdfScrypt is the key derivation of keys.encrypted (see the kdf config option)
+const kdfScrypt = "scrypt"
+
+// KDFParams are the scrypt cost parameters a key was encrypted with
+type KDFParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+// defaultKDFParams are the parameters new keys are encrypted with
+var defaultKDFParams = KDFParams{N: scryptN, R: scryptR, P: scryptP}
+
+// EncryptedKey represents encrypted nsec storage. How it is stored is up to
+// keyfile.go.
 type EncryptedKey struct {
-	Salt           []byte `json:"salt"`
-	EncryptedNsec  []byte `json:"encrypted_nsec"`
+	// Version is the keys.encrypted format the key was read from
+	Version   int
+	KDF       string
+	KDFParams KDFParams
+	Salt      []byte
+	Cipher    string
+	// Nonce is empty with the XOR cipher
+	Nonce         []byte
+	EncryptedNsec []byte
+}
+
+// sealedLen returns how long the ciphertext of an n byte nsec is
+func (k *EncryptedKey) sealedLen(n int) int {
+	if k.Cipher == cipherAESGCM {
+		return n + aesGCMTagSize
+	}
+	return n
 }
 
-// getStorageDir returns the storage directory for NoorSigner data
-// ~/.noorsigner on macOS/Linux
+// storageHomeEnv names the environment variable that relocates the storage
+// directory (the global --home flag sets it too)
+const storageHomeEnv = "NOORSIGNER_HOME"
+
+// storageDirOverride returns $NOORSIGNER_HOME as an absolute path ("" if unset)
+func storageDirOverride() string {
+	storageDir := os.Getenv(storageHomeEnv)
+	if storageDir == "" {
+		return ""
+	}
+	if absDir, err := filepath.Abs(storageDir); err == nil {
+		return absDir
+	}
+	return storageDir
+}
+
+// getStorageDir returns the storage directory for NoorSigner data:
+// $NOORSIGNER_HOME if set, else ~/.noorsigner
 func getStorageDir() (string, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("cannot get home directory: %v", err)
+	storageDir := storageDirOverride()
+	if storageDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot get home directory: %v", err)
+		}
+		storageDir = filepath.Join(homeDir, ".noorsigner")
 	}
-	storageDir := filepath.Join(homeDir, ".noorsigner")
 
 	// Create directory if it doesn't exist
 	if err := os.MkdirAll(storageDir, 0700); err != nil {
@@ -55,49 +120,110 @@ func getKeyFilePath() (string, error) {
 	return filepath.Join(storageDir, "keys.encrypted"), nil
 }
 
-// encryptNsec encrypts nsec with password using NIP-49 compatible scrypt
+// encryptNsec encrypts nsec with password: scrypt with the NIP-49
+// parameters derives an AES-256-GCM key
 func encryptNsec(nsec, password string) (*EncryptedKey, error) {
-	// Generate random salt
+	return sealNsec([]byte(nsec), password)
+}
+
+// sealNsec is encryptNsec for an nsec held in a buffer the caller clears
+func sealNsec(nsec []byte, password string) (*EncryptedKey, error) {
+	if err := requireEntropy(); err != nil {
+		return nil, err
+	}
+
+	// Generate random salt and nonce
 	salt := make([]byte, saltLen)
 	if _, err := rand.Read(salt); err != nil {
 		return nil, fmt.Errorf("cannot generate salt: %v", err)
 	}
-	
-	// Derive key using scrypt (NIP-49 parameters)
-	derivedKey, err := scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, keyLen)
-	if err != nil {
-		return nil, fmt.Errorf("scrypt key derivation failed: %v", err)
+	nonce := make([]byte, aesGCMNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("cannot generate nonce: %v", err)
 	}
-	
-	// Simple XOR encryption (for now - could be upgraded to AES)
-	nsecBytes := []byte(nsec)
-	encrypted := make([]byte, len(nsecBytes))
-	
-	for i := 0; i < len(nsecBytes); i++ {
-		encrypted[i] = nsecBytes[i] ^ derivedKey[i%len(derivedKey)]
+
+	encKey := &EncryptedKey{
+		Version:   keyFormatVersion,
+		KDF:       kdfScrypt,
+		KDFParams: defaultKDFParams,
+		Salt:      salt,
+		Cipher:    cipherAESGCM,
+		Nonce:     nonce,
 	}
-	
-	return &EncryptedKey{
-		Salt:          salt,
-		EncryptedNsec: encrypted,
-	}, nil
+	aead, err := encKey.aead(password)
+	if err != nil {
+		return nil, err
+	}
+	encKey.EncryptedNsec = aead.Seal(nil, nonce, nsec, nil)
+	return encKey, nil
 }
 
 // decryptNsec decrypts nsec with password
 func decryptNsec(encKey *EncryptedKey, password string) (string, error) {
-	// Derive same key using stored salt
-	derivedKey, err := scrypt.Key([]byte(password), encKey.Salt, scryptN, scryptR, scryptP, keyLen)
+	decrypted, err := decryptNsecBytes(encKey, password)
 	if err != nil {
-		return "", fmt.Errorf("scrypt key derivation failed: %v", err)
+		return "", err
 	}
-	
-	// Decrypt using XOR
-	decrypted := make([]byte, len(encKey.EncryptedNsec))
-	for i := 0; i < len(encKey.EncryptedNsec); i++ {
-		decrypted[i] = encKey.EncryptedNsec[i] ^ derivedKey[i%len(derivedKey)]
+	nsec := string(decrypted)
+	zeroBytes(decrypted)
+	return nsec, nil
+}
+
+// decryptNsecBytes is decryptNsec into a buffer the caller clears
+func decryptNsecBytes(encKey *EncryptedKey, password string) ([]byte, error) {
+	// No key is decrypted while frozen (see freeze.go)
+	if err := checkNotFrozen(); err != nil {
+		return nil, err
 	}
-	
-	return string(decrypted), nil
+
+	if encKey.Cipher == cipherXOR {
+		derivedKey, err := encKey.deriveKey(password)
+		if err != nil {
+			return nil, err
+		}
+		// A wrong password decrypts to garbage rather than failing
+		decrypted := make([]byte, len(encKey.EncryptedNsec))
+		for i := 0; i < len(encKey.EncryptedNsec); i++ {
+			decrypted[i] = encKey.EncryptedNsec[i] ^ derivedKey[i%len(derivedKey)]
+		}
+		return decrypted, nil
+	}
+
+	aead, err := encKey.aead(password)
+	if err != nil {
+		return nil, err
+	}
+	if len(encKey.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length %d", len(encKey.Nonce))
+	}
+	decrypted, err := aead.Open(nil, encKey.Nonce, encKey.EncryptedNsec, nil)
+	if err != nil {
+		return nil, errKeyDecryptFailed
+	}
+	return decrypted, nil
+}
+
+// deriveKey derives the key's encryption key from password
+func (k *EncryptedKey) deriveKey(password string) ([]byte, error) {
+	params := k.KDFParams
+	derivedKey, err := scrypt.Key([]byte(password), k.Salt, params.N, params.R, params.P, keyLen)
+	if err != nil {
+		return nil, fmt.Errorf("scrypt key derivation failed: %v", err)
+	}
+	return derivedKey, nil
+}
+
+// aead returns the AES-256-GCM cipher for password
+func (k *EncryptedKey) aead(password string) (cipher.AEAD, error) {
+	derivedKey, err := k.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derivedKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
 
 // saveEncryptedKey saves encrypted key to file
@@ -113,7 +239,7 @@ func saveEncryptedKey(encKey *EncryptedKey) error {
 	
 	content := fmt.Sprintf("%s:%s", saltHex, encryptedHex)
 	
-	if err := os.WriteFile(keyFile, []byte(content), 0600); err != nil {
+	if err := writeFileAtomic(keyFile, []byte(content), 0600); err != nil {
 		return fmt.Errorf("cannot write key file: %v", err)
 	}
 	
@@ -168,10 +294,8 @@ func loadEncryptedKey() (*EncryptedKey, error) {
 		return nil, fmt.Errorf("invalid encrypted data in key file: %v", err)
 	}
 
-	return &EncryptedKey{
-		Salt:          salt,
-		EncryptedNsec: encrypted,
-	}, nil
+	// Written before multi-account support, so always format version 1
+	return legacyEncryptedKey(salt, encrypted), nil
 }
 
 // TrustSession represents a 24h trust mode session
@@ -180,6 +304,18 @@ type TrustSession struct {
 	ExpiresAt     time.Time `json:"expires_at"`
 	CreatedAt     time.Time `json:"created_at"`
 	EncryptedNsec []byte    `json:"encrypted_nsec"` // Cached nsec for trust mode
+	// LastUsedAt is the last key-using request, for trust_idle_timeout
+	// (see trustidle.go); zero = not used since CreatedAt
+	LastUsedAt time.Time `json:"last_used_at"`
+	// HardExpiresAt is the most ExpiresAt may slide to with trust_sliding
+	// (see trustslide.go); zero = the session doesn't slide
+	HardExpiresAt time.Time `json:"hard_expires_at"`
+	// TokenInKeyring: SessionToken is kept in the OS keyring, not the
+	// session file (see trustkeyring.go). Loaded sessions leave
+	// SessionToken empty until the nsec is decrypted.
+	TokenInKeyring bool `json:"token_in_keyring"`
+
+	npub string // Account the session was loaded for
 }
 
 // getTrustSessionFilePath returns path to trust session file
@@ -207,67 +343,19 @@ func saveTrustSession(session *TrustSession) error {
 		session.CreatedAt.Unix(),
 		encryptedHex)
 
-	if err := os.WriteFile(sessionFile, []byte(content), 0600); err != nil {
+	if err := writeFileAtomic(sessionFile, []byte(content), 0600); err != nil {
 		return fmt.Errorf("cannot write trust session file: %v", err)
 	}
 
 	return nil
 }
 
-// loadTrustSession loads trust session from file
-func loadTrustSession() (*TrustSession, error) {
-	sessionFile, err := getTrustSessionFilePath()
-	if err != nil {
-		return nil, err
-	}
-
-	if _, err := os.Stat(sessionFile); os.IsNotExist(err) {
-		return nil, fmt.Errorf("no trust session found")
-	}
-
-	content, err := os.ReadFile(sessionFile)
-	if err != nil {
-		return nil, fmt.Errorf("cannot read trust session file: %v", err)
-	}
-
-	// Parse format: token:expires_unix:created_unix:encrypted_nsec_hex
-	parts := strings.Split(string(content), ":")
-	if len(parts) != 4 {
-		return nil, fmt.Errorf("invalid trust session format - expected 4 parts, got %d", len(parts))
-	}
-
-	token := parts[0]
-	expiresUnix, err := strconv.ParseInt(parts[1], 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid expiry timestamp: %v", err)
-	}
-
-	createdUnix, err := strconv.ParseInt(parts[2], 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid created timestamp: %v", err)
-	}
-
-	encryptedHex := parts[3]
-
-	encryptedNsec, err := hex.DecodeString(encryptedHex)
-	if err != nil {
-		return nil, fmt.Errorf("invalid encrypted nsec in trust session: %v", err)
-	}
-
-	return &TrustSession{
-		SessionToken:  token,
-		ExpiresAt:     time.Unix(expiresUnix, 0),
-		CreatedAt:     time.Unix(createdUnix, 0),
-		EncryptedNsec: encryptedNsec,
-	}, nil
-}
-
 // isTrustSessionValid checks if trust session is still valid
 func isTrustSessionValid(session *TrustSession) bool {
-	return time.Now().Before(session.ExpiresAt)
+	return time.Now().Before(session.deadline(appConfig.trustIdleTimeout()))
 }
 
-// createTrustSession creates a new 24h trust session with cached nsec
+// createTrustSession creates a new trust session (24h unless trust_duration is set) with cached nsec
 func createTrustSession(nsec string) (*TrustSession, error) {
 	// Generate random session token
 	tokenBytes := make([]byte, 32)
@@ -275,42 +363,48 @@ func createTrustSession(nsec string) (*TrustSession, error) {
 		return nil, fmt.Errorf("cannot generate session token: %v", err)
 	}
 
-	// Encrypt nsec with session token as key (simple but secure for 24h)
-	sessionKey := tokenBytes[:32] // Use first 32 bytes as encryption key
-	nsecBytes := []byte(nsec)
-	encryptedNsec := make([]byte, len(nsecBytes))
-
-	for i := 0; i < len(nsecBytes); i++ {
-		encryptedNsec[i] = nsecBytes[i] ^ sessionKey[i%len(sessionKey)]
+	// Encrypt nsec with a key derived from the token and this machine, so
+	// the session file alone unlocks nothing elsewhere (see trustbind.go)
+	encryptedNsec, err := sealTrustSessionNsec(tokenBytes, nsec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encrypt trust session: %v", err)
 	}
 
 	token := hex.EncodeToString(tokenBytes)
 	now := time.Now()
-	expires := now.Add(24 * time.Hour) // 24 hour trust period
-
-	return &TrustSession{
-		SessionToken:  token,
-		ExpiresAt:     expires,
-		CreatedAt:     now,
-		EncryptedNsec: encryptedNsec,
-	}, nil
+	expires := now.Add(trustDuration()) // 24 hour trust period by default
+
+	session := &TrustSession{
+		SessionToken:   token,
+		ExpiresAt:      expires,
+		CreatedAt:      now,
+		EncryptedNsec:  encryptedNsec,
+		TokenInKeyring: !appConfig.trustTokenInFile(),
+	}
+	if appConfig.trustSliding() {
+		// The hard limit is fixed now; later config changes don't move it
+		session.HardExpiresAt = now.Add(max(appConfig.trustMaxLifetime(), trustDuration()))
+	}
+	return session, nil
 }
 
-// decryptTrustSessionNsec decrypts nsec from trust session
+// decryptTrustSessionNsec decrypts nsec from trust session. A session made
+// on another machine or installation fails with errTrustSessionForeign.
 func decryptTrustSessionNsec(session *TrustSession) (string, error) {
-	tokenBytes, err := hex.DecodeString(session.SessionToken)
-	if err != nil {
-		return "", fmt.Errorf("invalid session token: %v", err)
+	if err := checkNotFrozen(); err != nil {
+		return "", err
 	}
 
-	sessionKey := tokenBytes[:32]
-	decrypted := make([]byte, len(session.EncryptedNsec))
-
-	for i := 0; i < len(session.EncryptedNsec); i++ {
-		decrypted[i] = session.EncryptedNsec[i] ^ sessionKey[i%len(sessionKey)]
+	token, err := session.token()
+	if err != nil {
+		return "", err
+	}
+	tokenBytes, err := hex.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("invalid session token: %v", err)
 	}
 
-	return string(decrypted), nil
+	return openTrustSessionNsec(tokenBytes, session.EncryptedNsec)
 }
 
 // clearTrustSession removes trust session file
@@ -325,4 +419,58 @@ func clearTrustSession() error {
 	}
 
 	return os.Remove(sessionFile)
-}
\ No newline at end of file
+}
+
+// writeFileAtomic writes data to a temp file in the same directory and
+// renames it over path, so readers never see a partial file. The file and
+// then the directory are synced, so after a crash path holds either the
+// old or the new content.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("cannot create temp file: %v", err)
+	}
+	tmpPath := tmpFile.Name()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot write temp file: %v", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot write temp file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot write temp file: %v", err)
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot set file permissions: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot replace %s: %v", path, err)
+	}
+
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("cannot sync %s: %v", filepath.Dir(path), err)
+	}
+	return nil
+}
+
+// syncDir makes a rename in dir durable. Windows can't open a directory
+// for syncing; NTFS journals the rename itself.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "active_account")
+
+	for _, content := range []string{"first", "second, longer content", "3"} {
+		if err := writeFileAtomic(path, []byte(content), 0600); err != nil {
+			t.Fatalf("writeFileAtomic: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != content {
+			t.Fatalf("read back %q, %v; want %q", data, err, content)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("mode %o, want 600", mode)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("%d files in the directory, want only the target (no temp files left)", len(entries))
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "file"), []byte("x"), 0600); err == nil {
+		t.Error("writing into a missing directory succeeded")
+	}
+}
+
+// A crash while the new key file is being written leaves a truncated temp
+// file next to keys.encrypted; the key file itself must be untouched and
+// the account usable, and the next write must still succeed
+func TestWriteFileAtomicAfterCrash(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	accountDir, err := getAccountDir(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(accountDir, recordKey)
+	original, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What writeFileAtomic leaves if the process dies halfway through
+	// writing the temp file, before the rename
+	replacement := bytes.Repeat([]byte("x"), len(original))
+	tmpFile, err := os.CreateTemp(accountDir, "."+recordKey+".tmp-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Write(replacement)
+	if err := tmpFile.Truncate(int64(len(replacement) / 2)); err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Close()
+
+	if data, _ := os.ReadFile(keyPath); !bytes.Equal(data, original) {
+		t.Fatal("keys.encrypted changed without a rename")
+	}
+	encKey, err := loadAccountEncryptedKey(npub)
+	if err != nil {
+		t.Fatalf("loadAccountEncryptedKey after the crash: %v", err)
+	}
+	if _, _, err := decryptAccountKey(encKey, npub, testPassword); err != nil {
+		t.Fatalf("decrypt after the crash: %v", err)
+	}
+
+	if err := saveAccountEncryptedKey(npub, encKey); err != nil {
+		t.Fatalf("saveAccountEncryptedKey after the crash: %v", err)
+	}
+	encKey, err = loadAccountEncryptedKey(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := decryptAccountKey(encKey, npub, testPassword); err != nil {
+		t.Errorf("decrypt after rewriting: %v", err)
+	}
+}
+
+// Readers see the old or the new content, never a mix or a partial file
+func TestWriteFileAtomicReaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trust_session")
+	contents := []string{strings.Repeat("a", 4096), strings.Repeat("b", 100)}
+	if err := writeFileAtomic(path, []byte(contents[0]), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Errorf("read: %v", err)
+				return
+			}
+			if string(data) != contents[0] && string(data) != contents[1] {
+				t.Errorf("read a partial file of %d bytes", len(data))
+				return
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		if err := writeFileAtomic(path, []byte(contents[i%2]), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// storageLockWait bounds how long a mutation waits for another process to
+// finish its own (a variable so tests can wait less)
+var storageLockWait = 10 * time.Second
+
+// storageLockPoll is how often a waiting mutation retries the lock
+const storageLockPoll = 50 * time.Millisecond
+
+// errStorageBusy is returned when another process held storage.lock for
+// all of storageLockWait
+var errStorageBusy = errors.New("another noorsigner operation is in progress - try again in a moment")
+
+// withStorageLock runs mutate holding storage.lock, which every process
+// takes before it changes active_account or creates or removes an account.
+// The CLI and a running daemon both write them (switch writes
+// active_account, then the daemon writes it again), so without the lock
+// two of them could interleave. The lock is not reentrant: mutate must not
+// take it again.
+func withStorageLock(mutate func() error) error {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(filepath.Join(storageDir, "storage.lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot open storage lock: %v", err)
+	}
+	defer lock.Close()
+
+	deadline := time.Now().Add(storageLockWait)
+	for {
+		unlock, ok, err := tryLockFile(lock)
+		if err != nil {
+			return fmt.Errorf("cannot lock storage: %v", err)
+		}
+		if ok {
+			defer unlock()
+			return mutate()
+		}
+		if time.Now().After(deadline) {
+			return errStorageBusy
+		}
+		time.Sleep(storageLockPoll)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Concurrent writers each take the lock for a read-modify-write of one
+// file; none may overlap and no increment may be lost
+func TestWithStorageLockContention(t *testing.T) {
+	dir := testHome(t)
+	counterPath := filepath.Join(dir, "counter")
+	if err := os.WriteFile(counterPath, []byte("0"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, increments = 8, 10
+	var holders, maxHolders atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				err := withStorageLock(func() error {
+					if n := holders.Add(1); n > maxHolders.Load() {
+						maxHolders.Store(n)
+					}
+					defer holders.Add(-1)
+
+					data, err := os.ReadFile(counterPath)
+					if err != nil {
+						return err
+					}
+					n, err := strconv.Atoi(string(data))
+					if err != nil {
+						return err
+					}
+					time.Sleep(time.Millisecond)
+					return writeFileAtomic(counterPath, []byte(strconv.Itoa(n+1)), 0600)
+				})
+				if err != nil {
+					t.Errorf("withStorageLock: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxHolders.Load() != 1 {
+		t.Errorf("%d writers held the lock at once", maxHolders.Load())
+	}
+	data, _ := os.ReadFile(counterPath)
+	if string(data) != strconv.Itoa(writers*increments) {
+		t.Errorf("counter = %s, want %d: increments were lost", data, writers*increments)
+	}
+}
+
+// A writer that can't get the lock within storageLockWait gives up with
+// errStorageBusy and doesn't run its mutation
+func TestWithStorageLockBusy(t *testing.T) {
+	testHome(t)
+	previous := storageLockWait
+	storageLockWait = 200 * time.Millisecond
+	t.Cleanup(func() { storageLockWait = previous })
+
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	holderDone := make(chan error)
+	go func() {
+		holderDone <- withStorageLock(func() error {
+			close(locked)
+			<-release
+			return nil
+		})
+	}()
+	<-locked
+
+	ran := false
+	start := time.Now()
+	err := withStorageLock(func() error {
+		ran = true
+		return nil
+	})
+	if !errors.Is(err, errStorageBusy) {
+		t.Errorf("withStorageLock = %v, want errStorageBusy", err)
+	}
+	if ran {
+		t.Error("the mutation ran without the lock")
+	}
+	if waited := time.Since(start); waited < storageLockWait {
+		t.Errorf("gave up after %v, before storageLockWait", waited)
+	}
+
+	close(release)
+	if err := <-holderDone; err != nil {
+		t.Fatal(err)
+	}
+	if err := withStorageLock(func() error { return nil }); err != nil {
+		t.Errorf("withStorageLock after the holder finished: %v", err)
+	}
+}
+
+// Concurrent switches leave active_account naming one of the accounts
+func TestConcurrentSaveActiveAccount(t *testing.T) {
+	testHome(t)
+	npubs := make([]string, 4)
+	for i := range npubs {
+		_, npubs[i] = testKey(t)
+	}
+
+	var wg sync.WaitGroup
+	for _, npub := range npubs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := saveActiveAccount(npub); err != nil {
+					t.Errorf("saveActiveAccount: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	active, err := loadActiveAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, npub := range npubs {
+		if active == npub {
+			return
+		}
+	}
+	t.Errorf("active_account = %q, not one of the written npubs", active)
+}
+
+// holdStorageLock takes storage.lock until the returned func is called
+func holdStorageLock(t *testing.T) func() {
+	t.Helper()
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- withStorageLock(func() error {
+			close(locked)
+			<-release
+			return nil
+		})
+	}()
+	<-locked
+	return func() {
+		close(release)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Every change to accounts and active_account takes storage.lock: while
+// another process holds it, each gives up with errStorageBusy and changes
+// nothing, and succeeds once the lock is free
+func TestStorageLockCallers(t *testing.T) {
+	previous := storageLockWait
+	storageLockWait = 100 * time.Millisecond
+	t.Cleanup(func() { storageLockWait = previous })
+
+	// The key the add account case adds, new in each run
+	var newNsec, newNpub string
+	tests := []struct {
+		name string
+		// run makes the change to npub (other is a second account)
+		run func(npub, other string) error
+		// changed reports whether it took effect
+		changed func(npub, other string) bool
+	}{
+		{"add account", func(npub, other string) error {
+			key, err := encryptNsec(newNsec, testPassword)
+			if err != nil {
+				return err
+			}
+			return saveAccountEncryptedKey(newNpub, key)
+		}, func(npub, other string) bool {
+			return accountExists(newNpub)
+		}},
+		{"change password", func(npub, other string) error {
+			encKey, err := loadAccountEncryptedKey(npub)
+			if err != nil {
+				return err
+			}
+			nsec, err := decryptNsec(encKey, testPassword)
+			if err != nil {
+				return err
+			}
+			rotated, err := encryptNsec(nsec, "AnotherPassword7!")
+			if err != nil {
+				return err
+			}
+			return saveAccountEncryptedKey(npub, rotated)
+		}, func(npub, other string) bool {
+			encKey, err := loadAccountEncryptedKey(npub)
+			if err != nil {
+				return false
+			}
+			_, err = decryptNsec(encKey, "AnotherPassword7!")
+			return err == nil
+		}},
+		{"remove account", func(npub, other string) error {
+			return removeAccount(npub)
+		}, func(npub, other string) bool {
+			return !accountExists(npub)
+		}},
+		{"switch account", func(npub, other string) error {
+			return saveActiveAccount(other)
+		}, func(npub, other string) bool {
+			active, _ := loadActiveAccount()
+			return active == other
+		}},
+		{"set label", func(npub, other string) error {
+			return setAccountLabel(npub, "renamed")
+		}, func(npub, other string) bool {
+			return accountLabel(npub) == "renamed"
+		}},
+		{"set relays", func(npub, other string) error {
+			_, err := updateAccountRelays(npub, func(map[string]RelayPolicy) (map[string]RelayPolicy, error) {
+				return map[string]RelayPolicy{"wss://relay.example": {Read: true, Write: true}}, nil
+			})
+			return err
+		}, func(npub, other string) bool {
+			relays, _ := loadAccountRelays(npub)
+			_, ok := relays["wss://relay.example"]
+			return ok
+		}},
+		{"cache profile", func(npub, other string) error {
+			return saveAccountProfile(npub, &AccountProfile{Name: "cached", EventCreatedAt: 1})
+		}, func(npub, other string) bool {
+			meta, err := loadAccountMetadata(npub)
+			return err == nil && meta.Profile != nil && meta.Profile.Name == "cached"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHome(t)
+			other := addTestAccount(t, "")
+			npub := addTestAccount(t, "")
+			newNsec, newNpub = testNsec(t)
+
+			release := holdStorageLock(t)
+			if err := tt.run(npub, other); !errors.Is(err, errStorageBusy) {
+				t.Errorf("while storage.lock is held: %v, want errStorageBusy", err)
+			}
+			if tt.changed(npub, other) {
+				t.Error("changed without storage.lock")
+			}
+			release()
+
+			if err := tt.run(npub, other); err != nil {
+				t.Fatalf("with storage.lock free: %v", err)
+			}
+			if !tt.changed(npub, other) {
+				t.Error("no change with storage.lock free")
+			}
+		})
+	}
+}
+
+// A wrong password is counted under lockout.json's own lock: a counter
+// waits for the holder instead of losing its count
+func TestLockoutUpdateWaits(t *testing.T) {
+	testHome(t)
+	_, npub := testKey(t)
+	path, err := getLockoutPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	unlock, err := lockFile(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counted := make(chan struct{})
+	go func() {
+		recordPasswordFailure(npub)
+		close(counted)
+	}()
+	select {
+	case <-counted:
+		t.Fatal("counted while lockout.json was locked")
+	case <-time.After(200 * time.Millisecond):
+	}
+	unlock()
+	<-counted
+
+	lockouts, err := loadLockouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := lockouts.Accounts[npub]; entry == nil || entry.Failures != 1 {
+		t.Errorf("lockout entry %+v, want 1 failure", entry)
+	}
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Account records: what the store keeps per account. In the files backend
+// each one is the file of the same name in the account's directory.
+const (
+	recordKey          = "keys.encrypted"
+	recordKeyChecksum  = "keys.sha256"
+	recordHealth       = "health.json"
+	recordTrustSession = "trust_session"
+	recordFido         = "fido.json"
+	recordFidoKey      = "keys.fido"
+	recordCounters     = "counters.json"
+	recordMetadata     = "metadata.json"
+	recordRelays       = "relays.json"
+)
+
+// Storage backends
+const (
+	storeFiles  = "files"
+	storeSQLite = "sqlite"
+)
+
+// storeRecord is one named record of an account
+type storeRecord struct {
+	Name string
+	Data []byte
+}
+
+// Store keeps the accounts: their records and which one is active. Records
+// are opaque bytes; their formats are the same in every backend, so a
+// migration copies them unchanged.
+type Store interface {
+	// Backend returns storeFiles or storeSQLite
+	Backend() string
+	// Location describes where an account's records live, for messages
+	Location(npub string) string
+
+	// ListAccounts returns every account that has a directory or entry
+	ListAccounts() ([]AccountInfo, error)
+	// RecordNames lists the records an account has
+	RecordNames(npub string) ([]string, error)
+	// ReadRecord returns a record and when it was last written. A missing
+	// record returns an error for which os.IsNotExist is true.
+	ReadRecord(npub, name string) ([]byte, time.Time, error)
+	// WriteRecords writes records in order, creating the account if needed.
+	// The sqlite backend writes all of them or none.
+	WriteRecords(npub string, records ...storeRecord) error
+	// DeleteRecord removes a record (a missing one is not an error)
+	DeleteRecord(npub, name string) error
+	// RemoveAccount removes an account with all its records and, if it was
+	// the active account, the active account setting
+	RemoveAccount(npub string) error
+
+	// LoadActiveAccount returns the active npub
+	LoadActiveAccount() (string, error)
+	// SaveActiveAccount sets the active npub
+	SaveActiveAccount(npub string) error
+}
+
+var (
+	storeMu     sync.Mutex
+	cachedStore Store
+	cachedPath  string
+)
+
+// getSQLiteStorePath returns the path of the sqlite database. Its existence
+// selects the sqlite backend.
+func getSQLiteStorePath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storageDir, "noorsigner.db"), nil
+}
+
+// accountStore returns the store of the storage dir: sqlite if
+// noorsigner.db exists, else the files under accounts/
+func accountStore() (Store, error) {
+	dbPath, err := getSQLiteStorePath()
+	if err != nil {
+		return nil, err
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	if _, err := os.Stat(dbPath); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot access %s: %v", dbPath, err)
+		}
+		if sqlite, ok := cachedStore.(*sqliteStore); ok {
+			// Migrated back to files
+			sqlite.close()
+		}
+		cachedStore, cachedPath = &fileStore{}, ""
+		return cachedStore, nil
+	}
+
+	if cachedPath == dbPath {
+		return cachedStore, nil
+	}
+	store, err := openSQLiteStore(dbPath, false)
+	if err != nil {
+		return nil, err
+	}
+	cachedStore, cachedPath = store, dbPath
+	return store, nil
+}
+
+// fileStore is the original layout: accounts/<npub>/<record> and the
+// active_account file
+type fileStore struct{}
+
+// Backend returns storeFiles
+func (s *fileStore) Backend() string {
+	return storeFiles
+}
+
+// Location returns the account's directory
+func (s *fileStore) Location(npub string) string {
+	accountDir, err := getAccountDir(npub)
+	if err != nil {
+		return "accounts/" + canonicalNpub(npub)
+	}
+	return accountDir
+}
+
+// ListAccounts returns the accounts with a directory under accounts/
+func (s *fileStore) ListAccounts() ([]AccountInfo, error) {
+	accountsDir, err := getAccountsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(accountsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []AccountInfo{}, nil
+		}
+		return nil, fmt.Errorf("cannot read accounts directory: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+
+	var accounts []AccountInfo
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		npub := canonicalNpub(entry.Name())
+		if !strings.HasPrefix(npub, "npub1") {
+			continue // Skip non-npub directories
+		}
+
+		// Case variants of one npub are one account; the lowercase directory
+		// wins, as in getAccountDir (see checkAccountDirCase)
+		if entry.Name() != npub && names[npub] {
+			continue
+		}
+		if seen[npub] {
+			continue
+		}
+		seen[npub] = true
+
+		// Get creation time from directory
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		// Derive pubkey from npub
+		pubkey, err := npubToPubkey(npub)
+		if err != nil {
+			continue
+		}
+
+		accounts = append(accounts, AccountInfo{
+			Npub:      npub,
+			Pubkey:    pubkey,
+			CreatedAt: info.ModTime(),
+		})
+	}
+
+	return accounts, nil
+}
+
+// RecordNames lists the regular files in the account's directory
+func (s *fileStore) RecordNames(npub string) ([]string, error) {
+	accountDir, err := getAccountDir(npub)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(accountDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read account directory: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// ReadRecord reads the record's file
+func (s *fileStore) ReadRecord(npub, name string) ([]byte, time.Time, error) {
+	accountDir, err := getAccountDir(npub)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	path := filepath.Join(accountDir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, info.ModTime(), nil
+}
+
+// WriteRecords writes one file per record (mode 0600), each atomically
+func (s *fileStore) WriteRecords(npub string, records ...storeRecord) error {
+	accountDir, err := getAccountDir(npub)
+	if err != nil {
+		return err
+	}
+
+	// Create account directory
+	if err := os.MkdirAll(accountDir, 0700); err != nil {
+		return fmt.Errorf("cannot create account directory: %v", err)
+	}
+
+	for _, record := range records {
+		if err := writeFileAtomic(filepath.Join(accountDir, record.Name), record.Data, 0600); err != nil {
+			return fmt.Errorf("cannot write %s: %v", record.Name, err)
+		}
+	}
+	return nil
+}
+
+// DeleteRecord removes the record's file
+func (s *fileStore) DeleteRecord(npub, name string) error {
+	accountDir, err := getAccountDir(npub)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(accountDir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RemoveAccount overwrites and removes the account's directory
+func (s *fileStore) RemoveAccount(npub string) error {
+	accountDir, err := getAccountDir(npub)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(accountDir); os.IsNotExist(err) {
+		return fmt.Errorf("account not found: %s", npub)
+	}
+
+	// Overwrite key material before unlinking, then remove entire account directory
+	if err := secureDeleteDir(accountDir); err != nil {
+		return fmt.Errorf("cannot securely delete account files: %v", err)
+	}
+	if err := os.RemoveAll(accountDir); err != nil {
+		return fmt.Errorf("cannot remove account: %v", err)
+	}
+
+	// If this was the active account, clear active_account file
+	activeNpub, err := s.LoadActiveAccount()
+	if err == nil && activeNpub == canonicalNpub(npub) {
+		activeFile, _ := getActiveAccountFilePath()
+		os.Remove(activeFile)
+	}
+
+	return nil
+}
+
+// LoadActiveAccount reads the active_account file
+func (s *fileStore) LoadActiveAccount() (string, error) {
+	filePath, err := getActiveAccountFilePath()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return "", fmt.Errorf("no active account set")
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("cannot read active account file: %v", err)
+	}
+
+	return canonicalNpub(string(content)), nil
+}
+
+// SaveActiveAccount writes the active_account file
+func (s *fileStore) SaveActiveAccount(npub string) error {
+	filePath, err := getActiveAccountFilePath()
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filePath, []byte(canonicalNpub(npub)), 0600); err != nil {
+		return fmt.Errorf("cannot write active account file: %v", err)
+	}
+
+	return nil
+}
+
+// storedAccount is an account with all its records, as read for a migration
+type storedAccount struct {
+	Info    AccountInfo
+	Records []storeRecord
+}
+
+// readAllAccounts reads every account with every record from store
+func readAllAccounts(store Store) ([]storedAccount, error) {
+	accounts, err := store.ListAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var all []storedAccount
+	for _, info := range accounts {
+		names, err := store.RecordNames(info.Npub)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", displayNpub(info.Npub), err)
+		}
+		account := storedAccount{Info: info}
+		for _, name := range names {
+			data, _, err := store.ReadRecord(info.Npub, name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", displayNpub(info.Npub), name, err)
+			}
+			account.Records = append(account.Records, storeRecord{Name: name, Data: data})
+		}
+		all = append(all, account)
+	}
+	return all, nil
+}
+
+// verifyMigratedAccounts checks that store holds exactly the records of accounts
+func verifyMigratedAccounts(store Store, accounts []storedAccount) error {
+	for _, account := range accounts {
+		names, err := store.RecordNames(account.Info.Npub)
+		if err != nil {
+			return err
+		}
+		if len(names) != len(account.Records) {
+			return fmt.Errorf("%s: %d records instead of %d", displayNpub(account.Info.Npub), len(names), len(account.Records))
+		}
+		for _, record := range account.Records {
+			data, _, err := store.ReadRecord(account.Info.Npub, record.Name)
+			if err != nil || string(data) != string(record.Data) {
+				return fmt.Errorf("%s: %s differs after the migration", displayNpub(account.Info.Npub), record.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// migrateToSQLite copies every account into a new noorsigner.db. The
+// database is built under a temporary name and renamed into place once it
+// has been read back and compared, so an interrupted migration leaves the
+// files in charge. Only then are the account files overwritten and removed.
+func migrateToSQLite(from Store, accounts []storedAccount, active string) error {
+	dbPath, err := getSQLiteStorePath()
+	if err != nil {
+		return err
+	}
+	tmpPath := dbPath + ".migrating"
+	os.Remove(tmpPath)
+	os.Remove(tmpPath + "-journal")
+
+	store, err := openSQLiteStore(tmpPath, true)
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		for _, account := range accounts {
+			if err := store.WriteRecords(account.Info.Npub, account.Records...); err != nil {
+				return err
+			}
+			if _, err := store.db.Exec("UPDATE accounts SET created_at = ? WHERE npub = ?",
+				account.Info.CreatedAt.Unix(), account.Info.Npub); err != nil {
+				return err
+			}
+		}
+		if active != "" {
+			if err := store.SaveActiveAccount(active); err != nil {
+				return err
+			}
+		}
+		return verifyMigratedAccounts(store, accounts)
+	}()
+	store.close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot move %s into place: %v", dbPath, err)
+	}
+
+	// noorsigner.db is in charge now - the files are leftovers
+	for _, account := range accounts {
+		if err := from.RemoveAccount(account.Info.Npub); err != nil {
+			return fmt.Errorf("migrated, but cannot remove the files of %s: %v", displayNpub(account.Info.Npub), err)
+		}
+	}
+	if activeFile, err := getActiveAccountFilePath(); err == nil {
+		os.Remove(activeFile)
+	}
+	return nil
+}
+
+// migrateToFiles writes every account back to accounts/ and removes
+// noorsigner.db. The directories are built in accounts.migrating and
+// renamed into place, so an interrupted migration leaves the database in
+// charge.
+func migrateToFiles(from *sqliteStore, accounts []storedAccount, active string) error {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return err
+	}
+	if existing, err := (&fileStore{}).ListAccounts(); err != nil {
+		return err
+	} else if len(existing) > 0 {
+		return fmt.Errorf("accounts/ already holds %d account directories (left from an interrupted migration?) - move them away first", len(existing))
+	}
+
+	accountsDir := filepath.Join(storageDir, "accounts")
+	stagingDir := filepath.Join(storageDir, "accounts.migrating")
+	os.RemoveAll(stagingDir)
+	if err := os.Mkdir(stagingDir, 0700); err != nil {
+		return fmt.Errorf("cannot create %s: %v", stagingDir, err)
+	}
+	for _, account := range accounts {
+		accountDir := filepath.Join(stagingDir, account.Info.Npub)
+		if err := os.Mkdir(accountDir, 0700); err != nil {
+			return fmt.Errorf("cannot create %s: %v", accountDir, err)
+		}
+		for _, record := range account.Records {
+			if err := writeFileAtomic(filepath.Join(accountDir, record.Name), record.Data, 0600); err != nil {
+				return fmt.Errorf("cannot write %s: %v", record.Name, err)
+			}
+		}
+		// listAccounts reports the directory's time as the creation time
+		os.Chtimes(accountDir, account.Info.CreatedAt, account.Info.CreatedAt)
+	}
+
+	// accounts/ holds no account directories (checked above)
+	if err := os.RemoveAll(accountsDir); err != nil {
+		return fmt.Errorf("cannot replace %s: %v", accountsDir, err)
+	}
+	if err := os.Rename(stagingDir, accountsDir); err != nil {
+		return fmt.Errorf("cannot move %s into place: %v", accountsDir, err)
+	}
+	files := &fileStore{}
+	if active != "" {
+		if err := files.SaveActiveAccount(active); err != nil {
+			return err
+		}
+	}
+	if err := verifyMigratedAccounts(files, accounts); err != nil {
+		return err
+	}
+
+	// The files are in charge once the database is gone
+	from.close()
+	if err := secureDeleteFile(from.path); err != nil {
+		return fmt.Errorf("migrated, but cannot remove %s: %v", from.path, err)
+	}
+	os.Remove(from.path + "-journal")
+	return nil
+}
+
+// secureDeleteFile overwrites a file with zeros, syncs and removes it
+func secureDeleteFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(make([]byte, info.Size()))
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// storageCmd shows the storage backend or migrates to the other one
+func storageCmd(args []string) error {
+	usage := "Usage: noorsigner storage [migrate --to sqlite|files]"
+
+	store, err := accountStore()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+
+	if len(args) == 0 {
+		accounts, err := store.ListAccounts()
+		if err != nil {
+			return commandFailed(1, "❌ %v", err)
+		}
+		fmt.Printf("Storage backend: %s\n", store.Backend())
+		if store.Backend() == storeSQLite {
+			fmt.Printf("Database:        %s\n", store.Location(""))
+		} else if accountsDir, err := getAccountsDir(); err == nil {
+			fmt.Printf("Directory:       %s\n", accountsDir)
+		}
+		fmt.Printf("Accounts:        %d\n", len(accounts))
+		return nil
+	}
+
+	if len(args) != 3 || args[0] != "migrate" || args[1] != "--to" {
+		return commandFailed(1, "%s", usage)
+	}
+	to := args[2]
+	if to != storeSQLite && to != storeFiles {
+		return commandFailed(1, "%s", usage)
+	}
+	if store.Backend() == to {
+		fmt.Printf("Already using the %s backend.\n", to)
+		return nil
+	}
+
+	// A running daemon writes trust sessions and health state under us
+	if running, pid, err := runningDaemon(); err == nil && running {
+		return commandFailed(1, "❌ The daemon is running (pid %d). Stop it first: noorsigner drain", pid)
+	}
+	if isDaemonRunning() {
+		return commandFailed(1, "❌ A daemon is answering on the socket. Stop it first: noorsigner drain")
+	}
+
+	accounts, err := readAllAccounts(store)
+	if err != nil {
+		return commandFailed(1, "❌ Cannot read the accounts: %v", err)
+	}
+	active, _ := store.LoadActiveAccount()
+
+	fmt.Printf("🔄 Migrating %d accounts from %s to %s...\n", len(accounts), store.Backend(), to)
+	if to == storeSQLite {
+		err = migrateToSQLite(store, accounts, active)
+	} else {
+		err = migrateToFiles(store.(*sqliteStore), accounts, active)
+	}
+	if err != nil {
+		auditCLI("storage_migrate", "", err, nil)
+		return commandFailed(1, "❌ %v", err)
+	}
+	auditCLI("storage_migrate", "", nil, nil)
+
+	store, err = accountStore()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	fmt.Printf("✅ Accounts are now stored in %s (%s backend)\n", store.Location(""), store.Backend())
+	return nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchemaVersion is stored in PRAGMA user_version
+const sqliteSchemaVersion = 1
+
+// sqliteSchema holds the same data as the files backend: one row per
+// account, one per record, and the active account in settings
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS accounts (
+	npub       TEXT PRIMARY KEY,
+	created_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS records (
+	npub       TEXT NOT NULL REFERENCES accounts(npub) ON DELETE CASCADE,
+	name       TEXT NOT NULL,
+	data       BLOB NOT NULL,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (npub, name)
+);
+CREATE TABLE IF NOT EXISTS settings (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+// sqliteStore keeps everything in one database file, noorsigner.db
+type sqliteStore struct {
+	path string
+	db   *sql.DB
+}
+
+// openSQLiteStore opens the database at path. With create set the file is
+// created first (mode 0600, it must not exist yet); otherwise it must exist.
+func openSQLiteStore(path string, create bool) (*sqliteStore, error) {
+	if create {
+		// Create it ourselves so it never exists with looser permissions;
+		// sqlite gives its journal the same mode
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create %s: %v", path, err)
+		}
+		file.Close()
+	} else if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("cannot open %s: %v", path, err)
+	}
+
+	// secure_delete overwrites deleted rows, like secureDeleteDir does for files
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_pragma=secure_delete(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %v", path, err)
+	}
+	// One connection: the daemon and CLI write rarely, and the pragmas above
+	// then apply to every statement
+	db.SetMaxOpenConns(1)
+
+	store := &sqliteStore{path: path, db: db}
+	if err := store.migrateSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// migrateSchema creates the tables of a new database and refuses one
+// written by a newer version
+func (s *sqliteStore) migrateSchema() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("cannot read %s: %v", s.path, err)
+	}
+	if version > sqliteSchemaVersion {
+		return fmt.Errorf("%s was written by a newer noorsigner (schema %d)", s.path, version)
+	}
+	if version == sqliteSchemaVersion {
+		return nil
+	}
+	if _, err := s.db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("cannot create tables in %s: %v", s.path, err)
+	}
+	if _, err := s.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqliteSchemaVersion)); err != nil {
+		return fmt.Errorf("cannot set schema version: %v", err)
+	}
+	return nil
+}
+
+// close closes the database
+func (s *sqliteStore) close() error {
+	return s.db.Close()
+}
+
+// Backend returns storeSQLite
+func (s *sqliteStore) Backend() string {
+	return storeSQLite
+}
+
+// Location returns the database path
+func (s *sqliteStore) Location(npub string) string {
+	return s.path
+}
+
+// ListAccounts returns every account row
+func (s *sqliteStore) ListAccounts() ([]AccountInfo, error) {
+	rows, err := s.db.Query("SELECT npub, created_at FROM accounts ORDER BY npub")
+	if err != nil {
+		return nil, fmt.Errorf("cannot list accounts: %v", err)
+	}
+	defer rows.Close()
+
+	var accounts []AccountInfo
+	for rows.Next() {
+		var npub string
+		var createdAt int64
+		if err := rows.Scan(&npub, &createdAt); err != nil {
+			return nil, fmt.Errorf("cannot list accounts: %v", err)
+		}
+		pubkey, err := npubToPubkey(npub)
+		if err != nil {
+			continue
+		}
+		accounts = append(accounts, AccountInfo{
+			Npub:      npub,
+			Pubkey:    pubkey,
+			CreatedAt: time.Unix(createdAt, 0),
+		})
+	}
+	return accounts, rows.Err()
+}
+
+// RecordNames lists the account's records
+func (s *sqliteStore) RecordNames(npub string) ([]string, error) {
+	rows, err := s.db.Query("SELECT name FROM records WHERE npub = ? ORDER BY name", canonicalNpub(npub))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list records: %v", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("cannot list records: %v", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// ReadRecord reads one record
+func (s *sqliteStore) ReadRecord(npub, name string) ([]byte, time.Time, error) {
+	var data []byte
+	var updatedAt int64
+	err := s.db.QueryRow("SELECT data, updated_at FROM records WHERE npub = ? AND name = ?",
+		canonicalNpub(npub), name).Scan(&data, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("cannot read %s: %v", name, err)
+	}
+	return data, time.Unix(updatedAt, 0), nil
+}
+
+// WriteRecords writes all records in one transaction
+func (s *sqliteStore) WriteRecords(npub string, records ...storeRecord) error {
+	npub = canonicalNpub(npub)
+	now := time.Now().Unix()
+	return s.inTransaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO accounts (npub, created_at) VALUES (?, ?)", npub, now); err != nil {
+			return err
+		}
+		for _, record := range records {
+			if _, err := tx.Exec(`INSERT INTO records (npub, name, data, updated_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT (npub, name) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`,
+				npub, record.Name, record.Data, now); err != nil {
+				return fmt.Errorf("cannot write %s: %v", record.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteRecord deletes one record
+func (s *sqliteStore) DeleteRecord(npub, name string) error {
+	_, err := s.db.Exec("DELETE FROM records WHERE npub = ? AND name = ?", canonicalNpub(npub), name)
+	return err
+}
+
+// RemoveAccount deletes the account, its records and, if it was active,
+// the active account setting in one transaction
+func (s *sqliteStore) RemoveAccount(npub string) error {
+	npub = canonicalNpub(npub)
+	return s.inTransaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM accounts WHERE npub = ?", npub)
+		if err != nil {
+			return fmt.Errorf("cannot remove account: %v", err)
+		}
+		if removed, _ := result.RowsAffected(); removed == 0 {
+			return fmt.Errorf("account not found: %s", npub)
+		}
+		if _, err := tx.Exec("DELETE FROM settings WHERE key = 'active_account' AND value = ?", npub); err != nil {
+			return fmt.Errorf("cannot clear active account: %v", err)
+		}
+		return nil
+	})
+}
+
+// LoadActiveAccount reads the active account setting
+func (s *sqliteStore) LoadActiveAccount() (string, error) {
+	var npub string
+	err := s.db.QueryRow("SELECT value FROM settings WHERE key = 'active_account'").Scan(&npub)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("no active account set")
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot read active account: %v", err)
+	}
+	return canonicalNpub(npub), nil
+}
+
+// SaveActiveAccount sets the active account
+func (s *sqliteStore) SaveActiveAccount(npub string) error {
+	_, err := s.db.Exec(`INSERT INTO settings (key, value) VALUES ('active_account', ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, canonicalNpub(npub))
+	if err != nil {
+		return fmt.Errorf("cannot write active account: %v", err)
+	}
+	return nil
+}
+
+// inTransaction runs fn in a transaction, committed only if fn succeeds
+func (s *sqliteStore) inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkIntegrity runs sqlite's integrity check (for doctor)
+func (s *sqliteStore) checkIntegrity() error {
+	var result string
+	if err := s.db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check: %s", result)
+	}
+	return nil
+}
+
+// checkSQLiteStoreFile checks noorsigner.db, if the sqlite backend is in
+// use: mode 0600 (reset with fix) and sqlite's integrity check. It prints
+// what it finds and returns the number of problems left.
+func checkSQLiteStoreFile(fix bool) int {
+	store, err := accountStore()
+	if err != nil {
+		fmt.Printf("❌ database: %v\n", err)
+		return 1
+	}
+	sqlite, ok := store.(*sqliteStore)
+	if !ok {
+		return 0
+	}
+
+	problems := 0
+	info, err := os.Stat(sqlite.path)
+	if err != nil {
+		fmt.Printf("❌ database: %v\n", err)
+		return 1
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		if fix && os.Chmod(sqlite.path, 0600) == nil {
+			fmt.Printf("🔧 database: permissions reset from %04o to 0600\n", mode)
+		} else {
+			fmt.Printf("⚠️  database: %s has mode %04o, expected 0600\n", sqlite.path, mode)
+			problems++
+		}
+	}
+	if err := sqlite.checkIntegrity(); err != nil {
+		fmt.Printf("❌ database: %v - restore noorsigner.db from a backup\n", err)
+		problems++
+	} else {
+		fmt.Printf("✅ database: %s passes the integrity check\n", sqlite.path)
+	}
+	return problems
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFileStore returns the files backend of a fresh storage directory
+func newFileStore(t *testing.T) Store {
+	t.Helper()
+	testHome(t)
+	store, err := accountStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// newSQLiteStore returns the sqlite backend of a fresh storage directory,
+// as after 'noorsigner storage migrate --to sqlite'
+func newSQLiteStore(t *testing.T) Store {
+	t.Helper()
+	testHome(t)
+	dbPath, err := getSQLiteStorePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := openSQLiteStore(dbPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.close()
+	store, err := accountStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.(*sqliteStore).close() })
+	return store
+}
+
+func TestFileStore(t *testing.T) {
+	testStore(t, newFileStore)
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, newSQLiteStore)
+}
+
+// backendSuites are the tests of main_test.go and daemon_test.go. They run
+// on the files backend like every test, and TestSuitesOnSQLite runs them
+// again on sqlite.
+var backendSuites = map[string]func(*testing.T){
+	"TestAddAccount":                  TestAddAccount,
+	"TestAddAccountRefuses":           TestAddAccountRefuses,
+	"TestAddAccountRetriesPassword":   TestAddAccountRetriesPassword,
+	"TestSwitchAccount":               TestSwitchAccount,
+	"TestRemoveAccount":               TestRemoveAccount,
+	"TestRunCommandUsage":             TestRunCommandUsage,
+	"TestDaemonRemoveActiveAccount":   TestDaemonRemoveActiveAccount,
+	"TestDaemonRemoveUnlockedAccount": TestDaemonRemoveUnlockedAccount,
+	"TestDaemonRemoveStoredAccount":   TestDaemonRemoveStoredAccount,
+	"TestDaemonRemoveAccountCleanup":  TestDaemonRemoveAccountCleanup,
+	"TestDaemonRemoveAccountSelector": TestDaemonRemoveAccountSelector,
+	"TestResolveAccount":              TestResolveAccount,
+	"TestDaemonDuplicateRequestID":    TestDaemonDuplicateRequestID,
+}
+
+func TestSuitesOnSQLite(t *testing.T) {
+	testBackend = storeSQLite
+	t.Cleanup(func() { testBackend = storeFiles })
+	testHome(t)
+	if store, err := accountStore(); err != nil || store.Backend() != storeSQLite {
+		t.Fatalf("testHome set up %v, %v; want the sqlite backend", store, err)
+	}
+
+	names := make([]string, 0, len(backendSuites))
+	for name := range backendSuites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t.Run(name, backendSuites[name])
+	}
+}
+
+// A test added to main_test.go or daemon_test.go runs on both backends
+func TestBackendSuitesComplete(t *testing.T) {
+	for _, file := range []string{"main_test.go", "daemon_test.go"} {
+		parsed, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range parsed.Decls {
+			function, ok := decl.(*ast.FuncDecl)
+			if !ok || function.Recv != nil || !strings.HasPrefix(function.Name.Name, "Test") {
+				continue
+			}
+			if _, listed := backendSuites[function.Name.Name]; !listed {
+				t.Errorf("%s in %s is not in backendSuites", function.Name.Name, file)
+			}
+		}
+	}
+}
+
+// testStore runs the Store conformance suite against the backend newStore
+// returns. Each subtest gets its own storage directory, and the store is
+// the one accountStore selects there, so the account commands use it too.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("Empty", func(t *testing.T) {
+		store := newStore(t)
+		accounts, err := store.ListAccounts()
+		if err != nil || len(accounts) != 0 {
+			t.Errorf("ListAccounts = %v, %v; want none", accounts, err)
+		}
+		if npub, err := store.LoadActiveAccount(); err == nil {
+			t.Errorf("LoadActiveAccount = %s, want an error", npub)
+		}
+	})
+
+	t.Run("Records", func(t *testing.T) {
+		store := newStore(t)
+		_, npub := testKey(t)
+		before := time.Now().Add(-time.Second)
+		if err := store.WriteRecords(npub,
+			storeRecord{Name: recordKey, Data: []byte("key")},
+			storeRecord{Name: recordMetadata, Data: []byte(`{"label":"work"}`)},
+		); err != nil {
+			t.Fatalf("WriteRecords: %v", err)
+		}
+
+		accounts, err := store.ListAccounts()
+		if err != nil || len(accounts) != 1 {
+			t.Fatalf("ListAccounts = %v, %v; want the new account", accounts, err)
+		}
+		pubkey, _ := npubToPubkey(npub)
+		if accounts[0].Npub != npub || accounts[0].Pubkey != pubkey {
+			t.Errorf("ListAccounts = %+v, want %s", accounts[0], npub)
+		}
+
+		names, err := store.RecordNames(npub)
+		sort.Strings(names)
+		if want := []string{recordKey, recordMetadata}; err != nil || !reflect.DeepEqual(names, want) {
+			t.Errorf("RecordNames = %v, %v; want %v", names, err, want)
+		}
+		data, modTime, err := store.ReadRecord(npub, recordMetadata)
+		if err != nil || string(data) != `{"label":"work"}` {
+			t.Errorf("ReadRecord = %q, %v", data, err)
+		}
+		if modTime.Before(before) || modTime.After(time.Now().Add(time.Second)) {
+			t.Errorf("ReadRecord time = %v, want about now", modTime)
+		}
+
+		if err := store.WriteRecords(npub, storeRecord{Name: recordMetadata, Data: []byte("{}")}); err != nil {
+			t.Fatalf("WriteRecords over an existing record: %v", err)
+		}
+		if data, _, _ := store.ReadRecord(npub, recordMetadata); string(data) != "{}" {
+			t.Errorf("ReadRecord after overwriting = %q, want {}", data)
+		}
+		if data, _, _ := store.ReadRecord(npub, recordKey); string(data) != "key" {
+			t.Errorf("overwriting one record changed another to %q", data)
+		}
+	})
+
+	t.Run("MissingRecord", func(t *testing.T) {
+		store := newStore(t)
+		_, npub := testKey(t)
+		if err := store.WriteRecords(npub, storeRecord{Name: recordKey, Data: []byte("key")}); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := store.ReadRecord(npub, recordRelays); !os.IsNotExist(err) {
+			t.Errorf("ReadRecord of a missing record = %v, want a not-exist error", err)
+		}
+	})
+
+	t.Run("DeleteRecord", func(t *testing.T) {
+		store := newStore(t)
+		_, npub := testKey(t)
+		if err := store.WriteRecords(npub,
+			storeRecord{Name: recordKey, Data: []byte("key")},
+			storeRecord{Name: recordTrustSession, Data: []byte("session")},
+		); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.DeleteRecord(npub, recordTrustSession); err != nil {
+			t.Fatalf("DeleteRecord: %v", err)
+		}
+		if _, _, err := store.ReadRecord(npub, recordTrustSession); !os.IsNotExist(err) {
+			t.Errorf("ReadRecord after DeleteRecord = %v, want a not-exist error", err)
+		}
+		if err := store.DeleteRecord(npub, recordTrustSession); err != nil {
+			t.Errorf("DeleteRecord of a missing record = %v, want nil", err)
+		}
+		if names, _ := store.RecordNames(npub); len(names) != 1 || names[0] != recordKey {
+			t.Errorf("RecordNames = %v, want only %s", names, recordKey)
+		}
+	})
+
+	t.Run("ActiveAccount", func(t *testing.T) {
+		store := newStore(t)
+		_, npub := testKey(t)
+		if err := store.SaveActiveAccount(strings.ToUpper(npub)); err != nil {
+			t.Fatalf("SaveActiveAccount: %v", err)
+		}
+		if active, err := store.LoadActiveAccount(); err != nil || active != npub {
+			t.Errorf("LoadActiveAccount = %s, %v; want %s in lowercase", active, err, npub)
+		}
+		_, other := testKey(t)
+		if err := store.SaveActiveAccount(other); err != nil {
+			t.Fatal(err)
+		}
+		if active, _ := store.LoadActiveAccount(); active != other {
+			t.Errorf("LoadActiveAccount = %s, want %s", active, other)
+		}
+	})
+
+	t.Run("RemoveAccount", func(t *testing.T) {
+		store := newStore(t)
+		_, active := testKey(t)
+		_, other := testKey(t)
+		for _, npub := range []string{active, other} {
+			if err := store.WriteRecords(npub, storeRecord{Name: recordKey, Data: []byte(npub)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.SaveActiveAccount(active); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.RemoveAccount(other); err != nil {
+			t.Fatalf("RemoveAccount: %v", err)
+		}
+		if current, err := store.LoadActiveAccount(); err != nil || current != active {
+			t.Errorf("removing another account changed the active one to %s, %v", current, err)
+		}
+		if err := store.RemoveAccount(active); err != nil {
+			t.Fatalf("RemoveAccount of the active account: %v", err)
+		}
+		if current, err := store.LoadActiveAccount(); err == nil {
+			t.Errorf("LoadActiveAccount = %s after removing it, want an error", current)
+		}
+		if accounts, _ := store.ListAccounts(); len(accounts) != 0 {
+			t.Errorf("ListAccounts = %v, want none", accounts)
+		}
+		if _, _, err := store.ReadRecord(active, recordKey); err == nil {
+			t.Error("a removed account's record can still be read")
+		}
+		if err := store.RemoveAccount(active); err == nil {
+			t.Error("removing a missing account succeeded")
+		}
+	})
+
+	t.Run("AccountCommands", func(t *testing.T) {
+		store := newStore(t)
+		first := addTestAccount(t, "personal")
+		second := addTestAccount(t, "")
+		if err := switchAccount(&scriptedPrompter{answers: []string{testPassword}}, first); err != nil {
+			t.Fatalf("switch-account: %v", err)
+		}
+		if active, _ := store.LoadActiveAccount(); active != first {
+			t.Errorf("the store's active account is %s, want %s", active, first)
+		}
+		if label := accountLabel(first); label != "personal" {
+			t.Errorf("label = %q, want personal", label)
+		}
+		if _, err := loadAccountEncryptedKey(second); err != nil {
+			t.Errorf("loadAccountEncryptedKey: %v", err)
+		}
+		if err := removeAccount(second); err != nil {
+			t.Fatalf("removeAccount: %v", err)
+		}
+		if accounts, _ := store.ListAccounts(); len(accounts) != 1 || accounts[0].Npub != first {
+			t.Errorf("ListAccounts = %v, want only %s", accounts, first)
+		}
+	})
+}
+
+func TestSQLiteStoreFileMode(t *testing.T) {
+	store := newSQLiteStore(t)
+	_, npub := testKey(t)
+	if err := store.WriteRecords(npub, storeRecord{Name: recordKey, Data: []byte("key")}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(store.(*sqliteStore).path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("noorsigner.db has mode %o, want 600", mode)
+	}
+}
+
+func TestStorageMigrationRoundTrip(t *testing.T) {
+	testHome(t)
+	first := addTestAccount(t, "personal")
+	second := addTestAccount(t, "")
+	files, err := accountStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := readAllAccounts(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateToSQLite(files, want, second); err != nil {
+		t.Fatalf("migrate to sqlite: %v", err)
+	}
+	sqlite, err := accountStore()
+	if err != nil || sqlite.Backend() != storeSQLite {
+		t.Fatalf("after migrating, accountStore = %v, %v; want the sqlite backend", sqlite, err)
+	}
+	if err := verifyMigratedAccounts(sqlite, want); err != nil {
+		t.Error(err)
+	}
+	if remaining, _ := (&fileStore{}).ListAccounts(); len(remaining) != 0 {
+		t.Errorf("%d account directories left after migrating to sqlite", len(remaining))
+	}
+
+	if err := migrateToFiles(sqlite.(*sqliteStore), want, second); err != nil {
+		t.Fatalf("migrate to files: %v", err)
+	}
+	files, err = accountStore()
+	if err != nil || files.Backend() != storeFiles {
+		t.Fatalf("after migrating back, accountStore = %v, %v; want the files backend", files, err)
+	}
+	if err := verifyMigratedAccounts(files, want); err != nil {
+		t.Error(err)
+	}
+	if active, _ := loadActiveAccount(); active != second {
+		t.Errorf("active account = %s, want %s", active, second)
+	}
+	if err := switchAccount(&scriptedPrompter{answers: []string{testPassword}}, first); err != nil {
+		t.Errorf("switch-account after the round trip: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// StreamEvent is pushed to clients subscribed via the "subscribe" method
+type StreamEvent struct {
+	Type      string            `json:"type"`
+	Timestamp int64             `json:"timestamp"`
+	Npub      string            `json:"npub,omitempty"`
+	Pubkey    string            `json:"pubkey,omitempty"`
+	Data      map[string]string `json:"data,omitempty"`
+	// Set for "activity" events (see activity.go)
+	Activity *ActivityEntry `json:"activity,omitempty"`
+}
+
+// streamBufferSize is how many undelivered events a slow subscriber may queue
+const streamBufferSize = 64
+
+// streamFlushTimeout bounds how long shutdown waits for subscribers to
+// receive their last events
+const streamFlushTimeout = time.Second
+
+// emit broadcasts an event to all subscribers without blocking and
+// triggers the matching user hook, if configured
+func (d *Daemon) emit(event StreamEvent) {
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	event = redactStreamEvent(event)
+
+	d.runHook(event)
+	d.notifier.handleEvent(event)
+
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+
+	for ch := range d.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Subscriber is not keeping up - drop rather than stall the daemon
+		}
+	}
+}
+
+// flushSubscribers waits until every subscriber's queue is empty (or
+// timeout passes), so events emitted right before exit are delivered
+func (d *Daemon) flushSubscribers(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		queued := 0
+		d.subMu.Lock()
+		for ch := range d.subscribers {
+			queued += len(ch)
+		}
+		d.subMu.Unlock()
+
+		if queued == 0 {
+			// The last event may still be in the encoder
+			time.Sleep(10 * time.Millisecond)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// addSubscriber registers a new event channel
+func (d *Daemon) addSubscriber() chan StreamEvent {
+	ch := make(chan StreamEvent, streamBufferSize)
+
+	d.subMu.Lock()
+	if d.subscribers == nil {
+		d.subscribers = make(map[chan StreamEvent]struct{})
+	}
+	d.subscribers[ch] = struct{}{}
+	d.subMu.Unlock()
+
+	return ch
+}
+
+// removeSubscriber unregisters an event channel
+func (d *Daemon) removeSubscriber(ch chan StreamEvent) {
+	d.subMu.Lock()
+	delete(d.subscribers, ch)
+	d.subMu.Unlock()
+}
+
+// streamEvents keeps the connection open and writes events until the client disconnects
+func (d *Daemon) streamEvents(conn net.Conn, encoder *json.Encoder) {
+	ch := d.addSubscriber()
+	defer d.removeSubscriber(ch)
+
+	// Any read (data or EOF) from the client ends the subscription
+	closed := make(chan struct{})
+	go func() {
+		buf := make([]byte, 1)
+		conn.Read(buf)
+		close(closed)
+	}()
+
+	for {
+		select {
+		case event := <-ch:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "strings"
+
+// codeStrict refuses a change strict mode does not allow
+const codeStrict = "ERR_STRICT"
+
+// strictConfirmKinds must be confirmed in strict mode: profile metadata,
+// the follow list and deletions, which are hard to take back
+var strictConfirmKinds = []int{0, 3, 5}
+
+// strictEnabled reports whether strict mode is on (config.json strict or
+// daemon --strict)
+func (c *Config) strictEnabled() bool {
+	return c != nil && c.Strict
+}
+
+// applyStrictProfile turns strict mode on in the daemon's config and
+// force-sets what it guarantees there. It returns the settings it had to
+// override, for the log. runDaemon disables Trust Mode and locks memory;
+// newSigningPolicy adds strictConfirmKinds. config.json is not changed.
+func applyStrictProfile(config *Config) []string {
+	config.Strict = true
+
+	var overridden []string
+	if config.LogLevel == "debug" {
+		// Debug logging records every request
+		config.LogLevel = "info"
+		overridden = append(overridden, "log_level debug → info")
+	}
+	return overridden
+}
+
+// describeStrictKinds names strictConfirmKinds for messages
+func describeStrictKinds() string {
+	kinds := make([]string, len(strictConfirmKinds))
+	for i, kind := range strictConfirmKinds {
+		kinds[i] = describeKind(kind)
+	}
+	return strings.Join(kinds, ", ")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// Strict mode refuses what would weaken it: allowing kinds 0, 3 and 5
+// outright, signing them without confirmation, and skipping confirmation
+// with a remembered permission. Every case passes without strict mode.
+func TestStrictModeRefusals(t *testing.T) {
+	event := func(kind int) string {
+		return fmt.Sprintf(`{"kind":%d,"content":"","tags":[],"created_at":1700000000}`, kind)
+	}
+	tests := []struct {
+		name string
+		req  SignRequest
+		code string // In strict mode
+	}{
+		{"set_policy allows metadata", SignRequest{Method: "set_policy", Policy: &PolicyUpdate{Kind: intPtr(0), Action: policyAllow}}, codeStrict},
+		{"set_policy allows the follow list", SignRequest{Method: "set_policy", Policy: &PolicyUpdate{Kind: intPtr(3), Action: policyAllow}}, codeStrict},
+		{"set_policy allows deletions", SignRequest{Method: "set_policy", Policy: &PolicyUpdate{Kind: intPtr(5), Action: policyAllow}}, codeStrict},
+		{"sign_event of metadata", SignRequest{Method: "sign_event", EventJSON: event(0)}, codePending},
+		{"sign_event of a follow list", SignRequest{Method: "sign_event", EventJSON: event(3)}, codePending},
+		{"sign_event of a deletion", SignRequest{Method: "sign_event", EventJSON: event(5)}, codePending},
+		{"sign_events with a follow list", SignRequest{Method: "sign_events", Events: []string{event(1), event(3)}}, codePending},
+		{"sign_event with a remembered permission", SignRequest{Method: "sign_event", EventJSON: event(30023)}, codePending},
+	}
+	for _, strict := range []bool{false, true} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/strict=%v", tt.name, strict), func(t *testing.T) {
+				testHome(t)
+				// Everything is allowed unless strict mode says otherwise;
+				// long-form articles need confirmation, which the
+				// permission below skips
+				appConfig.KindPolicy = map[string]string{"0": policyAllow, "3": policyAllow, "5": policyAllow, "30023": policyConfirm}
+				npub := addTestAccount(t, "")
+				permitted := SignRequest{Method: "sign_event", EventJSON: event(30023)}
+				session := &connSession{exe: peerExecutable(os.Getpid())}
+				if _, err := newDaemon(appConfig, true, defaultRequestTimeout, "").rememberPermission(session, permitted, npub); err != nil {
+					t.Fatal(err)
+				}
+
+				appConfig.Strict = strict
+				d := testDaemon(t, npub)
+				serveTestDaemon(t, d)
+				var response SignResponse
+				if err := dialTestDaemon(t).request(t, tt.req, &response); err != nil {
+					t.Fatal(err)
+				}
+				want := ""
+				if strict {
+					want = tt.code
+				}
+				if response.Code != want || (want == "" && response.Error != "") {
+					t.Errorf("%s: %+v, want code %q", tt.req.Method, response, want)
+				}
+			})
+		}
+	}
+}
+
+// Strict mode remembers no new permissions, and 'policy set' refuses what
+// set_policy refuses
+func TestStrictModeCLI(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	appConfig.Strict = true
+	d := testDaemon(t, npub)
+
+	session := &connSession{exe: peerExecutable(os.Getpid())}
+	req := SignRequest{Method: "sign_event", EventJSON: `{"kind":1,"content":"","tags":[],"created_at":1700000000}`}
+	if _, err := d.rememberPermission(session, req, npub); err == nil {
+		t.Error("strict mode remembered a permission")
+	}
+	for _, kind := range strictConfirmKinds {
+		if err := policyCmd([]string{"set", fmt.Sprint(kind), policyAllow}); exitCode(err) != 1 {
+			t.Errorf("policy set %d allow: %v, want refused", kind, err)
+		}
+	}
+	if err := policyCmd([]string{"set", "3", policyDeny}); err != nil {
+		t.Errorf("policy set 3 deny: %v", err)
+	}
+
+	config := &Config{LogLevel: "debug"}
+	if overridden := applyStrictProfile(config); config.LogLevel != "info" || len(overridden) != 1 {
+		t.Errorf("strict profile over log_level debug: %q, overridden %v", config.LogLevel, overridden)
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
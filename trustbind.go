@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// trustSessionFormat is the first field of a trust_session record. Sessions
+// without it predate machine binding and are not accepted.
+const trustSessionFormat = "v2"
+
+// trustSessionTagLen is the length of the HMAC-SHA256 tag appended to a
+// session's encrypted nsec
+const trustSessionTagLen = sha256.Size
+
+var (
+	// errTrustSessionOutdated is returned for a session written before
+	// machine binding; the password is needed once to replace it
+	errTrustSessionOutdated = errors.New("trust session is from an older version")
+
+	// errTrustSessionForeign is returned for a session whose key can't be
+	// derived here: it was copied from another machine or installation
+	errTrustSessionForeign = errors.New("trust session was created on another machine or installation")
+)
+
+// trustBinding is what ties trust sessions to this machine and
+// installation: the OS's machine ID and a random secret kept in the storage
+// dir, apart from the accounts. A copied trust_session file is useless
+// without both.
+type trustBinding struct {
+	machineID     string // "" if the OS has none we can read
+	installSecret []byte
+}
+
+var (
+	bindingMu     sync.Mutex
+	cachedBinding *trustBinding
+	bindingDir    string
+)
+
+// getInstallSecretPath returns the path of the per-install secret
+func getInstallSecretPath() (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storageDir, "install_secret"), nil
+}
+
+// loadTrustBinding returns the binding of the storage dir, creating the
+// install secret on first use
+func loadTrustBinding() (*trustBinding, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return nil, err
+	}
+
+	bindingMu.Lock()
+	defer bindingMu.Unlock()
+	if cachedBinding != nil && bindingDir == storageDir {
+		return cachedBinding, nil
+	}
+
+	secret, err := loadInstallSecret()
+	if err != nil {
+		return nil, err
+	}
+	// Without a machine ID the install secret alone binds the session
+	machineID, _ := platformMachineID()
+	cachedBinding = &trustBinding{machineID: machineID, installSecret: secret}
+	bindingDir = storageDir
+	return cachedBinding, nil
+}
+
+// loadInstallSecret reads install_secret, creating it (mode 0600) if there
+// is none. O_EXCL lets the daemon and the CLI race for it safely.
+func loadInstallSecret() ([]byte, error) {
+	path, err := getInstallSecretPath()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		content, err := os.ReadFile(path)
+		if err == nil {
+			secret, err := hex.DecodeString(strings.TrimSpace(string(content)))
+			if err != nil || len(secret) != 32 {
+				return nil, fmt.Errorf("install_secret is damaged - remove it (trust sessions then need the password once)")
+			}
+			return secret, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot read install_secret: %v", err)
+		}
+
+		if err := requireEntropy(); err != nil {
+			return nil, err
+		}
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("cannot generate install secret: %v", err)
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue // Someone else was first - use theirs
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot create install_secret: %v", err)
+		}
+		_, err = file.WriteString(hex.EncodeToString(secret) + "\n")
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+			return nil, fmt.Errorf("cannot write install_secret: %v", err)
+		}
+		return secret, nil
+	}
+}
+
+// trustSessionKeys derives a session's keystream (n bytes) and MAC key from
+// its token, the machine ID and the install secret (HKDF-SHA256)
+func trustSessionKeys(token []byte, n int) (stream, macKey []byte, err error) {
+	binding, err := loadTrustBinding()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := "noorsigner trust session " + trustSessionFormat + "\x00" + binding.machineID
+	reader := hkdf.New(sha256.New, token, binding.installSecret, []byte(info))
+	macKey = make([]byte, sha256.Size)
+	stream = make([]byte, n)
+	if _, err := io.ReadFull(reader, macKey); err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(reader, stream); err != nil {
+		return nil, nil, err
+	}
+	return stream, macKey, nil
+}
+
+// sealTrustSessionNsec encrypts nsec for a session with token: the XOR
+// ciphertext followed by its HMAC tag
+func sealTrustSessionNsec(token []byte, nsec string) ([]byte, error) {
+	stream, macKey, err := trustSessionKeys(token, len(nsec))
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, len(nsec), len(nsec)+trustSessionTagLen)
+	for i := 0; i < len(nsec); i++ {
+		sealed[i] = nsec[i] ^ stream[i]
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(sealed)
+	return mac.Sum(sealed), nil
+}
+
+// openTrustSessionNsec checks a session's tag and decrypts its nsec. A tag
+// that doesn't match means the keys derived here are not the ones the
+// session was made with.
+func openTrustSessionNsec(token, sealed []byte) (string, error) {
+	ciphertext, stream, err := verifyTrustSessionTag(token, sealed)
+	if err != nil {
+		return "", err
+	}
+
+	decrypted := make([]byte, len(ciphertext))
+	for i := range ciphertext {
+		decrypted[i] = ciphertext[i] ^ stream[i]
+	}
+	return string(decrypted), nil
+}
+
+// verifyTrustSessionTag checks sealed's tag and returns its ciphertext and
+// keystream
+func verifyTrustSessionTag(token, sealed []byte) (ciphertext, stream []byte, err error) {
+	if len(sealed) < trustSessionTagLen {
+		return nil, nil, fmt.Errorf("encrypted nsec is too short")
+	}
+	ciphertext, tag := sealed[:len(sealed)-trustSessionTagLen], sealed[len(sealed)-trustSessionTagLen:]
+	stream, macKey, err := trustSessionKeys(token, len(ciphertext))
+	if err != nil {
+		return nil, nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(ciphertext)
+	if !hmac.Equal(mac.Sum(nil), tag) {
+		return nil, nil, errTrustSessionForeign
+	}
+	return ciphertext, stream, nil
+}
+
+// checkTrustSessionBinding reports errTrustSessionForeign if session can't
+// be decrypted here, without decrypting it
+func checkTrustSessionBinding(session *TrustSession) error {
+	sessionToken, err := session.token()
+	if err != nil {
+		return err
+	}
+	token, err := hex.DecodeString(sessionToken)
+	if err != nil {
+		return fmt.Errorf("invalid session token: %v", err)
+	}
+	_, _, err = verifyTrustSessionTag(token, session.EncryptedNsec)
+	return err
+}
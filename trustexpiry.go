@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const codeTrustExpired = "ERR_TRUST_EXPIRED"
+
+// errTrustExpired is returned by key-using methods after the trust session
+// ran out and took the key with it, until the daemon is unlocked again
+var errTrustExpired = errors.New("trust session expired - the daemon is locked until the password is entered again")
+
+// trustExpiry locks the daemon when the unlocked account's trust session
+// reaches its expiry. A timer fires at the expiry, and each key-using
+// request checks it too, so a machine that slept through it locks before
+// serving. Like idleTracker, the clock and timer are injected.
+type trustExpiry struct {
+	now       func() time.Time
+	afterFunc func(time.Duration, func())
+	expire    func(npub string, expiresAt time.Time)
+
+	mu        sync.Mutex
+	npub      string    // Account being watched, "" = none
+	expiresAt time.Time // Wall clock
+	watch     uint64    // Bumped by start and stop, so older timers do nothing
+}
+
+// newTrustExpiry builds the tracker
+func newTrustExpiry(now func() time.Time, afterFunc func(time.Duration, func()),
+	expire func(string, time.Time)) *trustExpiry {
+	return &trustExpiry{now: now, afterFunc: afterFunc, expire: expire}
+}
+
+// start watches npub's trust session, which expires at expiresAt
+func (t *trustExpiry) start(npub string, expiresAt time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.watch++
+	t.npub = npub
+	t.expiresAt = expiresAt.Round(0)
+	t.scheduleLocked(t.watch)
+	t.mu.Unlock()
+}
+
+// stop ends the watch (lock, account without trust session)
+func (t *trustExpiry) stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.watch++
+	t.npub = ""
+	t.mu.Unlock()
+}
+
+// extend moves the expiry of the watched session later (trust_sliding).
+// The pending timer finds the new expiry and waits for it.
+func (t *trustExpiry) extend(npub string, expiresAt time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.npub == npub && expiresAt.After(t.expiresAt) {
+		t.expiresAt = expiresAt.Round(0)
+	}
+}
+
+// expireIfDue locks before a key-using request if the expiry has passed
+// without the timer noticing. It reports whether it locked.
+func (t *trustExpiry) expireIfDue() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	npub, expiresAt := t.npub, t.expiresAt
+	if npub == "" || t.now().Round(0).Before(expiresAt) {
+		t.mu.Unlock()
+		return false
+	}
+	t.watch++
+	t.npub = ""
+	t.mu.Unlock()
+	t.expire(npub, expiresAt)
+	return true
+}
+
+// scheduleLocked sets a timer for the current expiry. Caller holds t.mu.
+func (t *trustExpiry) scheduleLocked(watch uint64) {
+	delay := t.expiresAt.Sub(t.now().Round(0))
+	if delay < 0 {
+		delay = 0
+	}
+	t.afterFunc(delay, func() { t.check(watch) })
+}
+
+// check runs when a timer fires: it locks if the expiry has passed, else
+// waits for the expiry the session has slid to
+func (t *trustExpiry) check(watch uint64) {
+	t.mu.Lock()
+	if watch != t.watch || t.npub == "" {
+		t.mu.Unlock()
+		return
+	}
+	if t.now().Round(0).Before(t.expiresAt) {
+		t.scheduleLocked(watch)
+		t.mu.Unlock()
+		return
+	}
+	npub, expiresAt := t.npub, t.expiresAt
+	t.watch++
+	t.npub = ""
+	t.mu.Unlock()
+	t.expire(npub, expiresAt)
+}
+
+// expireTrust locks the daemon when npub's trust session expired: the key
+// is zeroed, the session file removed, and key-using methods answer
+// ERR_TRUST_EXPIRED until the daemon is unlocked again
+func (d *Daemon) expireTrust(npub string, expiresAt time.Time) {
+	if !d.npubMatches(npub) {
+		return
+	}
+	d.mu.RLock()
+	pubkey := d.pubkey
+	d.mu.RUnlock()
+	d.emit(StreamEvent{Type: "trust_expired", Npub: npub, Pubkey: pubkey})
+	d.lockDaemon(LockEvent{Reason: lockReasonTrustExpired, Trigger: "at " + expiresAt.Format("2006-01-02 15:04")})
+}
+
+// lockedError is the error of a key-using method while no key is loaded:
+// errTrustExpired if the trust session's expiry locked the daemon, else
+// errDaemonLocked
+func (d *Daemon) lockedError() error {
+	if lock, ok := d.locks.last(); ok && lock.Reason == lockReasonTrustExpired {
+		return errTrustExpired
+	}
+	return errDaemonLocked
+}
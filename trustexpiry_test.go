@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrustExpiry(t *testing.T) {
+	const npub = "npub1expiry"
+	tests := []struct {
+		name   string
+		run    func(clock *fakeClock, expiry *trustExpiry)
+		locked bool
+	}{
+		{"before the expiry", func(clock *fakeClock, expiry *trustExpiry) {
+			clock.advance(time.Hour - time.Second)
+		}, false},
+		{"at the expiry", func(clock *fakeClock, expiry *trustExpiry) {
+			clock.advance(time.Hour)
+		}, true},
+		{"slid later", func(clock *fakeClock, expiry *trustExpiry) {
+			expiry.extend(npub, clock.Now().Add(2*time.Hour))
+			clock.advance(time.Hour)
+		}, false},
+		{"slid later, then reached", func(clock *fakeClock, expiry *trustExpiry) {
+			expiry.extend(npub, clock.Now().Add(2*time.Hour))
+			clock.advance(time.Hour)
+			clock.advance(time.Hour)
+		}, true},
+		{"slid earlier", func(clock *fakeClock, expiry *trustExpiry) {
+			expiry.extend(npub, clock.Now().Add(time.Minute))
+			clock.advance(time.Minute)
+		}, false},
+		{"other account slid", func(clock *fakeClock, expiry *trustExpiry) {
+			expiry.extend("npub1other", clock.Now().Add(2*time.Hour))
+			clock.advance(time.Hour)
+		}, true},
+		{"suspended past the expiry, request on resume", func(clock *fakeClock, expiry *trustExpiry) {
+			clock.jump(2 * time.Hour)
+			expiry.expireIfDue()
+		}, true},
+		{"suspended within the expiry, request on resume", func(clock *fakeClock, expiry *trustExpiry) {
+			clock.jump(30 * time.Minute)
+			expiry.expireIfDue()
+		}, false},
+		{"stopped", func(clock *fakeClock, expiry *trustExpiry) {
+			expiry.stop()
+			clock.advance(2 * time.Hour)
+			expiry.expireIfDue()
+		}, false},
+		{"restarted", func(clock *fakeClock, expiry *trustExpiry) {
+			expiry.start(npub, clock.Now().Add(3*time.Hour))
+			clock.advance(2 * time.Hour)
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			var expired []time.Time
+			expiry := newTrustExpiry(clock.Now, clock.AfterFunc, func(expiredNpub string, expiresAt time.Time) {
+				if expiredNpub != npub {
+					t.Errorf("expired %s, want %s", expiredNpub, npub)
+				}
+				expired = append(expired, expiresAt)
+			})
+			expiry.start(npub, clock.Now().Add(time.Hour))
+
+			tt.run(clock, expiry)
+			if locked := len(expired) > 0; locked != tt.locked {
+				t.Fatalf("locked = %v, want %v", locked, tt.locked)
+			}
+			if len(expired) > 1 {
+				t.Errorf("locked %d times", len(expired))
+			}
+			// Once locked, nothing locks again
+			clock.advance(24 * time.Hour)
+			if expiry.expireIfDue() {
+				t.Error("expireIfDue locked after the watch ended")
+			}
+		})
+	}
+}
+
+// An expired trust session locks the daemon, and key-using methods answer
+// ERR_TRUST_EXPIRED until it is unlocked again
+func TestDaemonTrustExpiry(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	d := testDaemon(t, npub)
+	clock := newFakeClock()
+	d.expiry = newTrustExpiry(clock.Now, clock.AfterFunc, d.expireTrust)
+	d.expiry.start(npub, clock.Now().Add(time.Hour))
+	events := d.addSubscriber()
+	defer d.removeSubscriber(events)
+	serveTestDaemon(t, d)
+	conn := dialTestDaemon(t)
+
+	sign := func() SignResponse {
+		t.Helper()
+		var response SignResponse
+		request := SignRequest{Method: "sign_event", EventJSON: `{"kind":1,"content":"hi","tags":[],"created_at":1700000000}`}
+		if err := conn.request(t, request, &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	if response := sign(); response.Error != "" {
+		t.Fatalf("sign_event before the expiry: %+v", response)
+	}
+	clock.advance(time.Hour)
+	if d.privateKey != nil {
+		t.Fatal("still unlocked after the trust session expired")
+	}
+	if lock, ok := d.locks.last(); !ok || lock.Reason != lockReasonTrustExpired {
+		t.Errorf("last lock %+v, want %s", lock, lockReasonTrustExpired)
+	}
+	if response := sign(); response.Code != codeTrustExpired {
+		t.Errorf("sign_event after the expiry: %+v, want %s", response, codeTrustExpired)
+	}
+
+	expiredEvent := false
+	for len(events) > 0 {
+		if event := <-events; event.Type == "trust_expired" && event.Npub == npub {
+			expiredEvent = true
+		}
+	}
+	if !expiredEvent {
+		t.Error("no trust_expired event")
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trustTouchInterval is how often the last use is written to the trust
+// session. In between it is only kept in memory, so a restart may lose
+// up to this much of it - which only ever shortens the idle window.
+const trustTouchInterval = time.Minute
+
+// parseTrustIdleTimeout parses trust_idle_timeout (empty or off = 0, never)
+func parseTrustIdleTimeout(value string) (time.Duration, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "off", "never":
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid trust_idle_timeout %q: %v", value, err)
+	}
+	if timeout < time.Minute || timeout > 720*time.Hour {
+		return 0, fmt.Errorf("trust_idle_timeout must be between 1m and 720h")
+	}
+	return timeout, nil
+}
+
+// trustIdleTimeout returns trust_idle_timeout (0 = off)
+func (c *Config) trustIdleTimeout() time.Duration {
+	if c == nil {
+		return 0
+	}
+	timeout, err := parseTrustIdleTimeout(c.TrustIdleTimeout)
+	if err != nil {
+		return 0
+	}
+	return timeout
+}
+
+// lastUsed returns when the session was last used to sign or decrypt
+// (its creation, if never)
+func (s *TrustSession) lastUsed() time.Time {
+	if s.LastUsedAt.IsZero() {
+		return s.CreatedAt
+	}
+	return s.LastUsedAt
+}
+
+// idleDeadline returns when the session ends for lack of use (zero with
+// idle 0, i.e. never)
+func (s *TrustSession) idleDeadline(idle time.Duration) time.Time {
+	if idle <= 0 {
+		return time.Time{}
+	}
+	return s.lastUsed().Add(idle)
+}
+
+// deadline returns when the session ends: at ExpiresAt or after idle
+// without use, whichever comes first
+func (s *TrustSession) deadline(idle time.Duration) time.Time {
+	if idleDeadline := s.idleDeadline(idle); !idleDeadline.IsZero() && idleDeadline.Before(s.ExpiresAt) {
+		return idleDeadline
+	}
+	return s.ExpiresAt
+}
+
+// touchAccountTrustSession records the last use in npub's trust session
+func touchAccountTrustSession(npub string, at time.Time) error {
+	trustSessionMu.Lock()
+	defer trustSessionMu.Unlock()
+	session, err := loadAccountTrustSession(npub)
+	if err != nil {
+		return err
+	}
+	session.LastUsedAt = at
+	return saveAccountTrustSession(npub, session)
+}
+
+// idleTracker locks the daemon once the unlocked account's trust session
+// went unused for trust_idle_timeout. Only key-using requests count as
+// use. Times are compared on the wall clock, so a machine that slept
+// through the deadline locks on its first check after waking: the timer
+// (which may not count the suspend) or the next request, whichever comes
+// first. The clock, timer, persistence and lock are injected.
+type idleTracker struct {
+	timeout   time.Duration
+	now       func() time.Time
+	afterFunc func(time.Duration, func())
+	persist   func(npub string, at time.Time) error
+	expire    func(npub string)
+
+	mu       sync.Mutex
+	npub     string    // Account being watched, "" = none
+	lastUsed time.Time // Wall clock
+	saved    time.Time // lastUsed as last written to the trust session
+	watch    uint64    // Bumped by start and stop, so older timers do nothing
+}
+
+// newIdleTracker builds the tracker; with timeout 0 it never locks
+func newIdleTracker(timeout time.Duration, now func() time.Time, afterFunc func(time.Duration, func()),
+	persist func(string, time.Time) error, expire func(string)) *idleTracker {
+	return &idleTracker{
+		timeout:   timeout,
+		now:       now,
+		afterFunc: afterFunc,
+		persist:   persist,
+		expire:    expire,
+	}
+}
+
+// wallNow returns the current time without its monotonic reading
+func (t *idleTracker) wallNow() time.Time {
+	return t.now().Round(0)
+}
+
+// start watches npub, last used at lastUsed (from its trust session)
+func (t *idleTracker) start(npub string, lastUsed time.Time) {
+	if t == nil || t.timeout <= 0 {
+		return
+	}
+	t.mu.Lock()
+	t.watch++
+	t.npub = npub
+	t.lastUsed = lastUsed.Round(0)
+	t.saved = t.lastUsed
+	t.scheduleLocked(t.watch)
+	t.mu.Unlock()
+}
+
+// stop ends the watch (lock, account without trust session)
+func (t *idleTracker) stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.watch++
+	t.npub = ""
+	t.mu.Unlock()
+}
+
+// deadline returns when the watched account locks (zero if not watched)
+func (t *idleTracker) deadline(npub string) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.npub == "" || t.npub != npub {
+		return time.Time{}
+	}
+	return t.lastUsed.Add(t.timeout)
+}
+
+// touch records a key-using request. It returns false if the account had
+// already gone unused for too long; it is locked then, before the request
+// is served.
+func (t *idleTracker) touch() bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	npub := t.npub
+	if npub == "" {
+		t.mu.Unlock()
+		return true
+	}
+	now := t.wallNow()
+	if !now.Before(t.lastUsed.Add(t.timeout)) {
+		t.watch++
+		t.npub = ""
+		t.mu.Unlock()
+		t.expire(npub)
+		return false
+	}
+	t.lastUsed = now
+	save := now.Sub(t.saved) >= trustTouchInterval
+	if save {
+		t.saved = now
+	}
+	t.mu.Unlock()
+
+	if save {
+		if err := t.persist(npub, now); err != nil {
+			logError("⚠️  Cannot record the trust session's last use: %v", err)
+		}
+	}
+	return true
+}
+
+// scheduleLocked sets a timer for the current deadline. Caller holds t.mu.
+func (t *idleTracker) scheduleLocked(watch uint64) {
+	delay := t.lastUsed.Add(t.timeout).Sub(t.wallNow())
+	if delay < 0 {
+		delay = 0
+	}
+	t.afterFunc(delay, func() { t.check(watch) })
+}
+
+// check runs when a timer fires: it locks if the deadline has passed, else
+// waits for the deadline the requests since then have moved it to
+func (t *idleTracker) check(watch uint64) {
+	t.mu.Lock()
+	if watch != t.watch || t.npub == "" {
+		t.mu.Unlock()
+		return
+	}
+	if t.wallNow().Before(t.lastUsed.Add(t.timeout)) {
+		t.scheduleLocked(watch)
+		t.mu.Unlock()
+		return
+	}
+	npub := t.npub
+	t.watch++
+	t.npub = ""
+	t.mu.Unlock()
+	t.expire(npub)
+}
+
+// expireIdle locks the daemon when npub's trust session went unused for
+// trust_idle_timeout
+func (d *Daemon) expireIdle(npub string) {
+	if !d.npubMatches(npub) {
+		return
+	}
+	d.lockDaemon(LockEvent{Reason: lockReasonIdle, Trigger: fmt.Sprintf("unused for %s", d.config.trustIdleTimeout())})
+}
+
+// trustDeadlines returns when npub's trust session expires, with
+// trust_idle_timeout when the daemon locks unless it is used before, and
+// with trust_sliding the hard limit the expiry can't slide past (Unix
+// times, 0 = none)
+func (d *Daemon) trustDeadlines(npub string) (expiresAt, idleAt, hardLimitAt int64) {
+	if d.noTrust || npub == "" {
+		return 0, 0, 0
+	}
+	session, err := loadAccountTrustSession(npub)
+	if err != nil || !isTrustSessionValid(session) {
+		return 0, 0, 0
+	}
+	if deadline := d.idle.deadline(npub); !deadline.IsZero() {
+		idleAt = deadline.Unix()
+	}
+	if !session.HardExpiresAt.IsZero() {
+		hardLimitAt = session.HardExpiresAt.Unix()
+	}
+	return session.ExpiresAt.Unix(), idleAt, hardLimitAt
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock stands in for time.Now and time.AfterFunc. Timers fire only
+// when the test advances the clock.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	f  func()
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(delay time.Duration, f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(delay), f: f})
+}
+
+// advance moves the clock forward and fires the timers that come due
+func (c *fakeClock) advance(d time.Duration) {
+	c.jump(d)
+	c.fire()
+}
+
+// jump moves the clock without firing timers, like a suspend the timers
+// don't count
+func (c *fakeClock) jump(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// fire runs the timers that are due, including those they set
+func (c *fakeClock) fire() {
+	for {
+		c.mu.Lock()
+		var due *fakeTimer
+		for i, timer := range c.timers {
+			if !timer.at.After(c.now) {
+				due = &timer
+				c.timers = append(c.timers[:i], c.timers[i+1:]...)
+				break
+			}
+		}
+		c.mu.Unlock()
+		if due == nil {
+			return
+		}
+		due.f()
+	}
+}
+
+// idleTestTracker returns a tracker on clock with a 10 minute timeout and
+// the accounts it locked and the last uses it wrote
+func idleTestTracker(clock *fakeClock) (*idleTracker, *[]string, *[]time.Time) {
+	var expired []string
+	var persisted []time.Time
+	tracker := newIdleTracker(10*time.Minute, clock.Now, clock.AfterFunc,
+		func(npub string, at time.Time) error {
+			persisted = append(persisted, at)
+			return nil
+		},
+		func(npub string) { expired = append(expired, npub) })
+	return tracker, &expired, &persisted
+}
+
+func TestIdleTracker(t *testing.T) {
+	const npub = "npub1idle"
+	tests := []struct {
+		name string
+		// run drives the tracker and returns whether its last request was
+		// served
+		run    func(clock *fakeClock, tracker *idleTracker) bool
+		locked bool
+		served bool
+	}{
+		{"unused past the timeout", func(clock *fakeClock, tracker *idleTracker) bool {
+			clock.advance(10 * time.Minute)
+			return true
+		}, true, true},
+		{"unused just under the timeout", func(clock *fakeClock, tracker *idleTracker) bool {
+			clock.advance(10*time.Minute - time.Second)
+			return true
+		}, false, true},
+		{"used before the timeout", func(clock *fakeClock, tracker *idleTracker) bool {
+			clock.advance(9 * time.Minute)
+			served := tracker.touch()
+			clock.advance(9 * time.Minute)
+			return served
+		}, false, true},
+		{"unused after the last use", func(clock *fakeClock, tracker *idleTracker) bool {
+			clock.advance(9 * time.Minute)
+			served := tracker.touch()
+			clock.advance(10 * time.Minute)
+			return served
+		}, true, true},
+		{"suspended past the timeout, used on resume", func(clock *fakeClock, tracker *idleTracker) bool {
+			clock.jump(time.Hour)
+			return tracker.touch()
+		}, true, false},
+		{"suspended past the timeout, timer fires on resume", func(clock *fakeClock, tracker *idleTracker) bool {
+			clock.jump(time.Hour)
+			clock.fire()
+			return true
+		}, true, true},
+		{"suspended within the timeout", func(clock *fakeClock, tracker *idleTracker) bool {
+			clock.jump(5 * time.Minute)
+			return tracker.touch()
+		}, false, true},
+		{"stopped", func(clock *fakeClock, tracker *idleTracker) bool {
+			tracker.stop()
+			clock.advance(time.Hour)
+			return tracker.touch()
+		}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			tracker, expired, _ := idleTestTracker(clock)
+			tracker.start(npub, clock.Now())
+
+			served := tt.run(clock, tracker)
+			if locked := len(*expired) > 0; locked != tt.locked {
+				t.Fatalf("locked = %v, want %v", locked, tt.locked)
+			}
+			if tt.locked && (len(*expired) != 1 || (*expired)[0] != npub) {
+				t.Errorf("locked %v, want %s once", *expired, npub)
+			}
+			if served != tt.served {
+				t.Errorf("last request served = %v, want %v", served, tt.served)
+			}
+			if tt.locked && !tracker.deadline(npub).IsZero() {
+				t.Errorf("still watched until %v after locking", tracker.deadline(npub))
+			}
+		})
+	}
+}
+
+// Uses are written to the trust session at most every trustTouchInterval
+func TestIdleTrackerPersist(t *testing.T) {
+	clock := newFakeClock()
+	tracker, _, persisted := idleTestTracker(clock)
+	start := clock.Now()
+	tracker.start("npub1idle", start)
+
+	clock.advance(30 * time.Second)
+	tracker.touch()
+	if len(*persisted) != 0 {
+		t.Fatalf("use after 30s written: %v", *persisted)
+	}
+	clock.advance(30 * time.Second)
+	tracker.touch()
+	clock.advance(10 * time.Second)
+	tracker.touch()
+	if want := start.Add(time.Minute); len(*persisted) != 1 || !(*persisted)[0].Equal(want) {
+		t.Errorf("written uses %v, want only %v", *persisted, want)
+	}
+}
+
+// A trust session ends at its expiry or after trust_idle_timeout without
+// use, whichever comes first
+func TestTrustSessionDeadline(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	session := &TrustSession{CreatedAt: created, ExpiresAt: created.Add(24 * time.Hour)}
+	used := &TrustSession{CreatedAt: created, ExpiresAt: created.Add(24 * time.Hour), LastUsedAt: created.Add(23 * time.Hour)}
+	tests := []struct {
+		name    string
+		session *TrustSession
+		idle    time.Duration
+		want    time.Time
+	}{
+		{"no idle timeout", session, 0, created.Add(24 * time.Hour)},
+		{"idle timeout first", session, time.Hour, created.Add(time.Hour)},
+		{"expiry first", used, 2 * time.Hour, created.Add(24 * time.Hour)},
+		{"idle from the last use", used, 30 * time.Minute, created.Add(23*time.Hour + 30*time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.session.deadline(tt.idle); !got.Equal(tt.want) {
+				t.Errorf("deadline(%v) = %v, want %v", tt.idle, got, tt.want)
+			}
+		})
+	}
+}
+
+// The idle timeout locks the daemon with the idle reason
+func TestDaemonIdleLock(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	d := testDaemon(t, npub)
+	clock := newFakeClock()
+	d.idle = newIdleTracker(10*time.Minute, clock.Now, clock.AfterFunc,
+		func(string, time.Time) error { return nil }, d.expireIdle)
+	d.idle.start(npub, clock.Now())
+
+	clock.advance(5 * time.Minute)
+	if d.privateKey == nil {
+		t.Fatal("locked before the idle timeout")
+	}
+	clock.advance(5 * time.Minute)
+	if d.privateKey != nil {
+		t.Fatal("still unlocked after the idle timeout")
+	}
+	if lock, ok := d.locks.last(); !ok || lock.Reason != lockReasonIdle || lock.Npub != npub {
+		t.Errorf("last lock %+v, want %s of %s", lock, lockReasonIdle, npub)
+	}
+}
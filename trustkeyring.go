@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// Where a trust session's token is kept (trust_token_store)
+const (
+	trustTokenKeyring = "keyring"
+	trustTokenFile    = "file"
+)
+
+const (
+	// keyringService is the service name of the tokens in the OS keyring
+	keyringService = "noorsigner"
+
+	// trustTokenMarker stands in the token field of a trust_session record
+	// whose token is in the OS keyring
+	trustTokenMarker = "keyring"
+)
+
+// errKeyringUnavailable is returned when the OS keyring can't store a token
+// (no Secret Service on a headless Linux, a locked keychain)
+var errKeyringUnavailable = errors.New("no OS keyring available")
+
+// validateTrustTokenStore checks trust_token_store
+func validateTrustTokenStore(value string) error {
+	if value != "" && value != trustTokenKeyring && value != trustTokenFile {
+		return fmt.Errorf("invalid trust_token_store %q (use keyring or file)", value)
+	}
+	return nil
+}
+
+// trustTokenInFile reports whether new trust sessions keep their token in
+// the session file (trust_token_store file) instead of the OS keyring
+func (c *Config) trustTokenInFile() bool {
+	return c != nil && c.TrustTokenStore == trustTokenFile
+}
+
+// keyringUser names npub's token in the keyring. The storage dir is part of
+// it, so two installations (--home) don't overwrite each other's tokens.
+func keyringUser(npub string) (string, error) {
+	storageDir, err := getStorageDir()
+	if err != nil {
+		return "", err
+	}
+	return npub + "@" + storageDir, nil
+}
+
+// saveTrustToken puts npub's session token into the OS keyring, replacing
+// the token of an earlier session
+func saveTrustToken(npub, token string) error {
+	user, err := keyringUser(npub)
+	if err != nil {
+		return err
+	}
+	if err := keyring.Set(keyringService, user, token); err != nil {
+		return fmt.Errorf("%w: %v", errKeyringUnavailable, err)
+	}
+	return nil
+}
+
+// loadTrustToken fetches npub's session token from the OS keyring
+func loadTrustToken(npub string) (string, error) {
+	user, err := keyringUser(npub)
+	if err != nil {
+		return "", err
+	}
+	token, err := keyring.Get(keyringService, user)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", fmt.Errorf("trust session token is missing from the OS keyring")
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errKeyringUnavailable, err)
+	}
+	return token, nil
+}
+
+// deleteTrustToken removes npub's session token from the OS keyring (a
+// missing one is not an error)
+func deleteTrustToken(npub string) error {
+	user, err := keyringUser(npub)
+	if err != nil {
+		return err
+	}
+	if err := keyring.Delete(keyringService, user); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("cannot remove the trust session token from the OS keyring: %v", err)
+	}
+	return nil
+}
+
+// token returns the session token, fetching it from the OS keyring if the
+// session keeps it there
+func (s *TrustSession) token() (string, error) {
+	if s.SessionToken != "" || !s.TokenInKeyring {
+		return s.SessionToken, nil
+	}
+	return loadTrustToken(s.npub)
+}
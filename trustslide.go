@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultTrustMaxLifetime caps a sliding trust session unless config.json
+// sets trust_max_lifetime
+const defaultTrustMaxLifetime = 7 * 24 * time.Hour
+
+// trustSessionMu serializes the daemon's read-modify-write updates of a
+// trust session (last use, sliding expiry), so neither loses the other's
+var trustSessionMu sync.Mutex
+
+// parseTrustMaxLifetime parses trust_max_lifetime (empty = 168h)
+func parseTrustMaxLifetime(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultTrustMaxLifetime, nil
+	}
+
+	lifetime, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid trust_max_lifetime %q: %v", value, err)
+	}
+	if lifetime < time.Hour || lifetime > 90*24*time.Hour {
+		return 0, fmt.Errorf("trust_max_lifetime must be between 1h and 2160h")
+	}
+	return lifetime, nil
+}
+
+// trustSliding reports whether each use extends the trust session
+func (c *Config) trustSliding() bool {
+	return c != nil && c.TrustSliding
+}
+
+// trustMaxLifetime returns trust_max_lifetime
+func (c *Config) trustMaxLifetime() time.Duration {
+	if c == nil {
+		return defaultTrustMaxLifetime
+	}
+	lifetime, err := parseTrustMaxLifetime(c.TrustMaxLifetime)
+	if err != nil {
+		return defaultTrustMaxLifetime
+	}
+	return lifetime
+}
+
+// slide moves the session's expiry to duration from now, but never past
+// HardExpiresAt. Sessions created without trust_sliding have no hard limit
+// and don't slide. It reports whether ExpiresAt moved.
+func (s *TrustSession) slide(now time.Time, duration time.Duration) bool {
+	if s.HardExpiresAt.IsZero() {
+		return false
+	}
+	expires := now.Add(duration)
+	if expires.After(s.HardExpiresAt) {
+		expires = s.HardExpiresAt
+	}
+	if !expires.After(s.ExpiresAt) {
+		return false
+	}
+	s.ExpiresAt = expires
+	return true
+}
+
+// slideTrustSession extends the unlocked account's trust session after a
+// successful key operation (trust_sliding). Like the last use for
+// trust_idle_timeout, it is written at most once per trustTouchInterval.
+func (d *Daemon) slideTrustSession() {
+	if d.noTrust || !d.config.trustSliding() {
+		return
+	}
+	d.mu.RLock()
+	npub, unlocked := d.npub, d.privateKey != nil
+	d.mu.RUnlock()
+	if npub == "" || !unlocked {
+		return
+	}
+
+	now := time.Now()
+	last := d.trustSlidAt.Load()
+	if now.Unix()-last < int64(trustTouchInterval/time.Second) || !d.trustSlidAt.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+
+	trustSessionMu.Lock()
+	defer trustSessionMu.Unlock()
+	session, err := loadAccountTrustSession(npub)
+	if err != nil || !isTrustSessionValid(session) {
+		return
+	}
+	if !session.slide(now, trustDuration()) {
+		return
+	}
+	if err := saveAccountTrustSession(npub, session); err != nil {
+		logError("⚠️  Cannot extend the trust session of %s: %v", displayNpub(npub), err)
+		return
+	}
+	d.expiry.extend(npub, session.ExpiresAt)
+}
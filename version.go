@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// version is the release version, overridable at build time via
+// -ldflags "-X main.version=..."
+var version = "0.1.0"
+
+// canonicalBuildCommand is the documented invocation for reproducible builds
+const canonicalBuildCommand = `CGO_ENABLED=0 go build -trimpath -ldflags="-s -w -buildid=" -o noorsigner .`
+
+// ModuleInfo describes a dependency compiled into the binary
+type ModuleInfo struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+}
+
+// BuildAttestation holds everything a user needs to verify which build is running
+type BuildAttestation struct {
+	Version      string       `json:"version"`
+	GoVersion    string       `json:"go_version"`
+	Platform     string       `json:"platform"`
+	Revision     string       `json:"revision,omitempty"`
+	RevisionTime string       `json:"revision_time,omitempty"`
+	Modified     bool         `json:"modified"`
+	Canonical    bool         `json:"canonical"`
+	BinarySHA256 string       `json:"binary_sha256,omitempty"`
+	Modules      []ModuleInfo `json:"modules,omitempty"`
+}
+
+// readBuildAttestation collects build metadata from runtime/debug.ReadBuildInfo
+func readBuildAttestation() BuildAttestation {
+	info, _ := debug.ReadBuildInfo()
+	attestation := buildAttestation(info)
+	if hash, err := executableHash(); err == nil {
+		attestation.BinarySHA256 = hash
+	}
+	return attestation
+}
+
+// buildAttestation describes the build in info (nil if the binary has no
+// build information)
+func buildAttestation(info *debug.BuildInfo) BuildAttestation {
+	attestation := BuildAttestation{
+		Version:   version,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if info == nil {
+		return attestation
+	}
+	attestation.GoVersion = info.GoVersion
+
+	trimpath := false
+	strippedBuildID := false
+	cgoDisabled := false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			attestation.Revision = setting.Value
+		case "vcs.time":
+			attestation.RevisionTime = setting.Value
+		case "vcs.modified":
+			attestation.Modified = setting.Value == "true"
+		case "-trimpath":
+			trimpath = setting.Value == "true"
+		case "-ldflags":
+			strippedBuildID = strings.Contains(setting.Value, "-buildid=")
+		case "CGO_ENABLED":
+			// A cgo build links against the local C toolchain and libc
+			cgoDisabled = setting.Value == "0"
+		}
+	}
+	attestation.Canonical = trimpath && strippedBuildID && cgoDisabled
+
+	for _, dep := range info.Deps {
+		// Replaced modules report the replacement that was actually compiled
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		attestation.Modules = append(attestation.Modules, ModuleInfo{
+			Path:    dep.Path,
+			Version: dep.Version,
+			Sum:     dep.Sum,
+		})
+	}
+	return attestation
+}
+
+var (
+	executableHashOnce sync.Once
+	executableHashSum  string
+	executableHashErr  error
+)
+
+// executableHash returns the SHA-256 of the running binary. The binary is
+// read once; get_version asks for it on every call.
+func executableHash() (string, error) {
+	executableHashOnce.Do(func() {
+		executableHashSum, executableHashErr = hashExecutable()
+	})
+	return executableHashSum, executableHashErr
+}
+
+// hashExecutable returns the SHA-256 of the running binary
+func hashExecutable() (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(exePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// versionCmd prints version info; with --verify it prints the full attestation
+// and exits non-zero if the binary was not built from a clean checkout
+func versionCmd(args []string) error {
+	verify := false
+	for _, arg := range args {
+		switch arg {
+		case "--verify":
+			verify = true
+		default:
+			return commandFailed(1, "Unknown option: %s\nUsage: noorsigner version [--verify]", arg)
+		}
+	}
+
+	attestation := readBuildAttestation()
+
+	if !verify {
+		fmt.Printf("NoorSigner %s (%s, %s)\n", attestation.Version, attestation.GoVersion, attestation.Platform)
+		if attestation.Revision != "" {
+			fmt.Printf("Revision: %s\n", attestation.Revision)
+		}
+		return nil
+	}
+
+	fmt.Printf("Version:        %s\n", attestation.Version)
+	fmt.Printf("Go version:     %s\n", attestation.GoVersion)
+	fmt.Printf("Platform:       %s\n", attestation.Platform)
+	fmt.Printf("VCS revision:   %s\n", valueOrUnknown(attestation.Revision))
+	fmt.Printf("VCS time:       %s\n", valueOrUnknown(attestation.RevisionTime))
+	fmt.Printf("Dirty tree:     %v\n", attestation.Modified)
+	fmt.Printf("Canonical:      %v\n", attestation.Canonical)
+	fmt.Printf("Binary SHA-256: %s\n", valueOrUnknown(attestation.BinarySHA256))
+	fmt.Println()
+	fmt.Println("Module checksums:")
+	for _, mod := range attestation.Modules {
+		fmt.Printf("  %s %s %s\n", mod.Path, mod.Version, mod.Sum)
+	}
+	fmt.Println()
+	fmt.Println("Reproduce this build from a clean checkout of the revision above with:")
+	fmt.Printf("  %s\n", canonicalBuildCommand)
+	fmt.Println("and compare the SHA-256 of the resulting binary.")
+
+	if attestation.Revision == "" {
+		fmt.Println()
+		return commandFailed(1, "❌ No VCS information embedded - this build cannot be attested")
+	}
+	if attestation.Modified {
+		fmt.Println()
+		return commandFailed(1, "❌ Binary was built from a dirty tree")
+	}
+	if !attestation.Canonical {
+		fmt.Println()
+		fmt.Println("⚠️  Binary was not built with the canonical flags - hash will not be reproducible")
+	}
+	return nil
+}
+
+func valueOrUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
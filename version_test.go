@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"runtime/debug"
+	"testing"
+)
+
+func canonicalSettings(overrides map[string]string) []debug.BuildSetting {
+	settings := map[string]string{
+		"-trimpath":    "true",
+		"-ldflags":     "-s -w -buildid=",
+		"CGO_ENABLED":  "0",
+		"vcs.revision": "8cbae1d11233482da1b9f2c9bd166f793b03132b",
+		"vcs.time":     "2025-12-11T16:44:02Z",
+		"vcs.modified": "false",
+	}
+	for key, value := range overrides {
+		if value == "" {
+			delete(settings, key)
+		} else {
+			settings[key] = value
+		}
+	}
+	var list []debug.BuildSetting
+	for key, value := range settings {
+		list = append(list, debug.BuildSetting{Key: key, Value: value})
+	}
+	return list
+}
+
+func TestBuildAttestationCanonical(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		want      bool
+	}{
+		{"canonical flags", nil, true},
+		{"cgo enabled", map[string]string{"CGO_ENABLED": "1"}, false},
+		{"cgo unknown", map[string]string{"CGO_ENABLED": ""}, false},
+		{"no trimpath", map[string]string{"-trimpath": ""}, false},
+		{"build id kept", map[string]string{"-ldflags": "-s -w"}, false},
+		{"no ldflags", map[string]string{"-ldflags": ""}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &debug.BuildInfo{GoVersion: "go1.24.1", Settings: canonicalSettings(tt.overrides)}
+			if got := buildAttestation(info).Canonical; got != tt.want {
+				t.Errorf("Canonical = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildAttestation(t *testing.T) {
+	info := &debug.BuildInfo{
+		GoVersion: "go1.24.1",
+		Settings:  canonicalSettings(map[string]string{"vcs.modified": "true"}),
+		Deps: []*debug.Module{
+			{Path: "github.com/btcsuite/btcd/btcec/v2", Version: "v2.3.4", Sum: "h1:btcec"},
+			{Path: "golang.org/x/crypto", Version: "v0.36.0", Sum: "h1:old",
+				Replace: &debug.Module{Path: "example.com/crypto", Version: "v0.0.1", Sum: "h1:fork"}},
+		},
+	}
+	attestation := buildAttestation(info)
+
+	if attestation.GoVersion != "go1.24.1" {
+		t.Errorf("GoVersion = %q", attestation.GoVersion)
+	}
+	if attestation.Revision != "8cbae1d11233482da1b9f2c9bd166f793b03132b" || attestation.RevisionTime != "2025-12-11T16:44:02Z" {
+		t.Errorf("revision = %q at %q", attestation.Revision, attestation.RevisionTime)
+	}
+	if !attestation.Modified {
+		t.Error("Modified = false for vcs.modified=true")
+	}
+	want := []ModuleInfo{
+		{Path: "github.com/btcsuite/btcd/btcec/v2", Version: "v2.3.4", Sum: "h1:btcec"},
+		{Path: "example.com/crypto", Version: "v0.0.1", Sum: "h1:fork"},
+	}
+	if len(attestation.Modules) != len(want) {
+		t.Fatalf("Modules = %v, want %v", attestation.Modules, want)
+	}
+	for i := range want {
+		if attestation.Modules[i] != want[i] {
+			t.Errorf("Modules[%d] = %v, want %v", i, attestation.Modules[i], want[i])
+		}
+	}
+}
+
+func TestBuildAttestationWithoutBuildInfo(t *testing.T) {
+	attestation := buildAttestation(nil)
+	if attestation.Version != version || attestation.GoVersion == "" || attestation.Platform == "" {
+		t.Errorf("attestation = %+v, want version, Go version and platform", attestation)
+	}
+	if attestation.Canonical || attestation.Revision != "" {
+		t.Errorf("attestation = %+v, want no build claims without build info", attestation)
+	}
+}
+
+func TestExecutableHash(t *testing.T) {
+	exePath, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	data, err := os.ReadFile(exePath)
+	if err != nil {
+		t.Skip(err)
+	}
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:])
+
+	got, err := executableHash()
+	if err != nil || got != want {
+		t.Fatalf("executableHash() = %q, %v, want %q", got, err, want)
+	}
+
+	// Later calls answer from the first hash without reading the binary
+	executableHashSum = "cached"
+	defer func() { executableHashSum = want }()
+	if got, _ := executableHash(); got != "cached" {
+		t.Errorf("executableHash() = %q, want the cached hash", got)
+	}
+}
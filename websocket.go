@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/coder/websocket"
+)
+
+// wsNoOrigin in ws_origins admits clients that send no Origin header:
+// programs rather than browsers, such as 'noorsigner conformance --ws'
+const wsNoOrigin = "none"
+
+// wsConn is a WebSocket connection served like a socket connection. The
+// client's text messages make up the request stream; each response is one
+// message.
+type wsConn struct {
+	net.Conn
+	// origin is the Origin header of the upgrade request ("" if none)
+	origin    string
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Close closes the WebSocket and lets serveWebSocket return
+func (c *wsConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { close(c.closed) })
+	return err
+}
+
+// parseWSOrigins splits and checks ws_origins
+func parseWSOrigins(value string) ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if strings.EqualFold(origin, wsNoOrigin) {
+			origins = append(origins, wsNoOrigin)
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || strings.Trim(parsed.Path, "/") != "" {
+			return nil, fmt.Errorf("invalid origin %q: expected scheme://host[:port], e.g. http://localhost:3000, or %s", origin, wsNoOrigin)
+		}
+		origins = append(origins, strings.ToLower(parsed.Scheme+"://"+parsed.Host))
+	}
+	return origins, nil
+}
+
+// wsOriginAllowed reports whether a browser page from origin may connect.
+// A client without an Origin ("") needs wsNoOrigin in ws_origins.
+func (c *Config) wsOriginAllowed(origin string) bool {
+	if c == nil {
+		return false
+	}
+	if origin == "" {
+		origin = wsNoOrigin
+	} else if strings.EqualFold(origin, wsNoOrigin) {
+		return false // Not an origin a browser sends
+	}
+	origins, _ := parseWSOrigins(c.WSOrigins)
+	return slices.Contains(origins, strings.ToLower(origin))
+}
+
+// isWebSocketUpgrade reports whether r asks to switch to a WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// wsOriginError explains why a WebSocket from origin is refused
+func wsOriginError(origin string) string {
+	if origin == "" {
+		return fmt.Sprintf("a WebSocket without an Origin header is not allowed (add %s to ws_origins)", wsNoOrigin)
+	}
+	return fmt.Sprintf("origin %q is not allowed (ws_origins)", origin)
+}
+
+// serveWebSocket upgrades an authorized request on the HTTP listener and
+// serves it with handleConnection, within max_connections. Only origins in
+// ws_origins may connect; a client that sends no Origin is refused unless
+// ws_origins lists wsNoOrigin. admitPeer checks again when the connection is
+// served.
+func (d *Daemon) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if !d.config.wsOriginAllowed(origin) {
+		logInfo("🚫 WebSocket from origin %q refused - not in ws_origins", origin)
+		writeHTTPResponse(w, http.StatusForbidden, SignResponse{
+			Error: wsOriginError(origin),
+			Code:  codeForbidden,
+		})
+		return
+	}
+
+	// The Origin was checked above against ws_origins
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		logError("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	conn := &wsConn{
+		Conn:   websocket.NetConn(context.Background(), c, websocket.MessageText),
+		origin: origin,
+		closed: make(chan struct{}),
+	}
+	d.admitConnection(conn)
+	<-conn.closed
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/77elements/noorsigner/pkg/client"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// serveTestHTTP serves d's HTTP API (daemon --http) until the test ends and
+// returns its WebSocket URL and bearer token
+func serveTestHTTP(t *testing.T, d *Daemon) (string, string) {
+	t.Helper()
+	token, err := d.setupHTTPToken(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(d.serveHTTP))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/", token
+}
+
+// dialTestWebSocket opens a WebSocket to wsURL as a page from origin would
+// ("" sends no Origin, like a program). A refused upgrade returns its
+// HTTP status.
+func dialTestWebSocket(ctx context.Context, wsURL, token, origin string) (net.Conn, int, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	c, response, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{HTTPHeader: header})
+	if err != nil {
+		status := 0
+		if response != nil {
+			status = response.StatusCode
+		}
+		return nil, status, err
+	}
+	return websocket.NetConn(context.Background(), c, websocket.MessageText), http.StatusSwitchingProtocols, nil
+}
+
+// wsTestClient is a client of the daemon's WebSocket, one connection per
+// request like on the socket
+func wsTestClient(wsURL, token, origin string) *client.Client {
+	return client.New(func(ctx context.Context) (net.Conn, error) {
+		conn, _, err := dialTestWebSocket(ctx, wsURL, token, origin)
+		return conn, err
+	})
+}
+
+func TestWebSocketOrigins(t *testing.T) {
+	testHome(t)
+	d := testDaemon(t, addTestAccount(t, ""))
+	wsURL, token := serveTestHTTP(t, d)
+
+	tests := []struct {
+		name       string
+		wsOrigins  string
+		origin     string
+		wantStatus int
+	}{
+		{"listed origin", "http://localhost:3000", "http://localhost:3000", http.StatusSwitchingProtocols},
+		{"listed origin in other case", "http://localhost:3000", "HTTP://LOCALHOST:3000", http.StatusSwitchingProtocols},
+		{"unlisted origin", "http://localhost:3000", "http://evil.example", http.StatusForbidden},
+		{"other port", "http://localhost:3000", "http://localhost:3001", http.StatusForbidden},
+		{"no origin", "http://localhost:3000", "", http.StatusForbidden},
+		{"no origin, none allowed", "http://localhost:3000,none", "", http.StatusSwitchingProtocols},
+		{"origin named none", "none", "none", http.StatusForbidden},
+		{"opaque origin", "none", "null", http.StatusForbidden},
+		{"ws_origins unset", "", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d.config.WSOrigins = tt.wsOrigins
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			conn, status, err := dialTestWebSocket(ctx, wsURL, token, tt.origin)
+			if status != tt.wantStatus {
+				t.Fatalf("status %d (%v), want %d", status, err, tt.wantStatus)
+			}
+			if conn != nil {
+				conn.Close()
+			}
+		})
+	}
+
+	d.config.WSOrigins = "none"
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, status, _ := dialTestWebSocket(ctx, wsURL, "nsh_wrong", ""); status != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+// An upgraded connection whose origin is no longer allowed (ws_origins
+// changed) is refused by admitPeer before any request is read
+func TestWebSocketAdmitPeer(t *testing.T) {
+	d := newDaemon(&Config{WSOrigins: "http://localhost:3000"}, true, defaultRequestTimeout, "")
+	tests := []struct {
+		origin string
+		admit  bool
+	}{
+		{"http://localhost:3000", true},
+		{"http://evil.example", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		server, peer := net.Pipe()
+		go func() {
+			// Drain the refusal
+			var response SignResponse
+			json.NewDecoder(peer).Decode(&response)
+			peer.Close()
+		}()
+		if _, admitted := d.admitPeer(&wsConn{Conn: server, origin: tt.origin, closed: make(chan struct{})}); admitted != tt.admit {
+			t.Errorf("origin %q: admitted %v, want %v", tt.origin, admitted, tt.admit)
+		}
+		server.Close()
+	}
+}
+
+func TestWebSocketSignAndDecrypt(t *testing.T) {
+	testHome(t)
+	npub := addTestAccount(t, "")
+	d := testDaemon(t, npub)
+	d.config.WSOrigins = "http://localhost:3000"
+	wsURL, token := serveTestHTTP(t, d)
+	ws := wsTestClient(wsURL, token, "http://localhost:3000")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	event, err := ws.SignEvent(ctx, `{"kind":1,"created_at":1700000000,"tags":[],"content":"over a WebSocket"}`)
+	if err != nil {
+		t.Fatalf("sign_event: %v", err)
+	}
+	var signed nostr.Event
+	data, _ := json.Marshal(event)
+	if err := json.Unmarshal(data, &signed); err != nil {
+		t.Fatal(err)
+	}
+	if signed.PubKey != d.pubkey || signed.GetID() != signed.ID {
+		t.Errorf("signed event %+v has the wrong pubkey or id", signed)
+	}
+	if ok, err := signed.CheckSignature(); !ok {
+		t.Errorf("invalid signature: %v", err)
+	}
+
+	sender, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversationKey, err := nip44.GenerateConversationKey(d.pubkey, hex.EncodeToString(sender.Serialize()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := nip44.Encrypt("hello over a WebSocket", conversationKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderPubkey := hex.EncodeToString(sender.PubKey().SerializeCompressed()[1:])
+	plaintext, err := ws.Nip44Decrypt(ctx, payload, senderPubkey)
+	if err != nil {
+		t.Fatalf("nip44_decrypt: %v", err)
+	}
+	if plaintext != "hello over a WebSocket" {
+		t.Errorf("nip44_decrypt = %q", plaintext)
+	}
+}
+
+// Many requests share one WebSocket; ids correlate the responses and
+// can't be reused on it
+func TestWebSocketRequestIDs(t *testing.T) {
+	testHome(t)
+	d := testDaemon(t, addTestAccount(t, ""))
+	d.config.WSOrigins = "none"
+	wsURL, token := serveTestHTTP(t, d)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, _, err := dialTestWebSocket(ctx, wsURL, token, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ws := &testConn{Conn: conn, encoder: json.NewEncoder(conn), decoder: json.NewDecoder(conn)}
+
+	for _, id := range []string{"1", "2", "3"} {
+		var response SignResponse
+		if err := ws.request(t, SignRequest{ID: id, Method: "get_public_key"}, &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.ID != id || response.Signature != d.pubkey {
+			t.Errorf("request %s: got %+v", id, response)
+		}
+	}
+
+	var response SignResponse
+	if err := ws.request(t, SignRequest{ID: "2", Method: "get_public_key"}, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error == "" {
+		t.Errorf("a reused id was answered: %+v", response)
+	}
+}
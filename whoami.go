@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// whoami returns the active identity: the running daemon's account, or
+// without a daemon the one in active_account, with its trust session's
+// expiry. No account fails.
+func whoami() (*WhoamiOutput, error) {
+	var output WhoamiOutput
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	var status StatusResponse
+	err := daemonRequest(ctx, SignRequest{Method: "get_status"}, &status)
+	switch {
+	case err == nil:
+		output.DaemonRunning = true
+		output.Npub = status.Npub
+		output.Unlocked = status.IsUnlocked
+		output.TrustExpiresAt = status.TrustExpiresAt
+	case errors.Is(err, errDaemonUnauthorized):
+		output.DaemonRunning = true
+		output.refused = true
+	}
+
+	if output.Npub == "" {
+		npub, err := loadActiveAccount()
+		if err != nil || npub == "" || !accountExists(npub) {
+			if accounts, _ := listAccounts(); len(accounts) > 0 {
+				return nil, errors.New("no active account - choose one with 'noorsigner switch'")
+			}
+			return nil, errors.New("no account - add one with 'noorsigner add-account'")
+		}
+		output.Npub = npub
+		if session, err := loadAccountTrustSession(npub); err == nil && time.Now().Before(session.ExpiresAt) {
+			output.TrustExpiresAt = session.ExpiresAt.Unix()
+		}
+	}
+
+	pubkey, err := npubToPubkey(output.Npub)
+	if err != nil {
+		return nil, err
+	}
+	output.Pubkey = pubkey
+	output.KeyID = npubKeyID(output.Npub)
+	output.Label = accountLabel(output.Npub)
+	return &output, nil
+}
+
+// whoamiCmd prints the active identity, for people and for scripts: exit 0
+// with an account, 1 without one
+func whoamiCmd(args []string) error {
+	if len(args) > 0 {
+		return commandFailed(1, "Usage: noorsigner whoami")
+	}
+
+	identity, err := whoami()
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if jsonOutput {
+		printJSON(identity)
+		return nil
+	}
+
+	fmt.Printf("Npub:    %s\n", identity.Npub)
+	fmt.Printf("Pubkey:  %s\n", identity.Pubkey)
+	fmt.Printf("Key ID:  %s\n", identity.KeyID)
+	if identity.Label != "" {
+		fmt.Printf("Label:   %s\n", identity.Label)
+	}
+	switch {
+	case !identity.DaemonRunning:
+		fmt.Println("Daemon:  not running")
+	case identity.refused:
+		fmt.Println("Daemon:  running, but it refused the request")
+	case identity.Unlocked:
+		fmt.Println("Daemon:  running, unlocked")
+	default:
+		fmt.Println("Daemon:  running, locked")
+	}
+	if identity.TrustExpiresAt != 0 {
+		fmt.Printf("Trusted: until %s\n", time.Unix(identity.TrustExpiresAt, 0).Format("2006-01-02 15:04"))
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/77elements/noorsigner/pkg/client"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+)
+
+// zapRequestKind is the NIP-57 zap request event kind
+const zapRequestKind = 9734
+
+// codeInvalidZapRequest rejects zap_request parameters
+const codeInvalidZapRequest = "ERR_INVALID_ZAP_REQUEST"
+
+// ZapRequest holds the parameters of a NIP-57 zap request
+type ZapRequest = client.ZapRequest
+
+// zapError reports an invalid zap request parameter
+func zapError(format string, args ...interface{}) error {
+	return &eventError{Code: codeInvalidZapRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// zapUnsignedEvent validates the parameters and builds the kind 9734 event
+// without pubkey, id and sig
+func zapUnsignedEvent(z *ZapRequest, createdAt int64) (string, error) {
+	recipient, err := zapRecipientPubkey(z.Recipient)
+	if err != nil {
+		return "", err
+	}
+	if z.Amount <= 0 {
+		return "", zapError("amount must be a positive number of millisats")
+	}
+	if len(z.Relays) == 0 {
+		return "", zapError("at least one relay is required for the zap receipt")
+	}
+	for _, relay := range z.Relays {
+		if err := checkZapRelay(relay); err != nil {
+			return "", err
+		}
+	}
+
+	tags := [][]string{
+		append([]string{"relays"}, z.Relays...),
+		{"amount", strconv.FormatInt(z.Amount, 10)},
+	}
+	if z.LNURL != "" {
+		if err := checkLNURL(z.LNURL); err != nil {
+			return "", err
+		}
+		tags = append(tags, []string{"lnurl", strings.ToLower(z.LNURL)})
+	}
+	tags = append(tags, []string{"p", recipient})
+	if z.EventID != "" {
+		if !isHexID(z.EventID) {
+			return "", zapError("event_id must be 64 lowercase hex characters")
+		}
+		tags = append(tags, []string{"e", z.EventID})
+	}
+
+	event, err := json.Marshal(map[string]interface{}{
+		"kind":       zapRequestKind,
+		"created_at": createdAt,
+		"tags":       tags,
+		"content":    z.Comment,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(event), nil
+}
+
+// zapRecipientPubkey returns the recipient as a hex pubkey on the curve
+func zapRecipientPubkey(recipient string) (string, error) {
+	if recipient == "" {
+		return "", zapError("recipient pubkey required")
+	}
+	pubkey, err := parsePubkey(recipient)
+	if err != nil {
+		return "", zapError("invalid recipient: %v", err)
+	}
+	return pubkey, nil
+}
+
+// checkZapRelay accepts ws:// and wss:// relay URLs
+func checkZapRelay(relay string) error {
+	parsed, err := url.Parse(relay)
+	if err != nil || (parsed.Scheme != "wss" && parsed.Scheme != "ws") || parsed.Host == "" {
+		return zapError("invalid relay %q: expected a wss:// or ws:// URL", relay)
+	}
+	return nil
+}
+
+// checkLNURL accepts a bech32 lnurl that decodes to an http(s) URL
+func checkLNURL(lnurl string) error {
+	hrp, data, err := bech32.DecodeNoLimit(lnurl)
+	if err != nil || hrp != "lnurl" {
+		return zapError("invalid lnurl: expected a bech32 string starting with lnurl1")
+	}
+	decoded, err := bech32.ConvertBits(data, 5, 8, false)
+	if err != nil {
+		return zapError("invalid lnurl: %v", err)
+	}
+	parsed, err := url.Parse(string(decoded))
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return zapError("invalid lnurl: does not encode an http(s) URL")
+	}
+	return nil
+}
+
+// isHexID reports whether id is a 32-byte lowercase hex event id
+func isHexID(id string) bool {
+	decoded, err := hex.DecodeString(id)
+	return err == nil && len(decoded) == 32 && id == strings.ToLower(id)
+}
+
+// zapCmd builds a zap request from flags, signs it with the active account
+// and prints it, ready for the LNURL callback's nostr parameter
+func zapCmd(args []string) error {
+	usage := "noorsigner zap --to <npub|hex> --amount <millisats> --relay <url> [--relay <url>...] " +
+		"[--lnurl <lnurl>] [--event <id>] [--comment <text>] " + passwordFlagsUsage
+	args, err := passwordArgs(args, usage)
+	if err != nil {
+		return err
+	}
+
+	// stdout carries only the signed event
+	redirectChatter()
+	activeNpub, activePubkey, err := loadActiveSigner()
+	if err != nil {
+		return err
+	}
+
+	var zap ZapRequest
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return commandFailed(1, "Usage: %s", usage)
+		}
+		switch args[i] {
+		case "--to":
+			zap.Recipient = args[i+1]
+		case "--amount":
+			amount, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return commandFailed(1, "❌ Invalid --amount %q: expected millisats", args[i+1])
+			}
+			zap.Amount = amount
+		case "--relay":
+			zap.Relays = append(zap.Relays, args[i+1])
+		case "--lnurl":
+			zap.LNURL = args[i+1]
+		case "--event":
+			zap.EventID = args[i+1]
+		case "--comment":
+			zap.Comment = args[i+1]
+		default:
+			return commandFailed(1, "Usage: %s", usage)
+		}
+		i++
+	}
+
+	eventJSON, err := zapUnsignedEvent(&zap, time.Now().Unix())
+	if err != nil {
+		return commandFailed(1, "❌ %v", err)
+	}
+	if _, err := signEventInput(activeNpub, activePubkey, []byte(eventJSON), "zap"); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// testLNURL encodes rawURL as a bech32 lnurl
+func testLNURL(t *testing.T, rawURL string) string {
+	t.Helper()
+	data, err := bech32.ConvertBits([]byte(rawURL), 8, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lnurl, err := bech32.Encode("lnurl", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lnurl
+}
+
+func TestZapUnsignedEvent(t *testing.T) {
+	_, recipientNpub := testKey(t)
+	recipient, _ := npubToPubkey(recipientNpub)
+	lnurl := testLNURL(t, "https://example.com/.well-known/lnurlp/alice")
+	eventID := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name    string
+		zap     ZapRequest
+		tags    [][]string
+		content string
+	}{
+		{
+			"minimal",
+			ZapRequest{Recipient: recipient, Amount: 21000, Relays: []string{"wss://relay.example"}},
+			[][]string{{"relays", "wss://relay.example"}, {"amount", "21000"}, {"p", recipient}},
+			"",
+		},
+		{
+			"npub recipient",
+			ZapRequest{Recipient: recipientNpub, Amount: 1000, Relays: []string{"wss://relay.example"}},
+			[][]string{{"relays", "wss://relay.example"}, {"amount", "1000"}, {"p", recipient}},
+			"",
+		},
+		{
+			"everything",
+			ZapRequest{Recipient: recipient, Amount: 5000, Relays: []string{"wss://a.example", "ws://b.example"}, LNURL: strings.ToUpper(lnurl), EventID: eventID, Comment: "great post"},
+			[][]string{{"relays", "wss://a.example", "ws://b.example"}, {"amount", "5000"}, {"lnurl", lnurl}, {"p", recipient}, {"e", eventID}},
+			"great post",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eventJSON, err := zapUnsignedEvent(&tt.zap, 1700000000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var event map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
+				t.Fatal(err)
+			}
+			// Unsigned: the daemon fills in pubkey, id and sig when signing
+			for _, field := range []string{"pubkey", "id", "sig"} {
+				if _, ok := event[field]; ok {
+					t.Errorf("unsigned zap request has %s", field)
+				}
+			}
+			var kind, createdAt int64
+			var tags [][]string
+			var content string
+			json.Unmarshal(event["kind"], &kind)
+			json.Unmarshal(event["created_at"], &createdAt)
+			json.Unmarshal(event["tags"], &tags)
+			json.Unmarshal(event["content"], &content)
+			if kind != zapRequestKind || createdAt != 1700000000 || content != tt.content {
+				t.Errorf("kind %d, created_at %d, content %q; want %d, 1700000000, %q", kind, createdAt, content, zapRequestKind, tt.content)
+			}
+			if !reflect.DeepEqual(tags, tt.tags) {
+				t.Errorf("tags %v, want %v", tags, tt.tags)
+			}
+		})
+	}
+}
+
+func TestZapUnsignedEventInvalid(t *testing.T) {
+	_, recipientNpub := testKey(t)
+	recipient, _ := npubToPubkey(recipientNpub)
+	relays := []string{"wss://relay.example"}
+
+	tests := []struct {
+		name string
+		zap  ZapRequest
+		want string
+	}{
+		{"no recipient", ZapRequest{Amount: 1000, Relays: relays}, "recipient pubkey required"},
+		{"invalid recipient", ZapRequest{Recipient: "npub1nope", Amount: 1000, Relays: relays}, "invalid recipient"},
+		{"no amount", ZapRequest{Recipient: recipient, Relays: relays}, "amount must be a positive"},
+		{"negative amount", ZapRequest{Recipient: recipient, Amount: -1, Relays: relays}, "amount must be a positive"},
+		{"no relays", ZapRequest{Recipient: recipient, Amount: 1000}, "at least one relay"},
+		{"http relay", ZapRequest{Recipient: recipient, Amount: 1000, Relays: []string{"https://relay.example"}}, "invalid relay"},
+		{"relay without host", ZapRequest{Recipient: recipient, Amount: 1000, Relays: []string{"wss://"}}, "invalid relay"},
+		{"lnurl not bech32", ZapRequest{Recipient: recipient, Amount: 1000, Relays: relays, LNURL: "https://example.com"}, "invalid lnurl"},
+		{"lnurl of another scheme", ZapRequest{Recipient: recipient, Amount: 1000, Relays: relays, LNURL: testLNURL(t, "ftp://example.com/pay")}, "invalid lnurl"},
+		{"short event id", ZapRequest{Recipient: recipient, Amount: 1000, Relays: relays, EventID: "abcd"}, "event_id must be"},
+		{"uppercase event id", ZapRequest{Recipient: recipient, Amount: 1000, Relays: relays, EventID: strings.Repeat("AB", 32)}, "event_id must be"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := zapUnsignedEvent(&tt.zap, 1700000000)
+			var refused *eventError
+			if !errors.As(err, &refused) || refused.Code != codeInvalidZapRequest {
+				t.Fatalf("error %v, want %s", err, codeInvalidZapRequest)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// zap_request signs as the account, and only where the kind policy lets
+// kind 9734 through
+func TestDaemonZapRequest(t *testing.T) {
+	_, recipientNpub := testKey(t)
+	zap := &ZapRequest{Recipient: recipientNpub, Amount: 21000, Relays: []string{"wss://relay.example"}, Comment: "zap"}
+
+	tests := []struct {
+		name   string
+		policy string // kind_policy for 9734, "" for none
+		req    SignRequest
+		code   string
+	}{
+		{"signed", "", SignRequest{Method: "zap_request", Zap: zap}, ""},
+		{"allowed", policyAllow, SignRequest{Method: "zap_request", Zap: zap}, ""},
+		{"denied", policyDeny, SignRequest{Method: "zap_request", Zap: zap}, codePolicy},
+		{"needs confirmation", policyConfirm, SignRequest{Method: "zap_request", Zap: zap}, codePending},
+		{"no parameters", "", SignRequest{Method: "zap_request"}, codeInvalidRequest},
+		{"invalid parameters", "", SignRequest{Method: "zap_request", Zap: &ZapRequest{Recipient: recipientNpub, Relays: zap.Relays}}, codeInvalidZapRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHome(t)
+			if tt.policy != "" {
+				appConfig.KindPolicy = map[string]string{"9734": tt.policy}
+			}
+			npub := addTestAccount(t, "")
+			d := testDaemon(t, npub)
+			serveTestDaemon(t, d)
+
+			var response SignResponse
+			if err := dialTestDaemon(t).request(t, tt.req, &response); err != nil {
+				t.Fatal(err)
+			}
+			if tt.code != "" {
+				if response.Code != tt.code || response.Event != nil {
+					t.Fatalf("zap_request: %+v, want %s", response, tt.code)
+				}
+				return
+			}
+			if response.Error != "" || response.Event == nil {
+				t.Fatalf("zap_request: %+v", response)
+			}
+			// go-nostr must compute the same id and accept the signature
+			var event nostr.Event
+			data, _ := json.Marshal(response.Event)
+			if err := json.Unmarshal(data, &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Kind != zapRequestKind || event.PubKey != d.pubkey || event.Content != "zap" || response.EventID != event.ID {
+				t.Fatalf("signed zap request %+v: want kind %d by %s", event, zapRequestKind, d.pubkey)
+			}
+			if event.GetID() != event.ID {
+				t.Errorf("id %s, go-nostr computes %s", event.ID, event.GetID())
+			}
+			if ok, err := event.CheckSignature(); !ok {
+				t.Errorf("invalid signature: %v", err)
+			}
+		})
+	}
+}